@@ -3,9 +3,15 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"github.com/spf13/cobra"
+	"github.com/tikv/migration/br/pkg/backup"
 	"github.com/tikv/migration/br/pkg/gluetikv"
 	"github.com/tikv/migration/br/pkg/summary"
 	"github.com/tikv/migration/br/pkg/task"
@@ -24,10 +30,28 @@ func runBackupRawCommand(command *cobra.Command, cmdName string) error {
 	}
 
 	ctx := GetDefaultContext()
-	if cfg.EnableOpenTracing {
+	if outputs := cfg.TraceOutputs(); outputs.Enabled() {
 		var store *appdash.MemoryStore
 		ctx, store = trace.TracerStartSpan(ctx)
-		defer trace.TracerFinishSpan(ctx, store)
+		defer trace.TracerFinishSpan(ctx, store, outputs)
+	}
+	if cfg.DryRun {
+		estimate, err := task.RunBackupDryRunRaw(ctx, gluetikv.Glue{}, "Backup dry run", &cfg)
+		if err != nil {
+			log.Error("failed to estimate the backup", zap.Error(err))
+			return errors.Trace(err)
+		}
+		printBackupEstimate(command, estimate)
+		return nil
+	}
+	if cfg.EstimateCompression {
+		samples, err := task.RunEstimateCompressionRaw(ctx, gluetikv.Glue{}, "Estimate compression", &cfg)
+		if err != nil {
+			log.Error("failed to estimate compression", zap.Error(err))
+			return errors.Trace(err)
+		}
+		printCompressionSamples(command, samples)
+		return nil
 	}
 	if err := task.RunBackupRaw(ctx, gluetikv.Glue{}, cmdName, &cfg); err != nil {
 		log.Error("failed to backup raw kv", zap.Error(err))
@@ -36,6 +60,27 @@ func runBackupRawCommand(command *cobra.Command, cmdName string) error {
 	return nil
 }
 
+func printCompressionSamples(command *cobra.Command, samples []backup.CompressionSample) {
+	command.Printf("%-12s%-16s%-16s%-10s%s\n", "COMPRESSION", "SIZE", "COMPRESSED", "RATIO", "SPEED")
+	for i := range samples {
+		s := &samples[i]
+		command.Printf("%-12s%-16s%-16s%-10s%s/s\n", strings.ToLower(s.CompressionType.String()),
+			units.HumanSize(float64(s.TotalBytes)), units.HumanSize(float64(s.Size)),
+			fmt.Sprintf("%.2f", s.Ratio()), units.HumanSize(s.Speed()))
+	}
+}
+
+func printBackupEstimate(command *cobra.Command, estimate *task.BackupEstimate) {
+	command.Printf("%-10s%-10s%-16s%s\n", "STORE", "REGIONS", "SIZE", "DURATION")
+	for _, s := range estimate.Stores {
+		command.Printf("%-10d%-10d%-16s%s\n", s.StoreID, s.Regions, units.HumanSize(float64(s.Size)),
+			s.Duration.Round(time.Second))
+	}
+	command.Printf("ranges: %d, regions: %d, files: %d, keys: %d, size: %s, duration: %s\n",
+		estimate.Ranges, estimate.Regions, estimate.Files, estimate.Keys, units.HumanSize(float64(estimate.Size)),
+		estimate.Duration.Round(time.Second))
+}
+
 // NewBackupCommand return a full backup subcommand.
 func NewBackupCommand() *cobra.Command {
 	command := &cobra.Command{
@@ -67,7 +112,10 @@ func newRawBackupCommand() *cobra.Command {
 	command := &cobra.Command{
 		Use:   "raw",
 		Short: "backup full raw kv pairs from TiKV cluster",
-		Args:  cobra.NoArgs,
+		Long: "Backup full raw kv pairs from TiKV cluster. Besides backupmeta, the topology of the cluster, " +
+			"i.e. the labels of the stores and the replication config of PD, is recorded in the side file " +
+			"backup.topology.json, which restore compares the target cluster with.",
+		Args: cobra.NoArgs,
 		RunE: func(command *cobra.Command, _ []string) error {
 			return runBackupRawCommand(command, "Raw backup")
 		},
@@ -0,0 +1,115 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/tikv/migration/br/pkg/gluetikv"
+	"github.com/tikv/migration/br/pkg/task"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/tikv/migration/br/pkg/version/build"
+	"go.uber.org/zap"
+)
+
+// NewBenchCommand returns a bench subcommand, which measures the storage and the
+// cluster separately to locate the bottleneck of backup and restore.
+func NewBenchCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "bench <subcommand>",
+		Short:        "benchmark the external storage or the TiKV cluster",
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+	}
+	command.AddCommand(
+		newBenchStorageCommand(),
+		newBenchClusterCommand(),
+	)
+	return command
+}
+
+func newBenchStorageCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "storage",
+		Short: "measure the upload and download throughput and latencies of the storage specified by --storage",
+		Args:  cobra.NoArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			cfg := task.BenchStorageConfig{Config: task.Config{LogProgress: HasLogFile()}}
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				command.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			results, err := task.RunBenchStorage(GetDefaultContext(), "Storage benchmark", &cfg)
+			if err != nil {
+				log.Error("failed to benchmark the storage", zap.Error(err))
+				return errors.Trace(err)
+			}
+			command.Printf("%-10s%-10s%-12s%-14s%-10s%-10s%-10s%s\n",
+				"OP", "OBJECTS", "SIZE", "THROUGHPUT", "P50", "P90", "P99", "MAX")
+			for i := range results {
+				r := &results[i]
+				command.Printf("%-10s%-10d%-12s%-14s%-10s%-10s%-10s%s\n", r.Op, r.Objects,
+					units.HumanSize(float64(r.Bytes)), units.HumanSize(r.Throughput())+"/s",
+					r.P50.Round(time.Microsecond), r.P90.Round(time.Microsecond),
+					r.P99.Round(time.Microsecond), r.Max.Round(time.Microsecond))
+			}
+			return nil
+		},
+	}
+	task.DefineBenchStorageFlags(command)
+	return command
+}
+
+func newBenchClusterCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "cluster",
+		Short: "measure the backup throughput of each TiKV store with the SST files discarded",
+		Args:  cobra.NoArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			cfg := task.RawKvConfig{Config: task.Config{LogProgress: HasLogFile()}}
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				command.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			results, err := task.RunBenchCluster(GetDefaultContext(), gluetikv.Glue{}, "Cluster benchmark", &cfg)
+			if err != nil {
+				log.Error("failed to benchmark the cluster", zap.Error(err))
+				return errors.Trace(err)
+			}
+			command.Printf("%-10s%-24s%-12s%-10s%-12s%-12s%s\n",
+				"STORE", "ADDRESS", "RESPONSES", "ERRORS", "SCANNED", "SST SIZE", "SPEED")
+			for i := range results {
+				r := &results[i]
+				command.Printf("%-10d%-24s%-12d%-10d%-12s%-12s%s/s\n", r.StoreID, r.Address, r.Responses, r.Errors,
+					units.HumanSize(float64(r.TotalBytes)), units.HumanSize(float64(r.Size)), units.HumanSize(r.Speed()))
+			}
+			return nil
+		},
+	}
+	task.DefineBenchClusterFlags(command)
+	return command
+}
@@ -0,0 +1,76 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/tikv/migration/br/pkg/task"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/tikv/migration/br/pkg/version/build"
+	"go.uber.org/zap"
+)
+
+// NewCleanupCommand returns a cleanup subcommand, which deletes the SST files under the backup
+// storage not referenced by any backupmeta.
+func NewCleanupCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "cleanup",
+		Short:        "delete the orphaned SST files not referenced by any backup in the storage specified by --storage",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+		RunE: func(command *cobra.Command, _ []string) error {
+			cfg := task.CleanupConfig{Config: task.Config{LogProgress: HasLogFile()}}
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				command.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			report, err := task.RunCleanup(GetDefaultContext(), "Cleanup", &cfg)
+			if err != nil {
+				log.Error("failed to cleanup the backups", zap.Error(err))
+				return errors.Trace(err)
+			}
+			command.Printf("backups: %d\n", len(report.Backups))
+			command.Printf("orphaned files: %d (%s)\n",
+				len(report.Orphaned), units.HumanSize(float64(report.OrphanedSize)))
+			for _, name := range report.Orphaned {
+				command.Printf("  %s\n", name)
+			}
+			if len(report.Kept) > 0 {
+				command.Printf("kept files of the resumable backups: %d\n", len(report.Kept))
+			}
+			if len(report.Recent) > 0 {
+				command.Printf("kept files modified within --min-age: %d\n", len(report.Recent))
+			}
+			if !cfg.DryRun {
+				command.Printf("deleted files: %d\n", len(report.Deleted))
+			}
+			return nil
+		},
+	}
+	task.DefineCleanupFlags(command)
+	return command
+}
@@ -11,13 +11,17 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"github.com/spf13/cobra"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/logutil"
 	"github.com/tikv/migration/br/pkg/redact"
 	"github.com/tikv/migration/br/pkg/summary"
 	"github.com/tikv/migration/br/pkg/task"
 	"github.com/tikv/migration/br/pkg/version/build"
+	"go.uber.org/zap"
 )
 
 var (
@@ -34,14 +38,26 @@ const (
 	FlagLogFile = "log-file"
 	// FlagLogFormat is the name of log-format flag.
 	FlagLogFormat = "log-format"
+	// FlagLogDedupInterval is the interval in which the repeats of a per-region message are counted.
+	FlagLogDedupInterval = "log-dedup-interval"
 	// FlagStatusAddr is the name of status-addr flag.
 	FlagStatusAddr = "status-addr"
+	// FlagMetricsAddr is the name of metrics-addr flag.
+	FlagMetricsAddr = "metrics-addr"
 	// FlagSlowLogFile is the name of slow-log-file flag.
 	FlagSlowLogFile = "slow-log-file"
 	// FlagRedactLog is whether to redact sensitive information in log, already deprecated by FlagRedactInfoLog
 	FlagRedactLog = "redact-log"
 	// FlagRedactInfoLog is whether to redact sensitive information in log.
 	FlagRedactInfoLog = "redact-info-log"
+	// FlagSummaryRedactLevel is how the failed units are redacted in the summary log.
+	FlagSummaryRedactLevel = "summary-redact-level"
+	// FlagSummaryMaxFailures is the max number of the failed units logged in the summary log.
+	FlagSummaryMaxFailures = "summary-max-failures"
+	// FlagSummaryFailureFile is the file receiving the full detail of the failed units.
+	FlagSummaryFailureFile = "summary-failure-file"
+	// FlagSummaryJSON is the file receiving the summary in json.
+	FlagSummaryJSON = "summary-json"
 
 	flagVersion      = "version"
 	flagVersionShort = "V"
@@ -62,13 +78,31 @@ func AddFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().String(FlagLogFile, timestampLogFileName(),
 		"Set the log file path. If not set, logs will output to temp file")
 	cmd.PersistentFlags().String(FlagLogFormat, "text",
-		"Set the log format")
+		"Set the log format. Available options: \"text\", \"json\"")
+	cmd.PersistentFlags().Duration(FlagLogDedupInterval, logutil.DefaultDedupInterval,
+		fmt.Sprintf("Set the interval in which the repeats of a per-region message, e.g. \"range backed up\", "+
+			"are logged %d times at most and then only counted. 0 means logging all of them", logutil.DefaultDedupBurst))
 	cmd.PersistentFlags().Bool(FlagRedactLog, false,
 		"Set whether to redact sensitive info in log, already deprecated by --redact-info-log")
 	cmd.PersistentFlags().Bool(FlagRedactInfoLog, false,
 		"Set whether to redact sensitive info in log")
+	cmd.PersistentFlags().String(FlagSummaryRedactLevel, "",
+		"Set how the failed units are redacted in the summary log. Available options: \"none\", \"keys\" (log the "+
+			"fingerprints of the keys), \"all\" (log the fingerprints of the units and the error codes only). "+
+			"Defaults to \"all\" if --redact-info-log is set, otherwise \"none\"")
+	cmd.PersistentFlags().Int(FlagSummaryMaxFailures, summary.DefaultMaxFailureUnits,
+		"Set the max number of the failed units logged in the summary log, the others are only counted. 0 means no limit")
+	cmd.PersistentFlags().String(FlagSummaryFailureFile, "",
+		"Set the file receiving the full detail of the failed units, which are only counted in the summary log then. "+
+			"The detail isn't redacted")
+	cmd.PersistentFlags().String(FlagSummaryJSON, "",
+		"Set the file receiving the summary in json besides the summary log, including the totals, the failed units "+
+			"and the time spent on each store. \"-\" means stdout, then the summary log isn't duplicated to stdout")
 	cmd.PersistentFlags().String(FlagStatusAddr, "",
 		"Set the HTTP listening address for the status report service. Set to empty string to disable")
+	cmd.PersistentFlags().String(FlagMetricsAddr, "",
+		"Set the HTTP listening address serving the prometheus metrics only at /metrics, e.g. the regions and "+
+			"bytes backed up or restored, the retries and the storage write latency. Set to empty string to disable")
 	task.DefineCommonFlags(cmd.PersistentFlags())
 
 	cmd.PersistentFlags().StringP(FlagSlowLogFile, "", "",
@@ -76,8 +110,6 @@ func AddFlags(cmd *cobra.Command) {
 	_ = cmd.PersistentFlags().MarkHidden(FlagSlowLogFile)
 	_ = cmd.PersistentFlags().MarkHidden(FlagRedactLog)
 	_ = cmd.PersistentFlags().MarkHidden(FlagRedactInfoLog)
-	_ = cmd.PersistentFlags().MarkHidden(FlagLogFormat)
-	_ = cmd.PersistentFlags().MarkHidden(FlagStatusAddr)
 }
 
 // Init initializes BR cli.
@@ -97,6 +129,11 @@ func Init(cmd *cobra.Command) (err error) {
 		if err != nil {
 			return
 		}
+		summaryJSON, e := cmd.Flags().GetString(FlagSummaryJSON)
+		if e != nil {
+			err = e
+			return
+		}
 		_, outputLogToTerm := os.LookupEnv(envLogToTermKey)
 		if outputLogToTerm {
 			// Log to term if env `BR_LOG_TO_TERM` is set.
@@ -104,7 +141,10 @@ func Init(cmd *cobra.Command) (err error) {
 		}
 		if len(conf.File.Filename) != 0 {
 			atomic.StoreUint64(&hasLogFile, 1)
-			summary.InitCollector(true)
+			// keep stdout for the json summary only.
+			if summaryJSON != summary.JSONStdout {
+				summary.InitCollector(true)
+			}
 			// cmd.PrintErr prints to stderr, but PrintErrf prints to stdout.
 			cmd.PrintErr(fmt.Sprintf("Detail BR log in %s \n", conf.File.Filename))
 		}
@@ -113,7 +153,18 @@ func Init(cmd *cobra.Command) (err error) {
 			err = e
 			return
 		}
-		log.ReplaceGlobals(lg, p)
+		// annotate all logs with the run ID, so that the logs of this run can be
+		// filtered out after aggregated with others.
+		runID := uuid.New().String()
+		logutil.SetRunID(runID)
+		log.ReplaceGlobals(lg.With(zap.String(logutil.FieldRunID, runID)), p)
+
+		dedupInterval, e := cmd.Flags().GetDuration(FlagLogDedupInterval)
+		if e != nil {
+			err = e
+			return
+		}
+		logutil.InitDeduper(dedupInterval, logutil.DefaultDedupBurst)
 
 		redactLog, e := cmd.Flags().GetBool(FlagRedactLog)
 		if e != nil {
@@ -126,10 +177,61 @@ func Init(cmd *cobra.Command) (err error) {
 			return
 		}
 		redact.InitRedact(redactLog || redactInfoLog)
+		if err = initSummaryFailureLog(cmd); err != nil {
+			return
+		}
+		summary.SetJSONOutput(summaryJSON)
+
+		statusAddr, e := cmd.Flags().GetString(FlagStatusAddr)
+		if e != nil {
+			err = e
+			return
+		}
+		if len(statusAddr) != 0 {
+			if err = startStatusServer(statusAddr); err != nil {
+				return
+			}
+		}
+
+		metricsAddr, e := cmd.Flags().GetString(FlagMetricsAddr)
+		if e != nil {
+			err = e
+			return
+		}
+		if len(metricsAddr) != 0 {
+			err = startMetricsServer(metricsAddr)
+		}
 	})
 	return errors.Trace(err)
 }
 
+func initSummaryFailureLog(cmd *cobra.Command) error {
+	cfg := summary.DefaultFailureLogConfig()
+	level, err := cmd.Flags().GetString(FlagSummaryRedactLevel)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch {
+	case len(level) > 0:
+		if cfg.RedactLevel, err = summary.ParseRedactLevel(level); err != nil {
+			return errors.Trace(err)
+		}
+	case redact.NeedRedact():
+		cfg.RedactLevel = summary.RedactAll
+	}
+	if cfg.MaxUnits, err = cmd.Flags().GetInt(FlagSummaryMaxFailures); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.MaxUnits < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must not be negative", FlagSummaryMaxFailures)
+	}
+	if cfg.DetailFile, err = cmd.Flags().GetString(FlagSummaryFailureFile); err != nil {
+		return errors.Trace(err)
+	}
+	summary.SetFailureLogConfig(cfg)
+	return nil
+}
+
 // HasLogFile returns whether we set a log file.
 func HasLogFile() bool {
 	return atomic.LoadUint64(&hasLogFile) != uint64(0)
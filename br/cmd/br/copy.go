@@ -0,0 +1,73 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/tikv/migration/br/pkg/gluetikv"
+	"github.com/tikv/migration/br/pkg/task"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/tikv/migration/br/pkg/version/build"
+	"go.uber.org/zap"
+)
+
+// NewCopyCommand returns a copy subcommand, which copies the data of a TiKV cluster
+// into another one through a relay served by BR, without an external storage.
+func NewCopyCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "copy",
+		Short:        "copy the data of a TiKV cluster into another one",
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+	}
+	command.AddCommand(
+		newRawCopyCommand(),
+	)
+	task.DefineBackupFlags(command.PersistentFlags())
+	return command
+}
+
+func newRawCopyCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use: "raw",
+		Short: "(experimental) back up the raw kv range of the cluster of --pd and restore it into the cluster " +
+			"of --dst-pd, the SST files pass through the relay served by BR instead of an external storage",
+		Args: cobra.NoArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			cfg := task.CopyRawConfig{RawKvConfig: task.RawKvConfig{Config: task.Config{LogProgress: HasLogFile()}}}
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				command.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			if err := task.RunCopyRaw(GetDefaultContext(), gluetikv.Glue{}, "Raw copy", &cfg); err != nil {
+				log.Error("failed to copy raw kv", zap.Error(err))
+				return errors.Trace(err)
+			}
+			return nil
+		},
+	}
+	task.DefineCopyRawFlags(command)
+	return command
+}
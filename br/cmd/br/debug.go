@@ -17,6 +17,7 @@ import (
 	"github.com/tikv/migration/br/pkg/checksum"
 	"github.com/tikv/migration/br/pkg/conn"
 	"github.com/tikv/migration/br/pkg/feature"
+	"github.com/tikv/migration/br/pkg/gluetikv"
 	"github.com/tikv/migration/br/pkg/metautil"
 	"github.com/tikv/migration/br/pkg/pdutil"
 	"github.com/tikv/migration/br/pkg/task"
@@ -49,6 +50,8 @@ func NewDebugCommand() *cobra.Command {
 	meta.AddCommand(decodeBackupMetaCommand())
 	meta.AddCommand(encodeBackupMetaCommand())
 	meta.AddCommand(setPDConfigCommand())
+	meta.AddCommand(newRawKVCommand())
+	meta.AddCommand(newHeatmapCommand())
 	meta.Hidden = true
 
 	return meta
@@ -57,7 +60,7 @@ func NewDebugCommand() *cobra.Command {
 func newCheckSumCommand() *cobra.Command {
 	command := &cobra.Command{
 		Use:   "checksum",
-		Short: "check the backup data",
+		Short: "check the backup data against the cluster it's backed up from or restored into",
 		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, _ []string) error {
 			return runRawChecksumCommand(cmd, "RawChecksum")
@@ -79,13 +82,29 @@ func newBackupMetaCommand() *cobra.Command {
 func newBackupMetaValidateCommand() *cobra.Command {
 	command := &cobra.Command{
 		Use:   "validate",
-		Short: "validate key range and rewrite rules of backupmeta",
+		Short: "validate the files of backupmeta against the backed up ranges",
+		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, _ []string) error {
-			return errors.Errorf("validate is unsupported")
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.Config
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				return errors.Trace(err)
+			}
+			if err := cfg.ResolveStorageByName(ctx); err != nil {
+				return errors.Trace(err)
+			}
+			result, err := task.ValidateBackupMeta(ctx, &cfg)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			cmd.Printf("backupmeta is valid, version: %d, shards: %d, files: %d, total kvs: %d, total bytes: %d, size: %d, "+
+				"checksum algorithm: %s\n", result.Version, result.Shards, result.Files, result.TotalKvs, result.TotalBytes,
+				result.Size, result.ChecksumAlgorithm)
+			return nil
 		},
 	}
-	command.Flags().Uint64("offset", 0, "the offset of table id alloctor")
-	command.Hidden = true
 	return command
 }
 
@@ -102,6 +121,9 @@ func decodeBackupMetaCommand() *cobra.Command {
 			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
 				return errors.Trace(err)
 			}
+			if err := cfg.ResolveStorageByName(ctx); err != nil {
+				return errors.Trace(err)
+			}
 			_, s, backupMeta, err := task.ReadBackupMeta(ctx, metautil.MetaFile, &cfg)
 			if err != nil {
 				return errors.Trace(err)
@@ -163,6 +185,9 @@ func encodeBackupMetaCommand() *cobra.Command {
 			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
 				return errors.Trace(err)
 			}
+			if err := cfg.ResolveStorageByName(ctx); err != nil {
+				return errors.Trace(err)
+			}
 			_, s, err := task.GetStorage(ctx, &cfg)
 			if err != nil {
 				return errors.Trace(err)
@@ -233,6 +258,84 @@ func setPDConfigCommand() *cobra.Command {
 	return pdConfigCmd
 }
 
+func newHeatmapCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "heatmap",
+		Short: "render the distribution of the data of the backup over the key space into an HTML or PNG report",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.DebugHeatmapConfig
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				return errors.Trace(err)
+			}
+			if err := task.RunDebugHeatmap(ctx, &cfg); err != nil {
+				return errors.Trace(err)
+			}
+			cmd.Printf("heatmap report written to %s\n", cfg.Report)
+			return nil
+		},
+	}
+	task.DefineDebugHeatmapFlags(command.Flags())
+	return command
+}
+
+func newRawKVCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "rawkv",
+		Short:        "read and write the raw keys of the cluster to verify the migration",
+		SilenceUsage: false,
+	}
+	task.DefineDebugRawKVFlags(command.PersistentFlags())
+	command.AddCommand(newRawKVSubCommand("get <key>", "print the value of the key", 1,
+		func(ctx context.Context, d *task.RawKVDebugger, cfg *task.DebugRawKVConfig, args []string) error {
+			return d.Get(ctx, args[0])
+		}))
+	command.AddCommand(newRawKVSubCommand("put <key> <value>", "write the key and the value", 2,
+		func(ctx context.Context, d *task.RawKVDebugger, cfg *task.DebugRawKVConfig, args []string) error {
+			return d.Put(ctx, args[0], args[1])
+		}))
+	command.AddCommand(newRawKVSubCommand("scan <start-key> <end-key>",
+		"print the keys and the values in [start-key, end-key), an empty end-key means the end of the key space", 2,
+		func(ctx context.Context, d *task.RawKVDebugger, cfg *task.DebugRawKVConfig, args []string) error {
+			return d.Scan(ctx, args[0], args[1], cfg.Limit)
+		}))
+	command.AddCommand(newRawKVSubCommand("delete-range <start-key> <end-key>", "delete the keys in [start-key, end-key)", 2,
+		func(ctx context.Context, d *task.RawKVDebugger, cfg *task.DebugRawKVConfig, args []string) error {
+			return d.DeleteRange(ctx, args[0], args[1])
+		}))
+	return command
+}
+
+func newRawKVSubCommand(
+	use, short string,
+	nArgs int,
+	run func(context.Context, *task.RawKVDebugger, *task.DebugRawKVConfig, []string) error,
+) *cobra.Command {
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.ExactArgs(nArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.DebugRawKVConfig
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				return errors.Trace(err)
+			}
+			debugger, err := task.NewRawKVDebugger(ctx, gluetikv.Glue{}, &cfg, cmd.OutOrStdout())
+			if err != nil {
+				return errors.Trace(err)
+			}
+			defer debugger.Close()
+			return errors.Trace(run(ctx, debugger, &cfg, args))
+		},
+	}
+}
+
 func runRawChecksumCommand(command *cobra.Command, cmdName string) error {
 	cfg := task.Config{LogProgress: HasLogFile()}
 	err := cfg.ParseFromFlags(command.Flags())
@@ -271,16 +374,25 @@ func runRawChecksumCommand(command *cobra.Command, cmdName string) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
-	_, _, backupMeta, err := task.ReadBackupMeta(ctx, metautil.MetaFile, &cfg)
+	if err = cfg.ResolveStorageByName(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	_, s, backupMeta, err := task.ReadBackupMeta(ctx, metautil.MetaFile, &cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	reader := metautil.NewMetaReader(backupMeta, s, &cfg.CipherInfo)
+	fileChecksum, keyRanges, err := task.CalcChecksumAndRangeFromBackupMeta(ctx, backupMeta, reader, storageAPIVersion)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	fileChecksum, keyRanges := task.CalcChecksumAndRangeFromBackupMeta(ctx, backupMeta, storageAPIVersion)
-	if !task.CheckBackupAPIVersion(featureGate, storageAPIVersion, backupMeta.ApiVersion) {
+	if !task.CheckChecksumAPIVersion(featureGate, storageAPIVersion, backupMeta.ApiVersion) {
 		return errors.Errorf("Unsupported api version, storage:%s, backup meta:%s",
 			storageAPIVersion.String(), backupMeta.ApiVersion.String())
 	}
 	checksumMethod := checksum.StorageChecksumCommand
+	// the checksum of the storage is calculated over the keys in the api version of the backup,
+	// e.g. the apiv2 storage restored from an apiv1 backup is checked without the apiv2 prefix.
 	if storageAPIVersion != backupMeta.ApiVersion {
 		checksumMethod = checksum.StorageScanCommand
 	}
@@ -0,0 +1,109 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/tikv/migration/br/pkg/task"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/tikv/migration/br/pkg/version/build"
+	"go.uber.org/zap"
+)
+
+// NewDescribeCommand returns a describe subcommand, which prints the summary of a backup read
+// from its backupmeta.
+func NewDescribeCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "describe",
+		Short:        "describe the backup at --storage, or of --name in the catalog of --catalog",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+		RunE: func(command *cobra.Command, _ []string) error {
+			cfg := task.DescribeConfig{Config: task.Config{LogProgress: HasLogFile()}}
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				command.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			d, err := task.DescribeBackup(GetDefaultContext(), &cfg)
+			if err != nil {
+				log.Error("failed to describe the backup", zap.Error(err))
+				return errors.Trace(err)
+			}
+			if cfg.Output == task.OutputJSON {
+				return errors.Trace(printJSON(command, d))
+			}
+			printBackupDescription(command, d)
+			return nil
+		},
+	}
+	task.DefineDescribeFlags(command)
+	return command
+}
+
+func printBackupDescription(command *cobra.Command, d *task.BackupDescription) {
+	command.Printf("storage: %s\n", d.Storage)
+	if d.BackupTime != nil {
+		command.Printf("backup-ts: %d (%s)\n", d.BackupTS, d.BackupTime.Format(time.RFC3339))
+	} else {
+		command.Printf("backup-ts: -\n")
+	}
+	if d.LastBackupTS > 0 {
+		command.Printf("last-backup-ts: %d\n", d.LastBackupTS)
+	}
+	command.Printf("cluster-id: %d\n", d.ClusterID)
+	command.Printf("cluster-version: %s\n", d.ClusterVersion)
+	command.Printf("br-version: %s\n", d.BRVersion)
+	command.Printf("api-version: %s\n", d.APIVersion)
+	command.Printf("meta-version: %d\n", d.MetaVersion)
+	command.Printf("files: %d\n", d.Files)
+	command.Printf("total-kvs: %d\n", d.TotalKvs)
+	command.Printf("total-bytes: %s\n", units.HumanSize(float64(d.TotalBytes)))
+	command.Printf("size: %s\n", units.HumanSize(float64(d.Size)))
+	if len(d.MasterKey) > 0 {
+		command.Printf("encryption: %s (master key %s)\n", d.Encryption, d.MasterKey)
+	} else {
+		command.Printf("encryption: %s\n", d.Encryption)
+	}
+	command.Printf("ranges:\n")
+	for _, rg := range d.Ranges {
+		command.Printf("  [%s, %s)\n", rg.StartKey, rg.EndKey)
+	}
+	if len(d.ParentChain) > 0 {
+		command.Printf("parent-chain:\n")
+		for _, parent := range d.ParentChain {
+			command.Printf("  %s\n", parent)
+		}
+	}
+	if len(d.UnsafeTSCaveats) > 0 {
+		command.Printf("unsafe-ts caveats:\n")
+		for _, caveat := range d.UnsafeTSCaveats {
+			command.Printf("  %s\n", caveat)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/migration/br/pkg/catalog"
+	"github.com/tikv/migration/br/pkg/task"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/tikv/migration/br/pkg/version/build"
+	"go.uber.org/zap"
+)
+
+// NewListCommand returns a list subcommand, which lists the backups recorded in the catalog
+// without walking the storage.
+func NewListCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "list",
+		Short:        "list the backups recorded in the catalog of --catalog, or at the root of --storage",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+		RunE: func(command *cobra.Command, _ []string) error {
+			cfg := task.Config{LogProgress: HasLogFile()}
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				command.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			output, err := task.ParseOutputFlag(command.Flags())
+			if err != nil {
+				command.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			entries, err := task.ListBackups(GetDefaultContext(), &cfg)
+			if err != nil {
+				log.Error("failed to list the backups", zap.Error(err))
+				return errors.Trace(err)
+			}
+			if output == task.OutputJSON {
+				return errors.Trace(printJSON(command, entries))
+			}
+			printBackupEntries(command, entries)
+			return nil
+		},
+	}
+	task.DefineOutputFlag(command)
+	return command
+}
+
+func printBackupEntries(command *cobra.Command, entries []catalog.Entry) {
+	w := tabwriter.NewWriter(command.OutOrStderr(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tBACKUP-TS\tBACKUP-TIME\tAPI-VERSION\tSIZE\tLAST-RESTORE\tSTORAGE")
+	for i := range entries {
+		e := &entries[i]
+		backupTime, lastRestore := "-", "-"
+		if e.BackupTS > 0 {
+			backupTime = oracle.GetTimeFromTS(e.BackupTS).Format(time.RFC3339)
+		}
+		if e.LastRestoredAt != nil {
+			lastRestore = fmt.Sprintf("%s (%s)", e.LastRestoredAt.Format(time.RFC3339), e.LastRestoreStatus)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", e.Name, e.GetStatus(), e.BackupTS, backupTime,
+			e.APIVersion, units.HumanSize(float64(e.Size)), lastRestore, e.Storage)
+	}
+	_ = w.Flush()
+}
+
+// printJSON prints v as indented JSON to the standard output, so that it can be piped into scripts.
+func printJSON(command *cobra.Command, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = fmt.Fprintln(command.OutOrStdout(), string(data))
+	return errors.Trace(err)
+}
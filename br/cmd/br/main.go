@@ -9,6 +9,9 @@ import (
 
 	"github.com/pingcap/log"
 	"github.com/spf13/cobra"
+	"github.com/tikv/migration/br/pkg/handover"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/task"
 	"go.uber.org/zap"
 )
 
@@ -25,17 +28,27 @@ func main() {
 		syscall.SIGQUIT)
 
 	go func() {
-		sig := <-sc
+		sig := waitExitSignal(sc)
 		fmt.Printf("\nGot signal [%v] to exit.\n", sig)
 		log.Warn("received signal to exit", zap.Stringer("signal", sig))
 		cancel()
 		fmt.Fprintln(os.Stderr, "gracefully shuting down, press ^C again to force exit")
-		<-sc
+		waitExitSignal(sc)
 		// Even user use SIGTERM to exit, there isn't any checkpoint for resuming,
 		// hence returning fail exit code.
 		os.Exit(1)
 	}()
 
+	// SIGUSR2 upgrades the long-running commands to the binary at the same path.
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	go func() {
+		for range usr2 {
+			log.Info("received signal to upgrade")
+			handover.Global().Request()
+		}
+	}()
+
 	rootCmd := &cobra.Command{
 		Use:              "tikv-br",
 		Short:            "tikv-br is a TiKV cluster backup restore tool.",
@@ -48,14 +61,38 @@ func main() {
 		NewDebugCommand(),
 		NewBackupCommand(),
 		NewRestoreCommand(),
+		NewServerCommand(),
+		NewBenchCommand(),
+		NewReconcileCommand(),
+		NewCleanupCommand(),
+		NewPurgeCommand(),
+		NewListCommand(),
+		NewDescribeCommand(),
+		NewStreamCommand(),
+		NewCopyCommand(),
+		NewShowCommand(),
+		NewServeBackupCommand(),
 	)
 	// Ouputs cmd.Print to stdout.
 	rootCmd.SetOut(os.Stdout)
 
 	rootCmd.SetArgs(os.Args[1:])
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	logutil.FlushDedup()
+	if err != nil {
 		cancel()
 		log.Error("br failed", zap.Error(err))
 		os.Exit(1) // nolint:gocritic
 	}
 }
+
+// waitExitSignal waits for a signal to exit, SIGHUP reloads the config file instead if
+// the task is given one.
+func waitExitSignal(sc <-chan os.Signal) os.Signal {
+	for {
+		sig := <-sc
+		if sig != syscall.SIGHUP || !task.HandleReloadSignal() {
+			return sig
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/tikv/migration/br/pkg/task"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/tikv/migration/br/pkg/version/build"
+	"go.uber.org/zap"
+)
+
+// NewPurgeCommand returns a purge subcommand, which deletes the backups under the storage
+// expired by the retention policy, keeping the parents of the incremental backups kept.
+func NewPurgeCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "purge",
+		Short:        "delete the backups under the storage specified by --storage expired by --keep-last and --keep-within",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+		RunE: func(command *cobra.Command, _ []string) error {
+			cfg := task.PurgeConfig{Config: task.Config{LogProgress: HasLogFile()}}
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				command.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			report, err := task.RunPurge(GetDefaultContext(), "Purge", &cfg)
+			if err != nil {
+				log.Error("failed to purge the backups", zap.Error(err))
+				return errors.Trace(err)
+			}
+			for _, b := range report.Backups {
+				if len(b.Keep) > 0 {
+					command.Printf("keep %s (%s)\n", b.Dir, b.Keep)
+				}
+			}
+			command.Printf("expired backups: %d (%s)\n",
+				len(report.Expired), units.HumanSize(float64(report.ExpiredSize)))
+			for _, dir := range report.Expired {
+				command.Printf("  %s\n", dir)
+			}
+			if !cfg.DryRun {
+				command.Printf("deleted objects: %d\n", len(report.Deleted))
+			}
+			return nil
+		},
+	}
+	task.DefinePurgeFlags(command)
+	return command
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/tikv/migration/br/pkg/task"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/tikv/migration/br/pkg/version/build"
+	"go.uber.org/zap"
+)
+
+// NewReconcileCommand returns a reconcile subcommand, which compares the objects under the
+// backup storage with the files recorded in the backupmeta.
+func NewReconcileCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "reconcile",
+		Short:        "report the objects not referenced by the backupmeta and the files missing in the storage specified by --storage",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+		RunE: func(command *cobra.Command, _ []string) error {
+			cfg := task.ReconcileConfig{Config: task.Config{LogProgress: HasLogFile()}}
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				command.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			report, err := task.RunReconcile(GetDefaultContext(), "Reconcile", &cfg)
+			if err != nil {
+				log.Error("failed to reconcile the backup", zap.Error(err))
+				return errors.Trace(err)
+			}
+			command.Printf("unreferenced objects: %d (%s)\n",
+				len(report.Unreferenced), units.HumanSize(float64(report.UnreferencedSize)))
+			for _, name := range report.Unreferenced {
+				command.Printf("  %s\n", name)
+			}
+			command.Printf("missing objects: %d\n", len(report.Missing))
+			for _, name := range report.Missing {
+				command.Printf("  %s\n", name)
+			}
+			if cfg.Cleanup {
+				command.Printf("deleted objects: %d\n", len(report.Deleted))
+			}
+			return nil
+		},
+	}
+	task.DefineReconcileFlags(command)
+	return command
+}
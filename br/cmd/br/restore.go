@@ -26,12 +26,12 @@ func runRestoreRawCommand(command *cobra.Command, cmdName string) error {
 	}
 
 	ctx := GetDefaultContext()
-	if cfg.EnableOpenTracing {
+	if outputs := cfg.TraceOutputs(); outputs.Enabled() {
 		var store *appdash.MemoryStore
 		ctx, store = trace.TracerStartSpan(ctx)
-		defer trace.TracerFinishSpan(ctx, store)
+		defer trace.TracerFinishSpan(ctx, store, outputs)
 	}
-	if err := task.RunRestoreRaw(GetDefaultContext(), gluetikv.Glue{}, cmdName, &cfg); err != nil {
+	if err := task.RunRestoreRaw(ctx, gluetikv.Glue{}, cmdName, &cfg); err != nil {
 		log.Error("failed to restore raw kv", zap.Error(err))
 		return errors.Trace(err)
 	}
@@ -67,7 +67,10 @@ func newRawRestoreCommand() *cobra.Command {
 	command := &cobra.Command{
 		Use:   "raw",
 		Short: "restore raw kv sst files to TiKV cluster",
-		Args:  cobra.NoArgs,
+		Long: "Restore raw kv sst files to TiKV cluster. It warns if the target cluster can't keep the placement " +
+			"of the backup cluster recorded in the side file backup.topology.json, not in backupmeta, " +
+			"and skips the check for the backups without the file.",
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, _ []string) error {
 			return runRestoreRawCommand(cmd, "Raw restore")
 		},
@@ -0,0 +1,90 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/tikv/migration/br/pkg/mount"
+	"github.com/tikv/migration/br/pkg/task"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/tikv/migration/br/pkg/version/build"
+	"go.uber.org/zap"
+)
+
+// NewServeBackupCommand returns a serve-backup subcommand, which serves the raw kvs of a
+// backup read-only by HTTP, so they can be inspected without restoring.
+func NewServeBackupCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use: "serve-backup",
+		Short: "serve the raw kv backup in --storage read-only by HTTP, " +
+			"the SST blocks are fetched from the storage on demand",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, _ []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			cfg := task.ServeBackupConfig{}
+			if err := cfg.ParseFromFlags(c.Flags()); err != nil {
+				c.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			return runServeBackupCommand(&cfg)
+		},
+	}
+	task.DefineServeBackupFlags(command.Flags())
+	return command
+}
+
+func runServeBackupCommand(cfg *task.ServeBackupConfig) error {
+	ctx := GetDefaultContext()
+	snapshot, err := task.OpenBackupSnapshot(ctx, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return errors.Annotatef(err, "failed to listen address %s", cfg.Addr)
+	}
+
+	srv := &http.Server{Handler: mount.NewHandler(snapshot, cfg.Format)}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Warn("failed to shutdown server", zap.Error(err))
+		}
+	}()
+	log.Info("serving the backup", zap.Stringer("address", listener.Addr()),
+		zap.String("api", mount.APIPrefix))
+	if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return errors.Trace(err)
+	}
+	<-stopped
+	log.Info("stopped serving the backup")
+	return nil
+}
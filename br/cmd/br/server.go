@@ -0,0 +1,237 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/handover"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/server"
+	"github.com/tikv/migration/br/pkg/summary"
+	"github.com/tikv/migration/br/pkg/task"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/tikv/migration/br/pkg/version/build"
+	"go.uber.org/zap"
+)
+
+const (
+	flagServerAddr        = "addr"
+	flagMaxConcurrentJobs = "max-concurrent-jobs"
+	flagMaxQueuedJobs     = "max-queued-jobs"
+	flagServerDataDir     = "data-dir"
+	flagServerTokenFile   = "token-file"
+
+	// maxServerJobConcurrency is the max number of the jobs running at the same time. The jobs
+	// share the process-wide runtime settings and summary, so they can only run one by one.
+	maxServerJobConcurrency = 1
+	serverShutdownTimeout   = 10 * time.Second
+	// handoverServerListener is the name of the listener of the job API passed on upgrade.
+	handoverServerListener = "server"
+)
+
+// NewServerCommand returns a server subcommand, which keeps running and executes
+// the backup and restore jobs submitted by the HTTP API.
+func NewServerCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "server",
+		Short:        "run as a long-lived service executing backup and restore jobs",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, _ []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return runServerCommand(c)
+		},
+	}
+	command.Flags().String(flagServerAddr, "127.0.0.1:8287", "the address to serve the job API")
+	command.Flags().Int(flagMaxConcurrentJobs, maxServerJobConcurrency,
+		"the max number of jobs running at the same time, only 1 is supported for now, "+
+			"since the jobs share the runtime settings and the summary of the process")
+	command.Flags().Int(flagMaxQueuedJobs, 64, "the max number of jobs waiting to run")
+	command.Flags().String(flagServerDataDir, "",
+		"the directory to persist the jobs, the unfinished jobs are requeued after restart, "+
+			"and the interrupted backups resume from their checkpoints by --resume. "+
+			"The jobs are only kept in memory if it's empty. It's required by the upgrade on SIGUSR2, "+
+			"which starts the binary at the same path to take over the address and the jobs without downtime")
+	command.Flags().String(flagServerTokenFile, "",
+		"the TOML file of the API tokens and their scopes (backup, restore, delete, admin), "+
+			"the API is not authorized if it's empty")
+	return command
+}
+
+func runServerCommand(c *cobra.Command) error {
+	addr, err := c.Flags().GetString(flagServerAddr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	concurrency, err := c.Flags().GetInt(flagMaxConcurrentJobs)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if concurrency < 1 || concurrency > maxServerJobConcurrency {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s must be between 1 and %d, the jobs share the runtime settings and the summary of the process",
+			flagMaxConcurrentJobs, maxServerJobConcurrency)
+	}
+	queueSize, err := c.Flags().GetInt(flagMaxQueuedJobs)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	dataDir, err := c.Flags().GetString(flagServerDataDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tokenFile, err := c.Flags().GetString(flagServerTokenFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var auth *server.Authorizer
+	if len(tokenFile) == 0 {
+		log.Warn("the API of br server is not authorized, anyone reaching the address can submit jobs")
+	} else if auth, err = server.LoadAuthorizer(tokenFile); err != nil {
+		return errors.Trace(err)
+	}
+
+	ctx := GetDefaultContext()
+	h := handover.Global()
+	listener, err := h.Listen(handoverServerListener, addr)
+	if err != nil {
+		return errors.Annotatef(err, "failed to listen address %s", addr)
+	}
+	// the jobs in the store are taken over after the old process hands them over.
+	if err = h.Ready(); err != nil {
+		return errors.Trace(err)
+	}
+	if err = h.WaitReleased(ctx); err != nil {
+		return errors.Trace(err)
+	}
+
+	var runner *server.Runner
+	if len(dataDir) == 0 {
+		runner = server.NewRunner(executeJob, concurrency, queueSize)
+	} else {
+		store, err := server.NewFileStore(dataDir)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if runner, err = server.NewRunnerWithStore(executeJob, concurrency, queueSize, store); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	runner.Start(ctx)
+
+	srv := &http.Server{Handler: server.NewHandler(runner, auth)}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		successor := waitServerUpgrade(ctx, h, len(dataDir) > 0)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Warn("failed to shutdown server", zap.Error(err))
+		}
+		if successor == nil {
+			return
+		}
+		if err := runner.Handover(); err != nil {
+			log.Warn("failed to hand over the jobs", zap.Error(err))
+		}
+		if err := successor.Release(); err != nil {
+			log.Warn("failed to release the new process", zap.Error(err))
+		}
+		log.Info("br server is handed over to the new process", zap.Int("pid", successor.Pid()))
+	}()
+	log.Info("br server started", zap.Stringer("address", listener.Addr()))
+	if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return errors.Trace(err)
+	}
+	<-stopped
+	log.Info("br server stopped")
+	return nil
+}
+
+// waitServerUpgrade waits until the ctx is done, or the server is upgraded, in which case
+// the new process ready to take over is returned.
+func waitServerUpgrade(ctx context.Context, h *handover.Handover, hasStore bool) *handover.Successor {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-h.Requested():
+		}
+		if !hasStore {
+			log.Warn("the upgrade of br server requires --" + flagServerDataDir + " to hand over the jobs")
+			continue
+		}
+		successor, err := h.Upgrade(ctx)
+		if err != nil {
+			log.Warn("failed to upgrade br server", zap.Error(err))
+			continue
+		}
+		return successor
+	}
+}
+
+// executeJob runs the job by parsing its arguments with the flags of the corresponding command.
+func executeJob(ctx context.Context, job *server.Job, g glue.Glue) error {
+	ctx = logutil.ContextWithField(ctx, zap.String("job-id", job.ID))
+	parent := &cobra.Command{Use: "job", SilenceUsage: true, SilenceErrors: true}
+	task.DefineCommonFlags(parent.PersistentFlags())
+	child := &cobra.Command{Use: "raw", Args: cobra.NoArgs}
+
+	switch job.Kind {
+	case server.JobKindBackupRaw:
+		task.DefineBackupFlags(parent.PersistentFlags())
+		task.DefineRawBackupFlags(child)
+		child.RunE = func(command *cobra.Command, _ []string) error {
+			cfg := task.RawKvConfig{}
+			if err := cfg.ParseBackupConfigFromFlags(command.Flags()); err != nil {
+				return errors.Trace(err)
+			}
+			summary.SetUnit(summary.BackupUnit)
+			return errors.Trace(task.RunBackupRaw(ctx, g, "Raw backup", &cfg))
+		}
+	case server.JobKindRestoreRaw:
+		task.DefineRestoreFlags(parent.PersistentFlags())
+		task.DefineRawRestoreFlags(child)
+		child.RunE = func(command *cobra.Command, _ []string) error {
+			cfg := task.RestoreRawConfig{}
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				return errors.Trace(err)
+			}
+			summary.SetUnit(summary.RestoreUnit)
+			return errors.Trace(task.RunRestoreRaw(ctx, g, "Raw restore", &cfg))
+		}
+	default:
+		return errors.Errorf("unsupported job kind '%s'", job.Kind)
+	}
+
+	parent.AddCommand(child)
+	parent.SetArgs(append([]string{"raw"}, job.CommandArgs()...))
+	return errors.Trace(parent.ExecuteContext(ctx))
+}
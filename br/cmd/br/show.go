@@ -0,0 +1,105 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+	"github.com/tikv/migration/br/pkg/backup"
+	"github.com/tikv/migration/br/pkg/task"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/tikv/migration/br/pkg/version/build"
+)
+
+// NewShowCommand returns a show subcommand, which prints the information of the backups.
+func NewShowCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "show",
+		Short:        "show the information of the backups",
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+	}
+	command.AddCommand(
+		newShowStatusCommand(),
+	)
+	return command
+}
+
+func newShowStatusCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use: "status",
+		Short: "print the progress of the backup in --storage, or of the backups published to the etcd of PD " +
+			"if --storage is not specified, the backup may be running on another machine or crashed",
+		Args: cobra.NoArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			cfg := task.Config{}
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				command.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			statuses, err := task.RunShowStatus(GetDefaultContext(), &cfg)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			now := time.Now()
+			for i, status := range statuses {
+				if i > 0 {
+					command.Println()
+				}
+				printStatus(command, status, now)
+			}
+			return nil
+		},
+	}
+	return command
+}
+
+func printStatus(command *cobra.Command, status *backup.Status, now time.Time) {
+	command.Printf("storage: %s\n", status.Storage)
+	if status.Stale(now) {
+		command.Printf("state: %s, but not updated for %s, the backup may have crashed\n",
+			status.State, now.Sub(status.UpdateTime).Round(time.Second))
+	} else {
+		command.Printf("state: %s\n", status.State)
+	}
+	if len(status.Error) > 0 {
+		command.Printf("error: %s\n", status.Error)
+	}
+	command.Printf("command: %s\n", status.Command)
+	command.Printf("host: %s (pid %d)\n", status.Host, status.PID)
+	command.Printf("start-time: %s\n", status.StartTime.Local())
+	command.Printf("update-time: %s\n", status.UpdateTime.Local())
+	percent := 0.0
+	if status.TotalRegions > 0 {
+		percent = float64(status.CompletedRegions) * 100 / float64(status.TotalRegions)
+	}
+	command.Printf("regions: %d/%d (%.1f%%)\n", status.CompletedRegions, status.TotalRegions, percent)
+	command.Printf("ranges: %d/%d\n", status.CompletedRanges, status.TotalRanges)
+	command.Printf("backed-up-size: %s\n", units.HumanSize(float64(status.BackedUpBytes)))
+	if status.State == backup.StatusRunning && status.ETA > 0 && !status.Stale(now) {
+		command.Printf("eta: %s\n", status.ETA)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tikv/migration/br/pkg/handover"
+	"github.com/tikv/migration/br/pkg/task"
+	"github.com/tikv/migration/br/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// handoverStatusListener is the name of the listener of the status server passed on upgrade.
+	handoverStatusListener = "status"
+	// handoverMetricsListener is the name of the listener of the metrics server passed on upgrade.
+	handoverMetricsListener = "metrics"
+)
+
+// statusMux is the handler of the status server, other components may register
+// their handlers before the server starts.
+var statusMux = http.NewServeMux()
+
+func init() {
+	statusMux.Handle("/metrics", promhttp.Handler())
+	statusMux.HandleFunc("/reload", handleReload)
+	statusMux.HandleFunc("/settings", handleSettings)
+	statusMux.HandleFunc("/pause", handlePause(true))
+	statusMux.HandleFunc("/resume", handlePause(false))
+}
+
+// handleReload reloads the config file, the same as sending SIGHUP.
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := task.ReloadRuntimeConfig(); err != nil {
+		log.Warn("failed to reload config", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleSettings shows the rate limit and concurrency of the running task by GET, and
+// adjusts them by POST with the same JSON body, e.g. {"ratelimit": 64, "concurrency": 8}.
+// The new values apply to the subsequent requests sent to the stores.
+func handleSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		cfg := &task.RuntimeConfig{}
+		if err := json.NewDecoder(r.Body).Decode(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := cfg.Apply(utils.GlobalDynamicSettings()); err != nil {
+			log.Warn("failed to update settings", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Info("settings updated by status server", zap.Reflect("settings", utils.GlobalDynamicSettings().Load()))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(task.CurrentRuntimeConfig()); err != nil {
+		log.Warn("failed to write settings", zap.Error(err))
+	}
+}
+
+// handlePause pauses or resumes the running restore by POST, the same as POST /settings with
+// {"paused": true} or {"paused": false}. The files being restored are finished before it pauses.
+func handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		utils.GlobalDynamicSettings().Update(func(ts *utils.TaskSettings) {
+			ts.Paused = paused
+		})
+		log.Info("restore paused or resumed by status server", zap.Bool("paused", paused))
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func startStatusServer(addr string) error {
+	listener, err := handover.Global().Listen(handoverStatusListener, addr)
+	if err != nil {
+		return errors.Annotatef(err, "failed to listen status address %s", addr)
+	}
+	log.Info("status server started", zap.Stringer("address", listener.Addr()))
+	go func() {
+		// nolint:gosec
+		if err := http.Serve(listener, statusMux); err != nil {
+			log.Warn("status server stopped", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// startMetricsServer serves the prometheus metrics only, so that it can be exposed to the
+// monitoring system without the handlers adjusting the running task.
+func startMetricsServer(addr string) error {
+	listener, err := handover.Global().Listen(handoverMetricsListener, addr)
+	if err != nil {
+		return errors.Annotatef(err, "failed to listen metrics address %s", addr)
+	}
+	log.Info("metrics server started", zap.Stringer("address", listener.Addr()))
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		// nolint:gosec
+		if err := http.Serve(listener, mux); err != nil {
+			log.Warn("metrics server stopped", zap.Error(err))
+		}
+	}()
+	return nil
+}
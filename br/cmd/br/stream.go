@@ -0,0 +1,100 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/migration/br/pkg/gluetikv"
+	"github.com/tikv/migration/br/pkg/task"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/tikv/migration/br/pkg/version/build"
+	"go.uber.org/zap"
+)
+
+// NewStreamCommand returns a stream subcommand, which backs up the changes of the raw keys
+// continuously into the changelog of the storage.
+func NewStreamCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "stream",
+		Short:        "back up the changes of the raw keys continuously into the changelog specified by --storage",
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+	}
+	command.AddCommand(
+		newStreamStartCommand(),
+		newStreamStatusCommand(),
+	)
+	return command
+}
+
+func newStreamStartCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use: "start",
+		Short: "start the stream backup, it runs until interrupted and resumes from the checkpoint of the changelog. " +
+			"SIGUSR2 hands it over to the binary at the same path, keeping the checkpoint and the GC safe point",
+		Args: cobra.NoArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			cfg := task.StreamConfig{Config: task.Config{LogProgress: HasLogFile()}}
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				command.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			if err := task.RunStreamStart(GetDefaultContext(), gluetikv.Glue{}, "Stream", &cfg); err != nil {
+				log.Error("failed to run the stream backup", zap.Error(err))
+				return errors.Trace(err)
+			}
+			return nil
+		},
+	}
+	task.DefineStreamStartFlags(command)
+	return command
+}
+
+func newStreamStatusCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "status",
+		Short: "print the ts range covered by the changelog",
+		Args:  cobra.NoArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			cfg := task.Config{}
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				command.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			meta, err := task.RunStreamStatus(GetDefaultContext(), &cfg)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			checkpoint := oracle.GetTimeFromTS(meta.CheckpointTS)
+			command.Printf("start-ts: %d (%s)\n", meta.StartTS, oracle.GetTimeFromTS(meta.StartTS))
+			command.Printf("checkpoint-ts: %d (%s)\n", meta.CheckpointTS, checkpoint)
+			command.Printf("lag: %s\n", time.Since(checkpoint).Round(time.Second))
+			return nil
+		},
+	}
+	return command
+}
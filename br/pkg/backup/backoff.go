@@ -0,0 +1,95 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+// backoffByPolicyMs is the backoff hint of a fine grained range deferring to the
+// FineGrained policy, e.g. the store is unreachable or the stream makes no progress.
+const backoffByPolicyMs = 1
+
+// BackoffConfig is the backoff policies of retrying the backup.
+type BackoffConfig struct {
+	// RegionLeader backs off finding the leader of a region from PD.
+	RegionLeader utils.BackoffPolicy `json:"region-leader" toml:"region-leader"`
+	// Stream backs off dispatching the range again after the backup stream fails, once
+	// it's exhausted the range is left to the fine grained backup.
+	Stream utils.BackoffPolicy `json:"stream" toml:"stream"`
+	// FineGrained backs off the rounds of the fine grained backup, the hint of the
+	// responses, e.g. the TTL of a lock, is the least to wait.
+	FineGrained utils.BackoffPolicy `json:"fine-grained" toml:"fine-grained"`
+}
+
+// DefaultBackoffConfig returns the default backoff policies.
+func DefaultBackoffConfig() BackoffConfig {
+	return BackoffConfig{
+		RegionLeader: utils.BackoffPolicy{
+			InitialInterval: 100 * time.Millisecond,
+			MaxInterval:     time.Second,
+			Multiplier:      2,
+			Jitter:          0.2,
+			MaxAttempts:     5,
+		},
+		Stream: utils.BackoffPolicy{
+			InitialInterval: time.Second,
+			MaxInterval:     10 * time.Second,
+			Multiplier:      2,
+			Jitter:          0.2,
+			MaxAttempts:     backupRetryTimes,
+		},
+		FineGrained: utils.BackoffPolicy{
+			// 20s is the default max duration before the raft election timer fires, after
+			// which a dead leader is replaced.
+			InitialInterval: time.Second,
+			MaxInterval:     20 * time.Second,
+			Multiplier:      2,
+			Jitter:          0.2,
+			MaxAttempts:     10,
+		},
+	}
+}
+
+// SetBackoffConfig sets the backoff policies of retrying the backup.
+func (bc *Client) SetBackoffConfig(cfg BackoffConfig) {
+	bc.backoff = &cfg
+}
+
+func (bc *Client) backoffConfig() *BackoffConfig {
+	if bc.backoff == nil {
+		cfg := DefaultBackoffConfig()
+		return &cfg
+	}
+	return bc.backoff
+}
+
+type streamBackoffKey struct{}
+
+// contextWithStreamBackoff sets the backoff policy of SendBackup dispatching the range again.
+func contextWithStreamBackoff(ctx context.Context, policy utils.BackoffPolicy) context.Context {
+	return context.WithValue(ctx, streamBackoffKey{}, policy)
+}
+
+func streamBackoffFromContext(ctx context.Context) utils.BackoffPolicy {
+	policy, ok := ctx.Value(streamBackoffKey{}).(utils.BackoffPolicy)
+	if !ok {
+		return DefaultBackoffConfig().Stream
+	}
+	return policy
+}
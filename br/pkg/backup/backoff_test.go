@@ -0,0 +1,71 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type unavailableBackupClient struct {
+	calls int
+}
+
+func (c *unavailableBackupClient) Backup(context.Context, *backuppb.BackupRequest, ...grpc.CallOption) (backuppb.Backup_BackupClient, error) {
+	c.calls++
+	return nil, status.Error(codes.Unavailable, "store is down")
+}
+
+func TestSendBackupBackoff(t *testing.T) {
+	require.Equal(t, DefaultBackoffConfig().Stream, streamBackoffFromContext(context.Background()))
+	for _, cfg := range []utils.BackoffPolicy{
+		DefaultBackoffConfig().RegionLeader, DefaultBackoffConfig().Stream, DefaultBackoffConfig().FineGrained,
+	} {
+		require.NoError(t, cfg.Validate())
+	}
+
+	policy := utils.BackoffPolicy{InitialInterval: 10 * time.Millisecond, Multiplier: 2, MaxAttempts: 3}
+	ctx := contextWithStreamBackoff(context.Background(), policy)
+	client := &unavailableBackupClient{}
+	resets := 0
+	start := time.Now()
+	// the range is left to the fine grained backup once the backoff is exhausted.
+	err := SendBackup(ctx, 1, client, backuppb.BackupRequest{},
+		func(*backuppb.BackupResponse) error { return nil },
+		func() (backuppb.BackupClient, error) {
+			resets++
+			return client, nil
+		})
+	require.NoError(t, err)
+	require.Equal(t, 4, client.calls)
+	require.Equal(t, 4, resets)
+	require.GreaterOrEqual(t, time.Since(start), 70*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(contextWithStreamBackoff(context.Background(),
+		utils.BackoffPolicy{InitialInterval: time.Hour, Multiplier: 1}))
+	cancel()
+	err = SendBackup(ctx, 1, client, backuppb.BackupRequest{},
+		func(*backuppb.BackupResponse) error { return nil },
+		func() (backuppb.BackupClient, error) { return client, nil })
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,116 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/migration/br/pkg/conn"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// StoreBenchResult is the result of streaming the backup responses of a store.
+type StoreBenchResult struct {
+	StoreID   uint64
+	Address   string
+	Responses int
+	// Errors is the number of the responses with an error, which are not retried.
+	Errors     int
+	TotalKvs   uint64
+	TotalBytes uint64
+	// Size is the size of the SST files, which are discarded by the noop storage.
+	Size     uint64
+	Duration time.Duration
+}
+
+// Speed returns the bytes of the key-value pairs scanned by the store per second.
+func (r *StoreBenchResult) Speed() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.TotalBytes) / r.Duration.Seconds()
+}
+
+// BenchStores backs up the range of the request on all stores at the same time, with
+// the SST files discarded by the noop storage, to measure the throughput of each store
+// without the external storage involved.
+func (bc *Client) BenchStores(ctx context.Context, req backuppb.BackupRequest) ([]StoreBenchResult, error) {
+	allStores, err := conn.GetAllTiKVStoresWithRetry(ctx, bc.mgr.GetPDClient(), conn.SkipTiFlash)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.StorageBackend = &backuppb.StorageBackend{
+		Backend: &backuppb.StorageBackend_Noop{Noop: &backuppb.Noop{}},
+	}
+	bc.applyDynamicSettings(&req)
+
+	stores := make([]*metapb.Store, 0, len(allStores))
+	for _, s := range allStores {
+		if s.GetState() == metapb.StoreState_Up {
+			stores = append(stores, s)
+		}
+	}
+	results := make([]StoreBenchResult, len(stores))
+	eg, ectx := errgroup.WithContext(ctx)
+	for i, s := range stores {
+		result := &results[i]
+		result.StoreID = s.GetId()
+		result.Address = s.GetAddress()
+		eg.Go(func() error {
+			lctx := logutil.ContextWithField(ectx, zap.Uint64("store-id", result.StoreID))
+			client, err := bc.mgr.GetBackupClient(lctx, result.StoreID)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			start := time.Now()
+			err = SendBackup(lctx, result.StoreID, client, req,
+				func(resp *backuppb.BackupResponse) error {
+					result.Responses++
+					if resp.GetError() != nil {
+						result.Errors++
+						return nil
+					}
+					for _, f := range resp.GetFiles() {
+						result.TotalKvs += f.TotalKvs
+						result.TotalBytes += f.TotalBytes
+						result.Size += f.Size_
+					}
+					return nil
+				},
+				func() (backuppb.BackupClient, error) {
+					return bc.mgr.ResetBackupClient(lctx, result.StoreID)
+				})
+			if err != nil {
+				return errors.Trace(err)
+			}
+			result.Duration = time.Since(start)
+			logutil.CL(lctx).Info("store benchmarked",
+				zap.Int("responses", result.Responses),
+				zap.Uint64("total-bytes", result.TotalBytes),
+				zap.Duration("take", result.Duration))
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return results, nil
+}
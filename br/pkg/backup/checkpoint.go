@@ -0,0 +1,258 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// CheckpointFile is the file recording the completed ranges of a running backup,
+// from which an interrupted backup resumes. It's removed once the backup finishes.
+const CheckpointFile = "backup.checkpoint.json"
+
+// CheckpointRange is a completed range and the files backed up for it.
+type CheckpointRange struct {
+	StartKey []byte           `json:"start-key"`
+	EndKey   []byte           `json:"end-key"`
+	Files    []*backuppb.File `json:"files"`
+}
+
+// Checkpoint is the progress of a backup.
+type Checkpoint struct {
+	StartKey      []byte `json:"start-key"`
+	EndKey        []byte `json:"end-key"`
+	StartVersion  uint64 `json:"start-version"`
+	EndVersion    uint64 `json:"end-version"`
+	BackupTS      uint64 `json:"backup-ts"`
+	DstAPIVersion string `json:"dst-api-version"`
+
+	Ranges []CheckpointRange `json:"ranges"`
+}
+
+// checkCompatible checks that the backup of cp is the same one as the backup to resume.
+func (cp *Checkpoint) checkCompatible(other *Checkpoint) error {
+	if !bytes.Equal(cp.StartKey, other.StartKey) || !bytes.Equal(cp.EndKey, other.EndKey) ||
+		cp.StartVersion != other.StartVersion || cp.EndVersion != other.EndVersion ||
+		cp.DstAPIVersion != other.DstAPIVersion {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"the checkpoint is of another backup, range [%x, %x), versions [%d, %d], dst api version %s, "+
+				"please backup to another directory or remove %s",
+			cp.StartKey, cp.EndKey, cp.StartVersion, cp.EndVersion, cp.DstAPIVersion, CheckpointFile)
+	}
+	return nil
+}
+
+// ReadCheckpoint reads the checkpoint of an interrupted backup from the storage,
+// it returns nil if there is none. The checkpoint may be compressed, see SetCheckpointCompression.
+func ReadCheckpoint(ctx context.Context, s storage.ExternalStorage) (*Checkpoint, error) {
+	name, exists, err := storage.FindCompressed(ctx, s, CheckpointFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := storage.WithSuffixCompression(s).ReadFile(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cp := &Checkpoint{}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", name, err)
+	}
+	return cp, nil
+}
+
+// checkpointer collects the completed ranges and persists them periodically.
+type checkpointer struct {
+	storage storage.ExternalStorage
+	// name is CheckpointFile with the suffix of its compression.
+	name string
+
+	mu        sync.Mutex
+	header    Checkpoint
+	completed rtree.RangeTree
+	dirty     bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newCheckpointer(s storage.ExternalStorage, compression storage.CompressType, header Checkpoint) *checkpointer {
+	c := &checkpointer{
+		storage:   storage.WithSuffixCompression(s),
+		name:      CheckpointFile + compression.Suffix(),
+		header:    header,
+		completed: rtree.NewRangeTree(),
+	}
+	c.header.Ranges = nil
+	for _, rg := range header.Ranges {
+		c.completed.Put(rg.StartKey, rg.EndKey, rg.Files)
+	}
+	return c
+}
+
+// put records a completed range.
+func (c *checkpointer) put(startKey, endKey []byte, files []*backuppb.File) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.completed.Put(startKey, endKey, files)
+	c.dirty = true
+}
+
+// resumedRanges returns the completed ranges within [startKey, endKey).
+func (c *checkpointer) resumedRanges(startKey, endKey []byte) rtree.RangeTree {
+	resumed := rtree.NewRangeTree()
+	if c == nil {
+		return resumed
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.completed.IterateOverlapping(startKey, endKey, func(rg *rtree.Range) bool {
+		// a range partially out of [startKey, endKey) is backed up again.
+		if bytes.Compare(rg.StartKey, startKey) >= 0 &&
+			(len(endKey) == 0 || (len(rg.EndKey) != 0 && bytes.Compare(rg.EndKey, endKey) <= 0)) {
+			resumed.Put(rg.StartKey, rg.EndKey, rg.Files)
+		}
+		return true
+	})
+	return resumed
+}
+
+// flush persists the completed ranges if any range completed since the last flush.
+func (c *checkpointer) flush(ctx context.Context) error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	cp := c.header
+	for _, rg := range c.completed.GetSortedRanges() {
+		cp.Ranges = append(cp.Ranges, CheckpointRange{StartKey: rg.StartKey, EndKey: rg.EndKey, Files: rg.Files})
+	}
+	c.dirty = false
+	c.mu.Unlock()
+
+	data, err := json.Marshal(&cp)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = c.storage.WriteFile(ctx, c.name, data); err != nil {
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+		return errors.Trace(err)
+	}
+	log.Debug("backup checkpoint flushed", zap.Int("completed-range-count", len(cp.Ranges)))
+	return nil
+}
+
+func (c *checkpointer) run(ctx context.Context, interval time.Duration) {
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.flush(ctx); err != nil {
+					log.Warn("failed to flush backup checkpoint", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// stop stops flushing periodically, and flushes the last completed ranges.
+func (c *checkpointer) stop() error {
+	c.cancel()
+	<-c.done
+	return errors.Trace(c.flush(context.Background()))
+}
+
+// StartCheckpoint persists the completed ranges of the backup described by header every
+// interval. If resumed is not nil, the backup resumes from it, the ranges completed by
+// the interrupted backup are not backed up again.
+func (bc *Client) StartCheckpoint(ctx context.Context, header Checkpoint, resumed *Checkpoint, interval time.Duration) error {
+	if resumed != nil {
+		if err := resumed.checkCompatible(&header); err != nil {
+			return errors.Trace(err)
+		}
+		header.Ranges = resumed.Ranges
+		log.Info("resume backup from checkpoint", zap.Int("completed-range-count", len(resumed.Ranges)))
+	}
+	bc.checkpoint = newCheckpointer(bc.storage, bc.checkpointCompression, header)
+	bc.checkpoint.run(ctx, interval)
+	return nil
+}
+
+// StopCheckpoint stops the checkpoint and persists the last completed ranges,
+// so that the backup can be resumed.
+func (bc *Client) StopCheckpoint() error {
+	if bc.checkpoint == nil {
+		return nil
+	}
+	c := bc.checkpoint
+	bc.checkpoint = nil
+	return errors.Trace(c.stop())
+}
+
+// SetCheckpointCompression sets the compression of the checkpoint, which is written to
+// CheckpointFile with the suffix of the compression type.
+func (bc *Client) SetCheckpointCompression(tp storage.CompressType) {
+	bc.checkpointCompression = tp
+}
+
+// RemoveCheckpoint stops the checkpoint and removes it from the storage,
+// it's called once the backup finishes.
+func (bc *Client) RemoveCheckpoint(ctx context.Context) error {
+	if bc.checkpoint == nil {
+		return nil
+	}
+	c := bc.checkpoint
+	bc.checkpoint = nil
+	c.cancel()
+	<-c.done
+	// the checkpoint resumed from may be of another compression.
+	for _, name := range storage.CompressedNames(CheckpointFile) {
+		exists, err := bc.storage.FileExists(ctx, name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !exists {
+			continue
+		}
+		if err = bc.storage.DeleteFile(ctx, name); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	bc := &Client{storage: s}
+
+	cp, err := ReadCheckpoint(ctx, s)
+	require.NoError(t, err)
+	require.Nil(t, cp)
+
+	header := Checkpoint{StartKey: []byte("a"), EndKey: []byte("z"), BackupTS: 42, DstAPIVersion: "V2"}
+	require.NoError(t, bc.StartCheckpoint(ctx, header, nil, time.Hour))
+	bc.checkpoint.put([]byte("a"), []byte("c"), []*backuppb.File{{Name: "1.sst"}})
+	bc.checkpoint.put([]byte("x"), []byte("z"), []*backuppb.File{{Name: "2.sst"}})
+	require.NoError(t, bc.StopCheckpoint())
+
+	cp, err = ReadCheckpoint(ctx, s)
+	require.NoError(t, err)
+	require.NotNil(t, cp)
+	require.Equal(t, uint64(42), cp.BackupTS)
+	require.Len(t, cp.Ranges, 2)
+	require.Equal(t, "1.sst", cp.Ranges[0].Files[0].Name)
+
+	// the checkpoint of another backup can't be resumed.
+	other := header
+	other.EndKey = []byte("y")
+	err = bc.StartCheckpoint(ctx, other, cp, time.Hour)
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+
+	require.NoError(t, bc.StartCheckpoint(ctx, header, cp, time.Hour))
+	resumed := bc.checkpoint.resumedRanges([]byte("a"), []byte("z"))
+	require.Equal(t, 2, resumed.Len())
+	// ranges partially out of the backup range are not resumed.
+	resumed = bc.checkpoint.resumedRanges([]byte("b"), []byte("y"))
+	require.Equal(t, 0, resumed.Len())
+
+	require.NoError(t, bc.RemoveCheckpoint(ctx))
+	cp, err = ReadCheckpoint(ctx, s)
+	require.NoError(t, err)
+	require.Nil(t, cp)
+}
+
+func TestCompressedCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	bc := &Client{storage: s}
+	bc.SetCheckpointCompression(storage.Zstd)
+
+	header := Checkpoint{StartKey: []byte("a"), EndKey: []byte("z"), BackupTS: 42, DstAPIVersion: "V2"}
+	require.NoError(t, bc.StartCheckpoint(ctx, header, nil, time.Hour))
+	bc.checkpoint.put([]byte("a"), []byte("c"), []*backuppb.File{{Name: "1.sst"}})
+	require.NoError(t, bc.StopCheckpoint())
+	exists, err := s.FileExists(ctx, CheckpointFile+".zst")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	cp, err := ReadCheckpoint(ctx, s)
+	require.NoError(t, err)
+	require.NotNil(t, cp)
+	require.Len(t, cp.Ranges, 1)
+
+	// the checkpoint resumed by another compression is removed as well.
+	bc.SetCheckpointCompression(storage.Gzip)
+	require.NoError(t, bc.StartCheckpoint(ctx, header, cp, time.Hour))
+	require.NoError(t, bc.StopCheckpoint())
+	require.NoError(t, bc.StartCheckpoint(ctx, header, cp, time.Hour))
+	require.NoError(t, bc.RemoveCheckpoint(ctx))
+	for _, name := range storage.CompressedNames(CheckpointFile) {
+		exists, err = s.FileExists(ctx, name)
+		require.NoError(t, err)
+		require.False(t, exists, name)
+	}
+}
@@ -3,9 +3,9 @@
 package backup
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
@@ -27,6 +27,7 @@ import (
 	"github.com/tikv/client-go/v2/util/codec"
 	"github.com/tikv/migration/br/pkg/conn"
 	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/failpoints"
 	"github.com/tikv/migration/br/pkg/logutil"
 	"github.com/tikv/migration/br/pkg/metautil"
 	"github.com/tikv/migration/br/pkg/redact"
@@ -47,6 +48,8 @@ type ClientMgr interface {
 	ResetBackupClient(ctx context.Context, storeID uint64) (backuppb.BackupClient, error)
 	GetPDClient() pd.Client
 	GetLockResolver() *txnlock.LockResolver
+	// FetchClusterID fetches the cluster ID from PD rather than the cache of the PD client.
+	FetchClusterID(ctx context.Context) (uint64, error)
 	Close()
 }
 
@@ -56,7 +59,16 @@ type ProgressUnit string
 // Maximum total sleep time(in ms) for kv/cop commands.
 const (
 	backupFineGrainedMaxBackoff = 80000
-	backupRetryTimes            = 5
+	// backupMaxCoarseRepush is the max times of falling back to push down backup
+	// when the fine grained backup doesn't converge.
+	backupMaxCoarseRepush = 3
+	backupRetryTimes      = 5
+	// fineGrainedMinWorkers and fineGrainedWorkersPerStore scale the workers of a fine grained
+	// backup round, see fineGrainedWorkers.
+	fineGrainedMinWorkers      = 4
+	fineGrainedWorkersPerStore = 2
+	// DefaultFineGrainedMaxWorkers is the default cap of the workers of a fine grained backup round.
+	DefaultFineGrainedMaxWorkers = 64
 	// RangeUnit represents the progress updated counter when a range finished.
 	RangeUnit ProgressUnit = "range"
 	// RegionUnit represents the progress updated counter when a region finished.
@@ -66,20 +78,70 @@ const (
 // Client is a client instructs TiKV how to do a backup.
 type Client struct {
 	mgr       ClientMgr
-	clusterID uint64
+	clusterID *clusterIDVerifier
 	curAPIVer kvrpcpb.APIVersion
 
 	storage storage.ExternalStorage
 	backend *backuppb.StorageBackend
+	// failover tracks the storage endpoints if the storage is a FailoverStorage.
+	failover *storageFailover
+	// mirror copies the files to the mirrors if the storage is a MirrorStorage.
+	mirror *storage.MirrorStorage
+	// fileLayout is the template of the prefixes of the files of the ranges, see SetFileLayout.
+	fileLayout string
+	layoutDate time.Time
 
 	gcTTL time.Duration
+	// gcSafePointMargin is the margin of the backup ts to the GC safe point, see
+	// SetGCSafePointMargin.
+	gcSafePointMargin time.Duration
+	// safePoint is the service safe point protecting the backup ts from GC.
+	safePoint utils.BRServiceSafePoint
+
+	// settings overrides the rate limit and concurrency of requests if set,
+	// so that they can be adjusted while the backup is running.
+	settings *utils.DynamicSettings
+
+	// fineGrainedMaxRounds and fineGrainedTimeout limit a fine grained backup,
+	// 0 means no limit. Once exceeded, the incomplete ranges are pushed down again.
+	fineGrainedMaxRounds int
+	fineGrainedTimeout   time.Duration
+	// fineGrainedMaxWorkers caps the workers retrying the incomplete regions concurrently.
+	fineGrainedMaxWorkers int
+
+	// transferLeader asks PD to transfer the leaders off the store excluded when a range
+	// failed by it is retried, see SetTransferLeader.
+	transferLeader bool
+
+	// skipStores are the stores avoided by the push down backup, see SetSkipStores.
+	skipStores   *conn.StoreSelector
+	deferSkipped bool
+
+	// stuckRangeTimeout is the timeout of a backup stream receiving no response.
+	stuckRangeTimeout time.Duration
+
+	// backoff is the backoff policies of retrying the backup, see SetBackoffConfig.
+	backoff *BackoffConfig
+
+	// checkpoint records the completed ranges if set, see StartCheckpoint.
+	checkpoint            *checkpointer
+	checkpointCompression storage.CompressType
+
+	// events sends the progress events if set, see Events.
+	events *eventEmitter
+
+	// status publishes the progress of the backup if set, see StartStatus.
+	status *statusReporter
+
+	// heatmap collects the distribution of the backed up data if set, see EnableHeatmap.
+	heatmap *heatmapCollector
 }
 
 // NewBackupClient returns a new backup client.
 func NewBackupClient(ctx context.Context, mgr ClientMgr, config *tls.Config) (*Client, error) {
 	log.Info("new backup client")
 	pdClient := mgr.GetPDClient()
-	clusterID := pdClient.GetClusterID(ctx)
+	clusterID := newClusterIDVerifier(pdClient.GetClusterID(ctx), mgr.FetchClusterID)
 	curAPIVer, err := conn.GetTiKVApiVersion(ctx, mgr.GetPDClient(), config)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -88,6 +150,8 @@ func NewBackupClient(ctx context.Context, mgr ClientMgr, config *tls.Config) (*C
 		clusterID: clusterID,
 		mgr:       mgr,
 		curAPIVer: curAPIVer,
+
+		fineGrainedMaxWorkers: DefaultFineGrainedMaxWorkers,
 	}
 	return &client, nil
 }
@@ -150,16 +214,38 @@ func (bc *Client) UpdateBRGCSafePoint(ctx context.Context, safeInterval time.Dur
 	if err != nil {
 		return 0, errors.Trace(err)
 	}
-	sp := utils.BRServiceSafePoint{
+	if err = bc.updateBRGCSafePointAt(ctx, backupTS); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return backupTS, nil
+}
+
+// UpdateBRGCSafePointWithTS updates the service safe point with the given backup ts,
+// e.g. the backup ts of a checkpoint or a backup point.
+func (bc *Client) UpdateBRGCSafePointWithTS(ctx context.Context, backupTS uint64) error {
+	// check the backup ts does not exceed GCSafePoint
+	if _, err := bc.GetTS(ctx, 0, backupTS); err != nil {
+		return errors.Trace(err)
+	}
+	return bc.updateBRGCSafePointAt(ctx, backupTS)
+}
+
+func (bc *Client) updateBRGCSafePointAt(ctx context.Context, backupTS uint64) error {
+	bc.safePoint = utils.BRServiceSafePoint{
 		BackupTS: backupTS,
 		TTL:      int64(bc.GetGCTTL().Seconds()),
 		ID:       utils.MakeSafePointID(),
 	}
-	err = utils.UpdateServiceSafePoint(ctx, bc.mgr.GetPDClient(), sp)
-	if err != nil {
-		return 0, errors.Trace(err)
-	}
-	return backupTS, nil
+	bc.reportSafePointConflicts(ctx, backupTS)
+	return errors.Trace(utils.UpdateServiceSafePoint(ctx, bc.mgr.GetPDClient(), bc.safePoint))
+}
+
+// StartGCSafePointKeeper keeps the service safe point set by UpdateBRGCSafePoint alive until ctx is done,
+// which survives PD being unavailable for a while. cancel is called if GC invalidates the backup ts.
+func (bc *Client) StartGCSafePointKeeper(ctx context.Context, cancel context.CancelFunc) *utils.ServiceSafePointKeeper {
+	keeper := utils.NewServiceSafePointKeeper(bc.mgr.GetPDClient(), bc.safePoint)
+	keeper.Start(ctx, cancel)
+	return keeper
 }
 
 // SetLockFile set write lock file.
@@ -182,6 +268,42 @@ func (bc *Client) GetGCTTL() time.Duration {
 	return bc.gcTTL
 }
 
+// SetDynamicSettings sets the adjustable settings for client.
+// The subsequent backup requests take the latest rate limit and concurrency from it.
+func (bc *Client) SetDynamicSettings(settings *utils.DynamicSettings) {
+	bc.settings = settings
+}
+
+// applyDynamicSettings overrides the rate limit and concurrency of the request by the latest settings.
+func (bc *Client) applyDynamicSettings(req *backuppb.BackupRequest) {
+	applySettings(bc.settings, req)
+}
+
+func applySettings(settings *utils.DynamicSettings, req *backuppb.BackupRequest) {
+	if settings == nil {
+		return
+	}
+	current := settings.Load()
+	req.RateLimit = current.RateLimit
+	req.Concurrency = current.Concurrency
+}
+
+type dynamicSettingsKey struct{}
+
+// contextWithDynamicSettings makes SendBackup take the latest settings when it
+// dispatches the request again.
+func contextWithDynamicSettings(ctx context.Context, settings *utils.DynamicSettings) context.Context {
+	if settings == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, dynamicSettingsKey{}, settings)
+}
+
+func dynamicSettingsFromContext(ctx context.Context) *utils.DynamicSettings {
+	settings, _ := ctx.Value(dynamicSettingsKey{}).(*utils.DynamicSettings)
+	return settings
+}
+
 // GetStorage gets storage for this backup.
 func (bc *Client) GetStorage() storage.ExternalStorage {
 	return bc.storage
@@ -189,11 +311,15 @@ func (bc *Client) GetStorage() storage.ExternalStorage {
 
 // SetStorage set ExternalStorage for client.
 func (bc *Client) SetStorage(ctx context.Context, backend *backuppb.StorageBackend, opts *storage.ExternalStorageOptions) error {
-	var err error
-	bc.storage, err = storage.New(ctx, backend, opts)
+	s, err := storage.New(ctx, backend, opts)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	return bc.setStorage(ctx, s, backend)
+}
+
+func (bc *Client) setStorage(ctx context.Context, s storage.ExternalStorage, backend *backuppb.StorageBackend) error {
+	bc.storage = s
 	// backupmeta already exists
 	exist, err := bc.storage.FileExists(ctx, metautil.MetaFile)
 	if err != nil {
@@ -214,7 +340,7 @@ func (bc *Client) SetStorage(ctx context.Context, backend *backuppb.StorageBacke
 
 // GetClusterID returns the cluster ID of the tidb cluster to backup.
 func (bc *Client) GetClusterID() uint64 {
-	return bc.clusterID
+	return bc.clusterID.get()
 }
 
 // CheckBackupStorageIsLocked checks whether backups is locked.
@@ -240,6 +366,40 @@ func CheckBackupStorageIsLocked(ctx context.Context, s storage.ExternalStorage)
 	return nil
 }
 
+// SetStuckRangeTimeout sets the timeout of a backup stream receiving no response,
+// after which the range is dispatched again. 0 means no timeout.
+func (bc *Client) SetStuckRangeTimeout(timeout time.Duration) {
+	bc.stuckRangeTimeout = timeout
+}
+
+// SetFineGrainedLimit sets the max rounds and the time budget of a fine grained backup,
+// after which the remaining ranges fall back to push down backup.
+func (bc *Client) SetFineGrainedLimit(maxRounds int, timeout time.Duration) {
+	bc.fineGrainedMaxRounds = maxRounds
+	bc.fineGrainedTimeout = timeout
+}
+
+// SetFineGrainedMaxWorkers sets the cap of the workers of a fine grained backup round.
+func (bc *Client) SetFineGrainedMaxWorkers(maxWorkers int) {
+	bc.fineGrainedMaxWorkers = maxWorkers
+}
+
+// fineGrainedWorkers returns the number of workers retrying the incomplete ranges, which
+// scales with the stores serving them, but no more than the ranges and maxWorkers.
+func fineGrainedWorkers(incomplete, stores, maxWorkers int) int {
+	workers := stores * fineGrainedWorkersPerStore
+	if workers < fineGrainedMinWorkers {
+		workers = fineGrainedMinWorkers
+	}
+	if maxWorkers > 0 && workers > maxWorkers {
+		workers = maxWorkers
+	}
+	if workers > incomplete {
+		workers = incomplete
+	}
+	return workers
+}
+
 // BackupRanges make a backup of the given key ranges.
 func (bc *Client) BackupRanges(
 	ctx context.Context,
@@ -258,6 +418,7 @@ func (bc *Client) BackupRanges(
 		ctx = opentracing.ContextWithSpan(ctx, span1)
 	}
 
+	progressCallBack = bc.status.wrapProgress(progressCallBack)
 	// we collect all files in a single goroutine to avoid thread safety issues.
 	workerPool := utils.NewWorkerPool(concurrency, "Ranges")
 	eg, ectx := errgroup.WithContext(ctx)
@@ -265,8 +426,13 @@ func (bc *Client) BackupRanges(
 		id := id
 		sk, ek := r.StartKey, r.EndKey
 		workerPool.ApplyOnErrorGroup(eg, func() error {
-			elctx := logutil.ContextWithField(ectx, logutil.RedactAny("range-sn", id))
-			err := bc.BackupRange(elctx, sk, ek, req, metaWriter, progressCallBack)
+			elctx := logutil.ContextWithRangeSN(ectx, id)
+			prefix, err := bc.filePrefix(id)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			elctx = contextWithFilePrefix(elctx, prefix)
+			err = bc.BackupRange(elctx, sk, ek, req, metaWriter, progressCallBack)
 			if err != nil {
 				// The error due to context cancel, stack trace is meaningless, the stack shall be suspended (also clear)
 				if errors.Cause(err) == context.Canceled {
@@ -290,33 +456,54 @@ func (bc *Client) BackupRange(
 	progressCallBack func(ProgressUnit),
 ) (err error) {
 	start := time.Now()
+	phases := newRangePhases(ctx)
 	defer func() {
 		elapsed := time.Since(start)
-		logutil.CL(ctx).Info("backup range finished", zap.Duration("take", elapsed))
-		key := "range start:" + hex.EncodeToString(startKey) + " end:" + hex.EncodeToString(endKey)
+		logutil.CL(ctx).Info("backup range finished", append(phases.fields(), zap.Duration("take", elapsed))...)
 		if err != nil {
-			summary.CollectFailureUnit(key, err)
+			summary.CollectFailureRange(startKey, endKey, err)
 		}
 	}()
+	ctx = contextWithStuckTimeout(ctx, bc.stuckRangeTimeout)
+	ctx = contextWithDynamicSettings(ctx, bc.settings)
+	ctx = contextWithStreamBackoff(ctx, bc.backoffConfig().Stream)
 	logutil.CL(ctx).Info("backup started",
 		logutil.Key("startKey", startKey), logutil.Key("endKey", endKey),
 		zap.Uint64("rateLimit", req.RateLimit),
 		zap.Uint32("concurrency", req.Concurrency))
 
-	var allStores []*metapb.Store
-	allStores, err = conn.GetAllTiKVStoresWithRetry(ctx, bc.mgr.GetPDClient(), conn.SkipTiFlash)
+	bc.events.rangeStarted(startKey, endKey)
+
+	var allStores, skipped []*metapb.Store
+	allStores, skipped, err = bc.backupStores(ctx)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if !bc.deferSkipped {
+		ctx = contextWithSkippedStores(ctx, skipped)
+	}
 
 	req.StartKey = startKey
 	req.EndKey = endKey
-	req.StorageBackend = bc.backend
-
-	push := newPushDown(bc.mgr, len(allStores))
+	var endpoint int
+	endpoint, req.StorageBackend = bc.storageBackend(ctx)
+	req.ClusterId = bc.clusterID.get()
+	bc.applyDynamicSettings(&req)
 
 	var results rtree.RangeTree
-	results, err = push.pushBackup(ctx, req, allStores, progressCallBack)
+	phases.begin(PhasePushDown)
+	if resumed := bc.checkpoint.resumedRanges(startKey, endKey); resumed.Len() > 0 {
+		// the range is partially backed up by the interrupted backup, only push down the rest.
+		logutil.CL(ctx).Info("resume backup range from checkpoint", zap.Int("completed-range-count", resumed.Len()))
+		for i := 0; i < resumed.Len(); i++ {
+			progressCallBack(RegionUnit)
+		}
+		results = resumed
+		err = bc.repushIncomplete(ctx, req, results, startKey, endKey, progressCallBack)
+	} else {
+		bc.events.phaseChanged(startKey, endKey, PhasePushDown)
+		results, err = bc.pushDownStores(ctx, req, endpoint, allStores, skipped, progressCallBack)
+	}
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -324,15 +511,29 @@ func (bc *Client) BackupRange(
 
 	// Find and backup remaining ranges.
 	// TODO: test fine grained backup.
-	err = bc.fineGrainedBackup(
-		ctx, req.DstApiVersion, startKey, endKey, req.StartVersion, req.EndVersion, req.CompressionType, req.CompressionLevel,
-		req.RateLimit, req.Concurrency, req.IsRawKv, req.CipherInfo, results, progressCallBack)
+	phases.begin(PhaseFineGrained)
+	fineGrainedCtx, failures := contextWithStoreFailures(ctx)
+	err = bc.completeRange(fineGrainedCtx, req, results, startKey, endKey, progressCallBack)
 	if err != nil {
-		return errors.Trace(err)
+		storeID, ok := failures.culprit()
+		if !ok || len(allStores) < 2 || ctx.Err() != nil {
+			return errors.Trace(err)
+		}
+		// the store may be failing only this range, e.g. a corrupted region, so retry the
+		// range once more on the other stores before failing the whole backup.
+		logutil.CL(ctx).Warn("backup range failed by a store, retry it excluding the store",
+			zap.Uint64("store-id", storeID), zap.Bool("transfer-leader", bc.transferLeader), logutil.ShortError(err))
+		summary.CollectInt(SummaryRangesRetriedExcludingStore, 1)
+		err = bc.completeRange(contextWithExcludedStore(ctx, storeID), req, results, startKey, endKey, progressCallBack)
+		if err != nil {
+			return errors.Annotatef(err, "failed to back up the range excluding store %d", storeID)
+		}
 	}
 
+	phases.end()
 	// update progress of range unit
 	progressCallBack(RangeUnit)
+	bc.events.phaseChanged(startKey, endKey, PhaseFinished)
 
 	if req.IsRawKv {
 		logutil.CL(ctx).Info("raw ranges backed up",
@@ -345,13 +546,24 @@ func (bc *Client) BackupRange(
 			zap.Reflect("EndVersion", req.EndVersion))
 	}
 
+	if bc.mirror != nil {
+		phases.begin(phaseMirror)
+	}
+	err = bc.mirrorFiles(ctx, &results)
+	phases.end()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
 	var ascendErr error
 	results.Ascend(func(i btree.Item) bool {
 		r := i.(*rtree.Range)
 		for _, f := range r.Files {
 			summary.CollectSuccessUnit(summary.TotalKV, 1, f.TotalKvs)
 			summary.CollectSuccessUnit(summary.TotalBytes, 1, f.TotalBytes)
+			bc.status.addBytes(f.Size_)
 		}
+		bc.heatmap.add(r)
 		// we need keep the files in order after we support multi_ingest sst.
 		// default_sst and write_sst need to be together.
 
@@ -371,28 +583,96 @@ func (bc *Client) BackupRange(
 	return nil
 }
 
+// completeRange backs up the incomplete ranges of [startKey, endKey) region by region,
+// and falls back to push down if it doesn't converge.
+func (bc *Client) completeRange(
+	ctx context.Context,
+	req backuppb.BackupRequest,
+	results rtree.RangeTree,
+	startKey, endKey []byte,
+	progressCallBack func(ProgressUnit),
+) error {
+	for repush := 0; ; repush++ {
+		err := bc.fineGrainedBackup(
+			ctx, req.DstApiVersion, startKey, endKey, req.StartVersion, req.EndVersion, req.CompressionType, req.CompressionLevel,
+			req.RateLimit, req.Concurrency, req.IsRawKv, req.CipherInfo, results, progressCallBack)
+		if err == nil {
+			return nil
+		}
+		if !berrors.Is(err, berrors.ErrBackupFineGrainedNotConverged) || repush >= backupMaxCoarseRepush {
+			return errors.Trace(err)
+		}
+		// the leaders of the remaining ranges may have changed, so push them down
+		// to all stores again, instead of retrying the regions one by one.
+		logutil.CL(ctx).Warn("fine grained backup not converged, fallback to push down",
+			zap.Int("repush", repush+1), zap.Error(err))
+		if err = bc.repushIncomplete(ctx, req, results, startKey, endKey, progressCallBack); err != nil {
+			return errors.Trace(err)
+		}
+	}
+}
+
+// repushIncomplete runs push down backup on the incomplete ranges of [startKey, endKey),
+// and puts the results into the range tree.
+func (bc *Client) repushIncomplete(
+	ctx context.Context,
+	req backuppb.BackupRequest,
+	rangeTree rtree.RangeTree,
+	startKey, endKey []byte,
+	progressCallBack func(ProgressUnit),
+) error {
+	allStores, skipped, err := bc.backupStores(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	incomplete := rangeTree.GetIncompleteRange(startKey, endKey)
+	logutil.CL(ctx).Info("start push down on incomplete ranges", zap.Int("incomplete", len(incomplete)))
+	bc.events.phaseChanged(startKey, endKey, PhasePushDown)
+	for _, rg := range incomplete {
+		req.StartKey = rg.StartKey
+		req.EndKey = rg.EndKey
+		bc.applyDynamicSettings(&req)
+		// the storage endpoint may have failed over since the last push down.
+		endpoint, backend := bc.storageBackend(ctx)
+		req.StorageBackend = backend
+		results, err := bc.pushDownStores(ctx, req, endpoint, allStores, skipped, progressCallBack)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		results.Ascend(func(i btree.Item) bool {
+			r := i.(*rtree.Range)
+			rangeTree.Put(r.StartKey, r.EndKey, r.Files)
+			return true
+		})
+	}
+	return nil
+}
+
 func (bc *Client) findRegionLeader(ctx context.Context, key []byte, needEncodeKey bool) (*metapb.Peer, error) {
 	// Keys are saved in encoded format in TiKV, so the key must be encoded
 	// in order to find the correct region.
 	if needEncodeKey {
 		key = codec.EncodeBytes([]byte{}, key)
 	}
-	for i := 0; i < 5; i++ {
-		// better backoff.
+	backoff := bc.backoffConfig().RegionLeader.NewBackoff()
+	for {
 		region, err := bc.mgr.GetPDClient().GetRegion(ctx, key)
 		if err != nil || region == nil {
 			log.Error("find leader failed", zap.Error(err), zap.Reflect("region", region))
-			time.Sleep(time.Millisecond * time.Duration(100*i))
-			continue
-		}
-		if region.Leader != nil {
-			log.Info("find leader",
+		} else if region.Leader != nil {
+			logutil.DedupInfo(log.L(), "find leader",
 				zap.Reflect("Leader", region.Leader), logutil.Key("key", key))
 			return region.Leader, nil
+		} else {
+			log.Warn("no region found", logutil.Key("key", key))
+		}
+		ok, err := backoff.Wait(ctx)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if !ok {
+			break
 		}
-		log.Warn("no region found", logutil.Key("key", key))
-		time.Sleep(time.Millisecond * time.Duration(100*i))
-		continue
 	}
 	log.Error("can not find leader", logutil.Key("key", key))
 	return nil, errors.Annotatef(berrors.ErrBackupNoLeader, "can not find leader")
@@ -419,7 +699,7 @@ func (bc *Client) fineGrainedBackup(
 		ctx = opentracing.ContextWithSpan(ctx, span1)
 	}
 
-	failpoint.Inject("hint-fine-grained-backup", func(v failpoint.Value) {
+	failpoint.Inject(failpoints.FineGrainedBackup, func(v failpoint.Value) {
 		log.Info("failpoint hint-fine-grained-backup injected, "+
 			"process will sleep for 3s and notify the shell.", zap.String("file", v.(string)))
 		if sigFile, ok := v.(string); ok {
@@ -434,25 +714,44 @@ func (bc *Client) fineGrainedBackup(
 		}
 	})
 
+	allStores, err := conn.GetAllTiKVStoresWithRetry(ctx, bc.mgr.GetPDClient(), conn.SkipTiFlash)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// bo resolves the locks, and backoff backs off the rounds.
 	bo := tikv.NewBackoffer(ctx, backupFineGrainedMaxBackoff)
-	for {
+	backoff := bc.backoffConfig().FineGrained.NewBackoff()
+	start := time.Now()
+	for round := 0; ; round++ {
 		// Step1, check whether there is any incomplete range
 		incomplete := rangeTree.GetIncompleteRange(startKey, endKey)
 		if len(incomplete) == 0 {
 			return nil
 		}
-		logutil.CL(ctx).Info("start fine grained backup", zap.Int("incomplete", len(incomplete)))
+		if round == 0 {
+			bc.events.phaseChanged(startKey, endKey, PhaseFineGrained)
+		}
+		if (bc.fineGrainedMaxRounds > 0 && round >= bc.fineGrainedMaxRounds) ||
+			(bc.fineGrainedTimeout > 0 && time.Since(start) > bc.fineGrainedTimeout) {
+			return errors.Annotatef(berrors.ErrBackupFineGrainedNotConverged,
+				"%d ranges incomplete after %d rounds in %s", len(incomplete), round, time.Since(start))
+		}
+		backupFineGrainedRounds.Inc()
+		workers := fineGrainedWorkers(len(incomplete), len(allStores), bc.fineGrainedMaxWorkers)
+		logutil.CL(ctx).Info("start fine grained backup",
+			zap.Int("incomplete", len(incomplete)), zap.Int("workers", workers))
 		// Step2, retry backup on incomplete range
-		respCh := make(chan *backuppb.BackupResponse, 4)
-		errCh := make(chan error, 4)
-		retry := make(chan rtree.Range, 4)
+		respCh := make(chan *backuppb.BackupResponse, workers)
+		errCh := make(chan error, workers)
+		retry := make(chan rtree.Range, workers)
 
 		max := &struct {
 			ms int
 			mu sync.Mutex
 		}{}
 		wg := new(sync.WaitGroup)
-		for i := 0; i < 4; i++ {
+		for i := 0; i < workers; i++ {
 			wg.Add(1)
 			fork, _ := bo.Fork()
 			go func(boFork *tikv.Backoffer) {
@@ -506,6 +805,9 @@ func (bc *Client) fineGrainedBackup(
 					logutil.Key("fine-grained-range-end", resp.EndKey),
 				)
 				rangeTree.Put(resp.StartKey, resp.EndKey, resp.Files)
+				bc.checkpoint.put(resp.StartKey, resp.EndKey, resp.Files)
+				bc.events.regionDone(resp.StartKey, resp.EndKey, 0)
+				observeBackupFiles(resp.Files)
 				// Update progress
 				progressCallBack(RegionUnit)
 			}
@@ -516,17 +818,25 @@ func (bc *Client) fineGrainedBackup(
 		ms := max.ms
 		max.mu.Unlock()
 		if ms != 0 {
-			log.Info("handle fine grained", zap.Int("backoffMs", ms))
-			// TODO: fill a meaningful error.
-			err := bo.BackoffWithMaxSleepTxnLockFast(ms, berrors.ErrUnknown)
-			if err != nil {
+			interval, ok := backoff.Next()
+			if !ok {
+				return errors.Annotatef(berrors.ErrBackupFineGrainedNotConverged,
+					"%d ranges incomplete after backing off %d times", len(incomplete), backoff.Attempts())
+			}
+			// the hint of the responses, e.g. the TTL of a lock, is the least to wait.
+			if hint := time.Duration(ms) * time.Millisecond; hint > interval {
+				interval = hint
+			}
+			log.Info("handle fine grained", zap.Int("backoffMs", ms), zap.Duration("backoff", interval))
+			if err := utils.Sleep(ctx, interval); err != nil {
 				return errors.Trace(err)
 			}
 		}
 	}
 }
 
-// OnBackupResponse checks the backup resp, decides whether to retry and generate the error.
+// OnBackupResponse checks the backup resp, decides whether to retry and generate the error
+// by the first matched ErrorPolicy.
 func OnBackupResponse(
 	storeID uint64,
 	bo *tikv.Backoffer,
@@ -534,64 +844,21 @@ func OnBackupResponse(
 	lockResolver *txnlock.LockResolver,
 	resp *backuppb.BackupResponse,
 ) (*backuppb.BackupResponse, int, error) {
+	return onBackupResponse(&ErrorContext{
+		StoreID:      storeID,
+		Bo:           bo,
+		BackupTS:     backupTS,
+		LockResolver: lockResolver,
+	}, resp)
+}
+
+func onBackupResponse(ec *ErrorContext, resp *backuppb.BackupResponse) (*backuppb.BackupResponse, int, error) {
 	log.Debug("OnBackupResponse", zap.Reflect("resp", resp))
 	if resp.Error == nil {
 		return resp, 0, nil
 	}
-	backoffMs := 0
-	switch v := resp.Error.Detail.(type) {
-	case *backuppb.Error_KvError:
-		if lockErr := v.KvError.Locked; lockErr != nil {
-			// Try to resolve lock.
-			log.Warn("backup occur kv error", zap.Reflect("error", v))
-			msBeforeExpired, err1 := lockResolver.ResolveLocks(
-				bo, backupTS, []*txnlock.Lock{txnlock.NewLock(lockErr)})
-			if err1 != nil {
-				return nil, 0, errors.Trace(err1)
-			}
-			if msBeforeExpired > 0 {
-				backoffMs = int(msBeforeExpired)
-			}
-			return nil, backoffMs, nil
-		}
-		// Backup should not meet error other than KeyLocked.
-		log.Error("unexpect kv error", zap.Reflect("KvError", v.KvError))
-		return nil, backoffMs, errors.Annotatef(berrors.ErrKVUnknown, "storeID: %d OnBackupResponse error %v", storeID, v)
-
-	case *backuppb.Error_RegionError:
-		regionErr := v.RegionError
-		// Ignore following errors.
-		if !(regionErr.EpochNotMatch != nil ||
-			regionErr.NotLeader != nil ||
-			regionErr.RegionNotFound != nil ||
-			regionErr.ServerIsBusy != nil ||
-			regionErr.StaleCommand != nil ||
-			regionErr.StoreNotMatch != nil ||
-			regionErr.ReadIndexNotReady != nil ||
-			regionErr.ProposalInMergingMode != nil) {
-			log.Error("unexpect region error", zap.Reflect("RegionError", regionErr))
-			return nil, backoffMs, errors.Annotatef(berrors.ErrKVUnknown, "storeID: %d OnBackupResponse error %v", storeID, v)
-		}
-		log.Warn("backup occur region error",
-			zap.Reflect("RegionError", regionErr),
-			zap.Uint64("storeID", storeID))
-		// TODO: a better backoff.
-		backoffMs = 1000 /* 1s */
-		return nil, backoffMs, nil
-	case *backuppb.Error_ClusterIdError:
-		log.Error("backup occur cluster ID error", zap.Reflect("error", v), zap.Uint64("storeID", storeID))
-		return nil, 0, errors.Annotatef(berrors.ErrKVClusterIDMismatch, "%v on storeID: %d", resp.Error, storeID)
-	default:
-		// UNSAFE! TODO: use meaningful error code instead of unstructured message to find failed to write error.
-		if utils.MessageIsRetryableStorageError(resp.GetError().GetMsg()) {
-			log.Warn("backup occur storage error", zap.String("error", resp.GetError().GetMsg()))
-			// back off 3000ms, for S3 is 99.99% available (i.e. the max outage time would less than 52.56mins per year),
-			// this time would be probably enough for s3 to resume.
-			return nil, 3000, nil
-		}
-		log.Error("backup occur unknown error", zap.String("error", resp.Error.GetMsg()), zap.Uint64("storeID", storeID))
-		return nil, 0, errors.Annotatef(berrors.ErrKVUnknown, "%v on storeID: %d", resp.Error, storeID)
-	}
+	backoffMs, err := handleBackupError(ec, resp.Error)
+	return nil, backoffMs, err
 }
 
 func (bc *Client) handleFineGrained(
@@ -615,14 +882,18 @@ func (bc *Client) handleFineGrained(
 		return 0, errors.Trace(pderr)
 	}
 	storeID := leader.GetStoreId()
+	if isExcludedStore(ctx, storeID) {
+		return bc.moveLeaderOff(ctx, rg.StartKey, encodeKey, storeID), nil
+	}
+	endpoint, backend := bc.storageBackend(ctx)
 
 	req := backuppb.BackupRequest{
-		ClusterId:        bc.clusterID,
+		ClusterId:        bc.clusterID.get(),
 		StartKey:         rg.StartKey, // TODO: the range may cross region.
 		EndKey:           rg.EndKey,
 		StartVersion:     lastBackupTS,
 		EndVersion:       backupTS,
-		StorageBackend:   bc.backend,
+		StorageBackend:   backend,
 		RateLimit:        rateLimit,
 		Concurrency:      concurrency,
 		IsRawKv:          isRawKv,
@@ -631,14 +902,16 @@ func (bc *Client) handleFineGrained(
 		CompressionLevel: compressionLevel,
 		CipherInfo:       cipherInfo,
 	}
+	bc.applyDynamicSettings(&req)
 	lockResolver := bc.mgr.GetLockResolver()
 	client, err := bc.mgr.GetBackupClient(ctx, storeID)
 	if err != nil {
+		bc.events.storeError(rg.StartKey, rg.EndKey, storeID, err)
+		storeFailuresFromContext(ctx).add(storeID)
 		if berrors.Is(err, berrors.ErrFailedToConnect) {
-			// When the leader store is died,
-			// 20s for the default max duration before the raft election timer fires.
+			// When the leader store is died, wait for a new leader to be elected.
 			logutil.CL(ctx).Warn("failed to connect to store, skipping", logutil.ShortError(err), zap.Uint64("storeID", storeID))
-			return 20000, nil
+			return backoffByPolicyMs, nil
 		}
 
 		logutil.CL(ctx).Error("fail to connect store", zap.Uint64("StoreID", storeID))
@@ -650,8 +923,16 @@ func (bc *Client) handleFineGrained(
 		ctx, storeID, client, req,
 		// Handle responses with the same backoffer.
 		func(resp *backuppb.BackupResponse) error {
-			response, shouldBackoff, err1 :=
-				OnBackupResponse(storeID, bo, backupTS, lockResolver, resp)
+			response, shouldBackoff, err1 := onBackupResponse(&ErrorContext{
+				StoreID:      storeID,
+				Bo:           bo,
+				BackupTS:     backupTS,
+				LockResolver: lockResolver,
+				ctx:          ctx,
+				clusterID:    bc.clusterID,
+				failover:     bc.failover,
+				endpoint:     endpoint,
+			}, resp)
 			if err1 != nil {
 				return err1
 			}
@@ -659,6 +940,8 @@ func (bc *Client) handleFineGrained(
 				backoffMill = shouldBackoff
 			}
 			if response != nil {
+				prefixFiles(filePrefixFromContext(ctx), response.GetFiles())
+				bc.failover.recordFiles(endpoint, response.GetFiles())
 				respCh <- response
 			}
 			// When meet an error, we need to set hasProgress too, in case of
@@ -672,22 +955,22 @@ func (bc *Client) handleFineGrained(
 			return bc.mgr.ResetBackupClient(ctx, storeID)
 		})
 	if err != nil {
+		bc.events.storeError(rg.StartKey, rg.EndKey, storeID, err)
+		storeFailuresFromContext(ctx).add(storeID)
 		if berrors.Is(err, berrors.ErrFailedToConnect) {
-			// When the leader store is died,
-			// 20s for the default max duration before the raft election timer fires.
+			// When the leader store is died, wait for a new leader to be elected.
 			logutil.CL(ctx).Warn("failed to connect to store, skipping", logutil.ShortError(err), zap.Uint64("storeID", storeID))
-			return 20000, nil
+			return backoffByPolicyMs, nil
 		}
 		logutil.CL(ctx).Error("failed to send fine-grained backup", zap.Uint64("storeID", storeID), logutil.ShortError(err))
 		return 0, errors.Annotatef(err, "failed to send fine-grained backup [%s, %s)",
 			redact.Key(req.StartKey), redact.Key(req.EndKey))
 	}
 
-	// If no progress, backoff 10s for debouncing.
-	// 10s is the default interval of stores sending a heartbeat to the PD.
-	// And is the average new leader election timeout, which would be a reasonable back off time.
+	// If no progress, back off for debouncing, e.g. waiting for the stores sending a
+	// heartbeat to PD, or a new leader to be elected.
 	if !hasProgress {
-		backoffMill = 10000
+		backoffMill = backoffByPolicyMs
 	}
 	return backoffMill, nil
 }
@@ -713,12 +996,48 @@ func SendBackup(
 	}
 
 	var errReset error
+	chunks := responseChunks{}
+	stuckTimeout := stuckTimeoutFromContext(ctx)
+	watchdog := newStreamWatchdog(0, nil)
+	cancelStream := context.CancelFunc(func() {})
+	defer func() {
+		watchdog.stop()
+		cancelStream()
+	}()
+	backoff := streamBackoffFromContext(ctx).NewBackoff()
+	// stuck is whether the last stream is canceled by the watchdog, which has waited long enough.
+	stuck := false
 backupLoop:
-	for retry := 0; retry < backupRetryTimes; retry++ {
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			interval, ok := backoff.Next()
+			if !ok {
+				// the incomplete range is left to the fine grained backup.
+				logutil.CL(ctx).Warn("give up dispatching the range after retries", zap.Uint64("store-id", storeID),
+					logutil.Key("start-key", req.StartKey), logutil.Key("end-key", req.EndKey), zap.Int("retry-time", retry))
+				break
+			}
+			if !stuck {
+				if err := utils.Sleep(ctx, interval); err != nil {
+					return errors.Trace(err)
+				}
+			}
+			stuck = false
+			backupRegionCounters.WithLabelValues("retry").Inc()
+			// the settings may be adjusted since the last dispatch.
+			applySettings(dynamicSettingsFromContext(ctx), &req)
+		}
 		logutil.CL(ctx).Info("try backup",
 			zap.Int("retry time", retry),
+			zap.Uint64("rate-limit", req.RateLimit),
+			zap.Uint32("concurrency", req.Concurrency),
 		)
-		failpoint.Inject("hint-backup-start", func(v failpoint.Value) {
+		watchdog.stop()
+		cancelStream()
+		var sctx context.Context
+		sctx, cancelStream = context.WithCancel(ctx)
+		watchdog = newStreamWatchdog(stuckTimeout, cancelStream)
+		failpoint.Inject(failpoints.BackupStart, func(v failpoint.Value) {
 			logutil.CL(ctx).Info("failpoint hint-backup-start injected, " +
 				"process will notify the shell.")
 			if sigFile, ok := v.(string); ok {
@@ -732,14 +1051,14 @@ backupLoop:
 			}
 			time.Sleep(3 * time.Second)
 		})
-		bcli, err := client.Backup(ctx, &req)
-		failpoint.Inject("reset-retryable-error", func(val failpoint.Value) {
+		bcli, err := client.Backup(sctx, &req)
+		failpoint.Inject(failpoints.ResetRetryableError, func(val failpoint.Value) {
 			if val.(bool) {
 				logutil.CL(ctx).Debug("failpoint reset-retryable-error injected.")
 				err = status.Error(codes.Unavailable, "Unavailable error")
 			}
 		})
-		failpoint.Inject("reset-not-retryable-error", func(val failpoint.Value) {
+		failpoint.Inject(failpoints.ResetNotRetryableError, func(val failpoint.Value) {
 			if val.(bool) {
 				logutil.CL(ctx).Debug("failpoint reset-not-retryable-error injected.")
 				err = status.Error(codes.Unknown, "Your server was haunted hence doesn't work, meow :3")
@@ -747,7 +1066,6 @@ backupLoop:
 		})
 		if err != nil {
 			if isRetryableError(err) {
-				time.Sleep(3 * time.Second)
 				client, errReset = resetFn()
 				if errReset != nil {
 					return errors.Annotatef(errReset, "failed to reset backup connection on store:%d "+
@@ -757,20 +1075,43 @@ backupLoop:
 			}
 			logutil.CL(ctx).Error("fail to backup", zap.Uint64("StoreID", storeID),
 				zap.Int("retry time", retry))
-			return berrors.ErrFailedToConnect.Wrap(err).GenWithStack("failed to create backup stream to store %d", storeID)
+			return berrors.ErrFailedToConnect.Wrap(annotateMsgSizeError(err)).
+				GenWithStack("failed to create backup stream to store %d", storeID)
 		}
 
 		for {
 			resp, err := bcli.Recv()
 			if err != nil {
+				if watchdog.hasFired() && ctx.Err() == nil {
+					// the stream is hung, dispatch the range again. If it keeps stuck,
+					// the range is left incomplete and retried by the fine grained backup,
+					// which sends it to the current leader.
+					logutil.CL(ctx).Warn("stuck range detected, dispatch it again",
+						zap.Uint64("store-id", storeID),
+						zap.Duration("no-response-for", stuckTimeout),
+						logutil.Key("start-key", req.StartKey), logutil.Key("end-key", req.EndKey),
+						zap.Int("retry-time", retry))
+					summary.CollectInt(SummaryStuckRanges, 1)
+					backupRegionCounters.WithLabelValues("stuck").Inc()
+					chunks.reset()
+					_ = bcli.CloseSend()
+					stuck = true
+					break
+				}
 				if errors.Cause(err) == io.EOF { // nolint:errorlint
 					logutil.CL(ctx).Info("backup streaming finish",
 						zap.Int("retry-time", retry))
 					_ = bcli.CloseSend()
+					if last := chunks.flush(); last != nil {
+						if err = respFn(last); err != nil {
+							return errors.Trace(err)
+						}
+					}
 					break backupLoop
 				}
 				if isRetryableError(err) {
-					time.Sleep(3 * time.Second)
+					// the incomplete range is backed up again in the new stream.
+					chunks.reset()
 					// current tikv is unavailable
 					client, errReset = resetFn()
 					if errReset != nil {
@@ -782,13 +1123,19 @@ backupLoop:
 					break
 				}
 				_ = bcli.CloseSend()
-				return berrors.ErrFailedToConnect.Wrap(err).GenWithStack("failed to connect to store: %d with retry times:%d", storeID, retry)
+				return berrors.ErrFailedToConnect.Wrap(annotateMsgSizeError(err)).
+					GenWithStack("failed to connect to store: %d with retry times:%d", storeID, retry)
 			}
 
+			watchdog.feed()
 			// TODO: handle errors in the resp.
-			logutil.CL(ctx).Info("range backed up",
+			logutil.DedupInfo(logutil.CL(ctx), "range backed up",
 				logutil.Key("small-range-start-key", resp.GetStartKey()),
 				logutil.Key("small-range-end-key", resp.GetEndKey()))
+			resp = chunks.add(resp)
+			if resp == nil {
+				continue
+			}
 			err = respFn(resp)
 			if err != nil {
 				_ = bcli.CloseSend()
@@ -804,6 +1151,63 @@ const (
 	gRPCCancel = "the client connection is closing"
 )
 
+// responseChunks reassembles the responses of one range, which TiKV may split into
+// several chunks when the file list is too large to fit in a single message.
+type responseChunks struct {
+	pending *backuppb.BackupResponse
+}
+
+// add buffers the response, and returns the previous complete response if the new one
+// is not a chunk of it.
+func (c *responseChunks) add(resp *backuppb.BackupResponse) *backuppb.BackupResponse {
+	prev := c.pending
+	if prev != nil && prev.GetError() == nil && resp.GetError() == nil &&
+		bytes.Equal(prev.GetStartKey(), resp.GetStartKey()) &&
+		bytes.Equal(prev.GetEndKey(), resp.GetEndKey()) {
+		prev.Files = appendNewFiles(prev.Files, resp.GetFiles())
+		return nil
+	}
+	c.pending = resp
+	return prev
+}
+
+// flush returns the buffered response at the end of the stream.
+func (c *responseChunks) flush() *backuppb.BackupResponse {
+	prev := c.pending
+	c.pending = nil
+	return prev
+}
+
+// reset drops the buffered response, which may be incomplete.
+func (c *responseChunks) reset() {
+	c.pending = nil
+}
+
+// appendNewFiles appends the files of the chunk which are not in the list yet,
+// since a chunk may be sent more than once.
+func appendNewFiles(files []*backuppb.File, chunk []*backuppb.File) []*backuppb.File {
+	names := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		names[f.GetName()] = struct{}{}
+	}
+	for _, f := range chunk {
+		if _, ok := names[f.GetName()]; ok {
+			continue
+		}
+		names[f.GetName()] = struct{}{}
+		files = append(files, f)
+	}
+	return files
+}
+
+// annotateMsgSizeError adds a hint to the error caused by a gRPC message exceeding the size limit.
+func annotateMsgSizeError(err error) error {
+	if status.Code(err) == codes.ResourceExhausted {
+		return errors.Annotate(err, "the gRPC message is too large, try to increase --grpc-max-msg-size")
+	}
+	return err
+}
+
 // isRetryableError represents whether we should retry reset grpc connection.
 func isRetryableError(err error) bool {
 
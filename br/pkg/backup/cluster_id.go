@@ -0,0 +1,112 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// clusterIDVerifier keeps the cluster ID sent to TiKV, and re-verifies it with PD once
+// a store rejects the request by the cluster ID mismatch, to tell the user what happened.
+type clusterIDVerifier struct {
+	fetch func(ctx context.Context) (uint64, error)
+	// adoptRecreated continues the backup with the new cluster ID if the cluster is recreated.
+	adoptRecreated bool
+
+	mu        sync.Mutex
+	clusterID uint64
+}
+
+func newClusterIDVerifier(clusterID uint64, fetch func(ctx context.Context) (uint64, error)) *clusterIDVerifier {
+	return &clusterIDVerifier{clusterID: clusterID, fetch: fetch}
+}
+
+func (v *clusterIDVerifier) get() uint64 {
+	if v == nil {
+		return 0
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.clusterID
+}
+
+// verify re-verifies the cluster ID rejected by the store with PD. It returns nil if the
+// request can be retried with the cluster ID adopted, or an error explaining the mismatch.
+func (v *clusterIDVerifier) verify(ctx context.Context, storeID uint64, e *backuppb.ClusterIDError) error {
+	if v == nil {
+		return errors.Annotatef(berrors.ErrKVClusterIDMismatch,
+			"store %d is of cluster %d, the request is of cluster %d", storeID, e.GetCurrent(), e.GetRequest())
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if e.GetRequest() != v.clusterID && e.GetCurrent() == v.clusterID {
+		// the request was sent before the new cluster ID adopted.
+		return nil
+	}
+	if v.fetch == nil {
+		return errors.Annotatef(berrors.ErrKVClusterIDMismatch,
+			"store %d is of cluster %d, the request is of cluster %d", storeID, e.GetCurrent(), e.GetRequest())
+	}
+	pdID, err := v.fetch(ctx)
+	if err != nil {
+		return errors.Annotatef(berrors.ErrKVClusterIDMismatch,
+			"store %d is of cluster %d, the request is of cluster %d, and failed to re-verify it with PD: %v",
+			storeID, e.GetCurrent(), e.GetRequest(), err)
+	}
+	log.Warn("re-verified the cluster ID with PD", zap.Uint64("storeID", storeID),
+		zap.Uint64("store-cluster-id", e.GetCurrent()), zap.Uint64("request-cluster-id", e.GetRequest()),
+		zap.Uint64("pd-cluster-id", pdID))
+	switch {
+	case pdID == e.GetCurrent() && pdID != v.clusterID:
+		if v.adoptRecreated {
+			log.Warn("the cluster is recreated, continue the backup with the new cluster ID",
+				zap.Uint64("old-cluster-id", v.clusterID), zap.Uint64("new-cluster-id", pdID))
+			v.clusterID = pdID
+			return nil
+		}
+		return errors.Annotatef(berrors.ErrKVClusterIDMismatch,
+			"the cluster is recreated since the backup started, its ID changed from %d to %d, "+
+				"e.g. PD is recovered by pd-recover with a new cluster ID. Please make sure the data is intact, "+
+				"then rerun the backup, or rerun it with --adopt-new-cluster-id to continue with the new cluster ID",
+			v.clusterID, pdID)
+	case pdID == v.clusterID && pdID != e.GetCurrent():
+		return errors.Annotatef(berrors.ErrKVClusterIDMismatch,
+			"store %d is of cluster %d while PD is of cluster %d. The store may join the PD of another cluster by mistake, "+
+				"or PD is recovered by pd-recover with a wrong cluster ID, which should be recovered with the cluster ID %d",
+			storeID, e.GetCurrent(), pdID, e.GetCurrent())
+	default:
+		return errors.Annotatef(berrors.ErrKVClusterIDMismatch,
+			"store %d is of cluster %d, the request is of cluster %d, while PD is of cluster %d",
+			storeID, e.GetCurrent(), e.GetRequest(), pdID)
+	}
+}
+
+// SetAdoptNewClusterID sets whether to continue the backup with the new cluster ID if
+// the cluster is recreated during the backup, e.g. PD is recovered with a new cluster ID.
+func (bc *Client) SetAdoptNewClusterID(adopt bool) {
+	if bc.clusterID == nil {
+		return
+	}
+	bc.clusterID.mu.Lock()
+	defer bc.clusterID.mu.Unlock()
+	bc.clusterID.adoptRecreated = adopt
+}
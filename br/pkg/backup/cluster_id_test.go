@@ -0,0 +1,66 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+func TestClusterIDVerifier(t *testing.T) {
+	ctx := context.Background()
+	pdID := uint64(2)
+	v := newClusterIDVerifier(1, func(context.Context) (uint64, error) { return pdID, nil })
+
+	// the cluster is recreated.
+	err := v.verify(ctx, 10, &backuppb.ClusterIDError{Current: 2, Request: 1})
+	require.True(t, berrors.Is(err, berrors.ErrKVClusterIDMismatch))
+	require.Contains(t, err.Error(), "the cluster is recreated")
+	require.Equal(t, uint64(1), v.get())
+
+	// the store belongs to another cluster.
+	pdID = 1
+	err = v.verify(ctx, 10, &backuppb.ClusterIDError{Current: 3, Request: 1})
+	require.True(t, berrors.Is(err, berrors.ErrKVClusterIDMismatch))
+	require.Contains(t, err.Error(), "store 10 is of cluster 3 while PD is of cluster 1")
+
+	// failed to re-verify.
+	v.fetch = func(context.Context) (uint64, error) { return 0, errors.New("pd is down") }
+	err = v.verify(ctx, 10, &backuppb.ClusterIDError{Current: 2, Request: 1})
+	require.True(t, berrors.Is(err, berrors.ErrKVClusterIDMismatch))
+	require.Contains(t, err.Error(), "pd is down")
+
+	// adopt the new cluster ID.
+	pdID = 2
+	v.fetch = func(context.Context) (uint64, error) { return pdID, nil }
+	bc := &Client{clusterID: v}
+	bc.SetAdoptNewClusterID(true)
+	ec := &ErrorContext{StoreID: 10, ctx: ctx, clusterID: v}
+	resp := &backuppb.BackupResponse{Error: &backuppb.Error{
+		Detail: &backuppb.Error_ClusterIdError{ClusterIdError: &backuppb.ClusterIDError{Current: 2, Request: 1}},
+	}}
+	_, backoffMs, err := onBackupResponse(ec, resp)
+	require.NoError(t, err)
+	require.Equal(t, 1000, backoffMs)
+	require.Equal(t, uint64(2), bc.GetClusterID())
+	// the requests sent before the adoption are retried.
+	v.fetch = nil
+	require.NoError(t, v.verify(ctx, 11, &backuppb.ClusterIDError{Current: 2, Request: 1}))
+}
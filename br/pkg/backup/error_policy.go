@@ -0,0 +1,200 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	"github.com/tikv/client-go/v2/tikv"
+	"github.com/tikv/client-go/v2/txnkv/txnlock"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// ErrorContext is the context of the backup response carrying an error.
+type ErrorContext struct {
+	StoreID      uint64
+	Bo           *tikv.Backoffer
+	BackupTS     uint64
+	LockResolver *txnlock.LockResolver
+
+	ctx context.Context
+	// clusterID re-verifies the cluster ID on the cluster ID errors if not nil.
+	clusterID *clusterIDVerifier
+	// failover counts the storage errors of the endpoint the request writes to if not nil.
+	failover *storageFailover
+	endpoint int
+}
+
+// ErrorPolicy decides how to handle an error of the backup response.
+type ErrorPolicy interface {
+	// Match tells whether the policy handles the error.
+	Match(e *backuppb.Error) bool
+	// Handle returns the backoff in milliseconds before retrying the range,
+	// or a non-nil error if the backup should fail.
+	Handle(ec *ErrorContext, e *backuppb.Error) (int, error)
+}
+
+var (
+	errorPoliciesMu sync.RWMutex
+	// errorPolicies are the registered policies, the latest registered one goes first.
+	errorPolicies []ErrorPolicy
+	// defaultErrorPolicies are consulted after the registered ones, the last one matches any error.
+	defaultErrorPolicies = []ErrorPolicy{
+		kvErrorPolicy{},
+		regionErrorPolicy{},
+		clusterIDErrorPolicy{},
+		storageErrorPolicy{},
+		unknownErrorPolicy{},
+	}
+)
+
+// RegisterErrorPolicy registers a policy which takes precedence over the default
+// policies and the ones registered before, so new TiKV error types can be handled
+// or the default handling can be overridden.
+func RegisterErrorPolicy(p ErrorPolicy) {
+	errorPoliciesMu.Lock()
+	defer errorPoliciesMu.Unlock()
+	errorPolicies = append([]ErrorPolicy{p}, errorPolicies...)
+}
+
+func handleBackupError(ec *ErrorContext, e *backuppb.Error) (int, error) {
+	errorPoliciesMu.RLock()
+	policies := append(append([]ErrorPolicy{}, errorPolicies...), defaultErrorPolicies...)
+	errorPoliciesMu.RUnlock()
+	for _, p := range policies {
+		if p.Match(e) {
+			return p.Handle(ec, e)
+		}
+	}
+	// unreachable, unknownErrorPolicy matches any error.
+	return unknownErrorPolicy{}.Handle(ec, e)
+}
+
+// kvErrorPolicy resolves the locks, backup should not meet kv error other than KeyLocked.
+type kvErrorPolicy struct{}
+
+func (kvErrorPolicy) Match(e *backuppb.Error) bool {
+	_, ok := e.Detail.(*backuppb.Error_KvError)
+	return ok
+}
+
+func (kvErrorPolicy) Handle(ec *ErrorContext, e *backuppb.Error) (int, error) {
+	v := e.Detail.(*backuppb.Error_KvError)
+	lockErr := v.KvError.Locked
+	if lockErr == nil {
+		log.Error("unexpect kv error", zap.Reflect("KvError", v.KvError))
+		return 0, errors.Annotatef(berrors.ErrKVUnknown, "storeID: %d OnBackupResponse error %v", ec.StoreID, v)
+	}
+	// Try to resolve lock.
+	log.Warn("backup occur kv error", zap.Reflect("error", v))
+	msBeforeExpired, err := ec.LockResolver.ResolveLocks(
+		ec.Bo, ec.BackupTS, []*txnlock.Lock{txnlock.NewLock(lockErr)})
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	backoffMs := 0
+	if msBeforeExpired > 0 {
+		backoffMs = int(msBeforeExpired)
+	}
+	return backoffMs, nil
+}
+
+// regionErrorPolicy retries the region errors which go away after the region cache is updated.
+type regionErrorPolicy struct{}
+
+func (regionErrorPolicy) Match(e *backuppb.Error) bool {
+	_, ok := e.Detail.(*backuppb.Error_RegionError)
+	return ok
+}
+
+func (regionErrorPolicy) Handle(ec *ErrorContext, e *backuppb.Error) (int, error) {
+	v := e.Detail.(*backuppb.Error_RegionError)
+	regionErr := v.RegionError
+	// Ignore following errors.
+	if !(regionErr.EpochNotMatch != nil ||
+		regionErr.NotLeader != nil ||
+		regionErr.RegionNotFound != nil ||
+		regionErr.ServerIsBusy != nil ||
+		regionErr.StaleCommand != nil ||
+		regionErr.StoreNotMatch != nil ||
+		regionErr.ReadIndexNotReady != nil ||
+		regionErr.ProposalInMergingMode != nil) {
+		log.Error("unexpect region error", zap.Reflect("RegionError", regionErr))
+		return 0, errors.Annotatef(berrors.ErrKVUnknown, "storeID: %d OnBackupResponse error %v", ec.StoreID, v)
+	}
+	log.Warn("backup occur region error",
+		zap.Reflect("RegionError", regionErr),
+		zap.Uint64("storeID", ec.StoreID))
+	// TODO: a better backoff.
+	return 1000 /* 1s */, nil
+}
+
+// clusterIDErrorPolicy re-verifies the cluster ID with PD, and retries the range if
+// the new cluster ID is adopted.
+type clusterIDErrorPolicy struct{}
+
+func (clusterIDErrorPolicy) Match(e *backuppb.Error) bool {
+	_, ok := e.Detail.(*backuppb.Error_ClusterIdError)
+	return ok
+}
+
+func (clusterIDErrorPolicy) Handle(ec *ErrorContext, e *backuppb.Error) (int, error) {
+	log.Error("backup occur cluster ID error", zap.Reflect("error", e.Detail), zap.Uint64("storeID", ec.StoreID))
+	if ec.clusterID == nil {
+		return 0, errors.Annotatef(berrors.ErrKVClusterIDMismatch, "%v on storeID: %d", e, ec.StoreID)
+	}
+	ctx := ec.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ec.clusterID.verify(ctx, ec.StoreID, e.GetClusterIdError()); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return 1000 /* 1s */, nil
+}
+
+// storageErrorPolicy retries the failures of writing to the external storage.
+type storageErrorPolicy struct{}
+
+func (storageErrorPolicy) Match(e *backuppb.Error) bool {
+	// UNSAFE! TODO: use meaningful error code instead of unstructured message to find failed to write error.
+	return utils.MessageIsRetryableStorageError(e.GetMsg())
+}
+
+func (storageErrorPolicy) Handle(ec *ErrorContext, e *backuppb.Error) (int, error) {
+	log.Warn("backup occur storage error", zap.String("error", e.GetMsg()))
+	// the retried request writes to the failed over endpoint on sustained errors.
+	ec.failover.reportError(ec.endpoint, e.GetMsg())
+	// back off 3000ms, for S3 is 99.99% available (i.e. the max outage time would less than 52.56mins per year),
+	// this time would be probably enough for s3 to resume.
+	return 3000, nil
+}
+
+type unknownErrorPolicy struct{}
+
+func (unknownErrorPolicy) Match(*backuppb.Error) bool {
+	return true
+}
+
+func (unknownErrorPolicy) Handle(ec *ErrorContext, e *backuppb.Error) (int, error) {
+	log.Error("backup occur unknown error", zap.String("error", e.GetMsg()), zap.Uint64("storeID", ec.StoreID))
+	return 0, errors.Annotatef(berrors.ErrKVUnknown, "%v on storeID: %d", e, ec.StoreID)
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"strings"
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/stretchr/testify/require"
+)
+
+type throttledErrorPolicy struct{}
+
+func (throttledErrorPolicy) Match(e *backuppb.Error) bool {
+	return strings.Contains(e.GetMsg(), "throttled")
+}
+
+func (throttledErrorPolicy) Handle(*ErrorContext, *backuppb.Error) (int, error) {
+	return 500, nil
+}
+
+func TestRegisterErrorPolicy(t *testing.T) {
+	defer func() { errorPolicies = nil }()
+	resp := &backuppb.BackupResponse{Error: &backuppb.Error{Msg: "store is throttled"}}
+
+	_, backoffMs, err := OnBackupResponse(1, nil, 0, nil, resp)
+	require.Error(t, err)
+	require.Equal(t, 0, backoffMs)
+
+	RegisterErrorPolicy(throttledErrorPolicy{})
+	_, backoffMs, err = OnBackupResponse(1, nil, 0, nil, resp)
+	require.NoError(t, err)
+	require.Equal(t, 500, backoffMs)
+
+	// the other errors are still handled by the default policies.
+	resp = &backuppb.BackupResponse{Error: &backuppb.Error{Msg: "unknown"}}
+	_, _, err = OnBackupResponse(1, nil, 0, nil, resp)
+	require.Error(t, err)
+}
@@ -0,0 +1,154 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType is the type of a backup progress event.
+type EventType int
+
+const (
+	// EventRangeStarted is sent when the backup of a range starts.
+	EventRangeStarted EventType = iota
+	// EventRegionDone is sent when a region, or a part of it, is backed up.
+	EventRegionDone
+	// EventStoreError is sent when a store fails to backup, the range is retried later.
+	EventStoreError
+	// EventPhaseChanged is sent when the backup of a range enters another phase.
+	EventPhaseChanged
+)
+
+// String implements fmt.Stringer.
+func (t EventType) String() string {
+	switch t {
+	case EventRangeStarted:
+		return "RangeStarted"
+	case EventRegionDone:
+		return "RegionDone"
+	case EventStoreError:
+		return "StoreError"
+	case EventPhaseChanged:
+		return "PhaseChanged"
+	default:
+		return "Unknown"
+	}
+}
+
+// The phases of the backup of a range.
+const (
+	// PhasePushDown pushes the range down to all stores.
+	PhasePushDown = "push-down"
+	// PhaseFineGrained retries the incomplete regions one by one at their leaders.
+	PhaseFineGrained = "fine-grained"
+	// PhaseFinished means the range is backed up.
+	PhaseFinished = "finished"
+)
+
+// eventChanSize is the buffer size of the event channel, the events are
+// dropped once it's full, so that a slow consumer never stalls the backup.
+const eventChanSize = 1024
+
+// Event is a progress event of the backup.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// StartKey and EndKey are the range of the event.
+	StartKey []byte
+	EndKey   []byte
+	// StoreID is the store of EventRegionDone and EventStoreError, 0 if unknown.
+	StoreID uint64
+	// Phase is the new phase of EventPhaseChanged.
+	Phase string
+	// Err is the error of EventStoreError.
+	Err error
+}
+
+// eventEmitter sends events to the channel without blocking, it's nil-safe.
+type eventEmitter struct {
+	mu      sync.Mutex
+	ch      chan Event
+	closed  bool
+	dropped int
+}
+
+func newEventEmitter() *eventEmitter {
+	return &eventEmitter{ch: make(chan Event, eventChanSize)}
+}
+
+func (e *eventEmitter) emit(event Event) {
+	if e == nil {
+		return
+	}
+	event.Time = time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.ch <- event:
+	default:
+		e.dropped++
+	}
+}
+
+func (e *eventEmitter) rangeStarted(startKey, endKey []byte) {
+	e.emit(Event{Type: EventRangeStarted, StartKey: startKey, EndKey: endKey})
+}
+
+func (e *eventEmitter) regionDone(startKey, endKey []byte, storeID uint64) {
+	e.emit(Event{Type: EventRegionDone, StartKey: startKey, EndKey: endKey, StoreID: storeID})
+}
+
+func (e *eventEmitter) storeError(startKey, endKey []byte, storeID uint64, err error) {
+	e.emit(Event{Type: EventStoreError, StartKey: startKey, EndKey: endKey, StoreID: storeID, Err: err})
+}
+
+func (e *eventEmitter) phaseChanged(startKey, endKey []byte, phase string) {
+	e.emit(Event{Type: EventPhaseChanged, StartKey: startKey, EndKey: endKey, Phase: phase})
+}
+
+// close closes the channel, and returns the number of the dropped events.
+func (e *eventEmitter) close() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.closed {
+		e.closed = true
+		close(e.ch)
+	}
+	return e.dropped
+}
+
+// Events returns the channel of the progress events of the backups run by the client
+// from now on. It's for embedders building their own UIs, the events are dropped if
+// the channel is full, and the channel is closed by CloseEvents.
+func (bc *Client) Events() <-chan Event {
+	if bc.events == nil {
+		bc.events = newEventEmitter()
+	}
+	return bc.events.ch
+}
+
+// CloseEvents closes the channel returned by Events, it's called after the backup finishes.
+// It returns the number of events dropped because the channel was full.
+func (bc *Client) CloseEvents() int {
+	if bc.events == nil {
+		return 0
+	}
+	return bc.events.close()
+}
@@ -0,0 +1,51 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvents(t *testing.T) {
+	bc := &Client{}
+	// no event is sent before Events is called.
+	bc.events.rangeStarted([]byte("a"), []byte("b"))
+	require.Equal(t, 0, bc.CloseEvents())
+
+	events := bc.Events()
+	bc.events.rangeStarted([]byte("a"), []byte("b"))
+	bc.events.phaseChanged([]byte("a"), []byte("b"), PhasePushDown)
+	bc.events.regionDone([]byte("a"), []byte("b"), 1)
+	bc.events.storeError([]byte("a"), []byte("b"), 2, errors.New("store down"))
+	for i := 0; i < eventChanSize; i++ {
+		bc.events.regionDone([]byte("a"), []byte("b"), 1)
+	}
+	require.Equal(t, 4, bc.CloseEvents())
+	// the events after close are ignored.
+	bc.events.phaseChanged([]byte("a"), []byte("b"), PhaseFinished)
+
+	types := make([]EventType, 0, 4)
+	for event := range events {
+		if len(types) < 4 {
+			types = append(types, event.Type)
+		}
+		require.False(t, event.Time.IsZero())
+	}
+	require.Equal(t, []EventType{EventRangeStarted, EventPhaseChanged, EventRegionDone, EventStoreError}, types)
+	require.Equal(t, "StoreError", EventStoreError.String())
+}
@@ -0,0 +1,253 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/btree"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/client-go/v2/util/codec"
+	"github.com/tikv/migration/br/pkg/conn"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"go.uber.org/zap"
+)
+
+const (
+	// SummaryRangesRetriedExcludingStore is the summary key of the number of the ranges
+	// retried excluding the store failing them.
+	SummaryRangesRetriedExcludingStore = "ranges retried excluding store"
+
+	// excludedLeaderBackoffMs is the backoff waiting for the leader to move off the excluded
+	// store by itself, which is the interval of stores sending a heartbeat to PD.
+	excludedLeaderBackoffMs = 10000
+	// transferredLeaderBackoffMs is the backoff waiting for the leader transferred by PD.
+	transferredLeaderBackoffMs = 3000
+)
+
+// leaderTransferer transfers the leader of a region, it's implemented by the PD controller
+// of conn.Mgr.
+type leaderTransferer interface {
+	TransferLeader(ctx context.Context, regionID, toStoreID uint64) error
+}
+
+// storeFailures counts the failures of the fine grained backup of a range by store,
+// so that the store failing the range can be excluded when the range is retried.
+type storeFailures struct {
+	mu     sync.Mutex
+	counts map[uint64]int
+}
+
+func (f *storeFailures) add(storeID uint64) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counts[storeID]++
+}
+
+// culprit returns the store failing the most, the smaller ID wins a tie.
+func (f *storeFailures) culprit() (storeID uint64, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	most := 0
+	for id, count := range f.counts {
+		if count > most || (count == most && id < storeID) {
+			storeID, most = id, count
+		}
+	}
+	return storeID, most > 0
+}
+
+type storeFailuresKey struct{}
+
+// contextWithStoreFailures makes the fine grained backup count the failures by store into
+// the returned storeFailures.
+func contextWithStoreFailures(ctx context.Context) (context.Context, *storeFailures) {
+	f := &storeFailures{counts: make(map[uint64]int)}
+	return context.WithValue(ctx, storeFailuresKey{}, f), f
+}
+
+func storeFailuresFromContext(ctx context.Context) *storeFailures {
+	f, _ := ctx.Value(storeFailuresKey{}).(*storeFailures)
+	return f
+}
+
+type excludedStoreKey struct{}
+
+// contextWithExcludedStore makes the backup skip the store, the regions led by it are
+// backed up once their leaders move to the other stores.
+func contextWithExcludedStore(ctx context.Context, storeID uint64) context.Context {
+	return context.WithValue(ctx, excludedStoreKey{}, storeID)
+}
+
+// excludedStoreFromContext returns the excluded store, 0 if none.
+func excludedStoreFromContext(ctx context.Context) uint64 {
+	storeID, _ := ctx.Value(excludedStoreKey{}).(uint64)
+	return storeID
+}
+
+type skippedStoresKey struct{}
+
+// contextWithSkippedStores makes the fine grained backup skip the stores like the excluded
+// one, i.e. the regions led by them are backed up once their leaders move.
+func contextWithSkippedStores(ctx context.Context, stores []*metapb.Store) context.Context {
+	if len(stores) == 0 {
+		return ctx
+	}
+	storeIDs := make(map[uint64]struct{}, len(stores))
+	for _, store := range stores {
+		storeIDs[store.GetId()] = struct{}{}
+	}
+	return context.WithValue(ctx, skippedStoresKey{}, storeIDs)
+}
+
+// isExcludedStore returns whether the store is excluded or skipped by the context.
+func isExcludedStore(ctx context.Context, storeID uint64) bool {
+	if storeID == excludedStoreFromContext(ctx) {
+		return true
+	}
+	skipped, _ := ctx.Value(skippedStoresKey{}).(map[uint64]struct{})
+	_, ok := skipped[storeID]
+	return ok
+}
+
+// SetSkipStores sets the stores the push down backup avoids, e.g. the stores known to be
+// draining. The regions led by them are left to the fine grained backup, which waits for
+// or transfers their leaders off the stores like the excluded one, see SetTransferLeader.
+// If deprioritize is true, the ranges are pushed down to them only after the other stores
+// finish instead, so that they are still used but carry the least load.
+func (bc *Client) SetSkipStores(selector *conn.StoreSelector, deprioritize bool) {
+	bc.skipStores = selector
+	bc.deferSkipped = deprioritize
+}
+
+// backupStores returns the TiKV stores the ranges are pushed down to, and the ones skipped
+// by SetSkipStores.
+func (bc *Client) backupStores(ctx context.Context) (stores, skipped []*metapb.Store, err error) {
+	allStores, err := conn.GetAllTiKVStoresWithRetry(ctx, bc.mgr.GetPDClient(), conn.SkipTiFlash)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	stores, skipped = conn.FilterStores(allStores, bc.skipStores)
+	if len(stores) == 0 && len(skipped) > 0 && !bc.deferSkipped {
+		return nil, nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"all the %d stores are skipped by %s, no store is left to backup", len(skipped), bc.skipStores)
+	}
+	return stores, skipped, nil
+}
+
+// pushDownStores pushes the backup down to the stores, and then to the deferred stores if
+// the skipped stores are deprioritized. The regions whose leaders are on the deferred stores
+// are left to the fine grained backup otherwise.
+func (bc *Client) pushDownStores(
+	ctx context.Context,
+	req backuppb.BackupRequest,
+	endpoint int,
+	stores, deferred []*metapb.Store,
+	progressCallBack func(ProgressUnit),
+) (rtree.RangeTree, error) {
+	results, err := bc.pushDown(ctx, req, endpoint, stores, progressCallBack)
+	if err != nil || !bc.deferSkipped || len(deferred) == 0 {
+		return results, errors.Trace(err)
+	}
+	logutil.CL(ctx).Info("push down the backup to the deprioritized stores", zap.Int("stores", len(deferred)))
+	deferredResults, err := bc.pushDown(ctx, req, endpoint, deferred, progressCallBack)
+	if err != nil {
+		return results, errors.Trace(err)
+	}
+	deferredResults.Ascend(func(i btree.Item) bool {
+		r := i.(*rtree.Range)
+		results.Put(r.StartKey, r.EndKey, r.Files)
+		return true
+	})
+	return results, nil
+}
+
+func (bc *Client) pushDown(
+	ctx context.Context,
+	req backuppb.BackupRequest,
+	endpoint int,
+	stores []*metapb.Store,
+	progressCallBack func(ProgressUnit),
+) (rtree.RangeTree, error) {
+	push := newPushDown(bc.mgr, len(stores))
+	push.checkpoint = bc.checkpoint
+	push.events = bc.events
+	push.clusterID = bc.clusterID
+	push.failover, push.endpoint = bc.failover, endpoint
+	results, err := push.pushBackup(ctx, req, stores, progressCallBack)
+	return results, errors.Trace(err)
+}
+
+// SetTransferLeader sets whether to ask PD to transfer the leaders off the excluded store,
+// when a range failed by the store is retried. Otherwise the backup waits for the leaders
+// to move by themselves.
+func (bc *Client) SetTransferLeader(transfer bool) {
+	bc.transferLeader = transfer
+}
+
+// moveLeaderOff handles a region led by the excluded store in the fine grained backup,
+// it returns the backoff before finding the leader again.
+func (bc *Client) moveLeaderOff(ctx context.Context, key []byte, needEncodeKey bool, excluded uint64) int {
+	if !bc.transferLeader {
+		logutil.CL(ctx).Info("the region is led by the excluded store, wait for the leader to move",
+			logutil.Key("key", key), zap.Uint64("store-id", excluded))
+		return excludedLeaderBackoffMs
+	}
+	if err := bc.transferLeaderOff(ctx, key, needEncodeKey, excluded); err != nil {
+		logutil.CL(ctx).Warn("failed to transfer the leader off the excluded store, wait for it to move",
+			logutil.Key("key", key), zap.Uint64("store-id", excluded), logutil.ShortError(err))
+		return excludedLeaderBackoffMs
+	}
+	return transferredLeaderBackoffMs
+}
+
+// transferLeaderOff asks PD to transfer the leader of the region containing the key to
+// a voter on another store.
+func (bc *Client) transferLeaderOff(ctx context.Context, key []byte, needEncodeKey bool, excluded uint64) error {
+	transferer, ok := bc.mgr.(leaderTransferer)
+	if !ok {
+		return errors.Annotate(berrors.ErrUnsupportedOperation, "transferring leader isn't supported")
+	}
+	if needEncodeKey {
+		key = codec.EncodeBytes([]byte{}, key)
+	}
+	region, err := bc.mgr.GetPDClient().GetRegion(ctx, key)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if region == nil {
+		return errors.Annotate(berrors.ErrBackupNoLeader, "region not found")
+	}
+	for _, peer := range region.Meta.GetPeers() {
+		if peer.GetStoreId() == excluded || isExcludedStore(ctx, peer.GetStoreId()) ||
+			peer.GetRole() != metapb.PeerRole_Voter {
+			continue
+		}
+		logutil.CL(ctx).Info("transfer the leader off the excluded store",
+			zap.Uint64("region-id", region.Meta.GetId()),
+			zap.Uint64("from-store-id", excluded), zap.Uint64("to-store-id", peer.GetStoreId()))
+		return errors.Trace(transferer.TransferLeader(ctx, region.Meta.GetId(), peer.GetStoreId()))
+	}
+	return errors.Annotatef(berrors.ErrBackupNoLeader,
+		"region %d has no voter besides the excluded store %d", region.Meta.GetId(), excluded)
+}
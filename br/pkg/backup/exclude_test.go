@@ -0,0 +1,183 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/conn"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	pd "github.com/tikv/pd/client"
+)
+
+type regionPDClient struct {
+	pd.Client
+	region *pd.Region
+}
+
+func (c *regionPDClient) GetRegion(ctx context.Context, key []byte, opts ...pd.GetRegionOption) (*pd.Region, error) {
+	return c.region, nil
+}
+
+type transferLeaderMgr struct {
+	*mockBackupMgr
+	transferred map[uint64]uint64
+}
+
+func (mgr *transferLeaderMgr) TransferLeader(ctx context.Context, regionID, toStoreID uint64) error {
+	mgr.transferred[regionID] = toStoreID
+	return nil
+}
+
+func TestStoreFailures(t *testing.T) {
+	ctx, failures := contextWithStoreFailures(context.Background())
+	_, ok := failures.culprit()
+	require.False(t, ok)
+	storeFailuresFromContext(ctx).add(3)
+	storeFailuresFromContext(ctx).add(2)
+	storeID, ok := failures.culprit()
+	require.True(t, ok)
+	require.Equal(t, uint64(2), storeID)
+	storeFailuresFromContext(ctx).add(3)
+	storeID, _ = failures.culprit()
+	require.Equal(t, uint64(3), storeID)
+
+	// failures aren't counted without the context.
+	storeFailuresFromContext(context.Background()).add(1)
+	require.Zero(t, excludedStoreFromContext(ctx))
+	require.Equal(t, uint64(3), excludedStoreFromContext(contextWithExcludedStore(ctx, 3)))
+}
+
+func TestPushDownExcludedStore(t *testing.T) {
+	mgr, err := newMockBackupMgr()
+	require.NoError(t, err)
+	stores := []*metapb.Store{{Id: 1, State: metapb.StoreState_Up}}
+	req := backuppb.BackupRequest{StartKey: testBackupStart, EndKey: []byte("rc")}
+
+	ctx := contextWithExcludedStore(context.Background(), 1)
+	results, err := newPushDown(mgr, 1).pushBackup(ctx, req, stores, func(ProgressUnit) {})
+	require.NoError(t, err)
+	require.Zero(t, results.Len())
+
+	ctx = contextWithExcludedStore(context.Background(), 2)
+	results, err = newPushDown(mgr, 1).pushBackup(ctx, req, stores, func(ProgressUnit) {})
+	require.NoError(t, err)
+	require.Equal(t, 1, results.Len())
+}
+
+func TestMoveLeaderOff(t *testing.T) {
+	mockMgr, err := newMockBackupMgr()
+	require.NoError(t, err)
+	mockMgr.pdClient = &regionPDClient{region: &pd.Region{Meta: &metapb.Region{
+		Id: 10,
+		Peers: []*metapb.Peer{
+			{StoreId: 1},
+			{StoreId: 2, Role: metapb.PeerRole_Learner},
+			{StoreId: 3},
+		},
+	}}}
+	mgr := &transferLeaderMgr{mockBackupMgr: mockMgr, transferred: make(map[uint64]uint64)}
+	ctx := context.Background()
+
+	bc := &Client{mgr: mgr}
+	require.Equal(t, excludedLeaderBackoffMs, bc.moveLeaderOff(ctx, []byte("a"), false, 1))
+	require.Empty(t, mgr.transferred)
+
+	bc.SetTransferLeader(true)
+	require.Equal(t, transferredLeaderBackoffMs, bc.moveLeaderOff(ctx, []byte("a"), true, 1))
+	require.Equal(t, map[uint64]uint64{10: 3}, mgr.transferred)
+
+	// no voter to transfer the leader to.
+	delete(mgr.transferred, 10)
+	mockMgr.pdClient.(*regionPDClient).region.Meta.Peers[2].Role = metapb.PeerRole_Learner
+	require.Equal(t, excludedLeaderBackoffMs, bc.moveLeaderOff(ctx, []byte("a"), false, 1))
+	require.Empty(t, mgr.transferred)
+
+	// the manager doesn't support transferring leader.
+	bc = &Client{mgr: mockMgr, transferLeader: true}
+	require.Equal(t, excludedLeaderBackoffMs, bc.moveLeaderOff(ctx, []byte("a"), false, 1))
+}
+
+type storesPDClient struct {
+	pd.Client
+	stores []*metapb.Store
+}
+
+func (c *storesPDClient) GetAllStores(ctx context.Context, opts ...pd.GetStoreOption) ([]*metapb.Store, error) {
+	return c.stores, nil
+}
+
+func TestSkippedStores(t *testing.T) {
+	ctx := contextWithSkippedStores(context.Background(), []*metapb.Store{{Id: 2}, {Id: 3}})
+	require.False(t, isExcludedStore(ctx, 1))
+	require.True(t, isExcludedStore(ctx, 2))
+	require.True(t, isExcludedStore(contextWithExcludedStore(ctx, 1), 1))
+	require.False(t, isExcludedStore(context.Background(), 2))
+
+	mgr, err := newMockBackupMgr()
+	require.NoError(t, err)
+	mgr.pdClient = &storesPDClient{stores: []*metapb.Store{
+		{Id: 1},
+		{Id: 2, Labels: []*metapb.StoreLabel{{Key: "zone", Value: "z1"}}},
+		{Id: 3, Labels: []*metapb.StoreLabel{{Key: "engine", Value: "tiflash"}}},
+	}}
+	bc := &Client{mgr: mgr}
+	stores, skipped, err := bc.backupStores(context.Background())
+	require.NoError(t, err)
+	require.Len(t, stores, 2)
+	require.Empty(t, skipped)
+
+	bc.SetSkipStores(&conn.StoreSelector{Labels: []*metapb.StoreLabel{{Key: "zone", Value: "z1"}}}, false)
+	stores, skipped, err = bc.backupStores(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), stores[0].Id)
+	require.Equal(t, uint64(2), skipped[0].Id)
+
+	// no store is left unless the skipped ones are deprioritized.
+	bc.SetSkipStores(&conn.StoreSelector{StoreIDs: []uint64{1, 2}}, false)
+	_, _, err = bc.backupStores(context.Background())
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+	bc.SetSkipStores(&conn.StoreSelector{StoreIDs: []uint64{1, 2}}, true)
+	stores, skipped, err = bc.backupStores(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, stores)
+	require.Len(t, skipped, 2)
+}
+
+func TestPushDownDeferredStores(t *testing.T) {
+	mgr, err := newMockBackupMgr()
+	require.NoError(t, err)
+	stores := []*metapb.Store{{Id: 1, State: metapb.StoreState_Up}}
+	deferred := []*metapb.Store{{Id: 2, State: metapb.StoreState_Up}}
+	req := backuppb.BackupRequest{StartKey: testBackupStart, EndKey: []byte("rc")}
+
+	for _, deprioritize := range []bool{false, true} {
+		bc := &Client{mgr: mgr}
+		bc.SetSkipStores(&conn.StoreSelector{StoreIDs: []uint64{2}}, deprioritize)
+		pushed := 0
+		results, err := bc.pushDownStores(context.Background(), req, 0, stores, deferred, func(ProgressUnit) { pushed++ })
+		require.NoError(t, err)
+		require.Equal(t, 1, results.Len())
+		if deprioritize {
+			require.Equal(t, 2, pushed)
+		} else {
+			require.Equal(t, 1, pushed)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// storageFailover tracks the storage endpoint the backup requests write to, which fails over
+// on the storage errors of TiKV, and the files written to each endpoint.
+type storageFailover struct {
+	storage *storage.FailoverStorage
+
+	mu sync.Mutex
+	// files are the names of the files written to each endpoint.
+	files map[int][]string
+}
+
+func newStorageFailover(s *storage.FailoverStorage) *storageFailover {
+	return &storageFailover{storage: s, files: make(map[int][]string)}
+}
+
+// endpoint returns the active endpoint, which the requests sent to TiKV write to.
+func (f *storageFailover) endpoint() (int, *backuppb.StorageBackend) {
+	idx, endpoint := f.storage.Active()
+	return idx, endpoint.Backend
+}
+
+// reportError counts a storage error of TiKV writing to the endpoint.
+func (f *storageFailover) reportError(endpoint int, msg string) {
+	if f == nil {
+		return
+	}
+	f.storage.ReportError(endpoint, errors.New(msg))
+}
+
+// recordFiles records the files written to the endpoint by TiKV.
+func (f *storageFailover) recordFiles(endpoint int, files []*backuppb.File) {
+	if f == nil {
+		return
+	}
+	f.storage.ReportSuccess(endpoint)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, file := range files {
+		f.files[endpoint] = append(f.files[endpoint], file.Name)
+	}
+}
+
+// locations returns the files written to each endpoint.
+func (f *storageFailover) locations() *metautil.Locations {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l := &metautil.Locations{}
+	// the locations are written to the active endpoint.
+	_, active := f.storage.Active()
+	for i, endpoint := range f.storage.Endpoints() {
+		files := metautil.EndpointFiles{
+			URI:   endpoint.Storage.URI(),
+			Files: append([]string{}, f.files[i]...),
+		}
+		files.RelativeURI, _ = storage.RelativeURL(active.Storage.URI(), files.URI)
+		l.Endpoints = append(l.Endpoints, files)
+	}
+	return l
+}
+
+// SetFailoverStorage sets the storage of the backup to the endpoints failing over to each other.
+// The files are written to the active endpoint of the storage.
+func (bc *Client) SetFailoverStorage(ctx context.Context, s *storage.FailoverStorage) error {
+	_, endpoint := s.Active()
+	if err := bc.setStorage(ctx, s, endpoint.Backend); err != nil {
+		return errors.Trace(err)
+	}
+	bc.failover = newStorageFailover(s)
+	return nil
+}
+
+// storageBackend returns the index of the endpoint and the storage backend the requests write to,
+// which is at the file prefix of the context, see SetFileLayout.
+func (bc *Client) storageBackend(ctx context.Context) (int, *backuppb.StorageBackend) {
+	idx, backend := 0, bc.backend
+	if bc.failover != nil {
+		idx, backend = bc.failover.endpoint()
+	}
+	// the backends are checked by SetFileLayout.
+	if prefixed, err := storage.BackendWithPrefix(backend, filePrefixFromContext(ctx)); err == nil {
+		backend = prefixed
+	}
+	return idx, backend
+}
+
+// FileLocations returns the files written to each storage endpoint,
+// it's nil if the backup is not written to a FailoverStorage.
+func (bc *Client) FileLocations() *metautil.Locations {
+	if bc.failover == nil {
+		return nil
+	}
+	return bc.failover.locations()
+}
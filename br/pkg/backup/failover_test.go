@@ -0,0 +1,78 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestStorageFailover(t *testing.T) {
+	ctx := context.Background()
+	endpoints := make([]storage.StorageEndpoint, 0, 2)
+	for i := 0; i < 2; i++ {
+		dir := t.TempDir()
+		s, err := storage.NewLocalStorage(dir)
+		require.NoError(t, err)
+		endpoints = append(endpoints, storage.StorageEndpoint{
+			Backend: &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_Local{Local: &backuppb.Local{Path: dir}}},
+			Storage: s,
+		})
+	}
+	s, err := storage.NewFailoverStorage(ctx, endpoints, storage.FailoverPolicyPrimary, 2)
+	require.NoError(t, err)
+	bc := &Client{}
+	require.NoError(t, bc.SetFailoverStorage(ctx, s))
+	endpoint, backend := bc.storageBackend(ctx)
+	require.Equal(t, 0, endpoint)
+	require.Equal(t, endpoints[0].Backend, backend)
+	bc.failover.recordFiles(endpoint, []*backuppb.File{{Name: "1.sst"}})
+
+	// the retried requests write to the secondary endpoint on the sustained storage errors of TiKV.
+	resp := &backuppb.BackupResponse{Error: &backuppb.Error{Msg: "failed to put object: connection reset by peer"}}
+	for i := 0; i < 2; i++ {
+		_, backoffMs, err := onBackupResponse(&ErrorContext{failover: bc.failover, endpoint: endpoint}, resp)
+		require.NoError(t, err)
+		require.Greater(t, backoffMs, 0)
+	}
+	endpoint, backend = bc.storageBackend(ctx)
+	require.Equal(t, 1, endpoint)
+	require.Equal(t, endpoints[1].Backend, backend)
+	bc.failover.recordFiles(endpoint, []*backuppb.File{{Name: "2.sst"}, {Name: "3.sst"}})
+
+	locations := bc.FileLocations()
+	require.Len(t, locations.Endpoints, 2)
+	require.Equal(t, []string{"1.sst"}, locations.Endpoints[0].Files)
+	require.Equal(t, []string{"2.sst", "3.sst"}, locations.Endpoints[1].Files)
+	// the locations are written to the active endpoint, and the others are relative to it.
+	require.Equal(t, ".", locations.Endpoints[1].RelativeURI)
+	require.NotEmpty(t, locations.Endpoints[0].RelativeURI)
+	fileEndpoints, err := locations.FileEndpoints(endpoints[1].Storage.URI())
+	require.NoError(t, err)
+	require.Equal(t, endpoints[0].Storage.URI(), fileEndpoints["1.sst"])
+	require.Equal(t, endpoints[1].Storage.URI(), fileEndpoints["3.sst"])
+	// the locations of older BR have only the absolute URIs.
+	locations.Endpoints[0].RelativeURI = ""
+	fileEndpoints, err = locations.FileEndpoints("file:///moved")
+	require.NoError(t, err)
+	require.Equal(t, endpoints[0].Storage.URI(), fileEndpoints["1.sst"])
+	require.Equal(t, "file:///moved", fileEndpoints["3.sst"])
+
+	require.Nil(t, (&Client{}).FileLocations())
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"sync"
+
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/rtree"
+)
+
+// heatmapCollector collects the data backed up in each range reported by TiKV, which are
+// merged into the buckets of the heatmap once the backup finishes.
+type heatmapCollector struct {
+	maxBuckets int
+
+	mu     sync.Mutex
+	ranges []metautil.HeatmapBucket
+}
+
+// EnableHeatmap collects the distribution of the backed up data over the key space, which
+// is merged into at most maxBuckets buckets by Heatmap.
+func (bc *Client) EnableHeatmap(maxBuckets int) {
+	bc.heatmap = &heatmapCollector{maxBuckets: maxBuckets}
+}
+
+// add collects the data of the range, the keys are those of its files, i.e. in the format
+// of backupmeta. The range without files is ignored.
+func (c *heatmapCollector) add(r *rtree.Range) {
+	if c == nil || len(r.Files) == 0 {
+		return
+	}
+	bucket := metautil.HeatmapBucket{
+		StartKey: r.Files[0].StartKey,
+		EndKey:   r.Files[len(r.Files)-1].EndKey,
+		Ranges:   1,
+	}
+	for _, f := range r.Files {
+		bucket.TotalKvs += f.TotalKvs
+		bucket.TotalBytes += f.TotalBytes
+	}
+	c.mu.Lock()
+	c.ranges = append(c.ranges, bucket)
+	c.mu.Unlock()
+}
+
+// Heatmap returns the heatmap of the data backed up, or nil if it's not enabled by EnableHeatmap.
+func (bc *Client) Heatmap() *metautil.Heatmap {
+	c := bc.heatmap
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return metautil.NewHeatmap(c.ranges, c.maxBuckets)
+}
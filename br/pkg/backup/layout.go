@@ -0,0 +1,139 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// The variables of the file layout, e.g. "{cluster_id}/{date}/{range_sn}".
+const (
+	LayoutClusterID = "{cluster_id}"
+	// LayoutDate is the UTC date of the backup, see SetFileLayout.
+	LayoutDate = "{date}"
+	// LayoutRangeSN is the serial number of the range in the ranges backed up.
+	LayoutRangeSN = "{range_sn}"
+
+	layoutDateFormat = "2006-01-02"
+)
+
+var fileLayoutVar = regexp.MustCompile(`\{[a-zA-Z_]+\}`)
+
+// expandFileLayout expands the layout into the prefix of the files of a range, which is
+// relative to the storage of the backup.
+func expandFileLayout(layout string, clusterID uint64, date time.Time, rangeSN int) (string, error) {
+	var err error
+	expanded := fileLayoutVar.ReplaceAllStringFunc(layout, func(v string) string {
+		switch v {
+		case LayoutClusterID:
+			return strconv.FormatUint(clusterID, 10)
+		case LayoutDate:
+			return date.UTC().Format(layoutDateFormat)
+		case LayoutRangeSN:
+			return strconv.Itoa(rangeSN)
+		default:
+			err = errors.Annotatef(berrors.ErrInvalidArgument, "unknown variable %s of the file layout, it should be one of %s",
+				v, strings.Join([]string{LayoutClusterID, LayoutDate, LayoutRangeSN}, ", "))
+			return v
+		}
+	})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	prefix := path.Clean(expanded)
+	if path.IsAbs(prefix) || prefix == ".." || strings.HasPrefix(prefix, "../") {
+		return "", errors.Annotatef(berrors.ErrInvalidArgument,
+			"the file layout %s must be a relative path inside the storage", layout)
+	}
+	if prefix == "." {
+		return "", nil
+	}
+	return prefix, nil
+}
+
+// CheckFileLayout checks the template of the file layout.
+func CheckFileLayout(layout string) error {
+	_, err := expandFileLayout(layout, 0, time.Time{}, 0)
+	return errors.Trace(err)
+}
+
+// SetFileLayout lays out the data files of each range under the prefix expanded from the
+// layout, so that a bucket holding the backups of many clusters can be managed by prefixes,
+// e.g. the lifecycle rules. The names of the files in the backupmeta are relative to the
+// storage, so the restore is unaware of the layout. The date is that of the backup ts, or of
+// the start of the backup, which must be kept when resuming. It must be called after the storage
+// is set.
+func (bc *Client) SetFileLayout(layout string, date time.Time) error {
+	if err := CheckFileLayout(layout); err != nil {
+		return errors.Trace(err)
+	}
+	backends := []*backuppb.StorageBackend{bc.backend}
+	if bc.failover != nil {
+		for _, endpoint := range bc.failover.storage.Endpoints() {
+			backends = append(backends, endpoint.Backend)
+		}
+	}
+	for _, backend := range backends {
+		if _, err := storage.BackendWithPrefix(backend, LayoutRangeSN); err != nil {
+			return errors.Annotate(err, "the storage doesn't support the file layout")
+		}
+	}
+	bc.fileLayout, bc.layoutDate = layout, date
+	return nil
+}
+
+// filePrefix returns the prefix of the files of the range, empty if there's no layout.
+func (bc *Client) filePrefix(rangeSN int) (string, error) {
+	if len(bc.fileLayout) == 0 {
+		return "", nil
+	}
+	prefix, err := expandFileLayout(bc.fileLayout, bc.clusterID.get(), bc.layoutDate, rangeSN)
+	return prefix, errors.Trace(err)
+}
+
+type filePrefixKey struct{}
+
+// contextWithFilePrefix makes the requests of the range write to the prefix of the storage.
+func contextWithFilePrefix(ctx context.Context, prefix string) context.Context {
+	if len(prefix) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, filePrefixKey{}, prefix)
+}
+
+func filePrefixFromContext(ctx context.Context) string {
+	prefix, _ := ctx.Value(filePrefixKey{}).(string)
+	return prefix
+}
+
+// prefixFiles makes the names of the files written to the prefix relative to the storage.
+func prefixFiles(prefix string, files []*backuppb.File) {
+	if len(prefix) == 0 {
+		return
+	}
+	for _, f := range files {
+		f.Name = path.Join(prefix, f.Name)
+	}
+}
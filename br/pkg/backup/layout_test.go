@@ -0,0 +1,76 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+func TestExpandFileLayout(t *testing.T) {
+	date := time.Date(2022, 6, 1, 23, 0, 0, 0, time.FixedZone("UTC-8", -8*3600))
+	prefix, err := expandFileLayout("{cluster_id}/{date}/{range_sn}/", 7, date, 3)
+	require.NoError(t, err)
+	require.Equal(t, "7/2022-06-02/3", prefix)
+
+	prefix, err = expandFileLayout("data/./", 7, date, 3)
+	require.NoError(t, err)
+	require.Equal(t, "data", prefix)
+	prefix, err = expandFileLayout("./", 7, date, 3)
+	require.NoError(t, err)
+	require.Empty(t, prefix)
+
+	for _, layout := range []string{"{cluster}/{date}", "/{cluster_id}", "../{cluster_id}", "{date}/../.."} {
+		_, err = expandFileLayout(layout, 7, date, 3)
+		require.ErrorIs(t, err, berrors.ErrInvalidArgument, layout)
+		require.Error(t, CheckFileLayout(layout), layout)
+	}
+	require.NoError(t, CheckFileLayout("{cluster_id}/{date}/{range_sn}"))
+}
+
+func TestFileLayout(t *testing.T) {
+	ctx := context.Background()
+	local := &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_Local{Local: &backuppb.Local{Path: "/tmp/backup"}}}
+	bc := &Client{backend: local, clusterID: newClusterIDVerifier(7, nil)}
+
+	// no layout, the files are at the root of the storage.
+	prefix, err := bc.filePrefix(3)
+	require.NoError(t, err)
+	require.Empty(t, prefix)
+	_, backend := bc.storageBackend(contextWithFilePrefix(ctx, prefix))
+	require.Same(t, local, backend)
+
+	require.NoError(t, bc.SetFileLayout("{cluster_id}/{date}/{range_sn}", time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)))
+	prefix, err = bc.filePrefix(3)
+	require.NoError(t, err)
+	require.Equal(t, "7/2022-06-01/3", prefix)
+	rangeCtx := contextWithFilePrefix(ctx, prefix)
+	_, backend = bc.storageBackend(rangeCtx)
+	require.Equal(t, "/tmp/backup/7/2022-06-01/3", backend.GetLocal().Path)
+
+	// the names of the files are relative to the storage.
+	files := []*backuppb.File{{Name: "1_2_default.sst"}}
+	prefixFiles(filePrefixFromContext(rangeCtx), files)
+	require.Equal(t, "7/2022-06-01/3/1_2_default.sst", files[0].Name)
+
+	require.Error(t, bc.SetFileLayout("{unknown}", time.Now()))
+	bc.backend = &backuppb.StorageBackend{}
+	require.Error(t, bc.SetFileLayout("{range_sn}", time.Now()))
+}
@@ -3,6 +3,7 @@
 package backup
 
 import (
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -15,6 +16,22 @@ var (
 			Help:      "Backup region statistic.",
 		}, []string{"type"})
 
+	backupBytesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "tikv_br",
+			Subsystem: "raw",
+			Name:      "backup_bytes",
+			Help:      "The bytes of the kvs backed up, before compressed.",
+		})
+
+	backupFineGrainedRounds = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "tikv_br",
+			Subsystem: "raw",
+			Name:      "backup_fine_grained_rounds",
+			Help:      "The rounds of retrying the incomplete regions one by one.",
+		})
+
 	backupRegionHistogram = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
 			Namespace: "tikv_br",
@@ -28,4 +45,14 @@ var (
 func init() { // nolint:gochecknoinits
 	prometheus.MustRegister(backupRegionCounters)
 	prometheus.MustRegister(backupRegionHistogram)
+	prometheus.MustRegister(backupBytesCounter)
+	prometheus.MustRegister(backupFineGrainedRounds)
+}
+
+// observeBackupFiles counts the region and the files backed up.
+func observeBackupFiles(files []*backuppb.File) {
+	backupRegionCounters.WithLabelValues("done").Inc()
+	for _, f := range files {
+		backupBytesCounter.Add(float64(f.GetTotalBytes()))
+	}
 }
@@ -0,0 +1,31 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObserveBackupFiles(t *testing.T) {
+	regions := testutil.ToFloat64(backupRegionCounters.WithLabelValues("done"))
+	bytes := testutil.ToFloat64(backupBytesCounter)
+	observeBackupFiles([]*backuppb.File{{TotalBytes: 10}, {TotalBytes: 20}})
+	require.Equal(t, regions+1, testutil.ToFloat64(backupRegionCounters.WithLabelValues("done")))
+	require.Equal(t, bytes+30, testutil.ToFloat64(backupBytesCounter))
+}
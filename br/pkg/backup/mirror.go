@@ -0,0 +1,55 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+
+	"github.com/google/btree"
+	"github.com/pingcap/errors"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// mirrorConcurrency is the number of files copied to the storage mirrors at the same time.
+const mirrorConcurrency = 8
+
+// SetMirrorStorage sets the storage of the backup to the primary endpoint and its mirrors.
+// TiKV writes the files to the primary, which are copied to the mirrors once a range is backed up.
+func (bc *Client) SetMirrorStorage(ctx context.Context, s *storage.MirrorStorage) error {
+	if err := bc.setStorage(ctx, s, s.Primary().Backend); err != nil {
+		return errors.Trace(err)
+	}
+	bc.mirror = s
+	return nil
+}
+
+// mirrorFiles copies the files of the backed up ranges to the storage mirrors.
+func (bc *Client) mirrorFiles(ctx context.Context, results *rtree.RangeTree) error {
+	if bc.mirror == nil {
+		return nil
+	}
+	var names []string
+	results.Ascend(func(i btree.Item) bool {
+		for _, f := range i.(*rtree.Range).Files {
+			names = append(names, f.Name)
+		}
+		return true
+	})
+	logutil.CL(ctx).Info("copy files to storage mirrors", zap.Int("files", len(names)))
+	return errors.Trace(bc.mirror.Mirror(ctx, names, mirrorConcurrency))
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/summary"
+	"go.uber.org/zap"
+)
+
+// phaseMirror copies the files of a range to the storage mirrors.
+const phaseMirror = "mirror"
+
+// rangePhases times the phases of the backup of a range, and collects them into the summary
+// under the range group named by the serial number of the range.
+type rangePhases struct {
+	group string
+
+	phase string
+	start time.Time
+	order []string
+	taken map[string]time.Duration
+}
+
+func newRangePhases(ctx context.Context) *rangePhases {
+	p := &rangePhases{taken: make(map[string]time.Duration)}
+	if sn, ok := logutil.RangeSNFromContext(ctx); ok {
+		p.group = fmt.Sprintf("range-%d", sn)
+	}
+	return p
+}
+
+// begin ends the current phase and begins the next one.
+func (p *rangePhases) begin(phase string) {
+	p.end()
+	p.phase = phase
+	p.start = time.Now()
+}
+
+// end ends the current phase, and collects the time spent in it.
+func (p *rangePhases) end() {
+	if len(p.phase) == 0 {
+		return
+	}
+	taken := time.Since(p.start)
+	if _, ok := p.taken[p.phase]; !ok {
+		p.order = append(p.order, p.phase)
+	}
+	p.taken[p.phase] += taken
+	summary.CollectPhase(p.group, p.phase, taken)
+	p.phase = ""
+}
+
+// fields returns the time spent in each phase for logging, it ends the current phase.
+func (p *rangePhases) fields() []zap.Field {
+	p.end()
+	fields := make([]zap.Field, 0, len(p.order))
+	for _, phase := range p.order {
+		fields = append(fields, zap.Duration(phase, p.taken[phase]))
+	}
+	return fields
+}
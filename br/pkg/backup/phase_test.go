@@ -0,0 +1,44 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/logutil"
+)
+
+func TestRangePhases(t *testing.T) {
+	p := newRangePhases(logutil.ContextWithRangeSN(context.Background(), 3))
+	require.Equal(t, "range-3", p.group)
+	// ending before any phase begins is a no-op.
+	p.end()
+	p.begin(PhasePushDown)
+	p.begin(PhaseFineGrained)
+	p.end()
+	p.begin(PhasePushDown)
+	p.begin(PhaseFineGrained)
+	fields := p.fields()
+	require.Len(t, fields, 2)
+	require.Equal(t, PhasePushDown, fields[0].Key)
+	require.Equal(t, PhaseFineGrained, fields[1].Key)
+	require.Empty(t, p.phase)
+
+	p = newRangePhases(context.Background())
+	require.Empty(t, p.group)
+	require.Empty(t, p.fields())
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/pingcap/errors"
@@ -14,9 +15,11 @@ import (
 	"github.com/pingcap/kvproto/pkg/errorpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/failpoints"
 	"github.com/tikv/migration/br/pkg/logutil"
 	"github.com/tikv/migration/br/pkg/redact"
 	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/summary"
 	"github.com/tikv/migration/br/pkg/utils"
 	"go.uber.org/zap"
 )
@@ -26,6 +29,16 @@ type pushDown struct {
 	mgr    ClientMgr
 	respCh chan responseAndStore
 	errCh  chan error
+
+	// checkpoint records the completed ranges if not nil.
+	checkpoint *checkpointer
+	// events sends the progress events if not nil.
+	events *eventEmitter
+	// clusterID re-verifies the cluster ID on the cluster ID errors if not nil.
+	clusterID *clusterIDVerifier
+	// failover tracks the storage endpoint the requests write to if not nil.
+	failover *storageFailover
+	endpoint int
 }
 
 type responseAndStore struct {
@@ -65,7 +78,7 @@ func (push *pushDown) pushBackup(
 
 	// Push down backup tasks to all tikv instances.
 	res := rtree.NewRangeTree()
-	failpoint.Inject("noop-backup", func(_ failpoint.Value) {
+	failpoint.Inject(failpoints.NoopBackup, func(_ failpoint.Value) {
 		logutil.CL(ctx).Warn("skipping normal backup, jump to fine-grained backup, meow :3", logutil.Key("start-key", req.StartKey), logutil.Key("end-key", req.EndKey))
 		failpoint.Return(res, nil)
 	})
@@ -79,6 +92,10 @@ func (push *pushDown) pushBackup(
 			logutil.CL(lctx).Warn("skip store", zap.Stringer("State", s.GetState()))
 			continue
 		}
+		if storeID == excludedStoreFromContext(ctx) {
+			logutil.CL(lctx).Info("skip the excluded store")
+			continue
+		}
 		client, err := push.mgr.GetBackupClient(lctx, storeID)
 		if err != nil {
 			// BR should be able to backup even some of stores disconnected.
@@ -89,7 +106,7 @@ func (push *pushDown) pushBackup(
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			failpoint.Inject("backup-storage-error", func(val failpoint.Value) {
+			failpoint.Inject(failpoints.BackupStorageError, func(val failpoint.Value) {
 				msg := val.(string)
 				logutil.CL(ctx).Debug("failpoint backup-storage-error injected.", zap.String("msg", msg))
 				resp := new(backuppb.BackupResponse)
@@ -101,7 +118,7 @@ func (push *pushDown) pushBackup(
 					Store: store,
 				}
 			})
-			failpoint.Inject("tikv-rw-error", func(val failpoint.Value) {
+			failpoint.Inject(failpoints.TiKVRWError, func(val failpoint.Value) {
 				msg := val.(string)
 				logutil.CL(ctx).Debug("failpoint tikv-rw-error injected.", zap.String("msg", msg))
 				resp := new(backuppb.BackupResponse)
@@ -113,7 +130,7 @@ func (push *pushDown) pushBackup(
 					Store: store,
 				}
 			})
-			failpoint.Inject("tikv-region-error", func(val failpoint.Value) {
+			failpoint.Inject(failpoints.TiKVRegionError, func(val failpoint.Value) {
 				msg := val.(string)
 				resp := new(backuppb.BackupResponse)
 				logutil.CL(ctx).Debug("failpoint tikv-region-error injected.", zap.String("msg", msg))
@@ -129,6 +146,7 @@ func (push *pushDown) pushBackup(
 					Store: store,
 				}
 			})
+			start := time.Now()
 			err := SendBackup(
 				lctx, storeID, client, req,
 				func(resp *backuppb.BackupResponse) error {
@@ -143,8 +161,10 @@ func (push *pushDown) pushBackup(
 					logutil.CL(lctx).Warn("reset the connection in push")
 					return push.mgr.ResetBackupClient(lctx, storeID)
 				})
+			summary.CollectStoreDuration(storeID, time.Since(start))
 			// Disconnected stores can be ignored.
 			if err != nil {
+				push.events.storeError(req.StartKey, req.EndKey, storeID, err)
 				push.errCh <- err
 				return
 			}
@@ -168,11 +188,18 @@ func (push *pushDown) pushBackup(
 			}
 			if resp.GetError() == nil {
 				// None error means range has been backuped successfully.
+				prefixFiles(filePrefixFromContext(ctx), resp.GetFiles())
 				res.Put(resp.GetStartKey(), resp.GetEndKey(), resp.GetFiles())
+				push.failover.recordFiles(push.endpoint, resp.GetFiles())
+				push.checkpoint.put(resp.GetStartKey(), resp.GetEndKey(), resp.GetFiles())
+				push.events.regionDone(resp.GetStartKey(), resp.GetEndKey(), store.GetId())
+				observeBackupFiles(resp.GetFiles())
 				// Update progress
 				progressCallBack(RegionUnit)
 			} else {
 				errPb := resp.GetError()
+				push.events.storeError(resp.GetStartKey(), resp.GetEndKey(), store.GetId(),
+					errors.Errorf("%v", errPb))
 				switch v := errPb.Detail.(type) {
 				case *backuppb.Error_KvError:
 					logutil.CL(ctx).Warn("backup occur kv error", zap.Reflect("error", v))
@@ -182,12 +209,22 @@ func (push *pushDown) pushBackup(
 
 				case *backuppb.Error_ClusterIdError:
 					logutil.CL(ctx).Error("backup occur cluster ID error", zap.Reflect("error", v))
-					return res, errors.Annotatef(berrors.ErrKVClusterIDMismatch, "%v", errPb)
+					if err := push.clusterID.verify(ctx, store.GetId(), v.ClusterIdError); err != nil {
+						return res, errors.Trace(err)
+					}
+					// the cluster ID is adopted, the range is retried by the fine grained backup.
 				default:
 					if utils.MessageIsRetryableStorageError(errPb.GetMsg()) {
 						logutil.CL(ctx).Warn("backup occur storage error", zap.String("error", errPb.GetMsg()))
+						push.failover.reportError(push.endpoint, errPb.GetMsg())
 						continue
 					}
+					if utils.MessageIsNoSpaceError(errPb.GetMsg()) {
+						// retrying only fails the other ranges the same way, fail early with the store.
+						return res, errors.Annotatef(berrors.ErrStorageNoSpace,
+							"store %v at %s has no space left to write the backup, please free up the space of the storage: %s",
+							store.GetId(), redact.String(store.GetAddress()), errPb.GetMsg())
+					}
 					if utils.MessageIsNotFoundStorageError(errPb.GetMsg()) {
 						errMsg := fmt.Sprintf("File or directory not found error occurs on TiKV Node(store id: %v; Address: %s)", store.GetId(), redact.String(store.GetAddress()))
 						logutil.CL(ctx).Error("", zap.String("error", berrors.ErrKVStorage.Error()+": "+errMsg),
@@ -81,6 +81,10 @@ func (mgr *mockBackupMgr) GetLockResolver() *txnlock.LockResolver {
 	return nil
 }
 
+func (mgr *mockBackupMgr) FetchClusterID(ctx context.Context) (uint64, error) {
+	return mgr.pdClient.GetClusterID(ctx), nil
+}
+
 func (mgr *mockBackupMgr) Close() {
 }
 
@@ -118,3 +122,49 @@ func TestBackupRaw(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, len(rgTree.GetIncompleteRange(testBackupStart, testBackupEnd)), 1)
 }
+
+func TestResponseChunks(t *testing.T) {
+	chunkOf := func(start, end string, files ...string) *backuppb.BackupResponse {
+		resp := &backuppb.BackupResponse{StartKey: []byte(start), EndKey: []byte(end)}
+		for _, name := range files {
+			resp.Files = append(resp.Files, &backuppb.File{Name: name})
+		}
+		return resp
+	}
+	namesOf := func(resp *backuppb.BackupResponse) []string {
+		names := make([]string, 0, len(resp.Files))
+		for _, f := range resp.Files {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+
+	chunks := responseChunks{}
+	require.Nil(t, chunks.add(chunkOf("a", "b", "1.sst")))
+	require.Nil(t, chunks.add(chunkOf("a", "b", "2.sst", "1.sst")))
+	resp := chunks.add(chunkOf("b", "c", "3.sst"))
+	require.Equal(t, []string{"1.sst", "2.sst"}, namesOf(resp))
+
+	// an error isn't merged with the chunks of the range.
+	errResp := chunkOf("b", "c")
+	errResp.Error = &backuppb.Error{Msg: "error"}
+	resp = chunks.add(errResp)
+	require.Equal(t, []string{"3.sst"}, namesOf(resp))
+	require.Equal(t, errResp, chunks.flush())
+	require.Nil(t, chunks.flush())
+
+	require.Nil(t, chunks.add(chunkOf("c", "d", "4.sst")))
+	chunks.reset()
+	require.Nil(t, chunks.flush())
+}
+
+func TestFineGrainedWorkers(t *testing.T) {
+	// few stores keep the minimum workers.
+	require.Equal(t, fineGrainedMinWorkers, fineGrainedWorkers(100, 1, DefaultFineGrainedMaxWorkers))
+	// the workers scale with the stores.
+	require.Equal(t, 10*fineGrainedWorkersPerStore, fineGrainedWorkers(100, 10, DefaultFineGrainedMaxWorkers))
+	// but no more than the cap and the incomplete ranges.
+	require.Equal(t, 8, fineGrainedWorkers(100, 10, 8))
+	require.Equal(t, 3, fineGrainedWorkers(3, 10, DefaultFineGrainedMaxWorkers))
+	require.Equal(t, 1, fineGrainedWorkers(1, 1, DefaultFineGrainedMaxWorkers))
+}
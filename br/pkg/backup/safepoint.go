@@ -0,0 +1,147 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/log"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/migration/br/pkg/pdutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// SafePointConflictStaleService is a service safe point of another service lagging behind
+	// the backup ts by more than StaleServiceSafePointLag, GC is blocked by it anyway.
+	SafePointConflictStaleService = "stale-service-safe-point"
+	// SafePointConflictNearGCSafePoint is the backup ts being older than the GC safe point,
+	// or ahead of it by less than the margin.
+	SafePointConflictNearGCSafePoint = "near-gc-safe-point"
+
+	// StaleServiceSafePointLag is the lag behind the backup ts a service safe point is
+	// reported beyond.
+	StaleServiceSafePointLag = time.Hour
+	// DefaultGCSafePointMargin is the default margin of the backup ts to the GC safe point.
+	DefaultGCSafePointMargin = time.Minute
+
+	// gcWorkerServiceID is the service safe point of the GC worker, which is the progress
+	// of GC rather than a service blocking it.
+	gcWorkerServiceID = "gc_worker"
+)
+
+// gcSafePointLister lists the safe points of the cluster, it's implemented by the PD
+// controller of conn.Mgr.
+type gcSafePointLister interface {
+	GetGCSafePoints(ctx context.Context) (*pdutil.GCSafePoints, error)
+}
+
+// SafePointConflict is a safe point of the cluster which may pile up GC or invalidate the
+// backup ts, found before the backup sets its service safe point.
+type SafePointConflict struct {
+	Kind string
+	// ServiceID is the service of the safe point, empty for the GC safe point.
+	ServiceID string
+	SafePoint uint64
+	// ExpiredAt is the unix time in seconds the service safe point expires at.
+	ExpiredAt int64
+	// Lag is how long the safe point lags behind the backup ts, negative if it's ahead.
+	Lag time.Duration
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (c SafePointConflict) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
+	encoder.AddString("kind", c.Kind)
+	if len(c.ServiceID) > 0 {
+		encoder.AddString("service-id", c.ServiceID)
+		encoder.AddString("expired-at", time.Unix(c.ExpiredAt, 0).String())
+	}
+	encoder.AddUint64("safe-point", c.SafePoint)
+	encoder.AddString("safe-point-time", oracle.GetTimeFromTS(c.SafePoint).String())
+	encoder.AddDuration("lag", c.Lag)
+	return nil
+}
+
+// FindSafePointConflicts finds the service safe points of the other services lagging far
+// behind the backup ts, and whether the backup ts is within the margin of the GC safe point.
+// The expired service safe points are ignored.
+func FindSafePointConflicts(
+	safePoints *pdutil.GCSafePoints,
+	backupTS uint64,
+	margin time.Duration,
+	now time.Time,
+) []SafePointConflict {
+	backupTime := oracle.GetTimeFromTS(backupTS)
+	lag := func(ts uint64) time.Duration {
+		return backupTime.Sub(oracle.GetTimeFromTS(ts))
+	}
+	var conflicts []SafePointConflict
+	if gcLag := lag(safePoints.GCSafePoint); backupTS <= safePoints.GCSafePoint || gcLag < margin {
+		conflicts = append(conflicts, SafePointConflict{
+			Kind:      SafePointConflictNearGCSafePoint,
+			SafePoint: safePoints.GCSafePoint,
+			Lag:       gcLag,
+		})
+	}
+	for _, sp := range safePoints.ServiceSafePoints {
+		if sp.ServiceID == gcWorkerServiceID || sp.ExpiredAt < now.Unix() {
+			continue
+		}
+		if spLag := lag(sp.SafePoint); spLag > StaleServiceSafePointLag {
+			conflicts = append(conflicts, SafePointConflict{
+				Kind:      SafePointConflictStaleService,
+				ServiceID: sp.ServiceID,
+				SafePoint: sp.SafePoint,
+				ExpiredAt: sp.ExpiredAt,
+				Lag:       spLag,
+			})
+		}
+	}
+	return conflicts
+}
+
+// SetGCSafePointMargin sets the margin of the backup ts to the GC safe point, the safe
+// points are checked before the service safe point is set, and the backup ts closer to
+// the GC safe point is reported. The check is skipped if it's not positive.
+func (bc *Client) SetGCSafePointMargin(margin time.Duration) {
+	bc.gcSafePointMargin = margin
+}
+
+// reportSafePointConflicts logs the safe points which may pile up GC or invalidate the
+// backup ts. It's best effort, the failure of listing the safe points is only logged.
+func (bc *Client) reportSafePointConflicts(ctx context.Context, backupTS uint64) {
+	lister, ok := bc.mgr.(gcSafePointLister)
+	if !ok || bc.gcSafePointMargin <= 0 {
+		return
+	}
+	safePoints, err := lister.GetGCSafePoints(ctx)
+	if err != nil {
+		log.Warn("failed to list the safe points, skip checking the conflicts", zap.Error(err))
+		return
+	}
+	for _, c := range FindSafePointConflicts(safePoints, backupTS, bc.gcSafePointMargin, time.Now()) {
+		switch c.Kind {
+		case SafePointConflictStaleService:
+			log.Warn("another service pins a much older ts, GC is blocked by it during the backup",
+				zap.Uint64("backup-ts", backupTS), zap.Object("conflict", c))
+		case SafePointConflictNearGCSafePoint:
+			log.Warn("the backup ts is close to the GC safe point, the backup may fail if GC advances",
+				zap.Uint64("backup-ts", backupTS), zap.Duration("margin", bc.gcSafePointMargin),
+				zap.Object("conflict", c))
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/migration/br/pkg/backup"
+	"github.com/tikv/migration/br/pkg/pdutil"
+)
+
+func TestFindSafePointConflicts(t *testing.T) {
+	now := time.Now()
+	ts := func(ago time.Duration) uint64 {
+		return oracle.GoTimeToTS(now.Add(-ago))
+	}
+	backupTS := ts(time.Minute)
+	safePoints := &pdutil.GCSafePoints{
+		GCSafePoint: ts(10 * time.Minute),
+		ServiceSafePoints: []pdutil.ServiceSafePoint{
+			{ServiceID: "gc_worker", ExpiredAt: now.Add(time.Hour).Unix(), SafePoint: ts(3 * time.Hour)},
+			{ServiceID: "ticdc", ExpiredAt: now.Add(time.Hour).Unix(), SafePoint: ts(2 * time.Hour)},
+			{ServiceID: "expired", ExpiredAt: now.Add(-time.Hour).Unix(), SafePoint: ts(2 * time.Hour)},
+			{ServiceID: "recent", ExpiredAt: now.Add(time.Hour).Unix(), SafePoint: ts(30 * time.Minute)},
+		},
+	}
+
+	conflicts := backup.FindSafePointConflicts(safePoints, backupTS, time.Minute, now)
+	require.Len(t, conflicts, 1)
+	require.Equal(t, backup.SafePointConflictStaleService, conflicts[0].Kind)
+	require.Equal(t, "ticdc", conflicts[0].ServiceID)
+	require.InDelta(t, float64(119*time.Minute), float64(conflicts[0].Lag), float64(time.Second))
+
+	// the backup ts is within the margin of the GC safe point.
+	conflicts = backup.FindSafePointConflicts(safePoints, backupTS, 15*time.Minute, now)
+	require.Len(t, conflicts, 2)
+	require.Equal(t, backup.SafePointConflictNearGCSafePoint, conflicts[0].Kind)
+	require.Equal(t, safePoints.GCSafePoint, conflicts[0].SafePoint)
+
+	// the backup ts is older than the GC safe point.
+	conflicts = backup.FindSafePointConflicts(safePoints, ts(20*time.Minute), 0, now)
+	require.Len(t, conflicts, 2)
+	require.Equal(t, backup.SafePointConflictNearGCSafePoint, conflicts[0].Kind)
+	require.Less(t, conflicts[0].Lag, time.Duration(0))
+}
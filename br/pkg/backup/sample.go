@@ -0,0 +1,185 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/google/btree"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/client-go/v2/util/codec"
+	"github.com/tikv/migration/br/pkg/conn"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"go.uber.org/zap"
+)
+
+// sampleScanBatch is the batch size of scanning regions for sampling.
+const sampleScanBatch = 1024
+
+// CompressionSample is the result of backing up the sampled regions with a compression type.
+type CompressionSample struct {
+	CompressionType backuppb.CompressionType
+	// TotalKvs and TotalBytes are the size of the sampled key-value pairs before compression.
+	TotalKvs   uint64
+	TotalBytes uint64
+	// Size is the size of the SST files after compression.
+	Size     uint64
+	Duration time.Duration
+}
+
+// Ratio returns the compression ratio, i.e. the size before compression divided by the size after it.
+func (s *CompressionSample) Ratio() float64 {
+	if s.Size == 0 {
+		return 0
+	}
+	return float64(s.TotalBytes) / float64(s.Size)
+}
+
+// Speed returns the backup speed of the sample in bytes per second, before compression.
+func (s *CompressionSample) Speed() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.TotalBytes) / s.Duration.Seconds()
+}
+
+// pickEvenly picks at most n indexes evenly from [0, total).
+func pickEvenly(total, n int) []int {
+	if n <= 0 || total <= 0 {
+		return nil
+	}
+	if n > total {
+		n = total
+	}
+	picked := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		picked = append(picked, i*total/n)
+	}
+	return picked
+}
+
+// SampleRegions picks at most n regions evenly in [startKey, endKey), and returns
+// their key ranges clipped by [startKey, endKey).
+func (bc *Client) SampleRegions(ctx context.Context, startKey, endKey []byte, n int) ([]rtree.Range, error) {
+	// Keys are saved in encoded format in TiKV for API V2.
+	encodeKey := bc.curAPIVer == kvrpcpb.APIVersion_V2
+	scanStart, scanEnd := startKey, endKey
+	if encodeKey {
+		scanStart = codec.EncodeBytes(nil, startKey)
+		if len(endKey) > 0 {
+			scanEnd = codec.EncodeBytes(nil, endKey)
+		}
+	}
+
+	var regions []rtree.Range
+	for key := scanStart; ; {
+		batch, err := bc.mgr.GetPDClient().ScanRegions(ctx, key, scanEnd, sampleScanBatch)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, region := range batch {
+			regions = append(regions, rtree.Range{StartKey: region.Meta.StartKey, EndKey: region.Meta.EndKey})
+		}
+		if len(batch) == 0 {
+			break
+		}
+		key = batch[len(batch)-1].Meta.EndKey
+		if len(key) == 0 || (len(scanEnd) > 0 && bytes.Compare(key, scanEnd) >= 0) {
+			break
+		}
+	}
+
+	sampled := make([]rtree.Range, 0, n)
+	for _, i := range pickEvenly(len(regions), n) {
+		rg := regions[i]
+		if bytes.Compare(rg.StartKey, scanStart) < 0 {
+			rg.StartKey = scanStart
+		}
+		if len(scanEnd) > 0 && (len(rg.EndKey) == 0 || bytes.Compare(rg.EndKey, scanEnd) > 0) {
+			rg.EndKey = scanEnd
+		}
+		if encodeKey {
+			var err error
+			if _, rg.StartKey, err = codec.DecodeBytes(rg.StartKey, nil); err != nil {
+				return nil, errors.Trace(err)
+			}
+			if len(rg.EndKey) > 0 {
+				if _, rg.EndKey, err = codec.DecodeBytes(rg.EndKey, nil); err != nil {
+					return nil, errors.Trace(err)
+				}
+			}
+		}
+		sampled = append(sampled, rg)
+	}
+	logutil.CL(ctx).Info("sampled regions", zap.Int("total", len(regions)), zap.Int("sampled", len(sampled)))
+	return sampled, nil
+}
+
+// SampleCompression backs up the ranges with each of the compression types, and
+// reports the achieved ratios and speeds. The SST files are discarded by the noop
+// storage, and the incomplete regions are not retried because it's only an estimation.
+func (bc *Client) SampleCompression(
+	ctx context.Context,
+	ranges []rtree.Range,
+	req backuppb.BackupRequest,
+	compressionTypes []backuppb.CompressionType,
+) ([]CompressionSample, error) {
+	allStores, err := conn.GetAllTiKVStoresWithRetry(ctx, bc.mgr.GetPDClient(), conn.SkipTiFlash)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.StorageBackend = &backuppb.StorageBackend{
+		Backend: &backuppb.StorageBackend_Noop{Noop: &backuppb.Noop{}},
+	}
+	bc.applyDynamicSettings(&req)
+
+	samples := make([]CompressionSample, 0, len(compressionTypes))
+	for _, tp := range compressionTypes {
+		sample := CompressionSample{CompressionType: tp}
+		req.CompressionType = tp
+		start := time.Now()
+		for _, rg := range ranges {
+			req.StartKey = rg.StartKey
+			req.EndKey = rg.EndKey
+			push := newPushDown(bc.mgr, len(allStores))
+			results, err := push.pushBackup(ctx, req, allStores, func(ProgressUnit) {})
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			results.Ascend(func(i btree.Item) bool {
+				for _, f := range i.(*rtree.Range).Files {
+					sample.TotalKvs += f.TotalKvs
+					sample.TotalBytes += f.TotalBytes
+					sample.Size += f.Size_
+				}
+				return true
+			})
+		}
+		sample.Duration = time.Since(start)
+		logutil.CL(ctx).Info("compression sampled",
+			zap.Stringer("compression-type", tp),
+			zap.Uint64("total-bytes", sample.TotalBytes),
+			zap.Uint64("size", sample.Size),
+			zap.Float64("ratio", sample.Ratio()),
+			zap.Duration("take", sample.Duration))
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPickEvenly(t *testing.T) {
+	require.Nil(t, pickEvenly(10, 0))
+	require.Nil(t, pickEvenly(0, 3))
+	require.Equal(t, []int{0, 1, 2}, pickEvenly(3, 5))
+	require.Equal(t, []int{0, 3, 6}, pickEvenly(10, 3))
+	require.Equal(t, []int{0, 25, 50, 75}, pickEvenly(100, 4))
+}
+
+func TestCompressionSample(t *testing.T) {
+	sample := CompressionSample{TotalBytes: 1000, Size: 250, Duration: 2 * time.Second}
+	require.Equal(t, 4.0, sample.Ratio())
+	require.Equal(t, 500.0, sample.Speed())
+
+	sample = CompressionSample{}
+	require.Equal(t, 0.0, sample.Ratio())
+	require.Equal(t, 0.0, sample.Speed())
+}
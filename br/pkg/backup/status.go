@@ -0,0 +1,318 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// StatusFile is the file recording the progress of a backup, it's updated periodically
+	// while the backup runs and kept after the backup ends, so that it can be queried by
+	// `br show status` from another machine.
+	StatusFile = "backup.status.json"
+	// StatusEtcdPrefix is the prefix of the keys in the etcd of PD the status is published under.
+	StatusEtcdPrefix = "/tikv/br/backup/status/"
+
+	// statusEtcdTTL is the TTL of the status published to PD, which is renewed on every update.
+	statusEtcdTTL = 7 * 24 * time.Hour
+	// statusStaleIntervals is the number of the update intervals without any update, after
+	// which a running backup is considered crashed.
+	statusStaleIntervals = 3
+	// statusPutTimeout is the timeout of publishing the status to a sink.
+	statusPutTimeout = 10 * time.Second
+)
+
+// The states of a backup in Status.
+const (
+	StatusRunning  = "running"
+	StatusFinished = "finished"
+	StatusFailed   = "failed"
+)
+
+// Status is the progress of a backup.
+type Status struct {
+	// Storage is the storage backed up to, without the query parameters.
+	Storage string `json:"storage"`
+	Command string `json:"command"`
+	// Host and PID are the process running the backup.
+	Host  string `json:"host"`
+	PID   int    `json:"pid"`
+	State string `json:"state"`
+	// Error is the error the backup fails with.
+	Error      string    `json:"error,omitempty"`
+	StartTime  time.Time `json:"start-time"`
+	UpdateTime time.Time `json:"update-time"`
+	// UpdateInterval is the interval the status is updated at.
+	UpdateInterval time.Duration `json:"update-interval"`
+
+	TotalRegions     int64  `json:"total-regions"`
+	CompletedRegions int64  `json:"completed-regions"`
+	TotalRanges      int64  `json:"total-ranges"`
+	CompletedRanges  int64  `json:"completed-ranges"`
+	BackedUpBytes    uint64 `json:"backed-up-bytes"`
+	// ETA is the remaining time estimated by the speed of the completed regions, 0 if unknown.
+	ETA time.Duration `json:"eta"`
+}
+
+// Stale returns whether the backup is running but not updated for a few intervals,
+// i.e. the process is likely crashed or stuck.
+func (s *Status) Stale(now time.Time) bool {
+	return s.State == StatusRunning && s.UpdateInterval > 0 &&
+		now.Sub(s.UpdateTime) > statusStaleIntervals*s.UpdateInterval
+}
+
+// estimate updates the ETA by the speed of the completed regions so far.
+func (s *Status) estimate() {
+	s.ETA = 0
+	elapsed := s.UpdateTime.Sub(s.StartTime)
+	if s.CompletedRegions <= 0 || elapsed <= 0 {
+		return
+	}
+	remaining := s.TotalRegions - s.CompletedRegions
+	if remaining <= 0 {
+		return
+	}
+	s.ETA = time.Duration(float64(elapsed) / float64(s.CompletedRegions) * float64(remaining)).Round(time.Second)
+}
+
+// StatusEtcdKey returns the key in the etcd of PD the status of the backup to the storage is published under.
+func StatusEtcdKey(storageURL string) string {
+	sum := sha256.Sum256([]byte(storageURL))
+	return StatusEtcdPrefix + hex.EncodeToString(sum[:8])
+}
+
+// ReadStatus reads the status of the backup in the storage, it returns nil if there is none.
+func ReadStatus(ctx context.Context, s storage.ExternalStorage) (*Status, error) {
+	exists, err := s.FileExists(ctx, StatusFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, StatusFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	status := &Status{}
+	if err = json.Unmarshal(data, status); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", StatusFile, err)
+	}
+	return status, nil
+}
+
+// ReadEtcdStatuses reads the status of the backups published to the etcd of PD.
+func ReadEtcdStatuses(ctx context.Context, cli *clientv3.Client) ([]*Status, error) {
+	resp, err := cli.Get(ctx, StatusEtcdPrefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	statuses := make([]*Status, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		status := &Status{}
+		if err = json.Unmarshal(kv.Value, status); err != nil {
+			log.Warn("skip the invalid backup status", zap.ByteString("key", kv.Key), zap.Error(err))
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// StatusSink is where the status of the backup is published to.
+type StatusSink interface {
+	PutStatus(ctx context.Context, status *Status) error
+}
+
+type storageStatusSink struct {
+	storage storage.ExternalStorage
+}
+
+// NewStorageStatusSink returns a sink writing the status into StatusFile of the storage.
+func NewStorageStatusSink(s storage.ExternalStorage) StatusSink {
+	return &storageStatusSink{storage: s}
+}
+
+// PutStatus implements StatusSink.
+func (s *storageStatusSink) PutStatus(ctx context.Context, status *Status) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.storage.WriteFile(ctx, StatusFile, data))
+}
+
+type etcdStatusSink struct {
+	cli   *clientv3.Client
+	lease clientv3.LeaseID
+}
+
+// NewEtcdStatusSink returns a sink publishing the status into the etcd of PD under StatusEtcdKey.
+// The status expires a week after the last update.
+func NewEtcdStatusSink(cli *clientv3.Client) StatusSink {
+	return &etcdStatusSink{cli: cli}
+}
+
+// PutStatus implements StatusSink.
+func (s *etcdStatusSink) PutStatus(ctx context.Context, status *Status) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if s.lease == clientv3.NoLease {
+		resp, err := s.cli.Grant(ctx, int64(statusEtcdTTL.Seconds()))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		s.lease = resp.ID
+	} else if _, err = s.cli.KeepAliveOnce(ctx, s.lease); err != nil {
+		// the lease may be expired, e.g. PD is unavailable for long, grant another one next time.
+		s.lease = clientv3.NoLease
+		return errors.Trace(err)
+	}
+	_, err = s.cli.Put(ctx, StatusEtcdKey(status.Storage), string(data), clientv3.WithLease(s.lease))
+	return errors.Trace(err)
+}
+
+// statusReporter counts the progress of the backup and publishes it periodically.
+type statusReporter struct {
+	sinks []StatusSink
+
+	mu     sync.Mutex
+	status Status
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newStatusReporter(status Status, interval time.Duration, sinks []StatusSink) *statusReporter {
+	now := time.Now()
+	status.Host, _ = os.Hostname()
+	status.PID = os.Getpid()
+	status.State = StatusRunning
+	status.StartTime = now
+	status.UpdateTime = now
+	status.UpdateInterval = interval
+	return &statusReporter{status: status, sinks: sinks}
+}
+
+// wrapProgress counts the progress besides calling progressCallBack.
+func (r *statusReporter) wrapProgress(progressCallBack func(ProgressUnit)) func(ProgressUnit) {
+	if r == nil {
+		return progressCallBack
+	}
+	return func(unit ProgressUnit) {
+		r.mu.Lock()
+		if unit == RangeUnit {
+			r.status.CompletedRanges++
+		} else {
+			r.status.CompletedRegions++
+		}
+		r.mu.Unlock()
+		progressCallBack(unit)
+	}
+}
+
+func (r *statusReporter) addBytes(n uint64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.BackedUpBytes += n
+}
+
+func (r *statusReporter) snapshot() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.UpdateTime = time.Now()
+	r.status.estimate()
+	return r.status
+}
+
+// flush publishes the status to all sinks, a failed sink doesn't stop the others.
+func (r *statusReporter) flush(ctx context.Context) {
+	status := r.snapshot()
+	for _, sink := range r.sinks {
+		putCtx, cancel := context.WithTimeout(ctx, statusPutTimeout)
+		if err := sink.PutStatus(putCtx, &status); err != nil {
+			log.Warn("failed to publish the backup status", zap.Error(err))
+		}
+		cancel()
+	}
+}
+
+func (r *statusReporter) run(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.done = make(chan struct{})
+	r.flush(ctx)
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.status.UpdateInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.flush(ctx)
+			}
+		}
+	}()
+}
+
+// stop stops publishing periodically, and publishes the final state by the error of the backup.
+func (r *statusReporter) stop(backupErr error) {
+	r.cancel()
+	<-r.done
+	r.mu.Lock()
+	if backupErr != nil {
+		r.status.State = StatusFailed
+		r.status.Error = backupErr.Error()
+	} else {
+		r.status.State = StatusFinished
+	}
+	r.mu.Unlock()
+	r.flush(context.Background())
+}
+
+// StartStatus publishes the progress of the backup to the sinks every interval, the storage,
+// the command and the totals of status are filled by the caller.
+func (bc *Client) StartStatus(ctx context.Context, status Status, interval time.Duration, sinks ...StatusSink) {
+	bc.status = newStatusReporter(status, interval, sinks)
+	bc.status.run(ctx)
+}
+
+// StopStatus stops publishing the progress, and publishes the final state of the backup,
+// which failed if backupErr is not nil.
+func (bc *Client) StopStatus(backupErr error) {
+	if bc.status == nil {
+		return
+	}
+	r := bc.status
+	bc.status = nil
+	r.stop(backupErr)
+}
@@ -0,0 +1,107 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestStatus(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	bc := &Client{storage: s}
+
+	status, err := ReadStatus(ctx, s)
+	require.NoError(t, err)
+	require.Nil(t, status)
+
+	bc.StartStatus(ctx, Status{Storage: "local:///backup", Command: "Raw backup", TotalRegions: 4, TotalRanges: 1},
+		time.Hour, NewStorageStatusSink(s))
+	// the status is published once started.
+	status, err = ReadStatus(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, StatusRunning, status.State)
+	require.Equal(t, os.Getpid(), status.PID)
+	require.Equal(t, int64(4), status.TotalRegions)
+	require.Zero(t, status.CompletedRegions)
+
+	regions := 0
+	progress := bc.status.wrapProgress(func(unit ProgressUnit) {
+		if unit == RegionUnit {
+			regions++
+		}
+	})
+	progress(RegionUnit)
+	progress(RegionUnit)
+	progress(RangeUnit)
+	bc.status.addBytes(1024)
+	require.Equal(t, 2, regions)
+	bc.StopStatus(nil)
+
+	status, err = ReadStatus(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, StatusFinished, status.State)
+	require.Equal(t, int64(2), status.CompletedRegions)
+	require.Equal(t, int64(1), status.CompletedRanges)
+	require.Equal(t, uint64(1024), status.BackedUpBytes)
+	require.Equal(t, time.Hour, status.UpdateInterval)
+
+	bc.StartStatus(ctx, Status{Storage: "local:///backup"}, time.Hour, NewStorageStatusSink(s))
+	bc.StopStatus(errors.New("store 1 is down"))
+	status, err = ReadStatus(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, StatusFailed, status.State)
+	require.Equal(t, "store 1 is down", status.Error)
+	// stopping again is a no-op.
+	bc.StopStatus(nil)
+}
+
+func TestStatusEstimate(t *testing.T) {
+	start := time.Now()
+	status := &Status{
+		State:            StatusRunning,
+		StartTime:        start,
+		UpdateTime:       start.Add(time.Minute),
+		UpdateInterval:   10 * time.Second,
+		TotalRegions:     100,
+		CompletedRegions: 25,
+	}
+	status.estimate()
+	require.Equal(t, 3*time.Minute, status.ETA)
+	status.CompletedRegions = 0
+	status.estimate()
+	require.Zero(t, status.ETA)
+
+	require.False(t, status.Stale(status.UpdateTime.Add(30*time.Second)))
+	require.True(t, status.Stale(status.UpdateTime.Add(31*time.Second)))
+	// a finished backup is never stale.
+	status.State = StatusFinished
+	require.False(t, status.Stale(status.UpdateTime.Add(time.Hour)))
+}
+
+func TestStatusEtcdKey(t *testing.T) {
+	key := StatusEtcdKey("s3://bucket/backup")
+	require.Regexp(t, "^"+StatusEtcdPrefix+"[0-9a-f]{16}$", key)
+	require.Equal(t, key, StatusEtcdKey("s3://bucket/backup"))
+	require.NotEqual(t, key, StatusEtcdKey("s3://bucket/backup2"))
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"go.uber.org/zap"
+)
+
+// storeSafeTSTimeout is how long GetUnsafeTS waits for a store to report its safe-ts.
+const storeSafeTSTimeout = 10 * time.Second
+
+// StoreSafeTSGetter gets the safe-ts reported by a store.
+type StoreSafeTSGetter interface {
+	GetStoreSafeTS(ctx context.Context, storeID uint64) (uint64, error)
+}
+
+// GetUnsafeTS derives the backup ts from the safe-ts reported by the stores, for the disasters
+// in which the TSO of PD is unavailable. It's the max safe-ts of the stores rather than their max
+// commit ts, which the stores don't report, so the writes before it which are still unresolved on
+// the lagging stores, or on the unreachable ones, may be missed by the backup. The caveats are
+// returned along with the ts.
+func GetUnsafeTS(ctx context.Context, stores []*metapb.Store, getter StoreSafeTSGetter) (*metautil.UnsafeTS, error) {
+	reported := make([]metautil.UnsafeTSStore, 0, len(stores))
+	for _, store := range stores {
+		r := metautil.UnsafeTSStore{StoreID: store.GetId(), Address: store.GetAddress()}
+		storeCtx, cancel := context.WithTimeout(ctx, storeSafeTSTimeout)
+		safeTS, err := getter.GetStoreSafeTS(storeCtx, store.GetId())
+		cancel()
+		if err != nil {
+			log.Warn("failed to get the safe-ts of the store", zap.Uint64("store-id", store.GetId()),
+				zap.String("address", store.GetAddress()), zap.Error(err))
+			r.Error = err.Error()
+		} else {
+			r.SafeTS = safeTS
+		}
+		reported = append(reported, r)
+	}
+	return deriveUnsafeTS(reported)
+}
+
+// deriveUnsafeTS picks the max safe-ts reported by the stores, and explains what may be missed.
+func deriveUnsafeTS(stores []metautil.UnsafeTSStore) (*metautil.UnsafeTS, error) {
+	u := &metautil.UnsafeTS{Stores: stores}
+	var minTS uint64
+	var unreachable []uint64
+	for _, s := range stores {
+		if len(s.Error) > 0 || s.SafeTS == 0 {
+			unreachable = append(unreachable, s.StoreID)
+			continue
+		}
+		if s.SafeTS > u.BackupTS {
+			u.BackupTS = s.SafeTS
+		}
+		if minTS == 0 || s.SafeTS < minTS {
+			minTS = s.SafeTS
+		}
+	}
+	if u.BackupTS == 0 {
+		return nil, errors.Annotatef(berrors.ErrBackupUnsafeTSUnavailable,
+			"none of the %d stores reports its safe-ts, the backup ts can't be derived", len(stores))
+	}
+	u.Caveats = append(u.Caveats, fmt.Sprintf(
+		"the backup ts %d is the max safe-ts reported by the stores instead of a TSO allocated by PD", u.BackupTS))
+	if minTS < u.BackupTS {
+		u.Caveats = append(u.Caveats, fmt.Sprintf(
+			"the safe-ts of the stores lags behind down to %d, the writes between it and the backup ts "+
+				"which are unresolved on the lagging stores may be missed", minTS))
+	}
+	if len(unreachable) > 0 {
+		u.Caveats = append(u.Caveats, fmt.Sprintf(
+			"the stores %v don't report their safe-ts, the writes on them may be missed", unreachable))
+	}
+	return u, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+type fakeSafeTSGetter map[uint64]uint64
+
+func (g fakeSafeTSGetter) GetStoreSafeTS(_ context.Context, storeID uint64) (uint64, error) {
+	safeTS, ok := g[storeID]
+	if !ok {
+		return 0, errors.Errorf("store %d is unreachable", storeID)
+	}
+	return safeTS, nil
+}
+
+func TestGetUnsafeTS(t *testing.T) {
+	ctx := context.Background()
+	stores := []*metapb.Store{
+		{Id: 1, Address: "tikv-0:20160"},
+		{Id: 2, Address: "tikv-1:20160"},
+		{Id: 3, Address: "tikv-2:20160"},
+	}
+
+	u, err := GetUnsafeTS(ctx, stores, fakeSafeTSGetter{1: 100, 2: 100, 3: 100})
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), u.BackupTS)
+	require.Len(t, u.Stores, 3)
+	require.Len(t, u.Caveats, 1)
+
+	u, err = GetUnsafeTS(ctx, stores, fakeSafeTSGetter{1: 100, 2: 90})
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), u.BackupTS)
+	require.Equal(t, "store 3 is unreachable", u.Stores[2].Error)
+	require.Len(t, u.Caveats, 3)
+	require.Contains(t, u.Caveats[1], "lags behind down to 90")
+	require.Contains(t, u.Caveats[2], "the stores [3] don't report their safe-ts")
+
+	_, err = GetUnsafeTS(ctx, stores, fakeSafeTSGetter{1: 0})
+	require.True(t, berrors.ErrBackupUnsafeTSUnavailable.Equal(err))
+}
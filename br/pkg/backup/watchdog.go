@@ -0,0 +1,77 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// SummaryStuckRanges is the summary key of the number of the stuck backup streams.
+const SummaryStuckRanges = "stuck ranges"
+
+type stuckTimeoutKey struct{}
+
+// contextWithStuckTimeout sets the timeout of a backup stream receiving no response,
+// after which the stream is canceled and the range is dispatched again.
+func contextWithStuckTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	if timeout <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, stuckTimeoutKey{}, timeout)
+}
+
+func stuckTimeoutFromContext(ctx context.Context) time.Duration {
+	timeout, _ := ctx.Value(stuckTimeoutKey{}).(time.Duration)
+	return timeout
+}
+
+// streamWatchdog cancels a backup stream if it receives no response within the timeout.
+type streamWatchdog struct {
+	timeout time.Duration
+	timer   *time.Timer
+	fired   int32
+}
+
+// newStreamWatchdog starts a watchdog calling cancel on timeout, it never fires if timeout is 0.
+func newStreamWatchdog(timeout time.Duration, cancel context.CancelFunc) *streamWatchdog {
+	w := &streamWatchdog{timeout: timeout}
+	if timeout > 0 {
+		w.timer = time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&w.fired, 1)
+			cancel()
+		})
+	}
+	return w
+}
+
+// feed postpones the timeout after a response is received.
+func (w *streamWatchdog) feed() {
+	if w.timer != nil && atomic.LoadInt32(&w.fired) == 0 {
+		w.timer.Reset(w.timeout)
+	}
+}
+
+func (w *streamWatchdog) stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+// hasFired tells whether the stream is canceled by the watchdog.
+func (w *streamWatchdog) hasFired() bool {
+	return atomic.LoadInt32(&w.fired) == 1
+}
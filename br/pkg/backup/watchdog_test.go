@@ -0,0 +1,120 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStreamWatchdog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := newStreamWatchdog(50*time.Millisecond, cancel)
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		w.feed()
+	}
+	require.False(t, w.hasFired())
+	<-ctx.Done()
+	require.True(t, w.hasFired())
+
+	// a disabled watchdog never fires.
+	w = newStreamWatchdog(0, nil)
+	w.feed()
+	w.stop()
+	require.False(t, w.hasFired())
+
+	require.Equal(t, time.Duration(0), stuckTimeoutFromContext(context.Background()))
+	require.Equal(t, time.Minute, stuckTimeoutFromContext(contextWithStuckTimeout(context.Background(), time.Minute)))
+}
+
+// hungBackupStream hangs until the stream is canceled if hung is set.
+type hungBackupStream struct {
+	grpc.ClientStream
+	ctx  context.Context
+	hung bool
+	sent bool
+}
+
+func (s *hungBackupStream) Recv() (*backuppb.BackupResponse, error) {
+	if s.hung {
+		<-s.ctx.Done()
+		return nil, status.Error(codes.Canceled, s.ctx.Err().Error())
+	}
+	if s.sent {
+		return nil, io.EOF
+	}
+	s.sent = true
+	return &backuppb.BackupResponse{StartKey: []byte("a"), EndKey: []byte("b")}, nil
+}
+
+func (s *hungBackupStream) CloseSend() error {
+	return nil
+}
+
+type hungBackupClient struct {
+	// hungStreams is the number of the first streams which hang.
+	hungStreams int
+	streams     int
+	lastReq     backuppb.BackupRequest
+}
+
+func (c *hungBackupClient) Backup(ctx context.Context, req *backuppb.BackupRequest, _ ...grpc.CallOption) (backuppb.Backup_BackupClient, error) {
+	c.streams++
+	c.lastReq = *req
+	return &hungBackupStream{ctx: ctx, hung: c.streams <= c.hungStreams}, nil
+}
+
+func TestSendBackupRedispatchStuckRange(t *testing.T) {
+	ctx := contextWithStuckTimeout(context.Background(), 50*time.Millisecond)
+	client := &hungBackupClient{hungStreams: 2}
+	responses := 0
+	err := SendBackup(ctx, 1, client, backuppb.BackupRequest{},
+		func(*backuppb.BackupResponse) error {
+			responses++
+			return nil
+		},
+		func() (backuppb.BackupClient, error) {
+			return client, nil
+		})
+	require.NoError(t, err)
+	require.Equal(t, 3, client.streams)
+	require.Equal(t, 1, responses)
+}
+
+func TestSendBackupTakesLatestSettings(t *testing.T) {
+	settings := utils.NewDynamicSettings(utils.TaskSettings{RateLimit: 10, Concurrency: 4})
+	ctx := contextWithStuckTimeout(context.Background(), 50*time.Millisecond)
+	ctx = contextWithDynamicSettings(ctx, settings)
+	client := &hungBackupClient{hungStreams: 1}
+	req := backuppb.BackupRequest{RateLimit: 10, Concurrency: 4}
+	settings.Store(utils.TaskSettings{RateLimit: 20, Concurrency: 8})
+	err := SendBackup(ctx, 1, client, req,
+		func(*backuppb.BackupResponse) error { return nil },
+		func() (backuppb.BackupClient, error) { return client, nil })
+	require.NoError(t, err)
+	require.Equal(t, 2, client.streams)
+	require.Equal(t, uint64(20), client.lastReq.RateLimit)
+	require.Equal(t, uint32(8), client.lastReq.Concurrency)
+}
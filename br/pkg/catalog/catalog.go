@@ -0,0 +1,185 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package catalog maintains the index of the backups, so that a backup can be
+// referenced by its name instead of the full storage URI.
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// FileName is the name of the catalog file under the catalog storage.
+const FileName = "catalog.json"
+
+// The status of the backups in the catalog, the same as those of backup.Status.
+const (
+	StatusRunning  = "running"
+	StatusFinished = "finished"
+	StatusFailed   = "failed"
+)
+
+// validName is the name of a backup, which may have several segments separated by '/', e.g. the
+// path of the backup under the catalog storage.
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,127}(/[a-zA-Z0-9][a-zA-Z0-9._-]{0,127})*$`)
+
+// ValidateName checks whether the name can be used as a backup name.
+func ValidateName(name string) error {
+	if !validName.MatchString(name) || len(name) > 1024 {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"invalid backup name '%s', only letters, digits, '.', '_', '-' and '/' between them are allowed", name)
+	}
+	return nil
+}
+
+// Entry is a backup recorded in the catalog.
+type Entry struct {
+	Name string `json:"name"`
+	// Storage is the URI of the backup storage, without the credentials.
+	Storage string `json:"storage"`
+	// StorageTemplate is the template Storage is expanded from, if any.
+	StorageTemplate string    `json:"storage-template,omitempty"`
+	CreatedAt       time.Time `json:"created-at"`
+	ClusterID       uint64    `json:"cluster-id"`
+	APIVersion      string    `json:"api-version"`
+	// BackupTS is the snapshot ts of the backup, 0 if the backup is not at a fixed ts.
+	BackupTS uint64 `json:"backup-ts,omitempty"`
+	// Size is the size of the backup files in bytes.
+	Size uint64 `json:"size"`
+	// Status is the status of the backup, the entries recorded by older BR are finished if it's empty.
+	Status    string    `json:"status,omitempty"`
+	UpdatedAt time.Time `json:"updated-at"`
+	// LastRestoredAt and LastRestoreStatus are of the last restore of the backup, if any.
+	LastRestoredAt    *time.Time `json:"last-restored-at,omitempty"`
+	LastRestoreStatus string     `json:"last-restore-status,omitempty"`
+}
+
+// GetStatus returns the status of the backup.
+func (e *Entry) GetStatus() string {
+	if len(e.Status) == 0 {
+		return StatusFinished
+	}
+	return e.Status
+}
+
+// Catalog is the index of the backups by name.
+type Catalog struct {
+	Entries []Entry `json:"entries"`
+
+	// fileName is FileName with the suffix of the compression of the catalog, if any.
+	fileName string
+}
+
+// Load reads the catalog from the storage. An empty catalog is returned if it does not exist yet.
+// The catalog may be compressed, i.e. FileName with the suffix of a compression type.
+func Load(ctx context.Context, s storage.ExternalStorage) (*Catalog, error) {
+	name, exists, err := storage.FindCompressed(ctx, s, FileName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	c := &Catalog{}
+	if !exists {
+		return c, nil
+	}
+	data, err := storage.WithSuffixCompression(s).ReadFile(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse catalog %s: %v", name, err)
+	}
+	c.fileName = name
+	return c, nil
+}
+
+// SetCompression sets the compression of the catalog if it does not exist yet, an existing
+// catalog keeps its compression.
+func (c *Catalog) SetCompression(tp storage.CompressType) {
+	if len(c.fileName) == 0 {
+		c.fileName = FileName + tp.Suffix()
+	}
+}
+
+// Save writes the catalog to the storage.
+func (c *Catalog) Save(ctx context.Context, s storage.ExternalStorage) error {
+	sort.Slice(c.Entries, func(i, j int) bool {
+		return c.Entries[i].Name < c.Entries[j].Name
+	})
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	name := c.fileName
+	if len(name) == 0 {
+		name = FileName
+	}
+	return errors.Trace(storage.WithSuffixCompression(s).WriteFile(ctx, name, data))
+}
+
+// Lookup returns the entry with the name.
+func (c *Catalog) Lookup(name string) (*Entry, bool) {
+	for i := range c.Entries {
+		if c.Entries[i].Name == name {
+			return &c.Entries[i], true
+		}
+	}
+	return nil, false
+}
+
+// Add adds the entry into the catalog. It fails if the name is used by another backup.
+func (c *Catalog) Add(entry Entry) error {
+	if err := ValidateName(entry.Name); err != nil {
+		return errors.Trace(err)
+	}
+	if old, ok := c.Lookup(entry.Name); ok {
+		if old.Storage != entry.Storage {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"backup name '%s' is already used by %s", entry.Name, old.Storage)
+		}
+		// the same backup is taken again, refresh the entry.
+		*old = entry
+		return nil
+	}
+	c.Entries = append(c.Entries, entry)
+	return nil
+}
+
+// LookupStorage returns the entry of the backup in the storage URI without the credentials.
+func (c *Catalog) LookupStorage(storage string) (*Entry, bool) {
+	for i := range c.Entries {
+		if c.Entries[i].Storage == storage {
+			return &c.Entries[i], true
+		}
+	}
+	return nil, false
+}
+
+// Remove removes the entry with the name, and returns whether it exists.
+func (c *Catalog) Remove(name string) bool {
+	for i := range c.Entries {
+		if c.Entries[i].Name == name {
+			c.Entries = append(c.Entries[:i], c.Entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
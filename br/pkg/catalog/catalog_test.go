@@ -0,0 +1,104 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/catalog"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestCatalog(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	c, err := catalog.Load(ctx, s)
+	require.NoError(t, err)
+	require.Empty(t, c.Entries)
+
+	require.NoError(t, c.Add(catalog.Entry{Name: "prod-daily-2024-06-01", Storage: "s3://bucket/daily/0601"}))
+	require.NoError(t, c.Add(catalog.Entry{Name: "prod-daily-2024-05-31", Storage: "s3://bucket/daily/0531"}))
+	require.Error(t, c.Add(catalog.Entry{Name: "prod-daily-2024-06-01", Storage: "s3://bucket/other"}))
+	require.NoError(t, c.Add(catalog.Entry{Name: "prod-daily-2024-06-01", Storage: "s3://bucket/daily/0601", Size: 10}))
+	require.Error(t, c.Add(catalog.Entry{Name: "../escape", Storage: "local:///tmp"}))
+	require.NoError(t, c.Save(ctx, s))
+
+	c, err = catalog.Load(ctx, s)
+	require.NoError(t, err)
+	require.Len(t, c.Entries, 2)
+	require.Equal(t, "prod-daily-2024-05-31", c.Entries[0].Name)
+	entry, ok := c.Lookup("prod-daily-2024-06-01")
+	require.True(t, ok)
+	require.Equal(t, "s3://bucket/daily/0601", entry.Storage)
+	require.Equal(t, uint64(10), entry.Size)
+
+	require.True(t, c.Remove("prod-daily-2024-05-31"))
+	require.False(t, c.Remove("prod-daily-2024-05-31"))
+	_, ok = c.Lookup("prod-daily-2024-05-31")
+	require.False(t, ok)
+}
+
+func TestCompressedCatalog(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	c, err := catalog.Load(ctx, s)
+	require.NoError(t, err)
+	c.SetCompression(storage.Gzip)
+	require.NoError(t, c.Add(catalog.Entry{Name: "daily", Storage: "s3://bucket/daily"}))
+	require.NoError(t, c.Save(ctx, s))
+	exists, err := s.FileExists(ctx, catalog.FileName+".gz")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// the existing catalog keeps its compression.
+	c, err = catalog.Load(ctx, s)
+	require.NoError(t, err)
+	c.SetCompression(storage.Zstd)
+	require.NoError(t, c.Add(catalog.Entry{Name: "weekly", Storage: "s3://bucket/weekly"}))
+	require.NoError(t, c.Save(ctx, s))
+	exists, err = s.FileExists(ctx, catalog.FileName+".zst")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	c, err = catalog.Load(ctx, s)
+	require.NoError(t, err)
+	require.Len(t, c.Entries, 2)
+}
+
+func TestEntryStatus(t *testing.T) {
+	require.NoError(t, catalog.ValidateName("fleet/app-1/daily"))
+	for _, name := range []string{"fleet/../app", "fleet//app", "/fleet", "fleet/"} {
+		require.Error(t, catalog.ValidateName(name), name)
+	}
+
+	c := &catalog.Catalog{}
+	require.NoError(t, c.Add(catalog.Entry{Name: "old", Storage: "s3://bucket/old"}))
+	require.NoError(t, c.Add(catalog.Entry{Name: "fleet/app-1", Storage: "s3://bucket/fleet/app-1", Status: catalog.StatusRunning}))
+	entry, ok := c.LookupStorage("s3://bucket/old")
+	require.True(t, ok)
+	// the entries recorded by older BR are finished.
+	require.Equal(t, catalog.StatusFinished, entry.GetStatus())
+	entry, ok = c.LookupStorage("s3://bucket/fleet/app-1")
+	require.True(t, ok)
+	require.Equal(t, catalog.StatusRunning, entry.GetStatus())
+	_, ok = c.LookupStorage("s3://bucket/other")
+	require.False(t, ok)
+}
@@ -25,8 +25,10 @@ import (
 	"github.com/tikv/client-go/v2/config"
 	"github.com/tikv/client-go/v2/rawkv"
 	"github.com/tikv/migration/br/pkg/backup"
+	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/gluetikv"
 	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/redact"
 	"github.com/tikv/migration/br/pkg/utils"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
@@ -38,6 +40,21 @@ func UpdateChecksum(c *rawkv.RawChecksum, crc64Xor, totalKvs, totalBytes uint64)
 	c.TotalBytes += totalBytes
 }
 
+// ConvertChecksum returns the checksum of the kvs of a backup in srcAPIVersion after their keys
+// are converted into dstAPIVersion. The Crc64Xor can't be converted, because the crc64 of each
+// kv depends on the length of its value, so it's left unchanged and shouldn't be verified.
+func ConvertChecksum(c rawkv.RawChecksum, srcAPIVersion, dstAPIVersion kvrpcpb.APIVersion) rawkv.RawChecksum {
+	if srcAPIVersion == dstAPIVersion {
+		return c
+	}
+	if dstAPIVersion == kvrpcpb.APIVersion_V2 {
+		c.TotalBytes += c.TotalKvs * uint64(utils.APIV2KeyPrefixLen)
+	} else if srcAPIVersion == kvrpcpb.APIVersion_V2 {
+		c.TotalBytes -= c.TotalKvs * uint64(utils.APIV2KeyPrefixLen)
+	}
+	return c
+}
+
 type StorageChecksumMethod int32
 
 const (
@@ -57,6 +74,8 @@ type Executor struct {
 	apiVersion     kvrpcpb.APIVersion
 	checksumClient Client
 	concurrency    uint
+	// ignoreCrc64Xor verifies the total kvs and bytes only.
+	ignoreCrc64Xor bool
 }
 
 // NewExecutorBuilder returns a new executor builder.
@@ -79,18 +98,43 @@ func NewExecutor(ctx context.Context, keyRanges []*utils.KeyRange, pdAddrs []str
 	}, nil
 }
 
+// IgnoreCrc64Xor makes the executor verify the total kvs and bytes only, e.g. when the expected
+// checksum is converted by ConvertChecksum.
+func (exec *Executor) IgnoreCrc64Xor() {
+	exec.ignoreCrc64Xor = true
+}
+
+// match returns whether the checksum of the storage matches the expected one.
+func (exec *Executor) match(expect, storage rawkv.RawChecksum) bool {
+	if exec.ignoreCrc64Xor {
+		storage.Crc64Xor = expect.Crc64Xor
+	}
+	return expect == storage
+}
+
 const (
 	MaxScanCntLimit = 1024 // limited by grpc message size
 )
 
-// doScanChecksumOnRange scan all key/value in keyRange and format it to apiv2 format, then calc checksum
-// ATTENTION: just support call this func in apiv1/v1ttl store.
+// doScanChecksumOnRange scans the kvs in keyRange, and calculates their checksum as if the keys
+// are in the other api version: the keys of an apiv1/v1ttl store are formatted into apiv2, e.g. to
+// verify the apiv2 backup of it, and the keys of an apiv2 store are stripped of the apiv2 prefix,
+// e.g. to verify the restore of an apiv1/v1ttl backup into it. The keyRange is in the format of the
+// store. The scanned values are the user values, without the ttl and the flags of apiv1ttl/apiv2,
+// which are excluded from the checksums of the backup files as well.
 func (exec *Executor) doScanChecksumOnRange(
 	ctx context.Context,
 	keyRange *utils.KeyRange,
 ) (rawkv.RawChecksum, error) {
-	if exec.apiVersion != kvrpcpb.APIVersion_V1 && exec.apiVersion != kvrpcpb.APIVersion_V1TTL {
-		return rawkv.RawChecksum{}, errors.New("only support scan checksum on apiv1/v1ttl")
+	formatKey := func(key []byte) []byte { return utils.FormatAPIV2Key(key, false) }
+	switch exec.apiVersion {
+	case kvrpcpb.APIVersion_V1, kvrpcpb.APIVersion_V1TTL:
+	case kvrpcpb.APIVersion_V2:
+		// rawkv client accept user key without prefix, which is the key in apiv1 format.
+		keyRange = utils.ConvertBackupConfigKeyRange(keyRange.Start, keyRange.End, kvrpcpb.APIVersion_V2, kvrpcpb.APIVersion_V1)
+		formatKey = func(key []byte) []byte { return key }
+	default:
+		return rawkv.RawChecksum{}, errors.Errorf("unsupported api version %s of scan checksum", exec.apiVersion)
 	}
 	curStart := keyRange.Start
 	checksum := rawkv.RawChecksum{}
@@ -101,7 +145,7 @@ func (exec *Executor) doScanChecksumOnRange(
 			return rawkv.RawChecksum{}, err
 		}
 		for i, key := range keys {
-			newKey := utils.FormatAPIV2Key(key, false)
+			newKey := formatKey(key)
 			// keep the same with tikv-server: https://docs.rs/crc64fast/latest/crc64fast/
 			digest.Reset()
 			digest.Write(newKey)
@@ -130,6 +174,21 @@ func (exec *Executor) doChecksumOnRange(
 	return exec.checksumClient.Checksum(ctx, keyRange.Start, keyRange.End)
 }
 
+func (exec *Executor) checksumRange(
+	ctx context.Context,
+	keyRange *utils.KeyRange,
+	method StorageChecksumMethod,
+) (rawkv.RawChecksum, error) {
+	switch method {
+	case StorageChecksumCommand:
+		return exec.doChecksumOnRange(ctx, keyRange)
+	case StorageScanCommand:
+		return exec.doScanChecksumOnRange(ctx, keyRange)
+	default:
+		return rawkv.RawChecksum{}, errors.New("unsupported checksum method")
+	}
+}
+
 // Execute executes a checksum executor.
 func (exec *Executor) Execute(
 	ctx context.Context,
@@ -144,15 +203,7 @@ func (exec *Executor) Execute(
 	for _, r := range exec.keyRanges {
 		keyRange := r // copy to another variable in case it's overwritten
 		workerPool.ApplyOnErrorGroup(eg, func() error {
-			var err error
-			var ret rawkv.RawChecksum
-			if method == StorageChecksumCommand {
-				ret, err = exec.doChecksumOnRange(ectx, keyRange)
-			} else if method == StorageScanCommand {
-				ret, err = exec.doScanChecksumOnRange(ectx, keyRange)
-			} else {
-				err = errors.New("unsupported checksum method")
-			}
+			ret, err := exec.checksumRange(ectx, keyRange, method)
 			if err != nil {
 				// The error due to context cancel, stack trace is meaningless, the stack shall be suspended (also clear)
 				if errors.Cause(err) == context.Canceled {
@@ -175,7 +226,7 @@ func (exec *Executor) Execute(
 	if err != nil {
 		return err
 	}
-	if expect != storageChecksum {
+	if !exec.match(expect, storageChecksum) {
 		logutil.CL(ctx).Error("checksum fails", zap.Reflect("backup files checksum", expect),
 			zap.Reflect("storage checksum", storageChecksum), zap.Int("range cnt", len(exec.keyRanges)))
 		return errors.New("Checksum mismatch")
@@ -183,6 +234,55 @@ func (exec *Executor) Execute(
 	return nil
 }
 
+// ExecuteEachRange verifies the checksum of each range against expects, which are in the
+// same order as the ranges. Unlike Execute, it locates the mismatched ranges.
+func (exec *Executor) ExecuteEachRange(
+	ctx context.Context,
+	expects []rawkv.RawChecksum,
+	method StorageChecksumMethod,
+	progressCallBack func(backup.ProgressUnit),
+) error {
+	if len(expects) != len(exec.keyRanges) {
+		return errors.Errorf("%d expected checksums for %d ranges", len(expects), len(exec.keyRanges))
+	}
+	var mismatched []*utils.KeyRange
+	lock := sync.Mutex{}
+	workerPool := utils.NewWorkerPool(exec.concurrency, "Ranges")
+	eg, ectx := errgroup.WithContext(ctx)
+	for i, r := range exec.keyRanges {
+		keyRange, expect := r, expects[i]
+		workerPool.ApplyOnErrorGroup(eg, func() error {
+			ret, err := exec.checksumRange(ectx, keyRange, method)
+			if err != nil {
+				if errors.Cause(err) == context.Canceled {
+					return errors.SuspendStack(err)
+				}
+				return errors.Trace(err)
+			}
+			if !exec.match(expect, ret) {
+				logutil.CL(ctx).Error("range checksum mismatch",
+					logutil.Key("StartKey", keyRange.Start),
+					logutil.Key("EndKey", keyRange.End),
+					zap.Reflect("backup files checksum", expect),
+					zap.Reflect("storage checksum", ret))
+				lock.Lock()
+				mismatched = append(mismatched, keyRange)
+				lock.Unlock()
+			}
+			progressCallBack(backup.RangeUnit)
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	if len(mismatched) > 0 {
+		return errors.Annotatef(berrors.ErrBackupChecksumMismatch, "%d of %d ranges mismatch, e.g. [%s, %s)",
+			len(mismatched), len(exec.keyRanges), redact.Key(mismatched[0].Start), redact.Key(mismatched[0].End))
+	}
+	return nil
+}
+
 func (exec *Executor) Close() {
 	if exec.checksumClient != nil {
 		exec.checksumClient.Close()
@@ -208,3 +308,24 @@ func Run(ctx context.Context, cmdName string,
 	}
 	return nil
 }
+
+// RunEachRange is the same as Run, but verifies the checksum of each range against expects.
+func RunEachRange(ctx context.Context, cmdName string,
+	executor *Executor, method StorageChecksumMethod, expects []rawkv.RawChecksum) error {
+	if executor.apiVersion != kvrpcpb.APIVersion_V1 {
+		fmt.Printf("\033[1;37;41m%s\033[0m\n", "Warning: TiKV cluster is TTL enabled, checksum may be mismatch if some data expired during backup/restore.")
+	}
+	glue := new(gluetikv.Glue)
+	updateCh := glue.StartProgress(ctx, cmdName+" Verify", int64(len(executor.keyRanges)), false)
+	progressCallBack := func(unit backup.ProgressUnit) {
+		updateCh.Inc()
+	}
+	err := executor.ExecuteEachRange(ctx, expects, method, progressCallBack)
+	updateCh.Close()
+	if err != nil {
+		fmt.Printf("%s succeeded, but range verification failed, err:%v.\n",
+			cmdName, errors.Cause(err))
+		return errors.Trace(err)
+	}
+	return nil
+}
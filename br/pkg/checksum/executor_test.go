@@ -27,6 +27,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/tikv/client-go/v2/rawkv"
 	"github.com/tikv/migration/br/pkg/backup"
+	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/utils"
 )
 
@@ -179,3 +180,124 @@ func TestChecksumExcutor(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, callbackCnt, rangeCnt)
 }
+
+func TestChecksumExecutorEachRange(t *testing.T) {
+	ctx := context.TODO()
+	client := mockChecksumClient{
+		store: make(map[string]string),
+	}
+	keyCnt := int64(1024)
+	// the ranges are of different sizes, ranges of the same size may share
+	// the same checksum since the crc64 of the generated keys is xored.
+	bounds := []int64{0, 100, 300, 600, keyCnt}
+	rangeCnt := int64(len(bounds) - 1)
+	keys, values := batchGenerateData(keyCnt)
+	client.PutBatch(ctx, keys, values)
+
+	keyRanges := []*utils.KeyRange{}
+	expects := []rawkv.RawChecksum{}
+	for i := int64(0); i < rangeCnt; i++ {
+		start, _ := generateTestData(bounds[i])
+		end, _ := generateTestData(bounds[i+1])
+		keyRanges = append(keyRanges, &utils.KeyRange{
+			Start: []byte(start),
+			End:   []byte(end),
+		})
+		expect, err := client.Checksum(ctx, []byte(start), []byte(end))
+		require.Nil(t, err)
+		expects = append(expects, expect)
+	}
+	executor := Executor{
+		keyRanges:      keyRanges,
+		apiVersion:     kvrpcpb.APIVersion_V1,
+		checksumClient: &client,
+		concurrency:    2,
+	}
+	callbackCnt := int64(0)
+	callback := func(unit backup.ProgressUnit) {
+		atomic.AddInt64(&callbackCnt, 1)
+	}
+	err := executor.ExecuteEachRange(ctx, expects, StorageChecksumCommand, callback)
+	require.Nil(t, err)
+	require.Equal(t, rangeCnt, callbackCnt)
+
+	// the totals match, but two ranges are swapped.
+	expects[0], expects[1] = expects[1], expects[0]
+	err = executor.ExecuteEachRange(ctx, expects, StorageChecksumCommand, callback)
+	require.True(t, berrors.Is(err, berrors.ErrBackupChecksumMismatch))
+
+	err = executor.ExecuteEachRange(ctx, expects[:1], StorageChecksumCommand, callback)
+	require.Error(t, err)
+}
+
+func TestChecksumExecutorConvert(t *testing.T) {
+	ctx := context.TODO()
+	client := mockChecksumClient{
+		store: make(map[string]string),
+	}
+	// the crc64 of an even number of kvs of the same length may be xored to 0.
+	keys, values := batchGenerateData(1023)
+	client.PutBatch(ctx, keys, values)
+	rawChecksum, err := client.Checksum(ctx, []byte("a"), []byte("z"))
+	require.Nil(t, err)
+
+	require.Equal(t, rawChecksum, ConvertChecksum(rawChecksum, kvrpcpb.APIVersion_V1, kvrpcpb.APIVersion_V1))
+	converted := ConvertChecksum(rawChecksum, kvrpcpb.APIVersion_V1, kvrpcpb.APIVersion_V2)
+	require.Equal(t, rawChecksum.TotalKvs, converted.TotalKvs)
+	require.Equal(t, rawChecksum.TotalBytes+4*rawChecksum.TotalKvs, converted.TotalBytes)
+	require.Equal(t, rawChecksum, ConvertChecksum(converted, kvrpcpb.APIVersion_V2, kvrpcpb.APIVersion_V1))
+
+	// the scan checksum is computed over the keys in API V2 format.
+	executor := Executor{
+		keyRanges:      []*utils.KeyRange{{Start: []byte("a"), End: []byte("z")}},
+		apiVersion:     kvrpcpb.APIVersion_V1,
+		checksumClient: &client,
+		concurrency:    1,
+	}
+	callback := func(unit backup.ProgressUnit) {}
+	err = executor.Execute(ctx, converted, StorageScanCommand, callback)
+	require.Error(t, err)
+	executor.IgnoreCrc64Xor()
+	err = executor.Execute(ctx, converted, StorageScanCommand, callback)
+	require.Nil(t, err)
+
+	converted.TotalKvs++
+	err = executor.Execute(ctx, converted, StorageScanCommand, callback)
+	require.Error(t, err)
+}
+
+func TestChecksumExecutorScanAPIV2(t *testing.T) {
+	ctx := context.TODO()
+	// the rawkv client of an apiv2 store reads the user keys without the apiv2 prefix.
+	client := mockChecksumClient{
+		store: make(map[string]string),
+	}
+	keys, values := batchGenerateData(2047)
+	client.PutBatch(ctx, keys, values)
+	// the checksum of an apiv1 backup, which is restored into the apiv2 store.
+	expect, err := client.Checksum(ctx, []byte("a"), []byte("z"))
+	require.Nil(t, err)
+
+	split, _ := generateTestData(1000)
+	executor := Executor{
+		keyRanges: []*utils.KeyRange{
+			utils.FormatAPIV2KeyRange([]byte("a"), []byte(split)),
+			utils.FormatAPIV2KeyRange([]byte(split), []byte("z")),
+		},
+		apiVersion:     kvrpcpb.APIVersion_V2,
+		checksumClient: &client,
+		concurrency:    2,
+	}
+	callback := func(unit backup.ProgressUnit) {}
+	// the crc64 is verified as well, the keys are the same as the ones of the backup.
+	err = executor.Execute(ctx, expect, StorageScanCommand, callback)
+	require.Nil(t, err)
+
+	expect.Crc64Xor ^= 1
+	err = executor.Execute(ctx, expect, StorageScanCommand, callback)
+	require.Error(t, err)
+
+	executor.apiVersion = kvrpcpb.APIVersion(-1)
+	_, err = executor.doScanChecksumOnRange(ctx, executor.keyRanges[0])
+	require.Error(t, err)
+}
@@ -0,0 +1,194 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+)
+
+// AddrMapping maps the addresses advertised by the stores to the ones reachable from BR,
+// e.g. when TiKV is behind NAT or in another network. A key is either the advertised
+// address or the ID of a store, the latter takes precedence.
+type AddrMapping map[string]string
+
+// LoadAddrMapping loads the mapping from a JSON file like
+// {"tikv-0.tikv:20160": "10.0.0.1:30160", "4": "10.0.0.2:30160"}.
+func LoadAddrMapping(path string) (AddrMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to read the address mapping file %s: %v", path, err)
+	}
+	m := AddrMapping{}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to parse the address mapping file %s: %v", path, err)
+	}
+	for from, to := range m {
+		if _, _, err = net.SplitHostPort(to); err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"the address '%s' mapped from '%s' must be host:port", to, from)
+		}
+	}
+	return m, nil
+}
+
+// mapAddr returns the address mapped from addr of the store, or addr if it isn't mapped.
+func (m AddrMapping) mapAddr(storeID uint64, addr string) string {
+	if to, ok := m[strconv.FormatUint(storeID, 10)]; ok {
+		return to
+	}
+	if to, ok := m[addr]; ok {
+		return to
+	}
+	return addr
+}
+
+// MapStore returns a copy of the store with the addresses mapped.
+func (m AddrMapping) MapStore(store *metapb.Store) *metapb.Store {
+	if store == nil || len(m) == 0 {
+		return store
+	}
+	mapped := proto.Clone(store).(*metapb.Store)
+	mapped.Address = m.mapAddr(store.GetId(), store.GetAddress())
+	if len(store.GetPeerAddress()) > 0 {
+		mapped.PeerAddress = m.mapAddr(store.GetId(), store.GetPeerAddress())
+	}
+	return mapped
+}
+
+// addrMappingPDClient maps the addresses of the stores returned by PD, so that all the
+// connections to the stores are dialed to the mapped addresses.
+type addrMappingPDClient struct {
+	pd.Client
+	mapping AddrMapping
+}
+
+func (c *addrMappingPDClient) GetStore(ctx context.Context, storeID uint64) (*metapb.Store, error) {
+	store, err := c.Client.GetStore(ctx, storeID)
+	return c.mapping.MapStore(store), err
+}
+
+func (c *addrMappingPDClient) GetAllStores(ctx context.Context, opts ...pd.GetStoreOption) ([]*metapb.Store, error) {
+	stores, err := c.Client.GetAllStores(ctx, opts...)
+	for i := range stores {
+		stores[i] = c.mapping.MapStore(stores[i])
+	}
+	return stores, err
+}
+
+// SetAddrMapping maps the addresses of the stores returned by the PD client of the Mgr.
+// It must be called before creating any client by the PD client.
+func (mgr *Mgr) SetAddrMapping(mapping AddrMapping) {
+	if len(mapping) == 0 {
+		return
+	}
+	mgr.SetPDClient(&addrMappingPDClient{Client: mgr.GetPDClient(), mapping: mapping})
+	log.Info("map the addresses of the stores", zap.Any("mapping", mapping))
+}
+
+// StoreConnectivity is the result of probing the address of a store from BR.
+type StoreConnectivity struct {
+	StoreID uint64
+	// Addr is the address BR dials to the store.
+	Addr    string
+	Latency time.Duration
+	// Err is the error of dialing to Addr, nil means the store is reachable.
+	Err error
+}
+
+// ProbeStores dials to the addresses of the stores by TCP concurrently, each dial is limited by timeout.
+func ProbeStores(ctx context.Context, stores []*metapb.Store, timeout time.Duration) []StoreConnectivity {
+	results := make([]StoreConnectivity, len(stores))
+	var wg sync.WaitGroup
+	for i, store := range stores {
+		addr := store.GetPeerAddress()
+		if addr == "" {
+			addr = store.GetAddress()
+		}
+		results[i] = StoreConnectivity{StoreID: store.GetId(), Addr: addr}
+		wg.Add(1)
+		go func(result *StoreConnectivity) {
+			defer wg.Done()
+			dialer := net.Dialer{Timeout: timeout}
+			start := time.Now()
+			conn, err := dialer.DialContext(ctx, "tcp", result.Addr)
+			result.Latency = time.Since(start)
+			if err != nil {
+				result.Err = err
+				return
+			}
+			_ = conn.Close()
+		}(&results[i])
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool { return results[i].StoreID < results[j].StoreID })
+	return results
+}
+
+// ProbeStores probes the addresses of all the TiKV stores, it returns an error with the
+// connectivity of each store if any of them is unreachable.
+func (mgr *Mgr) ProbeStores(ctx context.Context, timeout time.Duration) ([]StoreConnectivity, error) {
+	stores, err := GetAllTiKVStores(ctx, mgr.GetPDClient(), SkipTiFlash)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	results := ProbeStores(ctx, stores, timeout)
+	unreachable := 0
+	for _, r := range results {
+		if r.Err != nil {
+			unreachable++
+			log.Warn("the store is unreachable", zap.Uint64("store-id", r.StoreID), zap.String("addr", r.Addr), zap.Error(r.Err))
+		} else {
+			log.Info("the store is reachable", zap.Uint64("store-id", r.StoreID), zap.String("addr", r.Addr),
+				zap.Duration("latency", r.Latency))
+		}
+	}
+	if unreachable > 0 {
+		return results, errors.Annotatef(berrors.ErrFailedToConnect,
+			"%d of %d stores are unreachable from BR, their advertised addresses may not be reachable from here, "+
+				"e.g. TiKV is behind NAT, map them to reachable ones by an address mapping file:\n%s",
+			unreachable, len(results), FormatConnectivity(results))
+	}
+	return results, nil
+}
+
+// FormatConnectivity formats the connectivity of the stores as a table.
+func FormatConnectivity(results []StoreConnectivity) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-10s %-40s %s\n", "STORE", "ADDRESS", "STATUS")
+	for _, r := range results {
+		status := fmt.Sprintf("ok (%s)", r.Latency.Round(time.Millisecond))
+		if r.Err != nil {
+			status = "unreachable: " + r.Err.Error()
+		}
+		fmt.Fprintf(&b, "%-10d %-40s %s\n", r.StoreID, r.Addr, status)
+	}
+	return b.String()
+}
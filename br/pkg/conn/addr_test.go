@@ -0,0 +1,96 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/pdutil"
+)
+
+func TestLoadAddrMapping(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mapping.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"tikv-0.tikv:20160": "10.0.0.1:30160", "2": "10.0.0.2:30160"}`), 0o644))
+	mapping, err := LoadAddrMapping(path)
+	require.NoError(t, err)
+	require.Equal(t, AddrMapping{"tikv-0.tikv:20160": "10.0.0.1:30160", "2": "10.0.0.2:30160"}, mapping)
+
+	for _, content := range []string{`["10.0.0.1:30160"]`, `{"tikv-0.tikv:20160": "10.0.0.1"}`} {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		_, err = LoadAddrMapping(path)
+		require.True(t, berrors.Is(err, berrors.ErrInvalidArgument), content)
+	}
+	_, err = LoadAddrMapping(filepath.Join(dir, "missing.json"))
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
+
+func TestAddrMappingPDClient(t *testing.T) {
+	stores := []*metapb.Store{
+		{Id: 1, Address: "tikv-0.tikv:20160", State: metapb.StoreState_Up},
+		{Id: 2, Address: "tikv-1.tikv:20160", PeerAddress: "tikv-1.tikv:20161", State: metapb.StoreState_Up},
+		{Id: 3, Address: "tikv-2.tikv:20160", State: metapb.StoreState_Up},
+	}
+	mgr := &Mgr{PdController: &pdutil.PdController{}}
+	mgr.SetPDClient(fakePDClient{stores: stores})
+	mgr.SetAddrMapping(AddrMapping{"tikv-0.tikv:20160": "10.0.0.1:30160", "2": "10.0.0.2:30160"})
+
+	mapped, err := mgr.GetPDClient().GetAllStores(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1:30160", mapped[0].Address)
+	// the store ID takes precedence over the address.
+	require.Equal(t, "10.0.0.2:30160", mapped[1].Address)
+	require.Equal(t, "10.0.0.2:30160", mapped[1].PeerAddress)
+	require.Equal(t, "tikv-2.tikv:20160", mapped[2].Address)
+	// the stores returned by PD are not changed.
+	require.Equal(t, "tikv-0.tikv:20160", stores[0].Address)
+}
+
+func TestProbeStores(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := closed.Addr().String()
+	require.NoError(t, closed.Close())
+
+	mgr := &Mgr{PdController: &pdutil.PdController{}}
+	mgr.SetPDClient(fakePDClient{stores: []*metapb.Store{
+		{Id: 2, Address: closedAddr, State: metapb.StoreState_Up},
+		{Id: 1, Address: "unused", PeerAddress: listener.Addr().String(), State: metapb.StoreState_Up},
+	}})
+	results, err := mgr.ProbeStores(context.Background(), time.Second)
+	require.True(t, berrors.Is(err, berrors.ErrFailedToConnect))
+	require.Contains(t, err.Error(), closedAddr)
+	require.Len(t, results, 2)
+	require.Equal(t, uint64(1), results[0].StoreID)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, closedAddr, results[1].Addr)
+	require.Error(t, results[1].Err)
+
+	// the unreachable store is mapped to a reachable address.
+	mgr.SetAddrMapping(AddrMapping{"2": listener.Addr().String()})
+	results, err = mgr.ProbeStores(context.Background(), time.Second)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+}
@@ -8,22 +8,29 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/docker/go-units"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
 	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/cdcpb"
+	"github.com/pingcap/kvproto/pkg/debugpb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/tikvpb"
 	"github.com/pingcap/log"
 	"github.com/tikv/client-go/v2/config"
 	"github.com/tikv/client-go/v2/tikv"
 	"github.com/tikv/client-go/v2/txnkv/txnlock"
 	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/failpoints"
 	"github.com/tikv/migration/br/pkg/glue"
 	"github.com/tikv/migration/br/pkg/httputil"
 	"github.com/tikv/migration/br/pkg/logutil"
@@ -44,6 +51,10 @@ const (
 	dialTimeout = 30 * time.Second
 
 	resetRetryTimes = 3
+
+	// DefaultGRPCMaxMsgSize is the default max size of the gRPC messages to TiKV,
+	// the response of a range with huge keys or values may exceed the default 4 MiB of gRPC.
+	DefaultGRPCMaxMsgSize = int(128 * units.MiB)
 )
 
 // Pool is a lazy pool of gRPC channels.
@@ -112,9 +123,24 @@ type Mgr struct {
 	grpcClis     struct {
 		mu   sync.Mutex
 		clis map[uint64]*grpc.ClientConn
+		// addrs are the addresses the connections are dialed to.
+		addrs map[uint64]*storeAddr
 	}
 	keepalive   keepalive.ClientParameters
 	ownsStorage bool
+	// grpcMaxMsgSize is the max size of the messages sent to and received from TiKV.
+	grpcMaxMsgSize int
+	// dnsRefreshInterval is the interval of re-resolving the addresses of the stores,
+	// zero means never re-resolving them.
+	dnsRefreshInterval time.Duration
+	lookupHost         func(ctx context.Context, host string) ([]string, error)
+}
+
+// storeAddr is the address of a store and the IPs it's resolved to.
+type storeAddr struct {
+	addr       string
+	ips        []string
+	resolvedAt time.Time
 }
 
 // StoreBehavior is the action to do in GetAllTiKVStores when a non-TiKV
@@ -179,14 +205,14 @@ func GetAllTiKVStoresWithRetry(ctx context.Context,
 		ctx,
 		func() error {
 			stores, err = GetAllTiKVStores(ctx, pdClient, storeBehavior)
-			failpoint.Inject("hint-GetAllTiKVStores-error", func(val failpoint.Value) {
+			failpoint.Inject(failpoints.GetAllTiKVStoresError, func(val failpoint.Value) {
 				if val.(bool) {
 					logutil.CL(ctx).Debug("failpoint hint-GetAllTiKVStores-error injected.")
 					err = status.Error(codes.Unknown, "Retryable error")
 				}
 			})
 
-			failpoint.Inject("hint-GetAllTiKVStores-cancel", func(val failpoint.Value) {
+			failpoint.Inject(failpoints.GetAllTiKVStoresCancel, func(val failpoint.Value) {
 				if val.(bool) {
 					logutil.CL(ctx).Debug("failpoint hint-GetAllTiKVStores-cancel injected.")
 					err = status.Error(codes.Canceled, "Cancel Retry")
@@ -270,21 +296,125 @@ func NewMgr(
 	}
 
 	mgr := &Mgr{
-		PdController: controller,
-		lockResolver: lockResolver,
-		tlsConf:      tlsConf,
-		ownsStorage:  g.OwnsStorage(),
-		grpcClis: struct {
-			mu   sync.Mutex
-			clis map[uint64]*grpc.ClientConn
-		}{clis: make(map[uint64]*grpc.ClientConn)},
-		keepalive: keepalive,
-	}
+		PdController:   controller,
+		lockResolver:   lockResolver,
+		tlsConf:        tlsConf,
+		ownsStorage:    g.OwnsStorage(),
+		keepalive:      keepalive,
+		grpcMaxMsgSize: DefaultGRPCMaxMsgSize,
+	}
+	mgr.grpcClis.clis = make(map[uint64]*grpc.ClientConn)
+	mgr.grpcClis.addrs = make(map[uint64]*storeAddr)
 	return mgr, nil
 }
 
+// SetDNSRefreshInterval sets the interval of re-resolving the addresses of the stores.
+// A connection is re-dialed once the address of its store is resolved to other IPs,
+// e.g. the pod of the store is rescheduled behind the same hostname on Kubernetes.
+// The interval is a lower bound, the records are cached by the system resolver
+// according to their TTLs.
+func (mgr *Mgr) SetDNSRefreshInterval(interval time.Duration) {
+	mgr.dnsRefreshInterval = interval
+}
+
+// storeAddress returns the address to dial to the store.
+func (mgr *Mgr) storeAddress(ctx context.Context, storeID uint64) (string, error) {
+	store, err := mgr.GetPDClient().GetStore(ctx, storeID)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	addr := store.GetPeerAddress()
+	if addr == "" {
+		addr = store.GetAddress()
+	}
+	return addr, nil
+}
+
+// resolveStoreAddr resolves the host of addr, the IPs are empty if it fails.
+func (mgr *Mgr) resolveStoreAddr(ctx context.Context, addr string) *storeAddr {
+	resolved := &storeAddr{addr: addr, resolvedAt: time.Now()}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if net.ParseIP(host) != nil {
+		resolved.ips = []string{host}
+		return resolved
+	}
+	lookupHost := mgr.lookupHost
+	if lookupHost == nil {
+		lookupHost = net.DefaultResolver.LookupHost
+	}
+	ips, err := lookupHost(ctx, host)
+	if err != nil {
+		log.Warn("failed to resolve the address of store", zap.String("addr", addr), zap.Error(err))
+		return resolved
+	}
+	sort.Strings(ips)
+	resolved.ips = ips
+	return resolved
+}
+
+// needRefreshAddrLocked checks whether the address of the connection to the store should be re-resolved.
+func (mgr *Mgr) needRefreshAddrLocked(storeID uint64) bool {
+	if mgr.dnsRefreshInterval <= 0 {
+		return false
+	}
+	old, ok := mgr.grpcClis.addrs[storeID]
+	return ok && time.Since(old.resolvedAt) >= mgr.dnsRefreshInterval
+}
+
+// addrChangedLocked checks whether the connection to the store is dialed to another address or
+// other IPs than addr resolves to now. A failed resolution is not regarded as a change.
+func (mgr *Mgr) addrChangedLocked(ctx context.Context, storeID uint64, addr string) bool {
+	old, ok := mgr.grpcClis.addrs[storeID]
+	if !ok {
+		return false
+	}
+	cur := mgr.resolveStoreAddr(ctx, addr)
+	if cur.addr == old.addr && (len(cur.ips) == 0 || equalIPs(cur.ips, old.ips)) {
+		old.resolvedAt = cur.resolvedAt
+		return false
+	}
+	log.Info("the address of store changed", zap.Uint64("storeID", storeID),
+		zap.String("oldAddr", old.addr), zap.Strings("oldIPs", old.ips),
+		zap.String("newAddr", cur.addr), zap.Strings("newIPs", cur.ips))
+	return true
+}
+
+func equalIPs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// closeConnLocked closes the cached connection to the store.
+func (mgr *Mgr) closeConnLocked(storeID uint64) {
+	conn, ok := mgr.grpcClis.clis[storeID]
+	if !ok {
+		return
+	}
+	if err := conn.Close(); err != nil {
+		log.Warn("close backup connection failed, ignore it", zap.Uint64("storeID", storeID))
+	}
+	delete(mgr.grpcClis.clis, storeID)
+	delete(mgr.grpcClis.addrs, storeID)
+}
+
+// SetGRPCMaxMsgSize sets the max size of the gRPC messages to TiKV,
+// which takes effect on the connections created later.
+func (mgr *Mgr) SetGRPCMaxMsgSize(size int) {
+	mgr.grpcMaxMsgSize = size
+}
+
 func (mgr *Mgr) getGrpcConnLocked(ctx context.Context, storeID uint64) (*grpc.ClientConn, error) {
-	failpoint.Inject("hint-get-backup-client", func(v failpoint.Value) {
+	failpoint.Inject(failpoints.GetBackupClient, func(v failpoint.Value) {
 		log.Info("failpoint hint-get-backup-client injected, "+
 			"process will notify the shell.", zap.Uint64("store", storeID))
 		if sigFile, ok := v.(string); ok {
@@ -298,7 +428,7 @@ func (mgr *Mgr) getGrpcConnLocked(ctx context.Context, storeID uint64) (*grpc.Cl
 		}
 		time.Sleep(3 * time.Second)
 	})
-	store, err := mgr.GetPDClient().GetStore(ctx, storeID)
+	addr, err := mgr.storeAddress(ctx, storeID)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -306,13 +436,10 @@ func (mgr *Mgr) getGrpcConnLocked(ctx context.Context, storeID uint64) (*grpc.Cl
 	if mgr.tlsConf != nil {
 		opt = grpc.WithTransportCredentials(credentials.NewTLS(mgr.tlsConf))
 	}
+	resolved := mgr.resolveStoreAddr(ctx, addr)
 	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
 	bfConf := backoff.DefaultConfig
 	bfConf.MaxDelay = time.Second * 3
-	addr := store.GetPeerAddress()
-	if addr == "" {
-		addr = store.GetAddress()
-	}
 	conn, err := grpc.DialContext(
 		ctx,
 		addr,
@@ -320,16 +447,67 @@ func (mgr *Mgr) getGrpcConnLocked(ctx context.Context, storeID uint64) (*grpc.Cl
 		grpc.WithBlock(),
 		grpc.WithConnectParams(grpc.ConnectParams{Backoff: bfConf}),
 		grpc.WithKeepaliveParams(mgr.keepalive),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(mgr.grpcMaxMsgSize),
+			grpc.MaxCallSendMsgSize(mgr.grpcMaxMsgSize),
+		),
 	)
 	cancel()
 	if err != nil {
 		return nil, berrors.ErrFailedToConnect.Wrap(err).GenWithStack("failed to make connection to store %d", storeID)
 	}
+	if mgr.grpcClis.addrs == nil {
+		mgr.grpcClis.addrs = make(map[uint64]*storeAddr)
+	}
+	mgr.grpcClis.addrs[storeID] = resolved
 	return conn, nil
 }
 
 // GetBackupClient get or create a backup client.
 func (mgr *Mgr) GetBackupClient(ctx context.Context, storeID uint64) (backuppb.BackupClient, error) {
+	conn, err := mgr.getConn(ctx, storeID)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return backuppb.NewBackupClient(conn), nil
+}
+
+// GetChangeDataClient get or create a change data client, which shares the connection
+// with the backup client.
+func (mgr *Mgr) GetChangeDataClient(ctx context.Context, storeID uint64) (cdcpb.ChangeDataClient, error) {
+	conn, err := mgr.getConn(ctx, storeID)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cdcpb.NewChangeDataClient(conn), nil
+}
+
+// GetDebugClient get or create a debug client, which shares the connection with the backup client.
+func (mgr *Mgr) GetDebugClient(ctx context.Context, storeID uint64) (debugpb.DebugClient, error) {
+	conn, err := mgr.getConn(ctx, storeID)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return debugpb.NewDebugClient(conn), nil
+}
+
+// GetStoreSafeTS returns the safe-ts of the regions on the store reported by the store itself,
+// which is available even if the TSO of PD isn't. The address of the store is resolved by PD.
+func (mgr *Mgr) GetStoreSafeTS(ctx context.Context, storeID uint64) (uint64, error) {
+	conn, err := mgr.getConn(ctx, storeID)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	// the empty key range covers all the regions on the store.
+	resp, err := tikvpb.NewTikvClient(conn).GetStoreSafeTS(ctx, &kvrpcpb.StoreSafeTSRequest{KeyRange: &kvrpcpb.KeyRange{}})
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return resp.GetSafeTs(), nil
+}
+
+// getConn gets the cached connection of the store, or creates one.
+func (mgr *Mgr) getConn(ctx context.Context, storeID uint64) (*grpc.ClientConn, error) {
 	if ctx.Err() != nil {
 		return nil, errors.Trace(ctx.Err())
 	}
@@ -338,8 +516,21 @@ func (mgr *Mgr) GetBackupClient(ctx context.Context, storeID uint64) (backuppb.B
 	defer mgr.grpcClis.mu.Unlock()
 
 	if conn, ok := mgr.grpcClis.clis[storeID]; ok {
-		// Find a cached backup client.
-		return backuppb.NewBackupClient(conn), nil
+		if !mgr.needRefreshAddrLocked(storeID) {
+			// Find a cached connection.
+			return conn, nil
+		}
+		addr, err := mgr.storeAddress(ctx, storeID)
+		if err != nil {
+			log.Warn("failed to get the address of store, keep the connection",
+				zap.Uint64("storeID", storeID), zap.Error(err))
+			return conn, nil
+		}
+		if !mgr.addrChangedLocked(ctx, storeID, addr) {
+			return conn, nil
+		}
+		log.Info("reconnect to the store as its address changed", zap.Uint64("storeID", storeID))
+		mgr.closeConnLocked(storeID)
 	}
 
 	conn, err := mgr.getGrpcConnLocked(ctx, storeID)
@@ -348,11 +539,29 @@ func (mgr *Mgr) GetBackupClient(ctx context.Context, storeID uint64) (backuppb.B
 	}
 	// Cache the conn.
 	mgr.grpcClis.clis[storeID] = conn
-	return backuppb.NewBackupClient(conn), nil
+	return conn, nil
 }
 
 // ResetBackupClient reset the connection for backup client.
 func (mgr *Mgr) ResetBackupClient(ctx context.Context, storeID uint64) (backuppb.BackupClient, error) {
+	conn, err := mgr.resetConn(ctx, storeID)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return backuppb.NewBackupClient(conn), nil
+}
+
+// ResetChangeDataClient reset the connection for change data client.
+func (mgr *Mgr) ResetChangeDataClient(ctx context.Context, storeID uint64) (cdcpb.ChangeDataClient, error) {
+	conn, err := mgr.resetConn(ctx, storeID)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cdcpb.NewChangeDataClient(conn), nil
+}
+
+// resetConn closes the cached connection of the store, and creates another one.
+func (mgr *Mgr) resetConn(ctx context.Context, storeID uint64) (*grpc.ClientConn, error) {
 	if ctx.Err() != nil {
 		return nil, errors.Trace(ctx.Err())
 	}
@@ -360,14 +569,12 @@ func (mgr *Mgr) ResetBackupClient(ctx context.Context, storeID uint64) (backuppb
 	mgr.grpcClis.mu.Lock()
 	defer mgr.grpcClis.mu.Unlock()
 
-	if conn, ok := mgr.grpcClis.clis[storeID]; ok {
-		// Find a cached backup client.
-		log.Info("Reset backup client", zap.Uint64("storeID", storeID))
-		err := conn.Close()
-		if err != nil {
-			log.Warn("close backup connection failed, ignore it", zap.Uint64("storeID", storeID))
-		}
-		delete(mgr.grpcClis.clis, storeID)
+	if _, ok := mgr.grpcClis.clis[storeID]; ok {
+		// Find a cached connection.
+		// The address of the store is fetched and resolved again on reconnecting,
+		// so a connection failed as the store moved reconnects to its new address.
+		log.Info("Reset the connection to store", zap.Uint64("storeID", storeID))
+		mgr.closeConnLocked(storeID)
 	}
 	var (
 		conn *grpc.ClientConn
@@ -387,7 +594,7 @@ func (mgr *Mgr) ResetBackupClient(ctx context.Context, storeID uint64) (backuppb
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	return backuppb.NewBackupClient(conn), nil
+	return conn, nil
 }
 
 // GetTLSConfig returns the tls config.
@@ -5,6 +5,7 @@ package conn
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/jarcoal/httpmock"
 	"github.com/pingcap/errors"
@@ -313,3 +314,41 @@ func TestGetTiKVApiVersion(t *testing.T) {
 	require.Equal(t, err, nil)
 	require.Equal(t, apiVer, kvrpcpb.APIVersion_V2)
 }
+
+func TestStoreAddrChanged(t *testing.T) {
+	ctx := context.Background()
+	ips := []string{"10.0.0.2", "10.0.0.1"}
+	mgr := &Mgr{
+		lookupHost: func(ctx context.Context, host string) ([]string, error) {
+			if host != "tikv-0.tikv" {
+				return nil, errors.New("no such host")
+			}
+			return append([]string{}, ips...), nil
+		},
+	}
+	mgr.grpcClis.addrs = make(map[uint64]*storeAddr)
+
+	addr := mgr.resolveStoreAddr(ctx, "127.0.0.1:20160")
+	require.Equal(t, []string{"127.0.0.1"}, addr.ips)
+	addr = mgr.resolveStoreAddr(ctx, "unknown:20160")
+	require.Empty(t, addr.ips)
+	addr = mgr.resolveStoreAddr(ctx, "tikv-0.tikv:20160")
+	require.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, addr.ips)
+	mgr.grpcClis.addrs[1] = addr
+
+	// never refresh by default.
+	require.False(t, mgr.needRefreshAddrLocked(1))
+	mgr.SetDNSRefreshInterval(time.Minute)
+	require.False(t, mgr.needRefreshAddrLocked(1))
+	addr.resolvedAt = time.Now().Add(-time.Hour)
+	require.True(t, mgr.needRefreshAddrLocked(1))
+	require.False(t, mgr.needRefreshAddrLocked(2))
+
+	require.False(t, mgr.addrChangedLocked(ctx, 1, "tikv-0.tikv:20160"))
+	// the resolution time is updated.
+	require.False(t, mgr.needRefreshAddrLocked(1))
+
+	ips = []string{"10.0.0.3"}
+	require.True(t, mgr.addrChangedLocked(ctx, 1, "tikv-0.tikv:20160"))
+	require.True(t, mgr.addrChangedLocked(ctx, 1, "tikv-1.tikv:20160"))
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conn
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// StoreSelector selects the stores by their IDs or labels, e.g. the stores known to be
+// draining, which the backup avoids. A nil or empty selector selects no store.
+type StoreSelector struct {
+	StoreIDs []uint64
+	// Labels select the stores having any of them.
+	Labels []*metapb.StoreLabel
+}
+
+// ParseStoreLabels parses the labels in the form of "key=value".
+func ParseStoreLabels(labels []string) ([]*metapb.StoreLabel, error) {
+	parsed := make([]*metapb.StoreLabel, 0, len(labels))
+	for _, label := range labels {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"bad store label %q, it should be in the form of \"key=value\"", label)
+		}
+		parsed = append(parsed, &metapb.StoreLabel{Key: strings.TrimSpace(kv[0]), Value: strings.TrimSpace(kv[1])})
+	}
+	return parsed, nil
+}
+
+// IsEmpty returns whether the selector selects no store.
+func (s *StoreSelector) IsEmpty() bool {
+	return s == nil || (len(s.StoreIDs) == 0 && len(s.Labels) == 0)
+}
+
+// Match returns whether the store is selected.
+func (s *StoreSelector) Match(store *metapb.Store) bool {
+	if s.IsEmpty() {
+		return false
+	}
+	for _, id := range s.StoreIDs {
+		if store.GetId() == id {
+			return true
+		}
+	}
+	for _, label := range s.Labels {
+		for _, storeLabel := range store.GetLabels() {
+			if storeLabel.GetKey() == label.GetKey() && storeLabel.GetValue() == label.GetValue() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *StoreSelector) String() string {
+	if s.IsEmpty() {
+		return "none"
+	}
+	parts := make([]string, 0, len(s.StoreIDs)+len(s.Labels))
+	for _, id := range s.StoreIDs {
+		parts = append(parts, fmt.Sprintf("store %d", id))
+	}
+	for _, label := range s.Labels {
+		parts = append(parts, fmt.Sprintf("%s=%s", label.GetKey(), label.GetValue()))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FilterStores splits the stores into the ones not selected and the ones selected by the
+// selector, the order of the stores is kept.
+func FilterStores(stores []*metapb.Store, selector *StoreSelector) (kept, selected []*metapb.Store) {
+	if selector.IsEmpty() {
+		return stores, nil
+	}
+	kept = make([]*metapb.Store, 0, len(stores))
+	for _, store := range stores {
+		if selector.Match(store) {
+			selected = append(selected, store)
+			continue
+		}
+		kept = append(kept, store)
+	}
+	return kept, selected
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conn
+
+import (
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+func TestParseStoreLabels(t *testing.T) {
+	labels, err := ParseStoreLabels([]string{"zone=z1", " host = h1 ", "draining="})
+	require.NoError(t, err)
+	require.Equal(t, []*metapb.StoreLabel{
+		{Key: "zone", Value: "z1"},
+		{Key: "host", Value: "h1"},
+		{Key: "draining", Value: ""},
+	}, labels)
+
+	for _, bad := range []string{"zone", "=z1"} {
+		_, err = ParseStoreLabels([]string{bad})
+		require.True(t, berrors.Is(err, berrors.ErrInvalidArgument), bad)
+	}
+}
+
+func TestFilterStores(t *testing.T) {
+	stores := []*metapb.Store{
+		{Id: 1, Labels: []*metapb.StoreLabel{{Key: "zone", Value: "z1"}}},
+		{Id: 2, Labels: []*metapb.StoreLabel{{Key: "zone", Value: "z2"}}},
+		{Id: 3, Labels: []*metapb.StoreLabel{{Key: "zone", Value: "z2"}, {Key: "host", Value: "h3"}}},
+		{Id: 4},
+	}
+	var selector *StoreSelector
+	require.True(t, selector.IsEmpty())
+	require.False(t, selector.Match(stores[0]))
+	require.Equal(t, "none", selector.String())
+	kept, selected := FilterStores(stores, selector)
+	require.Equal(t, stores, kept)
+	require.Empty(t, selected)
+
+	selector = &StoreSelector{
+		StoreIDs: []uint64{4},
+		Labels:   []*metapb.StoreLabel{{Key: "zone", Value: "z1"}, {Key: "host", Value: "h3"}},
+	}
+	require.Equal(t, "store 4, zone=z1, host=h3", selector.String())
+	kept, selected = FilterStores(stores, selector)
+	require.Equal(t, []*metapb.Store{stores[1]}, kept)
+	require.Equal(t, []*metapb.Store{stores[0], stores[2], stores[3]}, selected)
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package encryption
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/pingcap/errors"
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/option"
+)
+
+// awsKMSMasterKey wraps the data keys by a key of AWS KMS. The credentials are the
+// ones in the query, or found in the environment like S3.
+type awsKMSMasterKey struct {
+	keyID  string
+	client kmsiface.KMSAPI
+}
+
+func newAWSKMSMasterKey(keyID string, query url.Values) (*awsKMSMasterKey, error) {
+	config := aws.NewConfig()
+	if region := query.Get("region"); len(region) > 0 {
+		config.WithRegion(region)
+	}
+	if endpoint := query.Get("endpoint"); len(endpoint) > 0 {
+		config.WithEndpoint(endpoint)
+	}
+	if accessKey, secretKey := query.Get("access-key"), query.Get("secret-access-key"); len(accessKey) > 0 && len(secretKey) > 0 {
+		config.WithCredentials(credentials.NewStaticCredentials(accessKey, secretKey, ""))
+	}
+	ses, err := session.NewSessionWithOptions(session.Options{Config: *config, SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &awsKMSMasterKey{keyID: keyID, client: kms.New(ses)}, nil
+}
+
+// Encrypt implements MasterKey.
+func (k *awsKMSMasterKey) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	output, err := k.client.EncryptWithContext(ctx, &kms.EncryptInput{KeyId: aws.String(k.keyID), Plaintext: plaintext})
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to wrap the data key by AWS KMS key %s", k.keyID)
+	}
+	return output.CiphertextBlob, nil
+}
+
+// Decrypt implements MasterKey.
+func (k *awsKMSMasterKey) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	output, err := k.client.DecryptWithContext(ctx, &kms.DecryptInput{KeyId: aws.String(k.keyID), CiphertextBlob: ciphertext})
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to unwrap the data key by AWS KMS key %s", k.keyID)
+	}
+	return output.Plaintext, nil
+}
+
+// gcpKMSMasterKey wraps the data keys by a key of GCP Cloud KMS. The credentials are the
+// file in the query, or the application default credentials.
+type gcpKMSMasterKey struct {
+	name string
+	keys *cloudkms.ProjectsLocationsKeyRingsCryptoKeysService
+}
+
+func newGCPKMSMasterKey(ctx context.Context, name string, query url.Values) (*gcpKMSMasterKey, error) {
+	var opts []option.ClientOption
+	if file := query.Get("credentials-file"); len(file) > 0 {
+		opts = append(opts, option.WithCredentialsFile(file))
+	}
+	if endpoint := query.Get("endpoint"); len(endpoint) > 0 {
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+	if query.Get("no-credentials") == "true" {
+		opts = append(opts, option.WithoutAuthentication())
+	}
+	service, err := cloudkms.NewService(ctx, opts...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &gcpKMSMasterKey{name: name, keys: service.Projects.Locations.KeyRings.CryptoKeys}, nil
+}
+
+// Encrypt implements MasterKey.
+func (k *gcpKMSMasterKey) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	resp, err := k.keys.Encrypt(k.name, &cloudkms.EncryptRequest{
+		Plaintext: base64.StdEncoding.EncodeToString(plaintext),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to wrap the data key by GCP KMS key %s", k.name)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(resp.Ciphertext)
+	return ciphertext, errors.Trace(err)
+}
+
+// Decrypt implements MasterKey.
+func (k *gcpKMSMasterKey) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	resp, err := k.keys.Decrypt(k.name, &cloudkms.DecryptRequest{
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to unwrap the data key by GCP KMS key %s", k.name)
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+	return plaintext, errors.Trace(err)
+}
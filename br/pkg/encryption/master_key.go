@@ -0,0 +1,123 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package encryption wraps the data keys of the backups by the master keys, i.e. envelope
+// encryption, so that the data key is kept in the backup storage instead of being passed to
+// every task.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// MasterKey wraps and unwraps the data keys.
+type MasterKey interface {
+	// Encrypt wraps the data key.
+	Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
+	// Decrypt unwraps the data key wrapped by Encrypt.
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// NewMasterKey creates the master key from the URL, one of
+//
+//	local:///path/to/master.key, the file holds the hex of an AES-128/192/256 key.
+//	aws-kms:///<key id, arn or alias>?region=...&endpoint=...&access-key=...&secret-access-key=...
+//	gcp-kms:///projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>?credentials-file=...&endpoint=...&no-credentials=true
+func NewMasterKey(ctx context.Context, rawURL string) (MasterKey, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid master key '%s': %v", RedactURL(rawURL), err)
+	}
+	keyID := strings.TrimPrefix(u.Path, "/")
+	if len(keyID) == 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "please specify the key of the master key '%s'", RedactURL(rawURL))
+	}
+	switch u.Scheme {
+	case "local":
+		return newLocalMasterKey(u.Path)
+	case "aws-kms":
+		return newAWSKMSMasterKey(keyID, u.Query())
+	case "gcp-kms":
+		return newGCPKMSMasterKey(ctx, keyID, u.Query())
+	default:
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"unsupported master key '%s', should be one of local|aws-kms|gcp-kms", RedactURL(rawURL))
+	}
+}
+
+// RedactURL removes the query parameters of the master key URL, which may hold the credentials.
+func RedactURL(rawURL string) string {
+	if i := strings.IndexByte(rawURL, '?'); i >= 0 {
+		return rawURL[:i]
+	}
+	return rawURL
+}
+
+// localMasterKey wraps the data keys by AES-GCM with the key in a local file.
+type localMasterKey struct {
+	aead cipher.AEAD
+}
+
+func newLocalMasterKey(path string) (*localMasterKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to read the master key: %v", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "the master key %s is not hex encoded: %v", path, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid master key %s: %v", path, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &localMasterKey{aead: aead}, nil
+}
+
+// Encrypt implements MasterKey, the ciphertext is prefixed by the nonce.
+func (k *localMasterKey) Encrypt(_ context.Context, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return k.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt implements MasterKey.
+func (k *localMasterKey) Decrypt(_ context.Context, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < k.aead.NonceSize() {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, "the wrapped data key is too short")
+	}
+	nonce, sealed := ciphertext[:k.aead.NonceSize()], ciphertext[k.aead.NonceSize():]
+	plaintext, err := k.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"failed to unwrap the data key, the master key may be wrong: %v", err)
+	}
+	return plaintext, nil
+}
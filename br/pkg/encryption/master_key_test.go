@@ -0,0 +1,152 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+func TestLocalMasterKey(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "master.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)+"\n"), 0o600))
+
+	key, err := NewMasterKey(ctx, "local://"+keyFile)
+	require.NoError(t, err)
+	dataKey := []byte("0123456789abcdef")
+	wrapped, err := key.Encrypt(ctx, dataKey)
+	require.NoError(t, err)
+	require.NotContains(t, string(wrapped), string(dataKey))
+	unwrapped, err := key.Decrypt(ctx, wrapped)
+	require.NoError(t, err)
+	require.Equal(t, dataKey, unwrapped)
+
+	otherFile := filepath.Join(dir, "other.key")
+	require.NoError(t, os.WriteFile(otherFile, []byte(strings.Repeat("cd", 32)), 0o600))
+	other, err := NewMasterKey(ctx, "local://"+otherFile)
+	require.NoError(t, err)
+	_, err = other.Decrypt(ctx, wrapped)
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+
+	require.NoError(t, os.WriteFile(otherFile, []byte("abc"), 0o600))
+	_, err = NewMasterKey(ctx, "local://"+otherFile)
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+	_, err = NewMasterKey(ctx, "local://"+filepath.Join(dir, "missing.key"))
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+	_, err = NewMasterKey(ctx, "vault:///key?token=secret")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+	require.NotContains(t, err.Error(), "secret")
+	_, err = NewMasterKey(ctx, "aws-kms://")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
+
+// mockKMS reverses the plaintext as the ciphertext.
+type mockKMS struct {
+	kmsiface.KMSAPI
+	keyIDs []string
+}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+func (m *mockKMS) EncryptWithContext(_ context.Context, input *kms.EncryptInput, _ ...request.Option) (*kms.EncryptOutput, error) {
+	m.keyIDs = append(m.keyIDs, *input.KeyId)
+	return &kms.EncryptOutput{CiphertextBlob: reverse(input.Plaintext)}, nil
+}
+
+func (m *mockKMS) DecryptWithContext(_ context.Context, input *kms.DecryptInput, _ ...request.Option) (*kms.DecryptOutput, error) {
+	m.keyIDs = append(m.keyIDs, *input.KeyId)
+	return &kms.DecryptOutput{Plaintext: reverse(input.CiphertextBlob)}, nil
+}
+
+func TestAWSKMSMasterKey(t *testing.T) {
+	ctx := context.Background()
+	key, err := NewMasterKey(ctx, "aws-kms:///arn:aws:kms:us-west-2:111122223333:key/1234?region=us-west-2")
+	require.NoError(t, err)
+	awsKey := key.(*awsKMSMasterKey)
+	require.Equal(t, "arn:aws:kms:us-west-2:111122223333:key/1234", awsKey.keyID)
+	mock := &mockKMS{}
+	awsKey.client = mock
+
+	wrapped, err := key.Encrypt(ctx, []byte("data-key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("yek-atad"), wrapped)
+	unwrapped, err := key.Decrypt(ctx, wrapped)
+	require.NoError(t, err)
+	require.Equal(t, []byte("data-key"), unwrapped)
+	require.Equal(t, []string{awsKey.keyID, awsKey.keyID}, mock.keyIDs)
+}
+
+func TestGCPKMSMasterKey(t *testing.T) {
+	ctx := context.Background()
+	const name = "projects/p/locations/global/keyRings/r/cryptoKeys/k"
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var in, out string
+		if strings.HasSuffix(r.URL.Path, ":encrypt") {
+			in, out = req["plaintext"], "ciphertext"
+		} else {
+			in, out = req["ciphertext"], "plaintext"
+		}
+		data, err := base64.StdEncoding.DecodeString(in)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{out: base64.StdEncoding.EncodeToString(reverse(data))})
+	}))
+	defer server.Close()
+
+	key, err := NewMasterKey(ctx, "gcp-kms:///"+name+"?no-credentials=true&endpoint="+server.URL+"/")
+	require.NoError(t, err)
+	wrapped, err := key.Encrypt(ctx, []byte("data-key"))
+	require.NoError(t, err)
+	require.True(t, bytes.Equal([]byte("yek-atad"), wrapped))
+	unwrapped, err := key.Decrypt(ctx, wrapped)
+	require.NoError(t, err)
+	require.Equal(t, []byte("data-key"), unwrapped)
+	require.Equal(t, []string{"/v1/" + name + ":encrypt", "/v1/" + name + ":decrypt"}, paths)
+}
+
+func TestRedactURL(t *testing.T) {
+	require.Equal(t, "aws-kms:///key", RedactURL("aws-kms:///key?access-key=a&secret-access-key=b"))
+	require.Equal(t, "local:///master.key", RedactURL("local:///master.key"))
+}
@@ -25,16 +25,19 @@ var (
 	ErrInvalidMetaFile           = errors.Normalize("invalid metafile", errors.RFCCodeText("BR:Common:ErrInvalidMetaFile"))
 	ErrEnvNotSpecified           = errors.Normalize("environment variable not found", errors.RFCCodeText("BR:Common:ErrEnvNotSpecified"))
 	ErrUnsupportedOperation      = errors.Normalize("the operation is not supported", errors.RFCCodeText("BR:Common:ErrUnsupportedOperation"))
+	ErrHookFailed                = errors.Normalize("the hook failed", errors.RFCCodeText("BR:Common:ErrHookFailed"))
 
 	ErrPDUpdateFailed    = errors.Normalize("failed to update PD", errors.RFCCodeText("BR:PD:ErrPDUpdateFailed"))
 	ErrPDLeaderNotFound  = errors.Normalize("PD leader not found", errors.RFCCodeText("BR:PD:ErrPDLeaderNotFound"))
 	ErrPDInvalidResponse = errors.Normalize("PD invalid response", errors.RFCCodeText("BR:PD:ErrPDInvalidResponse"))
 	ErrPDBatchScanRegion = errors.Normalize("batch scan region", errors.RFCCodeText("BR:PD:ErrPDBatchScanRegion"))
 
-	ErrBackupChecksumMismatch    = errors.Normalize("backup checksum mismatch", errors.RFCCodeText("BR:Backup:ErrBackupChecksumMismatch"))
-	ErrBackupInvalidRange        = errors.Normalize("backup range invalid", errors.RFCCodeText("BR:Backup:ErrBackupInvalidRange"))
-	ErrBackupNoLeader            = errors.Normalize("backup no leader", errors.RFCCodeText("BR:Backup:ErrBackupNoLeader"))
-	ErrBackupGCSafepointExceeded = errors.Normalize("backup GC safepoint exceeded", errors.RFCCodeText("BR:Backup:ErrBackupGCSafepointExceeded"))
+	ErrBackupChecksumMismatch        = errors.Normalize("backup checksum mismatch", errors.RFCCodeText("BR:Backup:ErrBackupChecksumMismatch"))
+	ErrBackupInvalidRange            = errors.Normalize("backup range invalid", errors.RFCCodeText("BR:Backup:ErrBackupInvalidRange"))
+	ErrBackupNoLeader                = errors.Normalize("backup no leader", errors.RFCCodeText("BR:Backup:ErrBackupNoLeader"))
+	ErrBackupGCSafepointExceeded     = errors.Normalize("backup GC safepoint exceeded", errors.RFCCodeText("BR:Backup:ErrBackupGCSafepointExceeded"))
+	ErrBackupFineGrainedNotConverged = errors.Normalize("fine grained backup not converged", errors.RFCCodeText("BR:Backup:ErrBackupFineGrainedNotConverged"))
+	ErrBackupUnsafeTSUnavailable     = errors.Normalize("backup unsafe ts unavailable", errors.RFCCodeText("BR:Backup:ErrBackupUnsafeTSUnavailable"))
 
 	ErrRestoreModeMismatch     = errors.Normalize("restore mode mismatch", errors.RFCCodeText("BR:Restore:ErrRestoreModeMismatch"))
 	ErrRestoreRangeMismatch    = errors.Normalize("restore range mismatch", errors.RFCCodeText("BR:Restore:ErrRestoreRangeMismatch"))
@@ -48,6 +51,8 @@ var (
 	ErrRestoreInvalidRange     = errors.Normalize("invalid restore range", errors.RFCCodeText("BR:Restore:ErrRestoreInvalidRange"))
 	ErrRestoreWriteAndIngest   = errors.Normalize("failed to write and ingest", errors.RFCCodeText("BR:Restore:ErrRestoreWriteAndIngest"))
 	ErrRestoreSchemaNotExists  = errors.Normalize("schema not exists", errors.RFCCodeText("BR:Restore:ErrRestoreSchemaNotExists"))
+	ErrRestoreKeyspaceMismatch = errors.Normalize("restore keyspace mismatch", errors.RFCCodeText("BR:Restore:ErrRestoreKeyspaceMismatch"))
+	ErrRestorePreflightFailed  = errors.Normalize("restore preflight checks failed", errors.RFCCodeText("BR:Restore:ErrRestorePreflightFailed"))
 	ErrUnsupportedSystemTable  = errors.Normalize("the system table isn't supported for restoring yet", errors.RFCCodeText("BR:Restore:ErrUnsupportedSysTable"))
 
 	// TODO maybe it belongs to PiTR.
@@ -58,6 +63,18 @@ var (
 	ErrStorageUnknown           = errors.Normalize("unknown external storage error", errors.RFCCodeText("BR:ExternalStorage:ErrStorageUnknown"))
 	ErrStorageInvalidConfig     = errors.Normalize("invalid external storage config", errors.RFCCodeText("BR:ExternalStorage:ErrStorageInvalidConfig"))
 	ErrStorageInvalidPermission = errors.Normalize("external storage permission", errors.RFCCodeText("BR:ExternalStorage:ErrStorageInvalidPermission"))
+	ErrStorageNoSpace           = errors.Normalize("no space left on the storage", errors.RFCCodeText("BR:ExternalStorage:ErrStorageNoSpace"))
+	ErrStorageCorrupted         = errors.Normalize("the file in the external storage is corrupted", errors.RFCCodeText("BR:ExternalStorage:ErrStorageCorrupted"))
+
+	ErrServerJobNotFound  = errors.Normalize("job not found", errors.RFCCodeText("BR:Server:ErrServerJobNotFound"))
+	ErrServerQueueFull    = errors.Normalize("job queue is full", errors.RFCCodeText("BR:Server:ErrServerQueueFull"))
+	ErrServerJobFinished  = errors.Normalize("job has finished", errors.RFCCodeText("BR:Server:ErrServerJobFinished"))
+	ErrServerInvalidJob   = errors.Normalize("invalid job", errors.RFCCodeText("BR:Server:ErrServerInvalidJob"))
+	ErrServerUnauthorized = errors.Normalize("unauthorized", errors.RFCCodeText("BR:Server:ErrServerUnauthorized"))
+	ErrServerForbidden    = errors.Normalize("permission denied", errors.RFCCodeText("BR:Server:ErrServerForbidden"))
+
+	ErrSSTCorrupted   = errors.Normalize("corrupted sst file", errors.RFCCodeText("BR:SST:ErrSSTCorrupted"))
+	ErrSSTUnsupported = errors.Normalize("unsupported sst file", errors.RFCCodeText("BR:SST:ErrSSTUnsupported"))
 
 	// Errors reported from TiKV.
 	ErrKVStorage           = errors.Normalize("tikv storage occur I/O error", errors.RFCCodeText("BR:KV:ErrKVStorage"))
@@ -0,0 +1,166 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package failpoints is the registry of the failpoints injected into BR, so that the integration
+// tests can exercise the failures and crash points systematically. A failpoint is enabled by its
+// path in GO_FAILPOINTS of a BR built by failpoint-ctl, e.g.
+//
+//	GO_FAILPOINTS='github.com/tikv/migration/br/pkg/storage/storage-finalize-error=1*return("oops")'
+//
+// Any failpoint can crash BR by the term `panic` besides the values it takes.
+package failpoints
+
+//go:generate go run ./gen ../../docs/failpoints.md
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const modulePath = "github.com/tikv/migration/br"
+
+// The names of the failpoints passed to failpoint.Inject, see registry for their docs.
+const (
+	GetAllTiKVStoresError  = "hint-GetAllTiKVStores-error"
+	GetAllTiKVStoresCancel = "hint-GetAllTiKVStores-cancel"
+	GetBackupClient        = "hint-get-backup-client"
+
+	FastRetry            = "FastRetry"
+	PDEnabledPauseConfig = "PDEnabledPauseConfig"
+
+	NoopBackup              = "noop-backup"
+	BackupStorageError      = "backup-storage-error"
+	TiKVRWError             = "tikv-rw-error"
+	TiKVRegionError         = "tikv-region-error"
+	FineGrainedBackup       = "hint-fine-grained-backup"
+	BackupStart             = "hint-backup-start"
+	ResetRetryableError     = "reset-retryable-error"
+	ResetNotRetryableError  = "reset-not-retryable-error"
+	SafePointKeepAliveError = "safepoint-keepalive-error"
+	SafePointExceeded       = "safepoint-exceeded"
+
+	NotLeaderError         = "not-leader-error"
+	SomewhatRetryableError = "somewhat-retryable-error"
+	RestoreStorageError    = "restore-storage-error"
+	RestoreGRPCError       = "restore-gRPC-error"
+
+	StorageFinalizeError = "storage-finalize-error"
+	FlushMetafileError   = "flush-metafile-error"
+	FlushBackupMetaError = "flush-backupmeta-error"
+)
+
+// Failpoint is a failpoint injected into BR.
+type Failpoint struct {
+	// Package is the import path of the package injecting the failpoint.
+	Package string
+	// Name is the name passed to failpoint.Inject.
+	Name string
+	// Value is the value the failpoint takes, e.g. `return(true)`.
+	Value string
+	// Doc describes where the failpoint is injected and what it does.
+	Doc string
+}
+
+// Path returns the path enabling the failpoint.
+func (f Failpoint) Path() string {
+	return f.Package + "/" + f.Name
+}
+
+// Env returns the item of GO_FAILPOINTS enabling the failpoint by the term, e.g. `1*return("oops")`.
+func (f Failpoint) Env(term string) string {
+	return f.Path() + "=" + term
+}
+
+func pkg(name string) string {
+	return modulePath + "/pkg/" + name
+}
+
+var registry = []Failpoint{
+	{pkg("conn"), GetAllTiKVStoresError, "return(true)",
+		"Fails getting the stores from PD by a retryable error."},
+	{pkg("conn"), GetAllTiKVStoresCancel, "return(true)",
+		"Fails getting the stores from PD by a canceled error, which isn't retried."},
+	{pkg("conn"), GetBackupClient, `return("<file>")`,
+		"Creates the file once BR connects to a TiKV for backup, to notify the test script."},
+	{pkg("pdutil"), FastRetry, "return(true)",
+		"Retries the requests to PD without waiting."},
+	{pkg("pdutil"), PDEnabledPauseConfig, "return(true)",
+		"Takes PD as v5.0.0, which supports pausing the schedulers by the config."},
+	{pkg("backup"), NoopBackup, "return(true)",
+		"Skips backing up the ranges by the stores, so that all of them are backed up by the fine-grained backup."},
+	{pkg("backup"), BackupStorageError, `return("<msg>")`,
+		"Fails the backup response of a store by the error message, as if TiKV failed to write the external storage."},
+	{pkg("backup"), TiKVRWError, `return("<msg>")`,
+		"Fails the backup response of a store by the error message, as if TiKV failed to read or write."},
+	{pkg("backup"), TiKVRegionError, `return("<msg>")`,
+		"Fails the backup response of a store by a region error, which triggers the fine-grained backup."},
+	{pkg("backup"), FineGrainedBackup, `return("<file>")`,
+		"Creates the file once the fine-grained backup starts, to notify the test script."},
+	{pkg("backup"), BackupStart, `return("<file>")`,
+		"Creates the file once the backup of a range starts, to notify the test script."},
+	{pkg("backup"), ResetRetryableError, "return(true)",
+		"Fails the backup of a store by an unavailable error, which resets the connection and is retried."},
+	{pkg("backup"), ResetNotRetryableError, "return(true)",
+		"Fails the backup of a store by an unknown error, which resets the connection and isn't retried."},
+	{pkg("utils"), SafePointKeepAliveError, `return("<msg>")`,
+		"Fails the heartbeat of the service safe point, as if PD were unavailable, the heartbeat is retried."},
+	{pkg("utils"), SafePointExceeded, "return(true)",
+		"Fails the heartbeat of the service safe point as if GC had passed the backup ts, which aborts the backup."},
+	{pkg("restore"), NotLeaderError, "return(true)",
+		"Fails splitting the region by a not leader error, with the new leader if the value is true."},
+	{pkg("restore"), SomewhatRetryableError, "return(true)",
+		"Fails splitting the region by a server busy error."},
+	{pkg("restore"), RestoreStorageError, `return("<msg>")`,
+		"Fails downloading the SST files by the error message, as if TiKV failed to read the external storage."},
+	{pkg("restore"), RestoreGRPCError, "return(true)",
+		"Fails downloading the SST files by an unavailable gRPC error."},
+	{pkg("storage"), StorageFinalizeError, `return("<msg>")`,
+		"Fails finalizing a file written by the external storage writer, e.g. completing the multipart upload, before its last chunk is uploaded."},
+	{pkg("metautil"), FlushMetafileError, `return("<msg>")`,
+		"Fails flushing a metafile of the backupmeta v2 to the external storage, before it's written."},
+	{pkg("metautil"), FlushBackupMetaError, `return("<msg>")`,
+		"Fails flushing the backupmeta to the external storage, before it's written, so the backup is left unfinished."},
+}
+
+// All returns all the failpoints injected into BR.
+func All() []Failpoint {
+	all := make([]Failpoint, len(registry))
+	copy(all, registry)
+	return all
+}
+
+// Lookup returns the failpoint of the path.
+func Lookup(path string) (Failpoint, bool) {
+	for _, f := range registry {
+		if f.Path() == path {
+			return f, true
+		}
+	}
+	return Failpoint{}, false
+}
+
+// Markdown returns the document of the failpoints in markdown.
+func Markdown() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# Failpoints\n\n")
+	buf.WriteString("<!-- Generated by `go generate ./pkg/failpoints`, DO NOT EDIT. -->\n\n")
+	buf.WriteString("The failpoints injected into BR, which are enabled by `GO_FAILPOINTS` of a BR built by `make failpoint/enable`, " +
+		"e.g. `GO_FAILPOINTS='<path>=<value>'`. Any failpoint can crash BR by the value `panic`.\n\n")
+	buf.WriteString("| Path | Value | Description |\n")
+	buf.WriteString("| ---- | ----- | ----------- |\n")
+	for _, f := range registry {
+		fmt.Fprintf(&buf, "| `%s` | `%s` | %s |\n", f.Path(), f.Value, f.Doc)
+	}
+	return buf.Bytes()
+}
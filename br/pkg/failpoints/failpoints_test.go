@@ -0,0 +1,150 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package failpoints
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	paths := make(map[string]struct{})
+	for _, f := range All() {
+		require.NotEmpty(t, f.Value, f.Path())
+		require.NotEmpty(t, f.Doc, f.Path())
+		_, ok := paths[f.Path()]
+		require.False(t, ok, "duplicated failpoint %s", f.Path())
+		paths[f.Path()] = struct{}{}
+
+		found, ok := Lookup(f.Path())
+		require.True(t, ok)
+		require.Equal(t, f, found)
+	}
+	_, ok := Lookup(pkg("backup") + "/not-exist")
+	require.False(t, ok)
+
+	f, _ := Lookup(pkg("storage") + "/" + StorageFinalizeError)
+	require.Equal(t, `github.com/tikv/migration/br/pkg/storage/storage-finalize-error=1*return("oops")`, f.Env(`1*return("oops")`))
+}
+
+// constants returns the values of the constants of the package.
+func constants(t *testing.T) map[string]string {
+	file, err := parser.ParseFile(token.NewFileSet(), "failpoints.go", nil, 0)
+	require.NoError(t, err)
+	values := make(map[string]string)
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+		for i, name := range spec.Names {
+			if i >= len(spec.Values) {
+				break
+			}
+			if lit, ok := spec.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				values[name.Name], err = strconv.Unquote(lit.Value)
+				require.NoError(t, err)
+			}
+		}
+		return false
+	})
+	return values
+}
+
+// failpointName returns the name of the failpoint of the call, which is either failpoint.Inject
+// or failpoint.Eval rewritten by failpoint-ctl.
+func failpointName(call *ast.CallExpr) (ast.Expr, bool) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || len(call.Args) == 0 {
+		return nil, false
+	}
+	if x, ok := sel.X.(*ast.Ident); !ok || x.Name != "failpoint" {
+		return nil, false
+	}
+	switch sel.Sel.Name {
+	case "Inject":
+		return call.Args[0], true
+	case "InjectContext":
+		return call.Args[1], true
+	case "Eval", "EvalContext":
+		arg := call.Args[len(call.Args)-1]
+		if curpkg, ok := arg.(*ast.CallExpr); ok && len(curpkg.Args) == 1 {
+			return curpkg.Args[0], true
+		}
+	}
+	return nil, false
+}
+
+func TestFailpointsRegistered(t *testing.T) {
+	values := constants(t)
+	injected := make(map[string]struct{})
+	root := filepath.Join("..", "..")
+	for _, dir := range []string{"pkg", "cmd"} {
+		err := filepath.Walk(filepath.Join(root, dir), func(name string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+				return err
+			}
+			file, err := parser.ParseFile(token.NewFileSet(), name, nil, 0)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, filepath.Dir(name))
+			if err != nil {
+				return err
+			}
+			pkgPath := path.Join(modulePath, filepath.ToSlash(rel))
+			ast.Inspect(file, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				arg, ok := failpointName(call)
+				if !ok {
+					return true
+				}
+				sel, ok := arg.(*ast.SelectorExpr)
+				require.True(t, ok, "the failpoint at %s isn't in the registry, use the constants of package failpoints", name)
+				value, ok := values[sel.Sel.Name]
+				require.True(t, ok, "unknown failpoint %s at %s", sel.Sel.Name, name)
+				fpPath := pkgPath + "/" + value
+				_, ok = Lookup(fpPath)
+				require.True(t, ok, "failpoint %s isn't in the registry", fpPath)
+				injected[fpPath] = struct{}{}
+				return true
+			})
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	for _, f := range All() {
+		_, ok := injected[f.Path()]
+		require.True(t, ok, "failpoint %s isn't injected", f.Path())
+	}
+}
+
+func TestDocUpToDate(t *testing.T) {
+	doc, err := os.ReadFile(filepath.Join("..", "..", "docs", "failpoints.md"))
+	require.NoError(t, err)
+	require.Equal(t, string(Markdown()), string(doc), "run `go generate ./pkg/failpoints` to update the document")
+}
@@ -0,0 +1,397 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package handover upgrades the binary of the long-running BR processes (br server and
+// br stream start) without downtime. On an upgrade request, e.g. SIGUSR2, the running
+// process starts the binary at the same path with the same arguments, and passes its
+// listeners and state (e.g. the ID of its GC service safe point) to the new process:
+//
+//  1. the old process starts the new process, which inherits the listening sockets, so
+//     the connections are queued by the kernel instead of refused during the handover;
+//  2. the new process initializes itself, and tells the old process it's Ready;
+//  3. the old process stops, e.g. flushes its checkpoint, and Releases the new process;
+//  4. the new process takes over from the checkpoint, and the old process exits.
+//
+// If the new process fails before it's ready, the old process goes on as if nothing happened.
+package handover
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// envListeners lists the inherited listeners like name=fd,name=fd.
+	envListeners = "BR_HANDOVER_LISTENERS"
+	// envReadyFD is the fd the new process writes to when it's ready.
+	envReadyFD = "BR_HANDOVER_READY_FD"
+	// envReleaseFD is the fd closed by the old process when it releases the new process.
+	envReleaseFD = "BR_HANDOVER_RELEASE_FD"
+	// envState is the JSON of the state passed to the new process.
+	envState = "BR_HANDOVER_STATE"
+
+	// DefaultReadyTimeout is the max time waiting for the new process to be ready.
+	DefaultReadyTimeout = 2 * time.Minute
+)
+
+// Handover keeps the listeners and the state passed between the old and the new process.
+// It is safe for concurrent use.
+type Handover struct {
+	executable string
+	args       []string
+
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+	inherited map[string]*os.File
+	state     map[string]string
+	// parentState is the state passed by the old process.
+	parentState map[string]string
+	// upgraded is whether the process is started by an upgrade, ready and release are the
+	// pipes to the old process.
+	upgraded  bool
+	ready     *os.File
+	release   *os.File
+	upgrading bool
+
+	requests chan struct{}
+	// ReadyTimeout is the max time waiting for the new process to be ready.
+	ReadyTimeout time.Duration
+}
+
+var (
+	globalOnce     sync.Once
+	globalHandover *Handover
+)
+
+// Global returns the process-wide handover, which takes over the listeners and the state
+// from the old process if the current process is started by an upgrade.
+func Global() *Handover {
+	globalOnce.Do(func() {
+		executable, err := os.Executable()
+		if err != nil {
+			log.Warn("failed to locate the binary, the upgrade runs the binary of the first argument", zap.Error(err))
+			executable = os.Args[0]
+		}
+		globalHandover, err = newHandover(executable, os.Args[1:], os.Getenv)
+		if err != nil {
+			log.Warn("failed to take over from the old process", zap.Error(err))
+			globalHandover, _ = newHandover(executable, os.Args[1:], func(string) string { return "" })
+		}
+		for _, env := range []string{envListeners, envReadyFD, envReleaseFD, envState} {
+			// the envs are not passed to the processes started by this one.
+			_ = os.Unsetenv(env)
+		}
+	})
+	return globalHandover
+}
+
+func newHandover(executable string, args []string, getenv func(string) string) (*Handover, error) {
+	h := &Handover{
+		executable:   executable,
+		args:         args,
+		listeners:    make(map[string]net.Listener),
+		inherited:    make(map[string]*os.File),
+		state:        make(map[string]string),
+		parentState:  make(map[string]string),
+		requests:     make(chan struct{}, 1),
+		ReadyTimeout: DefaultReadyTimeout,
+	}
+	if v := getenv(envListeners); len(v) > 0 {
+		for _, item := range strings.Split(v, ",") {
+			parts := strings.SplitN(item, "=", 2)
+			if len(parts) != 2 {
+				return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid inherited listener '%s'", item)
+			}
+			fd, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid inherited listener '%s'", item)
+			}
+			h.inherited[parts[0]] = os.NewFile(uintptr(fd), parts[0])
+		}
+	}
+	if v := getenv(envState); len(v) > 0 {
+		if err := json.Unmarshal([]byte(v), &h.parentState); err != nil {
+			return nil, errors.Annotate(err, "invalid inherited state")
+		}
+	}
+	var err error
+	if h.ready, err = fileOfEnv(getenv, envReadyFD); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if h.release, err = fileOfEnv(getenv, envReleaseFD); err != nil {
+		return nil, errors.Trace(err)
+	}
+	h.upgraded = h.ready != nil
+	return h, nil
+}
+
+func fileOfEnv(getenv func(string) string, env string) (*os.File, error) {
+	v := getenv(env)
+	if len(v) == 0 {
+		return nil, nil
+	}
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid %s '%s'", env, v)
+	}
+	return os.NewFile(uintptr(fd), env), nil
+}
+
+// IsUpgraded returns whether the process is started by the upgrade of an old process.
+func (h *Handover) IsUpgraded() bool {
+	return h.upgraded
+}
+
+// Listen returns the listener of the name inherited from the old process, or listens
+// on the address if there is none. The listener is passed to the new process on upgrade.
+func (h *Handover) Listen(name string, addr string) (net.Listener, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if f, ok := h.inherited[name]; ok {
+		delete(h.inherited, name)
+		listener, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to take over the listener %s", name)
+		}
+		log.Info("took over the listener from the old process", zap.String("name", name),
+			zap.Stringer("address", listener.Addr()))
+		h.listeners[name] = listener
+		return listener, nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	h.listeners[name] = listener
+	return listener, nil
+}
+
+// State returns the state of the key passed by the old process, empty if there is none.
+func (h *Handover) State(key string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.parentState[key]
+}
+
+// SetState sets the state of the key passed to the new process on upgrade.
+func (h *Handover) SetState(key string, value string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state[key] = value
+}
+
+// Ready tells the old process that the new process is ready to take over.
+// It does nothing if the process isn't started by an upgrade.
+func (h *Handover) Ready() error {
+	h.mu.Lock()
+	ready := h.ready
+	h.ready = nil
+	h.mu.Unlock()
+	if ready == nil {
+		return nil
+	}
+	_, err := ready.Write([]byte{1})
+	_ = ready.Close()
+	return errors.Annotate(err, "failed to tell the old process ready")
+}
+
+// WaitReleased waits for the old process to release the resources, e.g. the checkpoint,
+// which the new process takes over. It returns at once if the process isn't started by an upgrade.
+func (h *Handover) WaitReleased(ctx context.Context) error {
+	h.mu.Lock()
+	release := h.release
+	h.release = nil
+	h.mu.Unlock()
+	if release == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		// the old process closes the pipe on release, or exits.
+		_, _ = io.Copy(io.Discard, release)
+		close(done)
+	}()
+	defer release.Close()
+	select {
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	case <-done:
+		log.Info("released by the old process")
+		return nil
+	}
+}
+
+// Request requests an upgrade, which is handled by the receiver of Requested. The request
+// is dropped if there is one not handled yet.
+func (h *Handover) Request() {
+	select {
+	case h.requests <- struct{}{}:
+	default:
+	}
+}
+
+// Requested returns the channel receiving the upgrade requests.
+func (h *Handover) Requested() <-chan struct{} {
+	return h.requests
+}
+
+// Successor is the new process started by the upgrade, which is ready to take over.
+type Successor struct {
+	cmd     *exec.Cmd
+	release *os.File
+}
+
+// Pid returns the pid of the new process.
+func (s *Successor) Pid() int {
+	return s.cmd.Process.Pid
+}
+
+// Release lets the new process take over, it should be called after the old process
+// stopped using the resources taken over, e.g. the checkpoint.
+func (s *Successor) Release() error {
+	return errors.Trace(s.release.Close())
+}
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Upgrade starts the new process with the listeners and the state, and waits for it to be
+// ready. The new process is killed if it isn't ready before ReadyTimeout or ctx is done.
+func (h *Handover) Upgrade(ctx context.Context) (*Successor, error) {
+	h.mu.Lock()
+	if h.upgrading {
+		h.mu.Unlock()
+		return nil, errors.Annotate(berrors.ErrUnknown, "the process is being upgraded")
+	}
+	h.upgrading = true
+	listeners := make(map[string]net.Listener, len(h.listeners))
+	for name, listener := range h.listeners {
+		listeners[name] = listener
+	}
+	state, err := json.Marshal(h.state)
+	h.mu.Unlock()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	successor, err := h.startSuccessor(ctx, listeners, state)
+	if err != nil {
+		h.mu.Lock()
+		h.upgrading = false
+		h.mu.Unlock()
+		return nil, errors.Trace(err)
+	}
+	return successor, nil
+}
+
+func (h *Handover) startSuccessor(ctx context.Context, listeners map[string]net.Listener, state []byte) (*Successor, error) {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	// the fd of ExtraFiles[i] is 3+i in the new process.
+	names := make([]string, 0, len(listeners))
+	for name, listener := range listeners {
+		l, ok := listener.(filer)
+		if !ok {
+			return nil, errors.Annotatef(berrors.ErrUnknown, "the listener %s can't be passed", name)
+		}
+		f, err := l.File()
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to pass the listener %s", name)
+		}
+		files = append(files, f)
+		names = append(names, fmt.Sprintf("%s=%d", name, 2+len(files)))
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer readyR.Close()
+	releaseR, releaseW, err := os.Pipe()
+	if err != nil {
+		_ = readyW.Close()
+		return nil, errors.Trace(err)
+	}
+	files = append(files, readyW, releaseR)
+
+	cmd := exec.Command(h.executable, h.args...) // #nosec G204
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		envListeners+"="+strings.Join(names, ","),
+		fmt.Sprintf("%s=%d", envReadyFD, 2+len(files)-1),
+		fmt.Sprintf("%s=%d", envReleaseFD, 2+len(files)),
+		envState+"="+string(state),
+	)
+	if err = cmd.Start(); err != nil {
+		_ = releaseW.Close()
+		return nil, errors.Annotatef(err, "failed to start the new process %s", h.executable)
+	}
+	log.Info("started the new process", zap.String("binary", h.executable), zap.Int("pid", cmd.Process.Pid))
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyR.Read(buf)
+		ready <- err
+	}()
+	// close the ends of the new process, so that the read fails once it exits.
+	for _, f := range files {
+		_ = f.Close()
+	}
+	files = nil
+
+	timer := time.NewTimer(h.ReadyTimeout)
+	defer timer.Stop()
+	select {
+	case err = <-ready:
+		if err == nil {
+			log.Info("the new process is ready to take over", zap.Int("pid", cmd.Process.Pid))
+			return &Successor{cmd: cmd, release: releaseW}, nil
+		}
+		err = errors.Annotate(err, "the new process exited before ready")
+	case err = <-exited:
+		err = errors.Annotatef(berrors.ErrUnknown, "the new process exited before ready: %v", err)
+	case <-timer.C:
+		err = errors.Annotatef(berrors.ErrUnknown, "the new process isn't ready in %s", h.ReadyTimeout)
+	case <-ctx.Done():
+		err = errors.Trace(ctx.Err())
+	}
+	_ = cmd.Process.Kill()
+	_ = releaseW.Close()
+	return nil, err
+}
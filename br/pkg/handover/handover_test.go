@@ -0,0 +1,122 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handover
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// envHelper makes the test binary run as the new process of TestUpgrade.
+const envHelper = "BR_HANDOVER_TEST_HELPER"
+
+// TestHelperProcess is the new process started by the upgrade, which takes over the
+// listener and replies the inherited state to the first connection.
+func TestHelperProcess(t *testing.T) {
+	mode := os.Getenv(envHelper)
+	if len(mode) == 0 {
+		t.Skip("only run as the new process")
+	}
+	if mode == "exit" {
+		os.Exit(1)
+	}
+	h, err := newHandover(os.Args[0], nil, os.Getenv)
+	require.NoError(t, err)
+	require.True(t, h.IsUpgraded())
+	listener, err := h.Listen("server", "")
+	require.NoError(t, err)
+	require.NoError(t, h.Ready())
+	require.NoError(t, h.WaitReleased(context.Background()))
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("taken over: " + h.State("checkpoint")))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+}
+
+func TestUpgrade(t *testing.T) {
+	t.Setenv(envHelper, "take-over")
+	h, err := newHandover(os.Args[0], []string{"-test.run=TestHelperProcess"}, func(string) string { return "" })
+	require.NoError(t, err)
+	require.False(t, h.IsUpgraded())
+	// the process not started by an upgrade doesn't wait.
+	require.NoError(t, h.Ready())
+	require.NoError(t, h.WaitReleased(context.Background()))
+
+	listener, err := h.Listen("server", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	h.SetState("checkpoint", "42")
+	h.Request()
+	h.Request()
+	select {
+	case <-h.Requested():
+	default:
+		require.Fail(t, "the upgrade is not requested")
+	}
+
+	successor, err := h.Upgrade(context.Background())
+	require.NoError(t, err)
+	require.Greater(t, successor.Pid(), 0)
+	_, err = h.Upgrade(context.Background())
+	require.Error(t, err)
+
+	// the connections are queued until the new process takes over.
+	require.NoError(t, listener.Close())
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, successor.Release())
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(30*time.Second)))
+	reply, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "taken over: 42", string(reply))
+}
+
+func TestUpgradeFailed(t *testing.T) {
+	t.Setenv(envHelper, "exit")
+	h, err := newHandover(os.Args[0], []string{"-test.run=TestHelperProcess"}, func(string) string { return "" })
+	require.NoError(t, err)
+	_, err = h.Listen("server", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, err = h.Upgrade(context.Background())
+	require.Error(t, err)
+	require.Regexp(t, "exited before ready", err.Error())
+
+	// the upgrade can be retried.
+	h.ReadyTimeout = time.Millisecond
+	t.Setenv(envHelper, "take-over")
+	h.SetState("checkpoint", "42")
+	_, err = h.Upgrade(context.Background())
+	require.Error(t, err)
+}
+
+func TestInvalidInheritance(t *testing.T) {
+	for _, env := range []map[string]string{
+		{envListeners: "server"},
+		{envListeners: "server=x"},
+		{envState: "{"},
+		{envReadyFD: "x"},
+	} {
+		_, err := newHandover("br", nil, func(k string) string { return env[k] })
+		require.Error(t, err, env)
+	}
+}
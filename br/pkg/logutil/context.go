@@ -4,6 +4,7 @@ package logutil
 
 import (
 	"context"
+	"sync/atomic"
 
 	"github.com/pingcap/log"
 	"go.uber.org/zap"
@@ -22,6 +23,52 @@ func ResetGlobalLogger(l *zap.Logger) {
 	globalLogger = l
 }
 
+// The common fields annotating the logs of a task, so that the logs of multiple
+// tasks can be told apart after they are aggregated.
+const (
+	// FieldRunID identifies a run of br, it's the same in all logs of the process.
+	FieldRunID = "run-id"
+	// FieldPhase is the phase of the task, e.g. backup, restore or checksum. Only the
+	// logs by CL of the context wrapped by ContextWithPhase carry it.
+	FieldPhase = "phase"
+	// FieldRangeSN is the serial number of the range being backed up or restored. Only
+	// the logs by CL of the context wrapped by ContextWithRangeSN carry it.
+	FieldRangeSN = "range-sn"
+)
+
+var runID atomic.Value
+
+// SetRunID sets the run ID of the process.
+func SetRunID(id string) {
+	runID.Store(id)
+}
+
+// RunID returns the run ID of the process, empty if not set.
+func RunID() string {
+	id, _ := runID.Load().(string)
+	return id
+}
+
+// ContextWithPhase wraps a context with a logger annotating the phase of the task.
+func ContextWithPhase(c context.Context, phase string) context.Context {
+	return ContextWithField(c, zap.String(FieldPhase, phase))
+}
+
+type rangeSNContextKey struct{}
+
+// ContextWithRangeSN wraps a context with a logger annotating the serial number of the range.
+// The serial number can be read by RangeSNFromContext as well.
+func ContextWithRangeSN(c context.Context, sn int) context.Context {
+	c = context.WithValue(c, rangeSNContextKey{}, sn)
+	return ContextWithField(c, RedactAny(FieldRangeSN, sn))
+}
+
+// RangeSNFromContext returns the serial number of the range set by ContextWithRangeSN.
+func RangeSNFromContext(c context.Context) (int, bool) {
+	sn, ok := c.Value(rangeSNContextKey{}).(int)
+	return sn, ok
+}
+
 type loggingContextKey struct{}
 
 var keyLogger loggingContextKey = loggingContextKey{}
@@ -0,0 +1,148 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logutil
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// DefaultDedupInterval is the default interval in which the repeats of a message are counted
+	// instead of logged.
+	DefaultDedupInterval = 10 * time.Second
+	// DefaultDedupBurst is the default number of the repeats of a message logged as is in an interval.
+	DefaultDedupBurst = 5
+)
+
+// FieldSuppressed is the number of the repeats of a message suppressed in an interval.
+const FieldSuppressed = "suppressed"
+
+// Deduper deduplicates the repeated messages logged per region or range, e.g. "range backed up",
+// which would otherwise fill tens of GB of logs in a large backup. In each interval, the first
+// burst repeats of a message are logged as is, and the others are only counted. The count is
+// logged with the message once the interval elapses, or on Flush.
+type Deduper struct {
+	interval time.Duration
+	burst    int
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	logger     *zap.Logger
+	level      zapcore.Level
+	start      time.Time
+	logged     int
+	suppressed int
+}
+
+// NewDeduper creates a Deduper. The messages are never suppressed if the interval is not positive.
+func NewDeduper(interval time.Duration, burst int) *Deduper {
+	return &Deduper{
+		interval: interval,
+		burst:    burst,
+		now:      time.Now,
+		entries:  make(map[string]*dedupEntry),
+	}
+}
+
+// Log logs the message by the logger at the level, unless the message has been logged burst
+// times in the current interval.
+func (d *Deduper) Log(logger *zap.Logger, level zapcore.Level, msg string, fields ...zap.Field) {
+	d.log(logger.WithOptions(zap.AddCallerSkip(1)), level, msg, fields...)
+}
+
+func (d *Deduper) log(logger *zap.Logger, level zapcore.Level, msg string, fields ...zap.Field) {
+	if d.interval <= 0 {
+		write(logger, level, msg, fields...)
+		return
+	}
+	now := d.now()
+	d.mu.Lock()
+	e, ok := d.entries[msg]
+	if !ok {
+		e = &dedupEntry{start: now}
+		d.entries[msg] = e
+	}
+	if now.Sub(e.start) >= d.interval {
+		d.flushEntry(msg, e)
+		e.start, e.logged = now, 0
+	}
+	e.logger, e.level = logger, level
+	if e.logged >= d.burst {
+		e.suppressed++
+		d.mu.Unlock()
+		return
+	}
+	e.logged++
+	d.mu.Unlock()
+	write(logger, level, msg, fields...)
+}
+
+// Flush logs the counts of the suppressed messages of the current intervals.
+func (d *Deduper) Flush() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for msg, e := range d.entries {
+		d.flushEntry(msg, e)
+	}
+}
+
+// flushEntry logs the count of the suppressed repeats of the message, it must be called with the
+// lock held.
+func (d *Deduper) flushEntry(msg string, e *dedupEntry) {
+	if e.suppressed == 0 {
+		return
+	}
+	write(e.logger, e.level, msg, zap.Int(FieldSuppressed, e.suppressed), zap.Duration("interval", d.interval))
+	e.suppressed = 0
+}
+
+func write(logger *zap.Logger, level zapcore.Level, msg string, fields ...zap.Field) {
+	if ce := logger.Check(level, msg); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+var globalDeduper atomic.Value
+
+func init() {
+	globalDeduper.Store(NewDeduper(DefaultDedupInterval, DefaultDedupBurst))
+}
+
+// InitDeduper replaces the deduper used by DedupInfo, the suppressed messages of the old one are
+// flushed.
+func InitDeduper(interval time.Duration, burst int) {
+	old := globalDeduper.Swap(NewDeduper(interval, burst))
+	old.(*Deduper).Flush()
+}
+
+// FlushDedup logs the counts of the messages suppressed by DedupInfo, it should be called before
+// the process exits.
+func FlushDedup() {
+	globalDeduper.Load().(*Deduper).Flush()
+}
+
+// DedupInfo logs the message at info level by the logger, deduplicated by the global deduper.
+func DedupInfo(logger *zap.Logger, msg string, fields ...zap.Field) {
+	globalDeduper.Load().(*Deduper).log(logger.WithOptions(zap.AddCallerSkip(1)), zapcore.InfoLevel, msg, fields...)
+}
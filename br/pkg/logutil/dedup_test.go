@@ -0,0 +1,69 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDeduper(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core)
+	now := time.Unix(0, 0)
+	d := NewDeduper(10*time.Second, 2)
+	d.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		d.Log(logger, zapcore.InfoLevel, "range backed up", zap.Int("i", i))
+	}
+	d.Log(logger, zapcore.InfoLevel, "find leader")
+	d.Log(logger, zapcore.DebugLevel, "debug")
+	require.Equal(t, 3, logs.Len())
+	require.Equal(t, 2, logs.FilterMessage("range backed up").Len())
+
+	// the suppressed repeats are counted once the interval elapses.
+	now = now.Add(10 * time.Second)
+	d.Log(logger, zapcore.InfoLevel, "range backed up", zap.Int("i", 5))
+	entries := logs.FilterMessage("range backed up").AllUntimed()
+	require.Len(t, entries, 4)
+	require.Equal(t, int64(3), entries[2].ContextMap()[FieldSuppressed])
+	require.Equal(t, int64(5), entries[3].ContextMap()["i"])
+
+	d.Log(logger, zapcore.InfoLevel, "range backed up")
+	d.Log(logger, zapcore.InfoLevel, "range backed up")
+	d.Flush()
+	entries = logs.FilterMessage("range backed up").AllUntimed()
+	require.Len(t, entries, 6)
+	require.Equal(t, int64(1), entries[5].ContextMap()[FieldSuppressed])
+	d.Flush()
+	require.Equal(t, 7, logs.Len())
+}
+
+func TestDeduperDisabled(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core)
+	d := NewDeduper(0, 2)
+	for i := 0; i < 5; i++ {
+		d.Log(logger, zapcore.InfoLevel, "range backed up")
+	}
+	d.Flush()
+	require.Equal(t, 5, logs.Len())
+}
@@ -183,3 +183,25 @@ func checkLog(t *testing.T, actual observer.LoggedEntry, message string, fields
 		require.Truef(t, f.Equals(actual.Context[i]), "Expected field(%+v) does not equal to actual one(%+v).", f, actual.Context[i])
 	}
 }
+
+func TestContextWithPhase(t *testing.T) {
+	testCore, logs := observer.New(zap.InfoLevel)
+	logutil.ResetGlobalLogger(zap.New(testCore))
+	defer logutil.ResetGlobalLogger(nil)
+
+	ctx := logutil.ContextWithPhase(context.Background(), "backup")
+	rangeCtx := logutil.ContextWithRangeSN(ctx, 3)
+	logutil.CL(rangeCtx).Info("backup range finished")
+	sn, ok := logutil.RangeSNFromContext(rangeCtx)
+	require.True(t, ok)
+	require.Equal(t, 3, sn)
+	_, ok = logutil.RangeSNFromContext(ctx)
+	require.False(t, ok)
+
+	observedLogs := logs.TakeAll()
+	checkLog(t, observedLogs[0], "backup range finished",
+		zap.String(logutil.FieldPhase, "backup"), zap.Int(logutil.FieldRangeSN, 3))
+
+	logutil.SetRunID("run-1")
+	require.Equal(t, "run-1", logutil.RunID())
+}
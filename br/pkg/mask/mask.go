@@ -0,0 +1,139 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mask masks the values of raw kv pairs by the rules configured per key prefix,
+// so that the production data can seed non-production clusters without exposing the
+// sensitive values.
+//
+// The masking is applied to the pairs read one by one, i.e. by the logical restore of
+// `br restore raw --mask-rules`. The physical restore ingests the SST files as is, so it
+// cannot mask them.
+package mask
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+// Method is the way a value is masked.
+type Method string
+
+const (
+	// MethodHash replaces the value by the hex of its sha256, which keeps the equal values equal.
+	MethodHash Method = "hash"
+	// MethodTruncate keeps the first Length bytes of the value.
+	MethodTruncate Method = "truncate"
+	// MethodNull replaces the value by an empty one.
+	MethodNull Method = "null"
+)
+
+// Rule masks the values of the keys with the prefix, the keys are the user keys, i.e. without
+// the API V2 prefix.
+type Rule struct {
+	Prefix string `json:"prefix" toml:"prefix"`
+	// Format is the format of Prefix, the same as the one of `--format`, "hex" by default.
+	Format string `json:"format" toml:"format"`
+	Method Method `json:"method" toml:"method"`
+	// Length is the bytes kept by MethodTruncate.
+	Length int `json:"length" toml:"length"`
+
+	prefix []byte
+}
+
+// Masker masks the values by the rule of the longest matched prefix.
+type Masker struct {
+	rules []Rule
+}
+
+// NewMasker validates the rules and creates a masker.
+func NewMasker(rules []Rule) (*Masker, error) {
+	m := &Masker{rules: make([]Rule, 0, len(rules))}
+	for _, rule := range rules {
+		format := rule.Format
+		if len(format) == 0 {
+			format = "hex"
+		}
+		prefix, err := utils.ParseKey(format, rule.Prefix)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid prefix '%s' of the mask rule", rule.Prefix)
+		}
+		rule.prefix = prefix
+		switch rule.Method {
+		case MethodHash, MethodNull:
+		case MethodTruncate:
+			if rule.Length < 0 {
+				return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+					"the length of the truncate mask rule of prefix '%s' must not be negative", rule.Prefix)
+			}
+		default:
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"unknown mask method '%s' of prefix '%s', available options: hash, truncate, null", rule.Method, rule.Prefix)
+		}
+		m.rules = append(m.rules, rule)
+	}
+	// the longest prefix goes first.
+	sort.SliceStable(m.rules, func(i, j int) bool {
+		return len(m.rules[i].prefix) > len(m.rules[j].prefix)
+	})
+	return m, nil
+}
+
+// Mask returns the masked value of the key, the value is returned as is if no rule matches.
+func (m *Masker) Mask(key, value []byte) []byte {
+	for i := range m.rules {
+		rule := &m.rules[i]
+		if !bytes.HasPrefix(key, rule.prefix) {
+			continue
+		}
+		switch rule.Method {
+		case MethodHash:
+			sum := sha256.Sum256(value)
+			return []byte(hex.EncodeToString(sum[:]))
+		case MethodTruncate:
+			if len(value) > rule.Length {
+				return value[:rule.Length]
+			}
+			return value
+		case MethodNull:
+			return []byte{}
+		}
+	}
+	return value
+}
+
+// LoadRules loads the [[rules]] of the TOML file, e.g.
+//
+//	[[rules]]
+//	prefix = "user_card_"
+//	format = "raw"
+//	method = "hash"
+func LoadRules(path string) ([]Rule, error) {
+	var file struct {
+		Rules []Rule `toml:"rules"`
+	}
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to load mask rules file %s: %v", path, err)
+	}
+	if len(file.Rules) == 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "no [[rules]] in %s", path)
+	}
+	return file.Rules, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mask
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMasker(t *testing.T) {
+	m, err := NewMasker([]Rule{
+		{Prefix: "user_", Format: "raw", Method: MethodTruncate, Length: 2},
+		{Prefix: "user_card_", Format: "raw", Method: MethodHash},
+		{Prefix: "7365637265745f", Method: MethodNull}, // "secret_"
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []byte("al"), m.Mask([]byte("user_1"), []byte("alice")))
+	require.Equal(t, []byte("a"), m.Mask([]byte("user_2"), []byte("a")))
+	// the longest prefix wins.
+	hashed := m.Mask([]byte("user_card_1"), []byte("4111111111111111"))
+	require.Len(t, hashed, 64)
+	require.Equal(t, hashed, m.Mask([]byte("user_card_2"), []byte("4111111111111111")))
+	require.Equal(t, []byte{}, m.Mask([]byte("secret_1"), []byte("password")))
+	require.Equal(t, []byte("public"), m.Mask([]byte("other"), []byte("public")))
+
+	_, err = NewMasker([]Rule{{Prefix: "a", Format: "raw", Method: "encrypt"}})
+	require.Error(t, err)
+	_, err = NewMasker([]Rule{{Prefix: "a", Format: "raw", Method: MethodTruncate, Length: -1}})
+	require.Error(t, err)
+	_, err = NewMasker([]Rule{{Prefix: "not hex", Method: MethodNull}})
+	require.Error(t, err)
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mask.toml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+[[rules]]
+prefix = "user_"
+format = "raw"
+method = "truncate"
+length = 2
+
+[[rules]]
+prefix = "7365637265745f"
+method = "null"
+`), 0o600))
+	rules, err := LoadRules(path)
+	require.NoError(t, err)
+	require.Equal(t, []Rule{
+		{Prefix: "user_", Format: "raw", Method: MethodTruncate, Length: 2},
+		{Prefix: "7365637265745f", Method: MethodNull},
+	}, rules)
+
+	require.NoError(t, os.WriteFile(path, []byte(`method = "null"`), 0o600))
+	_, err = LoadRules(path)
+	require.Error(t, err)
+}
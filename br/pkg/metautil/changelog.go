@@ -0,0 +1,122 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metautil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// The changelog is the change data of an API V2 cluster in an external storage, written by
+// the changelog sink of TiKV-CDC or by `br stream`, and replayed by `br restore raw`.
+// The layout is shared with TiKV-CDC, keep them in sync.
+const (
+	// ChangelogDir is the directory of the changelog files. A file is named by the
+	// min and the max commit ts of its events, the writer and the sequence of the file:
+	// changelog/{min-ts:016x}-{max-ts:016x}-{writer-id}-{seq}.log
+	ChangelogDir        = "changelog"
+	changelogFileSuffix = ".log"
+	// ChangelogMetaFile records the commit ts range covered by the changelog files.
+	ChangelogMetaFile    = "changelog.meta.json"
+	changelogMetaVersion = 1
+
+	// ChangelogOpPut and ChangelogOpDelete are the op types of the changelog events.
+	ChangelogOpPut    = "put"
+	ChangelogOpDelete = "delete"
+)
+
+// ChangelogMeta is the content of ChangelogMetaFile. All the events whose commit ts
+// are in (StartTS, CheckpointTS] are in the changelog files.
+type ChangelogMeta struct {
+	Version      int    `json:"version"`
+	StartTS      uint64 `json:"start-ts"`
+	CheckpointTS uint64 `json:"checkpoint-ts"`
+}
+
+// ChangelogEvent is a line of the changelog files in JSON.
+type ChangelogEvent struct {
+	OpType string `json:"op"`
+	// Key is the API V2 key, with the prefix of the keyspace.
+	Key       []byte `json:"key"`
+	Value     []byte `json:"value,omitempty"`
+	CommitTS  uint64 `json:"ts"`
+	ExpiredTS uint64 `json:"expired-ts,omitempty"`
+}
+
+// ChangelogFileName returns the path of a changelog file of the events in [minTS, maxTS].
+func ChangelogFileName(minTS, maxTS uint64, writerID string, seq uint64) string {
+	return fmt.Sprintf("%s/%016x-%016x-%s-%d%s", ChangelogDir, minTS, maxTS, writerID, seq, changelogFileSuffix)
+}
+
+// ParseChangelogFileName returns the min and the max commit ts of the events in the file,
+// the name is the base name of the file.
+func ParseChangelogFileName(name string) (minTS, maxTS uint64, ok bool) {
+	if !strings.HasSuffix(name, changelogFileSuffix) {
+		return 0, 0, false
+	}
+	if _, err := fmt.Sscanf(name, "%016x-%016x-", &minTS, &maxTS); err != nil {
+		return 0, 0, false
+	}
+	return minTS, maxTS, true
+}
+
+// EncodeChangelogEvents encodes the events into the content of a changelog file.
+func EncodeChangelogEvents(events []*ChangelogEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteChangelogMeta writes the meta of the changelog into the storage.
+func WriteChangelogMeta(ctx context.Context, s storage.ExternalStorage, meta *ChangelogMeta) error {
+	meta.Version = changelogMetaVersion
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, ChangelogMetaFile, data))
+}
+
+// ReadChangelogMeta reads the meta of the changelog in the storage, it returns nil if there is none.
+func ReadChangelogMeta(ctx context.Context, s storage.ExternalStorage) (*ChangelogMeta, error) {
+	exists, err := s.FileExists(ctx, ChangelogMetaFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, ChangelogMetaFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	meta := &ChangelogMeta{}
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", ChangelogMetaFile, err)
+	}
+	if meta.Version > changelogMetaVersion {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile,
+			"unsupported version %d of %s, the latest supported one is %d", meta.Version, ChangelogMetaFile, changelogMetaVersion)
+	}
+	return meta, nil
+}
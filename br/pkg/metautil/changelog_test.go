@@ -0,0 +1,56 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metautil
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestChangelogMeta(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	meta, err := ReadChangelogMeta(ctx, s)
+	require.NoError(t, err)
+	require.Nil(t, meta)
+
+	require.NoError(t, WriteChangelogMeta(ctx, s, &ChangelogMeta{StartTS: 90, CheckpointTS: 300}))
+	meta, err = ReadChangelogMeta(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, &ChangelogMeta{Version: changelogMetaVersion, StartTS: 90, CheckpointTS: 300}, meta)
+
+	require.NoError(t, s.WriteFile(ctx, ChangelogMetaFile, []byte(`{"version":2,"start-ts":90,"checkpoint-ts":300}`)))
+	_, err = ReadChangelogMeta(ctx, s)
+	require.Error(t, err)
+}
+
+func TestChangelogFileName(t *testing.T) {
+	name := ChangelogFileName(100, 4096, "writer", 7)
+	require.Equal(t, "changelog/0000000000000064-0000000000001000-writer-7.log", name)
+	minTS, maxTS, ok := ParseChangelogFileName(path.Base(name))
+	require.True(t, ok)
+	require.Equal(t, uint64(100), minTS)
+	require.Equal(t, uint64(4096), maxTS)
+
+	for _, name := range []string{"0000000000000064-0000000000001000-writer-7.tmp", "unknown.log"} {
+		_, _, ok = ParseChangelogFileName(name)
+		require.False(t, ok, name)
+	}
+}
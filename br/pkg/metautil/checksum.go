@@ -0,0 +1,152 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// ChecksumAlgorithm is the hash algorithm of the checksums computed by BR, i.e. the ones of
+// the meta files and of the parent backupmeta. The checksums of the SST files and of the
+// ranges are computed by TiKV, which are always sha256 and crc64.
+type ChecksumAlgorithm byte
+
+const (
+	// ChecksumSHA256 is the default algorithm, which is required in FIPS environments.
+	ChecksumSHA256 ChecksumAlgorithm = iota
+	// ChecksumXXHash64 is much faster than sha256, but isn't a cryptographic hash.
+	ChecksumXXHash64
+)
+
+var checksumAlgorithmNames = map[ChecksumAlgorithm]string{
+	ChecksumSHA256:   "sha256",
+	ChecksumXXHash64: "xxhash64",
+}
+
+// ParseChecksumAlgorithm parses the checksum algorithm from its name, i.e. sha256 or xxhash64.
+// The empty name is sha256, which is the algorithm of the backups taken by older versions.
+func ParseChecksumAlgorithm(name string) (ChecksumAlgorithm, error) {
+	if len(name) == 0 {
+		return ChecksumSHA256, nil
+	}
+	for a, aName := range checksumAlgorithmNames {
+		if strings.EqualFold(name, aName) {
+			return a, nil
+		}
+	}
+	return ChecksumSHA256, errors.Annotatef(berrors.ErrInvalidArgument,
+		"invalid checksum algorithm '%s', must be one of sha256|xxhash64", name)
+}
+
+func (a ChecksumAlgorithm) String() string {
+	if name, ok := checksumAlgorithmNames[a]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Size returns the size of the checksums of the algorithm.
+func (a ChecksumAlgorithm) Size() int {
+	if a == ChecksumXXHash64 {
+		return 8
+	}
+	return sha256.Size
+}
+
+// Sum returns the checksum of the data.
+func (a ChecksumAlgorithm) Sum(data []byte) []byte {
+	if a == ChecksumXXHash64 {
+		sum := make([]byte, 8)
+		binary.BigEndian.PutUint64(sum, xxhash.Sum64(data))
+		return sum
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// ChecksumAlgorithmOf tells the algorithm of a checksum by its size. The meta files keep
+// their checksums in the Sha256 field of backuppb.File whatever the algorithm is.
+func ChecksumAlgorithmOf(checksum []byte) (ChecksumAlgorithm, error) {
+	for a := range checksumAlgorithmNames {
+		if len(checksum) == a.Size() {
+			return a, nil
+		}
+	}
+	return ChecksumSHA256, errors.Annotatef(berrors.ErrInvalidMetaFile,
+		"unknown checksum algorithm of the %d bytes checksum, please upgrade BR", len(checksum))
+}
+
+// verifyChecksum checks that the data matches the checksum computed by any algorithm.
+func verifyChecksum(data, checksum []byte) error {
+	a, err := ChecksumAlgorithmOf(checksum)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if sum := a.Sum(data); !bytes.Equal(checksum, sum) {
+		return errors.Annotatef(berrors.ErrInvalidMetaFile,
+			"%s checksum mismatch expect %x, got %x", a, checksum, sum)
+	}
+	return nil
+}
+
+// ChecksumFile is the file recording the checksum algorithm of the backup. The backups
+// without it use sha256.
+const ChecksumFile = "backup.checksum.json"
+
+type checksumAlgorithmRecord struct {
+	Algorithm string `json:"algorithm"`
+}
+
+// WriteChecksumAlgorithm records the checksum algorithm of the backup into the backup storage.
+func WriteChecksumAlgorithm(ctx context.Context, s storage.ExternalStorage, a ChecksumAlgorithm) error {
+	data, err := json.MarshalIndent(&checksumAlgorithmRecord{Algorithm: a.String()}, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, ChecksumFile, data))
+}
+
+// ReadChecksumAlgorithm reads the checksum algorithm of the backup, it returns sha256 if
+// the backup doesn't record it.
+func ReadChecksumAlgorithm(ctx context.Context, s storage.ExternalStorage) (ChecksumAlgorithm, error) {
+	exists, err := s.FileExists(ctx, ChecksumFile)
+	if err != nil || !exists {
+		return ChecksumSHA256, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, ChecksumFile)
+	if err != nil {
+		return ChecksumSHA256, errors.Trace(err)
+	}
+	record := &checksumAlgorithmRecord{}
+	if err = json.Unmarshal(data, record); err != nil {
+		return ChecksumSHA256, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", ChecksumFile, err)
+	}
+	a, err := ParseChecksumAlgorithm(record.Algorithm)
+	if err != nil {
+		return ChecksumSHA256, errors.Annotatef(berrors.ErrInvalidMetaFile,
+			"unsupported checksum algorithm '%s' in %s, please upgrade BR", record.Algorithm, ChecksumFile)
+	}
+	return a, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestParseChecksumAlgorithm(t *testing.T) {
+	for name, expected := range map[string]ChecksumAlgorithm{
+		"":         ChecksumSHA256,
+		"sha256":   ChecksumSHA256,
+		"XXHash64": ChecksumXXHash64,
+	} {
+		a, err := ParseChecksumAlgorithm(name)
+		require.NoError(t, err)
+		require.Equal(t, expected, a)
+	}
+	_, err := ParseChecksumAlgorithm("md5")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	data := []byte("backupmeta.datafile.000000001")
+	for _, a := range []ChecksumAlgorithm{ChecksumSHA256, ChecksumXXHash64} {
+		sum := a.Sum(data)
+		require.Len(t, sum, a.Size())
+		tp, err := ChecksumAlgorithmOf(sum)
+		require.NoError(t, err)
+		require.Equal(t, a, tp)
+		require.NoError(t, verifyChecksum(data, sum))
+		require.True(t, berrors.Is(verifyChecksum([]byte("corrupted"), sum), berrors.ErrInvalidMetaFile))
+	}
+	require.True(t, berrors.Is(verifyChecksum(data, []byte{1, 2, 3}), berrors.ErrInvalidMetaFile))
+}
+
+func TestChecksumAlgorithmFile(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	a, err := ReadChecksumAlgorithm(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, ChecksumSHA256, a)
+
+	require.NoError(t, WriteChecksumAlgorithm(ctx, s, ChecksumXXHash64))
+	a, err = ReadChecksumAlgorithm(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, ChecksumXXHash64, a)
+
+	require.NoError(t, s.WriteFile(ctx, ChecksumFile, []byte(`{"algorithm": "blake3"}`)))
+	_, err = ReadChecksumAlgorithm(ctx, s)
+	require.True(t, berrors.Is(err, berrors.ErrInvalidMetaFile))
+}
@@ -0,0 +1,148 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metautil
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// MetaCompressionType is the compression algorithm of backupmeta and the meta files.
+type MetaCompressionType byte
+
+const (
+	// MetaCompressionNone keeps the meta files as they are.
+	MetaCompressionNone MetaCompressionType = iota
+	// MetaCompressionGzip compresses the meta files by gzip.
+	MetaCompressionGzip
+	// MetaCompressionZstd compresses the meta files by zstd.
+	MetaCompressionZstd
+)
+
+const (
+	// MetaCompressionFormatV1 is the version of the compressed meta file layout, which is
+	// the magic, the format version, the compression type and the compressed content.
+	MetaCompressionFormatV1 = 1
+
+	// metaCompressionMagic prefixes the compressed meta files. A protobuf message never starts
+	// with a zero byte, so it tells the compressed files from the plain ones written before.
+	metaCompressionMagic     = "\x00BRZ"
+	metaCompressionHeaderLen = len(metaCompressionMagic) + 2
+)
+
+var metaCompressionNames = map[MetaCompressionType]string{
+	MetaCompressionNone: "none",
+	MetaCompressionGzip: "gzip",
+	MetaCompressionZstd: "zstd",
+}
+
+// ParseMetaCompressionType parses the compression type from its name, i.e. none, gzip or zstd.
+func ParseMetaCompressionType(name string) (MetaCompressionType, error) {
+	for tp, tpName := range metaCompressionNames {
+		if strings.EqualFold(name, tpName) {
+			return tp, nil
+		}
+	}
+	return MetaCompressionNone, errors.Annotatef(berrors.ErrInvalidArgument,
+		"invalid meta compression type '%s', must be one of none|gzip|zstd", name)
+}
+
+func (tp MetaCompressionType) String() string {
+	if name, ok := metaCompressionNames[tp]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// CompressMeta compresses the content of a meta file and prefixes it with the header, the
+// content is returned as it is if tp is MetaCompressionNone.
+func CompressMeta(content []byte, tp MetaCompressionType) ([]byte, error) {
+	if tp == MetaCompressionNone {
+		return content, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, metaCompressionHeaderLen+len(content)/4))
+	buf.WriteString(metaCompressionMagic)
+	buf.WriteByte(MetaCompressionFormatV1)
+	buf.WriteByte(byte(tp))
+
+	var w io.WriteCloser
+	switch tp {
+	case MetaCompressionGzip:
+		w = gzip.NewWriter(buf)
+	case MetaCompressionZstd:
+		encoder, err := zstd.NewWriter(buf)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		w = encoder
+	default:
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid meta compression type %d", tp)
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecompressMeta decompresses the content of a meta file written by CompressMeta, the content
+// without the header, i.e. not compressed, is returned as it is.
+func DecompressMeta(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(metaCompressionMagic)) {
+		return data, nil
+	}
+	if len(data) < metaCompressionHeaderLen {
+		return nil, errors.Annotate(berrors.ErrInvalidMetaFile, "truncated compression header")
+	}
+	version, tp := data[len(metaCompressionMagic)], MetaCompressionType(data[len(metaCompressionMagic)+1])
+	if version != MetaCompressionFormatV1 {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile,
+			"unsupported meta compression format version %d, please upgrade BR", version)
+	}
+	payload := bytes.NewReader(data[metaCompressionHeaderLen:])
+
+	var r io.Reader
+	switch tp {
+	case MetaCompressionGzip:
+		gr, err := gzip.NewReader(payload)
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to decompress by gzip: %v", err)
+		}
+		defer gr.Close()
+		r = gr
+	case MetaCompressionZstd:
+		zr, err := zstd.NewReader(payload)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile,
+			"unsupported meta compression type %d, please upgrade BR", tp)
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to decompress by %s: %v", tp, err)
+	}
+	return content, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metautil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestCompressMeta(t *testing.T) {
+	content := bytes.Repeat([]byte("backupmeta"), 1024)
+	for _, name := range []string{"none", "gzip", "ZSTD"} {
+		tp, err := ParseMetaCompressionType(name)
+		require.NoError(t, err)
+		compressed, err := CompressMeta(content, tp)
+		require.NoError(t, err)
+		if tp == MetaCompressionNone {
+			require.Equal(t, content, compressed)
+		} else {
+			require.Less(t, len(compressed), len(content)/10)
+		}
+		decompressed, err := DecompressMeta(compressed)
+		require.NoError(t, err)
+		require.Equal(t, content, decompressed)
+	}
+
+	_, err := ParseMetaCompressionType("lz4")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+
+	compressed, err := CompressMeta(content, MetaCompressionZstd)
+	require.NoError(t, err)
+	unknownVersion := append([]byte{}, compressed...)
+	unknownVersion[len(metaCompressionMagic)] = MetaCompressionFormatV1 + 1
+	_, err = DecompressMeta(unknownVersion)
+	require.True(t, berrors.Is(err, berrors.ErrInvalidMetaFile))
+	unknownType := append([]byte{}, compressed...)
+	unknownType[len(metaCompressionMagic)+1] = 0xff
+	_, err = DecompressMeta(unknownType)
+	require.True(t, berrors.Is(err, berrors.ErrInvalidMetaFile))
+	_, err = DecompressMeta(compressed[:len(compressed)/2])
+	require.True(t, berrors.Is(err, berrors.ErrInvalidMetaFile))
+}
+
+func TestMetaWriterCompression(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	cipher := &backuppb.CipherInfo{
+		CipherType: encryptionpb.EncryptionMethod_AES128_CTR,
+		CipherKey:  []byte("0123456789abcdef"),
+	}
+
+	metaWriter := NewMetaWriter(s, 1024, true, cipher)
+	metaWriter.SetCompression(MetaCompressionZstd)
+	metaWriter.StartWriteMetasAsync(ctx, AppendDataFile)
+	for i := 0; i < 100; i++ {
+		err = metaWriter.Send([]*backuppb.File{{
+			Name:  fmt.Sprintf("%05d.sst", i),
+			Size_: 100,
+		}}, AppendDataFile)
+		require.NoError(t, err)
+	}
+	require.NoError(t, metaWriter.FinishWriteMetas(ctx, AppendDataFile))
+	require.NoError(t, metaWriter.FlushBackupMeta(ctx))
+
+	index := metaWriter.Backupmeta().FileIndex
+	require.NotEmpty(t, index.MetaFiles)
+	var names []string
+	err = walkLeafMetaFile(ctx, s, index, cipher, func(m *backuppb.MetaFile) {
+		for _, f := range m.DataFiles {
+			names = append(names, f.Name)
+		}
+	})
+	require.NoError(t, err)
+	require.Len(t, names, 100)
+	require.Equal(t, "00099.sst", names[99])
+
+	data, err := s.ReadFile(ctx, MetaFile)
+	require.NoError(t, err)
+	decrypted, err := Decrypt(data[CrypterIvLen:], cipher, data[:CrypterIvLen])
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(decrypted, []byte(metaCompressionMagic)))
+	content, err := DecompressMeta(decrypted)
+	require.NoError(t, err)
+	backupMeta := &backuppb.BackupMeta{}
+	require.NoError(t, backupMeta.Unmarshal(content))
+	require.Len(t, backupMeta.FileIndex.MetaFiles, len(index.MetaFiles))
+}
@@ -0,0 +1,64 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// EncryptionFile is the data key of the backup wrapped by the master key. It's kept aside
+// backupmeta, because backupmeta is encrypted by the data key.
+const EncryptionFile = "backup.encryption.json"
+
+// Encryption is the envelope encryption of a backup.
+type Encryption struct {
+	// MasterKey is the URL of the master key wrapping the data key, without the query parameters.
+	MasterKey string `json:"master-key"`
+	// Method is the encryption method of the data key, e.g. "aes256-ctr".
+	Method string `json:"method"`
+	// WrappedKey is the data key encrypted by the master key.
+	WrappedKey []byte `json:"wrapped-key"`
+}
+
+// WriteEncryption writes the wrapped data key into the backup storage.
+func WriteEncryption(ctx context.Context, s storage.ExternalStorage, e *Encryption) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, EncryptionFile, data))
+}
+
+// ReadEncryption reads the wrapped data key from the backup storage, it returns nil if
+// the backup isn't encrypted by a master key.
+func ReadEncryption(ctx context.Context, s storage.ExternalStorage) (*Encryption, error) {
+	exists, err := s.FileExists(ctx, EncryptionFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, EncryptionFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	e := &Encryption{}
+	if err = json.Unmarshal(data, e); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", EncryptionFile, err)
+	}
+	return e, nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metautil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestEncryption(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	e, err := ReadEncryption(ctx, s)
+	require.NoError(t, err)
+	require.Nil(t, e)
+
+	encryption := &Encryption{MasterKey: "aws-kms:///alias/br", Method: "aes256-ctr", WrappedKey: []byte{1, 2, 3}}
+	require.NoError(t, WriteEncryption(ctx, s, encryption))
+	e, err = ReadEncryption(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, encryption, e)
+
+	require.NoError(t, s.WriteFile(ctx, EncryptionFile, []byte("{")))
+	_, err = ReadEncryption(ctx, s)
+	require.True(t, berrors.Is(err, berrors.ErrInvalidMetaFile))
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"runtime/metrics"
+
+	"github.com/docker/go-units"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultFlushItems is the default limit of the data files buffered by MetaWriter before
+	// they are flushed into a meta file.
+	DefaultFlushItems = 64 * 1024
+	// DefaultFlushMinSize is the default size of the buffered meta below which the memory of
+	// the process doesn't trigger a flush, so that it doesn't produce lots of tiny meta files.
+	DefaultFlushMinSize = 256 * units.KiB
+
+	// the memory of the process is sampled once the buffered items grow by it.
+	memorySampleItems = 1024
+	heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+)
+
+// The reasons of flushing the buffered meta.
+const (
+	flushReasonSize   = "size"
+	flushReasonItems  = "items"
+	flushReasonMemory = "memory"
+	flushReasonFinish = "finish"
+)
+
+var (
+	metaFlushCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tikv_br",
+			Subsystem: "meta",
+			Name:      "flush_total",
+			Help:      "The meta files flushed by BR, by the reason of the flush.",
+		}, []string{"reason"})
+
+	metaFlushBytesHistogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "tikv_br",
+			Subsystem: "meta",
+			Name:      "flush_bytes",
+			Help:      "The size distributions of the meta files flushed by BR, before compressed.",
+			Buckets:   prometheus.ExponentialBuckets(4*units.KiB, 2, 16),
+		})
+
+	metaFlushItemsHistogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "tikv_br",
+			Subsystem: "meta",
+			Name:      "flush_items",
+			Help:      "The item count distributions of the meta files flushed by BR.",
+			Buckets:   prometheus.ExponentialBuckets(16, 2, 16),
+		})
+)
+
+func init() { // nolint:gochecknoinits
+	prometheus.MustRegister(metaFlushCounter)
+	prometheus.MustRegister(metaFlushBytesHistogram)
+	prometheus.MustRegister(metaFlushItemsHistogram)
+}
+
+// FlushPolicy decides when MetaWriter flushes the buffered data files into a meta file of
+// the V2 meta. Besides the size limit of the meta file, the buffer is flushed once it holds
+// MaxItems files, or the heap of the process exceeds MemoryLimit and the buffer is larger
+// than MinSize, which keeps the memory bounded on backups of lots of small files.
+type FlushPolicy struct {
+	// MaxItems is the limit of the buffered items, 0 means no limit.
+	MaxItems int
+	// MinSize is the encoded size of the buffer below which the memory doesn't trigger a flush.
+	MinSize int
+	// MemoryLimit is the heap size of the process above which the buffer is flushed, 0
+	// disables it.
+	MemoryLimit uint64
+}
+
+// DefaultFlushPolicy returns the FlushPolicy used by MetaWriter by default.
+func DefaultFlushPolicy() FlushPolicy {
+	return FlushPolicy{
+		MaxItems: DefaultFlushItems,
+		MinSize:  DefaultFlushMinSize,
+	}
+}
+
+// flushReason returns the reason to flush the buffered meta f, or "" if it shouldn't be
+// flushed. heapSize is called to sample the memory of the process when it's needed.
+func (p FlushPolicy) flushReason(f *sizedMetaFile, heapSize func() uint64) string {
+	switch {
+	case f.encodedSize > f.sizeLimit:
+		return flushReasonSize
+	case p.MaxItems > 0 && f.itemNum >= p.MaxItems:
+		return flushReasonItems
+	case p.MemoryLimit > 0 && f.encodedSize >= p.MinSize && f.itemNum >= f.sampledItemNum+memorySampleItems:
+		f.sampledItemNum = f.itemNum
+		if heapSize() > p.MemoryLimit {
+			return flushReasonMemory
+		}
+	}
+	return ""
+}
+
+// heapObjectsSize returns the size of the heap objects of the process, which is read without
+// stopping the world unlike runtime.ReadMemStats.
+func heapObjectsSize() uint64 {
+	sample := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+// observeMetaFlush observes the meta file of the items and the encoded size flushed by reason.
+func observeMetaFlush(reason string, size, items int) {
+	metaFlushCounter.WithLabelValues(reason).Inc()
+	metaFlushBytesHistogram.Observe(float64(size))
+	metaFlushItemsHistogram.Observe(float64(items))
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/docker/go-units"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestFlushReason(t *testing.T) {
+	heap := uint64(0)
+	sampled := 0
+	heapSize := func() uint64 {
+		sampled++
+		return heap
+	}
+	policy := FlushPolicy{MaxItems: 4096, MinSize: 1024, MemoryLimit: units.GiB}
+
+	f := NewSizedMetaFile(units.MiB)
+	f.itemNum, f.encodedSize = 100, 100
+	require.Equal(t, "", policy.flushReason(f, heapSize))
+	f.encodedSize = units.MiB + 1
+	require.Equal(t, flushReasonSize, policy.flushReason(f, heapSize))
+	f.itemNum, f.encodedSize = 4096, 4096
+	require.Equal(t, flushReasonItems, policy.flushReason(f, heapSize))
+	require.Equal(t, 0, sampled)
+
+	// the memory is sampled once per memorySampleItems items.
+	f.itemNum = 1024
+	require.Equal(t, "", policy.flushReason(f, heapSize))
+	require.Equal(t, 1, sampled)
+	heap = 2 * units.GiB
+	f.itemNum = 1500
+	require.Equal(t, "", policy.flushReason(f, heapSize))
+	require.Equal(t, 1, sampled)
+	f.itemNum = 2048
+	require.Equal(t, flushReasonMemory, policy.flushReason(f, heapSize))
+	require.Equal(t, 2, sampled)
+
+	// the tiny buffer isn't flushed even if the memory exceeds the limit.
+	f = NewSizedMetaFile(units.MiB)
+	f.itemNum, f.encodedSize = 2048, 1000
+	require.Equal(t, "", policy.flushReason(f, heapSize))
+	require.Equal(t, 2, sampled)
+
+	f.encodedSize = 1024
+	policy.MemoryLimit = 0
+	require.Equal(t, "", policy.flushReason(f, heapSize))
+	require.Equal(t, 2, sampled)
+}
+
+func TestMetaWriterFlushPolicy(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	cipher := &backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT}
+
+	send := func(writer *MetaWriter) *backuppb.BackupMeta {
+		writer.StartWriteMetasAsync(ctx, AppendDataFile)
+		for i := 0; i < 5000; i++ {
+			err := writer.Send([]*backuppb.File{{Name: fmt.Sprintf("%04d.sst", i)}}, AppendDataFile)
+			require.NoError(t, err)
+		}
+		require.NoError(t, writer.FinishWriteMetas(ctx, AppendDataFile))
+		require.Equal(t, 5000, writer.FlushedItems())
+		return writer.Backupmeta()
+	}
+
+	writer := NewMetaWriter(s, MetaShardSize, true, cipher)
+	writer.SetFlushPolicy(FlushPolicy{MaxItems: 1000})
+	require.Len(t, send(writer).FileIndex.MetaFiles, 5)
+
+	writer = NewMetaWriter(s, MetaShardSize, true, cipher)
+	writer.SetFlushPolicy(FlushPolicy{MinSize: 1, MemoryLimit: 1})
+	writer.heapSize = func() uint64 { return 2 }
+	// flushed once per memorySampleItems items.
+	require.Len(t, send(writer).FileIndex.MetaFiles, 5)
+
+	writer = NewMetaWriter(s, MetaShardSize, true, cipher)
+	require.Len(t, send(writer).FileIndex.MetaFiles, 1)
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// HeatmapFile is the side file recording the distribution of the backed up data over the key
+// space. It's written by backup raw --heatmap-buckets, and printed by br debug heatmap to plan
+// the partial restores.
+const HeatmapFile = "backup.heatmap.json"
+
+// Heatmap is the distribution of the backed up data over the key space, in the buckets sorted
+// by their start keys.
+type Heatmap struct {
+	Buckets []HeatmapBucket `json:"buckets"`
+}
+
+// HeatmapBucket is the data backed up in a key range, the keys are in the format of backupmeta.
+type HeatmapBucket struct {
+	StartKey []byte `json:"start-key"`
+	EndKey   []byte `json:"end-key"`
+	// Ranges is the number of the ranges backed up by TiKV in the bucket, i.e. the regions.
+	Ranges     int    `json:"ranges"`
+	TotalKvs   uint64 `json:"total-kvs"`
+	TotalBytes uint64 `json:"total-bytes"`
+}
+
+// NewHeatmap merges the adjacent ranges into at most maxBuckets buckets of about the same
+// number of ranges. The ranges are sorted by the start key.
+func NewHeatmap(ranges []HeatmapBucket, maxBuckets int) *Heatmap {
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].StartKey, ranges[j].StartKey) < 0
+	})
+	h := &Heatmap{}
+	if len(ranges) == 0 || maxBuckets <= 0 {
+		return h
+	}
+	perBucket := (len(ranges) + maxBuckets - 1) / maxBuckets
+	for i := 0; i < len(ranges); i += perBucket {
+		end := i + perBucket
+		if end > len(ranges) {
+			end = len(ranges)
+		}
+		bucket := HeatmapBucket{StartKey: ranges[i].StartKey, EndKey: ranges[end-1].EndKey}
+		for _, r := range ranges[i:end] {
+			bucket.Ranges += r.Ranges
+			bucket.TotalKvs += r.TotalKvs
+			bucket.TotalBytes += r.TotalBytes
+		}
+		h.Buckets = append(h.Buckets, bucket)
+	}
+	return h
+}
+
+// MaxBytes returns the largest TotalBytes of the buckets.
+func (h *Heatmap) MaxBytes() uint64 {
+	var max uint64
+	for _, b := range h.Buckets {
+		if b.TotalBytes > max {
+			max = b.TotalBytes
+		}
+	}
+	return max
+}
+
+// WriteHeatmap writes the heatmap into the backup storage.
+func WriteHeatmap(ctx context.Context, s storage.ExternalStorage, h *Heatmap) error {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, HeatmapFile, data))
+}
+
+// ReadHeatmap reads the heatmap from the backup storage, it returns nil if the backup has no
+// heatmap, e.g. it's taken by older BR.
+func ReadHeatmap(ctx context.Context, s storage.ExternalStorage) (*Heatmap, error) {
+	exists, err := s.FileExists(ctx, HeatmapFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, HeatmapFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	h := &Heatmap{}
+	if err = json.Unmarshal(data, h); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", HeatmapFile, err)
+	}
+	return h, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestHeatmap(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	h, err := ReadHeatmap(ctx, s)
+	require.NoError(t, err)
+	require.Nil(t, h)
+
+	ranges := make([]HeatmapBucket, 0, 10)
+	for i := 9; i >= 0; i-- {
+		ranges = append(ranges, HeatmapBucket{
+			StartKey:   []byte(fmt.Sprintf("k%d", i)),
+			EndKey:     []byte(fmt.Sprintf("k%d", i+1)),
+			Ranges:     1,
+			TotalKvs:   uint64(i),
+			TotalBytes: uint64(i * 10),
+		})
+	}
+	h = NewHeatmap(ranges, 4)
+	require.Len(t, h.Buckets, 4)
+	require.Equal(t, HeatmapBucket{StartKey: []byte("k0"), EndKey: []byte("k3"), Ranges: 3, TotalKvs: 3, TotalBytes: 30},
+		h.Buckets[0])
+	require.Equal(t, HeatmapBucket{StartKey: []byte("k9"), EndKey: []byte("k10"), Ranges: 1, TotalKvs: 9, TotalBytes: 90},
+		h.Buckets[3])
+	require.Equal(t, uint64(210), h.MaxBytes())
+	require.Len(t, NewHeatmap(ranges, 100).Buckets, 10)
+	require.Empty(t, NewHeatmap(nil, 4).Buckets)
+
+	require.NoError(t, WriteHeatmap(ctx, s, h))
+	read, err := ReadHeatmap(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, h, read)
+}
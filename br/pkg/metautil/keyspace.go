@@ -0,0 +1,93 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// KeyspacesFile is the file recording the API V2 keyspaces of the backup cluster, which
+// are recreated with the same IDs before restoring into a fresh cluster.
+const KeyspacesFile = "backup.keyspaces.json"
+
+// Keyspace is an API V2 keyspace, the ID is encoded in the keys of the keyspace.
+type Keyspace struct {
+	ID     uint32            `json:"id"`
+	Name   string            `json:"name"`
+	Config map[string]string `json:"config,omitempty"`
+}
+
+// WriteKeyspaces writes the keyspaces into the backup storage.
+func WriteKeyspaces(ctx context.Context, s storage.ExternalStorage, keyspaces []Keyspace) error {
+	data, err := json.MarshalIndent(keyspaces, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, KeyspacesFile, data))
+}
+
+// ReadKeyspaces reads the keyspaces from the backup storage, it returns nil if the
+// backup doesn't record them, e.g. taken by an older version or of API V1.
+func ReadKeyspaces(ctx context.Context, s storage.ExternalStorage) ([]Keyspace, error) {
+	exists, err := s.FileExists(ctx, KeyspacesFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, KeyspacesFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var keyspaces []Keyspace
+	if err = json.Unmarshal(data, &keyspaces); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", KeyspacesFile, err)
+	}
+	return keyspaces, nil
+}
+
+// KeyspaceScopeFile is the file recording the API V2 keyspace the backup is scoped to, all the
+// keys of the backup are in it. The backups without it are of the default keyspace.
+const KeyspaceScopeFile = "backup.keyspace-scope.json"
+
+// WriteKeyspaceScope writes the keyspace the backup is scoped to into the backup storage.
+func WriteKeyspaceScope(ctx context.Context, s storage.ExternalStorage, keyspace *Keyspace) error {
+	data, err := json.MarshalIndent(keyspace, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, KeyspaceScopeFile, data))
+}
+
+// ReadKeyspaceScope reads the keyspace the backup is scoped to, it returns nil if the backup
+// isn't scoped to a keyspace.
+func ReadKeyspaceScope(ctx context.Context, s storage.ExternalStorage) (*Keyspace, error) {
+	exists, err := s.FileExists(ctx, KeyspaceScopeFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, KeyspaceScopeFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	keyspace := &Keyspace{}
+	if err = json.Unmarshal(data, keyspace); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", KeyspaceScopeFile, err)
+	}
+	return keyspace, nil
+}
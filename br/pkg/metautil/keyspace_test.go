@@ -0,0 +1,55 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestKeyspaces(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	keyspaces, err := ReadKeyspaces(ctx, s)
+	require.NoError(t, err)
+	require.Nil(t, keyspaces)
+
+	expected := []Keyspace{{ID: 1, Name: "a", Config: map[string]string{"k": "v"}}, {ID: 3, Name: "b"}}
+	require.NoError(t, WriteKeyspaces(ctx, s, expected))
+	keyspaces, err = ReadKeyspaces(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, expected, keyspaces)
+}
+
+func TestKeyspaceScope(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	keyspace, err := ReadKeyspaceScope(ctx, s)
+	require.NoError(t, err)
+	require.Nil(t, keyspace)
+
+	expected := &Keyspace{ID: 2, Name: "a"}
+	require.NoError(t, WriteKeyspaceScope(ctx, s, expected))
+	keyspace, err = ReadKeyspaceScope(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, expected, keyspace)
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// LocationsFile is the side file recording which storage endpoint each file of a backup is
+// written to, if the backup fails over between several endpoints. restore raw reads each file
+// from the endpoint in it.
+const LocationsFile = "backup.locations.json"
+
+// Locations are the files written to each storage endpoint of a backup, the files not in
+// any endpoint are in the storage of backupmeta.
+type Locations struct {
+	Endpoints []EndpointFiles `json:"endpoints"`
+}
+
+// EndpointFiles are the files written to a storage endpoint.
+type EndpointFiles struct {
+	// URI is the URI of the endpoint, without the credentials.
+	URI string `json:"uri"`
+	// RelativeURI is the URI of the endpoint relative to the one the locations are written to,
+	// if they're in the same bucket. It's empty in the locations written by older BR.
+	RelativeURI string   `json:"relative-uri,omitempty"`
+	Files       []string `json:"files"`
+}
+
+// FileEndpoints returns the URI of the endpoint of each file. The relative URIs are resolved
+// against mainURI, the URI of the storage the backup is read from, so that the backup copied
+// to another bucket or prefix along with the endpoints still finds them.
+// The files not recorded are in the storage the backup is read from.
+func (l *Locations) FileEndpoints(mainURI string) (map[string]string, error) {
+	endpoints := make(map[string]string)
+	for _, e := range l.Endpoints {
+		uri := e.URI
+		if len(e.RelativeURI) > 0 {
+			var err error
+			if uri, err = storage.ResolveURL(mainURI, e.RelativeURI); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		for _, name := range e.Files {
+			endpoints[name] = uri
+		}
+	}
+	return endpoints, nil
+}
+
+// WriteLocations writes the locations of the files into the backup storage.
+func WriteLocations(ctx context.Context, s storage.ExternalStorage, l *Locations) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, LocationsFile, data))
+}
+
+// ReadLocations reads the locations of the files from the backup storage, it returns nil
+// if the backup is written to only one endpoint.
+func ReadLocations(ctx context.Context, s storage.ExternalStorage) (*Locations, error) {
+	exists, err := s.FileExists(ctx, LocationsFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, LocationsFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	l := &Locations{}
+	if err = json.Unmarshal(data, l); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", LocationsFile, err)
+	}
+	return l, nil
+}
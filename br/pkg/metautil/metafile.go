@@ -1,12 +1,15 @@
 // Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
 
+// Package metautil reads and writes the metadata of the backups. backupmeta is defined by
+// kvproto and shared with the other tools, so the metadata it has no field for is kept in the
+// side files, the JSON files named backup.*.json at the root of the backup storage. The side
+// files are optional, the backups of older BR don't have them, so their readers return nil
+// if the file doesn't exist.
 package metautil
 
 import (
-	"bytes"
 	"context"
 	"crypto/rand"
-	"crypto/sha256"
 	"fmt"
 	"sync"
 	"time"
@@ -15,11 +18,13 @@ import (
 	"github.com/gogo/protobuf/proto"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pingcap/errors"
+	"github.com/pingcap/failpoint"
 	backuppb "github.com/pingcap/kvproto/pkg/brpb"
 	"github.com/pingcap/kvproto/pkg/encryptionpb"
 	"github.com/pingcap/log"
 	"github.com/pingcap/tidb/util/encrypt"
 	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/failpoints"
 	"github.com/tikv/migration/br/pkg/storage"
 	"github.com/tikv/migration/br/pkg/summary"
 	"go.uber.org/zap"
@@ -37,6 +42,9 @@ const (
 
 	// MetaFileSize represents the limit size of one MetaFile
 	MetaFileSize = 128 * units.MiB
+	// MetaShardSize is the limit size of one shard of the file list in the V2 meta, which is
+	// read one by one by MetaReader.ReadDataFiles.
+	MetaShardSize = 8 * units.MiB
 
 	// CrypterIvLen represents the length of iv of crypter method
 	CrypterIvLen = 16
@@ -85,7 +93,7 @@ func Decrypt(content []byte, cipher *backuppb.CipherInfo, iv []byte) ([]byte, er
 
 func walkLeafMetaFile(
 	ctx context.Context,
-	storage storage.ExternalStorage,
+	s storage.ExternalStorage,
 	file *backuppb.MetaFile,
 	cipher *backuppb.CipherInfo,
 	output func(*backuppb.MetaFile)) error {
@@ -97,27 +105,27 @@ func walkLeafMetaFile(
 		return nil
 	}
 	for _, node := range file.MetaFiles {
-		content, err := storage.ReadFile(ctx, node.Name)
-		if err != nil {
-			return errors.Trace(err)
-		}
-
-		decryptContent, err := Decrypt(content, cipher, node.CipherIv)
+		var decryptContent []byte
+		_, err := storage.ReadFileVerified(ctx, s, node.Name, int64(node.Size_), func(content []byte) error {
+			var err error
+			decryptContent, err = Decrypt(content, cipher, node.CipherIv)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if decryptContent, err = DecompressMeta(decryptContent); err != nil {
+				return errors.Trace(err)
+			}
+			return errors.Trace(verifyChecksum(decryptContent, node.Sha256))
+		})
 		if err != nil {
 			return errors.Trace(err)
 		}
 
-		checksum := sha256.Sum256(decryptContent)
-		if !bytes.Equal(node.Sha256, checksum[:]) {
-			return errors.Annotatef(berrors.ErrInvalidMetaFile,
-				"checksum mismatch expect %x, got %x", node.Sha256, checksum[:])
-		}
-
 		child := &backuppb.MetaFile{}
 		if err = proto.Unmarshal(decryptContent, child); err != nil {
 			return errors.Trace(err)
 		}
-		if err = walkLeafMetaFile(ctx, storage, child, cipher, output); err != nil {
+		if err = walkLeafMetaFile(ctx, s, child, cipher, output); err != nil {
 			return errors.Trace(err)
 		}
 	}
@@ -143,6 +151,34 @@ func NewMetaReader(
 	}
 }
 
+// ReadDataFiles calls output with the data files of the backup one by one. The file list
+// of the V2 meta is read shard by shard, so it's never held in memory as a whole.
+func (reader *MetaReader) ReadDataFiles(ctx context.Context, output func(*backuppb.File) error) error {
+	for _, file := range reader.backupMeta.Files {
+		if err := output(file); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// stop reading the remaining shards once output fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var outputErr error
+	err := walkLeafMetaFile(ctx, reader.storage, reader.backupMeta.FileIndex, reader.cipher, func(leaf *backuppb.MetaFile) {
+		for _, file := range leaf.DataFiles {
+			if outputErr != nil {
+				return
+			}
+			if outputErr = output(file); outputErr != nil {
+				cancel()
+			}
+		}
+	})
+	if outputErr != nil {
+		return errors.Trace(outputErr)
+	}
+	return errors.Trace(err)
+}
+
 // ArchiveSize return the size of Archive data
 func (reader *MetaReader) ArchiveSize(ctx context.Context, files []*backuppb.File) uint64 {
 	total := uint64(0)
@@ -152,6 +188,30 @@ func (reader *MetaReader) ArchiveSize(ctx context.Context, files []*backuppb.Fil
 	return total
 }
 
+// ReferencedFiles returns the names of the data files and the meta files referenced by the backupmeta.
+func (reader *MetaReader) ReferencedFiles(ctx context.Context) ([]string, error) {
+	names := make([]string, 0, len(reader.backupMeta.Files))
+	for _, file := range reader.backupMeta.Files {
+		names = append(names, file.Name)
+	}
+	for _, index := range []*backuppb.MetaFile{
+		reader.backupMeta.FileIndex,
+		reader.backupMeta.SchemaIndex,
+		reader.backupMeta.RawRangeIndex,
+		reader.backupMeta.DdlIndexes,
+	} {
+		for _, node := range index.GetMetaFiles() {
+			names = append(names, node.Name)
+		}
+	}
+	err := walkLeafMetaFile(ctx, reader.storage, reader.backupMeta.FileIndex, reader.cipher, func(leaf *backuppb.MetaFile) {
+		for _, file := range leaf.DataFiles {
+			names = append(names, file.Name)
+		}
+	})
+	return names, errors.Trace(err)
+}
+
 // AppendOp represents the operation type of meta.
 type AppendOp int
 
@@ -176,14 +236,17 @@ func (op AppendOp) name() string {
 	return name
 }
 
-// appends item to MetaFile
-func (op AppendOp) appendFile(a *backuppb.MetaFile, b interface{}) (int, int) {
+// appends item to MetaFile, returns the size of the data files, the encoded size of the
+// items and the number of the items.
+func (op AppendOp) appendFile(a *backuppb.MetaFile, b interface{}) (int, int, int) {
 	size := 0
+	encodedSize := 0
 	itemCount := 0
 	switch op {
 	case AppendMetaFile:
 		a.MetaFiles = append(a.MetaFiles, b.(*backuppb.File))
 		size += int(b.(*backuppb.File).Size_)
+		encodedSize += b.(*backuppb.File).Size()
 		itemCount++
 	case AppendDataFile:
 		// receive a batch of file because we need write and default sst are adjacent.
@@ -192,18 +255,24 @@ func (op AppendOp) appendFile(a *backuppb.MetaFile, b interface{}) (int, int) {
 		for _, f := range files {
 			itemCount++
 			size += int(f.Size_)
+			encodedSize += f.Size()
 		}
 	}
 
-	return size, itemCount
+	return size, encodedSize, itemCount
 }
 
 type sizedMetaFile struct {
 	// A stack like array, we always append to the last node.
-	root      *backuppb.MetaFile
-	size      int
-	itemNum   int
-	sizeLimit int
+	root *backuppb.MetaFile
+	// size is the size of the data files, and encodedSize is the size of the meta file,
+	// which is bounded by sizeLimit.
+	size        int
+	encodedSize int
+	itemNum     int
+	sizeLimit   int
+	// sampledItemNum is itemNum when the memory was sampled last time.
+	sampledItemNum int
 }
 
 // NewSizedMetaFile represents the sizedMetaFile.
@@ -218,14 +287,14 @@ func NewSizedMetaFile(sizeLimit int) *sizedMetaFile {
 	}
 }
 
-func (f *sizedMetaFile) append(file interface{}, op AppendOp) bool {
+func (f *sizedMetaFile) append(file interface{}, op AppendOp) {
 	// append to root
 	// 	TODO maybe use multi level index
-	size, itemCount := op.appendFile(f.root, file)
+	size, encodedSize, itemCount := op.appendFile(f.root, file)
 	f.itemNum += itemCount
 	f.size += size
+	f.encodedSize += encodedSize
 	// f.size would reset outside
-	return f.size > f.sizeLimit
 }
 
 // MetaWriter represents wraps a writer, and the MetaWriter should be compatible with old version of backupmeta.
@@ -251,7 +320,12 @@ type MetaWriter struct {
 	// records the total item of in one write meta job.
 	flushedItemNum int
 
-	cipher *backuppb.CipherInfo
+	cipher            *backuppb.CipherInfo
+	compression       MetaCompressionType
+	checksumAlgorithm ChecksumAlgorithm
+	flushPolicy       FlushPolicy
+	// heapSize samples the memory of the process for flushPolicy.
+	heapSize func() uint64
 }
 
 // NewMetaWriter creates MetaWriter.
@@ -270,9 +344,29 @@ func NewMetaWriter(storage storage.ExternalStorage,
 		metafiles:      NewSizedMetaFile(metafileSizeLimit),
 		metafileSeqNum: make(map[string]int),
 		cipher:         cipher,
+		flushPolicy:    DefaultFlushPolicy(),
+		heapSize:       heapObjectsSize,
 	}
 }
 
+// SetCompression sets the compression of backupmeta and the meta files, which are compressed
+// before encrypted.
+func (writer *MetaWriter) SetCompression(compression MetaCompressionType) {
+	writer.compression = compression
+}
+
+// SetChecksumAlgorithm sets the algorithm of the checksums of the meta files, which is told
+// by the size of the checksums on reading.
+func (writer *MetaWriter) SetChecksumAlgorithm(a ChecksumAlgorithm) {
+	writer.checksumAlgorithm = a
+}
+
+// SetFlushPolicy sets the policy of flushing the buffered data files into the meta files of
+// the V2 meta, see FlushPolicy.
+func (writer *MetaWriter) SetFlushPolicy(p FlushPolicy) {
+	writer.flushPolicy = p
+}
+
 func (writer *MetaWriter) reset() {
 	writer.metasCh = make(chan interface{}, MaxBatchSize)
 	writer.errCh = make(chan error)
@@ -329,9 +423,12 @@ func (writer *MetaWriter) StartWriteMetasAsync(ctx context.Context, op AppendOp)
 					log.Info("write metas finished", zap.String("type", op.name()))
 					return
 				}
-				needFlush := writer.metafiles.append(meta, op)
-				if writer.useV2Meta && needFlush {
-					err := writer.flushMetasV2(ctx, op)
+				writer.metafiles.append(meta, op)
+				if !writer.useV2Meta {
+					continue
+				}
+				if reason := writer.flushPolicy.flushReason(writer.metafiles, writer.heapSize); reason != "" {
+					err := writer.flushMetasV2(ctx, op, reason)
 					if err != nil {
 						writer.errCh <- err
 					}
@@ -356,7 +453,7 @@ func (writer *MetaWriter) FinishWriteMetas(ctx context.Context, op AppendOp) err
 	if !writer.useV2Meta {
 		writer.fillMetasV1(ctx, op)
 	} else {
-		err = writer.flushMetasV2(ctx, op)
+		err = writer.flushMetasV2(ctx, op, flushReasonFinish)
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -371,7 +468,8 @@ func (writer *MetaWriter) FinishWriteMetas(ctx context.Context, op AppendOp) err
 	return nil
 }
 
-// FlushBackupMeta flush the `backupMeta` to `ExternalStorage`
+// FlushBackupMeta flush the `backupMeta` to `ExternalStorage`, which is read back to verify
+// the upload like the meta files.
 func (writer *MetaWriter) FlushBackupMeta(ctx context.Context) error {
 	// Set schema version
 	if writer.useV2Meta {
@@ -386,14 +484,22 @@ func (writer *MetaWriter) FlushBackupMeta(ctx context.Context) error {
 		return errors.Trace(err)
 	}
 	log.Debug("backup meta", zap.Reflect("meta", writer.backupMeta))
-	log.Info("save backup meta", zap.Int("size", len(backupMetaData)))
+	compressed, err := CompressMeta(backupMetaData, writer.compression)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("save backup meta", zap.Int("size", len(backupMetaData)),
+		zap.Int("stored-size", len(compressed)), zap.Stringer("compression", writer.compression))
 
-	encryptBuff, iv, err := Encrypt(backupMetaData, writer.cipher)
+	encryptBuff, iv, err := Encrypt(compressed, writer.cipher)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
-	return writer.storage.WriteFile(ctx, MetaFile, append(iv, encryptBuff...))
+	failpoint.Inject(failpoints.FlushBackupMetaError, func(val failpoint.Value) {
+		failpoint.Return(errors.Annotatef(berrors.ErrStorageUnknown, "failpoint: %v", val))
+	})
+	return storage.WriteFileVerified(ctx, writer.storage, MetaFile, append(iv, encryptBuff...))
 }
 
 // fillMetasV1 keep the compatibility for old version.
@@ -408,7 +514,7 @@ func (writer *MetaWriter) fillMetasV1(_ context.Context, op AppendOp) {
 	writer.flushedItemNum += writer.metafiles.itemNum
 }
 
-func (writer *MetaWriter) flushMetasV2(ctx context.Context, op AppendOp) error {
+func (writer *MetaWriter) flushMetasV2(ctx context.Context, op AppendOp, reason string) error {
 	var index *backuppb.MetaFile
 	switch op {
 	case AppendDataFile:
@@ -432,28 +538,43 @@ func (writer *MetaWriter) flushMetasV2(ctx context.Context, op AppendOp) error {
 	writer.metafileSeqNum["metafiles"] += 1
 	fname := fmt.Sprintf("backupmeta.%s.%09d", name, writer.metafileSeqNum["metafiles"])
 
-	encyptedContent, iv, err := Encrypt(content, writer.cipher)
+	compressed, err := CompressMeta(content, writer.compression)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	encyptedContent, iv, err := Encrypt(compressed, writer.cipher)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
-	if err = writer.storage.WriteFile(ctx, fname, encyptedContent); err != nil {
+	failpoint.Inject(failpoints.FlushMetafileError, func(val failpoint.Value) {
+		failpoint.Return(errors.Annotatef(berrors.ErrStorageUnknown, "failpoint: %v", val))
+	})
+	if err = storage.WriteFileVerified(ctx, writer.storage, fname, encyptedContent); err != nil {
 		return errors.Trace(err)
 	}
-	checksum := sha256.Sum256(content)
 	file := &backuppb.File{
 		Name:     fname,
-		Sha256:   checksum[:],
-		Size_:    uint64(len(content)),
+		Sha256:   writer.checksumAlgorithm.Sum(content),
+		Size_:    uint64(len(encyptedContent)),
 		CipherIv: iv,
 	}
 
 	index.MetaFiles = append(index.MetaFiles, file)
+	observeMetaFlush(reason, len(content), writer.metafiles.itemNum)
+	log.Debug("flush the meta file", zap.String("name", fname), zap.String("reason", reason),
+		zap.Int("item", writer.metafiles.itemNum), zap.Int("size", len(content)))
 	writer.flushedItemNum += writer.metafiles.itemNum
 	writer.metafiles = NewSizedMetaFile(writer.metafiles.sizeLimit)
 	return nil
 }
 
+// FlushedItems returns the number of the items written by the last FinishWriteMetas, e.g. the
+// data files.
+func (writer *MetaWriter) FlushedItems() int {
+	return writer.flushedItemNum
+}
+
 // ArchiveSize represents the size of ArchiveSize.
 func (writer *MetaWriter) ArchiveSize() uint64 {
 	total := uint64(0)
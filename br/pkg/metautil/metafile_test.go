@@ -5,15 +5,18 @@ package metautil
 import (
 	"context"
 	"crypto/sha256"
+	"fmt"
 	"testing"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/mock/gomock"
+	"github.com/pingcap/errors"
 	backuppb "github.com/pingcap/kvproto/pkg/brpb"
 	"github.com/pingcap/kvproto/pkg/encryptionpb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/stretchr/testify/require"
 	mockstorage "github.com/tikv/migration/br/pkg/mock/storage"
+	"github.com/tikv/migration/br/pkg/storage"
 )
 
 func checksum(m *backuppb.MetaFile) []byte {
@@ -70,7 +73,9 @@ func TestWalkMetaFileInvalid(t *testing.T) {
 	leaf := &backuppb.MetaFile{Schemas: []*backuppb.Schema{
 		{Db: []byte("db"), Table: []byte("table")},
 	}}
-	mockStorage.EXPECT().ReadFile(ctx, "leaf").Return(leaf.Marshal())
+	// the corrupted file is downloaded again before giving up.
+	mockStorage.EXPECT().ReadFile(ctx, "leaf").Return(leaf.Marshal()).Times(4)
+	mockStorage.EXPECT().URI().Return("mock://").AnyTimes()
 
 	root := &backuppb.MetaFile{MetaFiles: []*backuppb.File{
 		{Name: "leaf", Sha256: []byte{}},
@@ -247,8 +252,48 @@ func TestMetaWriter(t *testing.T) {
 	encryptBuff, iv, err := Encrypt(backupMetaData, metaWriter.cipher)
 	require.Nil(t, err)
 	mockStorage.EXPECT().WriteFile(ctx, MetaFile, append(iv, encryptBuff...)).Return(nil)
+	// the backupmeta is read back to verify the upload.
+	mockStorage.EXPECT().ReadFile(ctx, MetaFile).Return(append(iv, encryptBuff...), nil)
 
 	err = metaWriter.FlushBackupMeta(ctx)
 	require.Nil(t, err)
 	require.GreaterOrEqual(t, metaWriter.ArchiveSize(), uint64(0))
 }
+
+func TestReadDataFilesV2(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	cipher := &backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT}
+
+	metaWriter := NewMetaWriter(s, 128, true, cipher)
+	metaWriter.StartWriteMetasAsync(ctx, AppendDataFile)
+	for i := 0; i < 30; i++ {
+		// the size of the data files doesn't bound the shards.
+		err = metaWriter.Send([]*backuppb.File{{Name: fmt.Sprintf("%02d.sst", i), Size_: 1 << 30}}, AppendDataFile)
+		require.NoError(t, err)
+	}
+	require.NoError(t, metaWriter.FinishWriteMetas(ctx, AppendDataFile))
+	backupMeta := metaWriter.Backupmeta()
+	require.Empty(t, backupMeta.Files)
+	shards := len(backupMeta.FileIndex.MetaFiles)
+	require.Greater(t, shards, 2)
+	require.Less(t, shards, 30)
+
+	reader := NewMetaReader(backupMeta, s, cipher)
+	var names []string
+	require.NoError(t, reader.ReadDataFiles(ctx, func(f *backuppb.File) error {
+		names = append(names, f.Name)
+		return nil
+	}))
+	require.Len(t, names, 30)
+	require.Equal(t, "29.sst", names[29])
+
+	read := 0
+	err = reader.ReadDataFiles(ctx, func(f *backuppb.File) error {
+		read++
+		return errors.New("stop")
+	})
+	require.EqualError(t, err, "stop")
+	require.Equal(t, 1, read)
+}
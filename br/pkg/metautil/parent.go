@@ -0,0 +1,102 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// ParentFile is the side file linking an incremental backup to its parent backup. It's followed
+// by restore raw --restore-chain to restore the whole chain, and by br purge to keep the
+// parents of the backups retained.
+const ParentFile = "backup.parent.json"
+
+// Parent is the backup an incremental backup is based on.
+type Parent struct {
+	// Storage is the storage URL of the parent backup.
+	Storage string `json:"storage"`
+	// RelativeStorage is the URL of the parent backup relative to the incremental backup, if
+	// they're in the same bucket. It's preferred to Storage, so that the chain copied to another
+	// bucket or prefix together is still linked. It's empty in the linkages of older BR.
+	RelativeStorage string `json:"relative-storage,omitempty"`
+	// Checksum is the checksum of the backupmeta of the parent backup,
+	// which detects the parent backup being replaced.
+	Checksum string `json:"checksum"`
+	// ChecksumAlgorithm is the algorithm of Checksum, it's sha256 if empty.
+	ChecksumAlgorithm string `json:"checksum-algorithm,omitempty"`
+	// BackupTS is the backup ts of the parent backup, i.e. the start ts of the incremental backup.
+	BackupTS uint64 `json:"backup-ts"`
+}
+
+// BackupMetaChecksum returns the checksum of the backupmeta in the storage.
+func BackupMetaChecksum(ctx context.Context, s storage.ExternalStorage, a ChecksumAlgorithm) (string, error) {
+	data, err := s.ReadFile(ctx, MetaFile)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return hex.EncodeToString(a.Sum(data)), nil
+}
+
+// WriteParent writes the parent linkage into the backup storage.
+func WriteParent(ctx context.Context, s storage.ExternalStorage, p *Parent) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, ParentFile, data))
+}
+
+// ReadParent reads the parent linkage from the backup storage, it returns nil if
+// the backup is not an incremental one.
+func ReadParent(ctx context.Context, s storage.ExternalStorage) (*Parent, error) {
+	exists, err := s.FileExists(ctx, ParentFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, ParentFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	p := &Parent{}
+	if err = json.Unmarshal(data, p); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", ParentFile, err)
+	}
+	return p, nil
+}
+
+// VerifyParent checks that the backup in the storage is still the recorded parent.
+func VerifyParent(ctx context.Context, s storage.ExternalStorage, p *Parent) error {
+	a, err := ParseChecksumAlgorithm(p.ChecksumAlgorithm)
+	if err != nil {
+		return errors.Annotatef(berrors.ErrInvalidMetaFile,
+			"unsupported checksum algorithm '%s' of the parent backup, please upgrade BR", p.ChecksumAlgorithm)
+	}
+	checksum, err := BackupMetaChecksum(ctx, s, a)
+	if err != nil {
+		return errors.Annotatef(err, "failed to read the parent backup %s", p.Storage)
+	}
+	if checksum != p.Checksum {
+		return errors.Annotatef(berrors.ErrRestoreInvalidBackup,
+			"the parent backup %s has changed since the incremental backup, checksum %s, expected %s",
+			p.Storage, checksum, p.Checksum)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestParent(t *testing.T) {
+	ctx := context.Background()
+	parentStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	p, err := ReadParent(ctx, s)
+	require.NoError(t, err)
+	require.Nil(t, p)
+
+	require.NoError(t, parentStorage.WriteFile(ctx, MetaFile, []byte("parent backupmeta")))
+	checksum, err := BackupMetaChecksum(ctx, parentStorage, ChecksumSHA256)
+	require.NoError(t, err)
+	parent := &Parent{Storage: parentStorage.URI(), Checksum: checksum, BackupTS: 42}
+	require.NoError(t, WriteParent(ctx, s, parent))
+	p, err = ReadParent(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, parent, p)
+	require.NoError(t, VerifyParent(ctx, parentStorage, p))
+
+	// the parent linked by xxhash64.
+	checksum, err = BackupMetaChecksum(ctx, parentStorage, ChecksumXXHash64)
+	require.NoError(t, err)
+	require.Len(t, checksum, 16)
+	xxhashParent := &Parent{Storage: parentStorage.URI(), Checksum: checksum, ChecksumAlgorithm: "xxhash64"}
+	require.NoError(t, VerifyParent(ctx, parentStorage, xxhashParent))
+
+	// the parent backup is overwritten by another backup.
+	require.NoError(t, parentStorage.WriteFile(ctx, MetaFile, []byte("another backupmeta")))
+	require.Error(t, VerifyParent(ctx, parentStorage, p))
+	require.Error(t, VerifyParent(ctx, parentStorage, xxhashParent))
+}
@@ -0,0 +1,64 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// PartialRestoreFile is published into the backup storage when the files covering the
+// prioritized prefixes are restored, so that the applications of those keys can come
+// back online before the whole restore finishes.
+const PartialRestoreFile = "restore.partial.json"
+
+// PartialRestore is the content of PartialRestoreFile.
+type PartialRestore struct {
+	// Prefixes are the prioritized key prefixes, without the API V2 prefix.
+	Prefixes [][]byte `json:"prefixes"`
+	// Files is the number of the files restored for the prefixes.
+	Files      int       `json:"files"`
+	FinishTime time.Time `json:"finish-time"`
+}
+
+// WritePartialRestore publishes the marker of the restored prefixes into the storage.
+func WritePartialRestore(ctx context.Context, s storage.ExternalStorage, p *PartialRestore) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, PartialRestoreFile, data))
+}
+
+// ReadPartialRestore reads the marker of the restored prefixes, it returns nil if there is none.
+func ReadPartialRestore(ctx context.Context, s storage.ExternalStorage) (*PartialRestore, error) {
+	exists, err := s.FileExists(ctx, PartialRestoreFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, PartialRestoreFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	p := &PartialRestore{}
+	if err = json.Unmarshal(data, p); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", PartialRestoreFile, err)
+	}
+	return p, nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metautil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestPartialRestore(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	p, err := ReadPartialRestore(ctx, s)
+	require.NoError(t, err)
+	require.Nil(t, p)
+
+	finish := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	expected := &PartialRestore{Prefixes: [][]byte{[]byte("user"), []byte("order")}, Files: 3, FinishTime: finish}
+	require.NoError(t, WritePartialRestore(ctx, s, expected))
+	p, err = ReadPartialRestore(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, expected, p)
+}
@@ -0,0 +1,64 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// RestorePointsFile lists the restore points of a backup taken at several points in one run.
+// It's in the storage of the earliest point, whose backup the later ones are chained to.
+const RestorePointsFile = "backup.points.json"
+
+// RestorePoint is a point in time the backup can be restored to.
+type RestorePoint struct {
+	// BackupTS is the ts the backup of the point is consistent at.
+	BackupTS uint64 `json:"backup-ts"`
+	// Storage is the URL of the backup of the point relative to the storage of RestorePointsFile,
+	// the later points are incremental backups linked to the previous points.
+	Storage string `json:"storage"`
+}
+
+// WriteRestorePoints writes the restore points into the backup storage.
+func WriteRestorePoints(ctx context.Context, s storage.ExternalStorage, points []RestorePoint) error {
+	data, err := json.MarshalIndent(points, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, RestorePointsFile, data))
+}
+
+// ReadRestorePoints reads the restore points from the backup storage, it returns nil if
+// the backup is taken at a single point.
+func ReadRestorePoints(ctx context.Context, s storage.ExternalStorage) ([]RestorePoint, error) {
+	exists, err := s.FileExists(ctx, RestorePointsFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, RestorePointsFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var points []RestorePoint
+	if err = json.Unmarshal(data, &points); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", RestorePointsFile, err)
+	}
+	return points, nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestRestorePoints(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	points, err := ReadRestorePoints(ctx, s)
+	require.NoError(t, err)
+	require.Nil(t, points)
+
+	expected := []RestorePoint{{BackupTS: 100, Storage: "."}, {BackupTS: 200, Storage: "points/200"}}
+	require.NoError(t, WriteRestorePoints(ctx, s, expected))
+	points, err = ReadRestorePoints(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, expected, points)
+
+	require.NoError(t, s.WriteFile(ctx, RestorePointsFile, []byte("{")))
+	_, err = ReadRestorePoints(ctx, s)
+	require.Error(t, err)
+}
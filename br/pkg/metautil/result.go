@@ -0,0 +1,117 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+const (
+	// BackupResultFile is the result artifact of the backup task, at the root of the backup storage.
+	BackupResultFile = "backup.result.json"
+	// RestoreResultFile is the result artifact of the latest restore task, at the root of the backup storage.
+	RestoreResultFile = "restore.result.json"
+
+	// ResultSchemaVersion is the version of the schema of the result artifact. It's bumped
+	// on incompatible changes only, fields may be added without bumping it.
+	ResultSchemaVersion = 1
+
+	// ResultSucceeded and ResultFailed are the status of a task.
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+)
+
+// ResultChecksum is the checksum of the data backed up or restored.
+type ResultChecksum struct {
+	Crc64Xor   uint64 `json:"crc64-xor"`
+	TotalKvs   uint64 `json:"total-kvs"`
+	TotalBytes uint64 `json:"total-bytes"`
+}
+
+// Result is a machine-readable artifact of a task, which external workflow engines
+// read from the storage to consume the result of the task.
+type Result struct {
+	SchemaVersion int    `json:"schema-version"`
+	Task          string `json:"task"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+
+	StartTime       time.Time `json:"start-time"`
+	EndTime         time.Time `json:"end-time"`
+	DurationSeconds float64   `json:"duration-seconds"`
+
+	BackupTS uint64          `json:"backup-ts,omitempty"`
+	Size     uint64          `json:"size,omitempty"`
+	Checksum *ResultChecksum `json:"checksum,omitempty"`
+	// Outputs are the URLs of the files the task produced or consumed, by name.
+	Outputs map[string]string `json:"outputs,omitempty"`
+}
+
+// NewResult creates the result of a task starting now.
+func NewResult(task string) *Result {
+	return &Result{
+		SchemaVersion: ResultSchemaVersion,
+		Task:          task,
+		StartTime:     time.Now(),
+		Outputs:       make(map[string]string),
+	}
+}
+
+// Finish sets the status and the timings of the task finished with err.
+func (r *Result) Finish(err error) {
+	r.EndTime = time.Now()
+	r.DurationSeconds = r.EndTime.Sub(r.StartTime).Seconds()
+	r.Status = ResultSucceeded
+	if err != nil {
+		r.Status = ResultFailed
+		r.Error = err.Error()
+	}
+}
+
+// WriteResult writes the result artifact into the storage.
+func WriteResult(ctx context.Context, s storage.ExternalStorage, name string, r *Result) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, name, data))
+}
+
+// ReadResult reads the result artifact from the storage, it returns nil if there is none.
+func ReadResult(ctx context.Context, s storage.ExternalStorage, name string) (*Result, error) {
+	exists, err := s.FileExists(ctx, name)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	r := &Result{}
+	if err = json.Unmarshal(data, r); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", name, err)
+	}
+	if r.SchemaVersion > ResultSchemaVersion {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile,
+			"unsupported schema version %d of %s, the latest supported one is %d", r.SchemaVersion, name, ResultSchemaVersion)
+	}
+	return r, nil
+}
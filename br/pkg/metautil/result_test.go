@@ -0,0 +1,58 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestResult(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	r, err := ReadResult(ctx, s, BackupResultFile)
+	require.NoError(t, err)
+	require.Nil(t, r)
+
+	result := NewResult("Raw backup")
+	result.BackupTS = 42
+	result.Checksum = &ResultChecksum{Crc64Xor: 1, TotalKvs: 2, TotalBytes: 3}
+	result.Outputs["backupmeta"] = s.URI() + "/" + MetaFile
+	result.Finish(nil)
+	require.Equal(t, ResultSucceeded, result.Status)
+	require.NoError(t, WriteResult(ctx, s, BackupResultFile, result))
+	r, err = ReadResult(ctx, s, BackupResultFile)
+	require.NoError(t, err)
+	require.Equal(t, ResultSchemaVersion, r.SchemaVersion)
+	require.Equal(t, result.Checksum, r.Checksum)
+	require.Equal(t, result.Outputs, r.Outputs)
+
+	result = NewResult("Raw restore")
+	result.Finish(errors.New("restore failed"))
+	require.Equal(t, ResultFailed, result.Status)
+	require.Equal(t, "restore failed", result.Error)
+
+	// the artifact of a newer schema can't be read.
+	result.SchemaVersion = ResultSchemaVersion + 1
+	require.NoError(t, WriteResult(ctx, s, RestoreResultFile, result))
+	_, err = ReadResult(ctx, s, RestoreResultFile)
+	require.Error(t, err)
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// TopologyFile is the side file recording the topology of the backup cluster. restore raw
+// compares it with the target cluster, and warns if the placement cannot be kept.
+const TopologyFile = "backup.topology.json"
+
+// StoreTopology is the placement related information of a store.
+type StoreTopology struct {
+	ID     uint64            `json:"id"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Topology is the placement of a cluster, i.e. the stores, their labels and the replica count.
+type Topology struct {
+	MaxReplicas    uint64          `json:"max-replicas"`
+	LocationLabels []string        `json:"location-labels,omitempty"`
+	Stores         []StoreTopology `json:"stores"`
+}
+
+// NewTopology builds the topology from the stores and the replication config of PD.
+func NewTopology(stores []*metapb.Store, maxReplicas uint64, locationLabels string) *Topology {
+	t := &Topology{MaxReplicas: maxReplicas, Stores: make([]StoreTopology, 0, len(stores))}
+	for _, label := range strings.Split(locationLabels, ",") {
+		if label = strings.TrimSpace(label); len(label) > 0 {
+			t.LocationLabels = append(t.LocationLabels, label)
+		}
+	}
+	for _, store := range stores {
+		st := StoreTopology{ID: store.GetId()}
+		if len(store.GetLabels()) > 0 {
+			st.Labels = make(map[string]string, len(store.GetLabels()))
+			for _, label := range store.GetLabels() {
+				st.Labels[label.GetKey()] = label.GetValue()
+			}
+		}
+		t.Stores = append(t.Stores, st)
+	}
+	return t
+}
+
+// WriteTopology writes the topology into the backup storage.
+func WriteTopology(ctx context.Context, s storage.ExternalStorage, t *Topology) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, TopologyFile, data))
+}
+
+// ReadTopology reads the topology from the backup storage, it returns nil if the
+// backup doesn't record it, e.g. taken by an older version.
+func ReadTopology(ctx context.Context, s storage.ExternalStorage) (*Topology, error) {
+	exists, err := s.FileExists(ctx, TopologyFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, TopologyFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	t := &Topology{}
+	if err = json.Unmarshal(data, t); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", TopologyFile, err)
+	}
+	return t, nil
+}
+
+// labelValues returns the distinct values of the label among the stores, e.g. the zones.
+func (t *Topology) labelValues(key string) []string {
+	set := make(map[string]struct{})
+	for _, store := range t.Stores {
+		if v, ok := store.Labels[key]; ok {
+			set[v] = struct{}{}
+		}
+	}
+	values := make([]string, 0, len(set))
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// CompareTopology checks whether the target cluster can keep the placement of the
+// source cluster, and returns the differences that may surprise users after restore.
+func CompareTopology(source, target *Topology) []string {
+	var diffs []string
+	if source.MaxReplicas != target.MaxReplicas {
+		diffs = append(diffs, fmt.Sprintf("the replica count differs: %d in the backup cluster, %d in the target cluster",
+			source.MaxReplicas, target.MaxReplicas))
+	}
+	if uint64(len(target.Stores)) < target.MaxReplicas {
+		diffs = append(diffs, fmt.Sprintf("the target cluster has %d stores, fewer than its replica count %d",
+			len(target.Stores), target.MaxReplicas))
+	}
+	for _, label := range source.LocationLabels {
+		sourceValues, targetValues := source.labelValues(label), target.labelValues(label)
+		switch {
+		case len(targetValues) == 0 && len(sourceValues) > 0:
+			diffs = append(diffs, fmt.Sprintf("the location label '%s' (%s in the backup cluster) is missing in the target cluster",
+				label, strings.Join(sourceValues, ",")))
+		case len(targetValues) < len(sourceValues) && uint64(len(targetValues)) < target.MaxReplicas:
+			diffs = append(diffs, fmt.Sprintf(
+				"the target cluster has %d distinct '%s' (%s), fewer than the backup cluster (%s) and the replica count %d, "+
+					"so the replicas cannot be isolated by '%s' as before",
+				len(targetValues), label, strings.Join(targetValues, ","), strings.Join(sourceValues, ","),
+				target.MaxReplicas, label))
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func storesInZones(zones ...string) []*metapb.Store {
+	stores := make([]*metapb.Store, 0, len(zones))
+	for i, zone := range zones {
+		stores = append(stores, &metapb.Store{
+			Id: uint64(i + 1),
+			Labels: []*metapb.StoreLabel{
+				{Key: "zone", Value: zone},
+				{Key: "host", Value: fmt.Sprintf("h%d", i)},
+			},
+		})
+	}
+	return stores
+}
+
+func TestTopology(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	topo, err := ReadTopology(ctx, s)
+	require.NoError(t, err)
+	require.Nil(t, topo)
+
+	source := NewTopology(storesInZones("z1", "z2", "z3"), 3, "zone, host")
+	require.Equal(t, []string{"zone", "host"}, source.LocationLabels)
+	require.NoError(t, WriteTopology(ctx, s, source))
+	topo, err = ReadTopology(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, source, topo)
+
+	require.Empty(t, CompareTopology(source, NewTopology(storesInZones("a", "b", "c", "a"), 3, "zone,host")))
+
+	diffs := CompareTopology(source, NewTopology(storesInZones("z1", "z1", "z1"), 3, "zone,host"))
+	require.Len(t, diffs, 1)
+	require.Contains(t, diffs[0], "distinct 'zone'")
+
+	diffs = CompareTopology(source, NewTopology([]*metapb.Store{{Id: 1}}, 1, ""))
+	require.Len(t, diffs, 3)
+	require.Contains(t, diffs[0], "replica count differs")
+}
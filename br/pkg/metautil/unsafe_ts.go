@@ -0,0 +1,90 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// UnsafeTSFile is the side file recording how the backup ts of a backup taken by --unsafe-ts
+// is derived from the stores instead of the TSO of PD. The caveats in it are warned by restore
+// raw and listed by br describe.
+const UnsafeTSFile = "backup.unsafe-ts.json"
+
+// UnsafeTSCaveatNoSafePoint is the caveat of the backup ts which isn't protected from GC by the
+// service safe point, because PD doesn't accept it.
+const UnsafeTSCaveatNoSafePoint = "the service safe point isn't set, the versions of the backup ts may be garbage collected during the backup"
+
+// UnsafeTS is the backup ts derived from the safe-ts of the stores, and the reasons the backup
+// may be inconsistent at it.
+type UnsafeTS struct {
+	BackupTS uint64          `json:"backup-ts"`
+	Stores   []UnsafeTSStore `json:"stores"`
+	// Caveats are the reasons the backup may be inconsistent at BackupTS.
+	Caveats []string `json:"caveats"`
+}
+
+// UnsafeTSStore is the safe-ts reported by a store, or the error if the store doesn't report it.
+type UnsafeTSStore struct {
+	StoreID uint64 `json:"store-id"`
+	Address string `json:"address"`
+	SafeTS  uint64 `json:"safe-ts"`
+	Error   string `json:"error,omitempty"`
+}
+
+// WithoutSafePoint returns whether the backup ts isn't protected by the service safe point.
+func (u *UnsafeTS) WithoutSafePoint() bool {
+	if u == nil {
+		return false
+	}
+	for _, caveat := range u.Caveats {
+		if caveat == UnsafeTSCaveatNoSafePoint {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteUnsafeTS writes the derivation of the backup ts into the backup storage.
+func WriteUnsafeTS(ctx context.Context, s storage.ExternalStorage, u *UnsafeTS) error {
+	data, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, UnsafeTSFile, data))
+}
+
+// ReadUnsafeTS reads the derivation of the backup ts from the backup storage, it returns nil if
+// the backup ts is allocated by PD as usual.
+func ReadUnsafeTS(ctx context.Context, s storage.ExternalStorage) (*UnsafeTS, error) {
+	exists, err := s.FileExists(ctx, UnsafeTSFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, UnsafeTSFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	u := &UnsafeTS{}
+	if err = json.Unmarshal(data, u); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", UnsafeTSFile, err)
+	}
+	return u, nil
+}
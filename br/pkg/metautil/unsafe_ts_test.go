@@ -0,0 +1,56 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metautil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestUnsafeTS(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	u, err := ReadUnsafeTS(ctx, s)
+	require.NoError(t, err)
+	require.Nil(t, u)
+	require.False(t, u.WithoutSafePoint())
+
+	unsafeTS := &UnsafeTS{
+		BackupTS: 42,
+		Stores: []UnsafeTSStore{
+			{StoreID: 1, Address: "tikv-0:20160", SafeTS: 42},
+			{StoreID: 2, Address: "tikv-1:20160", Error: "connection refused"},
+		},
+		Caveats: []string{"the stores [2] don't report their safe-ts"},
+	}
+	require.NoError(t, WriteUnsafeTS(ctx, s, unsafeTS))
+	u, err = ReadUnsafeTS(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, unsafeTS, u)
+	require.False(t, u.WithoutSafePoint())
+
+	u.Caveats = append(u.Caveats, UnsafeTSCaveatNoSafePoint)
+	require.True(t, u.WithoutSafePoint())
+
+	require.NoError(t, s.WriteFile(ctx, UnsafeTSFile, []byte("{")))
+	_, err = ReadUnsafeTS(ctx, s)
+	require.True(t, berrors.ErrInvalidMetaFile.Equal(err))
+}
@@ -0,0 +1,186 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mount
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/sst"
+	"github.com/tikv/migration/br/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// APIPrefix is the path prefix of the query APIs.
+	APIPrefix = "/api/v1"
+
+	// DefaultScanLimit is the limit of a scan without the limit parameter.
+	DefaultScanLimit = 100
+	// MaxScanLimit is the max limit of a scan.
+	MaxScanLimit = 10000
+)
+
+// Info is the information of the served backup.
+type Info struct {
+	APIVersion string         `json:"api-version"`
+	Files      int            `json:"files"`
+	Cache      sst.CacheStats `json:"cache"`
+}
+
+// KV is an entry in the response, the key and the value are in the format of the handler.
+type KV struct {
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	ExpireTime uint64 `json:"expire-time,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewHandler returns the HTTP handler querying the snapshot, the keys in the parameters
+// and the response are in the format, raw|escaped|hex.
+//
+//	GET /api/v1/info                                  the backup and the cache statistics
+//	GET /api/v1/raw/get?key={key}                     get the key, 404 if it isn't in the backup
+//	GET /api/v1/raw/scan?start={key}&end={key}&limit={n}  scan the keys in [start, end)
+func NewHandler(s *Snapshot, format string) http.Handler {
+	h := &handler{snapshot: s, format: format}
+	router := mux.NewRouter()
+	api := router.PathPrefix(APIPrefix).Subrouter()
+	api.HandleFunc("/info", h.info).Methods(http.MethodGet)
+	api.HandleFunc("/raw/get", h.get).Methods(http.MethodGet)
+	api.HandleFunc("/raw/scan", h.scan).Methods(http.MethodGet)
+	return router
+}
+
+type handler struct {
+	snapshot *Snapshot
+	format   string
+}
+
+func (h *handler) info(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, Info{
+		APIVersion: h.snapshot.APIVersion().String(),
+		Files:      h.snapshot.Files(),
+		Cache:      h.snapshot.CacheStats(),
+	})
+}
+
+func (h *handler) get(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if !query.Has("key") {
+		writeError(w, errors.Annotate(berrors.ErrInvalidArgument, "the key is required"))
+		return
+	}
+	key, err := h.parseKey(query.Get("key"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	entry, ok, err := h.snapshot.Get(req.Context(), key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "key not found"})
+		return
+	}
+	kv, err := h.formatEntry(entry)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, kv)
+}
+
+func (h *handler) scan(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	startKey, err := h.parseKey(query.Get("start"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	endKey, err := h.parseKey(query.Get("end"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	limit := DefaultScanLimit
+	if s := query.Get("limit"); len(s) > 0 {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 || limit > MaxScanLimit {
+			writeError(w, errors.Annotatef(berrors.ErrInvalidArgument, "the limit must be within [1, %d]", MaxScanLimit))
+			return
+		}
+	}
+	entries, err := h.snapshot.Scan(req.Context(), startKey, endKey, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	kvs := make([]KV, 0, len(entries))
+	for _, entry := range entries {
+		kv, err := h.formatEntry(entry)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		kvs = append(kvs, kv)
+	}
+	writeJSON(w, http.StatusOK, kvs)
+}
+
+func (h *handler) parseKey(s string) ([]byte, error) {
+	key, err := utils.ParseKey(h.format, s)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to parse '%s' in format %s: %v", s, h.format, err)
+	}
+	return key, nil
+}
+
+func (h *handler) formatEntry(entry Entry) (KV, error) {
+	key, err := utils.FormatKey(h.format, entry.Key)
+	if err != nil {
+		return KV{}, errors.Trace(err)
+	}
+	value, err := utils.FormatKey(h.format, entry.Value)
+	if err != nil {
+		return KV{}, errors.Trace(err)
+	}
+	return KV{Key: key, Value: value, ExpireTime: entry.ExpireTime}, nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if berrors.Is(err, berrors.ErrInvalidArgument) {
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn("failed to write response", zap.Error(err))
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mount serves a raw kv backup as a read-only snapshot, the keys are read from the
+// SST files in the external storage directly, without restoring them into a cluster.
+package mount
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/client-go/v2/util/codec"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/sst"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+const (
+	// dataKeyPrefix is prepended to the keys in the SST files by TiKV.
+	dataKeyPrefix = 'z'
+	// tsSize is the size of the timestamp appended to the encoded API V2 keys.
+	tsSize = 8
+	// ttlSize is the size of the expire time appended to the values of API V1TTL and V2.
+	ttlSize = 8
+
+	// the flags of the last byte of the API V2 values.
+	valueFlagTTL    = 1
+	valueFlagDelete = 2
+)
+
+// Entry is a key and its value in the backup.
+type Entry struct {
+	Key   []byte
+	Value []byte
+	// ExpireTime is the unix time in seconds the key expires at, zero if it never expires.
+	// The expired keys are still served, it's up to the reader to skip them.
+	ExpireTime uint64
+}
+
+// Snapshot is a read-only view of the raw kvs of a backup. The keys are in the form of the
+// backed up ranges, i.e. with the API V2 prefix for the API V2 backups. It's safe for
+// concurrent use.
+type Snapshot struct {
+	storage    storage.ExternalStorage
+	apiVersion kvrpcpb.APIVersion
+	cipher     *backuppb.CipherInfo
+	cache      *sst.BlockCache
+	// files are of the default cf sorted by the start key.
+	files []*backuppb.File
+
+	mu     sync.Mutex
+	tables map[string]*sst.Table
+
+	// prefetch downloads each file as a whole by the ranged reader on its first fetch instead
+	// of reading the blocks one by one, nil means disabled.
+	prefetch   *storage.RangedReaderOptions
+	prefetchMu sync.Mutex
+	// prefetched is the content of the file downloaded last by prefetch.
+	prefetchedName string
+	prefetched     []byte
+}
+
+// NewSnapshot creates a snapshot of the backup files in the storage, cipher decrypts the
+// files encrypted by TiKV. The tables are opened on demand, and their blocks are cached.
+func NewSnapshot(
+	s storage.ExternalStorage,
+	apiVersion kvrpcpb.APIVersion,
+	files []*backuppb.File,
+	cipher *backuppb.CipherInfo,
+	cache *sst.BlockCache,
+) *Snapshot {
+	sorted := make([]*backuppb.File, 0, len(files))
+	for _, f := range files {
+		// the raw kvs are only in the default cf.
+		if f.Cf == "" || f.Cf == "default" {
+			sorted = append(sorted, f)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].StartKey, sorted[j].StartKey) < 0 })
+	return &Snapshot{
+		storage:    s,
+		apiVersion: apiVersion,
+		cipher:     cipher,
+		cache:      cache,
+		files:      sorted,
+		tables:     make(map[string]*sst.Table),
+	}
+}
+
+// SetPrefetch makes the snapshot download each file as a whole by the concurrent ranged reads
+// of opts, which suits the sequential scans of the whole backup, e.g. the logical restore.
+// Only the file downloaded last is kept in memory.
+func (s *Snapshot) SetPrefetch(opts storage.RangedReaderOptions) {
+	s.prefetch = &opts
+}
+
+// APIVersion returns the api version of the backup.
+func (s *Snapshot) APIVersion() kvrpcpb.APIVersion {
+	return s.apiVersion
+}
+
+// Files returns the number of the files of the snapshot.
+func (s *Snapshot) Files() int {
+	return len(s.files)
+}
+
+// CacheStats returns the statistics of the block cache.
+func (s *Snapshot) CacheStats() sst.CacheStats {
+	return s.cache.Stats()
+}
+
+// Get returns the entry of the key, it returns false if the key isn't in the backup.
+func (s *Snapshot) Get(ctx context.Context, key []byte) (Entry, bool, error) {
+	for _, f := range s.files {
+		if bytes.Compare(f.StartKey, key) > 0 {
+			break
+		}
+		if len(f.EndKey) > 0 && bytes.Compare(f.EndKey, key) <= 0 {
+			continue
+		}
+		entries, err := s.scanFile(ctx, f, key, nil, 1)
+		if err != nil {
+			return Entry{}, false, errors.Trace(err)
+		}
+		if len(entries) > 0 && bytes.Equal(entries[0].Key, key) {
+			return entries[0], true, nil
+		}
+	}
+	return Entry{}, false, nil
+}
+
+// Scan returns at most limit entries in [startKey, endKey) by the order of the keys,
+// an empty endKey means the end of the key space.
+func (s *Snapshot) Scan(ctx context.Context, startKey, endKey []byte, limit int) ([]Entry, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	var entries []Entry
+	for _, f := range s.files {
+		if len(endKey) > 0 && bytes.Compare(f.StartKey, endKey) >= 0 {
+			break
+		}
+		// the following files can't have keys before the entries found.
+		if len(entries) >= limit && bytes.Compare(f.StartKey, entries[limit-1].Key) > 0 {
+			break
+		}
+		if len(f.EndKey) > 0 && bytes.Compare(f.EndKey, startKey) <= 0 {
+			continue
+		}
+		found, err := s.scanFile(ctx, f, startKey, endKey, limit)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		entries = mergeEntries(entries, found, limit)
+	}
+	return entries, nil
+}
+
+// mergeEntries merges the sorted entries, the first ones win if the files overlap.
+func mergeEntries(a, b []Entry, limit int) []Entry {
+	if len(a) == 0 {
+		return b
+	}
+	merged := make([]Entry, 0, len(a)+len(b))
+	i, j := 0, 0
+	for len(merged) < limit && (i < len(a) || j < len(b)) {
+		switch {
+		case j == len(b):
+			merged = append(merged, a[i])
+			i++
+		case i == len(a):
+			merged = append(merged, b[j])
+			j++
+		default:
+			switch c := bytes.Compare(a[i].Key, b[j].Key); {
+			case c < 0:
+				merged = append(merged, a[i])
+				i++
+			case c > 0:
+				merged = append(merged, b[j])
+				j++
+			default:
+				merged = append(merged, a[i])
+				i++
+				j++
+			}
+		}
+	}
+	return merged
+}
+
+// scanFile returns at most limit entries in [startKey, endKey) of the file.
+func (s *Snapshot) scanFile(ctx context.Context, f *backuppb.File, startKey, endKey []byte, limit int) ([]Entry, error) {
+	table, err := s.openTable(ctx, f)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	it := table.NewIterator(ctx)
+	var (
+		entries []Entry
+		lastKey []byte
+	)
+	for ok := it.Seek(s.encodeKey(startKey)); ok && len(entries) < limit; ok = it.Next() {
+		entry, deleted, err := s.decode(it.Key(), it.Value())
+		if err != nil {
+			return nil, errors.Annotatef(err, "file %s", f.Name)
+		}
+		if len(endKey) > 0 && bytes.Compare(entry.Key, endKey) >= 0 {
+			break
+		}
+		// the API V2 keys may have several versions, the latest comes first.
+		if lastKey != nil && bytes.Equal(entry.Key, lastKey) {
+			continue
+		}
+		lastKey = entry.Key
+		if !deleted {
+			entries = append(entries, entry)
+		}
+	}
+	if err = it.Err(); err != nil {
+		return nil, errors.Annotatef(err, "file %s", f.Name)
+	}
+	return entries, nil
+}
+
+// encodeKey encodes the key into the form in the SST files, it's less than or equal to
+// all the versions of the key.
+func (s *Snapshot) encodeKey(key []byte) []byte {
+	encoded := []byte{dataKeyPrefix}
+	if s.apiVersion == kvrpcpb.APIVersion_V2 {
+		return codec.EncodeBytes(encoded, key)
+	}
+	return append(encoded, key...)
+}
+
+// decode decodes the key and the value in the SST file, it returns whether the key is
+// deleted, which is only recorded in API V2.
+func (s *Snapshot) decode(key, value []byte) (Entry, bool, error) {
+	if len(key) == 0 || key[0] != dataKeyPrefix {
+		return Entry{}, false, errors.Annotate(berrors.ErrSSTCorrupted, "the key has no data prefix")
+	}
+	key = key[1:]
+	var entry Entry
+	switch s.apiVersion {
+	case kvrpcpb.APIVersion_V2:
+		rest, decoded, err := codec.DecodeBytes(key, nil)
+		if err != nil || len(rest) != tsSize {
+			return Entry{}, false, errors.Annotate(berrors.ErrSSTCorrupted, "bad API V2 key")
+		}
+		if len(value) < 1 {
+			return Entry{}, false, errors.Annotate(berrors.ErrSSTCorrupted, "bad API V2 value")
+		}
+		flags := value[len(value)-1]
+		value = value[:len(value)-1]
+		if flags&valueFlagTTL != 0 {
+			if len(value) < ttlSize {
+				return Entry{}, false, errors.Annotate(berrors.ErrSSTCorrupted, "bad API V2 value")
+			}
+			entry.ExpireTime = binary.BigEndian.Uint64(value[len(value)-ttlSize:])
+			value = value[:len(value)-ttlSize]
+		}
+		entry.Key, entry.Value = decoded, append([]byte{}, value...)
+		return entry, flags&valueFlagDelete != 0, nil
+	case kvrpcpb.APIVersion_V1TTL:
+		if len(value) < ttlSize {
+			return Entry{}, false, errors.Annotate(berrors.ErrSSTCorrupted, "bad API V1TTL value")
+		}
+		entry.ExpireTime = binary.BigEndian.Uint64(value[len(value)-ttlSize:])
+		value = value[:len(value)-ttlSize]
+	}
+	entry.Key, entry.Value = append([]byte{}, key...), append([]byte{}, value...)
+	return entry, false, nil
+}
+
+// openTable opens the table of the file once, only its footer and meta blocks are read.
+func (s *Snapshot) openTable(ctx context.Context, f *backuppb.File) (*sst.Table, error) {
+	s.mu.Lock()
+	table, ok := s.tables[f.Name]
+	s.mu.Unlock()
+	if ok {
+		return table, nil
+	}
+	size := int64(f.Size_)
+	if size == 0 {
+		// the older backups don't record the size.
+		r, err := s.storage.Open(ctx, f.Name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		size, err = r.Seek(0, io.SeekEnd)
+		r.Close()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	table, err := sst.Open(ctx, f.Name, size, s.fetcher(f, size), s.cache)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s.mu.Lock()
+	s.tables[f.Name] = table
+	s.mu.Unlock()
+	return table, nil
+}
+
+// fetcher reads the ranges of the file of fileSize, and decrypts them if the file is encrypted.
+func (s *Snapshot) fetcher(f *backuppb.File, fileSize int64) sst.Fetcher {
+	return func(ctx context.Context, offset, size int64) ([]byte, error) {
+		var data []byte
+		var err error
+		if s.prefetch != nil {
+			data, err = s.readPrefetched(ctx, f.Name, fileSize, offset, offset+size)
+		} else {
+			data, err = storage.ReadRange(ctx, s.storage, f.Name, offset, offset+size)
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(f.CipherIv) == 0 || s.cipher == nil || s.cipher.CipherType == encryptionpb.EncryptionMethod_PLAINTEXT {
+			return data, nil
+		}
+		return decryptCTRAt(data, s.cipher.CipherKey, f.CipherIv, offset)
+	}
+}
+
+// readPrefetched returns [start, end) of the file, the file is downloaded by the ranged reader
+// if it isn't the one downloaded last.
+func (s *Snapshot) readPrefetched(ctx context.Context, name string, fileSize, start, end int64) ([]byte, error) {
+	s.prefetchMu.Lock()
+	defer s.prefetchMu.Unlock()
+	if s.prefetchedName != name {
+		r := storage.NewRangedReader(ctx, s.storage, name, fileSize, *s.prefetch)
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		s.prefetchedName, s.prefetched = name, data
+	}
+	if start < 0 || end > int64(len(s.prefetched)) || start > end {
+		return nil, errors.Annotatef(berrors.ErrSSTCorrupted,
+			"read [%d, %d) out of the file %s of size %d", start, end, name, len(s.prefetched))
+	}
+	return append([]byte{}, s.prefetched[start:end]...), nil
+}
+
+// decryptCTRAt decrypts the data at the offset of the file encrypted by AES-CTR, the
+// counter of the offset is the iv added by the number of the blocks before it.
+func decryptCTRAt(data, key, iv []byte, offset int64) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, err.Error())
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "bad iv length %d", len(iv))
+	}
+	counter := make([]byte, aes.BlockSize)
+	copy(counter, iv)
+	carry := uint64(offset / aes.BlockSize)
+	for i := aes.BlockSize - 1; i >= 0 && carry > 0; i-- {
+		sum := uint64(counter[i]) + carry&0xff
+		counter[i] = byte(sum)
+		carry = carry>>8 + sum>>8
+	}
+	stream := cipher.NewCTR(block, counter)
+	skip := make([]byte, offset%aes.BlockSize)
+	stream.XORKeyStream(skip, skip)
+	decrypted := make([]byte, len(data))
+	stream.XORKeyStream(decrypted, data)
+	return decrypted, nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mount
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/util/encrypt"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/util/codec"
+	"github.com/tikv/migration/br/pkg/sst"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// testdata/raw_v1 is an API V1 raw backup by TiKV v5.2.1, it has 1000 keys of
+// "test_<number>" in 2 files, whose values are 64 "A"s.
+func newTestSnapshot(t *testing.T) *Snapshot {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage("testdata/raw_v1")
+	require.NoError(t, err)
+	data, err := s.ReadFile(ctx, "backupmeta")
+	require.NoError(t, err)
+	meta := &backuppb.BackupMeta{}
+	require.NoError(t, proto.Unmarshal(data, meta))
+	return NewSnapshot(s, meta.ApiVersion, meta.Files, nil, sst.NewBlockCache(1<<20))
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	snapshot := newTestSnapshot(t)
+	require.Equal(t, 2, snapshot.Files())
+
+	entries, err := snapshot.Scan(ctx, nil, nil, 2000)
+	require.NoError(t, err)
+	require.Len(t, entries, 1000)
+	for i, entry := range entries {
+		require.True(t, bytes.HasPrefix(entry.Key, []byte("test_")))
+		require.Equal(t, bytes.Repeat([]byte("A"), 64), entry.Value)
+		require.Zero(t, entry.ExpireTime)
+		if i > 0 {
+			require.Less(t, string(entries[i-1].Key), string(entry.Key))
+		}
+	}
+
+	// the scan across the files.
+	scanned, err := snapshot.Scan(ctx, entries[498].Key, entries[503].Key, 100)
+	require.NoError(t, err)
+	require.Equal(t, entries[498:503], scanned)
+	scanned, err = snapshot.Scan(ctx, entries[499].Key, nil, 3)
+	require.NoError(t, err)
+	require.Equal(t, entries[499:502], scanned)
+
+	entry, ok, err := snapshot.Get(ctx, entries[700].Key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, entries[700], entry)
+	_, ok, err = snapshot.Get(ctx, append(entries[700].Key, 0))
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, ok, err = snapshot.Get(ctx, []byte("x"))
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NotZero(t, snapshot.CacheStats().Hits)
+}
+
+func TestSnapshotPrefetch(t *testing.T) {
+	ctx := context.Background()
+	expected, err := newTestSnapshot(t).Scan(ctx, nil, nil, 2000)
+	require.NoError(t, err)
+
+	snapshot := newTestSnapshot(t)
+	// the small parts make each file downloaded by several ranged reads.
+	snapshot.SetPrefetch(storage.RangedReaderOptions{PartSize: 4096, Concurrency: 2})
+	entries, err := snapshot.Scan(ctx, nil, nil, 2000)
+	require.NoError(t, err)
+	require.Equal(t, expected, entries)
+	entries, err = snapshot.Scan(ctx, expected[498].Key, expected[503].Key, 100)
+	require.NoError(t, err)
+	require.Equal(t, expected[498:503], entries)
+}
+
+func TestDecode(t *testing.T) {
+	ttl := make([]byte, ttlSize)
+	binary.BigEndian.PutUint64(ttl, 1234)
+
+	s := &Snapshot{apiVersion: kvrpcpb.APIVersion_V1TTL}
+	entry, deleted, err := s.decode([]byte("zk"), append([]byte("v"), ttl...))
+	require.NoError(t, err)
+	require.False(t, deleted)
+	require.Equal(t, Entry{Key: []byte("k"), Value: []byte("v"), ExpireTime: 1234}, entry)
+	_, _, err = s.decode([]byte("k"), []byte("v"))
+	require.Error(t, err)
+
+	s = &Snapshot{apiVersion: kvrpcpb.APIVersion_V2}
+	key := append(s.encodeKey([]byte("rkey")), make([]byte, tsSize)...)
+	require.True(t, bytes.HasPrefix(key, codec.EncodeBytes([]byte("z"), []byte("rkey"))))
+	entry, deleted, err = s.decode(key, append(append([]byte("v"), ttl...), valueFlagTTL))
+	require.NoError(t, err)
+	require.False(t, deleted)
+	require.Equal(t, Entry{Key: []byte("rkey"), Value: []byte("v"), ExpireTime: 1234}, entry)
+	_, deleted, err = s.decode(key, []byte{valueFlagDelete})
+	require.NoError(t, err)
+	require.True(t, deleted)
+}
+
+func TestDecryptCTRAt(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	// the counter carries over the bytes.
+	iv := append(bytes.Repeat([]byte{0}, 14), 0xff, 0xfe)
+	plain := bytes.Repeat([]byte("0123456789"), 100)
+	encrypted, err := encrypt.AESEncryptWithCTR(plain, key, iv)
+	require.NoError(t, err)
+	for _, offset := range []int64{0, 5, 16, 37, 500} {
+		decrypted, err := decryptCTRAt(encrypted[offset:offset+100], key, iv, offset)
+		require.NoError(t, err)
+		require.Equal(t, plain[offset:offset+100], decrypted)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(NewHandler(newTestSnapshot(t), "raw"))
+	defer server.Close()
+	get := func(path string, query url.Values, v interface{}) int {
+		resp, err := http.Get(server.URL + APIPrefix + path + "?" + query.Encode())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		return resp.StatusCode
+	}
+
+	var info Info
+	require.Equal(t, http.StatusOK, get("/info", nil, &info))
+	require.Equal(t, "V1", info.APIVersion)
+	require.Equal(t, 2, info.Files)
+
+	var kvs []KV
+	require.Equal(t, http.StatusOK, get("/raw/scan", url.Values{"start": {"test_"}, "limit": {"2"}}, &kvs))
+	require.Len(t, kvs, 2)
+	require.Equal(t, "test_-000001986070314", kvs[0].Key)
+	require.Less(t, kvs[0].Key, kvs[1].Key)
+
+	var kv KV
+	require.Equal(t, http.StatusOK, get("/raw/get", url.Values{"key": {kvs[1].Key}}, &kv))
+	require.Equal(t, kvs[1], kv)
+	var errResp errorResponse
+	require.Equal(t, http.StatusNotFound, get("/raw/get", url.Values{"key": {"nope"}}, &errResp))
+	require.Equal(t, http.StatusBadRequest, get("/raw/get", nil, &errResp))
+	require.Equal(t, http.StatusBadRequest, get("/raw/scan", url.Values{"limit": {"0"}}, &errResp))
+}
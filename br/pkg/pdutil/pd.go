@@ -24,6 +24,7 @@ import (
 	"github.com/pingcap/tidb/store/pdtypes"
 	"github.com/tikv/client-go/v2/util/codec"
 	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/failpoints"
 	"github.com/tikv/migration/br/pkg/httputil"
 	pd "github.com/tikv/pd/client"
 	"go.uber.org/zap"
@@ -34,7 +35,13 @@ const (
 	clusterVersionPrefix = "pd/api/v1/config/cluster-version"
 	regionCountPrefix    = "pd/api/v1/stats/region"
 	storePrefix          = "pd/api/v1/store"
+	minResolvedTSPrefix  = "pd/api/v1/min-resolved-ts"
+	replicateCfgPrefix   = "pd/api/v1/config/replicate"
+	keyspacesPrefix      = "pd/api/v2/keyspaces"
+	clusterPrefix        = "pd/api/v1/cluster"
 	schedulerPrefix      = "pd/api/v1/schedulers"
+	operatorsPrefix      = "pd/api/v1/operators"
+	gcSafePointPrefix    = "pd/api/v1/gc/safepoint"
 	maxMsgSize           = int(128 * units.MiB) // pd.ScanRegion may return a large response
 	scheduleConfigPrefix = "pd/api/v1/config/schedule"
 	pauseTimeout         = 5 * time.Minute
@@ -178,7 +185,7 @@ func pdRequest(
 }
 
 func pdRequestRetryInterval() time.Duration {
-	failpoint.Inject("FastRetry", func(v failpoint.Value) {
+	failpoint.Inject(failpoints.FastRetry, func(v failpoint.Value) {
 		if v.(bool) {
 			failpoint.Return(0)
 		}
@@ -266,7 +273,7 @@ func parseVersion(versionBytes []byte) *semver.Version {
 			zap.ByteString("version", versionBytes), zap.Error(err))
 		version = &semver.Version{Major: 0, Minor: 0, Patch: 0}
 	}
-	failpoint.Inject("PDEnabledPauseConfig", func(val failpoint.Value) {
+	failpoint.Inject(failpoints.PDEnabledPauseConfig, func(val failpoint.Value) {
 		if val.(bool) {
 			// test pause config is enable
 			version = &semver.Version{Major: 5, Minor: 0, Patch: 0}
@@ -326,6 +333,22 @@ func (p *PdController) GetRegionCount(ctx context.Context, startKey, endKey []by
 func (p *PdController) getRegionCountWith(
 	ctx context.Context, get pdHTTPRequest, startKey, endKey []byte,
 ) (int, error) {
+	stats, err := p.getRegionStatsWith(ctx, get, startKey, endKey)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return stats.Count, nil
+}
+
+// GetRegionStats returns the statistics of the regions in the specified range, e.g. the
+// approximate size in MiB of the regions led by each store.
+func (p *PdController) GetRegionStats(ctx context.Context, startKey, endKey []byte) (*pdtypes.RegionStats, error) {
+	return p.getRegionStatsWith(ctx, pdRequest, startKey, endKey)
+}
+
+func (p *PdController) getRegionStatsWith(
+	ctx context.Context, get pdHTTPRequest, startKey, endKey []byte,
+) (*pdtypes.RegionStats, error) {
 	// TiKV reports region start/end keys to PD in memcomparable-format.
 	var start, end string
 	start = url.QueryEscape(string(codec.EncodeBytes(nil, startKey)))
@@ -342,14 +365,13 @@ func (p *PdController) getRegionCountWith(
 			err = e
 			continue
 		}
-		regionsMap := make(map[string]interface{})
-		err = json.Unmarshal(v, &regionsMap)
-		if err != nil {
-			return 0, errors.Trace(err)
+		stats := &pdtypes.RegionStats{}
+		if err = json.Unmarshal(v, stats); err != nil {
+			return nil, errors.Trace(err)
 		}
-		return int(regionsMap["count"].(float64)), nil
+		return stats, nil
 	}
-	return 0, errors.Trace(err)
+	return nil, errors.Trace(err)
 }
 
 // GetStoreInfo returns the info of store with the specified id.
@@ -379,6 +401,229 @@ func (p *PdController) getStoreInfoWith(
 	return nil, errors.Trace(err)
 }
 
+// ReplicationConfig is the replication config of PD.
+type ReplicationConfig struct {
+	MaxReplicas uint64 `json:"max-replicas"`
+	// LocationLabels is the comma separated labels of the topology, e.g. "zone,rack,host".
+	LocationLabels string `json:"location-labels"`
+}
+
+// GetReplicationConfig returns the replication config of PD.
+func (p *PdController) GetReplicationConfig(ctx context.Context) (*ReplicationConfig, error) {
+	return p.getReplicationConfigWith(ctx, pdRequest)
+}
+
+func (p *PdController) getReplicationConfigWith(ctx context.Context, get pdHTTPRequest) (*ReplicationConfig, error) {
+	var err error
+	for _, addr := range p.addrs {
+		v, e := get(ctx, addr, replicateCfgPrefix, p.cli, http.MethodGet, nil)
+		if e != nil {
+			err = e
+			continue
+		}
+		cfg := &ReplicationConfig{}
+		if err = json.Unmarshal(v, cfg); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return cfg, nil
+	}
+	return nil, errors.Trace(err)
+}
+
+// GetMinResolvedTS returns the min resolved ts of the cluster, i.e. the safe-ts
+// before which all the stores can serve consistent snapshot reads.
+func (p *PdController) GetMinResolvedTS(ctx context.Context) (uint64, error) {
+	return p.getMinResolvedTSWith(ctx, pdRequest)
+}
+
+func (p *PdController) getMinResolvedTSWith(ctx context.Context, get pdHTTPRequest) (uint64, error) {
+	var err error
+	for _, addr := range p.addrs {
+		v, e := get(ctx, addr, minResolvedTSPrefix, p.cli, http.MethodGet, nil)
+		if e != nil {
+			err = e
+			continue
+		}
+		resp := struct {
+			MinResolvedTS uint64 `json:"min_resolved_ts"`
+			IsRealTime    bool   `json:"is_real_time"`
+		}{}
+		if err = json.Unmarshal(v, &resp); err != nil {
+			return 0, errors.Trace(err)
+		}
+		if !resp.IsRealTime || resp.MinResolvedTS == 0 {
+			return 0, errors.Annotatef(berrors.ErrPDInvalidResponse,
+				"min resolved ts is not available, please check the PD config `min-resolved-ts-persistence-interval`: %s", v)
+		}
+		return resp.MinResolvedTS, nil
+	}
+	return 0, errors.Trace(err)
+}
+
+// FetchClusterID fetches the cluster ID from PD, unlike the one cached by the PD client,
+// it's the ID of the cluster currently served by PD, e.g. after PD is recovered.
+func (p *PdController) FetchClusterID(ctx context.Context) (uint64, error) {
+	return p.fetchClusterIDWith(ctx, pdRequest)
+}
+
+func (p *PdController) fetchClusterIDWith(ctx context.Context, get pdHTTPRequest) (uint64, error) {
+	var err error
+	for _, addr := range p.addrs {
+		v, e := get(ctx, addr, clusterPrefix, p.cli, http.MethodGet, nil)
+		if e != nil {
+			err = e
+			continue
+		}
+		resp := struct {
+			ID uint64 `json:"id"`
+		}{}
+		if err = json.Unmarshal(v, &resp); err != nil {
+			return 0, errors.Trace(err)
+		}
+		if resp.ID == 0 {
+			return 0, errors.Annotatef(berrors.ErrPDInvalidResponse, "no cluster ID in %s", v)
+		}
+		return resp.ID, nil
+	}
+	return 0, errors.Trace(err)
+}
+
+// ServiceSafePoint is a service safe point in PD, GC doesn't advance past the oldest of them.
+type ServiceSafePoint struct {
+	ServiceID string `json:"service_id"`
+	// ExpiredAt is the unix time in seconds the safe point expires at.
+	ExpiredAt int64  `json:"expired_at"`
+	SafePoint uint64 `json:"safe_point"`
+}
+
+// GCSafePoints are the GC safe point and the service safe points of the cluster.
+type GCSafePoints struct {
+	GCSafePoint       uint64             `json:"gc_safe_point"`
+	ServiceSafePoints []ServiceSafePoint `json:"service_gc_safe_points"`
+}
+
+// GetGCSafePoints returns the GC safe point and all the service safe points of the cluster.
+func (p *PdController) GetGCSafePoints(ctx context.Context) (*GCSafePoints, error) {
+	return p.getGCSafePointsWith(ctx, pdRequest)
+}
+
+func (p *PdController) getGCSafePointsWith(ctx context.Context, get pdHTTPRequest) (*GCSafePoints, error) {
+	var err error
+	for _, addr := range p.addrs {
+		v, e := get(ctx, addr, gcSafePointPrefix, p.cli, http.MethodGet, nil)
+		if e != nil {
+			err = e
+			continue
+		}
+		safePoints := &GCSafePoints{}
+		if err = json.Unmarshal(v, safePoints); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return safePoints, nil
+	}
+	return nil, errors.Trace(err)
+}
+
+// KeyspaceMeta is the meta of an API V2 keyspace in PD.
+type KeyspaceMeta struct {
+	ID     uint32            `json:"id"`
+	Name   string            `json:"name"`
+	State  string            `json:"state,omitempty"`
+	Config map[string]string `json:"config,omitempty"`
+}
+
+// GetKeyspaces returns all the keyspaces of the cluster.
+func (p *PdController) GetKeyspaces(ctx context.Context) ([]*KeyspaceMeta, error) {
+	return p.getKeyspacesWith(ctx, pdRequest)
+}
+
+func (p *PdController) getKeyspacesWith(ctx context.Context, get pdHTTPRequest) ([]*KeyspaceMeta, error) {
+	var err error
+	for _, addr := range p.addrs {
+		var keyspaces []*KeyspaceMeta
+		pageToken := ""
+		for {
+			prefix := keyspacesPrefix
+			if len(pageToken) > 0 {
+				prefix += "?page_token=" + url.QueryEscape(pageToken)
+			}
+			v, e := get(ctx, addr, prefix, p.cli, http.MethodGet, nil)
+			if e != nil {
+				err = e
+				break
+			}
+			resp := struct {
+				Keyspaces     []*KeyspaceMeta `json:"keyspaces"`
+				NextPageToken string          `json:"next_page_token"`
+			}{}
+			if err = json.Unmarshal(v, &resp); err != nil {
+				return nil, errors.Trace(err)
+			}
+			keyspaces = append(keyspaces, resp.Keyspaces...)
+			if len(resp.NextPageToken) == 0 || resp.NextPageToken == pageToken {
+				return keyspaces, nil
+			}
+			pageToken = resp.NextPageToken
+		}
+	}
+	return nil, errors.Trace(err)
+}
+
+// CreateKeyspace creates a keyspace with the name and the config, the ID is allocated by PD.
+func (p *PdController) CreateKeyspace(ctx context.Context, name string, config map[string]string) (*KeyspaceMeta, error) {
+	return p.createKeyspaceWith(ctx, name, config, pdRequest)
+}
+
+func (p *PdController) createKeyspaceWith(
+	ctx context.Context, name string, config map[string]string, post pdHTTPRequest,
+) (*KeyspaceMeta, error) {
+	body, err := json.Marshal(&struct {
+		Name   string            `json:"name"`
+		Config map[string]string `json:"config,omitempty"`
+	}{Name: name, Config: config})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, addr := range p.addrs {
+		v, e := post(ctx, addr, keyspacesPrefix, p.cli, http.MethodPost, bytes.NewBuffer(body))
+		if e != nil {
+			err = e
+			continue
+		}
+		keyspace := &KeyspaceMeta{}
+		if err = json.Unmarshal(v, keyspace); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return keyspace, nil
+	}
+	return nil, errors.Trace(err)
+}
+
+// TransferLeader asks PD to transfer the leader of the region to the peer on the store.
+// The operator is only created, PD transfers the leader asynchronously.
+func (p *PdController) TransferLeader(ctx context.Context, regionID, toStoreID uint64) error {
+	return p.transferLeaderWith(ctx, regionID, toStoreID, pdRequest)
+}
+
+func (p *PdController) transferLeaderWith(ctx context.Context, regionID, toStoreID uint64, post pdHTTPRequest) error {
+	body, err := json.Marshal(&struct {
+		Name      string `json:"name"`
+		RegionID  uint64 `json:"region_id"`
+		ToStoreID uint64 `json:"to_store_id"`
+	}{Name: "transfer-leader", RegionID: regionID, ToStoreID: toStoreID})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, addr := range p.addrs {
+		_, e := post(ctx, addr, operatorsPrefix, p.cli, http.MethodPost, bytes.NewBuffer(body))
+		if e == nil {
+			return nil
+		}
+		err = e
+	}
+	return errors.Trace(err)
+}
+
 func (p *PdController) doPauseSchedulers(ctx context.Context, schedulers []string, post pdHTTPRequest) ([]string, error) {
 	// pause this scheduler with 300 seconds
 	body, err := json.Marshal(pauseSchedulerBody{Delay: int64(pauseTimeout)})
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -141,7 +142,7 @@ func TestRegionCount(t *testing.T) {
 		t.Log(hex.EncodeToString([]byte(start)))
 		t.Log(hex.EncodeToString([]byte(end)))
 		scanRegions := regions.ScanRange([]byte(start), []byte(end), 0)
-		stats := pdtypes.RegionStats{Count: len(scanRegions)}
+		stats := pdtypes.RegionStats{Count: len(scanRegions), StorageSize: int64(len(scanRegions)) * 96}
 		ret, err := json.Marshal(stats)
 		require.NoError(t, err)
 		return ret, nil
@@ -160,6 +161,11 @@ func TestRegionCount(t *testing.T) {
 	resp, err = pdController.getRegionCountWith(ctx, mock, []byte{1, 2}, []byte{1, 4})
 	require.NoError(t, err)
 	require.Equal(t, 2, resp)
+
+	stats, err := pdController.getRegionStatsWith(ctx, mock, []byte{1, 2}, []byte{1, 4})
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.Count)
+	require.Equal(t, int64(192), stats.StorageSize)
 }
 
 func TestPDVersion(t *testing.T) {
@@ -237,3 +243,143 @@ func TestStoreInfo(t *testing.T) {
 	require.Equal(t, "Tombstone", resp.Store.StateName)
 	require.Equal(t, uint64(1024), uint64(resp.Status.Available))
 }
+
+func TestGetMinResolvedTS(t *testing.T) {
+	resp := `{"min_resolved_ts":434619113386344449,"is_real_time":true,"persist_interval":"1s"}`
+	mock := func(
+		_ context.Context, addr string, prefix string, _ *http.Client, _ string, _ io.Reader,
+	) ([]byte, error) {
+		require.Equal(t, "http://mock/pd/api/v1/min-resolved-ts", fmt.Sprintf("%s/%s", addr, prefix))
+		return []byte(resp), nil
+	}
+
+	pdController := &PdController{addrs: []string{"http://mock"}}
+	ctx := context.Background()
+	ts, err := pdController.getMinResolvedTSWith(ctx, mock)
+	require.NoError(t, err)
+	require.Equal(t, uint64(434619113386344449), ts)
+
+	resp = `{"min_resolved_ts":0,"is_real_time":false}`
+	_, err = pdController.getMinResolvedTSWith(ctx, mock)
+	require.Error(t, err)
+}
+
+func TestFetchClusterID(t *testing.T) {
+	resp := `{"id":7117592880932085862,"max_peer_count":3}`
+	mock := func(
+		_ context.Context, addr string, prefix string, _ *http.Client, _ string, _ io.Reader,
+	) ([]byte, error) {
+		require.Equal(t, "http://mock/pd/api/v1/cluster", fmt.Sprintf("%s/%s", addr, prefix))
+		return []byte(resp), nil
+	}
+
+	pdController := &PdController{addrs: []string{"http://mock"}}
+	ctx := context.Background()
+	id, err := pdController.fetchClusterIDWith(ctx, mock)
+	require.NoError(t, err)
+	require.Equal(t, uint64(7117592880932085862), id)
+
+	resp = `{}`
+	_, err = pdController.fetchClusterIDWith(ctx, mock)
+	require.Error(t, err)
+}
+
+func TestGetGCSafePoints(t *testing.T) {
+	mock := func(
+		_ context.Context, addr string, prefix string, _ *http.Client, _ string, _ io.Reader,
+	) ([]byte, error) {
+		require.Equal(t, "http://mock/pd/api/v1/gc/safepoint", fmt.Sprintf("%s/%s", addr, prefix))
+		return []byte(`{"service_gc_safe_points":[` +
+			`{"service_id":"gc_worker","expired_at":9223372036854775807,"safe_point":434619113386344449},` +
+			`{"service_id":"ticdc","expired_at":1660000000,"safe_point":434619000000000000}],` +
+			`"gc_safe_point":434619100000000000}`), nil
+	}
+
+	pdController := &PdController{addrs: []string{"http://mock"}}
+	safePoints, err := pdController.getGCSafePointsWith(context.Background(), mock)
+	require.NoError(t, err)
+	require.Equal(t, &GCSafePoints{
+		GCSafePoint: 434619100000000000,
+		ServiceSafePoints: []ServiceSafePoint{
+			{ServiceID: "gc_worker", ExpiredAt: math.MaxInt64, SafePoint: 434619113386344449},
+			{ServiceID: "ticdc", ExpiredAt: 1660000000, SafePoint: 434619000000000000},
+		},
+	}, safePoints)
+}
+
+func TestGetReplicationConfig(t *testing.T) {
+	mock := func(
+		_ context.Context, addr string, prefix string, _ *http.Client, _ string, _ io.Reader,
+	) ([]byte, error) {
+		require.Equal(t, "http://mock/pd/api/v1/config/replicate", fmt.Sprintf("%s/%s", addr, prefix))
+		return []byte(`{"max-replicas":3,"location-labels":"zone,host","strictly-match-label":"false"}`), nil
+	}
+
+	pdController := &PdController{addrs: []string{"http://mock"}}
+	cfg, err := pdController.getReplicationConfigWith(context.Background(), mock)
+	require.NoError(t, err)
+	require.Equal(t, &ReplicationConfig{MaxReplicas: 3, LocationLabels: "zone,host"}, cfg)
+}
+
+func TestGetKeyspaces(t *testing.T) {
+	pages := map[string]string{
+		"http://mock/pd/api/v2/keyspaces": `{"keyspaces":[{"id":0,"name":"DEFAULT","state":"ENABLED"},` +
+			`{"id":1,"name":"a","state":"ENABLED","config":{"k":"v"}}],"next_page_token":"2"}`,
+		"http://mock/pd/api/v2/keyspaces?page_token=2": `{"keyspaces":[{"id":2,"name":"b","state":"ENABLED"}]}`,
+	}
+	mock := func(
+		_ context.Context, addr string, prefix string, _ *http.Client, _ string, _ io.Reader,
+	) ([]byte, error) {
+		resp, ok := pages[fmt.Sprintf("%s/%s", addr, prefix)]
+		require.True(t, ok)
+		return []byte(resp), nil
+	}
+
+	pdController := &PdController{addrs: []string{"http://mock"}}
+	keyspaces, err := pdController.getKeyspacesWith(context.Background(), mock)
+	require.NoError(t, err)
+	require.Equal(t, []*KeyspaceMeta{
+		{ID: 0, Name: "DEFAULT", State: "ENABLED"},
+		{ID: 1, Name: "a", State: "ENABLED", Config: map[string]string{"k": "v"}},
+		{ID: 2, Name: "b", State: "ENABLED"},
+	}, keyspaces)
+}
+
+func TestCreateKeyspace(t *testing.T) {
+	mock := func(
+		_ context.Context, addr string, prefix string, _ *http.Client, method string, body io.Reader,
+	) ([]byte, error) {
+		require.Equal(t, "http://mock/pd/api/v2/keyspaces", fmt.Sprintf("%s/%s", addr, prefix))
+		require.Equal(t, http.MethodPost, method)
+		data, err := io.ReadAll(body)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"name":"a","config":{"k":"v"}}`, string(data))
+		return []byte(`{"id":1,"name":"a","state":"ENABLED","config":{"k":"v"}}`), nil
+	}
+
+	pdController := &PdController{addrs: []string{"http://mock"}}
+	keyspace, err := pdController.createKeyspaceWith(context.Background(), "a", map[string]string{"k": "v"}, mock)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), keyspace.ID)
+}
+
+func TestTransferLeader(t *testing.T) {
+	mock := func(
+		_ context.Context, addr string, prefix string, _ *http.Client, method string, body io.Reader,
+	) ([]byte, error) {
+		if addr == "http://down" {
+			return nil, errors.New("connection refused")
+		}
+		require.Equal(t, "http://mock/pd/api/v1/operators", fmt.Sprintf("%s/%s", addr, prefix))
+		require.Equal(t, http.MethodPost, method)
+		data, err := io.ReadAll(body)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"name":"transfer-leader","region_id":2,"to_store_id":3}`, string(data))
+		return []byte(`"The operator is created."`), nil
+	}
+
+	pdController := &PdController{addrs: []string{"http://down", "http://mock"}}
+	require.NoError(t, pdController.transferLeaderWith(context.Background(), 2, 3, mock))
+	pdController = &PdController{addrs: []string{"http://down"}}
+	require.Error(t, pdController.transferLeaderWith(context.Background(), 2, 3, mock))
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// chunkedReader decodes the aws-chunked encoding of the signature v4 streaming uploads:
+//
+//	{hex size};chunk-signature={signature}\r\n{data}\r\n...0;chunk-signature={signature}\r\n\r\n
+//
+// The chunk signatures are not verified.
+type chunkedReader struct {
+	r *bufio.Reader
+	// remaining is the bytes left in the current chunk.
+	remaining int64
+	eof       bool
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", errors.Trace(err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+		header, err := c.readLine()
+		if err != nil {
+			return 0, err
+		}
+		size, err := strconv.ParseInt(strings.SplitN(header, ";", 2)[0], 16, 64)
+		if err != nil || size < 0 {
+			return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid aws-chunked header '%s'", header)
+		}
+		if size == 0 {
+			c.eof = true
+			// the final chunk is followed by an empty line.
+			if _, err = c.readLine(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		c.remaining = size
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return n, errors.Trace(err)
+	}
+	if c.remaining == 0 {
+		// the data of a chunk is followed by \r\n.
+		if line, err := c.readLine(); err != nil {
+			return n, err
+		} else if len(line) > 0 {
+			return n, errors.Annotate(berrors.ErrInvalidArgument, "invalid aws-chunked data")
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,492 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package relay implements the S3 compatible endpoint served by BR during a cluster to
+// cluster copy. The source TiKV stores upload the backup to it, and the importers of the
+// destination cluster download the files from it, so that the data only passes through
+// the local disk of BR instead of an external storage.
+package relay
+
+import (
+	"bufio"
+	"crypto/md5" // #nosec G501
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+const (
+	// uploadsDir is the directory under the spool keeping the parts of the multipart uploads.
+	uploadsDir = ".uploads"
+	// defaultMaxKeys is the max number of keys listed in a response.
+	defaultMaxKeys = 1000
+	// streamingPayload is the x-amz-content-sha256 of the bodies in the aws-chunked encoding.
+	streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+)
+
+// Server is the S3 compatible endpoint spooling the objects in a local directory. It serves
+// the requests in the path style, i.e. /{bucket}/{key}, of the operations used by BR and
+// TiKV: put, get (with range), head, delete and list objects, and the multipart uploads.
+//
+// The signatures of the requests are not verified, but the requests must carry the access
+// key of the server, so that a stale TiKV request of another copy is not mixed in.
+type Server struct {
+	dir       string
+	accessKey string
+
+	mu           sync.Mutex
+	uploads      map[string]string
+	nextUploadID uint64
+
+	received int64
+	sent     int64
+}
+
+// NewServer creates the relay server spooling the objects in dir.
+func NewServer(dir string, accessKey string) (*Server, error) {
+	if err := os.MkdirAll(filepath.Join(dir, uploadsDir), 0o700); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Server{
+		dir:       dir,
+		accessKey: accessKey,
+		uploads:   make(map[string]string),
+	}, nil
+}
+
+// Received returns the bytes of the objects uploaded to the relay.
+func (s *Server) Received() int64 {
+	return atomic.LoadInt64(&s.received)
+}
+
+// Sent returns the bytes of the objects downloaded from the relay.
+func (s *Server) Sent() int64 {
+	return atomic.LoadInt64(&s.sent)
+}
+
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+func writeError(w http.ResponseWriter, req *http.Request, status int, code string, message string) {
+	if status >= http.StatusInternalServerError {
+		log.Warn("relay request failed", zap.String("method", req.Method),
+			zap.String("path", req.URL.Path), zap.String("error", message))
+	}
+	writeXML(w, status, &errorResponse{Code: code, Message: message, Resource: req.URL.Path})
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+// accessKeyOf returns the access key of the signed request, in either the header or the query.
+func accessKeyOf(req *http.Request) string {
+	credential := req.URL.Query().Get("X-Amz-Credential")
+	if auth := req.Header.Get("Authorization"); len(auth) > 0 {
+		if i := strings.Index(auth, "Credential="); i >= 0 {
+			credential = auth[i+len("Credential="):]
+		} else if i := strings.Index(auth, "AWS "); i == 0 {
+			// the signature v2 is like "AWS {access key}:{signature}".
+			credential = strings.SplitN(auth[len("AWS "):], ":", 2)[0]
+		}
+	}
+	return strings.SplitN(credential, "/", 2)[0]
+}
+
+// parsePath splits the path style request path into the bucket and the key.
+func parsePath(path string) (bucket string, key string, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	bucket = parts[0]
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	if len(bucket) == 0 || strings.HasPrefix(bucket, ".") || key == "." || key == ".." {
+		return "", "", false
+	}
+	return bucket, key, true
+}
+
+// bucketDir returns the directory of the bucket, whose objects are kept flat with the keys escaped.
+func (s *Server) bucketDir(bucket string) string {
+	return filepath.Join(s.dir, url.QueryEscape(bucket))
+}
+
+func (s *Server) objectPath(bucket, key string) string {
+	return filepath.Join(s.bucketDir(bucket), url.QueryEscape(key))
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if len(s.accessKey) > 0 && accessKeyOf(req) != s.accessKey {
+		writeError(w, req, http.StatusForbidden, "InvalidAccessKeyId", "the access key is not of this relay")
+		return
+	}
+	bucket, key, ok := parsePath(req.URL.Path)
+	if !ok {
+		writeError(w, req, http.StatusBadRequest, "InvalidURI", "the request must be in the path style")
+		return
+	}
+	query := req.URL.Query()
+	switch {
+	case len(key) == 0 && (req.Method == http.MethodHead || req.Method == http.MethodPut):
+		// the buckets are created on the first upload.
+		w.WriteHeader(http.StatusOK)
+	case len(key) == 0 && req.Method == http.MethodGet:
+		s.listObjects(w, req, bucket)
+	case len(key) == 0:
+		writeError(w, req, http.StatusNotImplemented, "NotImplemented", "the bucket operation is not supported")
+	case req.Method == http.MethodPut && query.Has("uploadId"):
+		s.uploadPart(w, req, query.Get("uploadId"))
+	case req.Method == http.MethodPut:
+		s.putObject(w, req, bucket, key)
+	case req.Method == http.MethodPost && query.Has("uploads"):
+		s.createMultipartUpload(w, req, bucket, key)
+	case req.Method == http.MethodPost && query.Has("uploadId"):
+		s.completeMultipartUpload(w, req, bucket, key, query.Get("uploadId"))
+	case req.Method == http.MethodDelete && query.Has("uploadId"):
+		s.abortMultipartUpload(w, req, query.Get("uploadId"))
+	case req.Method == http.MethodDelete:
+		s.deleteObject(w, req, bucket, key)
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		s.getObject(w, req, bucket, key)
+	default:
+		writeError(w, req, http.StatusNotImplemented, "NotImplemented", "the object operation is not supported")
+	}
+}
+
+// requestBody returns the payload of the request, decoding the aws-chunked encoding.
+func requestBody(req *http.Request) io.Reader {
+	if req.Header.Get("X-Amz-Content-Sha256") == streamingPayload {
+		return &chunkedReader{r: bufio.NewReader(req.Body)}
+	}
+	return req.Body
+}
+
+// writeFile writes the body into the path atomically, and returns the hex md5 of it.
+// The bytes of the body are counted as received if count is set.
+func (s *Server) writeFile(path string, body io.Reader, count bool) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", errors.Trace(err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	tmpPath := f.Name()
+	hash := md5.New() // #nosec G401
+	n, err := io.Copy(io.MultiWriter(f, hash), body)
+	if count {
+		atomic.AddInt64(&s.received, n)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return "", errors.Trace(err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (s *Server) putObject(w http.ResponseWriter, req *http.Request, bucket, key string) {
+	etag, err := s.writeFile(s.objectPath(bucket, key), requestBody(req), true)
+	if err != nil {
+		writeError(w, req, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	w.Header().Set("ETag", strconv.Quote(etag))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) getObject(w http.ResponseWriter, req *http.Request, bucket, key string) {
+	f, err := os.Open(s.objectPath(bucket, key))
+	if os.IsNotExist(err) {
+		writeError(w, req, http.StatusNotFound, "NoSuchKey", "the key does not exist")
+		return
+	}
+	if err != nil {
+		writeError(w, req, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, req, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	// ServeContent handles the ranges and the HEAD requests.
+	http.ServeContent(&countingResponseWriter{ResponseWriter: w, n: &s.sent}, req, key, info.ModTime(), f)
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, req *http.Request, bucket, key string) {
+	if err := os.Remove(s.objectPath(bucket, key)); err != nil && !os.IsNotExist(err) {
+		writeError(w, req, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type listObject struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name     `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string       `xml:"Name"`
+	Prefix                string       `xml:"Prefix"`
+	Marker                string       `xml:"Marker,omitempty"`
+	NextMarker            string       `xml:"NextMarker,omitempty"`
+	StartAfter            string       `xml:"StartAfter,omitempty"`
+	ContinuationToken     string       `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string       `xml:"NextContinuationToken,omitempty"`
+	KeyCount              *int         `xml:"KeyCount,omitempty"`
+	MaxKeys               int          `xml:"MaxKeys"`
+	IsTruncated           bool         `xml:"IsTruncated"`
+	Contents              []listObject `xml:"Contents"`
+}
+
+// listObjects lists the objects of the bucket in the order of the keys, it serves both the
+// ListObjects and the ListObjectsV2 (list-type=2) requests.
+func (s *Server) listObjects(w http.ResponseWriter, req *http.Request, bucket string) {
+	query := req.URL.Query()
+	v2 := query.Get("list-type") == "2"
+	prefix := query.Get("prefix")
+	maxKeys := defaultMaxKeys
+	if v := query.Get("max-keys"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, req, http.StatusBadRequest, "InvalidArgument", "invalid max-keys "+v)
+			return
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	after := query.Get("marker")
+	if v2 {
+		after = query.Get("start-after")
+		if token := query.Get("continuation-token"); len(token) > 0 {
+			after = token
+		}
+	}
+
+	entries, err := os.ReadDir(s.bucketDir(bucket))
+	if err != nil && !os.IsNotExist(err) {
+		writeError(w, req, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+			continue
+		}
+		key, err := url.QueryUnescape(entry.Name())
+		if err != nil || !strings.HasPrefix(key, prefix) || key <= after {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := &listBucketResult{Name: bucket, Prefix: prefix, MaxKeys: maxKeys}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+	}
+	for _, key := range keys {
+		info, err := os.Stat(s.objectPath(bucket, key))
+		if err != nil {
+			// deleted after listed.
+			continue
+		}
+		result.Contents = append(result.Contents, listObject{
+			Key:          key,
+			LastModified: info.ModTime().UTC().Format(time.RFC3339),
+			ETag:         strconv.Quote(fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())),
+			Size:         info.Size(),
+			StorageClass: "STANDARD",
+		})
+	}
+	if v2 {
+		keyCount := len(result.Contents)
+		result.KeyCount = &keyCount
+		result.StartAfter = query.Get("start-after")
+		result.ContinuationToken = query.Get("continuation-token")
+		if result.IsTruncated {
+			result.NextContinuationToken = keys[len(keys)-1]
+		}
+	} else {
+		result.Marker = query.Get("marker")
+		if result.IsTruncated {
+			result.NextMarker = keys[len(keys)-1]
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+func (s *Server) uploadDir(uploadID string) string {
+	return filepath.Join(s.dir, uploadsDir, uploadID)
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, req *http.Request, bucket, key string) {
+	s.mu.Lock()
+	s.nextUploadID++
+	uploadID := strconv.FormatUint(s.nextUploadID, 10)
+	s.uploads[uploadID] = bucket + "/" + key
+	s.mu.Unlock()
+	if err := os.MkdirAll(s.uploadDir(uploadID), 0o700); err != nil {
+		writeError(w, req, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	writeXML(w, http.StatusOK, &initiateMultipartUploadResult{Bucket: bucket, Key: key, UploadID: uploadID})
+}
+
+// hasUpload returns whether the upload is in progress, for the object if bucket is not empty.
+func (s *Server) hasUpload(uploadID string, bucket, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.uploads[uploadID]
+	return ok && (len(bucket) == 0 || object == bucket+"/"+key)
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, req *http.Request, uploadID string) {
+	partNumber, err := strconv.Atoi(req.URL.Query().Get("partNumber"))
+	if err != nil || partNumber <= 0 {
+		writeError(w, req, http.StatusBadRequest, "InvalidArgument", "invalid partNumber")
+		return
+	}
+	if !s.hasUpload(uploadID, "", "") {
+		writeError(w, req, http.StatusNotFound, "NoSuchUpload", "the upload does not exist")
+		return
+	}
+	etag, err := s.writeFile(filepath.Join(s.uploadDir(uploadID), strconv.Itoa(partNumber)), requestBody(req), true)
+	if err != nil {
+		writeError(w, req, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	w.Header().Set("ETag", strconv.Quote(etag))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, req *http.Request, bucket, key, uploadID string) {
+	if !s.hasUpload(uploadID, bucket, key) {
+		writeError(w, req, http.StatusNotFound, "NoSuchUpload", "the upload does not exist")
+		return
+	}
+	var body completeMultipartUpload
+	if err := xml.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeError(w, req, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+	readers := make([]io.Reader, 0, len(body.Parts))
+	for _, part := range body.Parts {
+		f, err := os.Open(filepath.Join(s.uploadDir(uploadID), strconv.Itoa(part.PartNumber)))
+		if err != nil {
+			writeError(w, req, http.StatusBadRequest, "InvalidPart", err.Error())
+			return
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	// the parts are counted when uploaded.
+	etag, err := s.writeFile(s.objectPath(bucket, key), io.MultiReader(readers...), false)
+	if err != nil {
+		writeError(w, req, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	s.removeUpload(uploadID)
+	writeXML(w, http.StatusOK, &completeMultipartUploadResult{
+		Bucket: bucket, Key: key, ETag: strconv.Quote(fmt.Sprintf("%s-%d", etag, len(body.Parts))),
+	})
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, req *http.Request, uploadID string) {
+	if !s.hasUpload(uploadID, "", "") {
+		writeError(w, req, http.StatusNotFound, "NoSuchUpload", "the upload does not exist")
+		return
+	}
+	s.removeUpload(uploadID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) removeUpload(uploadID string) {
+	s.mu.Lock()
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+	if err := os.RemoveAll(s.uploadDir(uploadID)); err != nil {
+		log.Warn("failed to remove the parts of the upload", zap.String("upload", uploadID), zap.Error(err))
+	}
+}
+
+// countingResponseWriter counts the bytes written into n.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	atomic.AddInt64(w.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func newRelayStorage(t *testing.T, endpoint string, accessKey string) storage.ExternalStorage {
+	backend, err := storage.ParseBackend("s3://relay/copy", &storage.BackendOptions{S3: storage.S3BackendOptions{
+		Endpoint:        endpoint,
+		AccessKey:       accessKey,
+		SecretAccessKey: "secret",
+		ForcePathStyle:  true,
+	}})
+	require.NoError(t, err)
+	s, err := storage.New(context.Background(), backend, &storage.ExternalStorageOptions{SendCredentials: true})
+	require.NoError(t, err)
+	return s
+}
+
+func TestRelayServer(t *testing.T) {
+	ctx := context.Background()
+	relay, err := NewServer(t.TempDir(), "relay-key")
+	require.NoError(t, err)
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	s := newRelayStorage(t, server.URL, "relay-key")
+
+	require.NoError(t, s.WriteFile(ctx, "backupmeta", []byte("meta")))
+	data, err := s.ReadFile(ctx, "backupmeta")
+	require.NoError(t, err)
+	require.Equal(t, "meta", string(data))
+	exists, err := s.FileExists(ctx, "1.sst")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// the multipart upload is concatenated in the order of the parts.
+	sst := bytes.Repeat([]byte("0123456789"), 1<<20)
+	w, err := s.Create(ctx, "1.sst")
+	require.NoError(t, err)
+	_, err = w.Write(ctx, sst)
+	require.NoError(t, err)
+	require.NoError(t, w.Close(ctx))
+	r, err := s.Open(ctx, "1.sst")
+	require.NoError(t, err)
+	_, err = r.Seek(5, io.SeekStart)
+	require.NoError(t, err)
+	data, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, sst[5:], data)
+
+	files := make(map[string]int64)
+	require.NoError(t, s.WalkDir(ctx, &storage.WalkOption{ListCount: 1}, func(name string, size int64) error {
+		files[name] = size
+		return nil
+	}))
+	require.Equal(t, map[string]int64{"backupmeta": 4, "1.sst": int64(len(sst))}, files)
+	require.Equal(t, int64(len(sst)+4), relay.Received())
+	// the reader opened before seeking may have received some bytes.
+	require.GreaterOrEqual(t, relay.Sent(), int64(len(sst)-5+4))
+
+	require.NoError(t, s.DeleteFile(ctx, "1.sst"))
+	exists, err = s.FileExists(ctx, "1.sst")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// the requests of another access key are rejected.
+	other := newRelayStorage(t, server.URL, "other-key")
+	_, err = other.ReadFile(ctx, "backupmeta")
+	require.Error(t, err)
+	require.Regexp(t, "InvalidAccessKeyId", err.Error())
+}
+
+func TestRelayServerStreamingUpload(t *testing.T) {
+	relay, err := NewServer(t.TempDir(), "")
+	require.NoError(t, err)
+	body := "5;chunk-signature=aaa\r\nhello\r\n6;chunk-signature=bbb\r\n world\r\n0;chunk-signature=ccc\r\n\r\n"
+	req := httptest.NewRequest(http.MethodPut, "/relay/copy/1.sst", strings.NewReader(body))
+	req.Header.Set("X-Amz-Content-Sha256", streamingPayload)
+	resp := httptest.NewRecorder()
+	relay.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/relay/copy/1.sst", nil)
+	req.Header.Set("Range", "bytes=6-")
+	resp = httptest.NewRecorder()
+	relay.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusPartialContent, resp.Code)
+	require.Equal(t, "world", resp.Body.String())
+
+	// the truncated body is rejected.
+	req = httptest.NewRequest(http.MethodPut, "/relay/copy/2.sst", strings.NewReader("5;chunk-signature=aaa\r\nhel"))
+	req.Header.Set("X-Amz-Content-Sha256", streamingPayload)
+	resp = httptest.NewRecorder()
+	relay.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusInternalServerError, resp.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/relay/..", nil)
+	resp = httptest.NewRecorder()
+	relay.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+}
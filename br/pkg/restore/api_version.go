@@ -0,0 +1,36 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+// ConvertRawRanges converts the ranges of the raw keys of a backup in srcAPIVersion into the
+// key space of dstAPIVersion, e.g. the keys of an API V1 backup restored into an API V2 cluster
+// get the API V2 prefix of the default keyspace.
+func ConvertRawRanges(ranges []rtree.Range, srcAPIVersion, dstAPIVersion kvrpcpb.APIVersion) []rtree.Range {
+	if srcAPIVersion == dstAPIVersion {
+		return ranges
+	}
+	converted := make([]rtree.Range, 0, len(ranges))
+	for _, rg := range ranges {
+		keyRange := utils.ConvertBackupConfigKeyRange(rg.StartKey, rg.EndKey, srcAPIVersion, dstAPIVersion)
+		converted = append(converted, rtree.Range{StartKey: keyRange.Start, EndKey: keyRange.End})
+	}
+	return converted
+}
@@ -0,0 +1,37 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/rtree"
+)
+
+func TestConvertRawRanges(t *testing.T) {
+	ranges := []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("b")},
+		{StartKey: []byte("c"), EndKey: []byte{}},
+	}
+	require.Equal(t, ranges, ConvertRawRanges(ranges, kvrpcpb.APIVersion_V1, kvrpcpb.APIVersion_V1))
+
+	converted := ConvertRawRanges(ranges, kvrpcpb.APIVersion_V1TTL, kvrpcpb.APIVersion_V2)
+	require.Equal(t, []rtree.Range{
+		{StartKey: []byte("r\x00\x00\x00a"), EndKey: []byte("r\x00\x00\x00b")},
+		{StartKey: []byte("r\x00\x00\x00c"), EndKey: []byte("r\x00\x00\x01")},
+	}, converted)
+}
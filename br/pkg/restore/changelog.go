@@ -0,0 +1,204 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package restore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/log"
+	"github.com/tikv/client-go/v2/config"
+	"github.com/tikv/client-go/v2/rawkv"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/redact"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const defaultChangelogBatchSize = 1024
+
+// RawKVWriter is the subset of the rawkv client used by ChangelogReplayer.
+type RawKVWriter interface {
+	BatchPutWithTTL(ctx context.Context, keys, values [][]byte, ttls []uint64, options ...rawkv.RawOption) error
+	BatchDelete(ctx context.Context, keys [][]byte, options ...rawkv.RawOption) error
+	Close() error
+}
+
+// ChangelogStats is the statistics of a replay.
+type ChangelogStats struct {
+	Files   int
+	Events  int
+	Puts    int
+	Deletes int
+}
+
+// ChangelogReplayer replays the changelog upon a restored backup, which
+// restores an API V2 cluster to a point in time after the backup.
+type ChangelogReplayer struct {
+	client    RawKVWriter
+	batchSize int
+	// now returns the current unix time in seconds, which the TTLs are based on.
+	now func() uint64
+}
+
+// NewChangelogReplayer creates a ChangelogReplayer connecting to the API V2 cluster by a rawkv client.
+func NewChangelogReplayer(ctx context.Context, pdAddrs []string, tls utils.TLSConfig) (*ChangelogReplayer, error) {
+	security := config.Security{}
+	if tls.IsEnabled() {
+		security = config.NewSecurity(tls.CA, tls.Cert, tls.Key, []string{})
+	}
+	rawkvClient, err := rawkv.NewClientWithOpts(ctx, pdAddrs, rawkv.WithAPIVersion(kvrpcpb.APIVersion_V2),
+		rawkv.WithSecurity(security))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return NewChangelogReplayerWithClient(rawkvClient, defaultChangelogBatchSize), nil
+}
+
+// NewChangelogReplayerWithClient creates a ChangelogReplayer with the given client.
+func NewChangelogReplayerWithClient(client RawKVWriter, batchSize int) *ChangelogReplayer {
+	if batchSize <= 0 {
+		batchSize = defaultChangelogBatchSize
+	}
+	return &ChangelogReplayer{
+		client:    client,
+		batchSize: batchSize,
+		now:       func() uint64 { return uint64(time.Now().Unix()) },
+	}
+}
+
+// Close closes the client.
+func (r *ChangelogReplayer) Close() error {
+	return errors.Trace(r.client.Close())
+}
+
+// Replay applies the latest events of the keys in [startKey, endKey) whose commit ts are in
+// (startTS, restoredTS]. The keys are in the API V2 format, an empty endKey is unbounded.
+func (r *ChangelogReplayer) Replay(
+	ctx context.Context, s storage.ExternalStorage, startKey, endKey []byte, startTS, restoredTS uint64,
+) (ChangelogStats, error) {
+	stats := ChangelogStats{}
+	var names []string
+	err := s.WalkDir(ctx, &storage.WalkOption{SubDir: metautil.ChangelogDir}, func(name string, _ int64) error {
+		minTs, maxTs, ok := metautil.ParseChangelogFileName(path.Base(name))
+		if ok && maxTs > startTS && minTs <= restoredTS {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, errors.Trace(err)
+	}
+
+	// the files of the keyspans overlap in commit ts, only the latest event of a key takes effect.
+	latest := make(map[string]*metautil.ChangelogEvent)
+	for _, name := range names {
+		data, err := s.ReadFile(ctx, name)
+		if err != nil {
+			return stats, errors.Trace(err)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, len(data)+1)
+		for scanner.Scan() {
+			event := &metautil.ChangelogEvent{}
+			if err = json.Unmarshal(scanner.Bytes(), event); err != nil {
+				return stats, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse the changelog file %s: %v", name, err)
+			}
+			if event.CommitTS <= startTS || event.CommitTS > restoredTS ||
+				bytes.Compare(event.Key, startKey) < 0 || (len(endKey) > 0 && bytes.Compare(event.Key, endKey) >= 0) {
+				continue
+			}
+			stats.Events++
+			if prev, ok := latest[string(event.Key)]; !ok || prev.CommitTS <= event.CommitTS {
+				latest[string(event.Key)] = event
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return stats, errors.Trace(err)
+		}
+	}
+	stats.Files = len(names)
+
+	keys := make([]string, 0, len(latest))
+	for key := range latest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	now := r.now()
+	var putKeys, putValues, deleteKeys [][]byte
+	var putTTLs []uint64
+	for _, key := range keys {
+		event := latest[key]
+		if !bytes.HasPrefix(event.Key, utils.APIV2KeyPrefix[:]) {
+			return stats, errors.Annotatef(berrors.ErrInvalidArgument,
+				"key %s of the changelog is not in the default keyspace", redact.Key(event.Key))
+		}
+		// rawkv client accepts the user key without the prefix.
+		userKey := event.Key[utils.APIV2KeyPrefixLen:]
+		switch {
+		case event.OpType == metautil.ChangelogOpDelete, event.ExpiredTS > 0 && event.ExpiredTS <= now:
+			// the expired keys have the same effect as the deleted ones.
+			deleteKeys = append(deleteKeys, userKey)
+		case event.OpType == metautil.ChangelogOpPut:
+			var ttl uint64
+			if event.ExpiredTS > 0 {
+				ttl = event.ExpiredTS - now
+			}
+			putKeys = append(putKeys, userKey)
+			putValues = append(putValues, event.Value)
+			putTTLs = append(putTTLs, ttl)
+		default:
+			return stats, errors.Annotatef(berrors.ErrInvalidMetaFile, "unexpected op type %s of the changelog", event.OpType)
+		}
+		if len(putKeys) >= r.batchSize {
+			if err = r.client.BatchPutWithTTL(ctx, putKeys, putValues, putTTLs); err != nil {
+				return stats, errors.Trace(err)
+			}
+			stats.Puts += len(putKeys)
+			putKeys, putValues, putTTLs = nil, nil, nil
+		}
+		if len(deleteKeys) >= r.batchSize {
+			if err = r.client.BatchDelete(ctx, deleteKeys); err != nil {
+				return stats, errors.Trace(err)
+			}
+			stats.Deletes += len(deleteKeys)
+			deleteKeys = nil
+		}
+	}
+	if len(putKeys) > 0 {
+		if err = r.client.BatchPutWithTTL(ctx, putKeys, putValues, putTTLs); err != nil {
+			return stats, errors.Trace(err)
+		}
+		stats.Puts += len(putKeys)
+	}
+	if len(deleteKeys) > 0 {
+		if err = r.client.BatchDelete(ctx, deleteKeys); err != nil {
+			return stats, errors.Trace(err)
+		}
+		stats.Deletes += len(deleteKeys)
+	}
+	log.Info("replayed the changelog", zap.Int("files", stats.Files), zap.Int("events", stats.Events),
+		zap.Int("puts", stats.Puts), zap.Int("deletes", stats.Deletes),
+		zap.Uint64("start-ts", startTS), zap.Uint64("restored-ts", restoredTS))
+	return stats, nil
+}
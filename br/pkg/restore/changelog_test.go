@@ -0,0 +1,108 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/rawkv"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+type fakeRawKVWriter struct {
+	puts    map[string]string
+	ttls    map[string]uint64
+	deletes []string
+}
+
+func (w *fakeRawKVWriter) BatchPutWithTTL(_ context.Context, keys, values [][]byte, ttls []uint64, _ ...rawkv.RawOption) error {
+	for i, key := range keys {
+		w.puts[string(key)] = string(values[i])
+		w.ttls[string(key)] = ttls[i]
+	}
+	return nil
+}
+
+func (w *fakeRawKVWriter) BatchDelete(_ context.Context, keys [][]byte, _ ...rawkv.RawOption) error {
+	for _, key := range keys {
+		w.deletes = append(w.deletes, string(key))
+	}
+	return nil
+}
+
+func (w *fakeRawKVWriter) Close() error {
+	return nil
+}
+
+func writeChangelogFile(t *testing.T, s storage.ExternalStorage, name string, events ...metautil.ChangelogEvent) {
+	lines := make([]string, 0, len(events))
+	for _, event := range events {
+		event.Key = append(utils.APIV2KeyPrefix[:], event.Key...)
+		data, err := json.Marshal(&event)
+		require.NoError(t, err)
+		lines = append(lines, string(data))
+	}
+	require.NoError(t, s.WriteFile(context.Background(), metautil.ChangelogDir+"/"+name, []byte(strings.Join(lines, "\n")+"\n")))
+}
+
+func TestChangelogReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, metautil.ChangelogDir), 0o755))
+
+	name := func(minTs, maxTs uint64, seq int) string {
+		return fmt.Sprintf("%016x-%016x-sink-%d.log", minTs, maxTs, seq)
+	}
+	// before the backup.
+	writeChangelogFile(t, s, name(91, 100, 1),
+		metautil.ChangelogEvent{OpType: metautil.ChangelogOpPut, Key: []byte("a"), Value: []byte("old"), CommitTS: 91})
+	writeChangelogFile(t, s, name(99, 120, 2),
+		metautil.ChangelogEvent{OpType: metautil.ChangelogOpPut, Key: []byte("a"), Value: []byte("stale"), CommitTS: 99},
+		metautil.ChangelogEvent{OpType: metautil.ChangelogOpPut, Key: []byte("a"), Value: []byte("v1"), CommitTS: 110},
+		metautil.ChangelogEvent{OpType: metautil.ChangelogOpPut, Key: []byte("b"), Value: []byte("v1"), CommitTS: 111},
+		metautil.ChangelogEvent{OpType: metautil.ChangelogOpPut, Key: []byte("z"), Value: []byte("out of range"), CommitTS: 112},
+		metautil.ChangelogEvent{OpType: metautil.ChangelogOpPut, Key: []byte("t"), Value: []byte("ttl"), CommitTS: 113, ExpiredTS: 1100},
+		metautil.ChangelogEvent{OpType: metautil.ChangelogOpPut, Key: []byte("e"), Value: []byte("expired"), CommitTS: 114, ExpiredTS: 900},
+	)
+	writeChangelogFile(t, s, name(115, 150, 1),
+		metautil.ChangelogEvent{OpType: metautil.ChangelogOpDelete, Key: []byte("b"), CommitTS: 115},
+		metautil.ChangelogEvent{OpType: metautil.ChangelogOpPut, Key: []byte("a"), Value: []byte("v2"), CommitTS: 150},
+	)
+	// after the restored ts.
+	writeChangelogFile(t, s, name(201, 210, 3),
+		metautil.ChangelogEvent{OpType: metautil.ChangelogOpPut, Key: []byte("c"), Value: []byte("v1"), CommitTS: 201})
+	require.NoError(t, s.WriteFile(ctx, metautil.ChangelogDir+"/unknown.txt", []byte("garbage")))
+
+	writer := &fakeRawKVWriter{puts: make(map[string]string), ttls: make(map[string]uint64)}
+	replayer := NewChangelogReplayerWithClient(writer, 2)
+	replayer.now = func() uint64 { return 1000 }
+	keyRange := utils.FormatAPIV2KeyRange([]byte("a"), []byte("u"))
+	stats, err := replayer.Replay(ctx, s, keyRange.Start, keyRange.End, 100, 200)
+	require.NoError(t, err)
+	require.Equal(t, ChangelogStats{Files: 2, Events: 6, Puts: 2, Deletes: 2}, stats)
+	require.Equal(t, map[string]string{"a": "v2", "t": "ttl"}, writer.puts)
+	require.Equal(t, map[string]uint64{"a": 0, "t": 100}, writer.ttls)
+	require.ElementsMatch(t, []string{"b", "e"}, writer.deletes)
+}
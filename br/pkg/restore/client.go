@@ -6,13 +6,16 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
-	"encoding/hex"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/gogo/protobuf/proto"
 	"github.com/pingcap/errors"
 	backuppb "github.com/pingcap/kvproto/pkg/brpb"
 	"github.com/pingcap/kvproto/pkg/import_sstpb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/log"
 	"github.com/tikv/client-go/v2/oracle"
 	"github.com/tikv/client-go/v2/util/codec"
@@ -22,6 +25,7 @@ import (
 	"github.com/tikv/migration/br/pkg/logutil"
 	"github.com/tikv/migration/br/pkg/metautil"
 	"github.com/tikv/migration/br/pkg/redact"
+	"github.com/tikv/migration/br/pkg/rtree"
 	"github.com/tikv/migration/br/pkg/storage"
 	"github.com/tikv/migration/br/pkg/summary"
 	"github.com/tikv/migration/br/pkg/utils"
@@ -46,8 +50,12 @@ type Client struct {
 	keepaliveConf keepalive.ClientParameters
 
 	backupMeta    *backuppb.BackupMeta
+	metaReader    *metautil.MetaReader
 	dstAPIVersion kvrpcpb.APIVersion
 
+	// speedLimitMu guards rateLimit and hasSpeedLimited, which are updated at runtime by
+	// UpdateRateLimit while the restore sets and resets the speed limit.
+	speedLimitMu    sync.Mutex
 	rateLimit       uint64
 	isOnline        bool
 	hasSpeedLimited bool // nolint:unused
@@ -57,6 +65,23 @@ type Client struct {
 	backend            *backuppb.StorageBackend
 	switchModeInterval time.Duration
 	switchCh           chan struct{}
+	grpcMaxMsgSize     int
+
+	// importModeRanges limits import mode to the stores holding the ranges, all stores if it's empty.
+	importModeRanges []rtree.Range
+	// importModeStores are the stores ever switched to import mode, which are switched back
+	// to normal mode at the end. All stores are switched back if it's nil.
+	importModeMu     sync.Mutex
+	importModeStores map[uint64]struct{}
+
+	// rawKeyRewrites rewrite the disjoint prefixes of the raw keys restored, empty means no rewrite.
+	rawKeyRewrites []*RawKeyRewrite
+	// throttle is shared by the importers of all the backups restored.
+	throttle *IngestThrottle
+
+	// scatterWaitTimeout and scatterExisting configure the RegionSplitter of SplitRanges.
+	scatterWaitTimeout time.Duration
+	scatterExisting    bool
 }
 
 // NewRestoreClient returns a new RestoreClient.
@@ -77,14 +102,70 @@ func NewRestoreClient(
 		keepaliveConf: keepaliveConf,
 		switchCh:      make(chan struct{}),
 		dstAPIVersion: apiVerion,
+		throttle:      NewIngestThrottle(0),
+
+		scatterWaitTimeout: ScatterWaitUpperInterval,
 	}, nil
 }
 
+// SetScatterWaitTimeout sets how long SplitRanges waits for the split regions to be scattered,
+// 0 means not waiting.
+func (rc *Client) SetScatterWaitTimeout(timeout time.Duration) {
+	rc.scatterWaitTimeout = timeout
+}
+
+// EnableScatterExisting makes SplitRanges scatter the regions covering the ranges which need
+// no split as well.
+func (rc *Client) EnableScatterExisting() {
+	rc.scatterExisting = true
+}
+
+// SetRawKeyRewrites rewrites the prefixes of the raw keys restored by RestoreRawBackups, which
+// should have been checked by CheckRawKeyRewrites. Only the keys in the old prefixes are restored.
+func (rc *Client) SetRawKeyRewrites(rewrites []*RawKeyRewrite) {
+	rc.rawKeyRewrites = rewrites
+}
+
 // SetRateLimit to set rateLimit.
 func (rc *Client) SetRateLimit(rateLimit uint64) {
+	rc.speedLimitMu.Lock()
+	defer rc.speedLimitMu.Unlock()
 	rc.rateLimit = rateLimit
 }
 
+// UpdateRateLimit updates rateLimit, and applies it to TiKV immediately if the restore is in progress.
+// It's skipped once the speed limit is reset at the end of the restore.
+func (rc *Client) UpdateRateLimit(ctx context.Context, rateLimit uint64) error {
+	rc.speedLimitMu.Lock()
+	defer rc.speedLimitMu.Unlock()
+	rc.rateLimit = rateLimit
+	if !rc.hasSpeedLimited {
+		return nil
+	}
+	return rc.setSpeedLimit(ctx, rateLimit)
+}
+
+// SetIngestRateLimit limits the bytes of the files sent to each store per second, 0 means
+// unlimited. It takes effect immediately if the restore is in progress.
+func (rc *Client) SetIngestRateLimit(rateLimit uint64) {
+	rc.throttle.SetRateLimit(rateLimit)
+}
+
+// Pause pauses the restore, the files being restored are finished and the others wait for Resume.
+func (rc *Client) Pause() {
+	rc.throttle.Pause()
+}
+
+// Resume resumes the restore paused by Pause.
+func (rc *Client) Resume() {
+	rc.throttle.Resume()
+}
+
+// IsPaused returns whether the restore is paused.
+func (rc *Client) IsPaused() bool {
+	return rc.throttle.IsPaused()
+}
+
 func (rc *Client) SetCrypter(crypter *backuppb.CipherInfo) {
 	rc.cipher = crypter
 }
@@ -110,6 +191,12 @@ func (rc *Client) IsOnline() bool {
 	return rc.isOnline
 }
 
+// SetGRPCMaxMsgSize sets the max size of the gRPC messages to the importers of TiKV,
+// it must be called before InitBackupMeta.
+func (rc *Client) SetGRPCMaxMsgSize(size int) {
+	rc.grpcMaxMsgSize = size
+}
+
 // SetSwitchModeInterval set switch mode interval for client.
 func (rc *Client) SetSwitchModeInterval(interval time.Duration) {
 	rc.switchModeInterval = interval
@@ -131,11 +218,13 @@ func (rc *Client) InitBackupMeta(
 		return errors.Errorf("backup meta for non-rawkv is unsupported")
 	}
 	rc.backupMeta = backupMeta
+	rc.metaReader = reader
 
 	metaClient := NewSplitClient(rc.pdClient, rc.tlsConf, rc.backupMeta.IsRawKv)
-	importCli := NewImportClient(metaClient, rc.tlsConf, rc.keepaliveConf)
+	importCli := NewImportClient(metaClient, rc.tlsConf, rc.keepaliveConf, rc.grpcMaxMsgSize)
 	rc.fileImporter = NewFileImporter(metaClient, importCli, backend, rc.backupMeta.IsRawKv,
 		rc.backupMeta.ApiVersion)
+	rc.fileImporter.throttle = rc.throttle
 	return rc.fileImporter.CheckMultiIngestSupport(c, rc.pdClient)
 }
 
@@ -145,12 +234,22 @@ func (rc *Client) IsRawKvMode() bool {
 }
 
 // GetFilesInRawRange gets all files that are in the given range or intersects with the given range.
-func (rc *Client) GetFilesInRawRange(startKey []byte, endKey []byte, cf string) ([]*backuppb.File, error) {
+func (rc *Client) GetFilesInRawRange(ctx context.Context, startKey []byte, endKey []byte, cf string) ([]*backuppb.File, error) {
 	if !rc.IsRawKvMode() {
 		return nil, errors.Annotate(berrors.ErrRestoreModeMismatch, "the backup data is not in raw kv mode")
 	}
+	return filesInRawRange(ctx, rc.backupMeta, rc.metaReader, startKey, endKey, cf)
+}
 
-	for _, rawRange := range rc.backupMeta.RawRanges {
+// filesInRawRange reads the files of the backup by the reader, only the files in the range
+// are kept in memory.
+func filesInRawRange(
+	ctx context.Context,
+	backupMeta *backuppb.BackupMeta,
+	reader *metautil.MetaReader,
+	startKey []byte, endKey []byte, cf string,
+) ([]*backuppb.File, error) {
+	for _, rawRange := range backupMeta.RawRanges {
 		// First check whether the given range is backup-ed. If not, we cannot perform the restore.
 		if rawRange.Cf != cf {
 			continue
@@ -175,22 +274,26 @@ func (rc *Client) GetFilesInRawRange(startKey []byte, endKey []byte, cf string)
 		// We have found the range that contains the given range. Find all necessary files.
 		files := make([]*backuppb.File, 0)
 
-		for _, file := range rc.backupMeta.Files {
+		err := reader.ReadDataFiles(ctx, func(file *backuppb.File) error {
 			if file.Cf != cf {
-				continue
+				return nil
 			}
 
 			if len(file.EndKey) > 0 && bytes.Compare(file.EndKey, startKey) < 0 {
 				// The file is before the range to be restored.
-				continue
+				return nil
 			}
 			if len(endKey) > 0 && bytes.Compare(endKey, file.StartKey) <= 0 {
 				// The file is after the range to be restored.
 				// The specified endKey is exclusive, so when it equals to a file's startKey, the file is still skipped.
-				continue
+				return nil
 			}
 
 			files = append(files, file)
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Trace(err)
 		}
 
 		// There should be at most one backed up range that covers the restoring range.
@@ -225,6 +328,7 @@ func (rc *Client) GetTS(ctx context.Context) (uint64, error) {
 	return restoreTS, nil
 }
 
+// setSpeedLimit sets the download speed limit of the stores, speedLimitMu must be held.
 func (rc *Client) setSpeedLimit(ctx context.Context, rateLimit uint64) error {
 	stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient, conn.SkipTiFlash)
 	if err != nil {
@@ -241,6 +345,8 @@ func (rc *Client) setSpeedLimit(ctx context.Context, rateLimit uint64) error {
 }
 
 func (rc *Client) resetSpeedLimit(ctx context.Context) {
+	rc.speedLimitMu.Lock()
+	defer rc.speedLimitMu.Unlock()
 	if rc.hasSpeedLimited {
 		var resetErr error
 		for retry := 0; retry < resetSpeedLimitRetryTimes; retry++ {
@@ -263,6 +369,14 @@ func (rc *Client) resetSpeedLimit(ctx context.Context) {
 // RestoreRaw tries to restore raw keys in the specified range.
 func (rc *Client) RestoreRaw(
 	ctx context.Context, startKey []byte, endKey []byte, files []*backuppb.File, updateCh glue.Progress,
+) error {
+	return rc.RestoreRawBackups(ctx, startKey, endKey, []*RawBackup{rc.NewMainRawBackup(files)}, updateCh)
+}
+
+// RestoreRawBackups restores the raw keys in the specified range from the disjoint backups in one run.
+// The files of all backups share the worker pool, the rate limit and the progress.
+func (rc *Client) RestoreRawBackups(
+	ctx context.Context, startKey []byte, endKey []byte, backups []*RawBackup, updateCh glue.Progress,
 ) error {
 	start := time.Now()
 	defer func() {
@@ -270,47 +384,123 @@ func (rc *Client) RestoreRaw(
 		log.Info("Restore Raw",
 			logutil.Key("startKey", startKey),
 			logutil.Key("endKey", endKey),
+			zap.Int("backups", len(backups)),
 			zap.Duration("take", elapsed))
 	}()
-	errCh := make(chan error, len(files))
 	eg, ectx := errgroup.WithContext(ctx)
-	defer close(errCh)
+	// files are the files of each backup to import, whose ranges are in the key space of the cluster.
+	files := make([][]*backuppb.File, len(backups))
+	for i, backup := range backups {
+		files[i] = backup.Files
+	}
+	// fileRewrites are the rewrites of the files, and the parts of a file rewritten by the other
+	// rewrites than the first one are extraParts, which don't count in the progress.
+	fileRewrites := make(map[*backuppb.File]*RawKeyRewrite)
+	extraParts := make(map[*backuppb.File]struct{})
+	if rewrites := rc.rawKeyRewrites; len(rewrites) > 0 {
+		// the keys are rewritten by TiKV on download, the ranges are in the new prefixes.
+		newRanges := RewriteRawRanges(rewrites, []rtree.Range{{StartKey: startKey, EndKey: endKey}})
+		if len(newRanges) == 0 {
+			return errors.Annotatef(berrors.ErrRestoreInvalidRewrite,
+				"the range to restore is out of the prefixes %s to rewrite", redactOldPrefixes(rewrites))
+		}
+		// the importers restore the span of the new ranges, and each part of the files is clipped
+		// into the new range of its rewrite on download.
+		startKey, endKey = newRanges[0].StartKey, newRanges[len(newRanges)-1].EndKey
+		for i := range backups {
+			parts := make([]*backuppb.File, 0, len(files[i]))
+			for _, file := range files[i] {
+				fileRanges := make([]rtree.Range, 0, 1)
+				partRewrites := make([]*RawKeyRewrite, 0, 1)
+				for _, rewrite := range rewrites {
+					if fileRange, ok := rewrite.RewriteRange(rtree.Range{StartKey: file.StartKey, EndKey: file.EndKey}); ok {
+						fileRanges = append(fileRanges, fileRange)
+						partRewrites = append(partRewrites, rewrite)
+					}
+				}
+				if len(fileRanges) == 0 {
+					// the file is between the old prefixes, none of its keys are restored.
+					log.Info("skip the file out of the prefixes to rewrite", logutil.File(file))
+					updateCh.Inc()
+					continue
+				}
+				for j, fileRange := range fileRanges {
+					part := file
+					if j > 0 {
+						part = proto.Clone(file).(*backuppb.File)
+						extraParts[part] = struct{}{}
+					}
+					part.StartKey, part.EndKey = fileRange.StartKey, fileRange.EndKey
+					fileRewrites[part] = partRewrites[j]
+					parts = append(parts, part)
+				}
+			}
+			files[i] = parts
+		}
+	}
+	if srcAPIVersion := rc.backupMeta.ApiVersion; srcAPIVersion != rc.dstAPIVersion {
+		// the keys and values are converted by TiKV on download by the api version of the SSTs,
+		// e.g. the values of an API V1 backup get the API V2 meta without TTL, the ones of an
+		// API V1TTL backup keep their TTL. The ranges are in the key space of the cluster.
+		keyRange := utils.ConvertBackupConfigKeyRange(startKey, endKey, srcAPIVersion, rc.dstAPIVersion)
+		startKey, endKey = keyRange.Start, keyRange.End
+		for i := range backups {
+			for _, file := range files[i] {
+				keyRange = utils.ConvertBackupConfigKeyRange(file.StartKey, file.EndKey, srcAPIVersion, rc.dstAPIVersion)
+				file.StartKey, file.EndKey = keyRange.Start, keyRange.End
+			}
+		}
+	}
 	if rc.dstAPIVersion == kvrpcpb.APIVersion_V2 {
 		startKey = codec.EncodeBytes(nil, startKey)
 		endKey = codec.EncodeBytes(nil, endKey)
-		for _, file := range files {
-			file.StartKey = codec.EncodeBytes(nil, file.StartKey)
-			file.EndKey = codec.EncodeBytes(nil, file.EndKey)
+		for i := range backups {
+			for _, file := range files[i] {
+				file.StartKey = codec.EncodeBytes(nil, file.StartKey)
+				file.EndKey = codec.EncodeBytes(nil, file.EndKey)
+			}
 		}
 	}
 
-	err := rc.fileImporter.SetRawRange(startKey, endKey)
-	if err != nil {
-		return errors.Trace(err)
+	for _, backup := range backups {
+		if err := backup.importer.SetRawRange(startKey, endKey); err != nil {
+			return errors.Trace(err)
+		}
 	}
-	err = rc.setSpeedLimit(ctx, rc.rateLimit)
+	rc.speedLimitMu.Lock()
+	err := rc.setSpeedLimit(ctx, rc.rateLimit)
+	rc.speedLimitMu.Unlock()
 	if err != nil {
 		return errors.Trace(err)
 	}
 	// TODO: Need a mechanism to set speed limit in ttl.
 	defer rc.resetSpeedLimit(ctx)
 
-	for _, file := range files {
-		fileReplica := file
-		rc.workerPool.ApplyOnErrorGroup(eg,
-			func() error {
-				defer updateCh.Inc()
-				startTime := time.Now()
-				err := rc.fileImporter.Import(ectx, []*backuppb.File{fileReplica}, EmptyRewriteRule(), rc.cipher)
-				if err != nil {
-					key := "range start:" + hex.EncodeToString(fileReplica.StartKey) +
-						" end:" + hex.EncodeToString(fileReplica.EndKey)
-					summary.CollectFailureUnit(key, err)
-				} else {
-					summary.CollectSuccessUnit("Restore file", 1, time.Since(startTime))
-				}
-				return err
-			})
+	for i, backup := range backups {
+		importer := backup.importer
+		cipher := rc.cipher
+		if backup.cipher != nil {
+			cipher = backup.cipher
+		}
+		for _, file := range files[i] {
+			fileReplica := file
+			rules := fileRewrites[file].rewriteRules()
+			_, extra := extraParts[file]
+			rc.workerPool.ApplyOnErrorGroup(eg,
+				func() error {
+					if !extra {
+						defer updateCh.Inc()
+					}
+					startTime := time.Now()
+					err := importer.Import(ectx, []*backuppb.File{fileReplica}, rules, cipher)
+					if err != nil {
+						summary.CollectFailureRange(fileReplica.StartKey, fileReplica.EndKey, err)
+					} else {
+						summary.CollectSuccessUnit("Restore file", 1, time.Since(startTime))
+					}
+					return err
+				})
+		}
 	}
 	if err := eg.Wait(); err != nil {
 		log.Error(
@@ -329,6 +519,68 @@ func (rc *Client) RestoreRaw(
 	return nil
 }
 
+// SetImportModeRanges limits import mode to the stores holding the peers of the ranges,
+// leaving the other stores in normal mode. The ranges should have been split and scattered.
+func (rc *Client) SetImportModeRanges(ranges []rtree.Range) {
+	rc.importModeRanges = ranges
+}
+
+// importModeTargets returns the stores to switch to import mode.
+func (rc *Client) importModeTargets(ctx context.Context) ([]*metapb.Store, error) {
+	stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient, conn.SkipTiFlash)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(rc.importModeRanges) == 0 {
+		return stores, nil
+	}
+	affected := make(map[uint64]struct{})
+	for _, rg := range rc.importModeRanges {
+		startKey, endKey := rg.StartKey, rg.EndKey
+		if rc.dstAPIVersion == kvrpcpb.APIVersion_V2 {
+			startKey = codec.EncodeBytes(nil, startKey)
+			if len(endKey) > 0 {
+				endKey = codec.EncodeBytes(nil, endKey)
+			}
+		}
+		regions, err := PaginateScanRegion(ctx, rc.toolClient, startKey, endKey, ScanRegionPaginationLimit)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, region := range regions {
+			for _, peer := range region.Region.GetPeers() {
+				affected[peer.GetStoreId()] = struct{}{}
+			}
+		}
+	}
+	targets := make([]*metapb.Store, 0, len(affected))
+	for _, store := range stores {
+		if _, ok := affected[store.GetId()]; ok {
+			targets = append(targets, store)
+		}
+	}
+	log.Info("stores affected by restore", zap.Int("affected", len(targets)), zap.Int("total", len(stores)))
+	return targets, nil
+}
+
+// switchToImportMode switches the affected stores to import mode, and records them
+// to switch back at the end.
+func (rc *Client) switchToImportMode(ctx context.Context) error {
+	stores, err := rc.importModeTargets(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	rc.importModeMu.Lock()
+	if rc.importModeStores == nil {
+		rc.importModeStores = make(map[uint64]struct{})
+	}
+	for _, store := range stores {
+		rc.importModeStores[store.GetId()] = struct{}{}
+	}
+	rc.importModeMu.Unlock()
+	return rc.switchTiKVMode(ctx, import_sstpb.SwitchMode_Import, stores)
+}
+
 // SwitchToImportMode switch tikv cluster to import mode.
 func (rc *Client) SwitchToImportMode(ctx context.Context) {
 	// tikv automatically switch to normal mode in every 10 minutes
@@ -339,7 +591,7 @@ func (rc *Client) SwitchToImportMode(ctx context.Context) {
 
 		// [important!] switch tikv mode into import at the beginning
 		log.Info("switch to import mode at beginning")
-		err := rc.switchTiKVMode(ctx, import_sstpb.SwitchMode_Import)
+		err := rc.switchToImportMode(ctx)
 		if err != nil {
 			log.Warn("switch to import mode failed", zap.Error(err))
 		}
@@ -349,8 +601,9 @@ func (rc *Client) SwitchToImportMode(ctx context.Context) {
 			case <-ctx.Done():
 				return
 			case <-tick.C:
+				// the affected stores are refreshed, in case the regions are moved.
 				log.Info("switch to import mode")
-				err := rc.switchTiKVMode(ctx, import_sstpb.SwitchMode_Import)
+				err := rc.switchToImportMode(ctx)
 				if err != nil {
 					log.Warn("switch to import mode failed", zap.Error(err))
 				}
@@ -365,14 +618,25 @@ func (rc *Client) SwitchToImportMode(ctx context.Context) {
 // SwitchToNormalMode switch tikv cluster to normal mode.
 func (rc *Client) SwitchToNormalMode(ctx context.Context) error {
 	close(rc.switchCh)
-	return rc.switchTiKVMode(ctx, import_sstpb.SwitchMode_Normal)
-}
-
-func (rc *Client) switchTiKVMode(ctx context.Context, mode import_sstpb.SwitchMode) error {
 	stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient, conn.SkipTiFlash)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	rc.importModeMu.Lock()
+	if rc.importModeStores != nil {
+		switched := make([]*metapb.Store, 0, len(rc.importModeStores))
+		for _, store := range stores {
+			if _, ok := rc.importModeStores[store.GetId()]; ok {
+				switched = append(switched, store)
+			}
+		}
+		stores = switched
+	}
+	rc.importModeMu.Unlock()
+	return rc.switchTiKVMode(ctx, import_sstpb.SwitchMode_Normal, stores)
+}
+
+func (rc *Client) switchTiKVMode(ctx context.Context, mode import_sstpb.SwitchMode, stores []*metapb.Store) error {
 	bfConf := backoff.DefaultConfig
 	bfConf.MaxDelay = time.Second * 3
 	for _, store := range stores {
@@ -414,3 +678,12 @@ func (rc *Client) switchTiKVMode(ctx context.Context, mode import_sstpb.SwitchMo
 func (rc *Client) GetAPIVersion() kvrpcpb.APIVersion {
 	return rc.dstAPIVersion
 }
+
+// redactOldPrefixes returns the redacted old prefixes of the rewrites.
+func redactOldPrefixes(rewrites []*RawKeyRewrite) string {
+	prefixes := make([]string, 0, len(rewrites))
+	for _, r := range rewrites {
+		prefixes = append(prefixes, redact.Key(r.OldPrefix))
+	}
+	return strings.Join(prefixes, ", ")
+}
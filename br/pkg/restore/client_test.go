@@ -14,6 +14,7 @@ import (
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/rtree"
 	pd "github.com/tikv/pd/client"
 	"google.golang.org/grpc/keepalive"
 )
@@ -138,3 +139,70 @@ func TestSetSpeedLimit(t *testing.T) {
 		require.Equal(t, uint64(0), recordStores.get(mockStores[i].Id))
 	}
 }
+
+func TestUpdateRateLimit(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", `=~^/config`,
+		httpmock.NewStringResponder(200, `{"storage":{"api-version":2, "enable-ttl":true}}`))
+	client, err := NewRestoreClient(fakePDClient{
+		stores: []*metapb.Store{{Id: 1}, {Id: 2}},
+	}, nil, defaultKeepaliveCfg, true)
+	require.NoError(t, err)
+	client.fileImporter = NewFileImporter(nil, FakeImporterClient{}, nil, true, kvrpcpb.APIVersion_V2)
+	ctx := context.Background()
+
+	// the update before the restore starts is only recorded.
+	recordStores = NewRecordStores()
+	require.NoError(t, client.UpdateRateLimit(ctx, 10))
+	require.Equal(t, 0, recordStores.len())
+
+	client.speedLimitMu.Lock()
+	require.NoError(t, client.setSpeedLimit(ctx, client.rateLimit))
+	client.speedLimitMu.Unlock()
+	require.NoError(t, client.UpdateRateLimit(ctx, 20))
+	require.Equal(t, uint64(20), recordStores.get(1))
+
+	// the update after the reset doesn't limit the stores again.
+	client.resetSpeedLimit(ctx)
+	require.NoError(t, client.UpdateRateLimit(ctx, 30))
+	require.Equal(t, uint64(0), recordStores.get(1))
+	require.Equal(t, uint64(0), recordStores.get(2))
+}
+
+func TestImportModeTargets(t *testing.T) {
+	mockStores := []*metapb.Store{{Id: 1}, {Id: 2}, {Id: 3}, {Id: 4}}
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", `=~^/config`,
+		httpmock.NewStringResponder(200, `{"storage":{"api-version":1}}`))
+	client, err := NewRestoreClient(fakePDClient{stores: mockStores}, nil, defaultKeepaliveCfg, true)
+	require.NoError(t, err)
+
+	regionOf := func(id uint64, start, end string, stores ...uint64) *RegionInfo {
+		region := &metapb.Region{Id: id, StartKey: []byte(start), EndKey: []byte(end)}
+		for _, storeID := range stores {
+			region.Peers = append(region.Peers, &metapb.Peer{Id: id*10 + storeID, StoreId: storeID})
+		}
+		return &RegionInfo{Region: region, Leader: region.Peers[0]}
+	}
+	client.toolClient = NewTestClient(nil, map[uint64]*RegionInfo{
+		1: regionOf(1, "", "b", 1, 2),
+		2: regionOf(2, "b", "d", 2, 3),
+		3: regionOf(3, "d", "", 4),
+	}, 4)
+
+	ctx := context.Background()
+	targets, err := client.importModeTargets(ctx)
+	require.NoError(t, err)
+	require.Len(t, targets, 4)
+
+	client.SetImportModeRanges([]rtree.Range{{StartKey: []byte("a"), EndKey: []byte("c")}})
+	targets, err = client.importModeTargets(ctx)
+	require.NoError(t, err)
+	ids := make([]uint64, 0, len(targets))
+	for _, store := range targets {
+		ids = append(ids, store.GetId())
+	}
+	require.Equal(t, []uint64{1, 2, 3}, ids)
+}
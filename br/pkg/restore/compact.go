@@ -0,0 +1,156 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/debugpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/log"
+	"github.com/tikv/client-go/v2/util/codec"
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// the prefix of the data keys in the KV DB of TiKV, and the upper bound of them.
+var (
+	dataKeyPrefix = []byte{'z'}
+	dataMaxKey    = []byte{'z' + 1}
+)
+
+// DebugClientGetter gets the debug client of a store.
+type DebugClientGetter interface {
+	GetDebugClient(ctx context.Context, storeID uint64) (debugpb.DebugClient, error)
+}
+
+// CompactTask is a range of the KV DB of a store to compact. The keys are the data keys of the
+// KV DB, i.e. the keys of the cluster with the data prefix.
+type CompactTask struct {
+	StoreID  uint64
+	StartKey []byte
+	EndKey   []byte
+}
+
+// CompactTasks returns the ranges to compact on each store holding a peer of the restored ranges.
+// The SSTs ingested by the restore stay in the lower levels of the LSM tree until they are
+// compacted, so the reads right after the restore may be slow.
+func (rc *Client) CompactTasks(ctx context.Context, ranges []rtree.Range) ([]CompactTask, error) {
+	var tasks []CompactTask
+	for _, rg := range mergeAdjacentRanges(ranges) {
+		startKey, endKey := rg.StartKey, rg.EndKey
+		if rc.dstAPIVersion == kvrpcpb.APIVersion_V2 {
+			startKey = codec.EncodeBytes(nil, startKey)
+			if len(endKey) > 0 {
+				endKey = codec.EncodeBytes(nil, endKey)
+			}
+		}
+		regions, err := PaginateScanRegion(ctx, rc.toolClient, startKey, endKey, ScanRegionPaginationLimit)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		stores := make(map[uint64]struct{})
+		for _, region := range regions {
+			for _, peer := range region.Region.GetPeers() {
+				stores[peer.GetStoreId()] = struct{}{}
+			}
+		}
+		dataStart := append(append([]byte{}, dataKeyPrefix...), startKey...)
+		dataEnd := dataMaxKey
+		if len(endKey) > 0 {
+			dataEnd = append(append([]byte{}, dataKeyPrefix...), endKey...)
+		}
+		for storeID := range stores {
+			tasks = append(tasks, CompactTask{StoreID: storeID, StartKey: dataStart, EndKey: dataEnd})
+		}
+	}
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].StoreID < tasks[j].StoreID })
+	return tasks, nil
+}
+
+// mergeAdjacentRanges merges the ranges adjacent to or overlapping each other, so that the
+// ranges of the backup files, which usually cover a continuous key space, are compacted at once.
+func mergeAdjacentRanges(ranges []rtree.Range) []rtree.Range {
+	sorted := append([]rtree.Range{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].StartKey, sorted[j].StartKey) < 0 })
+	merged := make([]rtree.Range, 0, len(sorted))
+	for _, rg := range sorted {
+		if len(merged) == 0 {
+			merged = append(merged, rtree.Range{StartKey: rg.StartKey, EndKey: rg.EndKey})
+			continue
+		}
+		last := &merged[len(merged)-1]
+		if len(last.EndKey) == 0 {
+			continue
+		}
+		if bytes.Compare(rg.StartKey, last.EndKey) > 0 {
+			merged = append(merged, rtree.Range{StartKey: rg.StartKey, EndKey: rg.EndKey})
+			continue
+		}
+		if len(rg.EndKey) == 0 || bytes.Compare(rg.EndKey, last.EndKey) > 0 {
+			last.EndKey = rg.EndKey
+		}
+	}
+	return merged
+}
+
+// Compact compacts the ranges of the default CF of the stores, and returns once all of them are
+// compacted. The stores compact in parallel, each of them compacts its ranges one by one by
+// threads threads.
+func Compact(
+	ctx context.Context, getter DebugClientGetter, tasks []CompactTask, threads uint32, updateCh glue.Progress,
+) error {
+	byStore := make(map[uint64][]CompactTask)
+	for _, task := range tasks {
+		byStore[task.StoreID] = append(byStore[task.StoreID], task)
+	}
+	eg, ectx := errgroup.WithContext(ctx)
+	for storeID, storeTasks := range byStore {
+		storeID, storeTasks := storeID, storeTasks
+		eg.Go(func() error {
+			client, err := getter.GetDebugClient(ectx, storeID)
+			if err != nil {
+				return errors.Annotatef(err, "failed to connect to store %d", storeID)
+			}
+			for _, task := range storeTasks {
+				start := time.Now()
+				_, err = client.Compact(ectx, &debugpb.CompactRequest{
+					Db:                        debugpb.DB_KV,
+					Cf:                        "default",
+					FromKey:                   task.StartKey,
+					ToKey:                     task.EndKey,
+					Threads:                   threads,
+					BottommostLevelCompaction: debugpb.BottommostLevelCompaction_IfHaveCompactionFilter,
+				})
+				if err != nil {
+					return errors.Annotatef(err, "failed to compact store %d", storeID)
+				}
+				log.Info("range compacted", zap.Uint64("store-id", storeID),
+					logutil.Key("start", task.StartKey), logutil.Key("end", task.EndKey),
+					zap.Duration("take", time.Since(start)))
+				updateCh.Inc()
+			}
+			return nil
+		})
+	}
+	return errors.Trace(eg.Wait())
+}
@@ -0,0 +1,104 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/debugpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"google.golang.org/grpc"
+)
+
+type fakeDebugClient struct {
+	debugpb.DebugClient
+	storeID uint64
+	g       *fakeDebugClientGetter
+}
+
+func (c *fakeDebugClient) Compact(
+	_ context.Context, req *debugpb.CompactRequest, _ ...grpc.CallOption,
+) (*debugpb.CompactResponse, error) {
+	c.g.mu.Lock()
+	defer c.g.mu.Unlock()
+	if c.storeID == c.g.failStore {
+		return nil, errors.New("compaction failed")
+	}
+	c.g.requests[c.storeID] = append(c.g.requests[c.storeID], req)
+	return &debugpb.CompactResponse{}, nil
+}
+
+type fakeDebugClientGetter struct {
+	mu        sync.Mutex
+	requests  map[uint64][]*debugpb.CompactRequest
+	failStore uint64
+}
+
+func (g *fakeDebugClientGetter) GetDebugClient(_ context.Context, storeID uint64) (debugpb.DebugClient, error) {
+	return &fakeDebugClient{storeID: storeID, g: g}, nil
+}
+
+type countingProgress struct{ n int64 }
+
+func (p *countingProgress) Inc()   { atomic.AddInt64(&p.n, 1) }
+func (p *countingProgress) Close() {}
+
+func TestMergeAdjacentRanges(t *testing.T) {
+	merged := mergeAdjacentRanges([]rtree.Range{
+		{StartKey: []byte("c"), EndKey: []byte("d")},
+		{StartKey: []byte("a"), EndKey: []byte("b")},
+		{StartKey: []byte("b"), EndKey: []byte("c")},
+		{StartKey: []byte("f"), EndKey: []byte("h")},
+		{StartKey: []byte("g"), EndKey: []byte("g1")},
+		{StartKey: []byte("x"), EndKey: []byte("")},
+		{StartKey: []byte("y"), EndKey: []byte("z")},
+	})
+	require.Equal(t, []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("d")},
+		{StartKey: []byte("f"), EndKey: []byte("h")},
+		{StartKey: []byte("x"), EndKey: []byte("")},
+	}, merged)
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	tasks := []CompactTask{
+		{StoreID: 1, StartKey: []byte("za"), EndKey: []byte("zb")},
+		{StoreID: 1, StartKey: []byte("zc"), EndKey: dataMaxKey},
+		{StoreID: 2, StartKey: []byte("za"), EndKey: []byte("zb")},
+	}
+	getter := &fakeDebugClientGetter{requests: make(map[uint64][]*debugpb.CompactRequest)}
+	progress := &countingProgress{}
+	require.NoError(t, Compact(ctx, getter, tasks, 2, progress))
+	require.Equal(t, int64(3), progress.n)
+	require.Len(t, getter.requests[1], 2)
+	require.Len(t, getter.requests[2], 1)
+	req := getter.requests[1][1]
+	require.Equal(t, debugpb.DB_KV, req.Db)
+	require.Equal(t, "default", req.Cf)
+	require.Equal(t, []byte("zc"), req.FromKey)
+	require.Equal(t, []byte("{"), req.ToKey)
+	require.Equal(t, uint32(2), req.Threads)
+
+	getter = &fakeDebugClientGetter{requests: make(map[uint64][]*debugpb.CompactRequest), failStore: 2}
+	err := Compact(ctx, getter, tasks, 2, &countingProgress{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to compact store 2")
+}
@@ -20,7 +20,9 @@ import (
 	"github.com/pingcap/log"
 	"github.com/tikv/migration/br/pkg/conn"
 	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/failpoints"
 	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/storage"
 	"github.com/tikv/migration/br/pkg/summary"
 	"github.com/tikv/migration/br/pkg/utils"
 	pd "github.com/tikv/pd/client"
@@ -80,15 +82,23 @@ type importClient struct {
 	tlsConf    *tls.Config
 
 	keepaliveConf keepalive.ClientParameters
+	maxMsgSize    int
 }
 
 // NewImportClient returns a new ImporterClient.
-func NewImportClient(metaClient SplitClient, tlsConf *tls.Config, keepaliveConf keepalive.ClientParameters) ImporterClient {
+// maxMsgSize limits the size of the gRPC messages, the default limit of gRPC is used if it's 0.
+func NewImportClient(
+	metaClient SplitClient,
+	tlsConf *tls.Config,
+	keepaliveConf keepalive.ClientParameters,
+	maxMsgSize int,
+) ImporterClient {
 	return &importClient{
 		metaClient:    metaClient,
 		clients:       make(map[uint64]import_sstpb.ImportSSTClient),
 		tlsConf:       tlsConf,
 		keepaliveConf: keepaliveConf,
+		maxMsgSize:    maxMsgSize,
 	}
 }
 
@@ -164,15 +174,20 @@ func (ic *importClient) GetImportClient(
 	}
 	bfConf := backoff.DefaultConfig
 	bfConf.MaxDelay = gRPCBackOffMaxDelay
-	conn, err := grpc.DialContext(
-		ctx,
-		addr,
+	opts := []grpc.DialOption{
 		opt,
 		grpc.WithBlock(),
 		grpc.FailOnNonTempDialError(true),
 		grpc.WithConnectParams(grpc.ConnectParams{Backoff: bfConf}),
 		grpc.WithKeepaliveParams(ic.keepaliveConf),
-	)
+	}
+	if ic.maxMsgSize > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(ic.maxMsgSize),
+			grpc.MaxCallSendMsgSize(ic.maxMsgSize),
+		))
+	}
+	conn, err := grpc.DialContext(ctx, addr, opts...)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -207,6 +222,8 @@ type FileImporter struct {
 	rawStartKey        []byte
 	rawEndKey          []byte
 	supportMultiIngest bool
+	// throttle holds back the files sent to the stores, nil means no limit.
+	throttle *IngestThrottle
 }
 
 // NewFileImporter returns a new file importClient.
@@ -294,7 +311,11 @@ func (importer *FileImporter) Import(
 		logutil.Key("startKey", startKey),
 		logutil.Key("endKey", endKey))
 	downloadRegionCnt := 0
+	attempt := 0
 	err := utils.WithRetry(ctx, func() error {
+		if attempt++; attempt > 1 {
+			restoreRegionCounters.WithLabelValues("retry").Inc()
+		}
 		tctx, cancel := context.WithTimeout(ctx, importScanRegionTime)
 		defer cancel()
 		// Scan regions covered by the file range
@@ -309,6 +330,10 @@ func (importer *FileImporter) Import(
 	regionLoop:
 		for _, regionInfo := range regionInfos {
 			info := regionInfo
+			// each peer downloads the whole files.
+			if err := importer.throttle.Wait(ctx, regionStoreIDs(info.Region), filesSize(files)); err != nil {
+				return errors.Trace(err)
+			}
 			// Try to download file.
 			downloadMetas := make([]*import_sstpb.SSTMeta, 0, len(files))
 			remainFiles := files
@@ -317,16 +342,16 @@ func (importer *FileImporter) Import(
 				for i, f := range remainFiles {
 					var downloadMeta *import_sstpb.SSTMeta
 					if importer.isRawKvMode {
-						downloadMeta, e = importer.downloadRawKVSST(ctx, info, f, cipher)
+						downloadMeta, e = importer.downloadRawKVSST(ctx, info, f, rewriteRules, cipher)
 					} else {
 						return errors.Errorf("FileImporter for non-RawKV is unsupported")
 					}
-					failpoint.Inject("restore-storage-error", func(val failpoint.Value) {
+					failpoint.Inject(failpoints.RestoreStorageError, func(val failpoint.Value) {
 						msg := val.(string)
 						log.Debug("failpoint restore-storage-error injected.", zap.String("msg", msg))
 						e = errors.Annotate(e, msg)
 					})
-					failpoint.Inject("restore-gRPC-error", func(_ failpoint.Value) {
+					failpoint.Inject(failpoints.RestoreGRPCError, func(_ failpoint.Value) {
 						log.Warn("the connection to TiKV has been cut by a neko, meow :3")
 						e = status.Error(codes.Unavailable, "the connection to TiKV has been cut by a neko, meow :3")
 					})
@@ -363,7 +388,7 @@ func (importer *FileImporter) Import(
 					logutil.ShortError(errDownload))
 				return errors.Trace(errDownload)
 			}
-			log.Info("download file done", zap.String("file-sample", files[0].Name), zap.Stringer("take", time.Since(start)),
+			logutil.DedupInfo(log.L(), "download file done", zap.String("file-sample", files[0].Name), zap.Stringer("take", time.Since(start)),
 				logutil.Key("start", files[0].StartKey),
 				logutil.Key("end", files[0].EndKey),
 				logutil.Region(info.Region),
@@ -433,21 +458,44 @@ func (importer *FileImporter) Import(
 					zap.Error(errIngest))
 				return errors.Trace(errIngest)
 			}
+			restoreRegionCounters.WithLabelValues("done").Inc()
 		}
 		if downloadRegionCnt == 0 {
 			log.Error("No region downloads the files", logutil.Files(files), zap.Int("count", len(regionInfos)))
 			return errors.Errorf("No region downloads the file: %s", files[0].Name)
 		}
-		log.Info("ingest file done", zap.String("file-sample", files[0].Name), zap.Stringer("take", time.Since(start)))
+		logutil.DedupInfo(log.L(), "ingest file done", zap.String("file-sample", files[0].Name), zap.Stringer("take", time.Since(start)))
 		for _, f := range files {
 			summary.CollectSuccessUnit(summary.TotalKV, 1, f.TotalKvs)
 			summary.CollectSuccessUnit(summary.TotalBytes, 1, f.TotalBytes)
+			restoreBytesCounter.Add(float64(f.TotalBytes))
 		}
 		return nil
 	}, utils.NewImportSSTBackoffer())
 	return errors.Trace(err)
 }
 
+func regionStoreIDs(region *metapb.Region) []uint64 {
+	storeIDs := make([]uint64, 0, len(region.GetPeers()))
+	for _, peer := range region.GetPeers() {
+		storeIDs = append(storeIDs, peer.GetStoreId())
+	}
+	return storeIDs
+}
+
+// filesSize returns the size of the files, or their total bytes if the size isn't recorded.
+func filesSize(files []*backuppb.File) uint64 {
+	var size uint64
+	for _, f := range files {
+		if f.GetSize_() > 0 {
+			size += f.GetSize_()
+		} else {
+			size += f.GetTotalBytes()
+		}
+	}
+	return size
+}
+
 func (importer *FileImporter) setDownloadSpeedLimit(ctx context.Context, storeID uint64, rateLimit uint64) error {
 	req := &import_sstpb.SetDownloadSpeedLimitRequest{
 		SpeedLimit: rateLimit,
@@ -460,13 +508,18 @@ func (importer *FileImporter) downloadRawKVSST(
 	ctx context.Context,
 	regionInfo *RegionInfo,
 	file *backuppb.File,
+	rewriteRules *RewriteRules,
 	cipher *backuppb.CipherInfo,
 ) (*import_sstpb.SSTMeta, error) {
 	uid := uuid.New()
 	id := uid[:]
-	// Empty rule
+	// The range of the SST meta is the region, whose keys are in the new prefix already,
+	// so it's computed by the empty rule.
 	var rule import_sstpb.RewriteRule
 	sstMeta := GetSSTMetaFromFile(id, file, regionInfo.Region, &rule)
+	if rewriteRules != nil && len(rewriteRules.Data) > 0 {
+		rule = *rewriteRules.Data[0]
+	}
 	sstMeta.ApiVersion = importer.sstAPIVersion
 
 	// Cut the SST file's range to fit in the restoring range.
@@ -478,6 +531,17 @@ func (importer *FileImporter) downloadRawKVSST(
 		sstMeta.Range.End = importer.rawEndKey
 		sstMeta.EndKeyExclusive = true
 	}
+	if len(rule.GetNewKeyPrefix()) > 0 {
+		// the file may be a part of a backup file, whose other parts are rewritten by other rules.
+		if bytes.Compare(file.GetStartKey(), sstMeta.Range.GetStart()) > 0 {
+			sstMeta.Range.Start = file.GetStartKey()
+		}
+		if len(file.GetEndKey()) > 0 &&
+			(len(sstMeta.Range.GetEnd()) == 0 || bytes.Compare(file.GetEndKey(), sstMeta.Range.GetEnd()) <= 0) {
+			sstMeta.Range.End = file.GetEndKey()
+			sstMeta.EndKeyExclusive = true
+		}
+	}
 	if bytes.Compare(sstMeta.Range.GetStart(), sstMeta.Range.GetEnd()) > 0 {
 		return nil, errors.Trace(berrors.ErrKVRangeIsEmpty)
 	}
@@ -497,11 +561,28 @@ func (importer *FileImporter) downloadRawKVSST(
 	for _, p := range regionInfo.Region.GetPeers() {
 		peer := p
 		eg.Go(func() error {
+			start := time.Now()
 			resp, err := importer.importClient.DownloadSST(ectx, peer.GetStoreId(), req)
+			summary.CollectStoreDuration(peer.GetStoreId(), time.Since(start))
 			if err != nil {
 				return errors.Trace(err)
 			}
 			if resp.GetError() != nil {
+				msg := resp.GetError().GetMessage()
+				if utils.MessageIsNoSpaceError(msg) {
+					// it isn't retried, the import directory of the store stays full.
+					return errors.Annotatef(berrors.ErrStorageNoSpace,
+						"store %d has no space left to download %s, please free up its disk or scale out the cluster: %s",
+						peer.GetStoreId(), file.GetName(), msg)
+				}
+				if utils.MessageIsCorruptedFileError(msg) {
+					// TiKV downloads the whole file again on retry.
+					summary.CollectInt(storage.SummaryCorruptedDownloads, 1)
+					log.Warn("the file downloaded by TiKV is corrupted",
+						zap.String("name", file.GetName()),
+						zap.Uint64("store-id", peer.GetStoreId()),
+						zap.String("error", msg))
+				}
 				return errors.Annotate(berrors.ErrKVDownloadFailed, resp.GetError().GetMessage())
 			}
 			if resp.GetIsEmpty() {
@@ -0,0 +1,154 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/log"
+	"github.com/tikv/client-go/v2/config"
+	"github.com/tikv/client-go/v2/rawkv"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/mask"
+	"github.com/tikv/migration/br/pkg/mount"
+	"github.com/tikv/migration/br/pkg/redact"
+	"github.com/tikv/migration/br/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// BackupScanner reads the raw kvs of a backup by the order of the keys, e.g. mount.Snapshot.
+type BackupScanner interface {
+	APIVersion() kvrpcpb.APIVersion
+	Scan(ctx context.Context, startKey, endKey []byte, limit int) ([]mount.Entry, error)
+}
+
+// LogicalStats is the statistics of a logical restore.
+type LogicalStats struct {
+	Keys    int
+	Masked  int
+	Expired int
+}
+
+// LogicalRestorer restores the raw kvs of a backup by writing them through the rawkv client
+// batch by batch instead of ingesting the SST files, so that the values can be masked on the
+// way, e.g. to seed a staging cluster by a production backup. It's much slower than ingesting.
+type LogicalRestorer struct {
+	client    RawKVWriter
+	batchSize int
+	masker    *mask.Masker
+	// now returns the current unix time in seconds, which the TTLs are based on.
+	now func() uint64
+}
+
+// NewLogicalRestorer creates a LogicalRestorer connecting to the cluster of the api version by
+// a rawkv client, the values are masked by masker if it isn't nil.
+func NewLogicalRestorer(
+	ctx context.Context, pdAddrs []string, tls utils.TLSConfig, apiVersion kvrpcpb.APIVersion, masker *mask.Masker,
+) (*LogicalRestorer, error) {
+	security := config.Security{}
+	if tls.IsEnabled() {
+		security = config.NewSecurity(tls.CA, tls.Cert, tls.Key, []string{})
+	}
+	rawkvClient, err := rawkv.NewClientWithOpts(ctx, pdAddrs, rawkv.WithAPIVersion(apiVersion),
+		rawkv.WithSecurity(security))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return NewLogicalRestorerWithClient(rawkvClient, defaultChangelogBatchSize, masker), nil
+}
+
+// NewLogicalRestorerWithClient creates a LogicalRestorer with the given client.
+func NewLogicalRestorerWithClient(client RawKVWriter, batchSize int, masker *mask.Masker) *LogicalRestorer {
+	if batchSize <= 0 {
+		batchSize = defaultChangelogBatchSize
+	}
+	return &LogicalRestorer{
+		client:    client,
+		batchSize: batchSize,
+		masker:    masker,
+		now:       func() uint64 { return uint64(time.Now().Unix()) },
+	}
+}
+
+// Close closes the client.
+func (r *LogicalRestorer) Close() error {
+	return errors.Trace(r.client.Close())
+}
+
+// Restore writes the keys of the backup in [startKey, endKey) into the cluster, the keys are
+// in the form of the backup, i.e. with the API V2 prefix for the API V2 backups, and an empty
+// endKey is unbounded. The keys expired already are skipped.
+func (r *LogicalRestorer) Restore(ctx context.Context, backup BackupScanner, startKey, endKey []byte) (LogicalStats, error) {
+	stats := LogicalStats{}
+	for {
+		entries, err := backup.Scan(ctx, startKey, endKey, r.batchSize)
+		if err != nil {
+			return stats, errors.Trace(err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		now := r.now()
+		keys := make([][]byte, 0, len(entries))
+		values := make([][]byte, 0, len(entries))
+		ttls := make([]uint64, 0, len(entries))
+		for _, entry := range entries {
+			if entry.ExpireTime > 0 && entry.ExpireTime <= now {
+				stats.Expired++
+				continue
+			}
+			key := entry.Key
+			if backup.APIVersion() == kvrpcpb.APIVersion_V2 {
+				if !bytes.HasPrefix(key, utils.APIV2KeyPrefix[:]) {
+					return stats, errors.Annotatef(berrors.ErrInvalidArgument,
+						"key %s of the backup is not in the default keyspace", redact.Key(key))
+				}
+				// rawkv client accepts the user key without the prefix.
+				key = key[utils.APIV2KeyPrefixLen:]
+			}
+			value := entry.Value
+			if r.masker != nil {
+				masked := r.masker.Mask(key, value)
+				if !bytes.Equal(masked, value) {
+					stats.Masked++
+				}
+				value = masked
+			}
+			var ttl uint64
+			if entry.ExpireTime > 0 {
+				ttl = entry.ExpireTime - now
+			}
+			keys = append(keys, key)
+			values = append(values, value)
+			ttls = append(ttls, ttl)
+		}
+		if len(keys) > 0 {
+			if err = r.client.BatchPutWithTTL(ctx, keys, values, ttls); err != nil {
+				return stats, errors.Trace(err)
+			}
+			stats.Keys += len(keys)
+		}
+		// the next batch starts right after the last key.
+		last := entries[len(entries)-1].Key
+		startKey = append(append(make([]byte, 0, len(last)+1), last...), 0)
+	}
+	log.Info("restored the backup logically", zap.Int("keys", stats.Keys),
+		zap.Int("masked", stats.Masked), zap.Int("expired", stats.Expired))
+	return stats, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package restore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/mask"
+	"github.com/tikv/migration/br/pkg/mount"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+type fakeBackupScanner struct {
+	apiVersion kvrpcpb.APIVersion
+	entries    []mount.Entry
+}
+
+func (s *fakeBackupScanner) APIVersion() kvrpcpb.APIVersion {
+	return s.apiVersion
+}
+
+func (s *fakeBackupScanner) Scan(_ context.Context, startKey, endKey []byte, limit int) ([]mount.Entry, error) {
+	entries := make([]mount.Entry, 0, limit)
+	for _, entry := range s.entries {
+		if bytes.Compare(entry.Key, startKey) < 0 || (len(endKey) > 0 && bytes.Compare(entry.Key, endKey) >= 0) {
+			continue
+		}
+		if len(entries) == limit {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func v2Key(key string) []byte {
+	return append(utils.APIV2KeyPrefix[:], key...)
+}
+
+func TestLogicalRestore(t *testing.T) {
+	masker, err := mask.NewMasker([]mask.Rule{{Prefix: "secret/", Format: "raw", Method: mask.MethodNull}})
+	require.NoError(t, err)
+	writer := &fakeRawKVWriter{puts: map[string]string{}, ttls: map[string]uint64{}}
+	restorer := NewLogicalRestorerWithClient(writer, 2, masker)
+	restorer.now = func() uint64 { return 100 }
+
+	backup := &fakeBackupScanner{apiVersion: kvrpcpb.APIVersion_V2, entries: []mount.Entry{
+		{Key: v2Key("a"), Value: []byte("1")},
+		{Key: v2Key("b"), Value: []byte("2"), ExpireTime: 50},
+		{Key: v2Key("c"), Value: []byte("3"), ExpireTime: 130},
+		{Key: v2Key("secret/d"), Value: []byte("4")},
+		{Key: v2Key("z"), Value: []byte("5")},
+	}}
+	stats, err := restorer.Restore(context.Background(), backup, v2Key(""), v2Key("z"))
+	require.NoError(t, err)
+	require.Equal(t, LogicalStats{Keys: 3, Masked: 1, Expired: 1}, stats)
+	require.Equal(t, map[string]string{"a": "1", "c": "3", "secret/d": ""}, writer.puts)
+	require.Equal(t, uint64(30), writer.ttls["c"])
+	require.Equal(t, uint64(0), writer.ttls["a"])
+
+	// the keys of the other keyspaces can't be written by the client of the default keyspace.
+	backup.entries = []mount.Entry{{Key: []byte("r\x00\x00\x01a"), Value: []byte("1")}}
+	_, err = restorer.Restore(context.Background(), backup, nil, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not in the default keyspace")
+}
@@ -0,0 +1,42 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	restoreRegionCounters = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tikv_br",
+			Subsystem: "raw",
+			Name:      "restore_region",
+			Help:      "Restore region statistic.",
+		}, []string{"type"})
+
+	restoreBytesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "tikv_br",
+			Subsystem: "raw",
+			Name:      "restore_bytes",
+			Help:      "The bytes of the kvs restored, before compressed.",
+		})
+)
+
+func init() { // nolint:gochecknoinits
+	prometheus.MustRegister(restoreRegionCounters)
+	prometheus.MustRegister(restoreBytesCounter)
+}
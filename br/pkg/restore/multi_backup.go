@@ -0,0 +1,150 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/redact"
+	"github.com/tikv/migration/br/pkg/rtree"
+)
+
+// RawBackup is one of the backups restored together by RestoreRawBackups.
+type RawBackup struct {
+	// Files are the files of the backup in the range to restore.
+	Files    []*backuppb.File
+	importer *FileImporter
+	// cipher decrypts the files of the backup, it defaults to the one of the client.
+	cipher *backuppb.CipherInfo
+}
+
+// SetCrypter sets the cipher of the backup, which may differ from the one of the client
+// if the backups are encrypted by master keys.
+func (b *RawBackup) SetCrypter(cipher *backuppb.CipherInfo) {
+	b.cipher = cipher
+}
+
+// NewMainRawBackup returns the backup initialized by InitBackupMeta with its files to restore.
+func (rc *Client) NewMainRawBackup(files []*backuppb.File) *RawBackup {
+	return &RawBackup{Files: files, importer: &rc.fileImporter}
+}
+
+// NewRawBackupInStorage returns the files of the backup initialized by InitBackupMeta which
+// are in another storage, e.g. the one the backup failed over to.
+func (rc *Client) NewRawBackupInStorage(
+	ctx context.Context,
+	files []*backuppb.File,
+	backend *backuppb.StorageBackend,
+) (*RawBackup, error) {
+	importer := NewFileImporter(rc.fileImporter.metaClient, rc.fileImporter.importClient, backend,
+		rc.backupMeta.IsRawKv, rc.backupMeta.ApiVersion)
+	importer.throttle = rc.throttle
+	if err := importer.CheckMultiIngestSupport(ctx, rc.pdClient); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &RawBackup{Files: files, importer: &importer}, nil
+}
+
+// NewRawBackup prepares another backup restored along with the one initialized by
+// InitBackupMeta. It must be a raw kv backup of the same api version.
+func (rc *Client) NewRawBackup(
+	ctx context.Context,
+	backupMeta *backuppb.BackupMeta,
+	reader *metautil.MetaReader,
+	backend *backuppb.StorageBackend,
+	startKey, endKey []byte,
+	cf string,
+) (*RawBackup, error) {
+	if !backupMeta.IsRawKv {
+		return nil, errors.Annotate(berrors.ErrRestoreModeMismatch, "cannot do raw restore from transactional data")
+	}
+	if backupMeta.ApiVersion != rc.backupMeta.ApiVersion {
+		return nil, errors.Annotatef(berrors.ErrRestoreModeMismatch,
+			"the backups to restore together have different api versions: %s, %s",
+			rc.backupMeta.ApiVersion, backupMeta.ApiVersion)
+	}
+	files, err := filesInRawRange(ctx, backupMeta, reader, startKey, endKey, cf)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	importer := NewFileImporter(rc.fileImporter.metaClient, rc.fileImporter.importClient, backend,
+		backupMeta.IsRawKv, backupMeta.ApiVersion)
+	importer.throttle = rc.throttle
+	if err = importer.CheckMultiIngestSupport(ctx, rc.pdClient); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &RawBackup{Files: files, importer: &importer}, nil
+}
+
+// CheckRawBackupsDisjoint checks that the files of different backups don't overlap,
+// otherwise the result of restoring them together is undefined.
+func CheckRawBackupsDisjoint(backups []*RawBackup) error {
+	type fileOfBackup struct {
+		file   *backuppb.File
+		backup int
+	}
+	files := make([]fileOfBackup, 0)
+	for i, backup := range backups {
+		for _, file := range backup.Files {
+			files = append(files, fileOfBackup{file: file, backup: i})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return bytes.Compare(files[i].file.StartKey, files[j].file.StartKey) < 0
+	})
+	// last is the file with the max end key seen so far.
+	var last *fileOfBackup
+	for i := range files {
+		cur := &files[i]
+		if last != nil && last.backup != cur.backup &&
+			(len(last.file.EndKey) == 0 || bytes.Compare(cur.file.StartKey, last.file.EndKey) < 0) {
+			return errors.Annotatef(berrors.ErrRestoreInvalidRange,
+				"backup #%d and backup #%d overlap in range [%s, %s)", last.backup, cur.backup,
+				redact.Key(cur.file.StartKey), redact.Key(last.file.EndKey))
+		}
+		if last == nil || len(cur.file.EndKey) == 0 ||
+			(len(last.file.EndKey) > 0 && bytes.Compare(cur.file.EndKey, last.file.EndKey) > 0) {
+			last = cur
+		}
+	}
+	return nil
+}
+
+// SplitRawBackupsByRanges splits the files of each backup into the ones overlapping the
+// ranges and the others, so that the former can be restored first. The ranges are in the
+// format of the file keys, i.e. not encoded.
+func SplitRawBackupsByRanges(backups []*RawBackup, ranges []rtree.Range) (overlapped, others []*RawBackup) {
+	for _, backup := range backups {
+		in, out := *backup, *backup
+		in.Files, out.Files = nil, nil
+		for _, file := range backup.Files {
+			fileRange := rtree.Range{StartKey: file.StartKey, EndKey: file.EndKey}
+			if len(rtree.Intersection([]rtree.Range{fileRange}, ranges)) > 0 {
+				in.Files = append(in.Files, file)
+			} else {
+				out.Files = append(out.Files, file)
+			}
+		}
+		overlapped = append(overlapped, &in)
+		others = append(others, &out)
+	}
+	return overlapped, others
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/rtree"
+)
+
+func rawBackupOf(ranges ...string) *RawBackup {
+	backup := &RawBackup{}
+	for i := 0; i < len(ranges); i += 2 {
+		backup.Files = append(backup.Files, &backuppb.File{
+			StartKey: []byte(ranges[i]),
+			EndKey:   []byte(ranges[i+1]),
+		})
+	}
+	return backup
+}
+
+func TestCheckRawBackupsDisjoint(t *testing.T) {
+	cases := []struct {
+		backups  []*RawBackup
+		disjoint bool
+	}{
+		{
+			backups:  []*RawBackup{rawBackupOf("a", "b", "b", "c"), rawBackupOf("c", "d")},
+			disjoint: true,
+		},
+		{
+			backups:  []*RawBackup{rawBackupOf("a", "b", "e", "f"), rawBackupOf("c", "d", "f", "")},
+			disjoint: true,
+		},
+		{
+			backups:  []*RawBackup{rawBackupOf("a", "c"), rawBackupOf("b", "d")},
+			disjoint: false,
+		},
+		{
+			// the file of the second backup is covered by the first file.
+			backups:  []*RawBackup{rawBackupOf("a", "z", "b", "c"), rawBackupOf("d", "e")},
+			disjoint: false,
+		},
+		{
+			backups:  []*RawBackup{rawBackupOf("x", ""), rawBackupOf("y", "z")},
+			disjoint: false,
+		},
+	}
+	for i, cs := range cases {
+		err := CheckRawBackupsDisjoint(cs.backups)
+		if cs.disjoint {
+			require.NoError(t, err, "case #%d", i)
+		} else {
+			require.True(t, berrors.Is(err, berrors.ErrRestoreInvalidRange), "case #%d", i)
+		}
+	}
+}
+
+func TestSplitRawBackupsByRanges(t *testing.T) {
+	backup := rawBackupOf("a", "c", "c", "e", "e", "g", "x", "")
+	cipher := &backuppb.CipherInfo{}
+	backup.SetCrypter(cipher)
+	overlapped, others := SplitRawBackupsByRanges([]*RawBackup{backup, rawBackupOf("h", "i")},
+		[]rtree.Range{{StartKey: []byte("b"), EndKey: []byte("c")}, {StartKey: []byte("f"), EndKey: []byte("f1")}, {StartKey: []byte("y")}})
+	require.Len(t, overlapped, 2)
+	require.Len(t, others, 2)
+	require.Equal(t, []*backuppb.File{backup.Files[0], backup.Files[2], backup.Files[3]}, overlapped[0].Files)
+	require.Equal(t, []*backuppb.File{backup.Files[1]}, others[0].Files)
+	require.Same(t, cipher, overlapped[0].cipher)
+	require.Same(t, cipher, others[0].cipher)
+	require.Empty(t, overlapped[1].Files)
+	require.Len(t, others[1].Files, 1)
+}
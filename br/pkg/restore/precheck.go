@@ -0,0 +1,129 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/client-go/v2/config"
+	"github.com/tikv/client-go/v2/rawkv"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/redact"
+	"github.com/tikv/migration/br/pkg/utils"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// RawKVScanner is the subset of the rawkv client used by RangeProber.
+type RawKVScanner interface {
+	Scan(ctx context.Context, startKey, endKey []byte, limit int, options ...rawkv.RawOption) (keys [][]byte, values [][]byte, err error)
+	Close() error
+}
+
+// RangeProbeResult is the probe result of a target range.
+type RangeProbeResult struct {
+	Range utils.KeyRange
+	// SampledKeys are the existing keys found in the range, at most `sampleSize` keys.
+	SampledKeys [][]byte
+}
+
+// IsEmpty returns whether no existing key is found in the range.
+func (r *RangeProbeResult) IsEmpty() bool {
+	return len(r.SampledKeys) == 0
+}
+
+// RangeProber detects whether the target ranges of a restore already contain data
+// before ingesting, by sampling a few existing keys of each range.
+type RangeProber struct {
+	client      RawKVScanner
+	apiVersion  kvrpcpb.APIVersion
+	sampleSize  int
+	concurrency uint
+}
+
+// NewRangeProber creates a RangeProber connecting to the cluster by a rawkv client.
+func NewRangeProber(ctx context.Context, pdAddrs []string, apiVersion kvrpcpb.APIVersion, tls utils.TLSConfig,
+	sampleSize int, concurrency uint) (*RangeProber, error) {
+	security := config.Security{}
+	if tls.IsEnabled() {
+		security = config.NewSecurity(tls.CA, tls.Cert, tls.Key, []string{})
+	}
+	rawkvClient, err := rawkv.NewClientWithOpts(ctx, pdAddrs, rawkv.WithAPIVersion(apiVersion),
+		rawkv.WithSecurity(security))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return NewRangeProberWithClient(rawkvClient, apiVersion, sampleSize, concurrency), nil
+}
+
+// NewRangeProberWithClient creates a RangeProber with the given client.
+func NewRangeProberWithClient(client RawKVScanner, apiVersion kvrpcpb.APIVersion,
+	sampleSize int, concurrency uint) *RangeProber {
+	if sampleSize <= 0 {
+		sampleSize = 1
+	}
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	return &RangeProber{
+		client:      client,
+		apiVersion:  apiVersion,
+		sampleSize:  sampleSize,
+		concurrency: concurrency,
+	}
+}
+
+// Probe samples the existing keys of the ranges in batch, the results are in the same order as ranges.
+// The ranges are in the format of backup meta, i.e. with the API V2 prefix if the api version is V2.
+func (p *RangeProber) Probe(ctx context.Context, ranges []*utils.KeyRange) ([]RangeProbeResult, error) {
+	results := make([]RangeProbeResult, len(ranges))
+	workerPool := utils.NewWorkerPool(p.concurrency, "Probe Ranges")
+	eg, ectx := errgroup.WithContext(ctx)
+	for i, r := range ranges {
+		idx, keyRange := i, r
+		workerPool.ApplyOnErrorGroup(eg, func() error {
+			scanRange := keyRange
+			// rawkv client accept user key without prefix, convert to v1 format.
+			if p.apiVersion == kvrpcpb.APIVersion_V2 {
+				scanRange = utils.ConvertBackupConfigKeyRange(keyRange.Start, keyRange.End, kvrpcpb.APIVersion_V2, kvrpcpb.APIVersion_V1)
+			}
+			keys, _, err := p.client.Scan(ectx, scanRange.Start, scanRange.End, p.sampleSize)
+			if err != nil {
+				return errors.Annotatef(err, "failed to probe range [%s, %s)",
+					redact.Key(keyRange.Start), redact.Key(keyRange.End))
+			}
+			// each worker writes its own slot, no lock is needed.
+			results[idx] = RangeProbeResult{Range: *keyRange, SampledKeys: keys}
+			if len(keys) > 0 {
+				logutil.CL(ctx).Warn("target range is non-empty",
+					logutil.Key("StartKey", keyRange.Start),
+					logutil.Key("EndKey", keyRange.End),
+					zap.Int("sampled-keys", len(keys)))
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return results, nil
+}
+
+// Close closes the underlying client.
+func (p *RangeProber) Close() error {
+	return p.client.Close()
+}
@@ -0,0 +1,85 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/rawkv"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+type fakeRawKVScanner struct {
+	keys [][]byte
+	err  error
+}
+
+func (f *fakeRawKVScanner) Scan(_ context.Context, startKey, endKey []byte, limit int,
+	_ ...rawkv.RawOption) ([][]byte, [][]byte, error) {
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+	keys := make([][]byte, 0, limit)
+	for _, key := range f.keys {
+		if len(keys) >= limit {
+			break
+		}
+		if bytes.Compare(key, startKey) >= 0 && (len(endKey) == 0 || bytes.Compare(key, endKey) < 0) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, make([][]byte, len(keys)), nil
+}
+
+func (f *fakeRawKVScanner) Close() error {
+	return nil
+}
+
+func TestRangeProber(t *testing.T) {
+	scanner := &fakeRawKVScanner{keys: [][]byte{[]byte("b1"), []byte("b2"), []byte("b3"), []byte("d")}}
+	sort.Slice(scanner.keys, func(i, j int) bool { return bytes.Compare(scanner.keys[i], scanner.keys[j]) < 0 })
+	ranges := []*utils.KeyRange{
+		{Start: []byte("a"), End: []byte("b")},
+		{Start: []byte("b"), End: []byte("c")},
+		{Start: []byte("c"), End: []byte("")},
+	}
+
+	prober := NewRangeProberWithClient(scanner, kvrpcpb.APIVersion_V1, 2, 2)
+	results, err := prober.Probe(context.Background(), ranges)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.True(t, results[0].IsEmpty())
+	require.Equal(t, [][]byte{[]byte("b1"), []byte("b2")}, results[1].SampledKeys)
+	require.Equal(t, [][]byte{[]byte("d")}, results[2].SampledKeys)
+	require.Equal(t, *ranges[2], results[2].Range)
+
+	// API V2 ranges are probed without the prefix.
+	prober = NewRangeProberWithClient(scanner, kvrpcpb.APIVersion_V2, 1, 1)
+	results, err = prober.Probe(context.Background(),
+		[]*utils.KeyRange{utils.FormatAPIV2KeyRange([]byte("b"), []byte("c"))})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("b1")}, results[0].SampledKeys)
+
+	scanner.err = errors.New("scan failed")
+	prober = NewRangeProberWithClient(scanner, kvrpcpb.APIVersion_V1, 2, 2)
+	_, err = prober.Probe(context.Background(), ranges)
+	require.Error(t, err)
+}
@@ -0,0 +1,223 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// PreflightStatus is the outcome of a preflight check.
+type PreflightStatus string
+
+const (
+	// PreflightPass means the check finds nothing wrong.
+	PreflightPass PreflightStatus = "pass"
+	// PreflightWarn doesn't stop the restore, but the user should look into it.
+	PreflightWarn PreflightStatus = "warn"
+	// PreflightFail stops the restore before anything is written into the target cluster.
+	PreflightFail PreflightStatus = "fail"
+)
+
+// The names of the preflight checks.
+const (
+	PreflightAPIVersion  = "api-version"
+	PreflightTiKVVersion = "tikv-version"
+	PreflightStoreState  = "store-state"
+	PreflightSchedulers  = "schedulers"
+	PreflightRegionCount = "region-count"
+	PreflightDiskSpace   = "disk-space"
+	PreflightOverlap     = "overlapping-data"
+)
+
+// balanceSchedulers are the schedulers BR removes during restore, which are expected to exist
+// before it starts.
+var balanceSchedulers = []string{"balance-leader-scheduler", "balance-region-scheduler"}
+
+// PreflightResult is the result of a preflight check.
+type PreflightResult struct {
+	Check   string
+	Status  PreflightStatus
+	Message string
+}
+
+// PreflightReport collects the results of the checks verifying the target cluster is able to
+// take the restore, which run before ingesting so that the restore fails fast instead of in
+// the middle of it.
+type PreflightReport struct {
+	Results []PreflightResult
+}
+
+// Add records the result of a check.
+func (r *PreflightReport) Add(result PreflightResult) {
+	r.Results = append(r.Results, result)
+}
+
+// Pass records a passed check.
+func (r *PreflightReport) Pass(check, format string, args ...interface{}) {
+	r.Add(PreflightResult{Check: check, Status: PreflightPass, Message: fmt.Sprintf(format, args...)})
+}
+
+// Warn records a check which found something suspicious but not fatal.
+func (r *PreflightReport) Warn(check, format string, args ...interface{}) {
+	r.Add(PreflightResult{Check: check, Status: PreflightWarn, Message: fmt.Sprintf(format, args...)})
+}
+
+// Fail records a failed check.
+func (r *PreflightReport) Fail(check, format string, args ...interface{}) {
+	r.Add(PreflightResult{Check: check, Status: PreflightFail, Message: fmt.Sprintf(format, args...)})
+}
+
+// Filter returns the results of the status.
+func (r *PreflightReport) Filter(status PreflightStatus) []PreflightResult {
+	var results []PreflightResult
+	for _, result := range r.Results {
+		if result.Status == status {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// Err returns an error listing all the failed checks, or nil if none fails.
+func (r *PreflightReport) Err() error {
+	failures := r.Filter(PreflightFail)
+	if len(failures) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(failures))
+	for _, f := range failures {
+		messages = append(messages, fmt.Sprintf("[%s] %s", f.Check, f.Message))
+	}
+	return errors.Annotatef(berrors.ErrRestorePreflightFailed,
+		"%d of %d preflight checks failed: %s", len(failures), len(r.Results), strings.Join(messages, "; "))
+}
+
+// Print writes the report as a table.
+func (r *PreflightReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "%-18s%-8s%s\n", "CHECK", "STATUS", "MESSAGE")
+	for _, result := range r.Results {
+		fmt.Fprintf(w, "%-18s%-8s%s\n", result.Check, result.Status, result.Message)
+	}
+}
+
+// PreflightStore is the state of a store of the target cluster reported by PD.
+type PreflightStore struct {
+	ID      uint64
+	Address string
+	// State is the state name of the store, e.g. "Up", "Disconnected", "Down" or "Offline".
+	State       string
+	Version     string
+	RegionCount int
+}
+
+// CheckStoreStates fails if any store isn't up, because the peers on it can't ingest the files,
+// or there are fewer stores than the replicas of a region.
+func CheckStoreStates(stores []PreflightStore, maxReplicas uint64) PreflightResult {
+	var notUp []string
+	for _, s := range stores {
+		if s.State != "Up" {
+			notUp = append(notUp, fmt.Sprintf("store %d at %s is %s", s.ID, s.Address, s.State))
+		}
+	}
+	if len(notUp) > 0 {
+		return PreflightResult{Check: PreflightStoreState, Status: PreflightFail, Message: fmt.Sprintf(
+			"%s; please bring the stores back or remove them from the cluster by pd-ctl", strings.Join(notUp, ", "))}
+	}
+	if uint64(len(stores)) < maxReplicas {
+		return PreflightResult{Check: PreflightStoreState, Status: PreflightFail, Message: fmt.Sprintf(
+			"%d stores can't hold %d replicas of a region; please scale out the cluster or lower max-replicas by pd-ctl",
+			len(stores), maxReplicas)}
+	}
+	return PreflightResult{Check: PreflightStoreState, Status: PreflightPass,
+		Message: fmt.Sprintf("%d stores are up", len(stores))}
+}
+
+// CheckStoreVersions warns if the stores are of different versions, e.g. in the middle of a
+// rolling upgrade, during which the stores may be restarted and fail the ingestion.
+func CheckStoreVersions(stores []PreflightStore) PreflightResult {
+	versions := make(map[string][]uint64)
+	for _, s := range stores {
+		versions[s.Version] = append(versions[s.Version], s.ID)
+	}
+	if len(versions) <= 1 {
+		return PreflightResult{Check: PreflightTiKVVersion, Status: PreflightPass,
+			Message: fmt.Sprintf("all %d stores are of the same version", len(stores))}
+	}
+	names := make([]string, 0, len(versions))
+	for v, ids := range versions {
+		names = append(names, fmt.Sprintf("%s on stores %v", v, ids))
+	}
+	sort.Strings(names)
+	return PreflightResult{Check: PreflightTiKVVersion, Status: PreflightWarn, Message: fmt.Sprintf(
+		"the stores are of different versions: %s; please restore after the rolling upgrade finishes",
+		strings.Join(names, ", "))}
+}
+
+// CheckSchedulers warns if the balance schedulers are missing, e.g. left removed by an
+// interrupted BR, since BR only adds back the schedulers it removes itself.
+func CheckSchedulers(schedulers []string) PreflightResult {
+	existing := make(map[string]struct{}, len(schedulers))
+	for _, s := range schedulers {
+		existing[s] = struct{}{}
+	}
+	var missing []string
+	for _, s := range balanceSchedulers {
+		if _, ok := existing[s]; !ok {
+			missing = append(missing, s)
+		}
+	}
+	if len(missing) > 0 {
+		return PreflightResult{Check: PreflightSchedulers, Status: PreflightWarn, Message: fmt.Sprintf(
+			"%s not found, they may be left removed by an interrupted BR and stay removed after the restore; "+
+				"please add them back by `pd-ctl scheduler add`", strings.Join(missing, ", "))}
+	}
+	return PreflightResult{Check: PreflightSchedulers, Status: PreflightPass,
+		Message: fmt.Sprintf("%d schedulers are running", len(schedulers))}
+}
+
+// CheckRegionCount estimates the regions on each store after splitting the target regions by
+// splitKeys keys, assuming the new peers are balanced over the stores. It fails if any store
+// would exceed limit regions, 0 means unlimited.
+func CheckRegionCount(stores []PreflightStore, splitKeys int, maxReplicas uint64, limit int) PreflightResult {
+	newPeers := 0
+	if len(stores) > 0 {
+		newPeers = (splitKeys*int(maxReplicas) + len(stores) - 1) / len(stores)
+	}
+	maxRegions := 0
+	var exceeded []string
+	for _, s := range stores {
+		regions := s.RegionCount + newPeers
+		if regions > maxRegions {
+			maxRegions = regions
+		}
+		if limit > 0 && regions > limit {
+			exceeded = append(exceeded, fmt.Sprintf("store %d at %s (%d regions)", s.ID, s.Address, regions))
+		}
+	}
+	if len(exceeded) > 0 {
+		return PreflightResult{Check: PreflightRegionCount, Status: PreflightFail, Message: fmt.Sprintf(
+			"splitting %d regions exceeds the limit of %d regions per store on %s; "+
+				"please restore a smaller range, scale out the cluster, or raise the limit",
+			splitKeys, limit, strings.Join(exceeded, ", "))}
+	}
+	return PreflightResult{Check: PreflightRegionCount, Status: PreflightPass, Message: fmt.Sprintf(
+		"%d regions to split, at most %d regions per store afterwards", splitKeys, maxRegions)}
+}
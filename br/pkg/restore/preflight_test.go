@@ -0,0 +1,98 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+func preflightStores() []PreflightStore {
+	return []PreflightStore{
+		{ID: 1, Address: "tikv-0:20160", State: "Up", Version: "6.1.0", RegionCount: 100},
+		{ID: 2, Address: "tikv-1:20160", State: "Up", Version: "6.1.0", RegionCount: 200},
+		{ID: 3, Address: "tikv-2:20160", State: "Up", Version: "6.1.0", RegionCount: 300},
+	}
+}
+
+func TestCheckStoreStates(t *testing.T) {
+	stores := preflightStores()
+	require.Equal(t, PreflightPass, CheckStoreStates(stores, 3).Status)
+
+	result := CheckStoreStates(stores, 5)
+	require.Equal(t, PreflightFail, result.Status)
+	require.Contains(t, result.Message, "3 stores can't hold 5 replicas")
+
+	stores[1].State = "Disconnected"
+	result = CheckStoreStates(stores, 3)
+	require.Equal(t, PreflightFail, result.Status)
+	require.Contains(t, result.Message, "store 2 at tikv-1:20160 is Disconnected")
+}
+
+func TestCheckStoreVersions(t *testing.T) {
+	stores := preflightStores()
+	require.Equal(t, PreflightPass, CheckStoreVersions(stores).Status)
+
+	stores[2].Version = "6.2.0"
+	result := CheckStoreVersions(stores)
+	require.Equal(t, PreflightWarn, result.Status)
+	require.Contains(t, result.Message, "6.1.0 on stores [1 2], 6.2.0 on stores [3]")
+}
+
+func TestCheckSchedulers(t *testing.T) {
+	require.Equal(t, PreflightPass, CheckSchedulers([]string{
+		"balance-hot-region-scheduler", "balance-leader-scheduler", "balance-region-scheduler",
+	}).Status)
+
+	result := CheckSchedulers([]string{"balance-hot-region-scheduler", "balance-leader-scheduler"})
+	require.Equal(t, PreflightWarn, result.Status)
+	require.Contains(t, result.Message, "balance-region-scheduler not found")
+}
+
+func TestCheckRegionCount(t *testing.T) {
+	stores := preflightStores()
+	// 100 new regions of 3 replicas add 100 peers to each store.
+	result := CheckRegionCount(stores, 100, 3, 0)
+	require.Equal(t, PreflightPass, result.Status)
+	require.Contains(t, result.Message, "at most 400 regions per store")
+	require.Equal(t, PreflightPass, CheckRegionCount(stores, 100, 3, 400).Status)
+
+	result = CheckRegionCount(stores, 100, 3, 350)
+	require.Equal(t, PreflightFail, result.Status)
+	require.Contains(t, result.Message, "store 3 at tikv-2:20160 (400 regions)")
+	require.NotContains(t, result.Message, "store 2")
+}
+
+func TestPreflightReport(t *testing.T) {
+	report := &PreflightReport{}
+	report.Pass(PreflightAPIVersion, "the backup and the cluster are of api version %s", "V2")
+	report.Warn(PreflightOverlap, "1 of 2 target ranges are non-empty")
+	require.NoError(t, report.Err())
+
+	report.Fail(PreflightDiskSpace, "store %d is full", 1)
+	report.Add(CheckStoreStates(nil, 3))
+	require.Len(t, report.Filter(PreflightFail), 2)
+	err := report.Err()
+	require.Error(t, err)
+	require.True(t, berrors.ErrRestorePreflightFailed.Equal(err))
+	require.Contains(t, err.Error(), "2 of 4 preflight checks failed: [disk-space] store 1 is full; [store-state]")
+
+	var buf bytes.Buffer
+	report.Print(&buf)
+	require.Contains(t, buf.String(), "overlapping-data  warn    1 of 2 target ranges are non-empty")
+}
@@ -0,0 +1,210 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/client-go/v2/util/codec"
+	"github.com/tikv/migration/br/pkg/conn"
+	"github.com/tikv/migration/br/pkg/rtree"
+)
+
+// StoreImpact is the impact of a restore on a store.
+type StoreImpact struct {
+	StoreID uint64
+	Address string
+	// SSTs and Bytes are the SST files and the bytes ingested into the peers on the store.
+	SSTs  int
+	Bytes uint64
+	// UsedSize is the used size of the store before restore.
+	UsedSize uint64
+}
+
+// ImpactReport previews the impact of a restore on the target cluster before ingesting,
+// it's computed from the ranges of the backup files and the regions and stores in PD.
+type ImpactReport struct {
+	// Regions is the count of the target regions overlapping the restored ranges.
+	Regions int
+	// SplitRegions is the count of the target regions split before ingesting, by SplitKeys keys.
+	SplitRegions int
+	SplitKeys    int
+	// Stores are the stores of the target cluster sorted by id.
+	Stores []*StoreImpact
+	// Bytes is the total bytes ingested into all the peers.
+	Bytes uint64
+	// RebalanceBytes estimates the bytes moved by PD to balance the used size of the stores
+	// after restore, i.e. the bytes exceeding the average on the stores above it.
+	RebalanceBytes uint64
+}
+
+// Print writes the report as a table.
+func (r *ImpactReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "target regions: %d, to split: %d (by %d keys)\n", r.Regions, r.SplitRegions, r.SplitKeys)
+	fmt.Fprintf(w, "%-10s%-24s%-10s%-16s%s\n", "STORE", "ADDRESS", "SSTS", "BYTES", "USED-AFTER")
+	for _, s := range r.Stores {
+		fmt.Fprintf(w, "%-10d%-24s%-10d%-16s%s\n", s.StoreID, s.Address, s.SSTs,
+			units.HumanSize(float64(s.Bytes)), units.HumanSize(float64(s.UsedSize+s.Bytes)))
+	}
+	fmt.Fprintf(w, "total ingested: %s, estimated rebalance afterwards: %s\n",
+		units.HumanSize(float64(r.Bytes)), units.HumanSize(float64(r.RebalanceBytes)))
+}
+
+// PreviewImpact previews the impact of restoring the files in the ranges on the target cluster,
+// storeSize returns the used size of a store.
+func (rc *Client) PreviewImpact(
+	ctx context.Context,
+	ranges []rtree.Range,
+	files []*backuppb.File,
+	storeSize func(ctx context.Context, storeID uint64) (uint64, error),
+) (*ImpactReport, error) {
+	stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient, conn.SkipTiFlash)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	usedSizes := make(map[uint64]uint64, len(stores))
+	for _, store := range stores {
+		size, err := storeSize(ctx, store.GetId())
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to get the size of store %d", store.GetId())
+		}
+		usedSizes[store.GetId()] = size
+	}
+	return PreviewImpact(ctx, rc.toolClient, stores, usedSizes, ranges, files,
+		rc.dstAPIVersion == kvrpcpb.APIVersion_V2)
+}
+
+// PreviewImpact previews the impact of restoring the files in the ranges on the stores.
+// The regions are split at the end keys of the ranges like SplitRanges, and each file is
+// ingested into all the peers of the regions it overlaps, with its size spread over them.
+func PreviewImpact(
+	ctx context.Context,
+	client SplitClient,
+	stores []*metapb.Store,
+	usedSizes map[uint64]uint64,
+	ranges []rtree.Range,
+	files []*backuppb.File,
+	needEncodeKey bool,
+) (*ImpactReport, error) {
+	report := &ImpactReport{}
+	impacts := make(map[uint64]*StoreImpact, len(stores))
+	for _, store := range stores {
+		impact := &StoreImpact{StoreID: store.GetId(), Address: store.GetAddress(), UsedSize: usedSizes[store.GetId()]}
+		impacts[store.GetId()] = impact
+		report.Stores = append(report.Stores, impact)
+	}
+	if len(ranges) == 0 {
+		return report, nil
+	}
+
+	encode := func(key []byte) []byte {
+		if needEncodeKey && len(key) > 0 {
+			return codec.EncodeBytes(nil, key)
+		}
+		return key
+	}
+	// the ranges of the backup chain overlap and aren't sorted, scan the whole span of them.
+	minKey, maxKey := ranges[0].StartKey, ranges[0].EndKey
+	for _, rg := range ranges[1:] {
+		if bytes.Compare(rg.StartKey, minKey) < 0 {
+			minKey = rg.StartKey
+		}
+		if len(maxKey) > 0 && (len(rg.EndKey) == 0 || bytes.Compare(rg.EndKey, maxKey) > 0) {
+			maxKey = rg.EndKey
+		}
+	}
+	regions, err := PaginateScanRegion(ctx, client, encode(minKey), encode(maxKey), ScanRegionPaginationLimit)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	touched := make(map[uint64]struct{})
+	for _, rg := range ranges {
+		for _, region := range overlappedRegions(regions, encode(rg.StartKey), encode(rg.EndKey)) {
+			touched[region.Region.GetId()] = struct{}{}
+		}
+	}
+	report.Regions = len(touched)
+	for _, keys := range getSplitKeys(nil, ranges, regions, needEncodeKey) {
+		report.SplitRegions++
+		report.SplitKeys += len(keys)
+	}
+
+	for _, file := range files {
+		size := file.GetSize_()
+		if size == 0 {
+			size = file.GetTotalBytes()
+		}
+		overlapped := overlappedRegions(regions, encode(file.GetStartKey()), encode(file.GetEndKey()))
+		for i, region := range overlapped {
+			share := size / uint64(len(overlapped))
+			if i == 0 {
+				share += size % uint64(len(overlapped))
+			}
+			for _, peer := range region.Region.GetPeers() {
+				impact, ok := impacts[peer.GetStoreId()]
+				if !ok {
+					impact = &StoreImpact{StoreID: peer.GetStoreId()}
+					impacts[peer.GetStoreId()] = impact
+					report.Stores = append(report.Stores, impact)
+				}
+				impact.SSTs++
+				impact.Bytes += share
+				report.Bytes += share
+			}
+		}
+	}
+	sort.Slice(report.Stores, func(i, j int) bool {
+		return report.Stores[i].StoreID < report.Stores[j].StoreID
+	})
+
+	if len(report.Stores) == 0 {
+		return report, nil
+	}
+	var total uint64
+	for _, s := range report.Stores {
+		total += s.UsedSize + s.Bytes
+	}
+	avg := total / uint64(len(report.Stores))
+	for _, s := range report.Stores {
+		if after := s.UsedSize + s.Bytes; after > avg {
+			report.RebalanceBytes += after - avg
+		}
+	}
+	return report, nil
+}
+
+// overlappedRegions returns the regions overlapping [startKey, endKey), the keys are encoded
+// like the ones of the regions.
+func overlappedRegions(regions []*RegionInfo, startKey, endKey []byte) []*RegionInfo {
+	overlapped := make([]*RegionInfo, 0, 1)
+	for _, region := range regions {
+		regionEnd := region.Region.GetEndKey()
+		if (len(regionEnd) == 0 || bytes.Compare(startKey, regionEnd) < 0) &&
+			(len(endKey) == 0 || bytes.Compare(region.Region.GetStartKey(), endKey) < 0) {
+			overlapped = append(overlapped, region)
+		}
+	}
+	return overlapped
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/util/codec"
+	"github.com/tikv/migration/br/pkg/rtree"
+)
+
+func TestPreviewImpact(t *testing.T) {
+	// regions: [, b) on store 1, 2; [b, d) on store 2, 3; [d, ) on store 1, 3.
+	keys := []string{"", "b", "d", ""}
+	peers := [][]uint64{{1, 2}, {2, 3}, {1, 3}}
+	regions := make(map[uint64]*RegionInfo)
+	for i := 0; i < 3; i++ {
+		region := &metapb.Region{Id: uint64(i + 1)}
+		if len(keys[i]) > 0 {
+			region.StartKey = codec.EncodeBytes(nil, []byte(keys[i]))
+		}
+		if len(keys[i+1]) > 0 {
+			region.EndKey = codec.EncodeBytes(nil, []byte(keys[i+1]))
+		}
+		for _, storeID := range peers[i] {
+			region.Peers = append(region.Peers, &metapb.Peer{Id: uint64(i*10) + storeID, StoreId: storeID})
+		}
+		regions[region.Id] = &RegionInfo{Region: region, Leader: region.Peers[0]}
+	}
+	stores := []*metapb.Store{{Id: 1, Address: "tikv1"}, {Id: 2, Address: "tikv2"}, {Id: 3, Address: "tikv3"}}
+	client := NewTestClient(map[uint64]*metapb.Store{1: stores[0], 2: stores[1], 3: stores[2]}, regions, 4)
+
+	ranges := []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("c")},
+		{StartKey: []byte("c"), EndKey: []byte("d")},
+	}
+	files := []*backuppb.File{
+		// spread over [, b) and [b, d).
+		{StartKey: []byte("a"), EndKey: []byte("c"), Size_: 100},
+		// in [b, d) only.
+		{StartKey: []byte("c"), EndKey: []byte("d"), Size_: 40},
+	}
+	usedSizes := map[uint64]uint64{1: 1000, 2: 1000, 3: 1000}
+	report, err := PreviewImpact(context.Background(), client, stores, usedSizes, ranges, files, true)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, report.Regions)
+	// "c" splits [b, d), "d" is the boundary of a region.
+	require.Equal(t, 1, report.SplitRegions)
+	require.Equal(t, 1, report.SplitKeys)
+
+	require.Len(t, report.Stores, 3)
+	require.Equal(t, StoreImpact{StoreID: 1, Address: "tikv1", SSTs: 1, Bytes: 50, UsedSize: 1000}, *report.Stores[0])
+	require.Equal(t, StoreImpact{StoreID: 2, Address: "tikv2", SSTs: 3, Bytes: 140, UsedSize: 1000}, *report.Stores[1])
+	require.Equal(t, StoreImpact{StoreID: 3, Address: "tikv3", SSTs: 2, Bytes: 90, UsedSize: 1000}, *report.Stores[2])
+	require.Equal(t, uint64(280), report.Bytes)
+	// the average after restore is 1093, store 2 is 47 above it.
+	require.Equal(t, uint64(47), report.RebalanceBytes)
+
+	var out bytes.Buffer
+	report.Print(&out)
+	require.Contains(t, out.String(), "target regions: 2, to split: 1 (by 1 keys)")
+	require.Contains(t, out.String(), "tikv2")
+
+	report, err = PreviewImpact(context.Background(), client, stores, usedSizes, nil, nil, true)
+	require.NoError(t, err)
+	require.Equal(t, 0, report.Regions)
+	require.Equal(t, uint64(0), report.RebalanceBytes)
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package restore
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/redact"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+// RawKeyRewrite rewrites the raw keys with OldPrefix to have NewPrefix instead during restore,
+// e.g. to restore the backup of a prefix into another one. Only the keys with OldPrefix are
+// restored. The prefixes are in the format of the raw keys of the backup, i.e. not encoded.
+type RawKeyRewrite struct {
+	OldPrefix []byte
+	NewPrefix []byte
+}
+
+// OldRange returns the range of the keys with OldPrefix.
+func (r *RawKeyRewrite) OldRange() rtree.Range {
+	return rtree.Range{StartKey: r.OldPrefix, EndKey: utils.PrefixNext(r.OldPrefix)}
+}
+
+// rewriteKey rewrites a key in OldRange or its end.
+func (r *RawKeyRewrite) rewriteKey(key []byte) []byte {
+	if !bytes.HasPrefix(key, r.OldPrefix) {
+		// it's the end of OldRange, including the empty key if OldRange is unbounded.
+		return utils.PrefixNext(r.NewPrefix)
+	}
+	return append(append([]byte{}, r.NewPrefix...), key[len(r.OldPrefix):]...)
+}
+
+// RewriteRange returns the range rewritten from the part of rg in OldRange, it returns false
+// if they don't overlap.
+func (r *RawKeyRewrite) RewriteRange(rg rtree.Range) (rtree.Range, bool) {
+	clipped := rtree.Intersection([]rtree.Range{rg}, []rtree.Range{r.OldRange()})
+	if len(clipped) == 0 {
+		return rtree.Range{}, false
+	}
+	return rtree.Range{
+		StartKey: r.rewriteKey(clipped[0].StartKey),
+		EndKey:   r.rewriteKey(clipped[0].EndKey),
+	}, true
+}
+
+// RewriteRanges rewrites the ranges, the parts out of OldRange are dropped.
+func (r *RawKeyRewrite) RewriteRanges(ranges []rtree.Range) []rtree.Range {
+	rewritten := make([]rtree.Range, 0, len(ranges))
+	for _, rg := range ranges {
+		if newRange, ok := r.RewriteRange(rg); ok {
+			rewritten = append(rewritten, newRange)
+		}
+	}
+	return rewritten
+}
+
+// rewriteRules returns the rules sent to TiKV to rewrite the keys on download.
+func (r *RawKeyRewrite) rewriteRules() *RewriteRules {
+	if r == nil {
+		return EmptyRewriteRule()
+	}
+	return &RewriteRules{Data: []*import_sstpb.RewriteRule{{
+		OldKeyPrefix: r.OldPrefix,
+		NewKeyPrefix: r.NewPrefix,
+	}}}
+}
+
+// prefixesOverlap returns whether a key may have both prefixes.
+func prefixesOverlap(a, b []byte) bool {
+	return bytes.HasPrefix(a, b) || bytes.HasPrefix(b, a)
+}
+
+// CheckRawKeyRewrites checks the rewrites applied in one restore. The old prefixes must not
+// overlap, otherwise a key would be rewritten by several rewrites, and neither must the new
+// prefixes, otherwise the keys of a rewrite would overwrite those of another.
+func CheckRawKeyRewrites(rewrites []*RawKeyRewrite) error {
+	for i, r := range rewrites {
+		if len(r.OldPrefix) == 0 || len(r.NewPrefix) == 0 {
+			return errors.Annotate(berrors.ErrRestoreInvalidRewrite, "the prefixes to rewrite must not be empty")
+		}
+		for _, other := range rewrites[:i] {
+			if prefixesOverlap(other.OldPrefix, r.OldPrefix) {
+				return errors.Annotatef(berrors.ErrRestoreInvalidRewrite, "the old prefixes %s and %s overlap",
+					redact.Key(other.OldPrefix), redact.Key(r.OldPrefix))
+			}
+			if prefixesOverlap(other.NewPrefix, r.NewPrefix) {
+				return errors.Annotatef(berrors.ErrRestoreInvalidRewrite, "the new prefixes %s and %s overlap",
+					redact.Key(other.NewPrefix), redact.Key(r.NewPrefix))
+			}
+		}
+	}
+	return nil
+}
+
+// RewriteRawRanges rewrites the ranges by each of the disjoint rewrites, the parts out of all the
+// old prefixes are dropped. The rewritten ranges are sorted.
+func RewriteRawRanges(rewrites []*RawKeyRewrite, ranges []rtree.Range) []rtree.Range {
+	rewritten := make([]rtree.Range, 0, len(ranges))
+	for _, r := range rewrites {
+		rewritten = append(rewritten, r.RewriteRanges(ranges)...)
+	}
+	sort.Slice(rewritten, func(i, j int) bool {
+		return bytes.Compare(rewritten[i].StartKey, rewritten[j].StartKey) < 0
+	})
+	return rewritten
+}
@@ -0,0 +1,96 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/rtree"
+)
+
+func TestRawKeyRewriteRanges(t *testing.T) {
+	rewrite := &RawKeyRewrite{OldPrefix: []byte("prod_"), NewPrefix: []byte("staging_")}
+	require.Equal(t, rtree.Range{StartKey: []byte("prod_"), EndKey: []byte("prod`")}, rewrite.OldRange())
+
+	rg, ok := rewrite.RewriteRange(rtree.Range{StartKey: []byte("prod_a"), EndKey: []byte("prod_c")})
+	require.True(t, ok)
+	require.Equal(t, rtree.Range{StartKey: []byte("staging_a"), EndKey: []byte("staging_c")}, rg)
+
+	// the parts out of the old prefix are clipped.
+	rg, ok = rewrite.RewriteRange(rtree.Range{StartKey: []byte("a"), EndKey: []byte("z")})
+	require.True(t, ok)
+	require.Equal(t, rtree.Range{StartKey: []byte("staging_"), EndKey: []byte("staging`")}, rg)
+	rg, ok = rewrite.RewriteRange(rtree.Range{StartKey: []byte("prod_x"), EndKey: []byte{}})
+	require.True(t, ok)
+	require.Equal(t, rtree.Range{StartKey: []byte("staging_x"), EndKey: []byte("staging`")}, rg)
+
+	_, ok = rewrite.RewriteRange(rtree.Range{StartKey: []byte("a"), EndKey: []byte("b")})
+	require.False(t, ok)
+
+	ranges := rewrite.RewriteRanges([]rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("b")},
+		{StartKey: []byte("prod_a"), EndKey: []byte("prod_b")},
+		{StartKey: []byte("x"), EndKey: []byte("y")},
+	})
+	require.Equal(t, []rtree.Range{{StartKey: []byte("staging_a"), EndKey: []byte("staging_b")}}, ranges)
+}
+
+func TestRawKeyRewriteRules(t *testing.T) {
+	var rewrite *RawKeyRewrite
+	require.Empty(t, rewrite.rewriteRules().Data)
+
+	rewrite = &RawKeyRewrite{OldPrefix: []byte("a"), NewPrefix: []byte("b")}
+	rules := rewrite.rewriteRules()
+	require.Len(t, rules.Data, 1)
+	require.Equal(t, []byte("a"), rules.Data[0].OldKeyPrefix)
+	require.Equal(t, []byte("b"), rules.Data[0].NewKeyPrefix)
+}
+
+func TestCheckRawKeyRewrites(t *testing.T) {
+	require.NoError(t, CheckRawKeyRewrites([]*RawKeyRewrite{
+		{OldPrefix: []byte("user_"), NewPrefix: []byte("u/")},
+		{OldPrefix: []byte("order_"), NewPrefix: []byte("o/")},
+		// a prefix can be restored as is among the rewritten ones.
+		{OldPrefix: []byte("item_"), NewPrefix: []byte("item_")},
+	}))
+
+	for _, rewrites := range [][]*RawKeyRewrite{
+		{{OldPrefix: []byte("user_"), NewPrefix: []byte("u/")}, {OldPrefix: []byte("user_vip_"), NewPrefix: []byte("v/")}},
+		{{OldPrefix: []byte("user_"), NewPrefix: []byte("u/")}, {OldPrefix: []byte("order_"), NewPrefix: []byte("u/o/")}},
+		{{OldPrefix: []byte("user_"), NewPrefix: []byte("u/")}, {OldPrefix: []byte("user_"), NewPrefix: []byte("v/")}},
+		{{OldPrefix: []byte{}, NewPrefix: []byte("u/")}},
+	} {
+		require.ErrorIs(t, CheckRawKeyRewrites(rewrites), berrors.ErrRestoreInvalidRewrite)
+	}
+}
+
+func TestRewriteRawRanges(t *testing.T) {
+	rewrites := []*RawKeyRewrite{
+		{OldPrefix: []byte("b"), NewPrefix: []byte("y")},
+		{OldPrefix: []byte("a"), NewPrefix: []byte("z")},
+	}
+	// a range covering both of the old prefixes is split into the new prefixes.
+	ranges := RewriteRawRanges(rewrites, []rtree.Range{
+		{StartKey: []byte("a1"), EndKey: []byte("b5")},
+		{StartKey: []byte("c"), EndKey: []byte("d")},
+	})
+	require.Equal(t, []rtree.Range{
+		{StartKey: []byte("y"), EndKey: []byte("y5")},
+		{StartKey: []byte("z1"), EndKey: []byte("{")},
+	}, ranges)
+	require.Empty(t, RewriteRawRanges(rewrites, []rtree.Range{{StartKey: []byte("c"), EndKey: []byte("d")}}))
+}
@@ -56,15 +56,32 @@ var (
 // RegionSplitter is a executor of region split by rules.
 type RegionSplitter struct {
 	client SplitClient
+	// scatterWaitTimeout is how long Split waits for the regions to be scattered, 0 means not waiting.
+	scatterWaitTimeout time.Duration
+	// scatterExisting scatters the regions covering the ranges which need no split as well,
+	// e.g. the ones split by a previous restore, so that the ingestion isn't hot-spotted on
+	// the stores they happen to be on.
+	scatterExisting bool
 }
 
 // NewRegionSplitter returns a new RegionSplitter.
 func NewRegionSplitter(client SplitClient) *RegionSplitter {
 	return &RegionSplitter{
-		client: client,
+		client:             client,
+		scatterWaitTimeout: ScatterWaitUpperInterval,
 	}
 }
 
+// SetScatterWaitTimeout sets how long Split waits for the regions to be scattered, 0 means not waiting.
+func (rs *RegionSplitter) SetScatterWaitTimeout(timeout time.Duration) {
+	rs.scatterWaitTimeout = timeout
+}
+
+// EnableScatterExisting makes Split scatter the regions covering the ranges which need no split.
+func (rs *RegionSplitter) EnableScatterExisting() {
+	rs.scatterExisting = true
+}
+
 // OnSplitFunc is called before split a range.
 type OnSplitFunc func(key [][]byte)
 
@@ -106,9 +123,15 @@ func (rs *RegionSplitter) Split(
 
 	interval := SplitRetryInterval
 	scatterRegions := make([]*RegionInfo, 0)
+	// the regions and their split keys of the last scan.
+	var (
+		regions     []*RegionInfo
+		splitKeyMap map[uint64][][]byte
+	)
 SplitRegions:
 	for i := 0; i < SplitRetryTimes; i++ {
-		regions, errScan := PaginateScanRegion(ctx, rs.client, minKey, maxKey, ScanRegionPaginationLimit)
+		var errScan error
+		regions, errScan = PaginateScanRegion(ctx, rs.client, minKey, maxKey, ScanRegionPaginationLimit)
 		if errScan != nil {
 			if berrors.ErrPDBatchScanRegion.Equal(errScan) {
 				log.Warn("inconsistent region info get.", logutil.ShortError(errScan))
@@ -117,7 +140,7 @@ SplitRegions:
 			}
 			return errors.Trace(errScan)
 		}
-		splitKeyMap := getSplitKeys(rewriteRules, sortedRanges, regions, needEncodeKey)
+		splitKeyMap = getSplitKeys(rewriteRules, sortedRanges, regions, needEncodeKey)
 		regionMap := make(map[uint64]*RegionInfo)
 		for _, region := range regions {
 			regionMap[region.Region.GetId()] = region
@@ -168,13 +191,26 @@ SplitRegions:
 	if errSplit != nil {
 		return errors.Trace(errSplit)
 	}
+	if rs.scatterExisting {
+		existing := unsplitRegionsInRanges(regions, splitKeyMap, scatterRegions, sortedRanges, needEncodeKey)
+		if len(existing) > 0 {
+			log.Info("scatter the existing regions of the ranges", zap.Int("regions", len(existing)))
+			rs.ScatterRegions(ctx, existing)
+			scatterRegions = append(scatterRegions, existing...)
+		}
+	}
+	if rs.scatterWaitTimeout <= 0 {
+		log.Info("skip waiting for scattering regions",
+			zap.Int("regions", len(scatterRegions)), zap.Duration("take", time.Since(startTime)))
+		return nil
+	}
 	log.Info("start to wait for scattering regions",
 		zap.Int("regions", len(scatterRegions)), zap.Duration("take", time.Since(startTime)))
 	startTime = time.Now()
 	scatterCount := 0
 	for _, region := range scatterRegions {
 		rs.waitForScatterRegion(ctx, region)
-		if time.Since(startTime) > ScatterWaitUpperInterval {
+		if time.Since(startTime) > rs.scatterWaitTimeout {
 			break
 		}
 		scatterCount++
@@ -191,6 +227,47 @@ SplitRegions:
 	return nil
 }
 
+// unsplitRegionsInRanges returns the regions overlapping the ranges which are neither split nor
+// scattered already.
+func unsplitRegionsInRanges(
+	regions []*RegionInfo,
+	splitKeyMap map[uint64][][]byte,
+	scattered []*RegionInfo,
+	sortedRanges []rtree.Range,
+	needEncodeKey bool,
+) []*RegionInfo {
+	scatteredIDs := make(map[uint64]struct{}, len(scattered))
+	for _, region := range scattered {
+		scatteredIDs[region.Region.GetId()] = struct{}{}
+	}
+	var result []*RegionInfo
+	for _, region := range regions {
+		id := region.Region.GetId()
+		if _, ok := splitKeyMap[id]; ok {
+			continue
+		}
+		if _, ok := scatteredIDs[id]; ok {
+			continue
+		}
+		for _, rg := range sortedRanges {
+			start, end := rg.StartKey, rg.EndKey
+			if needEncodeKey {
+				start = codec.EncodeBytes(nil, start)
+				if len(end) > 0 {
+					end = codec.EncodeBytes(nil, end)
+				}
+			}
+			regionEnd := region.Region.GetEndKey()
+			if (len(regionEnd) == 0 || bytes.Compare(start, regionEnd) < 0) &&
+				(len(end) == 0 || bytes.Compare(region.Region.GetStartKey(), end) < 0) {
+				result = append(result, region)
+				break
+			}
+		}
+	}
+	return result
+}
+
 func (rs *RegionSplitter) hasRegion(ctx context.Context, regionID uint64) (bool, error) {
 	regionInfo, err := rs.client.GetRegionByID(ctx, regionID)
 	if err != nil {
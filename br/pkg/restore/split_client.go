@@ -23,6 +23,7 @@ import (
 	"github.com/pingcap/tidb/store/pdtypes"
 	"github.com/tikv/migration/br/pkg/conn"
 	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/failpoints"
 	"github.com/tikv/migration/br/pkg/httputil"
 	"github.com/tikv/migration/br/pkg/logutil"
 	pd "github.com/tikv/pd/client"
@@ -246,7 +247,7 @@ func splitRegionWithFailpoint(
 	keys [][]byte,
 	isRawKv bool,
 ) (*kvrpcpb.SplitRegionResponse, error) {
-	failpoint.Inject("not-leader-error", func(injectNewLeader failpoint.Value) {
+	failpoint.Inject(failpoints.NotLeaderError, func(injectNewLeader failpoint.Value) {
 		log.Debug("failpoint not-leader-error injected.")
 		resp := &kvrpcpb.SplitRegionResponse{
 			RegionError: &errorpb.Error{
@@ -260,7 +261,7 @@ func splitRegionWithFailpoint(
 		}
 		failpoint.Return(resp, nil)
 	})
-	failpoint.Inject("somewhat-retryable-error", func() {
+	failpoint.Inject(failpoints.SomewhatRetryableError, func() {
 		log.Debug("failpoint somewhat-retryable-error injected.")
 		failpoint.Return(&kvrpcpb.SplitRegionResponse{
 			RegionError: &errorpb.Error{
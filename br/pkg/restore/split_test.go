@@ -372,6 +372,37 @@ func runTestSplitAndScatterWith(t *testing.T, client *TestClient, needEncodeKey
 	}
 }
 
+// region: [, aay), [aay, bba), [bba, bbh), [bbh, cca), [cca, )
+// range: [bba, bbh), [bbh, bbz)
+// region 4 is split at bbz, and region 3 needs no split but is scattered by scatterExisting.
+func TestSplitScatterExisting(t *testing.T) {
+	ranges := []rtree.Range{
+		{StartKey: []byte("bba"), EndKey: []byte("bbh")},
+		{StartKey: []byte("bbh"), EndKey: []byte("bbz")},
+	}
+	for _, scatterExisting := range []bool{false, true} {
+		client := initTestClient()
+		scattered := map[uint64]bool{}
+		client.injectInScatter = func(regionInfo *RegionInfo) error {
+			scattered[regionInfo.Region.Id] = true
+			return nil
+		}
+		regionSplitter := NewRegionSplitter(client)
+		regionSplitter.SetScatterWaitTimeout(0)
+		if scatterExisting {
+			regionSplitter.EnableScatterExisting()
+		}
+		err := regionSplitter.Split(context.Background(), ranges, nil, true, func(key [][]byte) {})
+		require.NoError(t, err)
+		require.Equal(t, scatterExisting, scattered[3])
+		if scatterExisting {
+			require.Len(t, scattered, 2)
+		} else {
+			require.Len(t, scattered, 1)
+		}
+	}
+}
+
 // region: [, aay), [aay, bba), [bba, bbh), [bbh, cca), [cca, )
 func initTestClient() *TestClient {
 	peers := make([]*metapb.Peer, 1)
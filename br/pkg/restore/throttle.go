@@ -0,0 +1,142 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// IngestThrottle holds back the files restored into the stores, so that the restore doesn't
+// starve the foreground traffic of the cluster. It limits the bytes sent to each store per
+// second, and pauses the restore until it's resumed. A nil throttle never holds back.
+type IngestThrottle struct {
+	mu sync.Mutex
+	// rateLimit is in bytes/s per store, 0 means unlimited.
+	rateLimit uint64
+	// next is the time each store is free to receive more bytes.
+	next map[uint64]time.Time
+	// resumed is closed once the restore is resumed, nil if it isn't paused.
+	resumed chan struct{}
+
+	now func() time.Time
+}
+
+// NewIngestThrottle returns a throttle limiting each store to rateLimit bytes/s.
+func NewIngestThrottle(rateLimit uint64) *IngestThrottle {
+	return &IngestThrottle{rateLimit: rateLimit, next: make(map[uint64]time.Time), now: time.Now}
+}
+
+// SetRateLimit updates the bytes/s per store, 0 means unlimited. The bytes already reserved
+// aren't affected.
+func (t *IngestThrottle) SetRateLimit(rateLimit uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.rateLimit != rateLimit {
+		log.Info("ingest rate limit updated", zap.Uint64("old", t.rateLimit), zap.Uint64("new", rateLimit))
+	}
+	t.rateLimit = rateLimit
+}
+
+// Pause holds back the files not sent yet until Resume, the ones being restored are finished.
+func (t *IngestThrottle) Pause() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.resumed == nil {
+		log.Info("restore paused")
+		t.resumed = make(chan struct{})
+	}
+}
+
+// Resume resumes the restore paused by Pause.
+func (t *IngestThrottle) Resume() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.resumed != nil {
+		log.Info("restore resumed")
+		close(t.resumed)
+		t.resumed = nil
+	}
+}
+
+// IsPaused returns whether the restore is paused.
+func (t *IngestThrottle) IsPaused() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.resumed != nil
+}
+
+// Wait waits until the restore isn't paused and each of the stores is free to receive size
+// bytes, which are reserved for them.
+func (t *IngestThrottle) Wait(ctx context.Context, storeIDs []uint64, size uint64) error {
+	if t == nil {
+		return nil
+	}
+	for {
+		t.mu.Lock()
+		resumed := t.resumed
+		if resumed == nil {
+			break
+		}
+		t.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-resumed:
+		}
+	}
+	delay := t.reserveLocked(storeIDs, size)
+	t.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserveLocked reserves size bytes of each store, and returns how long to wait until the
+// slowest of them is free.
+func (t *IngestThrottle) reserveLocked(storeIDs []uint64, size uint64) time.Duration {
+	if t.rateLimit == 0 {
+		return 0
+	}
+	now := t.now()
+	cost := time.Duration(float64(size) / float64(t.rateLimit) * float64(time.Second))
+	var delay time.Duration
+	for _, storeID := range storeIDs {
+		next := t.next[storeID]
+		if next.Before(now) {
+			next = now
+		}
+		if d := next.Sub(now); d > delay {
+			delay = d
+		}
+		t.next[storeID] = next.Add(cost)
+	}
+	return delay
+}
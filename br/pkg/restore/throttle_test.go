@@ -0,0 +1,78 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngestThrottleRateLimit(t *testing.T) {
+	now := time.Unix(1000, 0)
+	throttle := NewIngestThrottle(100)
+	throttle.now = func() time.Time { return now }
+
+	// the first bytes of each store go at once, the later ones wait for the earlier ones.
+	require.Equal(t, time.Duration(0), throttle.reserveLocked([]uint64{1, 2}, 100))
+	require.Equal(t, time.Second, throttle.reserveLocked([]uint64{1}, 50))
+	require.Equal(t, 1500*time.Millisecond, throttle.reserveLocked([]uint64{1, 2}, 100))
+	require.Equal(t, time.Duration(0), throttle.reserveLocked([]uint64{3}, 100))
+
+	// the stores are free again once the time passes.
+	now = now.Add(10 * time.Second)
+	require.Equal(t, time.Duration(0), throttle.reserveLocked([]uint64{1, 2, 3}, 100))
+
+	throttle.SetRateLimit(0)
+	require.Equal(t, time.Duration(0), throttle.reserveLocked([]uint64{1}, 1000))
+
+	var unlimited *IngestThrottle
+	require.NoError(t, unlimited.Wait(context.Background(), []uint64{1}, 1000))
+	require.False(t, unlimited.IsPaused())
+}
+
+func TestIngestThrottlePause(t *testing.T) {
+	throttle := NewIngestThrottle(0)
+	ctx := context.Background()
+	require.NoError(t, throttle.Wait(ctx, []uint64{1}, 100))
+
+	throttle.Pause()
+	throttle.Pause()
+	require.True(t, throttle.IsPaused())
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, throttle.Wait(timeout, []uint64{1}, 100), context.DeadlineExceeded)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- throttle.Wait(ctx, []uint64{1}, 100)
+	}()
+	select {
+	case <-done:
+		require.FailNow(t, "the restore goes on while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	throttle.Resume()
+	throttle.Resume()
+	require.False(t, throttle.IsPaused())
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "the restore isn't resumed")
+	}
+}
@@ -116,6 +116,10 @@ func SplitRanges(
 	needEncodeKey bool,
 ) error {
 	splitter := NewRegionSplitter(NewSplitClient(client.GetPDClient(), client.GetTLSConfig(), isRawKv))
+	splitter.SetScatterWaitTimeout(client.scatterWaitTimeout)
+	if client.scatterExisting {
+		splitter.EnableScatterExisting()
+	}
 
 	return splitter.Split(ctx, ranges, rewriteRules, needEncodeKey, func(keys [][]byte) {
 		updateCh.Inc()
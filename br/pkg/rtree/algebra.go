@@ -0,0 +1,204 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtree
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/google/btree"
+)
+
+// The functions in this file treat ranges as key intervals [StartKey, EndKey),
+// where an empty EndKey means the end of the key space. The Files of the ranges
+// are ignored and not kept in the results.
+
+// keyBeforeEnd returns whether key < end, an empty end means +inf.
+func keyBeforeEnd(key, end []byte) bool {
+	return len(end) == 0 || bytes.Compare(key, end) < 0
+}
+
+// compareEnd compares two end keys, an empty end means +inf.
+func compareEnd(a, b []byte) int {
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+	return bytes.Compare(a, b)
+}
+
+// IsEmpty returns whether the range contains no key.
+func (rg *Range) IsEmpty() bool {
+	return !keyBeforeEnd(rg.StartKey, rg.EndKey)
+}
+
+// Normalize returns the sorted ranges covering the same keys as the input,
+// with the empty ranges removed and the overlapping or adjacent ranges merged.
+func Normalize(ranges []Range) []Range {
+	sorted := make([]Range, 0, len(ranges))
+	for _, rg := range ranges {
+		if !rg.IsEmpty() {
+			sorted = append(sorted, Range{StartKey: rg.StartKey, EndKey: rg.EndKey})
+		}
+	}
+	if len(sorted) == 0 {
+		return sorted
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].StartKey, sorted[j].StartKey) < 0
+	})
+
+	merged := sorted[:1]
+	for _, rg := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if !keyBeforeEnd(rg.StartKey, last.EndKey) && !bytes.Equal(rg.StartKey, last.EndKey) {
+			merged = append(merged, rg)
+			continue
+		}
+		if compareEnd(rg.EndKey, last.EndKey) > 0 {
+			last.EndKey = rg.EndKey
+		}
+	}
+	return merged
+}
+
+// Union returns the normalized ranges covering the keys in either a or b.
+func Union(a, b []Range) []Range {
+	all := make([]Range, 0, len(a)+len(b))
+	all = append(all, a...)
+	all = append(all, b...)
+	return Normalize(all)
+}
+
+// Subtract returns the normalized ranges covering the keys in a but not in b.
+func Subtract(a, b []Range) []Range {
+	na, nb := Normalize(a), Normalize(b)
+	result := make([]Range, 0, len(na))
+	j := 0
+	for _, rg := range na {
+		// skip the ranges of b before rg, they are before the following ranges of a too.
+		for j < len(nb) && len(nb[j].EndKey) != 0 && bytes.Compare(nb[j].EndKey, rg.StartKey) <= 0 {
+			j++
+		}
+		start, covered := rg.StartKey, false
+		for k := j; k < len(nb); k++ {
+			sub := nb[k]
+			if !keyBeforeEnd(sub.StartKey, rg.EndKey) {
+				break
+			}
+			if bytes.Compare(sub.StartKey, start) > 0 {
+				result = append(result, Range{StartKey: start, EndKey: sub.StartKey})
+			}
+			if compareEnd(sub.EndKey, rg.EndKey) >= 0 {
+				covered = true
+				break
+			}
+			start = sub.EndKey
+		}
+		if !covered {
+			result = append(result, Range{StartKey: start, EndKey: rg.EndKey})
+		}
+	}
+	return result
+}
+
+// Intersection returns the normalized ranges covering the keys in both a and b.
+func Intersection(a, b []Range) []Range {
+	return Subtract(a, Subtract(a, b))
+}
+
+// Gaps returns the parts of [startKey, endKey) not covered by the ranges.
+func Gaps(ranges []Range, startKey, endKey []byte) []Range {
+	return Subtract([]Range{{StartKey: startKey, EndKey: endKey}}, ranges)
+}
+
+// keyPositionBytes is the number of bytes used to estimate the position of a key.
+const keyPositionBytes = 8
+
+// keyPosition estimates the position of the key in the key space after the prefix as a number in [0, 1].
+func keyPosition(key []byte, prefixLen int) float64 {
+	pos, unit := 0.0, 1.0
+	for i := prefixLen; i < len(key) && i < prefixLen+keyPositionBytes; i++ {
+		unit /= 256
+		pos += float64(key[i]) * unit
+	}
+	return pos
+}
+
+// Coverage returns the estimated ratio of [startKey, endKey) covered by the ranges.
+// Keys are regarded as big-endian fractions after the common prefix of startKey and endKey,
+// so the result is an approximation of the portion of the key space, not of the data.
+func Coverage(ranges []Range, startKey, endKey []byte) float64 {
+	whole := []Range{{StartKey: startKey, EndKey: endKey}}
+	if whole[0].IsEmpty() {
+		return 1
+	}
+	gaps := Subtract(whole, ranges)
+	if len(gaps) == 0 {
+		return 1
+	}
+
+	prefixLen := 0
+	if len(endKey) != 0 {
+		for prefixLen < len(startKey) && prefixLen < len(endKey) && startKey[prefixLen] == endKey[prefixLen] {
+			prefixLen++
+		}
+	}
+	span := func(rg Range) float64 {
+		end := 1.0
+		if len(rg.EndKey) != 0 {
+			end = keyPosition(rg.EndKey, prefixLen)
+		}
+		return end - keyPosition(rg.StartKey, prefixLen)
+	}
+	total := span(whole[0])
+	if total <= 0 {
+		// the range is too narrow to be estimated, and it's not fully covered.
+		return 0
+	}
+	uncovered := 0.0
+	for _, gap := range gaps {
+		uncovered += span(gap)
+	}
+	ratio := 1 - uncovered/total
+	if ratio < 0 {
+		return 0
+	}
+	return ratio
+}
+
+// IterateOverlapping calls fn on the ranges in the tree overlapping [startKey, endKey)
+// in ascending order until fn returns false. Unlike GetSortedRanges, the ranges are
+// not collected, so it's suitable for walking a huge tree.
+func (rangeTree *RangeTree) IterateOverlapping(startKey, endKey []byte, fn func(*Range) bool) {
+	if !keyBeforeEnd(startKey, endKey) {
+		return
+	}
+	pivot := &Range{StartKey: startKey}
+	if first := rangeTree.Find(pivot); first != nil {
+		pivot.StartKey = first.StartKey
+	}
+	rangeTree.AscendGreaterOrEqual(pivot, func(i btree.Item) bool {
+		rg := i.(*Range)
+		if !keyBeforeEnd(rg.StartKey, endKey) {
+			return false
+		}
+		return fn(rg)
+	})
+}
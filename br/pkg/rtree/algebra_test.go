@@ -0,0 +1,166 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rtree_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/rtree"
+)
+
+const alphabet = 6
+
+// probeKeys are all the keys up to 2 bytes over the alphabet, it's used as
+// the model of the key space to check the interval algebra.
+func probeKeys() [][]byte {
+	keys := [][]byte{{}}
+	for a := byte(0); a < alphabet; a++ {
+		keys = append(keys, []byte{a})
+		for b := byte(0); b < alphabet; b++ {
+			keys = append(keys, []byte{a, b})
+		}
+	}
+	return keys
+}
+
+func randKey(r *rand.Rand) []byte {
+	key := make([]byte, r.Intn(3))
+	for i := range key {
+		key[i] = byte(r.Intn(alphabet))
+	}
+	return key
+}
+
+func randRanges(r *rand.Rand) []rtree.Range {
+	ranges := make([]rtree.Range, r.Intn(5))
+	for i := range ranges {
+		start, end := randKey(r), randKey(r)
+		if len(end) != 0 && bytes.Compare(start, end) > 0 && r.Intn(4) != 0 {
+			start, end = end, start
+		}
+		ranges[i] = rtree.Range{StartKey: start, EndKey: end}
+	}
+	return ranges
+}
+
+func covers(ranges []rtree.Range, key []byte) bool {
+	for i := range ranges {
+		if !ranges[i].IsEmpty() && ranges[i].Contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func requireNormalized(t *testing.T, ranges []rtree.Range) {
+	for i := range ranges {
+		require.False(t, ranges[i].IsEmpty(), "%v", ranges)
+		if i > 0 {
+			prevEnd := ranges[i-1].EndKey
+			require.NotEmpty(t, prevEnd, "%v", ranges)
+			require.Less(t, bytes.Compare(prevEnd, ranges[i].StartKey), 0, "%v", ranges)
+		}
+	}
+}
+
+func TestIntervalAlgebraProperties(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	keys := probeKeys()
+	for i := 0; i < 2000; i++ {
+		a, b := randRanges(r), randRanges(r)
+		start, end := randKey(r), randKey(r)
+		union := rtree.Union(a, b)
+		subtract := rtree.Subtract(a, b)
+		intersection := rtree.Intersection(a, b)
+		gaps := rtree.Gaps(a, start, end)
+		whole := []rtree.Range{{StartKey: start, EndKey: end}}
+
+		requireNormalized(t, rtree.Normalize(a))
+		requireNormalized(t, union)
+		requireNormalized(t, subtract)
+		requireNormalized(t, intersection)
+		requireNormalized(t, gaps)
+		for _, key := range keys {
+			inA, inB := covers(a, key), covers(b, key)
+			require.Equal(t, inA, covers(rtree.Normalize(a), key), "normalize %v key %v", a, key)
+			require.Equal(t, inA || inB, covers(union, key), "union %v %v key %v", a, b, key)
+			require.Equal(t, inA && !inB, covers(subtract, key), "subtract %v %v key %v", a, b, key)
+			require.Equal(t, inA && inB, covers(intersection, key), "intersection %v %v key %v", a, b, key)
+			require.Equal(t, covers(whole, key) && !inA, covers(gaps, key), "gaps %v [%v, %v) key %v", a, start, end, key)
+		}
+
+		coverage := rtree.Coverage(a, start, end)
+		require.GreaterOrEqual(t, coverage, 0.0)
+		require.LessOrEqual(t, coverage, 1.0)
+		if len(gaps) == 0 {
+			require.Equal(t, 1.0, coverage)
+		}
+		require.Equal(t, 1.0, rtree.Coverage(rtree.Union(a, whole), start, end))
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	ranges := []rtree.Range{
+		{StartKey: []byte("t\x00"), EndKey: []byte("t\x40")},
+		{StartKey: []byte("t\x80"), EndKey: []byte("t\xc0")},
+	}
+	require.InDelta(t, 0.5, rtree.Coverage(ranges, []byte("t\x00"), []byte("t\xff\xff\xff\xff\xff\xff\xff\xff")), 0.01)
+	require.InDelta(t, 0.5, rtree.Coverage(ranges, []byte("t\x80"), []byte("u")), 0.01)
+	require.Less(t, rtree.Coverage(ranges, []byte("t\x80"), []byte("")), 0.01)
+	require.Equal(t, 0.0, rtree.Coverage(nil, []byte("a"), []byte("b")))
+	require.Equal(t, 1.0, rtree.Coverage(nil, []byte("b"), []byte("a")))
+
+	require.Equal(t, []rtree.Range{
+		{StartKey: []byte(""), EndKey: []byte("t\x00")},
+		{StartKey: []byte("t\x40"), EndKey: []byte("t\x80")},
+		{StartKey: []byte("t\xc0"), EndKey: []byte("")},
+	}, rtree.Gaps(ranges, []byte(""), []byte("")))
+}
+
+func TestIterateOverlapping(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	for i := 0; i < 500; i++ {
+		rangeTree := rtree.NewRangeTree()
+		for _, rg := range rtree.Normalize(randRanges(r)) {
+			rangeTree.Update(rg)
+		}
+		start, end := randKey(r), randKey(r)
+		expected := make([]rtree.Range, 0)
+		for _, rg := range rangeTree.GetSortedRanges() {
+			if _, _, ok := rg.Intersect(start, end); ok && (len(end) == 0 || bytes.Compare(start, end) < 0) {
+				expected = append(expected, rg)
+			}
+		}
+		iterated := make([]rtree.Range, 0)
+		rangeTree.IterateOverlapping(start, end, func(rg *rtree.Range) bool {
+			iterated = append(iterated, *rg)
+			return true
+		})
+		require.Equal(t, expected, iterated, "[%v, %v)", start, end)
+	}
+
+	rangeTree := rtree.NewRangeTree()
+	rangeTree.Put([]byte("a"), []byte("b"), nil)
+	rangeTree.Put([]byte("c"), []byte("d"), nil)
+	count := 0
+	rangeTree.IterateOverlapping([]byte(""), []byte(""), func(*rtree.Range) bool {
+		count++
+		return false
+	})
+	require.Equal(t, 1, count)
+}
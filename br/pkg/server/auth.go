@@ -0,0 +1,125 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// Scope is a permission granted to an API token.
+type Scope string
+
+const (
+	// ScopeBackup allows submitting backup jobs.
+	ScopeBackup Scope = "backup"
+	// ScopeRestore allows submitting restore jobs.
+	ScopeRestore Scope = "restore"
+	// ScopeDelete allows canceling jobs.
+	ScopeDelete Scope = "delete"
+	// ScopeAdmin allows accessing the jobs of all tokens.
+	ScopeAdmin Scope = "admin"
+)
+
+// Token is an API token. A token can only see and cancel the jobs submitted by
+// itself, unless it has the admin scope.
+type Token struct {
+	// Name identifies the owner of the token, e.g. the team, it's recorded as the owner of the jobs.
+	Name   string  `toml:"name" json:"name"`
+	Token  string  `toml:"token" json:"token"`
+	Scopes []Scope `toml:"scopes" json:"scopes"`
+}
+
+// HasScope returns whether the token is granted the scope.
+func (t *Token) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeOfKind returns the scope required to submit the kind of job.
+func scopeOfKind(kind JobKind) Scope {
+	if kind == JobKindRestoreRaw {
+		return ScopeRestore
+	}
+	return ScopeBackup
+}
+
+// Authorizer authenticates the requests by the static API tokens.
+type Authorizer struct {
+	tokens []Token
+}
+
+// NewAuthorizer creates an authorizer with the tokens.
+func NewAuthorizer(tokens []Token) (*Authorizer, error) {
+	names := make(map[string]struct{}, len(tokens))
+	for _, t := range tokens {
+		if len(t.Name) == 0 || len(t.Token) == 0 {
+			return nil, errors.Annotate(berrors.ErrInvalidArgument, "the name and the token of an API token cannot be empty")
+		}
+		if _, ok := names[t.Name]; ok {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "duplicated API token name '%s'", t.Name)
+		}
+		names[t.Name] = struct{}{}
+		for _, s := range t.Scopes {
+			switch s {
+			case ScopeBackup, ScopeRestore, ScopeDelete, ScopeAdmin:
+			default:
+				return nil, errors.Annotatef(berrors.ErrInvalidArgument, "unknown scope '%s' of API token '%s'", s, t.Name)
+			}
+		}
+	}
+	return &Authorizer{tokens: tokens}, nil
+}
+
+// LoadAuthorizer reads the tokens from a TOML file like:
+//
+//	[[tokens]]
+//	name = "team-a"
+//	token = "secret"
+//	scopes = ["backup", "restore"]
+func LoadAuthorizer(path string) (*Authorizer, error) {
+	file := struct {
+		Tokens []Token `toml:"tokens"`
+	}{}
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to load token file %s: %v", path, err)
+	}
+	return NewAuthorizer(file.Tokens)
+}
+
+// Authenticate returns the token of the request, which is given by the header `Authorization: Bearer <token>`.
+func (a *Authorizer) Authenticate(req *http.Request) (*Token, error) {
+	const prefix = "Bearer "
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, prefix) {
+		return nil, errors.Annotate(berrors.ErrServerUnauthorized, "missing bearer token")
+	}
+	given := []byte(strings.TrimPrefix(header, prefix))
+	for i := range a.tokens {
+		if subtle.ConstantTimeCompare(given, []byte(a.tokens[i].Token)) == 1 {
+			return &a.tokens[i], nil
+		}
+	}
+	return nil, errors.Annotate(berrors.ErrServerUnauthorized, "invalid token")
+}
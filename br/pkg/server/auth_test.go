@@ -0,0 +1,101 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadAuthorizer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.toml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+[[tokens]]
+name = "team-a"
+token = "token-a"
+scopes = ["backup"]
+
+[[tokens]]
+name = "ops"
+token = "token-ops"
+scopes = ["admin"]
+`), 0o600))
+	a, err := LoadAuthorizer(path)
+	require.NoError(t, err)
+	require.Len(t, a.tokens, 2)
+	require.True(t, a.tokens[1].HasScope(ScopeDelete))
+
+	_, err = NewAuthorizer([]Token{{Name: "a", Token: "x", Scopes: []Scope{"drop"}}})
+	require.Error(t, err)
+	_, err = NewAuthorizer([]Token{{Name: "a", Token: "x"}, {Name: "a", Token: "y"}})
+	require.Error(t, err)
+}
+
+func TestHandlerWithAuth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := newBlockingExecutor()
+	r := NewRunner(e.exec, 1, 4)
+	r.Start(ctx)
+	auth, err := NewAuthorizer([]Token{
+		{Name: "team-a", Token: "token-a", Scopes: []Scope{ScopeBackup}},
+		{Name: "team-b", Token: "token-b", Scopes: []Scope{ScopeBackup, ScopeRestore, ScopeDelete}},
+		{Name: "ops", Token: "token-ops", Scopes: []Scope{ScopeAdmin}},
+	})
+	require.NoError(t, err)
+	h := NewHandler(r, auth)
+
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusUnauthorized, do("", http.MethodGet, "/api/v1/jobs", "").Code)
+	require.Equal(t, http.StatusUnauthorized, do("wrong", http.MethodGet, "/api/v1/jobs", "").Code)
+	require.Equal(t, http.StatusForbidden,
+		do("token-a", http.MethodPost, "/api/v1/jobs", `{"kind": "restore-raw"}`).Code)
+
+	rec := do("token-a", http.MethodPost, "/api/v1/jobs", `{"kind": "backup-raw"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var job Job
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	require.Equal(t, "team-a", job.Owner)
+	<-e.started
+
+	// the job is invisible to the other teams.
+	require.Equal(t, http.StatusNotFound, do("token-b", http.MethodGet, "/api/v1/jobs/"+job.ID, "").Code)
+	require.Equal(t, http.StatusNotFound, do("token-b", http.MethodDelete, "/api/v1/jobs/"+job.ID, "").Code)
+	var jobs []Job
+	require.NoError(t, json.Unmarshal(do("token-b", http.MethodGet, "/api/v1/jobs", "").Body.Bytes(), &jobs))
+	require.Empty(t, jobs)
+
+	// team-a has no delete scope.
+	require.Equal(t, http.StatusForbidden, do("token-a", http.MethodDelete, "/api/v1/jobs/"+job.ID, "").Code)
+	require.Equal(t, http.StatusOK, do("token-ops", http.MethodGet, "/api/v1/jobs/"+job.ID, "").Code)
+	require.Equal(t, http.StatusAccepted, do("token-ops", http.MethodDelete, "/api/v1/jobs/"+job.ID, "").Code)
+}
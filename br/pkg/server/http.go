@@ -0,0 +1,202 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// APIPrefix is the path prefix of the job APIs.
+const APIPrefix = "/api/v1"
+
+// SubmitRequest is the body of the job submission.
+type SubmitRequest struct {
+	Kind JobKind  `json:"kind"`
+	Args []string `json:"args"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewHandler returns the HTTP handler serving the job APIs of the runner.
+// If auth is not nil, every request must carry an API token, see Authorizer.
+//
+//	POST   /api/v1/jobs             submit a job
+//	GET    /api/v1/jobs             list the jobs
+//	GET    /api/v1/jobs/{id}        get a job
+//	POST   /api/v1/jobs/{id}/cancel cancel a job
+//	DELETE /api/v1/jobs/{id}        cancel a job
+func NewHandler(r *Runner, auth *Authorizer) http.Handler {
+	h := &handler{runner: r, auth: auth}
+	router := mux.NewRouter()
+	api := router.PathPrefix(APIPrefix).Subrouter()
+	api.HandleFunc("/jobs", h.submit).Methods(http.MethodPost)
+	api.HandleFunc("/jobs", h.list).Methods(http.MethodGet)
+	api.HandleFunc("/jobs/{id}", h.get).Methods(http.MethodGet)
+	api.HandleFunc("/jobs/{id}", h.cancel).Methods(http.MethodDelete)
+	api.HandleFunc("/jobs/{id}/cancel", h.cancel).Methods(http.MethodPost)
+	return router
+}
+
+type handler struct {
+	runner *Runner
+	auth   *Authorizer
+}
+
+// authenticate returns the token of the request, nil if the server has no authorization.
+func (h *handler) authenticate(req *http.Request) (*Token, error) {
+	if h.auth == nil {
+		return nil, nil
+	}
+	return h.auth.Authenticate(req)
+}
+
+// canAccess returns whether the token can see the job.
+func canAccess(token *Token, job *Job) bool {
+	return token == nil || token.HasScope(ScopeAdmin) || token.Name == job.Owner
+}
+
+// getJob returns the job if the token can access it. The jobs of others are
+// reported as not found, so that their existence is not leaked.
+func (h *handler) getJob(token *Token, id string) (Job, error) {
+	job, err := h.runner.Get(id)
+	if err != nil {
+		return Job{}, errors.Trace(err)
+	}
+	if !canAccess(token, &job) {
+		return Job{}, errors.Annotatef(berrors.ErrServerJobNotFound, "job %s", id)
+	}
+	return job, nil
+}
+
+func (h *handler) submit(w http.ResponseWriter, req *http.Request) {
+	token, err := h.authenticate(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var body SubmitRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	owner := ""
+	if token != nil {
+		if !token.HasScope(scopeOfKind(body.Kind)) {
+			writeError(w, errors.Annotatef(berrors.ErrServerForbidden,
+				"token '%s' cannot submit %s jobs", token.Name, body.Kind))
+			return
+		}
+		owner = token.Name
+	}
+	job, err := h.runner.Submit(body.Kind, body.Args, owner)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, job)
+}
+
+func (h *handler) list(w http.ResponseWriter, req *http.Request) {
+	token, err := h.authenticate(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	jobs := h.runner.List()
+	visible := jobs[:0]
+	for i := range jobs {
+		if canAccess(token, &jobs[i]) {
+			visible = append(visible, jobs[i])
+		}
+	}
+	writeJSON(w, http.StatusOK, visible)
+}
+
+func (h *handler) get(w http.ResponseWriter, req *http.Request) {
+	token, err := h.authenticate(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	job, err := h.getJob(token, mux.Vars(req)["id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (h *handler) cancel(w http.ResponseWriter, req *http.Request) {
+	token, err := h.authenticate(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	id := mux.Vars(req)["id"]
+	if _, err := h.getJob(token, id); err != nil {
+		writeError(w, err)
+		return
+	}
+	if token != nil && !token.HasScope(ScopeDelete) {
+		writeError(w, errors.Annotatef(berrors.ErrServerForbidden, "token '%s' cannot cancel jobs", token.Name))
+		return
+	}
+	if err := h.runner.Cancel(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	job, err := h.runner.Get(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case berrors.Is(err, berrors.ErrServerJobNotFound):
+		status = http.StatusNotFound
+	case berrors.Is(err, berrors.ErrServerQueueFull):
+		status = http.StatusServiceUnavailable
+	case berrors.Is(err, berrors.ErrServerJobFinished):
+		status = http.StatusConflict
+	case berrors.Is(err, berrors.ErrServerInvalidJob):
+		status = http.StatusBadRequest
+	case berrors.Is(err, berrors.ErrServerUnauthorized):
+		status = http.StatusUnauthorized
+	case berrors.Is(err, berrors.ErrServerForbidden):
+		status = http.StatusForbidden
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn("failed to write response", zap.Error(err))
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := newBlockingExecutor()
+	r := NewRunner(e.exec, 1, 4)
+	r.Start(ctx)
+	h := NewHandler(r, nil)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/v1/jobs", `{"kind": "backup-raw", "args": ["--pd", "127.0.0.1:2379"]}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var job Job
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	require.Equal(t, JobKindBackupRaw, job.Kind)
+	<-e.started
+
+	rec = do(http.MethodPost, "/api/v1/jobs", `{"kind": "unknown"}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = do(http.MethodPost, "/api/v1/jobs", `not json`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(http.MethodGet, "/api/v1/jobs/"+job.ID, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	require.Equal(t, JobRunning, job.State)
+
+	rec = do(http.MethodGet, "/api/v1/jobs/not-exist", "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = do(http.MethodPost, "/api/v1/jobs/"+job.ID+"/cancel", "")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	job, err := r.Wait(ctx, job.ID)
+	require.NoError(t, err)
+	require.Equal(t, JobCanceled, job.State)
+	rec = do(http.MethodDelete, "/api/v1/jobs/"+job.ID, "")
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = do(http.MethodGet, "/api/v1/jobs", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var jobs []Job
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &jobs))
+	require.Len(t, jobs, 1)
+}
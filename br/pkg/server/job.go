@@ -0,0 +1,195 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/gluetikv"
+)
+
+// JobKind is the kind of the task a job executes.
+type JobKind string
+
+const (
+	// JobKindBackupRaw runs `br backup raw`.
+	JobKindBackupRaw JobKind = "backup-raw"
+	// JobKindRestoreRaw runs `br restore raw`.
+	JobKindRestoreRaw JobKind = "restore-raw"
+)
+
+// Valid returns whether the kind is supported.
+func (k JobKind) Valid() bool {
+	return k == JobKindBackupRaw || k == JobKindRestoreRaw
+}
+
+// JobState is the state of a job.
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCanceled  JobState = "canceled"
+)
+
+// Finished returns whether the job will not run anymore.
+func (s JobState) Finished() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCanceled
+}
+
+// JobProgress is the progress of the current step of a job.
+type JobProgress struct {
+	Step    string `json:"step"`
+	Total   int64  `json:"total"`
+	Current int64  `json:"current"`
+}
+
+// Job is a backup or restore task submitted to the server.
+type Job struct {
+	ID   string  `json:"id"`
+	Kind JobKind `json:"kind"`
+	// Owner is the name of the API token submitting the job, empty if the server has no authorization.
+	Owner string `json:"owner,omitempty"`
+	// Args are the command line arguments of the task, the same as the br command,
+	// e.g. ["--pd", "127.0.0.1:2379", "--storage", "s3://bucket/prefix"].
+	Args     []string          `json:"args"`
+	State    JobState          `json:"state"`
+	Error    string            `json:"error,omitempty"`
+	Progress JobProgress       `json:"progress"`
+	Records  map[string]uint64 `json:"records,omitempty"`
+	// Restarts is the number of times the job is requeued because the server stopped while it's running.
+	Restarts   int       `json:"restarts,omitempty"`
+	CreatedAt  time.Time `json:"created-at"`
+	StartedAt  time.Time `json:"started-at"`
+	FinishedAt time.Time `json:"finished-at"`
+	// Resume is set when a backup job is requeued after it's interrupted, so that it resumes from
+	// the checkpoint in its --storage instead of backing up from scratch.
+	Resume bool `json:"resume,omitempty"`
+}
+
+// CommandArgs returns the arguments the job runs with, which resume the interrupted backup.
+func (j *Job) CommandArgs() []string {
+	if !j.Resume || j.Kind != JobKindBackupRaw {
+		return j.Args
+	}
+	args := make([]string, 0, len(j.Args)+1)
+	return append(append(args, j.Args...), "--resume")
+}
+
+// sensitiveFlags are the flag name fragments whose values are hidden in the API responses.
+var sensitiveFlags = []string{"key", "secret", "token", "password", "credential"}
+
+// redactArgs hides the credentials in the arguments, including the query and the user info
+// of the storage URI, whether it's given as a separate argument or by --flag=value.
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	hideNext := false
+	for i, arg := range args {
+		if hideNext {
+			redacted[i] = "?"
+			hideNext = false
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			redacted[i] = redactURL(arg)
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		value, hasValue := "", false
+		if idx := strings.Index(name, "="); idx >= 0 {
+			name, value, hasValue = name[:idx], name[idx+1:], true
+		}
+		prefix := arg[:len(arg)-len(value)]
+		redacted[i] = prefix + redactURL(value)
+		for _, fragment := range sensitiveFlags {
+			if strings.Contains(strings.ToLower(name), fragment) {
+				if hasValue {
+					redacted[i] = prefix + "?"
+				} else {
+					hideNext = true
+				}
+				break
+			}
+		}
+	}
+	return redacted
+}
+
+// redactURL removes the query and the user info of the URL, which may contain credentials.
+// The values which aren't URLs are returned as is.
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || (len(u.RawQuery) == 0 && u.User == nil) {
+		return value
+	}
+	u.RawQuery, u.User = "", nil
+	return u.String()
+}
+
+// jobGlue is the glue of a job, which records the progress and the records into the job.
+type jobGlue struct {
+	gluetikv.Glue
+
+	mu      *sync.Mutex
+	job     *Job
+	persist func(*Job)
+}
+
+// StartProgress implements glue.Glue.
+func (g jobGlue) StartProgress(_ context.Context, cmdName string, total int64, _ bool) glue.Progress {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.job.Progress = JobProgress{Step: cmdName, Total: total}
+	g.persist(g.job)
+	return &jobProgress{mu: g.mu, progress: &g.job.Progress}
+}
+
+// Record implements glue.Glue.
+func (g jobGlue) Record(name string, val uint64) {
+	g.Glue.Record(name, val)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.job.Records == nil {
+		g.job.Records = make(map[string]uint64)
+	}
+	g.job.Records[name] = val
+	g.persist(g.job)
+}
+
+type jobProgress struct {
+	mu       *sync.Mutex
+	progress *JobProgress
+}
+
+// Inc implements glue.Progress.
+func (p *jobProgress) Inc() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.progress.Current++
+}
+
+// Close implements glue.Progress.
+func (p *jobProgress) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.progress.Current = p.progress.Total
+}
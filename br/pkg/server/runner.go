@@ -0,0 +1,309 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/glue"
+	"go.uber.org/zap"
+)
+
+// Executor runs the task of the job, the progress and the records of the task
+// should be reported by the glue.
+type Executor func(ctx context.Context, job *Job, g glue.Glue) error
+
+// Runner queues the submitted jobs and runs them by the executor.
+type Runner struct {
+	exec        Executor
+	concurrency int
+	queue       chan string
+	// store persists the jobs, nil if the jobs are only kept in memory.
+	store Store
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	order   []string
+	cancels map[string]context.CancelFunc
+	done    map[string]chan struct{}
+	// handedOver is set when the jobs are handed over to the new process of an upgrade.
+	handedOver bool
+	running    sync.WaitGroup
+}
+
+// NewRunner creates a runner which runs at most `concurrency` jobs at the same time,
+// and keeps at most `queueSize` jobs waiting. The executor must be safe to run the
+// jobs concurrently if `concurrency` is greater than 1.
+func NewRunner(exec Executor, concurrency, queueSize int) *Runner {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &Runner{
+		exec:        exec,
+		concurrency: concurrency,
+		queue:       make(chan string, queueSize),
+		jobs:        make(map[string]*Job),
+		cancels:     make(map[string]context.CancelFunc),
+		done:        make(map[string]chan struct{}),
+	}
+}
+
+// NewRunnerWithStore creates a runner persisting the jobs into the store. The jobs
+// in the store are recovered: the finished jobs are kept for query, and the jobs
+// queued or running when the server stopped are queued again. The interrupted backup
+// resumes from its checkpoint, while the other requeued jobs run from the beginning.
+func NewRunnerWithStore(exec Executor, concurrency, queueSize int, store Store) (*Runner, error) {
+	jobs, err := store.LoadAll()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	pending := 0
+	for _, job := range jobs {
+		if !job.State.Finished() {
+			pending++
+		}
+	}
+	// make sure the recovered jobs fit in the queue.
+	if pending > queueSize {
+		queueSize = pending
+	}
+	r := NewRunner(exec, concurrency, queueSize)
+	r.store = store
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, job := range jobs {
+		r.jobs[job.ID] = job
+		r.order = append(r.order, job.ID)
+		r.done[job.ID] = make(chan struct{})
+		if job.State.Finished() {
+			close(r.done[job.ID])
+			continue
+		}
+		if job.State == JobRunning {
+			job.Restarts++
+			job.Resume = job.Kind == JobKindBackupRaw
+		}
+		job.State = JobQueued
+		job.Progress = JobProgress{}
+		r.queue <- job.ID
+		r.persist(job)
+		log.Info("job recovered", zap.String("id", job.ID), zap.String("kind", string(job.Kind)),
+			zap.Int("restarts", job.Restarts))
+	}
+	return r, nil
+}
+
+// persist saves the job into the store, it must be called with the lock held.
+// The failure is only logged, because the job itself can go on.
+func (r *Runner) persist(job *Job) {
+	if r.store == nil {
+		return
+	}
+	if err := r.store.Save(job); err != nil {
+		log.Warn("failed to persist job", zap.String("id", job.ID), zap.Error(err))
+	}
+}
+
+// Start starts the workers of the runner, which exit when the ctx is done.
+func (r *Runner) Start(ctx context.Context) {
+	for i := 0; i < r.concurrency; i++ {
+		go r.work(ctx)
+	}
+}
+
+func (r *Runner) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-r.queue:
+			r.run(ctx, id)
+		}
+	}
+}
+
+func (r *Runner) run(ctx context.Context, id string) {
+	r.mu.Lock()
+	job := r.jobs[id]
+	if job.State != JobQueued || r.handedOver {
+		// canceled before it starts, or left in the store for the new process.
+		r.mu.Unlock()
+		return
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r.cancels[id] = cancel
+	r.running.Add(1)
+	defer r.running.Done()
+	job.State = JobRunning
+	job.StartedAt = time.Now()
+	r.persist(job)
+	r.mu.Unlock()
+
+	log.Info("job started", zap.String("id", id), zap.String("kind", string(job.Kind)))
+	err := r.exec(jobCtx, job, jobGlue{mu: &r.mu, job: job, persist: r.persist})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cancels, id)
+	if r.handedOver {
+		// the job is kept running in the store, so that the new process runs it again.
+		log.Info("job handed over", zap.String("id", id), zap.Error(err))
+		return
+	}
+	job.FinishedAt = time.Now()
+	switch {
+	case err == nil:
+		job.State = JobSucceeded
+	case jobCtx.Err() != nil && errors.Cause(err) == context.Canceled:
+		job.State = JobCanceled
+		job.Error = err.Error()
+	default:
+		job.State = JobFailed
+		job.Error = err.Error()
+	}
+	r.persist(job)
+	log.Info("job finished", zap.String("id", id), zap.String("state", string(job.State)), zap.Error(err))
+	close(r.done[id])
+}
+
+// Submit queues a job owned by the owner, and returns a snapshot of it.
+func (r *Runner) Submit(kind JobKind, args []string, owner string) (Job, error) {
+	if !kind.Valid() {
+		return Job{}, errors.Annotatef(berrors.ErrServerInvalidJob, "unsupported job kind '%s'", kind)
+	}
+	job := &Job{
+		ID:        uuid.New().String(),
+		Kind:      kind,
+		Owner:     owner,
+		Args:      append([]string{}, args...),
+		State:     JobQueued,
+		CreatedAt: time.Now(),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case r.queue <- job.ID:
+	default:
+		return Job{}, errors.Annotatef(berrors.ErrServerQueueFull, "%d jobs are waiting", cap(r.queue))
+	}
+	r.jobs[job.ID] = job
+	r.order = append(r.order, job.ID)
+	r.done[job.ID] = make(chan struct{})
+	r.persist(job)
+	return snapshot(job), nil
+}
+
+// snapshot copies the job with the credentials in the arguments hidden.
+func snapshot(job *Job) Job {
+	s := *job
+	s.Args = redactArgs(job.Args)
+	if job.Records != nil {
+		s.Records = make(map[string]uint64, len(job.Records))
+		for k, v := range job.Records {
+			s.Records[k] = v
+		}
+	}
+	return s
+}
+
+// Get returns a snapshot of the job.
+func (r *Runner) Get(id string) (Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, errors.Annotatef(berrors.ErrServerJobNotFound, "job %s", id)
+	}
+	return snapshot(job), nil
+}
+
+// List returns the snapshots of all jobs in the order of submission.
+func (r *Runner) List() []Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobs := make([]Job, 0, len(r.order))
+	for _, id := range r.order {
+		jobs = append(jobs, snapshot(r.jobs[id]))
+	}
+	return jobs
+}
+
+// Cancel cancels the job. A queued job is canceled at once, while a running job
+// is canceled by its context and finishes asynchronously.
+func (r *Runner) Cancel(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return errors.Annotatef(berrors.ErrServerJobNotFound, "job %s", id)
+	}
+	switch job.State {
+	case JobQueued:
+		job.State = JobCanceled
+		job.FinishedAt = time.Now()
+		r.persist(job)
+		close(r.done[id])
+	case JobRunning:
+		r.cancels[id]()
+	default:
+		return errors.Annotatef(berrors.ErrServerJobFinished, "job %s is %s", id, job.State)
+	}
+	log.Info("job canceled", zap.String("id", id))
+	return nil
+}
+
+// Handover stops the runner for the new process of an upgrade, which recovers the jobs
+// from the same store: the running jobs are interrupted, and they are left in the store
+// as running together with the queued ones, so the new process queues them again.
+// It returns after the interrupted jobs exit.
+func (r *Runner) Handover() error {
+	r.mu.Lock()
+	if r.store == nil {
+		r.mu.Unlock()
+		return errors.Annotate(berrors.ErrInvalidArgument, "the jobs can't be handed over without a store")
+	}
+	r.handedOver = true
+	for _, cancel := range r.cancels {
+		cancel()
+	}
+	r.mu.Unlock()
+	r.running.Wait()
+	return nil
+}
+
+// Wait waits until the job finishes or the ctx is done, and returns a snapshot of it.
+func (r *Runner) Wait(ctx context.Context, id string) (Job, error) {
+	r.mu.Lock()
+	done, ok := r.done[id]
+	r.mu.Unlock()
+	if !ok {
+		return Job{}, errors.Annotatef(berrors.ErrServerJobNotFound, "job %s", id)
+	}
+	select {
+	case <-ctx.Done():
+		return Job{}, errors.Trace(ctx.Err())
+	case <-done:
+	}
+	return r.Get(id)
+}
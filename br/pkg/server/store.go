@@ -0,0 +1,95 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// Store persists the jobs, so that they can be recovered after the server restarts.
+type Store interface {
+	// Save writes the job, replacing the old one with the same ID.
+	Save(job *Job) error
+	// LoadAll reads all jobs in the order of submission.
+	LoadAll() ([]*Job, error)
+}
+
+const (
+	jobFileSuffix = ".json"
+	// the job files contain the arguments of the jobs, which may include credentials.
+	jobDirPerm  os.FileMode = 0o700
+	jobFilePerm os.FileMode = 0o600
+)
+
+// FileStore stores each job as a JSON file under a local directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a store under the directory, the directory is created if not exists.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, jobDirPerm); err != nil {
+		return nil, errors.Annotatef(err, "failed to create job directory %s", dir)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Save implements Store. The file is replaced atomically, so a crash never leaves a partial job.
+func (s *FileStore) Save(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	path := filepath.Join(s.dir, job.ID+jobFileSuffix)
+	tmpPath := path + ".tmp"
+	if err = os.WriteFile(tmpPath, data, jobFilePerm); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmpPath, path))
+}
+
+// LoadAll implements Store.
+func (s *FileStore) LoadAll() ([]*Job, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	jobs := make([]*Job, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), jobFileSuffix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		job := &Job{}
+		if err = json.Unmarshal(data, job); err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse job file %s: %v", entry.Name(), err)
+		}
+		jobs = append(jobs, job)
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverJobs(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := newBlockingExecutor()
+	r, err := NewRunnerWithStore(e.exec, 1, 1, store)
+	require.NoError(t, err)
+	r.Start(ctx)
+
+	finished, err := r.Submit(JobKindBackupRaw, []string{"--storage", "local:///tmp/a"}, "")
+	require.NoError(t, err)
+	<-e.started
+	e.release <- nil
+	_, err = r.Wait(ctx, finished.ID)
+	require.NoError(t, err)
+
+	running, err := r.Submit(JobKindBackupRaw, []string{"--storage", "local:///tmp/b"}, "")
+	require.NoError(t, err)
+	<-e.started
+	queued, err := r.Submit(JobKindRestoreRaw, []string{"--storage", "local:///tmp/a"}, "")
+	require.NoError(t, err)
+
+	// simulate a crash: the running job never finishes in the old runner.
+	jobs, err := store.LoadAll()
+	require.NoError(t, err)
+	require.Len(t, jobs, 3)
+	require.Equal(t, JobRunning, jobs[1].State)
+	require.Equal(t, int64(2), jobs[1].Progress.Total)
+
+	store, err = NewFileStore(dir)
+	require.NoError(t, err)
+	e2 := newBlockingExecutor()
+	r2, err := NewRunnerWithStore(e2.exec, 1, 1, store)
+	require.NoError(t, err)
+	cancel()
+	// wait for the old runner to stop writing the store.
+	_, err = r.Wait(context.Background(), running.ID)
+	require.NoError(t, err)
+
+	list := r2.List()
+	require.Len(t, list, 3)
+	require.Equal(t, JobSucceeded, list[0].State)
+	require.Equal(t, JobQueued, list[1].State)
+	require.Equal(t, 1, list[1].Restarts)
+	// the interrupted backup resumes from its checkpoint.
+	require.True(t, list[1].Resume)
+	require.Equal(t, []string{"--storage", "local:///tmp/b", "--resume"}, list[1].CommandArgs())
+	require.Equal(t, JobQueued, list[2].State)
+	require.Equal(t, 0, list[2].Restarts)
+	require.False(t, list[2].Resume)
+	require.Equal(t, []string{"--storage", "local:///tmp/a"}, list[2].CommandArgs())
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	r2.Start(ctx2)
+	require.Equal(t, running.ID, <-e2.started)
+	e2.release <- nil
+	require.Equal(t, queued.ID, <-e2.started)
+	e2.release <- nil
+	job, err := r2.Wait(ctx2, queued.ID)
+	require.NoError(t, err)
+	require.Equal(t, JobSucceeded, job.State)
+	// the arguments are kept in the store as they are, so the job can run again.
+	require.Equal(t, []string{"--storage", "local:///tmp/a"}, job.Args)
+}
+
+func TestHandoverJobs(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := newBlockingExecutor()
+	r, err := NewRunnerWithStore(e.exec, 1, 1, store)
+	require.NoError(t, err)
+	r.Start(ctx)
+
+	running, err := r.Submit(JobKindBackupRaw, []string{"--storage", "local:///tmp/b"}, "")
+	require.NoError(t, err)
+	<-e.started
+	queued, err := r.Submit(JobKindRestoreRaw, []string{"--storage", "local:///tmp/a"}, "")
+	require.NoError(t, err)
+
+	// the running job is interrupted, but it's not finished in the store.
+	require.NoError(t, r.Handover())
+	jobs, err := store.LoadAll()
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	require.Equal(t, running.ID, jobs[0].ID)
+	require.Equal(t, JobRunning, jobs[0].State)
+	require.Equal(t, queued.ID, jobs[1].ID)
+	require.Equal(t, JobQueued, jobs[1].State)
+
+	// the new process runs them again.
+	r2, err := NewRunnerWithStore(newBlockingExecutor().exec, 1, 1, store)
+	require.NoError(t, err)
+	list := r2.List()
+	require.Equal(t, JobQueued, list[0].State)
+	require.Equal(t, 1, list[0].Restarts)
+	require.Equal(t, JobQueued, list[1].State)
+
+	require.Error(t, NewRunner(e.exec, 1, 1).Handover())
+}
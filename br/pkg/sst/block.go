@@ -0,0 +1,238 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sst
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+const (
+	// blockTrailerSize is the size of the compression type and the checksum following a block.
+	blockTrailerSize = 5
+	// dataBlockHashIndexFlag is the highest bit of the restart count, set if the block
+	// has a hash index after the restarts.
+	dataBlockHashIndexFlag = 1 << 31
+)
+
+// blockHandle is the position of a block in the table.
+type blockHandle struct {
+	offset uint64
+	size   uint64
+}
+
+// decodeBlockHandle decodes the handle from the head of b, it returns the bytes consumed.
+func decodeBlockHandle(b []byte) (blockHandle, int, error) {
+	offset, n1 := binary.Uvarint(b)
+	if n1 <= 0 {
+		return blockHandle{}, 0, errors.Annotate(berrors.ErrSSTCorrupted, "bad block handle")
+	}
+	size, n2 := binary.Uvarint(b[n1:])
+	if n2 <= 0 {
+		return blockHandle{}, 0, errors.Annotate(berrors.ErrSSTCorrupted, "bad block handle")
+	}
+	return blockHandle{offset: offset, size: size}, n1 + n2, nil
+}
+
+// block is a decompressed block of prefix compressed entries, followed by the offsets of
+// the restart points, whose keys are stored in full.
+type block struct {
+	data     []byte
+	restarts []byte
+}
+
+func newBlock(b []byte) (*block, error) {
+	if len(b) < 4 {
+		return nil, errors.Annotate(berrors.ErrSSTCorrupted, "block too short")
+	}
+	footer := binary.LittleEndian.Uint32(b[len(b)-4:])
+	end := len(b) - 4
+	if footer&dataBlockHashIndexFlag != 0 {
+		footer &^= dataBlockHashIndexFlag
+		if end < 2 {
+			return nil, errors.Annotate(berrors.ErrSSTCorrupted, "block too short")
+		}
+		buckets := int(binary.LittleEndian.Uint16(b[end-2:]))
+		end -= 2 + buckets
+	}
+	restartsLen := int(footer) * 4
+	if footer == 0 || end < restartsLen {
+		return nil, errors.Annotatef(berrors.ErrSSTCorrupted, "bad restart count %d", footer)
+	}
+	return &block{data: b[:end-restartsLen], restarts: b[end-restartsLen : end]}, nil
+}
+
+func (b *block) numRestarts() int {
+	return len(b.restarts) / 4
+}
+
+func (b *block) restart(i int) int {
+	return int(binary.LittleEndian.Uint32(b.restarts[i*4:]))
+}
+
+// compareFunc compares the key of an entry with the target.
+type compareFunc func(key, target []byte) int
+
+// blockIter iterates the entries of a block.
+type blockIter struct {
+	b *block
+	// deltaHandle is whether the values are block handles delta encoded, which is the
+	// case of the index blocks of format version 4 and above.
+	deltaHandle bool
+
+	next   int
+	key    []byte
+	value  []byte
+	handle blockHandle
+	err    error
+	valid  bool
+}
+
+func newBlockIter(b *block, deltaHandle bool) *blockIter {
+	return &blockIter{b: b, deltaHandle: deltaHandle}
+}
+
+// seekToRestart positions the iterator before the entry at the restart point.
+func (it *blockIter) seekToRestart(i int) {
+	it.next = it.b.restart(i)
+	it.key = it.key[:0]
+	it.handle = blockHandle{}
+	it.valid = false
+}
+
+// Next moves to the next entry, it returns false at the end or on error.
+func (it *blockIter) Next() bool {
+	data := it.b.data
+	if it.err != nil || it.next >= len(data) {
+		it.valid = false
+		return false
+	}
+	p := it.next
+	shared, n := binary.Uvarint(data[p:])
+	if n <= 0 {
+		return it.corrupted()
+	}
+	p += n
+	nonShared, n := binary.Uvarint(data[p:])
+	if n <= 0 {
+		return it.corrupted()
+	}
+	p += n
+	var valueLen uint64
+	if !it.deltaHandle {
+		if valueLen, n = binary.Uvarint(data[p:]); n <= 0 {
+			return it.corrupted()
+		}
+		p += n
+	}
+	if shared > uint64(len(it.key)) || nonShared > uint64(len(data)-p) {
+		return it.corrupted()
+	}
+	it.key = append(it.key[:shared], data[p:p+int(nonShared)]...)
+	p += int(nonShared)
+
+	if !it.deltaHandle {
+		if valueLen > uint64(len(data)-p) {
+			return it.corrupted()
+		}
+		it.value = data[p : p+int(valueLen)]
+		it.next = p + int(valueLen)
+		it.valid = true
+		return true
+	}
+	// the entries sharing no prefix have the full handle, the others have the delta
+	// of the size to the previous one, their blocks are adjacent.
+	if shared == 0 {
+		h, n, err := decodeBlockHandle(data[p:])
+		if err != nil {
+			return it.corrupted()
+		}
+		it.handle = h
+		p += n
+	} else {
+		delta, n := binary.Varint(data[p:])
+		if n <= 0 {
+			return it.corrupted()
+		}
+		it.handle = blockHandle{
+			offset: it.handle.offset + it.handle.size + blockTrailerSize,
+			size:   uint64(int64(it.handle.size) + delta),
+		}
+		p += n
+	}
+	it.value = nil
+	it.next = p
+	it.valid = true
+	return true
+}
+
+func (it *blockIter) corrupted() bool {
+	it.err = errors.Annotate(berrors.ErrSSTCorrupted, "bad block entry")
+	it.valid = false
+	return false
+}
+
+// Seek moves to the first entry whose key isn't less than the target.
+func (it *blockIter) Seek(target []byte, cmp compareFunc) bool {
+	// find the last restart point whose key is less than the target.
+	lo, hi := 0, it.b.numRestarts()-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		it.seekToRestart(mid)
+		if !it.Next() {
+			return false
+		}
+		if cmp(it.key, target) < 0 {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	it.seekToRestart(lo)
+	for it.Next() {
+		if cmp(it.key, target) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// First moves to the first entry.
+func (it *blockIter) First() bool {
+	it.seekToRestart(0)
+	return it.Next()
+}
+
+// blockHandleValue returns the block handle of the current index entry.
+func (it *blockIter) blockHandleValue() (blockHandle, error) {
+	if it.deltaHandle {
+		return it.handle, nil
+	}
+	h, _, err := decodeBlockHandle(it.value)
+	return h, errors.Trace(err)
+}
+
+// findValue returns the value of the key in the block of unique keys, e.g. the meta
+// index block and the properties block.
+func (b *block) findValue(key []byte) ([]byte, bool, error) {
+	it := newBlockIter(b, false)
+	if it.Seek(key, bytes.Compare) && bytes.Equal(it.key, key) {
+		return it.value, true, nil
+	}
+	return nil, false, errors.Trace(it.err)
+}
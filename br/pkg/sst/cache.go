@@ -0,0 +1,110 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sst
+
+import (
+	"container/list"
+	"sync"
+)
+
+type cacheKey struct {
+	table  string
+	offset uint64
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	block *block
+	size  int64
+}
+
+// CacheStats is the statistics of a BlockCache.
+type CacheStats struct {
+	Capacity int64  `json:"capacity"`
+	Size     int64  `json:"size"`
+	Blocks   int    `json:"blocks"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+}
+
+// BlockCache is an LRU cache of the decompressed blocks shared by the tables, it's bounded
+// by the bytes of the blocks. It's safe for concurrent use.
+type BlockCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	lru      *list.List
+	entries  map[cacheKey]*list.Element
+	hits     uint64
+	misses   uint64
+}
+
+// NewBlockCache creates a BlockCache of at most capacity bytes, the blocks aren't cached
+// if it's not positive.
+func NewBlockCache(capacity int64) *BlockCache {
+	return &BlockCache{
+		capacity: capacity,
+		lru:      list.New(),
+		entries:  make(map[cacheKey]*list.Element),
+	}
+}
+
+func (c *BlockCache) get(key cacheKey) (*block, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).block, true
+}
+
+func (c *BlockCache) put(key cacheKey, b *block, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if size > c.capacity {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		// another reader has fetched it meanwhile.
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, block: b, size: size})
+	c.size += size
+	for c.size > c.capacity {
+		oldest := c.lru.Back()
+		entry := oldest.Value.(*cacheEntry)
+		c.lru.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.size -= entry.size
+	}
+}
+
+// Stats returns the statistics of the cache.
+func (c *BlockCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Capacity: c.capacity,
+		Size:     c.size,
+		Blocks:   c.lru.Len(),
+		Hits:     c.hits,
+		Misses:   c.misses,
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sst
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// the compression types of the blocks.
+const (
+	noCompression     = 0
+	snappyCompression = 1
+	zlibCompression   = 2
+	lz4Compression    = 4
+	lz4hcCompression  = 5
+	zstdCompression   = 7
+	// zstdNotFinalCompression is the zstd of the RocksDB versions before it's stable.
+	zstdNotFinalCompression = 0x88
+
+	// maxBlockSize bounds the decompressed size read from a block, against the corrupted ones.
+	maxBlockSize = 1 << 30
+)
+
+// zstdDecoder is shared by the tables, DecodeAll is safe for concurrent use.
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// decompress decompresses the block. Since format version 2, the blocks compressed by
+// lz4, zlib and zstd are prefixed by their decompressed size.
+func decompress(typ byte, data []byte, formatVersion uint32) ([]byte, error) {
+	if typ == noCompression {
+		return data, nil
+	}
+	if typ == snappyCompression {
+		b, err := snappy.Decode(nil, data)
+		if err != nil {
+			return nil, errors.Annotate(berrors.ErrSSTCorrupted, err.Error())
+		}
+		return b, nil
+	}
+	if formatVersion < 2 {
+		return nil, errors.Annotatef(berrors.ErrSSTUnsupported,
+			"compression type %d of format version %d", typ, formatVersion)
+	}
+	size, n := binary.Uvarint(data)
+	if n <= 0 || size > maxBlockSize {
+		return nil, errors.Annotate(berrors.ErrSSTCorrupted, "bad decompressed size")
+	}
+	data = data[n:]
+	var (
+		b   []byte
+		err error
+	)
+	switch typ {
+	case lz4Compression, lz4hcCompression:
+		b, err = lz4Decode(make([]byte, 0, size), data)
+	case zstdCompression, zstdNotFinalCompression:
+		b, err = zstdDecoder.DecodeAll(data, make([]byte, 0, size))
+	case zlibCompression:
+		// RocksDB writes raw deflate without the zlib header.
+		b, err = io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	default:
+		return nil, errors.Annotatef(berrors.ErrSSTUnsupported, "compression type %d", typ)
+	}
+	if err != nil {
+		return nil, errors.Annotate(berrors.ErrSSTCorrupted, err.Error())
+	}
+	if uint64(len(b)) != size {
+		return nil, errors.Annotatef(berrors.ErrSSTCorrupted, "decompressed %d bytes, expect %d", len(b), size)
+	}
+	return b, nil
+}
+
+// lz4Decode decodes the lz4 block, i.e. the sequences of literals and matches without
+// the frame, and appends it to dst.
+func lz4Decode(dst, src []byte) ([]byte, error) {
+	readLen := func(i, l int) (int, int, error) {
+		if l != 15 {
+			return i, l, nil
+		}
+		for {
+			if i >= len(src) {
+				return 0, 0, errors.New("lz4: truncated length")
+			}
+			b := src[i]
+			i++
+			l += int(b)
+			if b != 255 {
+				return i, l, nil
+			}
+		}
+	}
+	var (
+		litLen, matchLen int
+		err              error
+	)
+	for i := 0; i < len(src); {
+		token := src[i]
+		if i, litLen, err = readLen(i+1, int(token>>4)); err != nil {
+			return nil, err
+		}
+		if litLen > len(src)-i {
+			return nil, errors.New("lz4: truncated literals")
+		}
+		dst = append(dst, src[i:i+litLen]...)
+		i += litLen
+		if i == len(src) {
+			// the last sequence has only the literals.
+			break
+		}
+		if i+2 > len(src) {
+			return nil, errors.New("lz4: truncated offset")
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, errors.New("lz4: bad offset")
+		}
+		if i, matchLen, err = readLen(i, int(token&0xf)); err != nil {
+			return nil, err
+		}
+		matchLen += 4
+		// the match may overlap the bytes it copies, so copy them one by one.
+		pos := len(dst) - offset
+		for j := 0; j < matchLen; j++ {
+			dst = append(dst, dst[pos+j])
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,346 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sst reads the SST files of the backups, i.e. the block based tables written by
+// RocksDB in TiKV, lazily: only the blocks visited are fetched from the storage.
+package sst
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+const (
+	footerSize       = 53
+	legacyFooterSize = 48
+
+	magicNumber       uint64 = 0x88e241b785f4cff7
+	legacyMagicNumber uint64 = 0xdb4775248b80fb57
+	maxFormatVersion         = 5
+
+	checksumCRC32c = 1
+	crcMaskDelta   = 0xa282ead8
+
+	// internalKeySuffixSize is the size of the sequence number and the value type
+	// appended to the user key by RocksDB.
+	internalKeySuffixSize = 8
+	valueTypeValue        = 1
+
+	propertiesBlock              = "rocksdb.properties"
+	propIndexKeyIsUserKey        = "rocksdb.index.key.is.user.key"
+	propIndexValueIsDeltaEncoded = "rocksdb.index.value.is.delta.encoded"
+	propIndexType                = "rocksdb.block.based.table.index.type"
+
+	indexTypeTwoLevel                 = 2
+	indexTypeBinarySearchWithFirstKey = 3
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Fetcher reads the bytes [offset, offset+size) of the table file.
+type Fetcher func(ctx context.Context, offset, size int64) ([]byte, error)
+
+// Table is a block based table whose blocks are fetched on demand and cached.
+type Table struct {
+	name  string
+	fetch Fetcher
+	cache *BlockCache
+
+	formatVersion     uint32
+	checksumType      byte
+	index             blockHandle
+	indexKeyIsUserKey bool
+	indexDeltaHandle  bool
+}
+
+// Open opens the table of size bytes, only the footer and the meta blocks are read. The
+// name identifies the blocks of the table in the cache.
+func Open(ctx context.Context, name string, size int64, fetch Fetcher, cache *BlockCache) (*Table, error) {
+	if size < legacyFooterSize {
+		return nil, errors.Annotatef(berrors.ErrSSTCorrupted, "%s is too short", name)
+	}
+	n := int64(footerSize)
+	if size < n {
+		n = size
+	}
+	footer, err := fetch(ctx, size-n, n)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if int64(len(footer)) != n {
+		return nil, errors.Annotatef(berrors.ErrSSTCorrupted, "%s has a truncated footer", name)
+	}
+	t := &Table{name: name, fetch: fetch, cache: cache, checksumType: checksumCRC32c}
+	var handles []byte
+	switch binary.LittleEndian.Uint64(footer[len(footer)-8:]) {
+	case magicNumber:
+		if len(footer) != footerSize {
+			return nil, errors.Annotatef(berrors.ErrSSTCorrupted, "%s has a truncated footer", name)
+		}
+		t.checksumType = footer[0]
+		t.formatVersion = binary.LittleEndian.Uint32(footer[footerSize-12:])
+		handles = footer[1:]
+	case legacyMagicNumber:
+		handles = footer[len(footer)-legacyFooterSize:]
+	default:
+		return nil, errors.Annotatef(berrors.ErrSSTUnsupported, "%s isn't a block based table", name)
+	}
+	if t.formatVersion > maxFormatVersion {
+		return nil, errors.Annotatef(berrors.ErrSSTUnsupported, "%s is of format version %d", name, t.formatVersion)
+	}
+	metaIndex, n2, err := decodeBlockHandle(handles)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if t.index, _, err = decodeBlockHandle(handles[n2:]); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = t.readProperties(ctx, metaIndex); err != nil {
+		return nil, errors.Annotatef(err, "failed to read the properties of %s", name)
+	}
+	return t, nil
+}
+
+// readProperties reads how the index block is encoded from the properties.
+func (t *Table) readProperties(ctx context.Context, metaIndex blockHandle) error {
+	meta, err := t.readBlock(ctx, metaIndex)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	value, ok, err := meta.findValue([]byte(propertiesBlock))
+	if err != nil || !ok {
+		return errors.Trace(err)
+	}
+	handle, _, err := decodeBlockHandle(value)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	props, err := t.readBlock(ctx, handle)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	flag := func(name string) (bool, error) {
+		value, ok, err := props.findValue([]byte(name))
+		if err != nil || !ok {
+			return false, errors.Trace(err)
+		}
+		v, n := binary.Uvarint(value)
+		return n > 0 && v != 0, nil
+	}
+	if t.indexKeyIsUserKey, err = flag(propIndexKeyIsUserKey); err != nil {
+		return errors.Trace(err)
+	}
+	if t.indexDeltaHandle, err = flag(propIndexValueIsDeltaEncoded); err != nil {
+		return errors.Trace(err)
+	}
+	value, ok, err = props.findValue([]byte(propIndexType))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if ok && len(value) == 4 {
+		switch indexType := binary.LittleEndian.Uint32(value); indexType {
+		case indexTypeTwoLevel, indexTypeBinarySearchWithFirstKey:
+			return errors.Annotatef(berrors.ErrSSTUnsupported, "index type %d", indexType)
+		}
+	}
+	return nil
+}
+
+// readBlock reads the block from the cache, or fetches it and verifies its checksum.
+func (t *Table) readBlock(ctx context.Context, h blockHandle) (*block, error) {
+	key := cacheKey{table: t.name, offset: h.offset}
+	if b, ok := t.cache.get(key); ok {
+		return b, nil
+	}
+	raw, err := t.fetch(ctx, int64(h.offset), int64(h.size)+blockTrailerSize)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if uint64(len(raw)) != h.size+blockTrailerSize {
+		return nil, errors.Annotatef(berrors.ErrSSTCorrupted, "truncated block at %d of %s", h.offset, t.name)
+	}
+	data, trailer := raw[:h.size], raw[h.size:]
+	if t.checksumType == checksumCRC32c {
+		// the checksum covers the compression type as well.
+		crc := crc32.Update(crc32.Checksum(data, crc32cTable), crc32cTable, trailer[:1])
+		masked := binary.LittleEndian.Uint32(trailer[1:]) - crcMaskDelta
+		if crc != (masked>>17 | masked<<15) {
+			return nil, errors.Annotatef(berrors.ErrSSTCorrupted, "checksum mismatch of block at %d of %s", h.offset, t.name)
+		}
+	}
+	contents, err := decompress(trailer[0], data, t.formatVersion)
+	if err != nil {
+		return nil, errors.Annotatef(err, "block at %d of %s", h.offset, t.name)
+	}
+	b, err := newBlock(contents)
+	if err != nil {
+		return nil, errors.Annotatef(err, "block at %d of %s", h.offset, t.name)
+	}
+	t.cache.put(key, b, int64(len(contents)))
+	return b, nil
+}
+
+func compareIndexUserKey(key, target []byte) int {
+	return bytes.Compare(key, target)
+}
+
+func compareInternalKey(key, target []byte) int {
+	if len(key) >= internalKeySuffixSize {
+		key = key[:len(key)-internalKeySuffixSize]
+	}
+	return bytes.Compare(key, target)
+}
+
+// NewIterator returns an iterator of the table, the blocks are fetched with the ctx.
+func (t *Table) NewIterator(ctx context.Context) *Iterator {
+	return &Iterator{t: t, ctx: ctx}
+}
+
+// Iterator iterates the values in the table by the order of the keys, the deletions are
+// skipped. It isn't safe for concurrent use.
+type Iterator struct {
+	t     *Table
+	ctx   context.Context
+	index *blockIter
+	data  *blockIter
+	valid bool
+	err   error
+}
+
+// Seek moves to the first key not less than the target, it returns whether there is one.
+func (it *Iterator) Seek(target []byte) bool {
+	if !it.resetIndex() {
+		return false
+	}
+	cmp := compareInternalKey
+	if it.t.indexKeyIsUserKey {
+		cmp = compareIndexUserKey
+	}
+	// the key of an index entry isn't less than the keys in the block and less than the
+	// keys of the next block.
+	if !it.index.Seek(target, cmp) {
+		return it.fail(it.index.err)
+	}
+	if !it.loadData() {
+		return false
+	}
+	return it.settle(it.data.Seek(target, compareInternalKey))
+}
+
+// First moves to the first key.
+func (it *Iterator) First() bool {
+	if !it.resetIndex() {
+		return false
+	}
+	if !it.index.First() {
+		return it.fail(it.index.err)
+	}
+	if !it.loadData() {
+		return false
+	}
+	return it.settle(it.data.First())
+}
+
+// Next moves to the next key.
+func (it *Iterator) Next() bool {
+	if !it.valid {
+		return false
+	}
+	return it.settle(it.data.Next())
+}
+
+// Valid returns whether the iterator is at a key.
+func (it *Iterator) Valid() bool {
+	return it.valid
+}
+
+// Key returns the user key, it's only valid until the iterator moves.
+func (it *Iterator) Key() []byte {
+	return it.data.key[:len(it.data.key)-internalKeySuffixSize]
+}
+
+// Value returns the value, it's only valid until the iterator moves.
+func (it *Iterator) Value() []byte {
+	return it.data.value
+}
+
+// Err returns the error stopping the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+func (it *Iterator) fail(err error) bool {
+	it.err = errors.Trace(err)
+	it.valid = false
+	return false
+}
+
+func (it *Iterator) resetIndex() bool {
+	it.valid, it.err = false, nil
+	index, err := it.t.readBlock(it.ctx, it.t.index)
+	if err != nil {
+		return it.fail(err)
+	}
+	it.index = newBlockIter(index, it.t.indexDeltaHandle)
+	return true
+}
+
+// loadData loads the data block of the current index entry.
+func (it *Iterator) loadData() bool {
+	h, err := it.index.blockHandleValue()
+	if err != nil {
+		return it.fail(err)
+	}
+	b, err := it.t.readBlock(it.ctx, h)
+	if err != nil {
+		return it.fail(err)
+	}
+	it.data = newBlockIter(b, false)
+	return true
+}
+
+// settle moves on to the first value from the current entry of the data block, ok is
+// whether the data block is at an entry.
+func (it *Iterator) settle(ok bool) bool {
+	for {
+		if !ok {
+			if it.data.err != nil {
+				return it.fail(it.data.err)
+			}
+			if !it.index.Next() {
+				return it.fail(it.index.err)
+			}
+			if !it.loadData() {
+				return false
+			}
+			ok = it.data.First()
+			continue
+		}
+		key := it.data.key
+		if len(key) < internalKeySuffixSize {
+			return it.fail(errors.Annotatef(berrors.ErrSSTCorrupted, "bad internal key in %s", it.t.name))
+		}
+		// the value type is the lowest byte of the little endian suffix.
+		if key[len(key)-internalKeySuffixSize] == valueTypeValue {
+			it.valid = true
+			return true
+		}
+		ok = it.data.Next()
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sst
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// testdata/raw_v1.sst is a file of an API V1 raw backup by TiKV v5.2.1, it has 500 keys
+// of "ztest_<number>", whose values are 64 "A"s, in a zstd compressed data block.
+func openTestTable(t *testing.T, cache *BlockCache) (*Table, *int) {
+	data, err := os.ReadFile("testdata/raw_v1.sst")
+	require.NoError(t, err)
+	fetches := 0
+	fetch := func(ctx context.Context, offset, size int64) ([]byte, error) {
+		fetches++
+		return data[offset : offset+size], nil
+	}
+	table, err := Open(context.Background(), "raw_v1.sst", int64(len(data)), fetch, cache)
+	require.NoError(t, err)
+	return table, &fetches
+}
+
+func TestIterateTable(t *testing.T) {
+	cache := NewBlockCache(1 << 20)
+	table, fetches := openTestTable(t, cache)
+	it := table.NewIterator(context.Background())
+	var keys [][]byte
+	for ok := it.First(); ok; ok = it.Next() {
+		require.True(t, bytes.HasPrefix(it.Key(), []byte("ztest_")))
+		require.Equal(t, bytes.Repeat([]byte("A"), 64), it.Value())
+		keys = append(keys, append([]byte{}, it.Key()...))
+	}
+	require.NoError(t, it.Err())
+	require.Len(t, keys, 500)
+	for i := 1; i < len(keys); i++ {
+		require.Less(t, string(keys[i-1]), string(keys[i]))
+	}
+
+	fetched := *fetches
+	for _, i := range []int{0, 123, 499} {
+		require.True(t, it.Seek(keys[i]))
+		require.Equal(t, keys[i], it.Key())
+		// seeking a key between the keys.
+		require.Equal(t, i < 499, it.Seek(append(append([]byte{}, keys[i]...), 0)))
+		if i < 499 {
+			require.Equal(t, keys[i+1], it.Key())
+		}
+	}
+	require.False(t, it.Seek([]byte("zz")))
+	require.NoError(t, it.Err())
+	require.True(t, it.Seek(nil))
+	require.Equal(t, keys[0], it.Key())
+	// the blocks are cached.
+	require.Equal(t, fetched, *fetches)
+	require.NotZero(t, cache.Stats().Hits)
+
+	// without the cache the blocks are fetched again.
+	table, fetches = openTestTable(t, NewBlockCache(0))
+	fetched = *fetches
+	require.True(t, table.NewIterator(context.Background()).Seek(keys[1]))
+	require.Greater(t, *fetches, fetched)
+}
+
+func TestCorruptedTable(t *testing.T) {
+	data, err := os.ReadFile("testdata/raw_v1.sst")
+	require.NoError(t, err)
+	// flip a byte of the first data block.
+	data[10] ^= 0xff
+	fetch := func(ctx context.Context, offset, size int64) ([]byte, error) {
+		return data[offset : offset+size], nil
+	}
+	table, err := Open(context.Background(), "raw_v1.sst", int64(len(data)), fetch, NewBlockCache(1<<20))
+	require.NoError(t, err)
+	it := table.NewIterator(context.Background())
+	require.False(t, it.First())
+	require.True(t, berrors.ErrSSTCorrupted.Equal(it.Err()))
+
+	_, err = Open(context.Background(), "bad.sst", 64, func(ctx context.Context, offset, size int64) ([]byte, error) {
+		return make([]byte, size), nil
+	}, NewBlockCache(0))
+	require.True(t, berrors.ErrSSTUnsupported.Equal(err))
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return append(b, buf[:binary.PutUvarint(buf, v)]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return append(b, buf[:binary.PutVarint(buf, v)]...)
+}
+
+func TestDeltaEncodedIndex(t *testing.T) {
+	var b []byte
+	// the restart entry has the full handle, the next one has the delta of the size.
+	b = appendUvarint(b, 0)
+	b = appendUvarint(b, 2)
+	b = append(b, "ab"...)
+	b = appendUvarint(b, 0)
+	b = appendUvarint(b, 100)
+	b = appendUvarint(b, 1)
+	b = appendUvarint(b, 1)
+	b = append(b, "c"...)
+	b = appendVarint(b, -10)
+	b = append(b, 0, 0, 0, 0)
+	b = append(b, 1, 0, 0, 0)
+	blk, err := newBlock(b)
+	require.NoError(t, err)
+
+	it := newBlockIter(blk, true)
+	require.True(t, it.First())
+	require.Equal(t, []byte("ab"), it.key)
+	require.Equal(t, blockHandle{offset: 0, size: 100}, it.handle)
+	require.True(t, it.Next())
+	require.Equal(t, []byte("ac"), it.key)
+	require.Equal(t, blockHandle{offset: 105, size: 90}, it.handle)
+	require.False(t, it.Next())
+	require.NoError(t, it.err)
+	require.True(t, it.Seek([]byte("ac"), compareIndexUserKey))
+	require.Equal(t, blockHandle{offset: 105, size: 90}, it.handle)
+}
+
+func TestLZ4Decode(t *testing.T) {
+	// "abcd" as literals, then a match of 8 bytes at offset 4, then "xy" as literals.
+	src := []byte{0x44, 'a', 'b', 'c', 'd', 4, 0, 0x20, 'x', 'y'}
+	b, err := lz4Decode(nil, src)
+	require.NoError(t, err)
+	require.Equal(t, "abcdabcdabcdxy", string(b))
+
+	// the literal length of 15 and more is extended by the following bytes.
+	long := append([]byte{0xf0, 1}, bytes.Repeat([]byte("z"), 16)...)
+	b, err = lz4Decode(nil, long)
+	require.NoError(t, err)
+	require.Equal(t, bytes.Repeat([]byte("z"), 16), b)
+
+	_, err = lz4Decode(nil, []byte{0x10, 'a', 9, 0})
+	require.Error(t, err)
+}
+
+func TestBlockCache(t *testing.T) {
+	cache := NewBlockCache(10)
+	b := &block{}
+	cache.put(cacheKey{table: "a", offset: 0}, b, 4)
+	cache.put(cacheKey{table: "a", offset: 1}, b, 4)
+	_, ok := cache.get(cacheKey{table: "a", offset: 0})
+	require.True(t, ok)
+	// the least recently used one is evicted.
+	cache.put(cacheKey{table: "b", offset: 0}, b, 4)
+	_, ok = cache.get(cacheKey{table: "a", offset: 1})
+	require.False(t, ok)
+	_, ok = cache.get(cacheKey{table: "a", offset: 0})
+	require.True(t, ok)
+	// a block larger than the capacity isn't cached.
+	cache.put(cacheKey{table: "c", offset: 0}, b, 11)
+	require.Equal(t, CacheStats{Capacity: 10, Size: 8, Blocks: 2, Hits: 2, Misses: 1}, cache.Stats())
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/spf13/pflag"
@@ -24,6 +25,15 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	// azblobSASTokenEnv is the environment variable of the SAS token to access azure blob storage.
+	azblobSASTokenEnv = "AZURE_STORAGE_SAS_TOKEN"
+	// azblobAuthModeEnv set to azblobAuthModeManagedIdentity accesses azure blob storage by the managed
+	// identity of the host, AZURE_CLIENT_ID selects a user-assigned identity.
+	azblobAuthModeEnv             = "AZURE_STORAGE_AUTH_MODE"
+	azblobAuthModeManagedIdentity = "msi"
+)
+
 const (
 	azblobEndpointOption   = "azblob.endpoint"
 	azblobAccessTierOption = "azblob.access-tier"
@@ -116,6 +126,36 @@ func (b *tokenClientBuilder) GetAccountName() string {
 	return b.accountName
 }
 
+// use SAS token to access azure blob storage
+type sasClientBuilder struct {
+	sasToken    string
+	accountName string
+	serviceURL  string
+}
+
+func (b *sasClientBuilder) GetServiceClient() (azblob.ServiceClient, error) {
+	return azblob.NewServiceClientWithNoCredential(b.serviceURL+"?"+b.sasToken, nil)
+}
+
+func (b *sasClientBuilder) GetAccountName() string {
+	return b.accountName
+}
+
+// use the managed identity of the host to access azure blob storage
+type managedIdentityClientBuilder struct {
+	cred        *azidentity.ManagedIdentityCredential
+	accountName string
+	serviceURL  string
+}
+
+func (b *managedIdentityClientBuilder) GetServiceClient() (azblob.ServiceClient, error) {
+	return azblob.NewServiceClient(b.serviceURL, b.cred, nil)
+}
+
+func (b *managedIdentityClientBuilder) GetAccountName() string {
+	return b.accountName
+}
+
 func getAuthorizerFromEnvironment() (clientID, tenantID, clientSecret string) {
 	return os.Getenv("AZURE_CLIENT_ID"),
 		os.Getenv("AZURE_TENANT_ID"),
@@ -158,6 +198,38 @@ func getAzureServiceClientBuilder(options *backuppb.AzureBlobStorage, opts *Exte
 		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net", accountName)
 	}
 
+	if sasToken := strings.TrimPrefix(os.Getenv(azblobSASTokenEnv), "?"); len(sasToken) > 0 {
+		log.Info("Get azure SAS token from environment variable $" + azblobSASTokenEnv)
+		// the SAS token can't be sent to TiKV, which needs its own credential.
+		if opts != nil && opts.SendCredentials {
+			options.AccountName = accountName
+		}
+		return &sasClientBuilder{
+			sasToken,
+			accountName,
+			serviceURL,
+		}, nil
+	}
+
+	if os.Getenv(azblobAuthModeEnv) == azblobAuthModeManagedIdentity {
+		managedIdentityOptions := &azidentity.ManagedIdentityCredentialOptions{}
+		if clientID := os.Getenv("AZURE_CLIENT_ID"); len(clientID) > 0 {
+			managedIdentityOptions.ID = azidentity.ClientID(clientID)
+		}
+		cred, err := azidentity.NewManagedIdentityCredential(managedIdentityOptions)
+		if err != nil {
+			return nil, errors.Annotate(err, "Failed to get azure managed identity credential")
+		}
+		if opts != nil && opts.SendCredentials {
+			options.AccountName = accountName
+		}
+		return &managedIdentityClientBuilder{
+			cred,
+			accountName,
+			serviceURL,
+		}, nil
+	}
+
 	if clientID, tenantID, clientSecret := getAuthorizerFromEnvironment(); len(clientID) > 0 && len(tenantID) > 0 && len(clientSecret) > 0 {
 		cred, err := azidentity.NewClientSecretCredential(tenantID, clientID, clientSecret, nil)
 		if err != nil {
@@ -264,6 +336,7 @@ func (s *AzureBlobStorage) withPrefix(name string) string {
 }
 
 func (s *AzureBlobStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	defer observeWriteFile("azblob", time.Now())
 	client := s.containerClient.NewBlockBlobClient(s.withPrefix(name))
 	_, err := client.UploadBufferToBlockBlob(ctx, data, azblob.HighLevelUploadToBlockBlobOption{AccessTier: &s.accessTier})
 	if err != nil {
@@ -301,6 +374,18 @@ func (s *AzureBlobStorage) FileExists(ctx context.Context, name string) (bool, e
 	return true, nil
 }
 
+// ModTime implements ModTimeReader.
+func (s *AzureBlobStorage) ModTime(ctx context.Context, name string) (time.Time, error) {
+	props, err := s.containerClient.NewBlockBlobClient(s.withPrefix(name)).GetProperties(ctx, nil)
+	if err != nil {
+		return time.Time{}, errors.Trace(err)
+	}
+	if props.LastModified == nil {
+		return time.Time{}, nil
+	}
+	return *props.LastModified, nil
+}
+
 func (s *AzureBlobStorage) DeleteFile(ctx context.Context, name string) error {
 	client := s.containerClient.NewBlockBlobClient(s.withPrefix(name))
 	_, err := client.Delete(ctx, nil)
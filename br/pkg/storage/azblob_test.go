@@ -299,3 +299,32 @@ func TestNewAzblobStorage(t *testing.T) {
 	}
 
 }
+
+func TestNewAzblobStorageWithSASOrManagedIdentity(t *testing.T) {
+	options := &backuppb.AzureBlobStorage{
+		Endpoint:    "http://127.0.0.1:1000",
+		Bucket:      "test",
+		Prefix:      "a/b",
+		AccountName: "user",
+	}
+
+	t.Setenv(azblobAuthModeEnv, azblobAuthModeManagedIdentity)
+	// the credential probes the identity endpoint when created,
+	// point it to a local one so that the test doesn't depend on running in azure.
+	t.Setenv("MSI_ENDPOINT", "http://127.0.0.1:1001/msi/token")
+	builder, err := getAzureServiceClientBuilder(options, nil)
+	require.NoError(t, err)
+	mb, ok := builder.(*managedIdentityClientBuilder)
+	require.True(t, ok)
+	require.Equal(t, "user", mb.GetAccountName())
+	require.Equal(t, "http://127.0.0.1:1000", mb.serviceURL)
+
+	// the SAS token takes priority over the managed identity.
+	t.Setenv(azblobSASTokenEnv, "?sv=2020-08-04&sig=xxx")
+	builder, err = getAzureServiceClientBuilder(options, nil)
+	require.NoError(t, err)
+	sb, ok := builder.(*sasClientBuilder)
+	require.True(t, ok)
+	require.Equal(t, "sv=2020-08-04&sig=xxx", sb.sasToken)
+	require.Equal(t, "http://127.0.0.1:1000", sb.serviceURL)
+}
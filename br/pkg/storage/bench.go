@@ -0,0 +1,187 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// BenchUpload and BenchDownload are the operations measured by Bench.
+	BenchUpload   = "upload"
+	BenchDownload = "download"
+
+	benchPrefix = "br-bench"
+)
+
+// BenchOptions are the options of Bench.
+type BenchOptions struct {
+	// ObjectSize is the size in bytes of each object.
+	ObjectSize int
+	// Objects is the number of objects uploaded and downloaded.
+	Objects int
+	// Concurrency is the number of objects transferred at the same time.
+	Concurrency int
+}
+
+// BenchResult is the result of an operation of Bench.
+type BenchResult struct {
+	Op       string
+	Objects  int
+	Bytes    int64
+	Duration time.Duration
+	// P50, P90, P99 and Max are the latency percentiles of transferring a single object.
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// Throughput returns the bytes transferred per second.
+func (r *BenchResult) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Duration.Seconds()
+}
+
+// percentile returns the p-th (0 < p <= 1) percentile of the sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// Bench uploads random objects to the storage and downloads them back to measure
+// the throughput and the latencies. The objects are removed at the end.
+func Bench(ctx context.Context, s ExternalStorage, opts BenchOptions) ([]BenchResult, error) {
+	if opts.ObjectSize <= 0 || opts.Objects <= 0 || opts.Concurrency <= 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"object size, objects and concurrency of the benchmark must be positive, got %d, %d, %d",
+			opts.ObjectSize, opts.Objects, opts.Concurrency)
+	}
+	content := make([]byte, opts.ObjectSize)
+	if _, err := rand.Read(content); err != nil {
+		return nil, errors.Trace(err)
+	}
+	prefix := fmt.Sprintf("%s-%d", benchPrefix, time.Now().UnixNano())
+	names := make([]string, 0, opts.Objects)
+	for i := 0; i < opts.Objects; i++ {
+		names = append(names, fmt.Sprintf("%s-%06d", prefix, i))
+	}
+	defer func() {
+		for _, name := range names {
+			if err := s.DeleteFile(context.Background(), name); err != nil {
+				log.Warn("failed to remove the benchmark object", zap.String("name", name), zap.Error(err))
+			}
+		}
+	}()
+
+	upload, err := benchOp(ctx, BenchUpload, names, opts.Concurrency, func(ectx context.Context, name string) (int64, error) {
+		return int64(len(content)), s.WriteFile(ectx, name, content)
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	download, err := benchOp(ctx, BenchDownload, names, opts.Concurrency, func(ectx context.Context, name string) (int64, error) {
+		data, err := s.ReadFile(ectx, name)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.Equal(data, content) {
+			return 0, errors.Annotatef(berrors.ErrStorageUnknown, "the content of %s is changed", name)
+		}
+		return int64(len(data)), nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return []BenchResult{upload, download}, nil
+}
+
+func benchOp(
+	ctx context.Context,
+	op string,
+	names []string,
+	concurrency int,
+	fn func(context.Context, string) (int64, error),
+) (BenchResult, error) {
+	result := BenchResult{Op: op, Objects: len(names)}
+	latencies := make([]time.Duration, 0, len(names))
+	var mu sync.Mutex
+
+	eg, ectx := errgroup.WithContext(ctx)
+	workCh := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		eg.Go(func() error {
+			for name := range workCh {
+				start := time.Now()
+				n, err := fn(ectx, name)
+				if err != nil {
+					return errors.Annotatef(err, "failed to %s %s", op, name)
+				}
+				elapsed := time.Since(start)
+				mu.Lock()
+				latencies = append(latencies, elapsed)
+				result.Bytes += n
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	start := time.Now()
+	eg.Go(func() error {
+		defer close(workCh)
+		for _, name := range names {
+			select {
+			case workCh <- name:
+			case <-ectx.Done():
+				return ectx.Err()
+			}
+		}
+		return nil
+	})
+	if err := eg.Wait(); err != nil {
+		return result, errors.Trace(err)
+	}
+	result.Duration = time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result.P50 = percentile(latencies, 0.5)
+	result.P90 = percentile(latencies, 0.9)
+	result.P99 = percentile(latencies, 0.99)
+	result.Max = percentile(latencies, 1)
+	return result, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercentile(t *testing.T) {
+	latencies := make([]time.Duration, 0, 100)
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i))
+	}
+	require.Equal(t, time.Duration(50), percentile(latencies, 0.5))
+	require.Equal(t, time.Duration(99), percentile(latencies, 0.99))
+	require.Equal(t, time.Duration(100), percentile(latencies, 1))
+	require.Equal(t, time.Duration(0), percentile(nil, 0.5))
+	require.Equal(t, time.Duration(3), percentile([]time.Duration{3}, 0.01))
+}
+
+func TestBench(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewLocalStorage(dir)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	results, err := Bench(ctx, s, BenchOptions{ObjectSize: 1024, Objects: 10, Concurrency: 3})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for _, r := range results {
+		require.Equal(t, 10, r.Objects)
+		require.Equal(t, int64(10*1024), r.Bytes)
+		require.LessOrEqual(t, r.P50, r.Max)
+	}
+	require.Equal(t, BenchUpload, results[0].Op)
+	require.Equal(t, BenchDownload, results[1].Op)
+
+	// the objects are removed.
+	err = s.WalkDir(ctx, &WalkOption{}, func(path string, _ int64) error {
+		require.Failf(t, "benchmark object left", "%s", path)
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, err = Bench(ctx, s, BenchOptions{ObjectSize: 1024, Objects: 0, Concurrency: 3})
+	require.Error(t, err)
+}
@@ -6,11 +6,136 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"strings"
 
 	"github.com/pingcap/errors"
 	berrors "github.com/tikv/migration/br/pkg/errors"
 )
 
+var compressSuffixes = map[CompressType]string{
+	Gzip: ".gz",
+	Zstd: ".zst",
+}
+
+var compressNames = map[CompressType]string{
+	NoCompression: "none",
+	Gzip:          "gzip",
+	Zstd:          "zstd",
+}
+
+// ParseCompressType parses the compression type from its name, i.e. none, gzip or zstd.
+func ParseCompressType(name string) (CompressType, error) {
+	for tp, tpName := range compressNames {
+		if strings.EqualFold(name, tpName) {
+			return tp, nil
+		}
+	}
+	return NoCompression, errors.Annotatef(berrors.ErrInvalidArgument,
+		"invalid compression type '%s', must be one of none|gzip|zstd", name)
+}
+
+// Suffix returns the suffix of the files compressed by the compression type, e.g. ".zst".
+func (tp CompressType) Suffix() string {
+	return compressSuffixes[tp]
+}
+
+// CompressTypeBySuffix returns the compression type of the file by its suffix, NoCompression if
+// it has none of the suffixes of the compression types.
+func CompressTypeBySuffix(name string) CompressType {
+	for tp, suffix := range compressSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return tp
+		}
+	}
+	return NoCompression
+}
+
+// NewCompressWriter returns a writer compressing the data written to w by the compression type,
+// which is flushed to w on Close. Close doesn't close w.
+func NewCompressWriter(compressType CompressType, w io.Writer) io.WriteCloser {
+	if compressType == NoCompression {
+		return nopWriteCloser{w}
+	}
+	return newCompressWriter(compressType, w)
+}
+
+// NewCompressReader returns a reader decompressing the data read from r by the compression type.
+// Close doesn't close r.
+func NewCompressReader(compressType CompressType, r io.Reader) (io.ReadCloser, error) {
+	if compressType == NoCompression {
+		return io.NopCloser(r), nil
+	}
+	reader, err := newCompressReader(compressType, r)
+	return reader, errors.Trace(err)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type withSuffixCompression struct {
+	ExternalStorage
+}
+
+// WithSuffixCompression returns an ExternalStorage compressing the files by their suffixes
+// transparently, e.g. "catalog.json.zst" is written and read in zstd. The other files are
+// written and read as they are.
+func WithSuffixCompression(inner ExternalStorage) ExternalStorage {
+	if _, ok := inner.(*withSuffixCompression); ok {
+		return inner
+	}
+	return &withSuffixCompression{ExternalStorage: inner}
+}
+
+func (w *withSuffixCompression) storageOf(name string) ExternalStorage {
+	return WithCompression(w.ExternalStorage, CompressTypeBySuffix(name))
+}
+
+func (w *withSuffixCompression) Create(ctx context.Context, name string) (ExternalFileWriter, error) {
+	return w.storageOf(name).Create(ctx, name)
+}
+
+func (w *withSuffixCompression) Open(ctx context.Context, path string) (ExternalFileReader, error) {
+	return w.storageOf(path).Open(ctx, path)
+}
+
+func (w *withSuffixCompression) WriteFile(ctx context.Context, name string, data []byte) error {
+	return w.storageOf(name).WriteFile(ctx, name, data)
+}
+
+func (w *withSuffixCompression) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	return w.storageOf(name).ReadFile(ctx, name)
+}
+
+// CompressedNames returns the names of the file compressed by each compression type, i.e.
+// name itself and name with the suffixes of the compression types.
+func CompressedNames(name string) []string {
+	names := make([]string, 0, len(compressSuffixes)+1)
+	for _, tp := range []CompressType{NoCompression, Zstd, Gzip} {
+		names = append(names, name+tp.Suffix())
+	}
+	return names
+}
+
+// FindCompressed returns the first existing one of CompressedNames(name), it returns false if
+// there's none.
+func FindCompressed(ctx context.Context, s ExternalStorage, name string) (string, bool, error) {
+	for _, candidate := range CompressedNames(name) {
+		exists, err := s.FileExists(ctx, candidate)
+		if err != nil {
+			return "", false, errors.Trace(err)
+		}
+		if exists {
+			return candidate, true, nil
+		}
+	}
+	return "", false, nil
+}
+
 type withCompression struct {
 	ExternalStorage
 	compressType CompressType
@@ -77,6 +202,8 @@ func (w *withCompression) ReadFile(ctx context.Context, name string) ([]byte, er
 	if err != nil {
 		return nil, err
 	}
+	// the zstd decoder is released by Close.
+	defer compressBf.Close()
 	return io.ReadAll(compressBf)
 }
 
@@ -3,6 +3,7 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"os"
@@ -41,3 +42,83 @@ func TestWithCompressReadWriteFile(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, content, string(newContent))
 }
+
+func TestWithSuffixCompression(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	local, err := NewLocalStorage(dir)
+	require.NoError(t, err)
+	storage := WithSuffixCompression(local)
+	require.Same(t, storage, WithSuffixCompression(storage))
+
+	content := []byte(strings.Repeat("hello,world!", 100))
+	for _, name := range []string{"catalog.json", "catalog.json.gz", "catalog.json.zst"} {
+		require.NoError(t, storage.WriteFile(ctx, name, content))
+		data, err := storage.ReadFile(ctx, name)
+		require.NoError(t, err)
+		require.Equal(t, content, data, name)
+
+		// the file is compressed by its suffix.
+		raw, err := local.ReadFile(ctx, name)
+		require.NoError(t, err)
+		tp := CompressTypeBySuffix(name)
+		if tp == NoCompression {
+			require.Equal(t, content, raw)
+			continue
+		}
+		require.Less(t, len(raw), len(content), name)
+		r, err := NewCompressReader(tp, bytes.NewReader(raw))
+		require.NoError(t, err)
+		data, err = io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, content, data, name)
+	}
+
+	// the streaming writer of the storage.
+	w, err := storage.Create(ctx, "progress.json.zst")
+	require.NoError(t, err)
+	_, err = w.Write(ctx, content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close(ctx))
+	r, err := storage.Open(ctx, "progress.json.zst")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, content, data)
+
+	name, ok, err := FindCompressed(ctx, storage, "catalog.json")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "catalog.json", name)
+	require.NoError(t, local.DeleteFile(ctx, "catalog.json"))
+	name, ok, err = FindCompressed(ctx, storage, "catalog.json")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "catalog.json.zst", name)
+	_, ok, err = FindCompressed(ctx, storage, "backup.checkpoint.json")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestCompressCodec(t *testing.T) {
+	for _, name := range []string{"none", "gzip", "ZSTD"} {
+		tp, err := ParseCompressType(name)
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		w := NewCompressWriter(tp, &buf)
+		_, err = w.Write([]byte("hello,world!"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		r, err := NewCompressReader(tp, &buf)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, "hello,world!", string(data))
+	}
+	_, err := ParseCompressType("lz4")
+	require.Error(t, err)
+	require.Equal(t, Gzip, CompressTypeBySuffix("a.csv.gz"))
+	require.Equal(t, NoCompression, CompressTypeBySuffix("a.csv"))
+}
@@ -0,0 +1,322 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// FailoverPolicy decides the order the endpoints of a FailoverStorage are used in.
+type FailoverPolicy string
+
+const (
+	// FailoverPolicyPrimary uses the endpoints in the order they are given,
+	// i.e. the first one is the primary and the others are its failovers.
+	FailoverPolicyPrimary FailoverPolicy = "primary"
+	// FailoverPolicyNearest uses the endpoints in the order of their latency.
+	FailoverPolicyNearest FailoverPolicy = "nearest"
+
+	// DefaultFailoverErrorThreshold is the number of consecutive errors of the active endpoint
+	// to fail over to the next one.
+	DefaultFailoverErrorThreshold = 3
+
+	// failoverProbeFile is the file checked to measure the latency of an endpoint.
+	failoverProbeFile = "backup.failover.probe"
+)
+
+// ParseFailoverPolicy parses the failover policy, an empty one is FailoverPolicyPrimary.
+func ParseFailoverPolicy(s string) (FailoverPolicy, error) {
+	switch p := FailoverPolicy(s); p {
+	case "":
+		return FailoverPolicyPrimary, nil
+	case FailoverPolicyPrimary, FailoverPolicyNearest:
+		return p, nil
+	default:
+		return "", errors.Annotatef(berrors.ErrInvalidArgument, "invalid failover policy %s, must be primary or nearest", s)
+	}
+}
+
+// StorageEndpoint is one of the storages a FailoverStorage writes to, e.g. a bucket in a region.
+type StorageEndpoint struct {
+	// Backend is sent to TiKV to write the files to the endpoint.
+	Backend *backuppb.StorageBackend
+	Storage ExternalStorage
+}
+
+// FailoverStorage is an ExternalStorage over several endpoints. The files are written to
+// the active endpoint, which fails over to the next one once it fails for a number of
+// consecutive times. The files are read from whichever endpoint holds them.
+type FailoverStorage struct {
+	endpoints []StorageEndpoint
+	threshold int
+
+	mu                sync.Mutex
+	active            int
+	consecutiveErrors int
+	failovers         int
+}
+
+// NewFailoverStorage creates a FailoverStorage over the endpoints ordered by the policy.
+func NewFailoverStorage(
+	ctx context.Context,
+	endpoints []StorageEndpoint,
+	policy FailoverPolicy,
+	threshold int,
+) (*FailoverStorage, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.Annotate(berrors.ErrStorageInvalidConfig, "no storage endpoint")
+	}
+	if threshold <= 0 {
+		threshold = DefaultFailoverErrorThreshold
+	}
+	endpoints = append([]StorageEndpoint{}, endpoints...)
+	if policy == FailoverPolicyNearest {
+		orderByLatency(ctx, endpoints)
+	}
+	log.Info("storage endpoints", zap.String("policy", string(policy)), zap.Strings("endpoints", endpointURIs(endpoints)))
+	return &FailoverStorage{endpoints: endpoints, threshold: threshold}, nil
+}
+
+// orderByLatency sorts the endpoints by the latency of checking a file, the unreachable ones go last.
+func orderByLatency(ctx context.Context, endpoints []StorageEndpoint) {
+	type probed struct {
+		endpoint StorageEndpoint
+		latency  time.Duration
+	}
+	probes := make([]probed, 0, len(endpoints))
+	for _, e := range endpoints {
+		start := time.Now()
+		_, err := e.Storage.FileExists(ctx, failoverProbeFile)
+		latency := time.Since(start)
+		if err != nil {
+			log.Warn("storage endpoint is unreachable", zap.String("endpoint", e.Storage.URI()), zap.Error(err))
+			latency = math.MaxInt64
+		}
+		probes = append(probes, probed{endpoint: e, latency: latency})
+	}
+	sort.SliceStable(probes, func(i, j int) bool { return probes[i].latency < probes[j].latency })
+	for i, p := range probes {
+		endpoints[i] = p.endpoint
+	}
+}
+
+func endpointURIs(endpoints []StorageEndpoint) []string {
+	uris := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		uris = append(uris, e.Storage.URI())
+	}
+	return uris
+}
+
+// Endpoints returns the endpoints in the order they are used.
+func (s *FailoverStorage) Endpoints() []StorageEndpoint {
+	return s.endpoints
+}
+
+// Active returns the index and the endpoint the files are written to.
+func (s *FailoverStorage) Active() (int, StorageEndpoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active, s.endpoints[s.active]
+}
+
+// Failovers returns the times the active endpoint has failed over.
+func (s *FailoverStorage) Failovers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failovers
+}
+
+// ReportError counts an error of writing to the endpoint, the active endpoint fails over to
+// the next one if it fails for consecutive times. The errors of the endpoints not active
+// any more are ignored, so concurrent writers fail over only once.
+func (s *FailoverStorage) ReportError(endpoint int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if endpoint != s.active || len(s.endpoints) == 1 {
+		return
+	}
+	s.consecutiveErrors++
+	if s.consecutiveErrors < s.threshold {
+		return
+	}
+	from := s.endpoints[s.active].Storage.URI()
+	s.active = (s.active + 1) % len(s.endpoints)
+	s.consecutiveErrors = 0
+	s.failovers++
+	log.Warn("storage endpoint fails over", zap.String("from", from),
+		zap.String("to", s.endpoints[s.active].Storage.URI()), zap.Error(err))
+}
+
+// ReportSuccess resets the consecutive errors of the endpoint.
+func (s *FailoverStorage) ReportSuccess(endpoint int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if endpoint == s.active {
+		s.consecutiveErrors = 0
+	}
+}
+
+// write runs fn on the active endpoint, and retries it on the same or the failed over endpoint
+// until it succeeds or every endpoint fails for threshold times.
+func (s *FailoverStorage) write(fn func(ExternalStorage) error) error {
+	var err error
+	for i := 0; i < s.threshold*len(s.endpoints); i++ {
+		idx, endpoint := s.Active()
+		if err = fn(endpoint.Storage); err == nil {
+			s.ReportSuccess(idx)
+			return nil
+		}
+		s.ReportError(idx, err)
+	}
+	return errors.Trace(err)
+}
+
+// locate returns the endpoint holding the file, the active endpoint goes first.
+// It returns the active one if no endpoint holds the file.
+func (s *FailoverStorage) locate(ctx context.Context, name string) (ExternalStorage, error) {
+	active, endpoint := s.Active()
+	exists, err := endpoint.Storage.FileExists(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if exists {
+		return endpoint.Storage, nil
+	}
+	for i, e := range s.endpoints {
+		if i == active {
+			continue
+		}
+		exists, err := e.Storage.FileExists(ctx, name)
+		if err != nil {
+			log.Warn("failed to check file in storage endpoint", zap.String("endpoint", e.Storage.URI()),
+				zap.String("file", name), zap.Error(err))
+			continue
+		}
+		if exists {
+			return e.Storage, nil
+		}
+	}
+	return endpoint.Storage, nil
+}
+
+// WriteFile implements ExternalStorage.
+func (s *FailoverStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	return s.write(func(es ExternalStorage) error {
+		return es.WriteFile(ctx, name, data)
+	})
+}
+
+// ReadFile implements ExternalStorage.
+func (s *FailoverStorage) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	es, err := s.locate(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return es.ReadFile(ctx, name)
+}
+
+// FileExists implements ExternalStorage.
+func (s *FailoverStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	es, err := s.locate(ctx, name)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return es.FileExists(ctx, name)
+}
+
+// DeleteFile implements ExternalStorage, the file is deleted from every endpoint holding it.
+func (s *FailoverStorage) DeleteFile(ctx context.Context, name string) error {
+	for _, e := range s.endpoints {
+		exists, err := e.Storage.FileExists(ctx, name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !exists {
+			continue
+		}
+		if err = e.Storage.DeleteFile(ctx, name); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Open implements ExternalStorage.
+func (s *FailoverStorage) Open(ctx context.Context, path string) (ExternalFileReader, error) {
+	es, err := s.locate(ctx, path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return es.Open(ctx, path)
+}
+
+// WalkDir implements ExternalStorage, it walks the files of all the endpoints.
+func (s *FailoverStorage) WalkDir(ctx context.Context, opt *WalkOption, fn func(path string, size int64) error) error {
+	visited := make(map[string]struct{})
+	for _, e := range s.endpoints {
+		err := e.Storage.WalkDir(ctx, opt, func(path string, size int64) error {
+			if _, ok := visited[path]; ok {
+				return nil
+			}
+			visited[path] = struct{}{}
+			return fn(path, size)
+		})
+		if err != nil {
+			return errors.Annotatef(err, "failed to walk storage endpoint %s", e.Storage.URI())
+		}
+	}
+	return nil
+}
+
+// URI implements ExternalStorage, it's the URI of the active endpoint.
+func (s *FailoverStorage) URI() string {
+	_, endpoint := s.Active()
+	return endpoint.Storage.URI()
+}
+
+// Create implements ExternalStorage.
+func (s *FailoverStorage) Create(ctx context.Context, path string) (ExternalFileWriter, error) {
+	var w ExternalFileWriter
+	err := s.write(func(es ExternalStorage) error {
+		var err error
+		w, err = es.Create(ctx, path)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return w, nil
+}
+
+// SetupLifecycle implements LifecycleSetter, the rule is set up for every endpoint.
+func (s *FailoverStorage) SetupLifecycle(ctx context.Context, expireDays int64) error {
+	for _, e := range s.endpoints {
+		if err := SetupLifecycle(ctx, e.Storage, expireDays); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
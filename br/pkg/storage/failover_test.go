@@ -0,0 +1,133 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// unavailableStorage fails the writes and checks as if its region is unavailable.
+type unavailableStorage struct {
+	ExternalStorage
+	down bool
+}
+
+func (s *unavailableStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	if s.down {
+		return errors.New("503 service unavailable")
+	}
+	return s.ExternalStorage.WriteFile(ctx, name, data)
+}
+
+func (s *unavailableStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	if s.down {
+		return false, errors.New("503 service unavailable")
+	}
+	return s.ExternalStorage.FileExists(ctx, name)
+}
+
+func newTestEndpoint(t *testing.T) (StorageEndpoint, *unavailableStorage) {
+	dir := t.TempDir()
+	local, err := NewLocalStorage(dir)
+	require.NoError(t, err)
+	s := &unavailableStorage{ExternalStorage: local}
+	backend := &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_Local{Local: &backuppb.Local{Path: dir}}}
+	return StorageEndpoint{Backend: backend, Storage: s}, s
+}
+
+func TestFailoverStorage(t *testing.T) {
+	ctx := context.Background()
+	primary, primaryStorage := newTestEndpoint(t)
+	secondary, _ := newTestEndpoint(t)
+
+	s, err := NewFailoverStorage(ctx, []StorageEndpoint{primary, secondary}, FailoverPolicyPrimary, 3)
+	require.NoError(t, err)
+	idx, active := s.Active()
+	require.Equal(t, 0, idx)
+	require.Equal(t, primary.Backend, active.Backend)
+	require.NoError(t, s.WriteFile(ctx, "a", []byte("a")))
+
+	// the write fails over to the secondary endpoint once the primary fails for 3 times.
+	primaryStorage.down = true
+	require.NoError(t, s.WriteFile(ctx, "b", []byte("b")))
+	idx, _ = s.Active()
+	require.Equal(t, 1, idx)
+	require.Equal(t, 1, s.Failovers())
+	exists, err := secondary.Storage.FileExists(ctx, "b")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// the files are read from whichever endpoint holds them.
+	primaryStorage.down = false
+	data, err := s.ReadFile(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), data)
+	data, err = s.ReadFile(ctx, "b")
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), data)
+	var names []string
+	require.NoError(t, s.WalkDir(ctx, &WalkOption{}, func(path string, _ int64) error {
+		names = append(names, path)
+		return nil
+	}))
+	sort.Strings(names)
+	require.Equal(t, []string{"a", "b"}, names)
+
+	// the errors of the endpoint not active any more are ignored.
+	for i := 0; i < 3; i++ {
+		s.ReportError(0, errors.New("late error"))
+	}
+	idx, _ = s.Active()
+	require.Equal(t, 1, idx)
+	s.ReportError(1, errors.New("error"))
+	s.ReportSuccess(1)
+	s.ReportError(1, errors.New("error"))
+	s.ReportError(1, errors.New("error"))
+	idx, _ = s.Active()
+	require.Equal(t, 1, idx)
+
+	require.NoError(t, s.DeleteFile(ctx, "b"))
+	exists, err = s.FileExists(ctx, "b")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestFailoverStorageNearest(t *testing.T) {
+	ctx := context.Background()
+	unreachable, unreachableStorage := newTestEndpoint(t)
+	unreachableStorage.down = true
+	reachable, _ := newTestEndpoint(t)
+
+	s, err := NewFailoverStorage(ctx, []StorageEndpoint{unreachable, reachable}, FailoverPolicyNearest, 0)
+	require.NoError(t, err)
+	_, active := s.Active()
+	require.Equal(t, reachable.Backend, active.Backend)
+
+	_, err = NewFailoverStorage(ctx, nil, FailoverPolicyPrimary, 0)
+	require.True(t, berrors.Is(err, berrors.ErrStorageInvalidConfig))
+
+	policy, err := ParseFailoverPolicy("")
+	require.NoError(t, err)
+	require.Equal(t, FailoverPolicyPrimary, policy)
+	_, err = ParseFailoverPolicy("random")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
@@ -3,11 +3,16 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/pingcap/errors"
@@ -17,8 +22,10 @@ import (
 	berrors "github.com/tikv/migration/br/pkg/errors"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
 const (
@@ -26,6 +33,16 @@ const (
 	gcsStorageClassOption = "gcs.storage-class"
 	gcsPredefinedACL      = "gcs.predefined-acl"
 	gcsCredentialsFile    = "gcs.credentials-file"
+	gcsChunkSizeOption    = "gcs.chunk-size"
+
+	// defaultGCSChunkSize is the size of the chunks of the resumable uploads.
+	defaultGCSChunkSize = 16 * 1024 * 1024
+	// gcsDefaultEndpoint is the base URL of the GCS JSON API.
+	gcsDefaultEndpoint = "https://storage.googleapis.com/storage/v1/"
+	gcsDeleteAction    = "Delete"
+	// gcsWriteRetryTimes is the times to retry a write rejected as the service is unavailable.
+	gcsWriteRetryTimes   = 5
+	gcsWriteRetryBackoff = time.Second
 )
 
 // GCSBackendOptions are options for configuration the GCS storage.
@@ -34,6 +51,9 @@ type GCSBackendOptions struct {
 	StorageClass    string `json:"storage-class" toml:"storage-class"`
 	PredefinedACL   string `json:"predefined-acl" toml:"predefined-acl"`
 	CredentialsFile string `json:"credentials-file" toml:"credentials-file"`
+	// ChunkSize is the size of the chunks of the resumable uploads, it's used by BR
+	// only and isn't sent to TiKV.
+	ChunkSize int `json:"chunk-size" toml:"chunk-size"`
 }
 
 func (options *GCSBackendOptions) apply(gcs *backuppb.GCS) error {
@@ -57,10 +77,12 @@ func defineGCSFlags(flags *pflag.FlagSet) {
 	flags.String(gcsStorageClassOption, "", "(experimental) Specify the GCS storage class for objects")
 	flags.String(gcsPredefinedACL, "", "(experimental) Specify the GCS predefined acl for objects")
 	flags.String(gcsCredentialsFile, "", "(experimental) Set the GCS credentials file path")
+	flags.Int(gcsChunkSizeOption, defaultGCSChunkSize, "(experimental) Set the chunk size in bytes of the GCS resumable uploads")
 	_ = flags.MarkHidden(gcsEndpointOption)
 	_ = flags.MarkHidden(gcsStorageClassOption)
 	_ = flags.MarkHidden(gcsPredefinedACL)
 	_ = flags.MarkHidden(gcsCredentialsFile)
+	_ = flags.MarkHidden(gcsChunkSizeOption)
 }
 
 func (options *GCSBackendOptions) parseFromFlags(flags *pflag.FlagSet) error {
@@ -84,12 +106,19 @@ func (options *GCSBackendOptions) parseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+
+	options.ChunkSize, err = flags.GetInt(gcsChunkSizeOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	return nil
 }
 
 type gcsStorage struct {
-	gcs    *backuppb.GCS
-	bucket *storage.BucketHandle
+	gcs       *backuppb.GCS
+	bucket    *storage.BucketHandle
+	chunkSize int
+	clientOps []option.ClientOption
 }
 
 // DeleteFile delete the file in storage
@@ -99,21 +128,154 @@ func (s *gcsStorage) DeleteFile(ctx context.Context, name string) error {
 	return errors.Trace(err)
 }
 
+// gcsLifecycleRule is the part of a lifecycle rule of the GCS JSON API we care about.
+// The pinned storage client does not know `matchesPrefix`, so the rules are
+// read and written by the JSON API directly, and kept raw to preserve the other fields.
+type gcsLifecycleRule struct {
+	Action struct {
+		Type string `json:"type"`
+	} `json:"action"`
+	Condition struct {
+		Age           int64    `json:"age,omitempty"`
+		MatchesPrefix []string `json:"matchesPrefix,omitempty"`
+	} `json:"condition"`
+}
+
+type gcsBucketLifecycle struct {
+	Lifecycle struct {
+		Rule []json.RawMessage `json:"rule"`
+	} `json:"lifecycle"`
+}
+
+// SetupLifecycle implements LifecycleSetter.
+// GCS rules have no ID, the rule deleting objects under the same prefix is replaced.
+func (s *gcsStorage) SetupLifecycle(ctx context.Context, expireDays int64) error {
+	prefix := strings.TrimSuffix(s.gcs.Prefix, "/")
+	if len(prefix) == 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "refuse to set up lifecycle rule for the whole bucket")
+	}
+	prefix += "/"
+	client, _, err := htransport.NewClient(ctx, s.clientOps...)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	endpoint := s.gcs.Endpoint
+	if endpoint == "" {
+		endpoint = gcsDefaultEndpoint
+	}
+	bucketURL := strings.TrimSuffix(endpoint, "/") + "/b/" + url.PathEscape(s.gcs.Bucket) + "?fields=lifecycle"
+
+	var attrs gcsBucketLifecycle
+	if err := doGCSRequest(ctx, client, http.MethodGet, bucketURL, nil, &attrs); err != nil {
+		return errors.Annotatef(err, "failed to get lifecycle of bucket %s", s.gcs.Bucket)
+	}
+	rules := make([]json.RawMessage, 0, len(attrs.Lifecycle.Rule)+1)
+	for _, raw := range attrs.Lifecycle.Rule {
+		var rule gcsLifecycleRule
+		if err := json.Unmarshal(raw, &rule); err != nil {
+			return errors.Annotatef(err, "failed to parse lifecycle rule of bucket %s", s.gcs.Bucket)
+		}
+		matches := rule.Condition.MatchesPrefix
+		if rule.Action.Type == gcsDeleteAction && len(matches) == 1 && matches[0] == prefix {
+			continue
+		}
+		rules = append(rules, raw)
+	}
+	var rule gcsLifecycleRule
+	rule.Action.Type = gcsDeleteAction
+	rule.Condition.Age = expireDays
+	rule.Condition.MatchesPrefix = []string{prefix}
+	raw, err := json.Marshal(&rule)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	attrs.Lifecycle.Rule = append(rules, raw)
+	if err := doGCSRequest(ctx, client, http.MethodPatch, bucketURL, &attrs, nil); err != nil {
+		return errors.Annotatef(err, "failed to update lifecycle of bucket %s", s.gcs.Bucket)
+	}
+	log.Info("gcs lifecycle rule set up", zap.String("bucket", s.gcs.Bucket),
+		zap.String("prefix", prefix), zap.Int64("expire-days", expireDays))
+	return nil
+}
+
+// doGCSRequest sends a request with the JSON body `in` to the GCS JSON API,
+// and decodes the response into `out` if it isn't nil.
+func doGCSRequest(ctx context.Context, client *http.Client, method, reqURL string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return errors.Trace(err)
+	}
+	if out == nil {
+		return nil
+	}
+	return errors.Trace(json.NewDecoder(resp.Body).Decode(out))
+}
+
 func (s *gcsStorage) objectName(name string) string {
 	return path.Join(s.gcs.Prefix, name)
 }
 
-// WriteFile writes data to a file to storage.
-func (s *gcsStorage) WriteFile(ctx context.Context, name string, data []byte) error {
-	object := s.objectName(name)
+// newWriter creates a writer of the object, which uploads the object in chunks
+// of chunkSize by a resumable upload, a chunk failed by a transient error is retried.
+func (s *gcsStorage) newWriter(ctx context.Context, object string) *storage.Writer {
 	wc := s.bucket.Object(object).NewWriter(ctx)
 	wc.StorageClass = s.gcs.StorageClass
 	wc.PredefinedACL = s.gcs.PredefinedAcl
-	_, err := wc.Write(data)
-	if err != nil {
-		return errors.Trace(err)
+	wc.ChunkSize = s.chunkSize
+	return wc
+}
+
+// WriteFile writes data to a file to storage.
+func (s *gcsStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	defer observeWriteFile("gcs", time.Now())
+	object := s.objectName(name)
+	var err error
+	for i := 0; i < gcsWriteRetryTimes; i++ {
+		if i > 0 {
+			log.Warn("gcs service unavailable, retry writing file",
+				zap.String("bucket", s.gcs.Bucket), zap.String("key", object), zap.Int("retry", i), zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return errors.Trace(ctx.Err())
+			case <-time.After(gcsWriteRetryBackoff * time.Duration(i)):
+			}
+		}
+		wc := s.newWriter(ctx, object)
+		if _, err = wc.Write(data); err == nil {
+			err = wc.Close()
+		} else {
+			_ = wc.Close()
+		}
+		if !isGCSUnavailable(err) {
+			break
+		}
 	}
-	return wc.Close()
+	return errors.Trace(err)
+}
+
+// isGCSUnavailable checks whether err is returned as the service is unavailable temporarily.
+func isGCSUnavailable(err error) bool {
+	apiErr, ok := errors.Cause(err).(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusServiceUnavailable
 }
 
 // ReadFile reads the file from the storage and returns the contents.
@@ -152,6 +314,15 @@ func (s *gcsStorage) FileExists(ctx context.Context, name string) (bool, error)
 	return true, nil
 }
 
+// ModTime implements ModTimeReader.
+func (s *gcsStorage) ModTime(ctx context.Context, name string) (time.Time, error) {
+	attrs, err := s.bucket.Object(s.objectName(name)).Attrs(ctx)
+	if err != nil {
+		return time.Time{}, errors.Trace(err)
+	}
+	return attrs.Updated, nil
+}
+
 // Open a Reader by file path.
 func (s *gcsStorage) Open(ctx context.Context, path string) (ExternalFileReader, error) {
 	object := s.objectName(path)
@@ -221,14 +392,18 @@ func (s *gcsStorage) URI() string {
 
 // Create implements ExternalStorage interface.
 func (s *gcsStorage) Create(ctx context.Context, name string) (ExternalFileWriter, error) {
-	object := s.objectName(name)
-	wc := s.bucket.Object(object).NewWriter(ctx)
-	wc.StorageClass = s.gcs.StorageClass
-	wc.PredefinedACL = s.gcs.PredefinedAcl
+	wc := s.newWriter(ctx, s.objectName(name))
 	return newFlushStorageWriter(wc, &emptyFlusher{}, wc), nil
 }
 
 func newGCSStorage(ctx context.Context, gcs *backuppb.GCS, opts *ExternalStorageOptions) (*gcsStorage, error) {
+	chunkSize := opts.GCSChunkSize
+	if chunkSize < 0 {
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "invalid gcs chunk size %d", chunkSize)
+	}
+	if chunkSize == 0 {
+		chunkSize = defaultGCSChunkSize
+	}
 	var clientOps []option.ClientOption
 	if opts.NoCredentials {
 		clientOps = append(clientOps, option.WithoutAuthentication())
@@ -283,7 +458,7 @@ func newGCSStorage(ctx context.Context, gcs *backuppb.GCS, opts *ExternalStorage
 		// so we need find sst in slash directory
 		gcs.Prefix += "//"
 	}
-	return &gcsStorage{gcs: gcs, bucket: bucket}, nil
+	return &gcsStorage{gcs: gcs, bucket: bucket, chunkSize: chunkSize, clientOps: clientOps}, nil
 }
 
 func hasSSTFiles(ctx context.Context, bucket *storage.BucketHandle, prefix string) bool {
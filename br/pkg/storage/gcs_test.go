@@ -4,14 +4,21 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
 	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/pingcap/errors"
 	backuppb "github.com/pingcap/kvproto/pkg/brpb"
 	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
 )
 
 func TestGCS(t *testing.T) {
@@ -319,3 +326,70 @@ func TestNewGCSStorage(t *testing.T) {
 		require.Equal(t, "a/b/x", s.objectName("x"))
 	}
 }
+
+func TestGCSResumableUpload(t *testing.T) {
+	ctx := context.Background()
+
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{NoListener: true})
+	require.NoError(t, err)
+	bucketName := "testbucket"
+	server.CreateBucketWithOpts(fakestorage.CreateBucketOpts{Name: bucketName})
+
+	_, err = newGCSStorage(ctx, &backuppb.GCS{Bucket: bucketName, CredentialsBlob: "FakeCredentials"},
+		&ExternalStorageOptions{HTTPClient: server.HTTPClient(), GCSChunkSize: -1})
+	require.True(t, berrors.Is(err, berrors.ErrStorageInvalidConfig))
+
+	stg, err := newGCSStorage(ctx, &backuppb.GCS{Bucket: bucketName, Prefix: "a", CredentialsBlob: "FakeCredentials"},
+		&ExternalStorageOptions{HTTPClient: server.HTTPClient(), GCSChunkSize: 256 * 1024})
+	require.NoError(t, err)
+	require.Equal(t, 256*1024, stg.chunkSize)
+
+	// the fake server of this version can't serve resumable uploads,
+	// so only check the writer is set up to upload in chunks.
+	w := stg.newWriter(ctx, stg.objectName("large.sst"))
+	require.Equal(t, 256*1024, w.ChunkSize)
+	require.Equal(t, "a/large.sst", w.ObjectAttrs.Name)
+
+	require.True(t, isGCSUnavailable(errors.Trace(&googleapi.Error{Code: http.StatusServiceUnavailable})))
+	require.False(t, isGCSUnavailable(&googleapi.Error{Code: http.StatusForbidden}))
+	require.False(t, isGCSUnavailable(nil))
+}
+
+func TestGCSSetupLifecycle(t *testing.T) {
+	ctx := context.Background()
+	var patched map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/b/bucket", r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = io.WriteString(w, `{"lifecycle": {"rule": [
+				{"action": {"type": "Delete"}, "condition": {"age": 30, "matchesPrefix": ["backup/"]}},
+				{"action": {"type": "SetStorageClass", "storageClass": "COLDLINE"}, "condition": {"age": 10, "matchesPrefix": ["other/"]}}
+			]}}`)
+		case http.MethodPatch:
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&patched))
+			_, _ = io.WriteString(w, `{}`)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	s := &gcsStorage{
+		gcs:       &backuppb.GCS{Bucket: "bucket", Prefix: "backup", Endpoint: server.URL},
+		clientOps: []option.ClientOption{option.WithoutAuthentication()},
+	}
+	require.NoError(t, SetupLifecycle(ctx, s, 7))
+	rules := patched["lifecycle"].(map[string]interface{})["rule"].([]interface{})
+	require.Len(t, rules, 2)
+	// the rule of other prefix is kept with the fields unknown to us.
+	other := rules[0].(map[string]interface{})
+	require.Equal(t, "COLDLINE", other["action"].(map[string]interface{})["storageClass"])
+	// the old rule of the same prefix is replaced.
+	cond := rules[1].(map[string]interface{})["condition"].(map[string]interface{})
+	require.Equal(t, float64(7), cond["age"])
+	require.Equal(t, []interface{}{"backup/"}, cond["matchesPrefix"])
+
+	s.gcs.Prefix = "/"
+	require.True(t, berrors.Is(SetupLifecycle(ctx, s, 7), berrors.ErrInvalidArgument))
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"time"
 
 	"github.com/pingcap/errors"
 	berrors "github.com/tikv/migration/br/pkg/errors"
@@ -55,6 +56,7 @@ func dfsCommand(args ...string) (*exec.Cmd, error) {
 
 // WriteFile writes a complete file to storage, similar to os.WriteFile
 func (s *HDFSStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	defer observeWriteFile("hdfs", time.Now())
 	filePath := fmt.Sprintf("%s/%s", s.remote, name)
 	cmd, err := dfsCommand("-put", "-", filePath)
 	if err != nil {
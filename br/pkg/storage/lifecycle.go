@@ -0,0 +1,53 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// LifecycleSetter is implemented by the storages whose provider can expire
+// objects by bucket lifecycle rules.
+type LifecycleSetter interface {
+	// SetupLifecycle creates or updates the rule which expires the objects
+	// under the prefix of the storage after `expireDays` days.
+	// The rules of other prefixes are kept as is.
+	SetupLifecycle(ctx context.Context, expireDays int64) error
+}
+
+// SetupLifecycle sets up the lifecycle rule of the backup prefix for the storage.
+// It returns ErrUnsupportedOperation if the storage does not support lifecycle rules.
+func SetupLifecycle(ctx context.Context, s ExternalStorage, expireDays int64) error {
+	if expireDays <= 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid retention days %d", expireDays)
+	}
+	setter, ok := s.(LifecycleSetter)
+	if !ok {
+		return errors.Annotatef(berrors.ErrUnsupportedOperation, "storage %s does not support lifecycle rules", s.URI())
+	}
+	return errors.Trace(setter.SetupLifecycle(ctx, expireDays))
+}
+
+// lifecycleRuleID returns a stable rule ID for the prefix,
+// so that setting up the same prefix again replaces the old rule.
+func lifecycleRuleID(prefix string) string {
+	sum := sha256.Sum256([]byte(prefix))
+	return "tikv-br-" + hex.EncodeToString(sum[:8])
+}
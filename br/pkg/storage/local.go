@@ -7,6 +7,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/pingcap/errors"
 )
@@ -32,6 +33,7 @@ func (l *LocalStorage) DeleteFile(ctx context.Context, name string) error {
 
 // WriteFile writes data to a file to storage.
 func (l *LocalStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	defer observeWriteFile("local", time.Now())
 	path := filepath.Join(l.base, name)
 	return os.WriteFile(path, data, localFilePerm)
 	// the backup meta file _is_ intended to be world-readable.
@@ -49,6 +51,15 @@ func (l *LocalStorage) FileExists(ctx context.Context, name string) (bool, error
 	return pathExists(path)
 }
 
+// ModTime implements ModTimeReader.
+func (l *LocalStorage) ModTime(_ context.Context, name string) (time.Time, error) {
+	info, err := os.Stat(filepath.Join(l.base, name))
+	if err != nil {
+		return time.Time{}, errors.Trace(err)
+	}
+	return info.ModTime(), nil
+}
+
 // WalkDir traverse all the files in a dir.
 //
 // fn is the function called for each regular file visited by WalkDir.
@@ -8,8 +8,10 @@ import (
 	"path/filepath"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
 )
 
 func TestDeleteFile(t *testing.T) {
@@ -39,6 +41,24 @@ func TestDeleteFile(t *testing.T) {
 	require.Equal(t, false, ret)
 }
 
+func TestLocalModTime(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewLocalStorage(dir)
+	require.NoError(t, err)
+	require.NoError(t, store.WriteFile(ctx, "a.sst", []byte("a")))
+	modTime := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "a.sst"), modTime, modTime))
+
+	got, err := ModTime(ctx, store, "a.sst")
+	require.NoError(t, err)
+	require.True(t, modTime.Equal(got))
+	_, err = ModTime(ctx, store, "b.sst")
+	require.Error(t, err)
+	_, err = ModTime(ctx, newNoopStorage(), "a.sst")
+	require.True(t, berrors.Is(err, berrors.ErrUnsupportedOperation))
+}
+
 func TestWalkDirWithSoftLinkFile(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		// skip the test on windows. typically windows users don't have symlink permission.
@@ -89,3 +109,15 @@ func TestWalkDirWithSoftLinkFile(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 2, i)
 }
+
+func TestLocalFreeSpace(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("checking the free space isn't supported on windows")
+	}
+	free, err := LocalFreeSpace(t.TempDir())
+	require.NoError(t, err)
+	require.Greater(t, free, uint64(0))
+
+	_, err = LocalFreeSpace(filepath.Join(t.TempDir(), "not-exist"))
+	require.Error(t, err)
+}
@@ -18,3 +18,12 @@ func mkdirAll(base string) error {
 	syscall.Umask(mask)
 	return errors.Trace(err)
 }
+
+// LocalFreeSpace returns the bytes available to the user in the file system of the path.
+func LocalFreeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -7,8 +7,16 @@ package storage
 
 import (
 	"os"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
 )
 
 func mkdirAll(base string) error {
 	return os.MkdirAll(base, localDirPerm)
 }
+
+// LocalFreeSpace returns the bytes available to the user in the file system of the path.
+func LocalFreeSpace(path string) (uint64, error) {
+	return 0, errors.Annotate(berrors.ErrUnsupportedOperation, "checking the free space isn't supported on windows")
+}
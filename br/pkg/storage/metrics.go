@@ -0,0 +1,39 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var writeFileHistogram = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "tikv_br",
+		Subsystem: "storage",
+		Name:      "write_file_seconds",
+		Help:      "The latency of writing a file to the external storage by BR.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 16),
+	}, []string{"type"})
+
+func init() { // nolint:gochecknoinits
+	prometheus.MustRegister(writeFileHistogram)
+}
+
+// observeWriteFile observes the latency of writing a file to the storage of the type since start.
+func observeWriteFile(typ string, start time.Time) {
+	writeFileHistogram.WithLabelValues(typ).Observe(time.Since(start).Seconds())
+}
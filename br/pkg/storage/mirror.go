@@ -0,0 +1,294 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// MirrorPolicy decides how a MirrorStorage handles the failures of writing to a mirror.
+type MirrorPolicy string
+
+const (
+	// MirrorPolicyRequired fails the writes once any mirror fails, so every mirror holds a complete copy.
+	MirrorPolicyRequired MirrorPolicy = "required"
+	// MirrorPolicyBestEffort drops the failed mirror, the writes go on with the primary and the other mirrors.
+	MirrorPolicyBestEffort MirrorPolicy = "best-effort"
+)
+
+// ParseMirrorPolicy parses the mirror policy, an empty one is MirrorPolicyRequired.
+func ParseMirrorPolicy(s string) (MirrorPolicy, error) {
+	switch p := MirrorPolicy(s); p {
+	case "":
+		return MirrorPolicyRequired, nil
+	case MirrorPolicyRequired, MirrorPolicyBestEffort:
+		return p, nil
+	default:
+		return "", errors.Annotatef(berrors.ErrInvalidArgument, "invalid mirror policy %s, must be required or best-effort", s)
+	}
+}
+
+// MirrorStorage is an ExternalStorage writing every file to the primary endpoint and all its
+// mirrors, e.g. an onsite and an offsite copy of the backup. The files are read from the primary.
+// The files written by TiKV only go to the primary, and are copied to the mirrors by Mirror.
+type MirrorStorage struct {
+	primary StorageEndpoint
+	mirrors []StorageEndpoint
+	policy  MirrorPolicy
+
+	mu sync.Mutex
+	// dropped are the errors of the mirrors dropped by MirrorPolicyBestEffort.
+	dropped map[int]error
+}
+
+// NewMirrorStorage creates a MirrorStorage over the primary endpoint and its mirrors.
+func NewMirrorStorage(primary StorageEndpoint, mirrors []StorageEndpoint, policy MirrorPolicy) (*MirrorStorage, error) {
+	if len(mirrors) == 0 {
+		return nil, errors.Annotate(berrors.ErrStorageInvalidConfig, "no storage mirror")
+	}
+	log.Info("storage mirrors", zap.String("primary", primary.Storage.URI()),
+		zap.Strings("mirrors", endpointURIs(mirrors)), zap.String("policy", string(policy)))
+	return &MirrorStorage{
+		primary: primary,
+		mirrors: append([]StorageEndpoint{}, mirrors...),
+		policy:  policy,
+		dropped: make(map[int]error),
+	}, nil
+}
+
+// Primary returns the endpoint the files are read from, which TiKV writes to.
+func (s *MirrorStorage) Primary() StorageEndpoint {
+	return s.primary
+}
+
+// Dropped returns the URIs of the mirrors dropped on their failures.
+func (s *MirrorStorage) Dropped() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uris := make([]string, 0, len(s.dropped))
+	for i, e := range s.mirrors {
+		if _, ok := s.dropped[i]; ok {
+			uris = append(uris, e.Storage.URI())
+		}
+	}
+	return uris
+}
+
+// activeMirrors returns the indexes of the mirrors not dropped.
+func (s *MirrorStorage) activeMirrors() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active := make([]int, 0, len(s.mirrors))
+	for i := range s.mirrors {
+		if _, ok := s.dropped[i]; !ok {
+			active = append(active, i)
+		}
+	}
+	return active
+}
+
+// reportError handles the error of writing to the mirror by the policy, it returns the error
+// to fail the write with, which is nil if the mirror is dropped.
+func (s *MirrorStorage) reportError(mirror int, err error) error {
+	uri := s.mirrors[mirror].Storage.URI()
+	if s.policy != MirrorPolicyBestEffort {
+		return errors.Annotatef(err, "failed to write to storage mirror %s", uri)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.dropped[mirror]; !ok {
+		s.dropped[mirror] = err
+		log.Warn("storage mirror is dropped, it doesn't hold a complete backup", zap.String("mirror", uri), zap.Error(err))
+	}
+	return nil
+}
+
+// fanOut runs fn on the primary, and then on the mirrors concurrently.
+func (s *MirrorStorage) fanOut(fn func(ExternalStorage) error) error {
+	if err := fn(s.primary.Storage); err != nil {
+		return errors.Trace(err)
+	}
+	active := s.activeMirrors()
+	errs := make([]error, len(active))
+	var wg sync.WaitGroup
+	for i, mirror := range active {
+		wg.Add(1)
+		go func(i, mirror int) {
+			defer wg.Done()
+			if err := fn(s.mirrors[mirror].Storage); err != nil {
+				errs[i] = s.reportError(mirror, err)
+			}
+		}(i, mirror)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Mirror copies the files from the primary to the mirrors, which are the files written to the
+// primary by TiKV. At most concurrency files are copied at the same time.
+func (s *MirrorStorage) Mirror(ctx context.Context, names []string, concurrency int) error {
+	eg, ectx := errgroup.WithContext(ctx)
+	if concurrency > 0 {
+		eg.SetLimit(concurrency)
+	}
+	for _, name := range names {
+		name := name
+		eg.Go(func() error {
+			data, err := s.primary.Storage.ReadFile(ectx, name)
+			if err != nil {
+				return errors.Annotatef(err, "failed to read %s to mirror", name)
+			}
+			for _, mirror := range s.activeMirrors() {
+				if err = s.mirrors[mirror].Storage.WriteFile(ectx, name, data); err != nil {
+					if err = s.reportError(mirror, err); err != nil {
+						return errors.Trace(err)
+					}
+				}
+			}
+			return nil
+		})
+	}
+	return errors.Trace(eg.Wait())
+}
+
+// WriteFile implements ExternalStorage.
+func (s *MirrorStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	return s.fanOut(func(es ExternalStorage) error {
+		return es.WriteFile(ctx, name, data)
+	})
+}
+
+// ReadFile implements ExternalStorage.
+func (s *MirrorStorage) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	return s.primary.Storage.ReadFile(ctx, name)
+}
+
+// FileExists implements ExternalStorage.
+func (s *MirrorStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	return s.primary.Storage.FileExists(ctx, name)
+}
+
+// DeleteFile implements ExternalStorage, the file is deleted from the mirrors holding it as well.
+func (s *MirrorStorage) DeleteFile(ctx context.Context, name string) error {
+	return s.fanOut(func(es ExternalStorage) error {
+		exists, err := es.FileExists(ctx, name)
+		if err != nil || !exists {
+			return errors.Trace(err)
+		}
+		return es.DeleteFile(ctx, name)
+	})
+}
+
+// Open implements ExternalStorage.
+func (s *MirrorStorage) Open(ctx context.Context, path string) (ExternalFileReader, error) {
+	return s.primary.Storage.Open(ctx, path)
+}
+
+// WalkDir implements ExternalStorage, it walks the files of the primary.
+func (s *MirrorStorage) WalkDir(ctx context.Context, opt *WalkOption, fn func(path string, size int64) error) error {
+	return s.primary.Storage.WalkDir(ctx, opt, fn)
+}
+
+// URI implements ExternalStorage, it's the URI of the primary.
+func (s *MirrorStorage) URI() string {
+	return s.primary.Storage.URI()
+}
+
+// Create implements ExternalStorage, the file is written to the mirrors as well.
+func (s *MirrorStorage) Create(ctx context.Context, path string) (ExternalFileWriter, error) {
+	w := &mirrorWriter{storage: s, writers: make(map[int]ExternalFileWriter)}
+	var err error
+	if w.primary, err = s.primary.Storage.Create(ctx, path); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, mirror := range s.activeMirrors() {
+		mw, err := s.mirrors[mirror].Storage.Create(ctx, path)
+		if err != nil {
+			if err = s.reportError(mirror, err); err != nil {
+				return nil, errors.Trace(err)
+			}
+			continue
+		}
+		w.writers[mirror] = mw
+	}
+	return w, nil
+}
+
+// SetupLifecycle implements LifecycleSetter, the rule is set up for the primary and every mirror.
+func (s *MirrorStorage) SetupLifecycle(ctx context.Context, expireDays int64) error {
+	if err := SetupLifecycle(ctx, s.primary.Storage, expireDays); err != nil {
+		return errors.Trace(err)
+	}
+	for _, e := range s.mirrors {
+		if err := SetupLifecycle(ctx, e.Storage, expireDays); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// mirrorWriter writes a file to the primary and the mirrors, the writers of the dropped
+// mirrors are abandoned.
+type mirrorWriter struct {
+	storage *MirrorStorage
+	primary ExternalFileWriter
+	writers map[int]ExternalFileWriter
+}
+
+func (w *mirrorWriter) each(fn func(ExternalFileWriter) error) error {
+	if err := fn(w.primary); err != nil {
+		return errors.Trace(err)
+	}
+	for mirror, mw := range w.writers {
+		if err := fn(mw); err != nil {
+			delete(w.writers, mirror)
+			if err = w.storage.reportError(mirror, err); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
+}
+
+// Write implements ExternalFileWriter.
+func (w *mirrorWriter) Write(ctx context.Context, p []byte) (int, error) {
+	err := w.each(func(fw ExternalFileWriter) error {
+		_, err := fw.Write(ctx, p)
+		return err
+	})
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return len(p), nil
+}
+
+// Close implements ExternalFileWriter.
+func (w *mirrorWriter) Close(ctx context.Context) error {
+	return w.each(func(fw ExternalFileWriter) error {
+		return fw.Close(ctx)
+	})
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+func requireFile(t *testing.T, s ExternalStorage, name string, expected string) {
+	data, err := s.ReadFile(context.Background(), name)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(data))
+}
+
+func TestMirrorStorage(t *testing.T) {
+	ctx := context.Background()
+	primary, _ := newTestEndpoint(t)
+	onsite, _ := newTestEndpoint(t)
+	offsite, offsiteStorage := newTestEndpoint(t)
+
+	s, err := NewMirrorStorage(primary, []StorageEndpoint{onsite, offsite}, MirrorPolicyRequired)
+	require.NoError(t, err)
+	require.Equal(t, primary.Storage.URI(), s.URI())
+
+	// the files are written to every mirror.
+	require.NoError(t, s.WriteFile(ctx, "backupmeta", []byte("meta")))
+	w, err := s.Create(ctx, "backup.lock")
+	require.NoError(t, err)
+	_, err = w.Write(ctx, []byte("lock"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close(ctx))
+	for _, e := range []StorageEndpoint{primary, onsite, offsite} {
+		requireFile(t, e.Storage, "backupmeta", "meta")
+		requireFile(t, e.Storage, "backup.lock", "lock")
+	}
+
+	// the files written to the primary by TiKV are copied to the mirrors.
+	require.NoError(t, primary.Storage.WriteFile(ctx, "1.sst", []byte("sst1")))
+	require.NoError(t, primary.Storage.WriteFile(ctx, "2.sst", []byte("sst2")))
+	require.NoError(t, s.Mirror(ctx, []string{"1.sst", "2.sst"}, 1))
+	requireFile(t, offsite.Storage, "1.sst", "sst1")
+	requireFile(t, onsite.Storage, "2.sst", "sst2")
+
+	require.NoError(t, s.DeleteFile(ctx, "1.sst"))
+	exists, err := onsite.Storage.FileExists(ctx, "1.sst")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// a required mirror fails the writes.
+	offsiteStorage.down = true
+	require.Error(t, s.WriteFile(ctx, "3.sst", []byte("sst3")))
+	require.Empty(t, s.Dropped())
+
+	_, err = NewMirrorStorage(primary, nil, MirrorPolicyRequired)
+	require.True(t, berrors.Is(err, berrors.ErrStorageInvalidConfig))
+}
+
+func TestMirrorStorageBestEffort(t *testing.T) {
+	ctx := context.Background()
+	primary, primaryStorage := newTestEndpoint(t)
+	onsite, _ := newTestEndpoint(t)
+	offsite, offsiteStorage := newTestEndpoint(t)
+
+	s, err := NewMirrorStorage(primary, []StorageEndpoint{onsite, offsite}, MirrorPolicyBestEffort)
+	require.NoError(t, err)
+
+	// the failed mirror is dropped and never written to again.
+	offsiteStorage.down = true
+	require.NoError(t, s.WriteFile(ctx, "a", []byte("a")))
+	require.Equal(t, []string{offsite.Storage.URI()}, s.Dropped())
+	offsiteStorage.down = false
+	require.NoError(t, s.WriteFile(ctx, "b", []byte("b")))
+	requireFile(t, onsite.Storage, "b", "b")
+	exists, err := offsite.Storage.FileExists(ctx, "b")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// the failures of the primary always fail the writes.
+	primaryStorage.down = true
+	require.Error(t, s.WriteFile(ctx, "c", []byte("c")))
+
+	policy, err := ParseMirrorPolicy("")
+	require.NoError(t, err)
+	require.Equal(t, MirrorPolicyRequired, policy)
+	_, err = ParseMirrorPolicy("quorum")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
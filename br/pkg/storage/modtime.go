@@ -0,0 +1,41 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// ModTimeReader is implemented by the storages which can tell when the files are last modified.
+type ModTimeReader interface {
+	// ModTime returns the time the file is last modified.
+	ModTime(ctx context.Context, name string) (time.Time, error)
+}
+
+// ModTime returns the time the file of the storage is last modified.
+// It returns ErrUnsupportedOperation if the storage can't tell it.
+func ModTime(ctx context.Context, s ExternalStorage, name string) (time.Time, error) {
+	reader, ok := s.(ModTimeReader)
+	if !ok {
+		return time.Time{}, errors.Annotatef(berrors.ErrUnsupportedOperation,
+			"storage %s does not support the modification time of the files", s.URI())
+	}
+	modTime, err := reader.ModTime(ctx, name)
+	return modTime, errors.Trace(err)
+}
@@ -4,6 +4,7 @@ package storage
 
 import (
 	"net/url"
+	"path"
 	"path/filepath"
 	"reflect"
 	"strconv"
@@ -20,6 +21,8 @@ type BackendOptions struct {
 	S3     S3BackendOptions     `json:"s3" toml:"s3"`
 	GCS    GCSBackendOptions    `json:"gcs" toml:"gcs"`
 	Azblob AzblobBackendOptions `json:"azblob" toml:"azblob"`
+	SFTP   SFTPBackendOptions   `json:"sftp" toml:"sftp"`
+	HDFS   HDFSBackendOptions   `json:"hdfs" toml:"hdfs"`
 }
 
 // ParseRawURL parse raw url to url object.
@@ -108,6 +111,11 @@ func ParseBackend(rawURL string, options *BackendOptions) (*backuppb.StorageBack
 		ExtractQueryParameters(u, &options.Azblob)
 		options.Azblob.apply(azblob)
 		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_AzureBlobStorage{AzureBlobStorage: azblob}}, nil
+
+	case "sftp":
+		// TiKV writes the SST files to the backend directly, which doesn't support SFTP.
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"sftp is only supported by the storages accessed by BR itself, such as --catalog, not %s", u.Redacted())
 	default:
 		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "storage %s not support yet", u.Scheme)
 	}
@@ -190,3 +198,90 @@ func FormatBackendURL(backend *backuppb.StorageBackend) (u url.URL) {
 	}
 	return
 }
+
+// BackendWithPrefix returns a copy of the backend whose root is the relative path prefix of it,
+// e.g. the files written by TiKV to the returned backend are at prefix/name of the backend.
+func BackendWithPrefix(backend *backuppb.StorageBackend, prefix string) (*backuppb.StorageBackend, error) {
+	if len(prefix) == 0 {
+		return backend, nil
+	}
+	switch b := backend.Backend.(type) {
+	case *backuppb.StorageBackend_Local:
+		local := *b.Local
+		local.Path = path.Join(local.Path, prefix)
+		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_Local{Local: &local}}, nil
+	case *backuppb.StorageBackend_Noop:
+		return backend, nil
+	case *backuppb.StorageBackend_Hdfs:
+		hdfs := *b.Hdfs
+		hdfs.Remote = strings.TrimRight(hdfs.Remote, "/") + "/" + prefix
+		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_Hdfs{Hdfs: &hdfs}}, nil
+	case *backuppb.StorageBackend_S3:
+		s3 := *b.S3
+		s3.Prefix = path.Join(s3.Prefix, prefix)
+		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_S3{S3: &s3}}, nil
+	case *backuppb.StorageBackend_Gcs:
+		gcs := *b.Gcs
+		gcs.Prefix = path.Join(gcs.Prefix, prefix)
+		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_Gcs{Gcs: &gcs}}, nil
+	case *backuppb.StorageBackend_AzureBlobStorage:
+		azure := *b.AzureBlobStorage
+		azure.Prefix = path.Join(azure.Prefix, prefix)
+		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_AzureBlobStorage{AzureBlobStorage: &azure}}, nil
+	default:
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "storage %T doesn't support the prefix", b)
+	}
+}
+
+// RelativeURL returns the URL of target relative to base, e.g. "../full" for "s3://bucket/backup/full"
+// relative to "s3://bucket/backup/inc", so that the reference holds after both are copied to another
+// bucket or prefix together. It returns false if they aren't in the same bucket of the same storage
+// with the same options.
+func RelativeURL(base, target string) (string, bool) {
+	b, err := ParseRawURL(base)
+	if err != nil {
+		return "", false
+	}
+	t, err := ParseRawURL(target)
+	if err != nil {
+		return "", false
+	}
+	if b.Scheme != t.Scheme || b.Host != t.Host || b.User.String() != t.User.String() || b.RawQuery != t.RawQuery {
+		return "", false
+	}
+	rel, err := filepath.Rel(path.Clean("/"+b.Path), path.Clean("/"+t.Path))
+	if err != nil {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, ".") {
+		rel = "./" + rel
+	}
+	return rel, true
+}
+
+// ResolveURL resolves the URL ref returned by RelativeURL against base, the options of base are kept.
+func ResolveURL(base, ref string) (string, error) {
+	u, err := ParseRawURL(base)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	// keep the form of the base, e.g. the URI of S3 storage ends with a slash, and the URI of
+	// local storage starts with 2 slashes.
+	leadingSlashes := u.Path[:len(u.Path)-len(strings.TrimLeft(u.Path, "/"))]
+	trailingSlash := strings.HasSuffix(u.Path, "/")
+	if len(u.Host) > 0 || len(leadingSlashes) > 0 {
+		// the relative URL never goes above the bucket or the root.
+		u.Path = path.Join("/", u.Path, ref)
+		if len(leadingSlashes) > 1 {
+			u.Path = leadingSlashes + strings.TrimLeft(u.Path, "/")
+		}
+	} else {
+		u.Path = path.Join(u.Path, ref)
+	}
+	if trailingSlash && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	return u.String(), nil
+}
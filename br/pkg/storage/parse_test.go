@@ -194,3 +194,72 @@ func TestFormatBackendURL(t *testing.T) {
 	})
 	require.Equal(t, "azure://bucket/some%20prefix/", backendURL.String())
 }
+
+func TestBackendWithPrefix(t *testing.T) {
+	s3 := &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_S3{S3: &backuppb.S3{Bucket: "bucket", Prefix: "backup/"}}}
+	backend, err := BackendWithPrefix(s3, "1/2022-06-01/0")
+	require.NoError(t, err)
+	require.Equal(t, "backup/1/2022-06-01/0", backend.GetS3().Prefix)
+	require.Equal(t, "bucket", backend.GetS3().Bucket)
+	// the backend isn't modified.
+	require.Equal(t, "backup/", s3.GetS3().Prefix)
+
+	backend, err = BackendWithPrefix(s3, "")
+	require.NoError(t, err)
+	require.Same(t, s3, backend)
+
+	local := &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_Local{Local: &backuppb.Local{Path: "/tmp/backup"}}}
+	backend, err = BackendWithPrefix(local, "0")
+	require.NoError(t, err)
+	require.Equal(t, "/tmp/backup/0", backend.GetLocal().Path)
+
+	hdfs := &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_Hdfs{Hdfs: &backuppb.HDFS{Remote: "hdfs://host/backup/"}}}
+	backend, err = BackendWithPrefix(hdfs, "0")
+	require.NoError(t, err)
+	require.Equal(t, "hdfs://host/backup/0", backend.GetHdfs().Remote)
+}
+
+func TestRelativeURL(t *testing.T) {
+	for _, c := range []struct {
+		base, target, rel, resolved string
+	}{
+		{"s3://bucket/backup/inc", "s3://bucket/backup/full", "../full", "s3://bucket/backup/full"},
+		{"s3://bucket/backup?endpoint=http://minio:9000", "s3://bucket/backup/full?endpoint=http://minio:9000",
+			"./full", "s3://bucket/backup/full?endpoint=http://minio:9000"},
+		{"s3://bucket/backup", "s3://bucket/backup", ".", "s3://bucket/backup"},
+		{"s3://bucket/a/b/inc", "s3://bucket/full", "../../../full", "s3://bucket/full"},
+		{"local:///tmp/backup/inc", "local:///tmp/backup/full", "../full", "local:///tmp/backup/full"},
+		{"/tmp/backup/inc", "/tmp/backup/full", "../full", "/tmp/backup/full"},
+		{"backup/inc", "backup/full", "../full", "backup/full"},
+		// the URI of local storage.
+		{"file:////tmp/backup/inc", "file:////tmp/backup/failover", "../failover", "file:////tmp/backup/failover"},
+		// the URI of S3 storage.
+		{"s3://bucket/backup/inc/", "s3://bucket/backup/failover/", "../failover", "s3://bucket/backup/failover/"},
+	} {
+		rel, ok := RelativeURL(c.base, c.target)
+		require.True(t, ok, c.target)
+		require.Equal(t, c.rel, rel)
+		resolved, err := ResolveURL(c.base, rel)
+		require.NoError(t, err)
+		require.Equal(t, c.resolved, resolved)
+	}
+
+	// the same relative URL is resolved against the bucket and the prefix the backup is copied to.
+	resolved, err := ResolveURL("gcs://other-bucket/copied/inc", "../full")
+	require.NoError(t, err)
+	require.Equal(t, "gcs://other-bucket/copied/full", resolved)
+	// it never goes above the bucket.
+	resolved, err = ResolveURL("s3://bucket", "../full")
+	require.NoError(t, err)
+	require.Equal(t, "s3://bucket/full", resolved)
+
+	for _, c := range [][2]string{
+		{"s3://bucket/inc", "s3://other/full"},
+		{"s3://bucket/inc", "gcs://bucket/full"},
+		{"s3://bucket/inc?endpoint=a", "s3://bucket/full?endpoint=b"},
+		{"s3://bucket/inc", "local:///bucket/full"},
+	} {
+		_, ok := RelativeURL(c[0], c[1])
+		require.False(t, ok, c)
+	}
+}
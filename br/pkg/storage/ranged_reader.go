@@ -0,0 +1,130 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+)
+
+const (
+	defaultRangedReadPartSize    = 8 * units.MiB
+	defaultRangedReadConcurrency = 4
+)
+
+// RangedReaderOptions are the options of NewRangedReader.
+type RangedReaderOptions struct {
+	// PartSize is the size of each ranged read.
+	PartSize int64
+	// Concurrency is the max number of parts read concurrently, which bounds the memory
+	// used by the reader to Concurrency * PartSize.
+	Concurrency int
+}
+
+type rangedPart struct {
+	data []byte
+	err  error
+}
+
+// rangedReader reads the parts of a file concurrently, and returns them in order.
+type rangedReader struct {
+	cancel context.CancelFunc
+	parts  []chan rangedPart
+	// tokens limits the parts being read or buffered, a token is released once
+	// the part is consumed by Read.
+	tokens chan struct{}
+
+	next int
+	buf  []byte
+	err  error
+}
+
+// NewRangedReader returns a reader streaming the file of size by ranged reads of the parts,
+// the parts are read ahead concurrently, so that the consumer doesn't wait for the whole
+// file to be downloaded, nor for each part one by one.
+func NewRangedReader(
+	ctx context.Context,
+	s ExternalStorage,
+	name string,
+	size int64,
+	opts RangedReaderOptions,
+) io.ReadCloser {
+	if opts.PartSize <= 0 {
+		opts.PartSize = defaultRangedReadPartSize
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultRangedReadConcurrency
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r := &rangedReader{
+		cancel: cancel,
+		parts:  make([]chan rangedPart, (size+opts.PartSize-1)/opts.PartSize),
+		tokens: make(chan struct{}, opts.Concurrency),
+	}
+	for i := range r.parts {
+		r.parts[i] = make(chan rangedPart, 1)
+	}
+	go func() {
+		for i := range r.parts {
+			select {
+			case <-ctx.Done():
+				return
+			case r.tokens <- struct{}{}:
+			}
+			start := int64(i) * opts.PartSize
+			end := start + opts.PartSize
+			if end > size {
+				end = size
+			}
+			go func(part chan<- rangedPart) {
+				data, err := ReadRange(ctx, s, name, start, end)
+				part <- rangedPart{data: data, err: errors.Annotatef(err, "failed to read [%d, %d) of %s", start, end, name)}
+			}(r.parts[i])
+		}
+	}()
+	return r
+}
+
+// Read implements io.Reader.
+func (r *rangedReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.next >= len(r.parts) {
+			return 0, io.EOF
+		}
+		part := <-r.parts[r.next]
+		r.next++
+		<-r.tokens
+		if part.err != nil {
+			r.err = part.err
+			return 0, r.err
+		}
+		r.buf = part.data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close implements io.Closer, it stops reading the remaining parts.
+func (r *rangedReader) Close() error {
+	r.cancel()
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangedReader(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	data := make([]byte, 1000)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	require.NoError(t, s.WriteFile(ctx, "a.sst", data))
+
+	for _, partSize := range []int64{1, 64, 999, 1000, 4096} {
+		r := NewRangedReader(ctx, s, "a.sst", int64(len(data)), RangedReaderOptions{PartSize: partSize, Concurrency: 3})
+		read, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, data, read)
+		require.NoError(t, r.Close())
+	}
+
+	// the file is shorter than expected.
+	r := NewRangedReader(ctx, s, "a.sst", 2000, RangedReaderOptions{PartSize: 512})
+	_, err = io.ReadAll(r)
+	require.Error(t, err)
+	require.NoError(t, r.Close())
+
+	// closing before reading all parts doesn't block.
+	r = NewRangedReader(ctx, s, "a.sst", int64(len(data)), RangedReaderOptions{PartSize: 10, Concurrency: 2})
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	require.Equal(t, data[:5], buf)
+	require.NoError(t, r.Close())
+}
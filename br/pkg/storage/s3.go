@@ -5,6 +5,8 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/md5" // #nosec G501
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net/url"
@@ -40,6 +42,8 @@ const (
 	s3ACLOption          = "s3.acl"
 	s3ProviderOption     = "s3.provider"
 	notFound             = "NotFound"
+	// the error code of GetBucketLifecycleConfiguration if no rule has been set.
+	noSuchLifecycleConfiguration = "NoSuchLifecycleConfiguration"
 	// number of retries to make of operations.
 	maxRetries = 7
 	// max number of retries when meets error
@@ -51,6 +55,10 @@ const (
 
 	// TODO make this configurable, 5 mb is a good minimum size but on low latency/high bandwidth network you can go a lot bigger
 	hardcodedS3ChunkSize = 5 * 1024 * 1024
+
+	// the keys of the user metadata stamped on the uploaded objects, i.e. x-amz-meta-br-run-id.
+	s3MetadataRunID   = "Br-Run-Id"
+	s3MetadataRangeSN = "Br-Range-Sn"
 )
 
 var permissionCheckFn = map[Permission]func(*s3.S3, *backuppb.S3) error{
@@ -76,6 +84,8 @@ type S3Uploader struct {
 // UploadPart update partial data to s3, we should call CreateMultipartUpload to start it,
 // and call CompleteMultipartUpload to finish it.
 func (u *S3Uploader) Write(ctx context.Context, data []byte) (int, error) {
+	// S3 rejects the part corrupted in transit by the md5 of it.
+	digest := md5.Sum(data) // #nosec G401
 	partInput := &s3.UploadPartInput{
 		Body:          bytes.NewReader(data),
 		Bucket:        u.createOutput.Bucket,
@@ -83,6 +93,7 @@ func (u *S3Uploader) Write(ctx context.Context, data []byte) (int, error) {
 		PartNumber:    aws.Int64(int64(len(u.completeParts) + 1)),
 		UploadId:      u.createOutput.UploadId,
 		ContentLength: aws.Int64(int64(len(data))),
+		ContentMD5:    aws.String(base64.StdEncoding.EncodeToString(digest[:])),
 	}
 
 	uploadResult, err := u.svc.UploadPartWithContext(ctx, partInput)
@@ -349,8 +360,22 @@ func getObject(svc *s3.S3, qs *backuppb.S3) error {
 	return nil
 }
 
+// objectMetadata returns the user metadata stamped on the uploaded objects, so that the
+// objects in the access logs and the inventory reports can be joined back to the br run.
+func objectMetadata(ctx context.Context) map[string]*string {
+	metadata := make(map[string]*string, 2)
+	if runID := logutil.RunID(); len(runID) > 0 {
+		metadata[s3MetadataRunID] = aws.String(runID)
+	}
+	if sn, ok := logutil.RangeSNFromContext(ctx); ok {
+		metadata[s3MetadataRangeSN] = aws.String(strconv.Itoa(sn))
+	}
+	return metadata
+}
+
 // WriteFile writes data to a file to storage.
 func (rs *S3Storage) WriteFile(ctx context.Context, file string, data []byte) error {
+	defer observeWriteFile("s3", time.Now())
 	input := &s3.PutObjectInput{
 		Body:   aws.ReadSeekCloser(bytes.NewReader(data)),
 		Bucket: aws.String(rs.options.Bucket),
@@ -368,6 +393,9 @@ func (rs *S3Storage) WriteFile(ctx context.Context, file string, data []byte) er
 	if rs.options.StorageClass != "" {
 		input = input.SetStorageClass(rs.options.StorageClass)
 	}
+	if metadata := objectMetadata(ctx); len(metadata) > 0 {
+		input = input.SetMetadata(metadata)
+	}
 
 	_, err := rs.svc.PutObjectWithContext(ctx, input)
 	if err != nil {
@@ -432,6 +460,18 @@ func (rs *S3Storage) FileExists(ctx context.Context, file string) (bool, error)
 	return true, nil
 }
 
+// ModTime implements ModTimeReader.
+func (rs *S3Storage) ModTime(ctx context.Context, file string) (time.Time, error) {
+	output, err := rs.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(rs.options.Bucket),
+		Key:    aws.String(rs.options.Prefix + file),
+	})
+	if err != nil {
+		return time.Time{}, errors.Trace(err)
+	}
+	return aws.TimeValue(output.LastModified), nil
+}
+
 // WalkDir traverse all the files in a dir.
 //
 // fn is the function called for each regular file visited by WalkDir.
@@ -497,6 +537,45 @@ func (rs *S3Storage) URI() string {
 	return "s3://" + rs.options.Bucket + "/" + rs.options.Prefix
 }
 
+// SetupLifecycle implements LifecycleSetter.
+func (rs *S3Storage) SetupLifecycle(ctx context.Context, expireDays int64) error {
+	if len(rs.options.Prefix) == 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "refuse to set up lifecycle rule for the whole bucket")
+	}
+	ruleID := lifecycleRuleID(rs.options.Prefix)
+	rules := make([]*s3.LifecycleRule, 0, 1)
+	output, err := rs.svc.GetBucketLifecycleConfigurationWithContext(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(rs.options.Bucket),
+	})
+	if err != nil {
+		if aerr, ok := errors.Cause(err).(awserr.Error); !ok || aerr.Code() != noSuchLifecycleConfiguration { // nolint:errorlint
+			return errors.Annotatef(err, "failed to get lifecycle configuration of bucket %s", rs.options.Bucket)
+		}
+	} else {
+		for _, rule := range output.Rules {
+			if aws.StringValue(rule.ID) != ruleID {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	rules = append(rules, &s3.LifecycleRule{
+		ID:         aws.String(ruleID),
+		Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String(rs.options.Prefix)},
+		Status:     aws.String(s3.ExpirationStatusEnabled),
+		Expiration: &s3.LifecycleExpiration{Days: aws.Int64(expireDays)},
+	})
+	_, err = rs.svc.PutBucketLifecycleConfigurationWithContext(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(rs.options.Bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: rules},
+	})
+	if err != nil {
+		return errors.Annotatef(err, "failed to put lifecycle configuration of bucket %s", rs.options.Bucket)
+	}
+	log.Info("s3 lifecycle rule set up", zap.String("bucket", rs.options.Bucket),
+		zap.String("prefix", rs.options.Prefix), zap.String("rule", ruleID), zap.Int64("expire-days", expireDays))
+	return nil
+}
+
 // Open a Reader by file path.
 func (rs *S3Storage) Open(ctx context.Context, path string) (ExternalFileReader, error) {
 	reader, r, err := rs.open(ctx, path, 0, 0)
@@ -722,6 +801,9 @@ func (rs *S3Storage) CreateUploader(ctx context.Context, name string) (ExternalF
 	if rs.options.StorageClass != "" {
 		input = input.SetStorageClass(rs.options.StorageClass)
 	}
+	if metadata := objectMetadata(ctx); len(metadata) > 0 {
+		input = input.SetMetadata(metadata)
+	}
 
 	resp, err := rs.svc.CreateMultipartUploadWithContext(ctx, input)
 	if err != nil {
@@ -20,6 +20,7 @@ import (
 	"github.com/pingcap/errors"
 	backuppb "github.com/pingcap/kvproto/pkg/brpb"
 	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/logutil"
 	"github.com/tikv/migration/br/pkg/mock"
 	. "github.com/tikv/migration/br/pkg/storage"
 )
@@ -460,6 +461,38 @@ func TestWriteNoError(t *testing.T) {
 	require.NoError(t, err)
 }
 
+// TestWriteMetadata checks that the run ID and the range serial number are stamped on the objects.
+func TestWriteMetadata(t *testing.T) {
+	s, clean := createS3Suite(t)
+	defer clean()
+	logutil.SetRunID("run-1")
+	defer logutil.SetRunID("")
+	ctx := logutil.ContextWithRangeSN(aws.BackgroundContext(), 7)
+
+	putCall := s.s3.EXPECT().
+		PutObjectWithContext(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *s3.PutObjectInput, opt ...request.Option) (*s3.PutObjectOutput, error) {
+			require.Equal(t, "run-1", aws.StringValue(input.Metadata["Br-Run-Id"]))
+			require.Equal(t, "7", aws.StringValue(input.Metadata["Br-Range-Sn"]))
+			return &s3.PutObjectOutput{}, nil
+		})
+	s.s3.EXPECT().
+		WaitUntilObjectExistsWithContext(ctx, gomock.Any()).
+		Return(nil).
+		After(putCall)
+	require.NoError(t, s.storage.WriteFile(ctx, "file", []byte("test")))
+
+	s.s3.EXPECT().
+		CreateMultipartUploadWithContext(aws.BackgroundContext(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *s3.CreateMultipartUploadInput, opt ...request.Option) (*s3.CreateMultipartUploadOutput, error) {
+			require.Equal(t, "run-1", aws.StringValue(input.Metadata["Br-Run-Id"]))
+			require.NotContains(t, input.Metadata, "Br-Range-Sn")
+			return &s3.CreateMultipartUploadOutput{}, nil
+		})
+	_, err := s.storage.CreateUploader(aws.BackgroundContext(), "file")
+	require.NoError(t, err)
+}
+
 // TestReadNoError ensures the ReadFile API issues a GetObject request and correctly
 // read the entire body.
 func TestReadNoError(t *testing.T) {
@@ -1075,3 +1108,52 @@ func TestWalkDirWithEmptyPrefix(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 1, i)
 }
+
+func TestSetupLifecycle(t *testing.T) {
+	s, clean := createS3Suite(t)
+	defer clean()
+	ctx := aws.BackgroundContext()
+
+	otherRule := &s3.LifecycleRule{
+		ID:     aws.String("other"),
+		Filter: &s3.LifecycleRuleFilter{Prefix: aws.String("other/")},
+		Status: aws.String(s3.ExpirationStatusEnabled),
+	}
+	var ruleID string
+	s.s3.EXPECT().
+		GetBucketLifecycleConfigurationWithContext(ctx, gomock.Any()).
+		Return(&s3.GetBucketLifecycleConfigurationOutput{Rules: []*s3.LifecycleRule{otherRule}}, nil)
+	s.s3.EXPECT().
+		PutBucketLifecycleConfigurationWithContext(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *s3.PutBucketLifecycleConfigurationInput, opt ...request.Option) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+			require.Equal(t, "bucket", aws.StringValue(input.Bucket))
+			rules := input.LifecycleConfiguration.Rules
+			require.Len(t, rules, 2)
+			require.Equal(t, otherRule, rules[0])
+			require.Equal(t, "prefix/", aws.StringValue(rules[1].Filter.Prefix))
+			require.Equal(t, int64(7), aws.Int64Value(rules[1].Expiration.Days))
+			ruleID = aws.StringValue(rules[1].ID)
+			return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+		})
+	require.NoError(t, SetupLifecycle(ctx, s.storage, 7))
+
+	// Setting up again replaces the old rule, and the missing configuration is not an error.
+	s.s3.EXPECT().
+		GetBucketLifecycleConfigurationWithContext(ctx, gomock.Any()).
+		Return(nil, awserr.New("NoSuchLifecycleConfiguration", "no lifecycle", nil))
+	s.s3.EXPECT().
+		PutBucketLifecycleConfigurationWithContext(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *s3.PutBucketLifecycleConfigurationInput, opt ...request.Option) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+			rules := input.LifecycleConfiguration.Rules
+			require.Len(t, rules, 1)
+			require.Equal(t, ruleID, aws.StringValue(rules[0].ID))
+			require.Equal(t, int64(30), aws.Int64Value(rules[0].Expiration.Days))
+			return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+		})
+	require.NoError(t, SetupLifecycle(ctx, s.storage, 30))
+
+	require.Error(t, SetupLifecycle(ctx, s.storage, 0))
+	local, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	require.Error(t, SetupLifecycle(ctx, local, 7))
+}
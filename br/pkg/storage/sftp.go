@@ -0,0 +1,619 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pkg/sftp"
+	"github.com/spf13/pflag"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	sftpPasswordOption       = "sftp.password"
+	sftpPrivateKeyFileOption = "sftp.private-key-file"
+	sftpHostKeyOption        = "sftp.host-key-fingerprint"
+	sftpKnownHostsOption     = "sftp.known-hosts"
+
+	sftpDefaultPort = "22"
+	// sftpPoolSize is the max number of connections to the server.
+	sftpPoolSize = 4
+	// sftpPartSuffix is the suffix of the file being uploaded, it's renamed to
+	// the final name after the upload finished.
+	sftpPartSuffix = ".part"
+	// sftpUploadRetry is the max times of resuming an interrupted upload.
+	sftpUploadRetry = 3
+)
+
+// sftpClientOptions pipelines the write requests of a file, so that the uploads aren't
+// bound by the latency to the server. The reads are pipelined by default.
+var sftpClientOptions = []sftp.ClientOption{sftp.UseConcurrentWrites(true)}
+
+// SFTPBackendOptions contains options for the SFTP storage.
+type SFTPBackendOptions struct {
+	Password       string `json:"password" toml:"password"`
+	PrivateKeyFile string `json:"private-key-file" toml:"private-key-file"`
+	// HostKeyFingerprint pins the host key of the server, in the format of `ssh-keygen -l`, e.g. "SHA256:...".
+	HostKeyFingerprint string `json:"host-key-fingerprint" toml:"host-key-fingerprint"`
+	// KnownHosts is the known_hosts file verifying the host key, used if HostKeyFingerprint is not set.
+	KnownHosts string `json:"known-hosts" toml:"known-hosts"`
+}
+
+func defineSFTPFlags(flags *pflag.FlagSet) {
+	flags.String(sftpPasswordOption, "", "(experimental) Set the password of the SFTP user")
+	flags.String(sftpPrivateKeyFileOption, "", "(experimental) Set the private key file of the SFTP user")
+	flags.String(sftpHostKeyOption, "",
+		"(experimental) Pin the host key of the SFTP server by its SHA256 fingerprint, e.g. \"SHA256:...\"")
+	flags.String(sftpKnownHostsOption, "",
+		"(experimental) Set the known_hosts file verifying the host key of the SFTP server")
+	_ = flags.MarkHidden(sftpPasswordOption)
+	_ = flags.MarkHidden(sftpPrivateKeyFileOption)
+	_ = flags.MarkHidden(sftpHostKeyOption)
+	_ = flags.MarkHidden(sftpKnownHostsOption)
+}
+
+func (options *SFTPBackendOptions) parseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	options.Password, err = flags.GetString(sftpPasswordOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.PrivateKeyFile, err = flags.GetString(sftpPrivateKeyFileOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.HostKeyFingerprint, err = flags.GetString(sftpHostKeyOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.KnownHosts, err = flags.GetString(sftpKnownHostsOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// sshClientConfig builds the SSH config from the URL and the options.
+func (options *SFTPBackendOptions) sshClientConfig(u *url.URL) (*ssh.ClientConfig, error) {
+	cfg := &ssh.ClientConfig{User: u.User.Username()}
+	if len(cfg.User) == 0 {
+		cfg.User = os.Getenv("USER")
+	}
+
+	password, _ := u.User.Password()
+	if len(options.Password) > 0 {
+		password = options.Password
+	}
+	if len(password) > 0 {
+		cfg.Auth = append(cfg.Auth, ssh.Password(password))
+	}
+	if len(options.PrivateKeyFile) > 0 {
+		key, err := os.ReadFile(options.PrivateKeyFile)
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "failed to read private key: %v", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "failed to parse private key: %v", err)
+		}
+		cfg.Auth = append(cfg.Auth, ssh.PublicKeys(signer))
+	}
+	if len(cfg.Auth) == 0 {
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"please specify --%s or --%s for sftp", sftpPasswordOption, sftpPrivateKeyFileOption)
+	}
+
+	switch {
+	case len(options.HostKeyFingerprint) > 0:
+		fingerprint := options.HostKeyFingerprint
+		cfg.HostKeyCallback = func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+			if actual := ssh.FingerprintSHA256(key); actual != fingerprint {
+				return errors.Annotatef(berrors.ErrStorageInvalidPermission,
+					"the host key of %s is %s, doesn't match the pinned %s", hostname, actual, fingerprint)
+			}
+			return nil
+		}
+	case len(options.KnownHosts) > 0:
+		callback, err := knownhosts.New(options.KnownHosts)
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "failed to load known hosts: %v", err)
+		}
+		cfg.HostKeyCallback = callback
+	default:
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"the host key of the sftp server must be verified, please specify --%s or --%s",
+			sftpHostKeyOption, sftpKnownHostsOption)
+	}
+	return cfg, nil
+}
+
+// sftpConn is a SFTP client and the SSH connection carrying it.
+type sftpConn struct {
+	*sftp.Client
+	closer io.Closer
+}
+
+func (c *sftpConn) shutdown() {
+	_ = c.Client.Close()
+	if c.closer != nil {
+		_ = c.closer.Close()
+	}
+}
+
+func dialSFTP(host string, cfg *ssh.ClientConfig) (*sftpConn, error) {
+	sshClient, err := ssh.Dial("tcp", host, cfg)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to connect sftp server %s", host)
+	}
+	client, err := sftp.NewClient(sshClient, sftpClientOptions...)
+	if err != nil {
+		sshClient.Close()
+		return nil, errors.Annotatef(err, "failed to start sftp session on %s", host)
+	}
+	return &sftpConn{Client: client, closer: sshClient}, nil
+}
+
+// isSFTPConnError returns whether the error is caused by the connection rather than
+// reported by the server, so that the request may succeed on a new connection.
+func isSFTPConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	cause := errors.Cause(err)
+	var statusErr *sftp.StatusError
+	return !stderrors.As(cause, &statusErr) && !os.IsNotExist(cause) && !os.IsPermission(cause) && cause != io.EOF
+}
+
+// isSFTPNotExist returns whether the error reports that the file doesn't exist.
+func isSFTPNotExist(err error) bool {
+	cause := errors.Cause(err)
+	var statusErr *sftp.StatusError
+	if stderrors.As(cause, &statusErr) {
+		return statusErr.FxCode() == sftp.ErrSSHFxNoSuchFile
+	}
+	return os.IsNotExist(cause)
+}
+
+// sftpPool keeps the idle connections, and limits the number of connections.
+type sftpPool struct {
+	dial func() (*sftpConn, error)
+	sem  chan struct{}
+	idle chan *sftpConn
+}
+
+func newSFTPPool(size int, dial func() (*sftpConn, error)) *sftpPool {
+	return &sftpPool{
+		dial: dial,
+		sem:  make(chan struct{}, size),
+		idle: make(chan *sftpConn, size),
+	}
+}
+
+func (p *sftpPool) get(ctx context.Context) (*sftpConn, error) {
+	select {
+	case <-ctx.Done():
+		return nil, errors.Trace(ctx.Err())
+	case p.sem <- struct{}{}:
+	}
+	select {
+	case conn := <-p.idle:
+		return conn, nil
+	default:
+	}
+	conn, err := p.dial()
+	if err != nil {
+		<-p.sem
+		return nil, errors.Trace(err)
+	}
+	return conn, nil
+}
+
+// put returns the connection to the pool. The connection is closed if the error
+// is not reported by the server, because it may be broken.
+func (p *sftpPool) put(conn *sftpConn, err error) {
+	if isSFTPConnError(err) {
+		conn.shutdown()
+	} else {
+		p.idle <- conn
+	}
+	<-p.sem
+}
+
+func (p *sftpPool) withClient(ctx context.Context, fn func(c *sftp.Client) error) error {
+	conn, err := p.get(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = fn(conn.Client)
+	p.put(conn, err)
+	return err
+}
+
+// SFTPStorage is the storage on a SFTP server. It's only accessible by BR, so it
+// cannot be the target of the backup or restore, which is accessed by TiKV.
+type SFTPStorage struct {
+	host string
+	base string
+	pool *sftpPool
+}
+
+// NewSFTPStorage creates a storage from the URL like sftp://user@host:port/path.
+func NewSFTPStorage(u *url.URL, options *SFTPBackendOptions) (*SFTPStorage, error) {
+	if len(u.Host) == 0 {
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "please specify the host for sftp in %s", u.Redacted())
+	}
+	ExtractQueryParameters(u, options)
+	cfg, err := options.sshClientConfig(u)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	host := u.Host
+	if len(u.Port()) == 0 {
+		host = net.JoinHostPort(u.Hostname(), sftpDefaultPort)
+	}
+	return newSFTPStorageWithDialer(host, u.Path, func() (*sftpConn, error) {
+		return dialSFTP(host, cfg)
+	}), nil
+}
+
+func newSFTPStorageWithDialer(host, base string, dial func() (*sftpConn, error)) *SFTPStorage {
+	if len(base) == 0 {
+		base = "."
+	}
+	return &SFTPStorage{host: host, base: base, pool: newSFTPPool(sftpPoolSize, dial)}
+}
+
+func (s *SFTPStorage) path(name string) string {
+	return path.Join(s.base, name)
+}
+
+// openPart opens the part file to continue the upload at the offset. The chunks after the
+// offset may be left by the pipelined writes failed, so they are truncated. It fails if the
+// part is shorter than the offset, e.g. lost.
+func openPart(c *sftp.Client, part string, offset int64) (*sftp.File, error) {
+	if offset == 0 {
+		return c.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	}
+	info, err := c.Stat(part)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < offset {
+		return nil, errors.Annotatef(berrors.ErrStorageUnknown,
+			"sftp: the uploaded part %s is truncated to %d bytes, expect at least %d", part, info.Size(), offset)
+	}
+	f, err := c.OpenFile(part, os.O_WRONLY)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Truncate(offset); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// commitPart renames the part file to the final name.
+func commitPart(c *sftp.Client, full string) error {
+	// rename fails if the target exists in SFTP v3.
+	if err := c.Remove(full); err != nil && !isSFTPNotExist(err) {
+		return err
+	}
+	return c.Rename(full+sftpPartSuffix, full)
+}
+
+// upload writes the data into the part file from the offset, which is the length of the data
+// written by the last interrupted attempt, then renames the part file to the final name. It
+// returns the length of the data written, which the next attempt resumes from.
+func (s *SFTPStorage) upload(c *sftp.Client, name string, data []byte, offset int64) (int64, error) {
+	full := s.path(name)
+	if err := c.MkdirAll(path.Dir(full)); err != nil {
+		return offset, err
+	}
+	f, err := openPart(c, full+sftpPartSuffix, offset)
+	if err != nil {
+		return offset, err
+	}
+	if offset > 0 {
+		log.Info("resume sftp upload", zap.String("file", full), zap.Int64("offset", offset))
+	}
+	// the write requests are pipelined, the returned length is acknowledged by the server.
+	n, err := f.WriteAt(data[offset:], offset)
+	offset += int64(n)
+	if err != nil {
+		_ = f.Close()
+		return offset, err
+	}
+	if err = f.Close(); err != nil {
+		return offset, err
+	}
+	return offset, commitPart(c, full)
+}
+
+// WriteFile writes data to a file to storage. The upload interrupted by the connection is
+// resumed on a new connection.
+func (s *SFTPStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	defer observeWriteFile("sftp", time.Now())
+	var (
+		offset int64
+		err    error
+	)
+	for i := 0; i < sftpUploadRetry; i++ {
+		err = s.pool.withClient(ctx, func(c *sftp.Client) error {
+			var uploadErr error
+			offset, uploadErr = s.upload(c, name, data, offset)
+			return uploadErr
+		})
+		if !isSFTPConnError(err) || ctx.Err() != nil {
+			return errors.Trace(err)
+		}
+		log.Warn("sftp upload interrupted, retrying", zap.String("file", name), zap.Error(err))
+	}
+	return errors.Trace(err)
+}
+
+// ReadFile reads the file from the storage and returns the contents.
+func (s *SFTPStorage) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := s.pool.withClient(ctx, func(c *sftp.Client) error {
+		f, err := c.Open(s.path(name))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// the read requests are pipelined by WriteTo.
+		_, err = f.WriteTo(&buf)
+		return err
+	})
+	return buf.Bytes(), errors.Trace(err)
+}
+
+// FileExists implement ExternalStorage.FileExists.
+func (s *SFTPStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	exists := false
+	err := s.pool.withClient(ctx, func(c *sftp.Client) error {
+		_, err := c.Stat(s.path(name))
+		if isSFTPNotExist(err) {
+			return nil
+		}
+		exists = err == nil
+		return err
+	})
+	return exists, errors.Trace(err)
+}
+
+// DeleteFile deletes the file.
+func (s *SFTPStorage) DeleteFile(ctx context.Context, name string) error {
+	return errors.Trace(s.pool.withClient(ctx, func(c *sftp.Client) error {
+		return c.Remove(s.path(name))
+	}))
+}
+
+// WalkDir traverse all the files in a dir.
+func (s *SFTPStorage) WalkDir(ctx context.Context, opt *WalkOption, fn func(string, int64) error) error {
+	if opt == nil {
+		opt = &WalkOption{}
+	}
+	return errors.Trace(s.pool.withClient(ctx, func(c *sftp.Client) error {
+		return s.walk(ctx, c, opt.SubDir, fn)
+	}))
+}
+
+func (s *SFTPStorage) walk(ctx context.Context, c *sftp.Client, dir string, fn func(string, int64) error) error {
+	entries, err := c.ReadDir(s.path(dir))
+	if isSFTPNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// the entries are listed in the order of the server, which may be any order.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rel := path.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if err = s.walk(ctx, c, rel, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasSuffix(entry.Name(), sftpPartSuffix) {
+			continue
+		}
+		if err = fn(rel, entry.Size()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// URI returns the base path as a URI.
+func (s *SFTPStorage) URI() string {
+	return "sftp://" + s.host + "/" + strings.TrimPrefix(s.base, "/")
+}
+
+// Open a Reader by file path. The reader holds a connection until it's closed.
+func (s *SFTPStorage) Open(ctx context.Context, name string) (ExternalFileReader, error) {
+	conn, err := s.pool.get(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	f, err := conn.Open(s.path(name))
+	if err != nil {
+		s.pool.put(conn, err)
+		return nil, errors.Trace(err)
+	}
+	return &sftpFileReader{pool: s.pool, conn: conn, file: f}, nil
+}
+
+// Create opens a file writer by path. The file is visible after the writer is closed.
+func (s *SFTPStorage) Create(ctx context.Context, name string) (ExternalFileWriter, error) {
+	conn, err := s.pool.get(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	full := s.path(name)
+	if err = conn.MkdirAll(path.Dir(full)); err != nil {
+		s.pool.put(conn, err)
+		return nil, errors.Trace(err)
+	}
+	f, err := openPart(conn.Client, full+sftpPartSuffix, 0)
+	if err != nil {
+		s.pool.put(conn, err)
+		return nil, errors.Trace(err)
+	}
+	return &sftpFileWriter{pool: s.pool, conn: conn, file: f, path: full}, nil
+}
+
+type sftpFileReader struct {
+	pool *sftpPool
+	conn *sftpConn
+	file *sftp.File
+	err  error
+}
+
+// Read implements io.Reader.
+func (r *sftpFileReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (r *sftpFileReader) Seek(offset int64, whence int) (int64, error) {
+	offset, err := r.file.Seek(offset, whence)
+	return offset, errors.Trace(err)
+}
+
+// Close implements io.Closer.
+func (r *sftpFileReader) Close() error {
+	err := r.file.Close()
+	if r.err != nil {
+		r.pool.put(r.conn, r.err)
+	} else {
+		r.pool.put(r.conn, err)
+	}
+	return errors.Trace(err)
+}
+
+type sftpFileWriter struct {
+	pool   *sftpPool
+	conn   *sftpConn
+	file   *sftp.File
+	path   string
+	offset int64
+	err    error
+}
+
+// reopen replaces the broken connection with a new one, and reopens the part file to
+// continue the upload from the offset.
+func (w *sftpFileWriter) reopen(ctx context.Context) error {
+	if w.conn != nil {
+		w.pool.put(w.conn, w.err)
+		w.conn = nil
+	}
+	conn, err := w.pool.get(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	f, err := openPart(conn.Client, w.path+sftpPartSuffix, w.offset)
+	if err != nil {
+		w.pool.put(conn, err)
+		return errors.Trace(err)
+	}
+	w.conn, w.file, w.err = conn, f, nil
+	return nil
+}
+
+// Write implements ExternalFileWriter. The upload is resumed on a new connection if
+// the connection is broken.
+func (w *sftpFileWriter) Write(ctx context.Context, p []byte) (int, error) {
+	if w.err != nil {
+		return 0, errors.Trace(w.err)
+	}
+	data := p
+	for i := 0; ; i++ {
+		// the write requests are pipelined, the returned length is acknowledged by the server.
+		n, err := w.file.WriteAt(data, w.offset)
+		w.offset += int64(n)
+		data = data[n:]
+		if w.err = err; err == nil {
+			return len(p), nil
+		}
+		if !isSFTPConnError(err) || i >= sftpUploadRetry || ctx.Err() != nil {
+			return len(p) - len(data), errors.Trace(err)
+		}
+		log.Warn("sftp upload interrupted, resuming", zap.String("file", w.path),
+			zap.Int64("offset", w.offset), zap.Error(err))
+		if err = w.reopen(ctx); err != nil {
+			w.err = err
+			return len(p) - len(data), errors.Trace(err)
+		}
+	}
+}
+
+// Close implements ExternalFileWriter.
+func (w *sftpFileWriter) Close(ctx context.Context) error {
+	if w.conn == nil {
+		return errors.Trace(w.err)
+	}
+	if w.err != nil {
+		// the part is left to be overwritten by the next upload.
+		_ = w.file.Close()
+		w.pool.put(w.conn, w.err)
+		w.conn = nil
+		return errors.Trace(w.err)
+	}
+	var err error
+	for i := 0; ; i++ {
+		err = w.file.Close()
+		if err == nil {
+			err = commitPart(w.conn.Client, w.path)
+		}
+		if !isSFTPConnError(err) || i >= sftpUploadRetry || ctx.Err() != nil {
+			break
+		}
+		log.Warn("sftp upload interrupted, resuming", zap.String("file", w.path), zap.Error(err))
+		w.err = err
+		if err = w.reopen(ctx); err != nil {
+			w.err = err
+			return errors.Trace(err)
+		}
+	}
+	w.pool.put(w.conn, err)
+	w.conn = nil
+	return errors.Trace(err)
+}
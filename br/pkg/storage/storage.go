@@ -128,6 +128,14 @@ type ExternalStorageOptions struct {
 	// CheckPermissions check the given permission in New() function.
 	// make sure we can access the storage correctly before execute tasks.
 	CheckPermissions []Permission
+
+	// GCSChunkSize is the size of the chunks of the resumable uploads to GCS,
+	// the default one is used if it's zero.
+	GCSChunkSize int
+
+	// HDFS configures BR accessing the HDFS storage by WebHDFS, the storage is
+	// accessed by the hdfs command if it's nil.
+	HDFS *HDFSBackendOptions
 }
 
 // Create creates ExternalStorage.
@@ -152,6 +160,9 @@ func New(ctx context.Context, backend *backuppb.StorageBackend, opts *ExternalSt
 		if backend.Hdfs == nil {
 			return nil, errors.Annotate(berrors.ErrStorageInvalidConfig, "hdfs config not found")
 		}
+		if opts != nil && opts.HDFS != nil && len(opts.HDFS.WebHDFSEndpoint) > 0 {
+			return newWebHDFSStorage(ctx, backend.Hdfs.Remote, opts.HDFS, opts.HTTPClient)
+		}
 		return NewHDFSStorage(backend.Hdfs.Remote), nil
 	case *backuppb.StorageBackend_S3:
 		if backend.S3 == nil {
@@ -171,3 +182,23 @@ func New(ctx context.Context, backend *backuppb.StorageBackend, opts *ExternalSt
 		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "storage %T is not supported yet", backend)
 	}
 }
+
+// NewFromURL creates ExternalStorage from the storage URL. Besides the backends
+// supported by New, it supports the storages only accessible by BR, e.g. sftp.
+func NewFromURL(ctx context.Context, rawURL string, options *BackendOptions, opts *ExternalStorageOptions) (ExternalStorage, error) {
+	u, err := ParseRawURL(rawURL)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if u.Scheme == "sftp" {
+		if options == nil {
+			options = &BackendOptions{}
+		}
+		return NewSFTPStorage(u, &options.SFTP)
+	}
+	backend, err := ParseBackend(rawURL, options)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return New(ctx, backend, opts)
+}
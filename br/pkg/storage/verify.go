@@ -0,0 +1,157 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/summary"
+	"go.uber.org/zap"
+)
+
+const (
+	verifyRetryTimes = 3
+
+	// SummaryCorruptedDownloads is the summary key of the number of downloads failing the verification.
+	SummaryCorruptedDownloads = "corrupted downloads"
+	// SummaryFailedUploads is the summary key of the number of uploads failing or failing the verification.
+	SummaryFailedUploads = "failed uploads"
+
+	// MultipartUploadThreshold is the size above which WriteFileVerified uploads the file in parts
+	// by the writer of the storage, e.g. the multipart upload of S3.
+	MultipartUploadThreshold = 16 * 1024 * 1024
+)
+
+// uploadRetryBackoff is the wait before uploading a file again in WriteFileVerified.
+var uploadRetryBackoff = time.Second
+
+// WriteFileVerified writes the file, and verifies it by reading it back and comparing the
+// checksums, so that a corrupted upload is noticed while it can still be uploaded again.
+// The file larger than MultipartUploadThreshold is uploaded in parts. The failed uploads
+// are retried, and recorded in the summary.
+func WriteFileVerified(ctx context.Context, s ExternalStorage, name string, data []byte) error {
+	expected := sha256.Sum256(data)
+	for retry := 0; ; retry++ {
+		err := writeFileInParts(ctx, s, name, data)
+		if err == nil {
+			var written []byte
+			if written, err = s.ReadFile(ctx, name); err == nil {
+				if actual := sha256.Sum256(written); !bytes.Equal(actual[:], expected[:]) {
+					err = errors.Annotatef(berrors.ErrStorageCorrupted, "%s is read back as %d bytes of sha256 %x, expect %d bytes of sha256 %x",
+						name, len(written), actual, len(data), expected)
+				}
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		summary.CollectInt(SummaryFailedUploads, 1)
+		log.Warn("failed to upload the file",
+			zap.String("storage", s.URI()), zap.String("name", name),
+			zap.Int("size", len(data)), zap.Int("retry", retry), zap.Error(err))
+		if retry >= verifyRetryTimes {
+			return errors.Trace(err)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-time.After(uploadRetryBackoff):
+		}
+	}
+}
+
+// writeFileInParts writes the file by WriteFile, or by the writer of the storage if it's larger
+// than MultipartUploadThreshold, so that a part failed is retried alone by the storage.
+func writeFileInParts(ctx context.Context, s ExternalStorage, name string, data []byte) error {
+	if len(data) <= MultipartUploadThreshold {
+		return errors.Trace(s.WriteFile(ctx, name, data))
+	}
+	w, err := s.Create(ctx, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = w.Write(ctx, data); err != nil {
+		// the writer isn't closed, so that an incomplete multipart upload isn't completed.
+		return errors.Trace(err)
+	}
+	return errors.Trace(w.Close(ctx))
+}
+
+// ReadFileVerified reads the file of the expected size (unknown if it's 0), and
+// verifies the content by verify. A truncated content is completed by reading only
+// the missing part with a range read, other corrupted content is downloaded again.
+// Every failed verification is recorded in the summary.
+func ReadFileVerified(
+	ctx context.Context,
+	s ExternalStorage,
+	name string,
+	size int64,
+	verify func([]byte) error,
+) ([]byte, error) {
+	data, err := s.ReadFile(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for retry := 0; ; retry++ {
+		if size > 0 && int64(len(data)) < size {
+			tail, err := ReadRange(ctx, s, name, int64(len(data)), size)
+			if err != nil {
+				log.Warn("failed to read the missing part of the file",
+					zap.String("name", name), zap.Int("read", len(data)), zap.Int64("size", size), zap.Error(err))
+			} else {
+				data = append(data, tail...)
+			}
+		}
+		verifyErr := verify(data)
+		if verifyErr == nil {
+			return data, nil
+		}
+		summary.CollectInt(SummaryCorruptedDownloads, 1)
+		log.Warn("the downloaded file is corrupted",
+			zap.String("storage", s.URI()), zap.String("name", name),
+			zap.Int("read", len(data)), zap.Int64("size", size),
+			zap.Int("retry", retry), zap.Error(verifyErr))
+		if retry >= verifyRetryTimes {
+			return nil, errors.Trace(verifyErr)
+		}
+		if data, err = s.ReadFile(ctx, name); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+}
+
+// ReadRange reads [start, end) of the file, only the range is downloaded from the storage.
+func ReadRange(ctx context.Context, s ExternalStorage, name string, start, end int64) ([]byte, error) {
+	r, err := s.Open(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer r.Close()
+	if _, err = r.Seek(start, io.SeekStart); err != nil {
+		return nil, errors.Trace(err)
+	}
+	buf := make([]byte, end-start)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return buf, nil
+}
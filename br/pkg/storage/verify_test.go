@@ -0,0 +1,153 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// flakyStorage returns the corrupted content for the first reads of a file.
+type flakyStorage struct {
+	*LocalStorage
+	corrupt func([]byte) []byte
+	times   int
+	reads   int
+}
+
+func (s *flakyStorage) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	s.reads++
+	data, err := s.LocalStorage.ReadFile(ctx, name)
+	if err != nil || s.reads > s.times {
+		return data, err
+	}
+	return s.corrupt(data), nil
+}
+
+func TestReadFileVerified(t *testing.T) {
+	ctx := context.Background()
+	local, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	content := []byte("0123456789abcdef")
+	require.NoError(t, local.WriteFile(ctx, "file", content))
+	verify := func(data []byte) error {
+		if !bytes.Equal(data, content) {
+			return errors.New("checksum mismatch")
+		}
+		return nil
+	}
+
+	// a truncated content is completed by reading the missing part only.
+	s := &flakyStorage{LocalStorage: local, times: 1, corrupt: func(data []byte) []byte { return data[:5] }}
+	data, err := ReadFileVerified(ctx, s, "file", int64(len(content)), verify)
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+	require.Equal(t, 1, s.reads)
+
+	// a corrupted content is downloaded again.
+	flip := func(data []byte) []byte {
+		data[3] ^= 0xff
+		return data
+	}
+	s = &flakyStorage{LocalStorage: local, times: 2, corrupt: flip}
+	data, err = ReadFileVerified(ctx, s, "file", int64(len(content)), verify)
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+	require.Equal(t, 3, s.reads)
+
+	s = &flakyStorage{LocalStorage: local, times: 100, corrupt: flip}
+	_, err = ReadFileVerified(ctx, s, "file", int64(len(content)), verify)
+	require.Error(t, err)
+	require.Equal(t, verifyRetryTimes+1, s.reads)
+}
+
+// corruptingStorage corrupts the first writes of a file, in whole or in parts.
+type corruptingStorage struct {
+	*LocalStorage
+	times  int
+	writes int
+	parts  int
+}
+
+func (s *corruptingStorage) corrupt(data []byte) []byte {
+	s.writes++
+	if s.writes > s.times {
+		return data
+	}
+	corrupted := append([]byte{}, data...)
+	corrupted[0] ^= 0xff
+	return corrupted
+}
+
+func (s *corruptingStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	return s.LocalStorage.WriteFile(ctx, name, s.corrupt(data))
+}
+
+func (s *corruptingStorage) Create(ctx context.Context, name string) (ExternalFileWriter, error) {
+	w, err := s.LocalStorage.Create(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &corruptingWriter{ExternalFileWriter: w, s: s}, nil
+}
+
+type corruptingWriter struct {
+	ExternalFileWriter
+	s *corruptingStorage
+}
+
+func (w *corruptingWriter) Write(ctx context.Context, p []byte) (int, error) {
+	w.s.parts++
+	return w.ExternalFileWriter.Write(ctx, w.s.corrupt(p))
+}
+
+func TestWriteFileVerified(t *testing.T) {
+	backoff := uploadRetryBackoff
+	uploadRetryBackoff = time.Millisecond
+	defer func() { uploadRetryBackoff = backoff }()
+
+	ctx := context.Background()
+	local, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	content := []byte("0123456789abcdef")
+
+	// the corrupted uploads are uploaded again.
+	s := &corruptingStorage{LocalStorage: local, times: 2}
+	require.NoError(t, WriteFileVerified(ctx, s, "file", content))
+	require.Equal(t, 3, s.writes)
+	data, err := local.ReadFile(ctx, "file")
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+
+	s = &corruptingStorage{LocalStorage: local, times: 100}
+	err = WriteFileVerified(ctx, s, "file", content)
+	require.True(t, berrors.Is(err, berrors.ErrStorageCorrupted))
+	require.Equal(t, verifyRetryTimes+1, s.writes)
+
+	// the large file is uploaded by the writer.
+	large := bytes.Repeat(content, MultipartUploadThreshold/len(content)+1)
+	s = &corruptingStorage{LocalStorage: local, times: 1}
+	require.NoError(t, WriteFileVerified(ctx, s, "large", large))
+	require.Equal(t, 2, s.parts)
+	data, err = local.ReadFile(ctx, "large")
+	require.NoError(t, err)
+	require.Equal(t, large, data)
+}
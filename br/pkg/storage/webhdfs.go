@@ -0,0 +1,494 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	hdfsWebHDFSEndpointOption   = "hdfs.webhdfs-endpoint"
+	hdfsUserOption              = "hdfs.user"
+	hdfsKerberosKeytabOption    = "hdfs.kerberos-keytab"
+	hdfsKerberosPrincipalOption = "hdfs.kerberos-principal"
+
+	webHDFSPathPrefix = "/webhdfs/v1"
+)
+
+// HDFSBackendOptions contains options for BR accessing the HDFS storage by WebHDFS.
+// TiKV still accesses the storage by the hdfs:// URL.
+type HDFSBackendOptions struct {
+	// WebHDFSEndpoint is the HTTP address of the name node, e.g. "http://namenode:9870".
+	// BR accesses the storage by the hdfs command if it's not set.
+	WebHDFSEndpoint string `json:"webhdfs-endpoint" toml:"webhdfs-endpoint"`
+	// User is the user of the simple authentication.
+	User string `json:"user" toml:"user"`
+	// KerberosKeytab and KerberosPrincipal authenticate BR by Kerberos, with which
+	// BR gets a delegation token of WebHDFS.
+	KerberosKeytab    string `json:"kerberos-keytab" toml:"kerberos-keytab"`
+	KerberosPrincipal string `json:"kerberos-principal" toml:"kerberos-principal"`
+}
+
+func defineHDFSFlags(flags *pflag.FlagSet) {
+	flags.String(hdfsWebHDFSEndpointOption, "",
+		"(experimental) Set the WebHDFS endpoint of the name node, e.g. \"http://namenode:9870\", "+
+			"BR accesses the hdfs storage by WebHDFS instead of the hdfs command if it's set")
+	flags.String(hdfsUserOption, "", "(experimental) Set the user accessing WebHDFS without Kerberos")
+	flags.String(hdfsKerberosKeytabOption, "", "(experimental) Set the Kerberos keytab file accessing WebHDFS")
+	flags.String(hdfsKerberosPrincipalOption, "", "(experimental) Set the Kerberos principal accessing WebHDFS")
+	_ = flags.MarkHidden(hdfsWebHDFSEndpointOption)
+	_ = flags.MarkHidden(hdfsUserOption)
+	_ = flags.MarkHidden(hdfsKerberosKeytabOption)
+	_ = flags.MarkHidden(hdfsKerberosPrincipalOption)
+}
+
+func (options *HDFSBackendOptions) parseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	options.WebHDFSEndpoint, err = flags.GetString(hdfsWebHDFSEndpointOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.User, err = flags.GetString(hdfsUserOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.KerberosKeytab, err = flags.GetString(hdfsKerberosKeytabOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.KerberosPrincipal, err = flags.GetString(hdfsKerberosPrincipalOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// runCommand runs the external command, it's replaced in tests.
+var runCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+	//nolint:gosec
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+// getWebHDFSDelegationToken authenticates by the keytab and gets a delegation token of WebHDFS,
+// the SPNEGO authentication is done by kinit and curl of the host.
+func getWebHDFSDelegationToken(ctx context.Context, endpoint string, options *HDFSBackendOptions) (string, error) {
+	out, err := runCommand(ctx, "kinit", "-k", "-t", options.KerberosKeytab, options.KerberosPrincipal)
+	if err != nil {
+		return "", errors.Annotatef(berrors.ErrStorageInvalidPermission,
+			"failed to kinit as %s: %v, %s", options.KerberosPrincipal, err, out)
+	}
+	tokenURL := strings.TrimSuffix(endpoint, "/") + webHDFSPathPrefix + "/?op=GETDELEGATIONTOKEN"
+	out, err = runCommand(ctx, "curl", "-sS", "--fail", "--negotiate", "-u", ":", tokenURL)
+	if err != nil {
+		return "", errors.Annotatef(berrors.ErrStorageInvalidPermission,
+			"failed to get the delegation token of WebHDFS: %v, %s", err, out)
+	}
+	var resp struct {
+		Token struct {
+			URLString string `json:"urlString"`
+		} `json:"Token"`
+	}
+	if err = json.Unmarshal(out, &resp); err != nil || len(resp.Token.URLString) == 0 {
+		return "", errors.Annotatef(berrors.ErrStorageInvalidPermission,
+			"invalid delegation token response of WebHDFS: %s", out)
+	}
+	return resp.Token.URLString, nil
+}
+
+// WebHDFSStorage represents the HDFS storage accessed by the WebHDFS REST API.
+type WebHDFSStorage struct {
+	remote   string
+	endpoint *url.URL
+	base     string
+	user     string
+	token    string
+	cli      *http.Client
+}
+
+func newWebHDFSStorage(
+	ctx context.Context,
+	remote string,
+	options *HDFSBackendOptions,
+	httpClient *http.Client,
+) (*WebHDFSStorage, error) {
+	endpoint, err := url.Parse(options.WebHDFSEndpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || len(endpoint.Host) == 0 {
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"invalid WebHDFS endpoint %s, it should be like http://namenode:9870", options.WebHDFSEndpoint)
+	}
+	u, err := ParseRawURL(remote)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "invalid hdfs url %s", remote)
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	s := &WebHDFSStorage{
+		remote:   remote,
+		endpoint: endpoint,
+		base:     path.Join("/", u.Path),
+		user:     options.User,
+		cli:      httpClient,
+	}
+	if len(options.KerberosKeytab) > 0 {
+		if len(options.KerberosPrincipal) == 0 {
+			return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+				"please specify --%s along with --%s", hdfsKerberosPrincipalOption, hdfsKerberosKeytabOption)
+		}
+		if s.token, err = getWebHDFSDelegationToken(ctx, options.WebHDFSEndpoint, options); err != nil {
+			return nil, errors.Trace(err)
+		}
+		log.Info("got the delegation token of WebHDFS", zap.String("principal", options.KerberosPrincipal))
+	}
+	return s, nil
+}
+
+// requestURL returns the URL of the operation on the file.
+func (s *WebHDFSStorage) requestURL(name, op string, params url.Values) string {
+	u := *s.endpoint
+	u.Path = webHDFSPathPrefix + path.Join(s.base, name)
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("op", op)
+	if len(s.token) > 0 {
+		params.Set("delegation", s.token)
+	} else if len(s.user) > 0 {
+		params.Set("user.name", s.user)
+	}
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+// webHDFSError is the error returned by WebHDFS.
+type webHDFSError struct {
+	RemoteException struct {
+		Exception string `json:"exception"`
+		Message   string `json:"message"`
+	} `json:"RemoteException"`
+}
+
+func (s *WebHDFSStorage) do(
+	ctx context.Context,
+	cli *http.Client,
+	method, target string,
+	body io.Reader,
+	expected int,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	resp, err := cli.Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if resp.StatusCode == expected {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	var remoteErr webHDFSError
+	msg := string(data)
+	if json.Unmarshal(data, &remoteErr) == nil && len(remoteErr.RemoteException.Exception) > 0 {
+		msg = remoteErr.RemoteException.Exception + ": " + remoteErr.RemoteException.Message
+	}
+	return resp, errors.Annotatef(berrors.ErrStorageUnknown, "WebHDFS %s %s failed, status %d, %s",
+		method, s.redact(target), resp.StatusCode, msg)
+}
+
+// redact removes the delegation token from the URL logged.
+func (s *WebHDFSStorage) redact(target string) string {
+	if len(s.token) == 0 {
+		return target
+	}
+	return strings.ReplaceAll(target, url.QueryEscape(s.token), "xxxxx")
+}
+
+// create sends the file creation to the name node, and returns the location of the data node to write.
+func (s *WebHDFSStorage) create(ctx context.Context, name string) (string, error) {
+	// the data is sent to the data node redirected to, rather than to the name node.
+	cli := *s.cli
+	cli.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := s.do(ctx, &cli, http.MethodPut,
+		s.requestURL(name, "CREATE", url.Values{"overwrite": {"true"}}), nil, http.StatusTemporaryRedirect)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if len(location) == 0 {
+		return "", errors.Annotatef(berrors.ErrStorageUnknown, "WebHDFS doesn't redirect the creation of %s", name)
+	}
+	return location, nil
+}
+
+// WriteFile writes a complete file to storage, similar to os.WriteFile.
+func (s *WebHDFSStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	defer observeWriteFile("webhdfs", time.Now())
+	location, err := s.create(ctx, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := s.do(ctx, s.cli, http.MethodPut, location, bytes.NewReader(data), http.StatusCreated)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(resp.Body.Close())
+}
+
+// ReadFile reads a complete file from storage, similar to os.ReadFile.
+func (s *WebHDFSStorage) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	resp, err := s.do(ctx, s.cli, http.MethodGet, s.requestURL(name, "OPEN", nil), nil, http.StatusOK)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return data, errors.Trace(err)
+}
+
+// webHDFSFileStatus is the status of a file or a directory.
+type webHDFSFileStatus struct {
+	PathSuffix string `json:"pathSuffix"`
+	Type       string `json:"type"`
+	Length     int64  `json:"length"`
+}
+
+// fileStatus returns the status of the file, or nil if it doesn't exist.
+func (s *WebHDFSStorage) fileStatus(ctx context.Context, name string) (*webHDFSFileStatus, error) {
+	resp, err := s.do(ctx, s.cli, http.MethodGet, s.requestURL(name, "GETFILESTATUS", nil), nil, http.StatusOK)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	var status struct {
+		FileStatus webHDFSFileStatus `json:"FileStatus"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageUnknown, "invalid file status of %s: %v", name, err)
+	}
+	return &status.FileStatus, nil
+}
+
+// FileExists return true if file exists.
+func (s *WebHDFSStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	status, err := s.fileStatus(ctx, name)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return status != nil, nil
+}
+
+// DeleteFile delete the file in storage.
+func (s *WebHDFSStorage) DeleteFile(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, s.cli, http.MethodDelete, s.requestURL(name, "DELETE", nil), nil, http.StatusOK)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(resp.Body.Close())
+}
+
+// listStatus lists the directory, it returns nothing if the directory doesn't exist.
+func (s *WebHDFSStorage) listStatus(ctx context.Context, dir string) ([]webHDFSFileStatus, error) {
+	resp, err := s.do(ctx, s.cli, http.MethodGet, s.requestURL(dir, "LISTSTATUS", nil), nil, http.StatusOK)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	var list struct {
+		FileStatuses struct {
+			FileStatus []webHDFSFileStatus `json:"FileStatus"`
+		} `json:"FileStatuses"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageUnknown, "invalid list of %s: %v", dir, err)
+	}
+	return list.FileStatuses.FileStatus, nil
+}
+
+// WalkDir traverse all the files in a dir.
+//
+// fn is the function called for each regular file visited by WalkDir.
+// The argument `path` is the file path that can be used in `Open`
+// function; the argument `size` is the size in byte of the file determined
+// by path.
+func (s *WebHDFSStorage) WalkDir(ctx context.Context, opt *WalkOption, fn func(path string, size int64) error) error {
+	if opt == nil {
+		opt = &WalkOption{}
+	}
+	return s.walk(ctx, opt.SubDir, fn)
+}
+
+func (s *WebHDFSStorage) walk(ctx context.Context, dir string, fn func(string, int64) error) error {
+	statuses, err := s.listStatus(ctx, dir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, status := range statuses {
+		name := path.Join(dir, status.PathSuffix)
+		if status.Type == "DIRECTORY" {
+			if err = s.walk(ctx, name, fn); err != nil {
+				return errors.Trace(err)
+			}
+			continue
+		}
+		if err = fn(name, status.Length); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// URI returns the base path as a URI.
+func (s *WebHDFSStorage) URI() string {
+	return s.remote
+}
+
+// Open a Reader by file path. path is relative path to storage base path.
+func (s *WebHDFSStorage) Open(ctx context.Context, name string) (ExternalFileReader, error) {
+	status, err := s.fileStatus(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if status == nil {
+		return nil, errors.Annotatef(berrors.ErrStorageUnknown, "file %s not found in %s", name, s.remote)
+	}
+	return &webHDFSFileReader{ctx: ctx, storage: s, name: name, size: status.Length}, nil
+}
+
+// Create opens a file writer by path. path is relative path to storage base path.
+// The data is streamed to the data node, the file is complete after the writer is closed.
+func (s *WebHDFSStorage) Create(ctx context.Context, name string) (ExternalFileWriter, error) {
+	location, err := s.create(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	pr, pw := io.Pipe()
+	w := &webHDFSFileWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		resp, err := s.do(ctx, s.cli, http.MethodPut, location, pr, http.StatusCreated)
+		if err == nil {
+			err = resp.Body.Close()
+		}
+		_ = pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+type webHDFSFileReader struct {
+	ctx     context.Context
+	storage *WebHDFSStorage
+	name    string
+	size    int64
+	pos     int64
+	reader  io.ReadCloser
+}
+
+// Read implements io.Reader.
+func (r *webHDFSFileReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.reader == nil {
+		params := url.Values{"offset": {strconv.FormatInt(r.pos, 10)}}
+		resp, err := r.storage.do(r.ctx, r.storage.cli, http.MethodGet,
+			r.storage.requestURL(r.name, "OPEN", params), nil, http.StatusOK)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		r.reader = resp.Body
+	}
+	n, err := r.reader.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (r *webHDFSFileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.Annotatef(berrors.ErrStorageUnknown, "Seek: invalid whence '%d'", whence)
+	}
+	if offset < 0 {
+		return 0, errors.Annotatef(berrors.ErrInvalidArgument, "Seek: offset '%d' out of range", offset)
+	}
+	if offset != r.pos && r.reader != nil {
+		_ = r.reader.Close()
+		r.reader = nil
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// Close implements io.Closer.
+func (r *webHDFSFileReader) Close() error {
+	if r.reader == nil {
+		return nil
+	}
+	return errors.Trace(r.reader.Close())
+}
+
+type webHDFSFileWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// Write implements ExternalFileWriter.
+func (w *webHDFSFileWriter) Write(_ context.Context, p []byte) (int, error) {
+	n, err := w.pw.Write(p)
+	return n, errors.Trace(err)
+}
+
+// Close implements ExternalFileWriter.
+func (w *webHDFSFileWriter) Close(_ context.Context) error {
+	if err := w.pw.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(<-w.done)
+}
@@ -0,0 +1,222 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// fakeWebHDFS is a WebHDFS server keeping the files in memory, it serves both the name node
+// and the data node.
+type fakeWebHDFS struct {
+	mu     sync.Mutex
+	files  map[string][]byte
+	tokens map[string]struct{}
+	srv    *httptest.Server
+}
+
+func newFakeWebHDFS() *fakeWebHDFS {
+	f := &fakeWebHDFS{files: make(map[string][]byte), tokens: make(map[string]struct{})}
+	f.srv = httptest.NewServer(f)
+	return f
+}
+
+func (f *fakeWebHDFS) notFound(w http.ResponseWriter, p string) {
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprintf(w, `{"RemoteException":{"exception":"FileNotFoundException","message":"File %s does not exist."}}`, p)
+}
+
+func (f *fakeWebHDFS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := r.URL.Query()
+	if len(f.tokens) > 0 {
+		if _, ok := f.tokens[q.Get("delegation")]; !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	p := strings.TrimPrefix(r.URL.Path, webHDFSPathPrefix)
+	switch q.Get("op") {
+	case "CREATE":
+		if q.Get("datanode") != "true" {
+			q.Set("datanode", "true")
+			w.Header().Set("Location", f.srv.URL+r.URL.Path+"?"+q.Encode())
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		f.files[p] = data
+		w.WriteHeader(http.StatusCreated)
+	case "OPEN":
+		data, ok := f.files[p]
+		if !ok {
+			f.notFound(w, p)
+			return
+		}
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		_, _ = w.Write(data[offset:])
+	case "GETFILESTATUS":
+		data, ok := f.files[p]
+		if !ok {
+			f.notFound(w, p)
+			return
+		}
+		fmt.Fprintf(w, `{"FileStatus":{"pathSuffix":"","type":"FILE","length":%d}}`, len(data))
+	case "LISTSTATUS":
+		dir := strings.TrimSuffix(p, "/") + "/"
+		children := make(map[string]webHDFSFileStatus)
+		for name, data := range f.files {
+			if !strings.HasPrefix(name, dir) {
+				continue
+			}
+			rest := strings.TrimPrefix(name, dir)
+			if i := strings.Index(rest, "/"); i >= 0 {
+				children[rest[:i]] = webHDFSFileStatus{PathSuffix: rest[:i], Type: "DIRECTORY"}
+			} else {
+				children[rest] = webHDFSFileStatus{PathSuffix: rest, Type: "FILE", Length: int64(len(data))}
+			}
+		}
+		if len(children) == 0 {
+			f.notFound(w, p)
+			return
+		}
+		var list struct {
+			FileStatuses struct {
+				FileStatus []webHDFSFileStatus `json:"FileStatus"`
+			} `json:"FileStatuses"`
+		}
+		for _, c := range children {
+			list.FileStatuses.FileStatus = append(list.FileStatuses.FileStatus, c)
+		}
+		_ = json.NewEncoder(w).Encode(&list)
+	case "DELETE":
+		_, ok := f.files[p]
+		delete(f.files, p)
+		fmt.Fprintf(w, `{"boolean":%v}`, ok)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestWebHDFSStorage(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeWebHDFS()
+	defer fake.srv.Close()
+
+	backend, err := ParseBackend("hdfs://namenode:8020/backup/", nil)
+	require.NoError(t, err)
+	s, err := New(ctx, backend, &ExternalStorageOptions{
+		HDFS: &HDFSBackendOptions{WebHDFSEndpoint: fake.srv.URL, User: "tikv"},
+	})
+	require.NoError(t, err)
+	require.IsType(t, &WebHDFSStorage{}, s)
+	require.Equal(t, "hdfs://namenode:8020/backup/", s.URI())
+
+	require.NoError(t, s.WriteFile(ctx, "backupmeta", []byte("meta")))
+	require.Contains(t, fake.files, "/backup/backupmeta")
+	data, err := s.ReadFile(ctx, "backupmeta")
+	require.NoError(t, err)
+	require.Equal(t, []byte("meta"), data)
+
+	exists, err := s.FileExists(ctx, "backupmeta")
+	require.NoError(t, err)
+	require.True(t, exists)
+	exists, err = s.FileExists(ctx, "missing")
+	require.NoError(t, err)
+	require.False(t, exists)
+	_, err = s.ReadFile(ctx, "missing")
+	require.True(t, berrors.Is(err, berrors.ErrStorageUnknown))
+	require.Contains(t, err.Error(), "FileNotFoundException")
+
+	w, err := s.Create(ctx, "1/2.sst")
+	require.NoError(t, err)
+	_, err = w.Write(ctx, []byte("0123"))
+	require.NoError(t, err)
+	_, err = w.Write(ctx, []byte("456789"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close(ctx))
+
+	r, err := s.Open(ctx, "1/2.sst")
+	require.NoError(t, err)
+	offset, err := r.Seek(-4, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), offset)
+	data, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, []byte("6789"), data)
+	require.NoError(t, r.Close())
+
+	var files []string
+	require.NoError(t, s.WalkDir(ctx, nil, func(name string, size int64) error {
+		files = append(files, fmt.Sprintf("%s:%d", name, size))
+		return nil
+	}))
+	sort.Strings(files)
+	require.Equal(t, []string{"1/2.sst:10", "backupmeta:4"}, files)
+
+	require.NoError(t, s.DeleteFile(ctx, "backupmeta"))
+	exists, err = s.FileExists(ctx, "backupmeta")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestWebHDFSKerberos(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeWebHDFS()
+	defer fake.srv.Close()
+	fake.tokens["token-1"] = struct{}{}
+
+	origin := runCommand
+	defer func() { runCommand = origin }()
+	var commands []string
+	runCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		if name == "curl" {
+			return []byte(`{"Token":{"urlString":"token-1"}}`), nil
+		}
+		return nil, nil
+	}
+
+	options := &HDFSBackendOptions{WebHDFSEndpoint: fake.srv.URL, KerberosKeytab: "/etc/br.keytab"}
+	_, err := newWebHDFSStorage(ctx, "hdfs:///backup", options, nil)
+	require.True(t, berrors.Is(err, berrors.ErrStorageInvalidConfig))
+
+	options.KerberosPrincipal = "br@EXAMPLE.COM"
+	s, err := newWebHDFSStorage(ctx, "hdfs:///backup", options, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"kinit -k -t /etc/br.keytab br@EXAMPLE.COM",
+		"curl -sS --fail --negotiate -u : " + fake.srv.URL + "/webhdfs/v1/?op=GETDELEGATIONTOKEN",
+	}, commands)
+	require.NoError(t, s.WriteFile(ctx, "backupmeta", []byte("meta")))
+	require.Contains(t, fake.files, "/backup/backupmeta")
+
+	_, err = newWebHDFSStorage(ctx, "hdfs:///backup", &HDFSBackendOptions{WebHDFSEndpoint: "namenode:9870"}, nil)
+	require.True(t, berrors.Is(err, berrors.ErrStorageInvalidConfig))
+}
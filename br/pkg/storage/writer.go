@@ -6,7 +6,11 @@ import (
 	"context"
 	"io"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/pingcap/errors"
+	"github.com/pingcap/failpoint"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/failpoints"
 )
 
 // CompressType represents the type of compression.
@@ -17,6 +21,8 @@ const (
 	NoCompression CompressType = iota
 	// Gzip will compress given bytes in gzip format.
 	Gzip
+	// Zstd will compress given bytes in zstd format.
+	Zstd
 )
 
 type flusher interface {
@@ -49,6 +55,10 @@ func newCompressWriter(compressType CompressType, w io.Writer) simpleCompressWri
 	switch compressType {
 	case Gzip:
 		return gzip.NewWriter(w)
+	case Zstd:
+		// it never fails without options.
+		encoder, _ := zstd.NewWriter(w)
+		return encoder
 	default:
 		return nil
 	}
@@ -58,6 +68,12 @@ func newCompressReader(compressType CompressType, r io.Reader) (io.ReadCloser, e
 	switch compressType {
 	case Gzip:
 		return gzip.NewReader(r)
+	case Zstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return decoder.IOReadCloser(), nil
 	default:
 		return nil, nil
 	}
@@ -172,6 +188,9 @@ func (u *bufferedWriter) uploadChunk(ctx context.Context) error {
 }
 
 func (u *bufferedWriter) Close(ctx context.Context) error {
+	failpoint.Inject(failpoints.StorageFinalizeError, func(val failpoint.Value) {
+		failpoint.Return(errors.Annotatef(berrors.ErrStorageUnknown, "failpoint: %v", val))
+	})
 	u.buf.Close()
 	err := u.uploadChunk(ctx)
 	if err != nil {
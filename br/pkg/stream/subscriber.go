@@ -0,0 +1,472 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package stream
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/cdcpb"
+	"github.com/pingcap/log"
+	"github.com/tikv/client-go/v2/util/codec"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/redact"
+	"github.com/tikv/migration/br/pkg/rtree"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	scanRegionLimit = 64
+	// requestVersion is the version of TiKV-CDC which the requests are compatible with,
+	// TiKV enables the features of the change data service by it.
+	requestVersion = "1.0.0"
+	// retryInterval is the interval before subscribing the spans failed again.
+	retryInterval = time.Second
+)
+
+// RegionScanner locates the regions of the key ranges, it's implemented by pd.Client.
+type RegionScanner interface {
+	ScanRegions(ctx context.Context, key, endKey []byte, limit int) ([]*pd.Region, error)
+}
+
+// ChangeDataClients connects the change data service of the stores, it's implemented by conn.Mgr.
+type ChangeDataClients interface {
+	GetChangeDataClient(ctx context.Context, storeID uint64) (cdcpb.ChangeDataClient, error)
+	ResetChangeDataClient(ctx context.Context, storeID uint64) (cdcpb.ChangeDataClient, error)
+}
+
+// regionFeed is the subscription of the span of a region.
+type regionFeed struct {
+	requestID uint64
+	regionID  uint64
+	storeID   uint64
+	// span is in the encoded format of the region keys.
+	span rtree.Range
+	// resolvedTS is the ts before which all the changes of the span are received.
+	resolvedTS  uint64
+	initialized bool
+}
+
+// pendingSpan is a span waiting to be subscribed from ts, which is divided into the
+// region feeds by the regions it covers.
+type pendingSpan struct {
+	id   uint64
+	span rtree.Range
+	ts   uint64
+}
+
+type storeStream struct {
+	storeID uint64
+	client  cdcpb.ChangeData_EventFeedClient
+	cancel  context.CancelFunc
+	sendMu  sync.Mutex
+}
+
+// Subscriber subscribes the change data of the API V2 raw key ranges from TiKV, by an event
+// feed of each region, and tracks the watermark before which all the changes are received.
+type Subscriber struct {
+	regions   RegionScanner
+	clients   ChangeDataClients
+	clusterID uint64
+	onEvent   func(*metautil.ChangelogEvent)
+
+	eg  *errgroup.Group
+	ctx context.Context
+
+	mu      sync.Mutex
+	nextID  uint64
+	feeds   map[uint64]*regionFeed
+	streams map[uint64]*storeStream
+	// resetStores are the stores whose connections are broken.
+	resetStores map[uint64]struct{}
+	// pending are the resolved ts of the spans being subscribed, by the ids of the spans.
+	pending map[uint64]uint64
+	queue   []*pendingSpan
+	notify  chan struct{}
+}
+
+// NewSubscriber creates a Subscriber, onEvent is called with the changes in the order they
+// are received from each region, before the watermark advances over them.
+func NewSubscriber(
+	regions RegionScanner, clients ChangeDataClients, clusterID uint64, onEvent func(*metautil.ChangelogEvent),
+) *Subscriber {
+	return &Subscriber{
+		regions:     regions,
+		clients:     clients,
+		clusterID:   clusterID,
+		onEvent:     onEvent,
+		feeds:       make(map[uint64]*regionFeed),
+		streams:     make(map[uint64]*storeStream),
+		resetStores: make(map[uint64]struct{}),
+		pending:     make(map[uint64]uint64),
+		notify:      make(chan struct{}, 1),
+	}
+}
+
+// Run subscribes the changes of the ranges after startTS until ctx is done or an
+// unrecoverable error occurs. The ranges are in the API V2 format of raw keys.
+func (s *Subscriber) Run(ctx context.Context, ranges []rtree.Range, startTS uint64) error {
+	s.eg, s.ctx = errgroup.WithContext(ctx)
+	for _, rg := range ranges {
+		span := rtree.Range{StartKey: codec.EncodeBytes(nil, rg.StartKey)}
+		if len(rg.EndKey) > 0 {
+			span.EndKey = codec.EncodeBytes(nil, rg.EndKey)
+		}
+		s.schedule(span, startTS)
+	}
+	s.eg.Go(func() error {
+		return s.runScheduler(s.ctx)
+	})
+	return s.eg.Wait()
+}
+
+// Watermark returns the ts before which all the changes of the ranges are received.
+func (s *Subscriber) Watermark() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var watermark uint64
+	first := true
+	for _, feed := range s.feeds {
+		if first || feed.resolvedTS < watermark {
+			watermark, first = feed.resolvedTS, false
+		}
+	}
+	for _, ts := range s.pending {
+		if first || ts < watermark {
+			watermark, first = ts, false
+		}
+	}
+	return watermark
+}
+
+// schedule subscribes the span from ts later.
+func (s *Subscriber) schedule(span rtree.Range, ts uint64) {
+	s.mu.Lock()
+	s.scheduleLocked(span, ts)
+	s.mu.Unlock()
+}
+
+func (s *Subscriber) scheduleLocked(span rtree.Range, ts uint64) {
+	s.nextID++
+	s.pending[s.nextID] = ts
+	s.queue = append(s.queue, &pendingSpan{id: s.nextID, span: span, ts: ts})
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Subscriber) runScheduler(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-s.notify:
+		}
+		s.mu.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+		var failed []*pendingSpan
+		for _, p := range queue {
+			if err := s.subscribe(ctx, p); err != nil {
+				if berrors.Is(err, berrors.ErrKVClusterIDMismatch) || berrors.Is(err, berrors.ErrVersionMismatch) {
+					return errors.Trace(err)
+				}
+				log.Warn("failed to subscribe the span, retry it later",
+					logutil.Key("start-key", p.span.StartKey), logutil.Key("end-key", p.span.EndKey), zap.Error(err))
+				failed = append(failed, p)
+			}
+		}
+		if len(failed) > 0 {
+			select {
+			case <-ctx.Done():
+				return errors.Trace(ctx.Err())
+			case <-time.After(retryInterval):
+			}
+			s.mu.Lock()
+			s.queue = append(s.queue, failed...)
+			s.mu.Unlock()
+			select {
+			case s.notify <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// subscribe divides the span by the regions and sends a request to the leader of each region.
+// On error, the span is shrunk to the part not subscribed yet.
+func (s *Subscriber) subscribe(ctx context.Context, p *pendingSpan) error {
+	for {
+		regions, err := s.regions.ScanRegions(ctx, p.span.StartKey, p.span.EndKey, scanRegionLimit)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(regions) == 0 || bytes.Compare(regions[0].Meta.GetStartKey(), p.span.StartKey) > 0 {
+			return errors.Annotatef(berrors.ErrPDBatchScanRegion, "the regions don't cover the key %s", redact.Key(p.span.StartKey))
+		}
+		for _, region := range regions {
+			if bytes.Compare(region.Meta.GetStartKey(), p.span.StartKey) > 0 {
+				return errors.Annotatef(berrors.ErrPDBatchScanRegion, "the regions don't cover the key %s", redact.Key(p.span.StartKey))
+			}
+			if region.Leader == nil || region.Leader.GetStoreId() == 0 {
+				return errors.Annotatef(berrors.ErrBackupNoLeader, "region %d has no leader", region.Meta.GetId())
+			}
+			span := rtree.Range{StartKey: p.span.StartKey, EndKey: region.Meta.GetEndKey()}
+			done := len(span.EndKey) == 0 || (len(p.span.EndKey) > 0 && bytes.Compare(span.EndKey, p.span.EndKey) >= 0)
+			if done {
+				span.EndKey = p.span.EndKey
+			}
+			if err = s.subscribeRegion(ctx, region, span, p.ts); err != nil {
+				return errors.Trace(err)
+			}
+			p.span.StartKey = span.EndKey
+			if done {
+				s.mu.Lock()
+				delete(s.pending, p.id)
+				s.mu.Unlock()
+				return nil
+			}
+		}
+	}
+}
+
+func (s *Subscriber) subscribeRegion(ctx context.Context, region *pd.Region, span rtree.Range, ts uint64) error {
+	storeID := region.Leader.GetStoreId()
+	stream, err := s.getStream(ctx, storeID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.mu.Lock()
+	s.nextID++
+	feed := &regionFeed{
+		requestID:  s.nextID,
+		regionID:   region.Meta.GetId(),
+		storeID:    storeID,
+		span:       span,
+		resolvedTS: ts,
+	}
+	s.feeds[feed.requestID] = feed
+	s.mu.Unlock()
+
+	req := &cdcpb.ChangeDataRequest{
+		Header:       &cdcpb.Header{ClusterId: s.clusterID, TicdcVersion: requestVersion},
+		RegionId:     feed.regionID,
+		RegionEpoch:  region.Meta.GetRegionEpoch(),
+		CheckpointTs: ts,
+		StartKey:     span.StartKey,
+		EndKey:       span.EndKey,
+		RequestId:    feed.requestID,
+		KvApi:        cdcpb.ChangeDataRequest_RawKV,
+	}
+	stream.sendMu.Lock()
+	err = stream.client.Send(req)
+	stream.sendMu.Unlock()
+	if err != nil {
+		// the feeds of the stream are scheduled again by the receiver.
+		s.mu.Lock()
+		delete(s.feeds, feed.requestID)
+		s.mu.Unlock()
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func (s *Subscriber) getStream(ctx context.Context, storeID uint64) (*storeStream, error) {
+	s.mu.Lock()
+	stream, ok := s.streams[storeID]
+	_, reset := s.resetStores[storeID]
+	s.mu.Unlock()
+	if ok {
+		return stream, nil
+	}
+
+	var client cdcpb.ChangeDataClient
+	var err error
+	if reset {
+		client, err = s.clients.ResetChangeDataClient(ctx, storeID)
+	} else {
+		client, err = s.clients.GetChangeDataClient(ctx, storeID)
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	streamCtx, cancel := context.WithCancel(s.ctx)
+	eventFeed, err := client.EventFeed(streamCtx)
+	if err != nil {
+		cancel()
+		return nil, errors.Trace(err)
+	}
+	stream = &storeStream{storeID: storeID, client: eventFeed, cancel: cancel}
+	s.mu.Lock()
+	s.streams[storeID] = stream
+	delete(s.resetStores, storeID)
+	s.mu.Unlock()
+	log.Info("subscribe the change data of store", zap.Uint64("store-id", storeID))
+	s.eg.Go(func() error {
+		return s.receive(streamCtx, stream)
+	})
+	return stream, nil
+}
+
+func (s *Subscriber) receive(ctx context.Context, stream *storeStream) error {
+	for {
+		event, err := stream.client.Recv()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return errors.Trace(s.ctx.Err())
+			}
+			log.Warn("the change data stream of store is broken, subscribe its regions again",
+				zap.Uint64("store-id", stream.storeID), zap.Error(err))
+			s.dropStream(stream)
+			return nil
+		}
+		if err = s.handleEvent(stream, event); err != nil {
+			return errors.Trace(err)
+		}
+	}
+}
+
+// dropStream closes the stream, and subscribes the spans of its regions again.
+func (s *Subscriber) dropStream(stream *storeStream) {
+	stream.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams[stream.storeID] == stream {
+		delete(s.streams, stream.storeID)
+		s.resetStores[stream.storeID] = struct{}{}
+	}
+	for _, feed := range s.feeds {
+		if feed.storeID == stream.storeID {
+			s.retryLocked(feed)
+		}
+	}
+}
+
+// retryLocked cancels the feed, and subscribes its span again from its resolved ts.
+func (s *Subscriber) retryLocked(feed *regionFeed) {
+	if s.feeds[feed.requestID] != feed {
+		return
+	}
+	delete(s.feeds, feed.requestID)
+	s.scheduleLocked(feed.span, feed.resolvedTS)
+}
+
+func (s *Subscriber) handleEvent(stream *storeStream, event *cdcpb.ChangeDataEvent) error {
+	for _, e := range event.Events {
+		s.mu.Lock()
+		feed, ok := s.feeds[e.RequestId]
+		s.mu.Unlock()
+		if !ok || feed.storeID != stream.storeID {
+			// the feed has been cancelled.
+			continue
+		}
+		switch x := e.Event.(type) {
+		case *cdcpb.Event_Entries_:
+			if err := s.handleEntries(feed, x.Entries.GetEntries()); err != nil {
+				return errors.Trace(err)
+			}
+		case *cdcpb.Event_ResolvedTs:
+			s.mu.Lock()
+			s.advanceLocked(feed, x.ResolvedTs)
+			s.mu.Unlock()
+		case *cdcpb.Event_Error:
+			if err := regionError(x.Error); err != nil {
+				return errors.Trace(err)
+			}
+			log.Info("the region feed meets an error, subscribe its span again",
+				zap.Uint64("region-id", feed.regionID), zap.Stringer("error", x.Error))
+			s.mu.Lock()
+			s.retryLocked(feed)
+			s.mu.Unlock()
+		}
+	}
+	if resolved := event.ResolvedTs; resolved != nil {
+		regions := make(map[uint64]struct{}, len(resolved.Regions))
+		for _, regionID := range resolved.Regions {
+			regions[regionID] = struct{}{}
+		}
+		s.mu.Lock()
+		for _, feed := range s.feeds {
+			if _, ok := regions[feed.regionID]; ok && feed.storeID == stream.storeID {
+				s.advanceLocked(feed, resolved.Ts)
+			}
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *Subscriber) handleEntries(feed *regionFeed, rows []*cdcpb.Event_Row) error {
+	for _, row := range rows {
+		switch row.Type {
+		case cdcpb.Event_INITIALIZED:
+			s.mu.Lock()
+			feed.initialized = true
+			s.mu.Unlock()
+			continue
+		case cdcpb.Event_COMMITTED:
+		default:
+			continue
+		}
+		// TiKV sends the changes of the whole region, regardless of the span requested.
+		if !feed.span.Contains(codec.EncodeBytes(nil, row.Key)) {
+			continue
+		}
+		event := &metautil.ChangelogEvent{
+			Key:       row.Key,
+			CommitTS:  row.CommitTs,
+			ExpiredTS: row.ExpireTsUnixSecs,
+		}
+		switch row.OpType {
+		case cdcpb.Event_Row_PUT:
+			event.OpType = metautil.ChangelogOpPut
+			event.Value = row.Value
+		case cdcpb.Event_Row_DELETE:
+			event.OpType = metautil.ChangelogOpDelete
+		default:
+			return errors.Annotatef(berrors.ErrKVUnknown, "unknown op type %s of the change data", row.OpType)
+		}
+		s.onEvent(event)
+	}
+	return nil
+}
+
+// advanceLocked advances the resolved ts of the feed, which only takes effect after
+// the incremental scan of the region is finished.
+func (s *Subscriber) advanceLocked(feed *regionFeed, ts uint64) {
+	if feed.initialized && ts > feed.resolvedTS {
+		feed.resolvedTS = ts
+	}
+}
+
+// regionError returns the error which can't be recovered by subscribing the region again.
+func regionError(err *cdcpb.Error) error {
+	switch {
+	case err.GetClusterIdMismatch() != nil:
+		return errors.Annotatef(berrors.ErrKVClusterIDMismatch, "%s", err.GetClusterIdMismatch())
+	case err.GetCompatibility() != nil:
+		return errors.Annotatef(berrors.ErrVersionMismatch,
+			"the change data service of TiKV is incompatible: %s", err.GetCompatibility())
+	}
+	return nil
+}
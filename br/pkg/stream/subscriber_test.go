@@ -0,0 +1,219 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package stream
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/cdcpb"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/util/codec"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/rtree"
+	pd "github.com/tikv/pd/client"
+	"google.golang.org/grpc"
+)
+
+func rawKey(key string) []byte {
+	return append([]byte{'r', 0, 0, 0}, key...)
+}
+
+type fakeRegionScanner struct {
+	regions []*pd.Region
+}
+
+func (f *fakeRegionScanner) ScanRegions(_ context.Context, key, endKey []byte, limit int) ([]*pd.Region, error) {
+	var regions []*pd.Region
+	for _, region := range f.regions {
+		if len(region.Meta.EndKey) > 0 && bytes.Compare(region.Meta.EndKey, key) <= 0 {
+			continue
+		}
+		if len(endKey) > 0 && bytes.Compare(region.Meta.StartKey, endKey) >= 0 {
+			continue
+		}
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+type fakeEventFeed struct {
+	grpc.ClientStream
+	ctx    context.Context
+	sent   chan<- *cdcpb.ChangeDataRequest
+	events chan *cdcpb.ChangeDataEvent
+}
+
+func (f *fakeEventFeed) Send(req *cdcpb.ChangeDataRequest) error {
+	f.sent <- req
+	return nil
+}
+
+func (f *fakeEventFeed) Recv() (*cdcpb.ChangeDataEvent, error) {
+	select {
+	case <-f.ctx.Done():
+		return nil, f.ctx.Err()
+	case event, ok := <-f.events:
+		if !ok {
+			return nil, io.EOF
+		}
+		return event, nil
+	}
+}
+
+type fakeChangeDataClients struct {
+	mu     sync.Mutex
+	sent   chan *cdcpb.ChangeDataRequest
+	feeds  []*fakeEventFeed
+	resets int
+}
+
+func (f *fakeChangeDataClients) EventFeed(ctx context.Context, _ ...grpc.CallOption) (cdcpb.ChangeData_EventFeedClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	feed := &fakeEventFeed{ctx: ctx, sent: f.sent, events: make(chan *cdcpb.ChangeDataEvent, 16)}
+	f.feeds = append(f.feeds, feed)
+	return feed, nil
+}
+
+func (f *fakeChangeDataClients) GetChangeDataClient(context.Context, uint64) (cdcpb.ChangeDataClient, error) {
+	return f, nil
+}
+
+func (f *fakeChangeDataClients) ResetChangeDataClient(context.Context, uint64) (cdcpb.ChangeDataClient, error) {
+	f.mu.Lock()
+	f.resets++
+	f.mu.Unlock()
+	return f, nil
+}
+
+func (f *fakeChangeDataClients) lastFeed() *fakeEventFeed {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.feeds[len(f.feeds)-1]
+}
+
+func recvRequests(t *testing.T, sent <-chan *cdcpb.ChangeDataRequest, n int) map[uint64]*cdcpb.ChangeDataRequest {
+	reqs := make(map[uint64]*cdcpb.ChangeDataRequest, n)
+	for i := 0; i < n; i++ {
+		select {
+		case req := <-sent:
+			reqs[req.RegionId] = req
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timeout to wait for the requests")
+		}
+	}
+	return reqs
+}
+
+func TestSubscriber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	regions := &fakeRegionScanner{regions: []*pd.Region{
+		{
+			Meta:   &metapb.Region{Id: 1, StartKey: codec.EncodeBytes(nil, rawKey("")), EndKey: codec.EncodeBytes(nil, rawKey("m"))},
+			Leader: &metapb.Peer{StoreId: 1},
+		},
+		{
+			Meta:   &metapb.Region{Id: 2, StartKey: codec.EncodeBytes(nil, rawKey("m")), EndKey: codec.EncodeBytes(nil, []byte("s"))},
+			Leader: &metapb.Peer{StoreId: 1},
+		},
+	}}
+	clients := &fakeChangeDataClients{sent: make(chan *cdcpb.ChangeDataRequest, 16)}
+	received := make(chan *metautil.ChangelogEvent, 16)
+	sub := NewSubscriber(regions, clients, 7, func(event *metautil.ChangelogEvent) {
+		received <- event
+	})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sub.Run(ctx, []rtree.Range{{StartKey: rawKey("a"), EndKey: rawKey("z")}}, 100)
+	}()
+
+	reqs := recvRequests(t, clients.sent, 2)
+	require.Equal(t, codec.EncodeBytes(nil, rawKey("a")), reqs[1].StartKey)
+	require.Equal(t, codec.EncodeBytes(nil, rawKey("m")), reqs[1].EndKey)
+	require.Equal(t, codec.EncodeBytes(nil, rawKey("m")), reqs[2].StartKey)
+	require.Equal(t, codec.EncodeBytes(nil, rawKey("z")), reqs[2].EndKey)
+	for _, req := range reqs {
+		require.Equal(t, uint64(100), req.CheckpointTs)
+		require.Equal(t, uint64(7), req.Header.ClusterId)
+		require.Equal(t, cdcpb.ChangeDataRequest_RawKV, req.KvApi)
+	}
+	require.Equal(t, uint64(100), sub.Watermark())
+
+	// the changes out of the span are filtered, and the resolved ts takes effect after
+	// the region feed is initialized.
+	feed := clients.lastFeed()
+	feed.events <- &cdcpb.ChangeDataEvent{Events: []*cdcpb.Event{
+		{RequestId: reqs[1].RequestId, Event: &cdcpb.Event_Entries_{Entries: &cdcpb.Event_Entries{Entries: []*cdcpb.Event_Row{
+			{Type: cdcpb.Event_INITIALIZED},
+			{Type: cdcpb.Event_COMMITTED, OpType: cdcpb.Event_Row_PUT, Key: rawKey("0"), Value: []byte("v0"), CommitTs: 101},
+			{Type: cdcpb.Event_COMMITTED, OpType: cdcpb.Event_Row_PUT, Key: rawKey("b"), Value: []byte("v1"), CommitTs: 105, ExpireTsUnixSecs: 300},
+		}}}},
+		{RequestId: reqs[2].RequestId, Event: &cdcpb.Event_ResolvedTs{ResolvedTs: 120}},
+	}}
+	feed.events <- &cdcpb.ChangeDataEvent{ResolvedTs: &cdcpb.ResolvedTs{Regions: []uint64{1, 2}, Ts: 110}}
+	event := <-received
+	require.Equal(t, &metautil.ChangelogEvent{
+		OpType: metautil.ChangelogOpPut, Key: rawKey("b"), Value: []byte("v1"), CommitTS: 105, ExpiredTS: 300,
+	}, event)
+	require.Never(t, func() bool { return sub.Watermark() != 100 }, 200*time.Millisecond, 10*time.Millisecond)
+
+	feed.events <- &cdcpb.ChangeDataEvent{Events: []*cdcpb.Event{
+		{RequestId: reqs[2].RequestId, Event: &cdcpb.Event_Entries_{Entries: &cdcpb.Event_Entries{Entries: []*cdcpb.Event_Row{
+			{Type: cdcpb.Event_INITIALIZED},
+			{Type: cdcpb.Event_COMMITTED, OpType: cdcpb.Event_Row_DELETE, Key: rawKey("n"), CommitTs: 108},
+		}}}},
+	}}
+	feed.events <- &cdcpb.ChangeDataEvent{ResolvedTs: &cdcpb.ResolvedTs{Regions: []uint64{1, 2}, Ts: 110}}
+	event = <-received
+	require.Equal(t, &metautil.ChangelogEvent{OpType: metautil.ChangelogOpDelete, Key: rawKey("n"), CommitTS: 108}, event)
+	require.Eventually(t, func() bool { return sub.Watermark() == 110 }, 5*time.Second, 10*time.Millisecond)
+
+	// a region error subscribes the span again from its resolved ts.
+	feed.events <- &cdcpb.ChangeDataEvent{Events: []*cdcpb.Event{
+		{RequestId: reqs[1].RequestId, Event: &cdcpb.Event_Error{Error: &cdcpb.Error{NotLeader: &errorpb.NotLeader{RegionId: 1}}}},
+	}}
+	retried := recvRequests(t, clients.sent, 1)
+	require.Equal(t, codec.EncodeBytes(nil, rawKey("a")), retried[1].StartKey)
+	require.Equal(t, uint64(110), retried[1].CheckpointTs)
+	require.NotEqual(t, reqs[1].RequestId, retried[1].RequestId)
+	require.Equal(t, uint64(110), sub.Watermark())
+
+	// a broken stream subscribes all the regions of the store again by a new connection.
+	close(feed.events)
+	reqs = recvRequests(t, clients.sent, 2)
+	require.Len(t, reqs, 2)
+	require.Equal(t, 1, clients.resets)
+	require.NotSame(t, feed, clients.lastFeed())
+
+	// the cluster id mismatch can't be recovered.
+	clients.lastFeed().events <- &cdcpb.ChangeDataEvent{Events: []*cdcpb.Event{
+		{RequestId: reqs[2].RequestId, Event: &cdcpb.Event_Error{Error: &cdcpb.Error{ClusterIdMismatch: &cdcpb.ClusterIDMismatch{Current: 8, Request: 7}}}},
+	}}
+	select {
+	case err := <-errCh:
+		require.True(t, berrors.Is(err, berrors.ErrKVClusterIDMismatch), err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timeout to wait for the subscriber")
+	}
+}
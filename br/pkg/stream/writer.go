@@ -0,0 +1,142 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package stream
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// Writer persists the changes into the changelog files of the storage, in the format which
+// `br restore raw --changelog-storage` replays.
+type Writer struct {
+	storage storage.ExternalStorage
+	// id distinguishes the files written by different runs.
+	id  string
+	seq uint64
+
+	// flushMu serializes the flushes.
+	flushMu sync.Mutex
+	mu      sync.Mutex
+	buffer  []*metautil.ChangelogEvent
+	meta    metautil.ChangelogMeta
+}
+
+// NewWriter creates a Writer. If the storage has a changelog already, the writer resumes
+// from its checkpoint, otherwise a new changelog starting from startTS is created.
+func NewWriter(ctx context.Context, s storage.ExternalStorage, startTS uint64) (*Writer, error) {
+	meta, err := metautil.ReadChangelogMeta(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if meta == nil {
+		meta = &metautil.ChangelogMeta{StartTS: startTS, CheckpointTS: startTS}
+		if err = metautil.WriteChangelogMeta(ctx, s, meta); err != nil {
+			return nil, errors.Trace(err)
+		}
+	} else {
+		log.Info("resume the changelog in the storage",
+			zap.Uint64("start-ts", meta.StartTS), zap.Uint64("checkpoint-ts", meta.CheckpointTS))
+	}
+	return &Writer{
+		storage: s,
+		id:      uuid.New().String(),
+		meta:    *meta,
+	}, nil
+}
+
+// Checkpoint returns the ts before which all the changes are persisted.
+func (w *Writer) Checkpoint() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.meta.CheckpointTS
+}
+
+// Append buffers the event until it's flushed. Events not after the checkpoint are dropped,
+// they are persisted already.
+func (w *Writer) Append(event *metautil.ChangelogEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if event.CommitTS > w.meta.CheckpointTS {
+		w.buffer = append(w.buffer, event)
+	}
+}
+
+// Flush writes the buffered events whose commit ts are not after watermark into a changelog
+// file, then advances the checkpoint to watermark. It returns the number of events written.
+func (w *Writer) Flush(ctx context.Context, watermark uint64) (int, error) {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	meta := w.meta
+	if watermark <= meta.CheckpointTS {
+		w.mu.Unlock()
+		return 0, nil
+	}
+	var flushed, remained []*metautil.ChangelogEvent
+	for _, event := range w.buffer {
+		if event.CommitTS <= watermark {
+			flushed = append(flushed, event)
+		} else {
+			remained = append(remained, event)
+		}
+	}
+	w.buffer = remained
+	w.mu.Unlock()
+
+	if err := w.writeFile(ctx, flushed); err != nil {
+		// put them back, they are written in the next flush.
+		w.mu.Lock()
+		w.buffer = append(flushed, w.buffer...)
+		w.mu.Unlock()
+		return 0, errors.Trace(err)
+	}
+	meta.CheckpointTS = watermark
+	if err := metautil.WriteChangelogMeta(ctx, w.storage, &meta); err != nil {
+		// the file written is harmless, the events are replayed idempotently.
+		return 0, errors.Trace(err)
+	}
+	w.mu.Lock()
+	w.meta = meta
+	w.mu.Unlock()
+	return len(flushed), nil
+}
+
+func (w *Writer) writeFile(ctx context.Context, events []*metautil.ChangelogEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	minTS, maxTS := events[0].CommitTS, events[0].CommitTS
+	for _, event := range events {
+		if event.CommitTS < minTS {
+			minTS = event.CommitTS
+		}
+		if event.CommitTS > maxTS {
+			maxTS = event.CommitTS
+		}
+	}
+	data, err := metautil.EncodeChangelogEvents(events)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	w.seq++
+	return errors.Trace(w.storage.WriteFile(ctx, metautil.ChangelogFileName(minTS, maxTS, w.id, w.seq), data))
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func readChangelog(t *testing.T, ctx context.Context, s storage.ExternalStorage) map[string][]*metautil.ChangelogEvent {
+	files := make(map[string][]*metautil.ChangelogEvent)
+	err := s.WalkDir(ctx, &storage.WalkOption{SubDir: metautil.ChangelogDir}, func(name string, _ int64) error {
+		data, err := s.ReadFile(ctx, name)
+		require.NoError(t, err)
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			event := &metautil.ChangelogEvent{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), event))
+			files[filepath.Base(name)] = append(files[filepath.Base(name)], event)
+		}
+		return scanner.Err()
+	})
+	require.NoError(t, err)
+	return files
+}
+
+func TestWriter(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, metautil.ChangelogDir), 0o755))
+	s, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+
+	w, err := NewWriter(ctx, s, 100)
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), w.Checkpoint())
+	meta, err := metautil.ReadChangelogMeta(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, &metautil.ChangelogMeta{Version: 1, StartTS: 100, CheckpointTS: 100}, meta)
+
+	// the events not after the checkpoint are dropped.
+	w.Append(&metautil.ChangelogEvent{OpType: metautil.ChangelogOpPut, Key: []byte("ra"), Value: []byte("v0"), CommitTS: 100})
+	w.Append(&metautil.ChangelogEvent{OpType: metautil.ChangelogOpPut, Key: []byte("ra"), Value: []byte("v1"), CommitTS: 102})
+	w.Append(&metautil.ChangelogEvent{OpType: metautil.ChangelogOpDelete, Key: []byte("rb"), CommitTS: 101})
+	w.Append(&metautil.ChangelogEvent{OpType: metautil.ChangelogOpPut, Key: []byte("rc"), Value: []byte("v2"), CommitTS: 105, ExpiredTS: 200})
+
+	n, err := w.Flush(ctx, 103)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, uint64(103), w.Checkpoint())
+	files := readChangelog(t, ctx, s)
+	require.Len(t, files, 1)
+	for name, events := range files {
+		minTS, maxTS, ok := metautil.ParseChangelogFileName(name)
+		require.True(t, ok)
+		require.Equal(t, uint64(101), minTS)
+		require.Equal(t, uint64(102), maxTS)
+		require.Len(t, events, 2)
+	}
+
+	// the watermark not advanced writes nothing.
+	n, err = w.Flush(ctx, 103)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	// the checkpoint advances without any event.
+	n, err = w.Flush(ctx, 104)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	require.Len(t, readChangelog(t, ctx, s), 1)
+
+	// the next writer resumes from the checkpoint, regardless of the start ts.
+	w, err = NewWriter(ctx, s, 200)
+	require.NoError(t, err)
+	require.Equal(t, uint64(104), w.Checkpoint())
+	w.Append(&metautil.ChangelogEvent{OpType: metautil.ChangelogOpPut, Key: []byte("rc"), Value: []byte("v2"), CommitTS: 105, ExpiredTS: 200})
+	n, err = w.Flush(ctx, 110)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Len(t, readChangelog(t, ctx, s), 2)
+	meta, err = metautil.ReadChangelogMeta(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, &metautil.ChangelogMeta{Version: 1, StartTS: 100, CheckpointTS: 110}, meta)
+}
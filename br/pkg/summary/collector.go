@@ -4,6 +4,11 @@ package summary
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -11,6 +16,7 @@ import (
 	"github.com/docker/go-units"
 	berror "github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
 	"go.uber.org/zap"
 )
 
@@ -28,8 +34,50 @@ const (
 	BackupDataSize = "backup data size(after compressed)"
 	// RestoreDataSize is a field we collection after restore finish
 	RestoreDataSize = "restore data size(after compressed)"
+
+	// DefaultMaxFailureUnits is the default number of the failed units logged in summary.
+	DefaultMaxFailureUnits = 100
+)
+
+// RedactLevel is how the failed units are redacted in the summary log.
+type RedactLevel string
+
+const (
+	// RedactNone logs the keys and the errors of the failed units as they are.
+	RedactNone RedactLevel = "none"
+	// RedactKeys logs the fingerprints of the keys of the failed units instead of the keys.
+	RedactKeys RedactLevel = "keys"
+	// RedactAll logs the fingerprints of the failed units and the codes of their errors only.
+	RedactAll RedactLevel = "all"
 )
 
+// ParseRedactLevel parses the redact level, i.e. none, keys or all.
+func ParseRedactLevel(level string) (RedactLevel, error) {
+	switch l := RedactLevel(strings.ToLower(level)); l {
+	case RedactNone, RedactKeys, RedactAll:
+		return l, nil
+	default:
+		return "", berror.Annotatef(berrors.ErrInvalidArgument,
+			"invalid summary redact level '%s', must be one of none|keys|all", level)
+	}
+}
+
+// FailureLogConfig is how the failed units are rendered in the summary log.
+type FailureLogConfig struct {
+	RedactLevel RedactLevel
+	// MaxUnits is the max number of the failed units logged, the others are only counted.
+	// 0 means no limit.
+	MaxUnits int
+	// DetailFile is the file receiving the full detail of all the failed units, which are
+	// only counted in the summary log then. The detail isn't redacted.
+	DetailFile string
+}
+
+// DefaultFailureLogConfig returns the config logging at most DefaultMaxFailureUnits failed units.
+func DefaultFailureLogConfig() FailureLogConfig {
+	return FailureLogConfig{RedactLevel: RedactNone, MaxUnits: DefaultMaxFailureUnits}
+}
+
 // LogCollector collects infos into summary log.
 type LogCollector interface {
 	SetUnit(unit string)
@@ -38,14 +86,24 @@ type LogCollector interface {
 
 	CollectFailureUnit(name string, reason error)
 
+	CollectFailureRange(startKey, endKey []byte, reason error)
+
 	CollectDuration(name string, t time.Duration)
 
+	CollectPhase(group, phase string, t time.Duration)
+
+	CollectStoreDuration(storeID uint64, t time.Duration)
+
 	CollectInt(name string, t int)
 
 	CollectUInt(name string, t uint64)
 
 	SetSuccessStatus(success bool)
 
+	SetFailureLogConfig(cfg FailureLogConfig)
+
+	SetJSONOutput(path string)
+
 	Summary(name string)
 }
 
@@ -79,12 +137,18 @@ type logCollector struct {
 	failureUnitCount int
 	successCosts     map[string]time.Duration
 	successData      map[string]uint64
-	failureReasons   map[string]error
+	failureReasons   map[string]*failureUnit
+	failureLog       FailureLogConfig
 	durations        map[string]time.Duration
+	phases           *phaseDurations
+	groupPhases      map[string]*phaseDurations
+	groups           []string
+	storeDurations   map[uint64]time.Duration
 	ints             map[string]int
 	uints            map[string]uint64
 	successStatus    bool
 	startTime        time.Time
+	jsonOutput       string
 
 	log logFunc
 }
@@ -96,8 +160,12 @@ func NewLogCollector(log logFunc) LogCollector {
 		failureUnitCount: 0,
 		successCosts:     make(map[string]time.Duration),
 		successData:      make(map[string]uint64),
-		failureReasons:   make(map[string]error),
+		failureReasons:   make(map[string]*failureUnit),
+		failureLog:       DefaultFailureLogConfig(),
 		durations:        make(map[string]time.Duration),
+		phases:           newPhaseDurations(),
+		groupPhases:      make(map[string]*phaseDurations),
+		storeDurations:   make(map[uint64]time.Duration),
 		ints:             make(map[string]int),
 		uints:            make(map[string]uint64),
 		log:              log,
@@ -125,10 +193,24 @@ func (tc *logCollector) CollectSuccessUnit(name string, unitCount int, arg inter
 }
 
 func (tc *logCollector) CollectFailureUnit(name string, reason error) {
+	tc.collectFailure(&failureUnit{name: name, reason: reason})
+}
+
+func (tc *logCollector) CollectFailureRange(startKey, endKey []byte, reason error) {
+	tc.collectFailure(&failureUnit{
+		name:     rangeUnitName(hex.EncodeToString(startKey), hex.EncodeToString(endKey)),
+		startKey: startKey,
+		endKey:   endKey,
+		isRange:  true,
+		reason:   reason,
+	})
+}
+
+func (tc *logCollector) collectFailure(unit *failureUnit) {
 	tc.mu.Lock()
 	defer tc.mu.Unlock()
-	if _, ok := tc.failureReasons[name]; !ok {
-		tc.failureReasons[name] = reason
+	if _, ok := tc.failureReasons[unit.name]; !ok {
+		tc.failureReasons[unit.name] = unit
 		tc.failureUnitCount++
 	}
 }
@@ -139,6 +221,28 @@ func (tc *logCollector) CollectDuration(name string, t time.Duration) {
 	tc.durations[name] += t
 }
 
+func (tc *logCollector) CollectPhase(group, phase string, t time.Duration) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.phases.add(phase, t)
+	if len(group) == 0 {
+		return
+	}
+	phases, ok := tc.groupPhases[group]
+	if !ok {
+		phases = newPhaseDurations()
+		tc.groupPhases[group] = phases
+		tc.groups = append(tc.groups, group)
+	}
+	phases.add(phase, t)
+}
+
+func (tc *logCollector) CollectStoreDuration(storeID uint64, t time.Duration) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.storeDurations[storeID] += t
+}
+
 func (tc *logCollector) CollectInt(name string, t int) {
 	tc.mu.Lock()
 	defer tc.mu.Unlock()
@@ -157,6 +261,161 @@ func (tc *logCollector) SetSuccessStatus(success bool) {
 	tc.successStatus = success
 }
 
+func (tc *logCollector) SetFailureLogConfig(cfg FailureLogConfig) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.failureLog = cfg
+}
+
+func (tc *logCollector) SetJSONOutput(path string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.jsonOutput = path
+}
+
+// phaseDurations is the time spent in each phase, in the order the phases are first collected.
+type phaseDurations struct {
+	order     []string
+	durations map[string]time.Duration
+}
+
+func newPhaseDurations() *phaseDurations {
+	return &phaseDurations{durations: make(map[string]time.Duration)}
+}
+
+func (p *phaseDurations) add(phase string, t time.Duration) {
+	if _, ok := p.durations[phase]; !ok {
+		p.order = append(p.order, phase)
+	}
+	p.durations[phase] += t
+}
+
+// String returns the phases like "planning 1.2s, push-down 1m3s".
+func (p *phaseDurations) String() string {
+	var b strings.Builder
+	for i, phase := range p.order {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(phase)
+		b.WriteByte(' ')
+		b.WriteString(p.durations[phase].Round(time.Millisecond).String())
+	}
+	return b.String()
+}
+
+// failureUnit is a failed unit, the keys of a failed range are kept aside the name so
+// that they can be redacted when logged.
+type failureUnit struct {
+	name     string
+	startKey []byte
+	endKey   []byte
+	isRange  bool
+	reason   error
+}
+
+func rangeUnitName(startKey, endKey string) string {
+	return "range start:" + startKey + " end:" + endKey
+}
+
+// fingerprint identifies the sensitive data in the log without revealing it, the same
+// data has the same fingerprint, so the logs can be correlated.
+func fingerprint(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "#" + hex.EncodeToString(sum[:4])
+}
+
+// render returns the name and the error of the unit logged with the redact level.
+func (u *failureUnit) render(level RedactLevel) (string, zap.Field) {
+	name := u.name
+	switch {
+	case level == RedactNone:
+	case u.isRange:
+		name = rangeUnitName(fingerprint(u.startKey), fingerprint(u.endKey))
+	case level == RedactAll:
+		name = fingerprint([]byte(u.name))
+	}
+	if level != RedactAll {
+		return name, zap.Error(u.reason)
+	}
+	return name, zap.String("error-code", errorCode(u.reason))
+}
+
+// errorCode returns the RFC code of the error, or "unknown" if it isn't a normalized error.
+func errorCode(err error) string {
+	if normalized, ok := berror.Cause(err).(*berror.Error); ok {
+		return string(normalized.RFCCode())
+	}
+	return "unknown"
+}
+
+// writeFailureDetail writes the failed units into the detail file, one json per line.
+func writeFailureDetail(path string, units []*failureUnit, level RedactLevel) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return berror.Trace(err)
+	}
+	encoder := json.NewEncoder(f)
+	for _, unit := range units {
+		logged, _ := unit.render(level)
+		detail := struct {
+			Unit  string `json:"unit"`
+			Name  string `json:"name"`
+			Error string `json:"error"`
+		}{Unit: logged, Name: unit.name, Error: unit.reason.Error()}
+		if err = encoder.Encode(&detail); err != nil {
+			_ = f.Close()
+			return berror.Trace(err)
+		}
+	}
+	return berror.Trace(f.Close())
+}
+
+// failedUnits returns the failed units sorted by name, except the canceled ones which are only counted.
+func (tc *logCollector) failedUnits() (units []*failureUnit, canceled int) {
+	units = make([]*failureUnit, 0, len(tc.failureReasons))
+	for _, unit := range tc.failureReasons {
+		if berror.Cause(unit.reason) != context.Canceled {
+			units = append(units, unit)
+		} else {
+			canceled++
+		}
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].name < units[j].name })
+	return units, canceled
+}
+
+// failureFields renders the failed units, at most MaxUnits of them are logged unless
+// they go to the detail file.
+func (tc *logCollector) failureFields() []zap.Field {
+	units, canceledUnits := tc.failedUnits()
+	// only print total number of cancel unit
+	log.Info("units canceled", zap.Int("cancel-unit", canceledUnits))
+
+	cfg := tc.failureLog
+	if len(cfg.DetailFile) > 0 && len(units) > 0 {
+		err := writeFailureDetail(cfg.DetailFile, units, cfg.RedactLevel)
+		if err == nil {
+			return []zap.Field{zap.String("failure-detail-file", cfg.DetailFile)}
+		}
+		log.Warn("failed to write the failure detail, log them instead",
+			zap.String("file", cfg.DetailFile), zap.Error(err))
+	}
+	fields := make([]zap.Field, 0, 2*len(units)+1)
+	for i, unit := range units {
+		if cfg.MaxUnits > 0 && i >= cfg.MaxUnits {
+			fields = append(fields, zap.Int("failed-units-omitted", len(units)-i))
+			break
+		}
+		name, reason := unit.render(cfg.RedactLevel)
+		fields = append(fields, zap.String("unit-name", name), reason)
+	}
+	return fields
+}
+
 func logKeyFor(key string) string {
 	return strings.ReplaceAll(key, " ", "-")
 }
@@ -165,12 +424,22 @@ func (tc *logCollector) Summary(name string) {
 	tc.mu.Lock()
 	defer func() {
 		tc.durations = make(map[string]time.Duration)
+		tc.phases = newPhaseDurations()
+		tc.groupPhases = make(map[string]*phaseDurations)
+		tc.groups = nil
+		tc.storeDurations = make(map[uint64]time.Duration)
 		tc.ints = make(map[string]int)
 		tc.successCosts = make(map[string]time.Duration)
-		tc.failureReasons = make(map[string]error)
+		tc.failureReasons = make(map[string]*failureUnit)
 		tc.mu.Unlock()
 	}()
 
+	if len(tc.jsonOutput) > 0 {
+		if err := writeJSONSummary(tc.jsonOutput, tc.jsonSummary(name)); err != nil {
+			log.Warn("failed to write the summary in json", zap.String("output", tc.jsonOutput), zap.Error(err))
+		}
+	}
+
 	logFields := make([]zap.Field, 0, len(tc.durations)+len(tc.ints)+3)
 
 	logFields = append(logFields,
@@ -182,6 +451,17 @@ func (tc *logCollector) Summary(name string) {
 	for key, val := range tc.durations {
 		logFields = append(logFields, zap.Duration(logKeyFor(key), val))
 	}
+	if len(tc.phases.order) > 0 {
+		logFields = append(logFields, zap.String("phases", tc.phases.String()))
+	}
+	// a single group is the same as the whole task.
+	if len(tc.groups) > 1 {
+		groups := make([]string, 0, len(tc.groups))
+		for _, group := range tc.groups {
+			groups = append(groups, group+": "+tc.groupPhases[group].String())
+		}
+		logFields = append(logFields, zap.Strings("range-group-phases", groups))
+	}
 	for key, val := range tc.ints {
 		logFields = append(logFields, zap.Int(logKeyFor(key), val))
 	}
@@ -190,16 +470,7 @@ func (tc *logCollector) Summary(name string) {
 	}
 
 	if len(tc.failureReasons) != 0 || !tc.successStatus {
-		var canceledUnits int
-		for unitName, reason := range tc.failureReasons {
-			if berror.Cause(reason) != context.Canceled {
-				logFields = append(logFields, zap.String("unit-name", unitName), zap.Error(reason))
-			} else {
-				canceledUnits++
-			}
-		}
-		// only print total number of cancel unit
-		log.Info("units canceled", zap.Int("cancel-unit", canceledUnits))
+		logFields = append(logFields, tc.failureFields()...)
 		tc.log(name+" failed summary", logFields...)
 		return
 	}
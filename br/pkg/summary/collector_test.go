@@ -3,11 +3,20 @@
 package summary
 
 import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/pingcap/errors"
 	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func TestSumDurationInt(t *testing.T) {
@@ -38,3 +47,168 @@ func TestSumDurationInt(t *testing.T) {
 	assertContains(zap.Duration("b", 2*time.Second))
 	assertContains(zap.Int("c", 4))
 }
+
+func TestCollectPhase(t *testing.T) {
+	fields := map[string]zap.Field{}
+	logger := func(msg string, fs ...zap.Field) {
+		for _, f := range fs {
+			fields[f.Key] = f
+		}
+	}
+	col := NewLogCollector(logger)
+	col.CollectPhase("", "planning", time.Second)
+	col.CollectPhase("range-0", "push-down", 3*time.Second)
+	col.CollectPhase("range-0", "fine-grained", time.Second)
+	col.CollectPhase("", "planning", 500*time.Millisecond)
+	col.SetSuccessStatus(true)
+	col.Summary("foo")
+	require.Equal(t, "planning 1.5s, push-down 3s, fine-grained 1s", fields["phases"].String)
+	// a single group is not printed.
+	require.NotContains(t, fields, "range-group-phases")
+
+	fields = map[string]zap.Field{}
+	col.CollectPhase("range-1", "push-down", time.Minute)
+	col.CollectPhase("range-0", "push-down", time.Second)
+	col.CollectPhase("range-0", "fine-grained", 1234567*time.Microsecond)
+	col.Summary("foo")
+	require.Equal(t, "push-down 1m1s, fine-grained 1.235s", fields["phases"].String)
+	groups := fields["range-group-phases"].Interface.(zapcore.ArrayMarshaler)
+	enc := zapcore.NewMapObjectEncoder()
+	require.NoError(t, enc.AddArray("groups", groups))
+	require.Equal(t, []interface{}{"range-1: push-down 1m0s", "range-0: push-down 1s, fine-grained 1.235s"}, enc.Fields["groups"])
+}
+
+func TestFailureLog(t *testing.T) {
+	var fields []zap.Field
+	logger := func(msg string, fs ...zap.Field) {
+		fields = fs
+	}
+	unitNames := func() []string {
+		var names []string
+		for _, f := range fields {
+			if f.Key == "unit-name" {
+				names = append(names, f.String)
+			}
+		}
+		return names
+	}
+	findField := func(key string) *zap.Field {
+		for i := range fields {
+			if fields[i].Key == key {
+				return &fields[i]
+			}
+		}
+		return nil
+	}
+	collect := func(col LogCollector) {
+		col.CollectFailureRange([]byte("secret-a"), []byte("secret-b"),
+			errors.Annotate(berrors.ErrInvalidArgument, "key secret-a"))
+		col.CollectFailureRange([]byte("secret-b"), []byte("secret-c"), errors.New("key secret-b"))
+		col.CollectFailureRange([]byte("secret-b"), []byte("secret-c"), errors.New("duplicated"))
+		col.CollectFailureUnit("canceled", context.Canceled)
+		col.Summary("foo")
+	}
+
+	col := NewLogCollector(logger)
+	collect(col)
+	require.Equal(t, []string{
+		"range start:" + hex.EncodeToString([]byte("secret-a")) + " end:" + hex.EncodeToString([]byte("secret-b")),
+		"range start:" + hex.EncodeToString([]byte("secret-b")) + " end:" + hex.EncodeToString([]byte("secret-c")),
+	}, unitNames())
+	require.Equal(t, int64(3), findField("ranges-failed").Integer)
+
+	col = NewLogCollector(logger)
+	col.SetFailureLogConfig(FailureLogConfig{RedactLevel: RedactKeys, MaxUnits: 1})
+	collect(col)
+	names := unitNames()
+	require.Len(t, names, 1)
+	require.Equal(t, "range start:"+fingerprint([]byte("secret-a"))+" end:"+fingerprint([]byte("secret-b")), names[0])
+	require.Equal(t, int64(1), findField("failed-units-omitted").Integer)
+	require.NotNil(t, findField("error"))
+
+	col = NewLogCollector(logger)
+	col.SetFailureLogConfig(FailureLogConfig{RedactLevel: RedactAll})
+	collect(col)
+	require.Len(t, unitNames(), 2)
+	require.Nil(t, findField("error"))
+	for _, f := range fields {
+		require.NotContains(t, f.String, "secret")
+		if f.Key == "error-code" {
+			require.Contains(t, []string{"BR:Common:ErrInvalidArgument", "unknown"}, f.String)
+		}
+	}
+
+	detail := filepath.Join(t.TempDir(), "failures.json")
+	col = NewLogCollector(logger)
+	col.SetFailureLogConfig(FailureLogConfig{RedactLevel: RedactAll, MaxUnits: 1, DetailFile: detail})
+	collect(col)
+	require.Empty(t, unitNames())
+	require.Equal(t, detail, findField("failure-detail-file").String)
+	content, err := os.ReadFile(detail)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], hex.EncodeToString([]byte("secret-a")))
+	require.Contains(t, lines[0], fingerprint([]byte("secret-a")))
+	require.Contains(t, lines[1], "key secret-b")
+
+	_, err = ParseRedactLevel("some")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
+
+func TestJSONSummary(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "summary.json")
+	col := NewLogCollector(func(msg string, fs ...zap.Field) {})
+	col.SetUnit(BackupUnit)
+	col.SetJSONOutput(output)
+	col.CollectSuccessUnit("push", 2, time.Second)
+	col.CollectSuccessUnit(TotalKV, 1, uint64(10))
+	col.CollectSuccessUnit(TotalBytes, 1, uint64(100))
+	col.CollectSuccessUnit(BackupDataSize, 1, uint64(50))
+	col.CollectDuration("backup checksum", time.Second)
+	col.CollectPhase("range-0", "push-down", 2*time.Second)
+	col.CollectStoreDuration(2, time.Second)
+	col.CollectStoreDuration(1, time.Second)
+	col.CollectStoreDuration(2, 500*time.Millisecond)
+	col.CollectInt("backup ranges", 1)
+	col.SetSuccessStatus(true)
+	col.Summary("raw backup")
+
+	readReport := func() *Report {
+		data, err := os.ReadFile(output)
+		require.NoError(t, err)
+		report := &Report{}
+		require.NoError(t, json.Unmarshal(data, report))
+		return report
+	}
+	report := readReport()
+	require.Equal(t, "raw backup", report.Name)
+	require.Equal(t, BackupUnit, report.Unit)
+	require.True(t, report.Success)
+	require.Equal(t, 2, report.TotalRanges)
+	require.Equal(t, 2, report.RangesSucceed)
+	require.Equal(t, uint64(10), report.TotalKVs)
+	require.Equal(t, uint64(100), report.TotalBytes)
+	require.Equal(t, uint64(50), report.DataSize)
+	require.Equal(t, map[string]float64{"backup-checksum": 1}, report.Durations)
+	require.Equal(t, []PhaseReport{{Phase: "push-down", Seconds: 2}}, report.Phases)
+	require.Equal(t, []GroupReport{{Group: "range-0", Phases: report.Phases}}, report.RangeGroupPhases)
+	require.Equal(t, []StoreReport{{StoreID: 1, Seconds: 1}, {StoreID: 2, Seconds: 1.5}}, report.Stores)
+	require.Equal(t, map[string]int{"backup-ranges": 1}, report.Ints)
+	require.Empty(t, report.Failures)
+
+	col.SetFailureLogConfig(FailureLogConfig{RedactLevel: RedactAll, MaxUnits: 1})
+	col.CollectFailureRange([]byte("secret-a"), []byte("secret-b"), errors.Annotate(berrors.ErrInvalidArgument, "secret-a"))
+	col.CollectFailureRange([]byte("secret-b"), []byte("secret-c"), errors.New("secret-b"))
+	col.CollectFailureUnit("canceled", context.Canceled)
+	col.Summary("raw backup")
+	report = readReport()
+	require.False(t, report.Success)
+	require.Empty(t, report.Stores)
+	require.Equal(t, []FailureReport{{
+		Unit:      "range start:" + fingerprint([]byte("secret-a")) + " end:" + fingerprint([]byte("secret-b")),
+		ErrorCode: "BR:Common:ErrInvalidArgument",
+	}}, report.Failures)
+	require.Equal(t, 1, report.FailuresOmitted)
+	require.Equal(t, 1, report.CanceledUnits)
+}
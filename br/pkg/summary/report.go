@@ -0,0 +1,180 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	berror "github.com/pingcap/errors"
+)
+
+// JSONStdout is the json output meaning stdout.
+const JSONStdout = "-"
+
+// Report is the summary written in json by SetJSONOutput, so that it can be parsed by
+// the automation. The durations are in seconds.
+type Report struct {
+	Name             string    `json:"name"`
+	Unit             string    `json:"unit,omitempty"`
+	Success          bool      `json:"success"`
+	StartTime        time.Time `json:"start-time"`
+	TotalTakeSeconds float64   `json:"total-take-seconds"`
+
+	TotalRanges   int    `json:"total-ranges"`
+	RangesSucceed int    `json:"ranges-succeed"`
+	RangesFailed  int    `json:"ranges-failed"`
+	TotalKVs      uint64 `json:"total-kvs"`
+	TotalBytes    uint64 `json:"total-bytes"`
+	// DataSize is the size of the files backed up or restored, after compressed.
+	DataSize uint64 `json:"data-size"`
+	// Data is the other data collected by CollectSuccessUnit.
+	Data map[string]uint64 `json:"data,omitempty"`
+
+	Durations        map[string]float64 `json:"durations,omitempty"`
+	Phases           []PhaseReport      `json:"phases,omitempty"`
+	RangeGroupPhases []GroupReport      `json:"range-group-phases,omitempty"`
+	Stores           []StoreReport      `json:"stores,omitempty"`
+	Ints             map[string]int     `json:"ints,omitempty"`
+	Uints            map[string]uint64  `json:"uints,omitempty"`
+
+	// Failures are the failed units redacted like the summary log, at most MaxUnits of
+	// FailureLogConfig of them are reported, the others are counted in FailuresOmitted.
+	Failures        []FailureReport `json:"failures,omitempty"`
+	FailuresOmitted int             `json:"failures-omitted,omitempty"`
+	CanceledUnits   int             `json:"canceled-units,omitempty"`
+}
+
+// PhaseReport is the time spent in a phase.
+type PhaseReport struct {
+	Phase   string  `json:"phase"`
+	Seconds float64 `json:"seconds"`
+}
+
+// GroupReport is the time spent in the phases of a range group.
+type GroupReport struct {
+	Group  string        `json:"group"`
+	Phases []PhaseReport `json:"phases"`
+}
+
+// StoreReport is the time spent on a store collected by CollectStoreDuration.
+type StoreReport struct {
+	StoreID uint64  `json:"store-id"`
+	Seconds float64 `json:"seconds"`
+}
+
+// FailureReport is a failed unit, the error is omitted if the redact level is RedactAll.
+type FailureReport struct {
+	Unit      string `json:"unit"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error-code"`
+}
+
+func (p *phaseDurations) reports() []PhaseReport {
+	reports := make([]PhaseReport, 0, len(p.order))
+	for _, phase := range p.order {
+		reports = append(reports, PhaseReport{Phase: phase, Seconds: p.durations[phase].Seconds()})
+	}
+	return reports
+}
+
+// jsonSummary builds the report from what's collected, the caller must hold the lock.
+func (tc *logCollector) jsonSummary(name string) *Report {
+	report := &Report{
+		Name:             name,
+		Unit:             tc.unit,
+		Success:          len(tc.failureReasons) == 0 && tc.successStatus,
+		StartTime:        tc.startTime,
+		TotalTakeSeconds: time.Since(tc.startTime).Seconds(),
+		TotalRanges:      tc.failureUnitCount + tc.successUnitCount,
+		RangesSucceed:    tc.successUnitCount,
+		RangesFailed:     tc.failureUnitCount,
+		Phases:           tc.phases.reports(),
+	}
+	for key, val := range tc.successData {
+		switch key {
+		case TotalKV:
+			report.TotalKVs = val
+		case TotalBytes:
+			report.TotalBytes = val
+		case BackupDataSize, RestoreDataSize:
+			report.DataSize = val
+		default:
+			if report.Data == nil {
+				report.Data = make(map[string]uint64)
+			}
+			report.Data[logKeyFor(key)] = val
+		}
+	}
+	if len(tc.durations) > 0 {
+		report.Durations = make(map[string]float64, len(tc.durations))
+		for key, val := range tc.durations {
+			report.Durations[logKeyFor(key)] = val.Seconds()
+		}
+	}
+	for _, group := range tc.groups {
+		report.RangeGroupPhases = append(report.RangeGroupPhases,
+			GroupReport{Group: group, Phases: tc.groupPhases[group].reports()})
+	}
+	for storeID, val := range tc.storeDurations {
+		report.Stores = append(report.Stores, StoreReport{StoreID: storeID, Seconds: val.Seconds()})
+	}
+	sort.Slice(report.Stores, func(i, j int) bool { return report.Stores[i].StoreID < report.Stores[j].StoreID })
+	if len(tc.ints) > 0 {
+		report.Ints = make(map[string]int, len(tc.ints))
+		for key, val := range tc.ints {
+			report.Ints[logKeyFor(key)] = val
+		}
+	}
+	if len(tc.uints) > 0 {
+		report.Uints = make(map[string]uint64, len(tc.uints))
+		for key, val := range tc.uints {
+			report.Uints[logKeyFor(key)] = val
+		}
+	}
+
+	units, canceled := tc.failedUnits()
+	report.CanceledUnits = canceled
+	cfg := tc.failureLog
+	for i, unit := range units {
+		if cfg.MaxUnits > 0 && i >= cfg.MaxUnits {
+			report.FailuresOmitted = len(units) - i
+			break
+		}
+		name, _ := unit.render(cfg.RedactLevel)
+		failure := FailureReport{Unit: name, ErrorCode: errorCode(unit.reason)}
+		if cfg.RedactLevel != RedactAll {
+			failure.Error = unit.reason.Error()
+		}
+		report.Failures = append(report.Failures, failure)
+	}
+	return report
+}
+
+// writeJSONSummary writes the report into the file, or stdout if path is JSONStdout.
+func writeJSONSummary(path string, report *Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return berror.Trace(err)
+	}
+	data = append(data, '\n')
+	if path == JSONStdout {
+		_, err = os.Stdout.Write(data)
+		return berror.Trace(err)
+	}
+	return berror.Trace(os.WriteFile(path, data, 0o600))
+}
@@ -19,11 +19,30 @@ func CollectFailureUnit(name string, reason error) {
 	collector.CollectFailureUnit(name, reason)
 }
 
+// CollectFailureRange collects fail reason of a range, the keys are redacted
+// in the summary log by the redact level of SetFailureLogConfig.
+func CollectFailureRange(startKey, endKey []byte, reason error) {
+	collector.CollectFailureRange(startKey, endKey, reason)
+}
+
 // CollectDuration collects log time field.
 func CollectDuration(name string, t time.Duration) {
 	collector.CollectDuration(name, t)
 }
 
+// CollectPhase collects the time spent in a phase of the task, the phases are summed up and
+// printed in the order they're first collected. If group is not empty, e.g. the range the
+// phase belongs to, the phases of each group are printed as well when there are several groups.
+func CollectPhase(group, phase string, t time.Duration) {
+	collector.CollectPhase(group, phase, t)
+}
+
+// CollectStoreDuration collects the time spent on a store, e.g. pushing down the backup to it
+// or downloading the files to restore by it. It's only output in the json summary.
+func CollectStoreDuration(storeID uint64, t time.Duration) {
+	collector.CollectStoreDuration(storeID, t)
+}
+
 // CollectInt collects log int field.
 func CollectInt(name string, t int) {
 	collector.CollectInt(name, t)
@@ -39,6 +58,17 @@ func SetSuccessStatus(success bool) {
 	collector.SetSuccessStatus(success)
 }
 
+// SetFailureLogConfig sets how the failed units are logged.
+func SetFailureLogConfig(cfg FailureLogConfig) {
+	collector.SetFailureLogConfig(cfg)
+}
+
+// SetJSONOutput sets the file the summary is written to in json besides the summary log,
+// "-" means stdout and empty means no json summary.
+func SetJSONOutput(path string) {
+	collector.SetJSONOutput(path)
+}
+
 // Summary outputs summary log.
 func Summary(name string) {
 	collector.Summary(name)
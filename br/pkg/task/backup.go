@@ -58,7 +58,8 @@ func DefineBackupFlags(flags *pflag.FlagSet) {
 	_ = flags.MarkHidden(flagIgnoreStats)
 
 	flags.Bool(flagUseBackupMetaV2, false,
-		"use backup meta v2 to store meta info")
+		"use backup meta v2 to store meta info, which writes the file list into size bounded shards "+
+			"indexed by backupmeta, so that restore reads them lazily. Recommended for backups with millions of files")
 	// This flag will change the structure of backupmeta.
 	// we must make sure the old three version of br can parse the v2 meta to keep compatibility.
 	// so this flag should set to false for three version by default.
@@ -66,5 +67,4 @@ func DefineBackupFlags(flags *pflag.FlagSet) {
 	// if we put this feature in v4.0.14, then v4.0.14 br can parse v2 meta
 	// but will generate v1 meta due to this flag is false. the behaviour is as same as v4.0.15, v4.0.16.
 	// finally v4.0.17 will set this flag to true, and generate v2 meta.
-	_ = flags.MarkHidden(flagUseBackupMetaV2)
 }
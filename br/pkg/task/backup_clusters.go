@@ -0,0 +1,244 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/utils"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// flagClusters is the file of the source clusters backed up in one run.
+	flagClusters = "clusters"
+	// flagClusterConcurrency is the number of the clusters backed up concurrently.
+	flagClusterConcurrency = "cluster-concurrency"
+
+	defaultClusterConcurrency = 4
+)
+
+var clusterNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// ClusterSpec is a source cluster of the backup of many clusters, i.e. a [[clusters]] table
+// of the file of --clusters. The cluster is backed up into the directory of its name under
+// the storage.
+type ClusterSpec struct {
+	Name string   `json:"name" toml:"name"`
+	PD   []string `json:"pd" toml:"pd"`
+	// Start and End are the range to backup in --format, which default to --start and --end.
+	Start string `json:"start" toml:"start"`
+	End   string `json:"end" toml:"end"`
+
+	StartKey []byte `json:"-" toml:"-"`
+	EndKey   []byte `json:"-" toml:"-"`
+}
+
+// loadClusters loads the clusters of the file, whose keys are in the format.
+func loadClusters(path, format string) ([]ClusterSpec, error) {
+	var file struct {
+		Clusters []ClusterSpec `toml:"clusters"`
+	}
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to load clusters file %s: %v", path, err)
+	}
+	if len(file.Clusters) == 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "no [[clusters]] in %s", path)
+	}
+	names := make(map[string]struct{}, len(file.Clusters))
+	for i := range file.Clusters {
+		c := &file.Clusters[i]
+		if !clusterNamePattern.MatchString(c.Name) || c.Name == "." || c.Name == ".." {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"cluster #%d of %s: bad name %q, it's the directory of the backup of the cluster", i+1, path, c.Name)
+		}
+		if _, ok := names[c.Name]; ok {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "duplicated cluster %s in %s", c.Name, path)
+		}
+		names[c.Name] = struct{}{}
+		if len(c.PD) == 0 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "cluster %s of %s has no pd", c.Name, path)
+		}
+		var err error
+		if c.StartKey, err = utils.ParseKey(format, c.Start); err != nil {
+			return nil, errors.Annotatef(err, "the start of cluster %s", c.Name)
+		}
+		if c.EndKey, err = utils.ParseKey(format, c.End); err != nil {
+			return nil, errors.Annotatef(err, "the end of cluster %s", c.Name)
+		}
+		if len(c.StartKey) > 0 && len(c.EndKey) > 0 && bytes.Compare(c.StartKey, c.EndKey) >= 0 {
+			return nil, errors.Annotatef(berrors.ErrBackupInvalidRange, "the end of cluster %s must be greater than the start", c.Name)
+		}
+	}
+	return file.Clusters, nil
+}
+
+// parseClusters parses --clusters and --cluster-concurrency, the flags of the single cluster
+// backup can't be used with them.
+func (cfg *RawKvConfig) parseClusters(flags *pflag.FlagSet) error {
+	path, err := flags.GetString(flagClusters)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ClusterConcurrency, err = flags.GetUint(flagClusterConcurrency); err != nil {
+		return errors.Trace(err)
+	}
+	if len(path) == 0 {
+		return nil
+	}
+	if cfg.ClusterConcurrency == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must be positive", flagClusterConcurrency)
+	}
+	for _, name := range []string{
+		flagPD, flagBackupPoints, flagStorageFailover, flagStorageMirror, flagParentStorage, flagLastBackupTS,
+		flagDryRun, flagEstimateCompression,
+	} {
+		if flags.Changed(name) {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "--%s can't be used with --%s", name, flagClusters)
+		}
+	}
+	if isStorageTemplate(cfg.Storage) {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s can't be templated with --%s", flagStorage, flagClusters)
+	}
+	format, err := flags.GetString(flagKeyFormat)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Clusters, err = loadClusters(path, format)
+	return errors.Trace(err)
+}
+
+// clusterConfig returns the config of backing up the cluster into the directory of its name.
+func (cfg *RawKvConfig) clusterConfig(c *ClusterSpec) (*RawKvConfig, error) {
+	clusterCfg := *cfg
+	clusterCfg.Clusters = nil
+	clusterCfg.PD = c.PD
+	if len(c.Start) > 0 {
+		clusterCfg.StartKey = c.StartKey
+	}
+	if len(c.End) > 0 {
+		clusterCfg.EndKey = c.EndKey
+	}
+	if len(clusterCfg.StartKey) > 0 && len(clusterCfg.EndKey) > 0 && bytes.Compare(clusterCfg.StartKey, clusterCfg.EndKey) >= 0 {
+		return nil, errors.Annotatef(berrors.ErrBackupInvalidRange, "the end of cluster %s must be greater than the start", c.Name)
+	}
+	var err error
+	if clusterCfg.Storage, err = storage.ResolveURL(cfg.Storage, c.Name); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(cfg.Name) > 0 {
+		clusterCfg.Name = fmt.Sprintf("%s-%s", cfg.Name, c.Name)
+	}
+	return &clusterCfg, nil
+}
+
+// shareTotalThroughput splits the total throughput among the stores of all the clusters, so
+// that the clusters share the bandwidth of the storage. The rate limit and concurrency per
+// store are the same for all the clusters, which are kept in the global dynamic settings.
+func shareTotalThroughput(ctx context.Context, g glue.Glue, cfg *RawKvConfig) error {
+	if cfg.TotalThroughput == 0 {
+		return nil
+	}
+	stores := 0
+	for _, c := range cfg.Clusters {
+		mgr, err := NewMgr(ctx, g, c.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
+		if err != nil {
+			return errors.Annotatef(err, "failed to connect to cluster %s", c.Name)
+		}
+		count, err := countUpStores(ctx, mgr.GetPDClient())
+		mgr.Close()
+		if err != nil {
+			return errors.Annotatef(err, "failed to count the stores of cluster %s", c.Name)
+		}
+		stores += count
+	}
+	settings := throughputSettings(cfg.TotalThroughput, stores, cfg.Concurrency)
+	log.Info("share the total throughput among the clusters",
+		zap.Uint64("total-throughput", cfg.TotalThroughput),
+		zap.Int("clusters", len(cfg.Clusters)),
+		zap.Int("stores", stores),
+		zap.Uint64("rate-limit", settings.RateLimit),
+		zap.Uint32("concurrency", settings.Concurrency))
+	cfg.RateLimit, cfg.Concurrency = settings.RateLimit, settings.Concurrency
+	// the clusters don't derive their own settings.
+	cfg.TotalThroughput = 0
+	utils.GlobalDynamicSettings().Store(settings)
+	return nil
+}
+
+// runBackupClusters backs up the clusters concurrently, each into the directory of its name
+// under the storage. The rate limit and concurrency are shared, and reloadable by the config
+// file as well. A failed cluster doesn't stop the others, the failed ones are reported at the end.
+func runBackupClusters(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConfig) error {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+	shared := *cfg
+	if err := shareTotalThroughput(ctx, g, &shared); err != nil {
+		return errors.Trace(err)
+	}
+	clusterCfgs := make([]*RawKvConfig, 0, len(cfg.Clusters))
+	for i := range cfg.Clusters {
+		clusterCfg, err := shared.clusterConfig(&cfg.Clusters[i])
+		if err != nil {
+			return errors.Trace(err)
+		}
+		clusterCfgs = append(clusterCfgs, clusterCfg)
+	}
+
+	var (
+		mu     sync.Mutex
+		failed []string
+		first  error
+	)
+	pool := utils.NewWorkerPool(cfg.ClusterConcurrency, "clusters")
+	eg := new(errgroup.Group)
+	for i := range cfg.Clusters {
+		name, clusterCfg := cfg.Clusters[i].Name, clusterCfgs[i]
+		pool.ApplyOnErrorGroup(eg, func() error {
+			log.Info("backup the cluster", zap.String("cluster", name), zap.Strings("pd", clusterCfg.PD))
+			err := RunBackupRaw(ctx, g, fmt.Sprintf("%s of %s", cmdName, name), clusterCfg)
+			if err != nil {
+				log.Error("failed to backup the cluster", zap.String("cluster", name), zap.Error(err))
+				mu.Lock()
+				failed = append(failed, name)
+				if first == nil {
+					first = err
+				}
+				mu.Unlock()
+			}
+			// the other clusters go on.
+			return nil
+		})
+	}
+	_ = eg.Wait()
+	if len(failed) > 0 {
+		return errors.Annotatef(first, "failed to backup %d of %d clusters: %s",
+			len(failed), len(cfg.Clusters), strings.Join(failed, ", "))
+	}
+	return nil
+}
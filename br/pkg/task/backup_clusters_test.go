@@ -0,0 +1,118 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+func writeClustersFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "clusters.toml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoadClusters(t *testing.T) {
+	path := writeClustersFile(t, `
+[[clusters]]
+name = "app-1"
+pd = ["pd-1:2379"]
+
+[[clusters]]
+name = "app-2"
+pd = ["pd-2:2379", "pd-3:2379"]
+start = "6162"
+end = "6163"
+`)
+	clusters, err := loadClusters(path, "hex")
+	require.NoError(t, err)
+	require.Len(t, clusters, 2)
+	require.Equal(t, "app-1", clusters[0].Name)
+	require.Empty(t, clusters[0].StartKey)
+	require.Equal(t, []string{"pd-2:2379", "pd-3:2379"}, clusters[1].PD)
+	require.Equal(t, []byte("ab"), clusters[1].StartKey)
+	require.Equal(t, []byte("ac"), clusters[1].EndKey)
+
+	for _, content := range []string{
+		``,
+		"[[clusters]]\nname = \"../app\"\npd = [\"pd:2379\"]",
+		"[[clusters]]\nname = \"app\"",
+		"[[clusters]]\nname = \"app\"\npd = [\"pd:2379\"]\n[[clusters]]\nname = \"app\"\npd = [\"pd:2379\"]",
+		"[[clusters]]\nname = \"app\"\npd = [\"pd:2379\"]\nstart = \"62\"\nend = \"61\"",
+	} {
+		_, err = loadClusters(writeClustersFile(t, content), "hex")
+		require.Error(t, err, content)
+	}
+}
+
+func TestClusterConfig(t *testing.T) {
+	cfg := &RawKvConfig{EndKey: []byte("z")}
+	cfg.Storage = "s3://bucket/fleet?region=us-east-1"
+	cfg.PD = []string{"127.0.0.1:2379"}
+	cfg.Name = "daily"
+
+	clusterCfg, err := cfg.clusterConfig(&ClusterSpec{Name: "app-1", PD: []string{"pd-1:2379"}})
+	require.NoError(t, err)
+	require.Equal(t, "s3://bucket/fleet/app-1?region=us-east-1", clusterCfg.Storage)
+	require.Equal(t, []string{"pd-1:2379"}, clusterCfg.PD)
+	require.Equal(t, "daily-app-1", clusterCfg.Name)
+	require.Equal(t, []byte("z"), clusterCfg.EndKey)
+	// the shared config is kept.
+	require.Equal(t, "s3://bucket/fleet?region=us-east-1", cfg.Storage)
+
+	clusterCfg, err = cfg.clusterConfig(&ClusterSpec{Name: "app-2", PD: []string{"pd-2:2379"}, Start: "61", StartKey: []byte("a")})
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), clusterCfg.StartKey)
+	_, err = cfg.clusterConfig(&ClusterSpec{Name: "app-3", PD: []string{"pd-3:2379"}, Start: "7a7a", StartKey: []byte("zz")})
+	require.ErrorIs(t, err, berrors.ErrBackupInvalidRange)
+}
+
+func TestParseClusters(t *testing.T) {
+	path := writeClustersFile(t, "[[clusters]]\nname = \"app\"\npd = [\"pd:2379\"]")
+	newFlags := func() *cobra.Command {
+		command := &cobra.Command{}
+		DefineCommonFlags(command.Flags())
+		DefineRawBackupFlags(command)
+		return command
+	}
+	command := newFlags()
+	cfg := &RawKvConfig{}
+	require.NoError(t, cfg.parseClusters(command.Flags()))
+	require.Nil(t, cfg.Clusters)
+
+	require.NoError(t, command.Flags().Set(flagClusters, path))
+	require.NoError(t, cfg.parseClusters(command.Flags()))
+	require.Len(t, cfg.Clusters, 1)
+	require.Equal(t, uint(defaultClusterConcurrency), cfg.ClusterConcurrency)
+
+	for _, name := range []string{flagPD, flagBackupPoints, flagDryRun} {
+		command = newFlags()
+		require.NoError(t, command.Flags().Set(flagClusters, path))
+		require.NoError(t, command.Flags().Set(name, "1"))
+		require.Error(t, (&RawKvConfig{}).parseClusters(command.Flags()), name)
+	}
+
+	command = newFlags()
+	require.NoError(t, command.Flags().Set(flagClusters, path))
+	cfg = &RawKvConfig{}
+	cfg.Storage = "local:///backup/{date}"
+	require.Error(t, cfg.parseClusters(command.Flags()))
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/pdtypes"
+	"github.com/tikv/migration/br/pkg/backup"
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/summary"
+)
+
+// StoreBackupEstimate is the part of the backup done by a store, i.e. the regions it leads.
+type StoreBackupEstimate struct {
+	StoreID  uint64
+	Regions  int
+	Size     uint64
+	Duration time.Duration
+}
+
+// BackupEstimate is the estimated backup of the ranges by the approximate sizes of their
+// regions reported to PD. The size is that of the data on the disks of TiKV, which is
+// compressed as well, so it's close to the size of the backup.
+type BackupEstimate struct {
+	Ranges  int
+	Regions int
+	// Files is the number of the non-empty regions, each of which is backed up into a file.
+	Files int
+	Size  uint64
+	Keys  int64
+	// Duration is the time taken by the slowest store at the rate limit of the backup, or the
+	// expected throughput of its concurrency if the rate limit isn't set.
+	Duration time.Duration
+	Stores   []StoreBackupEstimate
+}
+
+// RunBackupDryRunRaw estimates the size, files and duration of the raw backup by the regions
+// of its ranges, instead of running it. Nothing is written to the storage.
+func RunBackupDryRunRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConfig) (*BackupEstimate, error) {
+	cfg.adjust()
+
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer mgr.Close()
+	if err = applyTotalThroughput(ctx, mgr, cfg); err != nil {
+		return nil, errors.Trace(err)
+	}
+	client, err := backup.NewBackupClient(ctx, mgr, mgr.GetTLSConfig())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	curAPIVersion := client.GetCurAPIVersion()
+	if _, err = cfg.resolveKeyspace(ctx, mgr); err != nil {
+		return nil, errors.Trace(err)
+	}
+	cfg.adjustBackupRange(curAPIVersion, cfg.keyspaceID())
+	ranges, err := cfg.backupRanges(curAPIVersion)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	ctx = logutil.ContextWithPhase(ctx, "dry-run")
+	stats := make([]*pdtypes.RegionStats, 0, len(ranges))
+	for _, rg := range ranges {
+		s, err := mgr.GetRegionStats(ctx, rg.StartKey, rg.EndKey)
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to get the regions of the backup from PD")
+		}
+		stats = append(stats, s)
+	}
+	estimate := estimateBackup(stats, cfg.RateLimit, cfg.Concurrency)
+	summary.CollectInt("backup ranges", estimate.Ranges)
+	summary.CollectInt("regions", estimate.Regions)
+
+	summary.SetSuccessStatus(true)
+	return estimate, nil
+}
+
+// estimateBackup sums up the region stats of the ranges. Each store backs up the regions it
+// leads at rateLimit bytes/s, or throughputPerThread per thread if rateLimit is 0.
+func estimateBackup(stats []*pdtypes.RegionStats, rateLimit uint64, concurrency uint32) *BackupEstimate {
+	throughput := rateLimit
+	if throughput == 0 {
+		if concurrency == 0 {
+			concurrency = 1
+		}
+		throughput = uint64(concurrency) * throughputPerThread
+	}
+	estimate := &BackupEstimate{Ranges: len(stats)}
+	stores := make(map[uint64]*StoreBackupEstimate)
+	for _, s := range stats {
+		estimate.Regions += s.Count
+		estimate.Files += s.Count - s.EmptyCount
+		// the sizes are reported in MiB.
+		estimate.Size += uint64(s.StorageSize) * units.MiB
+		estimate.Keys += s.StorageKeys
+		for storeID, count := range s.StoreLeaderCount {
+			store, ok := stores[storeID]
+			if !ok {
+				store = &StoreBackupEstimate{StoreID: storeID}
+				stores[storeID] = store
+			}
+			store.Regions += count
+			store.Size += uint64(s.StoreLeaderSize[storeID]) * units.MiB
+		}
+	}
+	for _, store := range stores {
+		store.Duration = time.Duration(float64(store.Size) / float64(throughput) * float64(time.Second))
+		if store.Duration > estimate.Duration {
+			estimate.Duration = store.Duration
+		}
+		estimate.Stores = append(estimate.Stores, *store)
+	}
+	sort.Slice(estimate.Stores, func(i, j int) bool { return estimate.Stores[i].StoreID < estimate.Stores[j].StoreID })
+	return estimate
+}
@@ -0,0 +1,57 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/tidb/store/pdtypes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateBackup(t *testing.T) {
+	stats := []*pdtypes.RegionStats{
+		{
+			Count: 3, EmptyCount: 1, StorageSize: 192, StorageKeys: 1000,
+			StoreLeaderCount: map[uint64]int{1: 2, 2: 1},
+			StoreLeaderSize:  map[uint64]int64{1: 128, 2: 64},
+		},
+		{
+			Count: 2, StorageSize: 256, StorageKeys: 500,
+			StoreLeaderCount: map[uint64]int{2: 2},
+			StoreLeaderSize:  map[uint64]int64{2: 256},
+		},
+	}
+	estimate := estimateBackup(stats, 64*units.MiB, 4)
+	require.Equal(t, 2, estimate.Ranges)
+	require.Equal(t, 5, estimate.Regions)
+	require.Equal(t, 4, estimate.Files)
+	require.Equal(t, uint64(448*units.MiB), estimate.Size)
+	require.Equal(t, int64(1500), estimate.Keys)
+	require.Equal(t, []StoreBackupEstimate{
+		{StoreID: 1, Regions: 2, Size: 128 * units.MiB, Duration: 2 * time.Second},
+		{StoreID: 2, Regions: 3, Size: 320 * units.MiB, Duration: 5 * time.Second},
+	}, estimate.Stores)
+	require.Equal(t, 5*time.Second, estimate.Duration)
+
+	// without the rate limit, each thread of the store reaches throughputPerThread.
+	estimate = estimateBackup(stats, 0, 5)
+	require.Equal(t, time.Second, estimate.Duration)
+
+	estimate = estimateBackup(nil, 0, 0)
+	require.Equal(t, &BackupEstimate{}, estimate)
+}
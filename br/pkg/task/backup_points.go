@@ -0,0 +1,206 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	"github.com/tikv/migration/br/pkg/backup"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// flagBackupPoints are the points in time backed up in one run.
+	flagBackupPoints = "backup-points"
+	// flagRestorePoint is the point restored from a backup taken by --backup-points.
+	flagRestorePoint = "restore-point"
+
+	// backupPointsDir is the directory under the storage the later points are backed up into.
+	backupPointsDir = "points"
+)
+
+// parseBackupPoints parses the TSOs or datetimes of --backup-points, and sorts them.
+func parseBackupPoints(flags *pflag.FlagSet) ([]uint64, error) {
+	values, err := flags.GetStringSlice(flagBackupPoints)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	points := make([]uint64, 0, len(values))
+	for _, value := range values {
+		ts, err := parseTSString(value)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		points = append(points, ts)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+	for i := 1; i < len(points); i++ {
+		if points[i] == points[i-1] {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "duplicated backup point %d", points[i])
+		}
+	}
+	for _, name := range []string{flagResume, flagUnsafeTS, flagStorageFailover, flagStorageMirror} {
+		if flags.Changed(name) {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "--%s can't be used with --%s", name, flagBackupPoints)
+		}
+	}
+	return points, nil
+}
+
+// runBackupPoints backs up the cluster at each of BackupPoints. The earliest point is backed up
+// into the storage as usual, and each later one is an incremental backup of the changes since
+// the previous point, which is linked to it, so only the changes are scanned again. The points
+// are listed in metautil.RestorePointsFile of the storage once they are backed up.
+func runBackupPoints(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConfig) (err error) {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+	points := cfg.BackupPoints
+
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer mgr.Close()
+	client, err := backup.NewBackupClient(ctx, mgr, mgr.GetTLSConfig())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if apiVersion := client.GetCurAPIVersion(); apiVersion != kvrpcpb.APIVersion_V2 {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s requires API V2, current api version: %s", flagBackupPoints, apiVersion)
+	}
+	safeTS, err := mgr.GetMinResolvedTS(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if last := points[len(points)-1]; last > safeTS {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"the backup point %d is after the min resolved ts %d, the data at it may be incomplete", last, safeTS)
+	}
+	_, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// hold the safe point at the earliest point, so that the later points aren't collected by GC
+	// while the earlier ones are backed up.
+	client.SetGCTTL(cfg.GCTTL)
+	if err = client.UpdateBRGCSafePointWithTS(ctx, points[0]); err != nil {
+		return errors.Trace(err)
+	}
+	keeperCtx, cancelKeeper := context.WithCancel(ctx)
+	defer cancelKeeper()
+	keeper := client.StartGCSafePointKeeper(keeperCtx, cancel)
+	defer func() {
+		if keeperErr := keeper.Err(); keeperErr != nil {
+			err = errors.Annotatef(keeperErr, "the backup point %d is invalidated by GC, please backup again", points[0])
+			return
+		}
+		cancelKeeper()
+		if releaseErr := keeper.Release(c); releaseErr != nil {
+			log.Warn("failed to release the service safe point, GC is held back until its TTL expires",
+				zap.Error(releaseErr))
+		}
+	}()
+
+	restorePoints := make([]metautil.RestorePoint, 0, len(points))
+	// parentStorage is the storage URL of the previous point.
+	var parentStorage string
+	for i, ts := range points {
+		pointCfg := *cfg
+		pointCfg.BackupPoints = nil
+		pointCfg.snapshotTS = ts
+		ref := "."
+		if i > 0 {
+			ref = path.Join(backupPointsDir, strconv.FormatUint(ts, 10))
+			if pointCfg.Storage, err = storage.ResolveURL(cfg.Storage, ref); err != nil {
+				return errors.Trace(err)
+			}
+			pointCfg.ParentStorage = parentStorage
+			pointCfg.LastBackupTS = points[i-1]
+			if len(cfg.Name) > 0 {
+				pointCfg.Name = fmt.Sprintf("%s-%d", cfg.Name, ts)
+			}
+		}
+		log.Info("backup the point", zap.Int("point", i+1), zap.Int("points", len(points)),
+			zap.Uint64("backup-ts", ts), zap.String("storage", ref))
+		if err = RunBackupRaw(ctx, g, fmt.Sprintf("%s at %d", cmdName, ts), &pointCfg); err != nil {
+			return errors.Annotatef(err, "failed to backup the point %d", ts)
+		}
+		parentStorage = pointCfg.Storage
+		restorePoints = append(restorePoints, metautil.RestorePoint{BackupTS: ts, Storage: ref})
+		// record the points backed up so far, which are restorable even if a later point fails.
+		if err = metautil.WriteRestorePoints(ctx, s, restorePoints); err != nil {
+			return errors.Annotate(err, "failed to record the restore points of the backup")
+		}
+	}
+	return nil
+}
+
+// resolveRestorePoint points Storage to the backup of RestorePoint, which is looked up in the
+// restore points of Storage. It does nothing if RestorePoint is 0.
+func (cfg *RestoreRawConfig) resolveRestorePoint(ctx context.Context) error {
+	if cfg.RestorePoint == 0 {
+		return nil
+	}
+	_, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	points, err := metautil.ReadRestorePoints(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(points) == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s requires a backup taken by --%s, %s has no restore points", flagRestorePoint, flagBackupPoints, cfg.Storage)
+	}
+	backupTSs := make([]uint64, 0, len(points))
+	for i, p := range points {
+		if p.BackupTS != cfg.RestorePoint {
+			backupTSs = append(backupTSs, p.BackupTS)
+			continue
+		}
+		if i > 0 && !cfg.RestoreChain {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"the restore point %d is an incremental backup of the previous points, it requires --%s",
+				p.BackupTS, flagRestoreChain)
+		}
+		pointURL, err := storage.ResolveURL(cfg.Storage, p.Storage)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("restore the point", zap.Uint64("backup-ts", p.BackupTS), zap.String("storage", p.Storage))
+		cfg.Storage = pointURL
+		return nil
+	}
+	return errors.Annotatef(berrors.ErrInvalidArgument,
+		"no restore point %d in %s, the restore points are %v", cfg.RestorePoint, cfg.Storage, backupTSs)
+}
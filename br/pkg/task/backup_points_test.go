@@ -0,0 +1,95 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestParseBackupPoints(t *testing.T) {
+	newFlags := func() *cobra.Command {
+		command := &cobra.Command{}
+		DefineRawBackupFlags(command)
+		return command
+	}
+	command := newFlags()
+	points, err := parseBackupPoints(command.Flags())
+	require.NoError(t, err)
+	require.Nil(t, points)
+
+	require.NoError(t, command.Flags().Set(flagBackupPoints, "300,100,200"))
+	points, err = parseBackupPoints(command.Flags())
+	require.NoError(t, err)
+	require.Equal(t, []uint64{100, 200, 300}, points)
+
+	command = newFlags()
+	require.NoError(t, command.Flags().Set(flagBackupPoints, "100,100"))
+	_, err = parseBackupPoints(command.Flags())
+	require.Error(t, err)
+
+	command = newFlags()
+	require.NoError(t, command.Flags().Set(flagBackupPoints, "yesterday"))
+	_, err = parseBackupPoints(command.Flags())
+	require.Error(t, err)
+
+	command = newFlags()
+	require.NoError(t, command.Flags().Set(flagBackupPoints, "100,200"))
+	require.NoError(t, command.Flags().Set(flagUnsafeTS, "true"))
+	_, err = parseBackupPoints(command.Flags())
+	require.Error(t, err)
+}
+
+func TestResolveRestorePoint(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	root := "local://" + dir
+
+	cfg := &RestoreRawConfig{RestoreChain: true}
+	cfg.Storage = root
+	require.NoError(t, cfg.resolveRestorePoint(ctx))
+	require.Equal(t, root, cfg.Storage)
+
+	// the storage isn't backed up by --backup-points.
+	cfg.RestorePoint = 100
+	require.Error(t, cfg.resolveRestorePoint(ctx))
+
+	require.NoError(t, metautil.WriteRestorePoints(ctx, s, []metautil.RestorePoint{
+		{BackupTS: 100, Storage: "."},
+		{BackupTS: 200, Storage: "points/200"},
+	}))
+	require.NoError(t, cfg.resolveRestorePoint(ctx))
+	require.Equal(t, root, cfg.Storage)
+
+	cfg.RestorePoint = 200
+	require.NoError(t, cfg.resolveRestorePoint(ctx))
+	require.Equal(t, root+"/points/200", cfg.Storage)
+
+	cfg.Storage = root
+	cfg.RestorePoint = 150
+	require.Error(t, cfg.resolveRestorePoint(ctx))
+
+	// the later points are incremental backups.
+	cfg.RestorePoint = 200
+	cfg.RestoreChain = false
+	require.Error(t, cfg.resolveRestorePoint(ctx))
+}
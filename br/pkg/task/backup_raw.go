@@ -4,6 +4,7 @@ package task
 
 import (
 	"context"
+	"time"
 
 	"github.com/coreos/go-semver/semver"
 	"github.com/opentracing/opentracing-go"
@@ -12,13 +13,18 @@ import (
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/log"
 	"github.com/spf13/cobra"
+	"github.com/tikv/client-go/v2/oracle"
 	"github.com/tikv/client-go/v2/rawkv"
 	"github.com/tikv/migration/br/pkg/backup"
+	"github.com/tikv/migration/br/pkg/catalog"
 	"github.com/tikv/migration/br/pkg/checksum"
+	"github.com/tikv/migration/br/pkg/conn"
+	"github.com/tikv/migration/br/pkg/encryption"
+	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/feature"
 	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/logutil"
 	"github.com/tikv/migration/br/pkg/metautil"
-	"github.com/tikv/migration/br/pkg/rtree"
 	"github.com/tikv/migration/br/pkg/storage"
 	"github.com/tikv/migration/br/pkg/summary"
 	"github.com/tikv/migration/br/pkg/utils"
@@ -32,6 +38,80 @@ const (
 	flagDstAPIVersion = "dst-api-version"
 	flagSafeInterval  = "safe-interval"
 	flagGCTTL         = "gcttl"
+
+	flagGCSafePointMargin = "gc-safepoint-margin"
+
+	flagSetupLifecycle = "setup-lifecycle"
+	flagRetentionDays  = "retention-days"
+
+	flagUnsafeTS = "unsafe-ts"
+
+	flagFineGrainedMaxRounds = "fine-grained-max-rounds"
+	flagFineGrainedTimeout   = "fine-grained-timeout"
+	flagFineGrainedWorkers   = "fine-grained-max-workers"
+	flagStuckRangeTimeout    = "stuck-range-timeout"
+	flagTransferLeader       = "transfer-leader-on-retry"
+
+	flagSkipStores         = "skip-stores"
+	flagSkipStoreLabels    = "skip-store-labels"
+	flagDeferSkippedStores = "defer-skipped-stores"
+
+	flagBackoffRegionLeader = "backoff-region-leader"
+	flagBackoffStream       = "backoff-stream"
+	flagBackoffFineGrained  = "backoff-fine-grained"
+
+	flagEstimateCompression = "estimate-compression"
+	flagSampleRegions       = "sample-regions"
+	flagDryRun              = "dry-run"
+
+	flagParentStorage = "parent-storage"
+	flagFileLayout    = "file-layout"
+
+	flagVerifyRanges = "verify-ranges"
+
+	flagIncludePrefix = "include-prefix"
+	flagExcludePrefix = "exclude-prefix"
+
+	flagResume             = "resume"
+	flagCheckpointInterval = "checkpoint-interval"
+
+	flagAdoptNewClusterID = "adopt-new-cluster-id"
+
+	flagMetaCompression = "meta-compression"
+
+	// flagMetaFlushItems and flagMetaFlushMemoryLimit tune when the file list is flushed into
+	// the meta files of the V2 meta, see metautil.FlushPolicy.
+	flagMetaFlushItems       = "meta-flush-items"
+	flagMetaFlushMemoryLimit = "meta-flush-memory-limit"
+
+	flagChecksumAlgorithm = "checksum-algorithm"
+
+	// flagHeatmapBuckets is the number of the buckets of the heatmap of the backup, see `br debug heatmap`.
+	flagHeatmapBuckets = "heatmap-buckets"
+
+	// flagStatusInterval is the interval of publishing the progress, see `br show status`.
+	flagStatusInterval = "status-interval"
+
+	// flagStorageMirror are the storages every file of the backup is written to besides --storage.
+	flagStorageMirror = "storage-mirror"
+	// flagStorageMirrorPolicy decides how the failures of a mirror are handled.
+	flagStorageMirrorPolicy = "storage-mirror-policy"
+
+	defaultCheckpointInterval   = time.Minute
+	defaultFineGrainedMaxRounds = 20
+	defaultStuckRangeTimeout    = 10 * time.Minute
+	defaultSampleRegions        = 16
+	defaultStatusInterval       = 10 * time.Second
+	defaultHeatmapBuckets       = 256
+)
+
+// The phases of the backup collected into the summary, besides the phases of each range
+// collected by the backup client.
+const (
+	phasePlanning     = "planning"
+	phaseSafePoint    = "safepoint"
+	phaseMetaFlush    = "meta-flush"
+	phaseVerification = "verification"
 )
 
 // DefineRawBackupFlags defines common flags for the backup command.
@@ -57,6 +137,147 @@ func DefineRawBackupFlags(command *cobra.Command) {
 	command.Flags().Duration(flagSafeInterval, utils.DefaultBRSafeInterval,
 		"The interval between backup-ts and current tso.")
 	command.Flags().Duration(flagGCTTL, utils.DefaultBRGCSafePointTTL, "The TTL of BR's GC safepoint")
+	command.Flags().Duration(flagGCSafePointMargin, backup.DefaultGCSafePointMargin,
+		"Warn if the backup-ts is ahead of the GC safepoint by less than it, or another service pins a much older "+
+			"safepoint blocking GC, before setting BR's GC safepoint. 0 disables the check.")
+
+	command.Flags().Bool(flagSetupLifecycle, false,
+		"Set up a lifecycle rule of the bucket (S3 and GCS only) which expires the objects under the backup prefix "+
+			"after --retention-days days.")
+	command.Flags().Int64(flagRetentionDays, 0,
+		"The days to retain the backup, used by --setup-lifecycle.")
+
+	command.Flags().Bool(flagUnsafeTS, false,
+		"(experimental) For the disasters in which the TSO of PD is unavailable, backup the snapshot at the max safe-ts "+
+			"reported by the stores instead, which is not the max commit ts of the stores. PD must still serve the "+
+			"metadata of the stores and the regions, which the backup is located by. The backup may miss the writes "+
+			"unresolved on the lagging or unreachable stores, the ts and the caveats are recorded in the side file "+
+			metautil.UnsafeTSFile+" next to backupmeta, which has no field for them. Only API V2 is supported.")
+
+	command.Flags().Int(flagFineGrainedMaxRounds, defaultFineGrainedMaxRounds,
+		"The max rounds of retrying the incomplete regions one by one, after which the remaining ranges "+
+			"are pushed down to all stores again. 0 means no limit.")
+	command.Flags().Duration(flagFineGrainedTimeout, 0,
+		"The time budget of retrying the incomplete regions one by one, after which the remaining ranges "+
+			"are pushed down to all stores again. 0 means no limit.")
+	command.Flags().Int(flagFineGrainedWorkers, backup.DefaultFineGrainedMaxWorkers,
+		"The max number of regions retried one by one concurrently, the workers scale with the number of "+
+			"incomplete regions and stores up to it.")
+	command.Flags().Bool(flagTransferLeader, false,
+		"When a range failed by a store is retried on the other stores, ask PD to transfer the leaders off the "+
+			"store instead of waiting for them to move.")
+	command.Flags().StringSlice(flagSkipStores, nil,
+		"The IDs of the stores the backup isn't pushed down to, e.g. the stores being drained. The regions led by "+
+			"them are backed up once their leaders move, see --transfer-leader-on-retry.")
+	command.Flags().StringSlice(flagSkipStoreLabels, nil,
+		"Skip the stores having any of the labels in the form of \"key=value\" like --skip-stores, e.g. \"zone=z1\" "+
+			"to keep the load off a failure domain.")
+	command.Flags().Bool(flagDeferSkippedStores, false,
+		"Push the backup down to the stores selected by --skip-stores and --skip-store-labels after the other "+
+			"stores finish, instead of skipping them.")
+	command.Flags().Uint64(flagTotalThroughput, 0,
+		"The total throughput of the backup in MB/s across all nodes. The rate limit of each node is the total "+
+			"throughput divided by the number of nodes, and the concurrency is derived from it unless --concurrency "+
+			"is set. They are adjusted when nodes join or leave the cluster. Can't be used with --ratelimit.")
+	command.Flags().Duration(flagStuckRangeTimeout, defaultStuckRangeTimeout,
+		"The max time a backup stream to a store can go without any response, after which the stream is "+
+			"canceled and the range is dispatched again. 0 means no limit.")
+
+	backoff := backup.DefaultBackoffConfig()
+	const backoffUsage = " In the form of \"initial=1s,max=10s,multiplier=2,jitter=0.2,max-elapsed=1m,max-attempts=5\", " +
+		"the fields absent keep the default: "
+	command.Flags().String(flagBackoffRegionLeader, "",
+		"The backoff of finding the leader of a region from PD."+backoffUsage+backoff.RegionLeader.String())
+	command.Flags().String(flagBackoffStream, "",
+		"The backoff of dispatching a range to a store again after the backup stream fails, once exhausted the "+
+			"range is retried by the fine grained backup."+backoffUsage+backoff.Stream.String())
+	command.Flags().String(flagBackoffFineGrained, "",
+		"The backoff between the rounds of the fine grained backup, the wait required by the store, e.g. for "+
+			"a lock to expire, is respected."+backoffUsage+backoff.FineGrained.String())
+
+	command.Flags().Bool(flagEstimateCompression, false,
+		"Instead of the backup, back up a few sampled regions with each compression algorithm and report "+
+			"the achieved ratios and speeds, which helps to choose --compression. Nothing is written to the storage.")
+	command.Flags().Int(flagSampleRegions, defaultSampleRegions,
+		"The number of regions sampled by --estimate-compression.")
+	command.Flags().Bool(flagDryRun, false,
+		"Instead of the backup, estimate its size, number of files and duration by the regions of the ranges "+
+			"reported to PD. Nothing is written to the storage.")
+
+	command.Flags().String(flagParentStorage, "",
+		"(experimental) The storage URL of the previous backup, makes an incremental backup of the changes since it "+
+			"and links them, so that restore walks the chain automatically. --lastbackupts defaults to its backup ts. "+
+			"Only API V2 is supported.")
+	command.Flags().String(flagFileLayout, "",
+		"The layout of the data files in the storage, e.g. '{cluster_id}/{date}/{range_sn}', so that one bucket "+
+			"can hold the backups of many clusters managed by the prefixes. The {date} is the UTC date of the backup ts, "+
+			"or of the start of the backup without it. The backupmeta is at the root of the storage, and the restore "+
+			"is unaware of the layout.")
+	command.Flags().String(flagClusters, "",
+		"(experimental) The TOML file of the source clusters backed up concurrently instead of --pd, each in a "+
+			"[[clusters]] table with its name, pd, and optionally the start and end of the range. Each cluster is "+
+			"backed up into the directory of its name under --storage, and --total-throughput is shared by the "+
+			"stores of all the clusters.")
+	command.Flags().Uint(flagClusterConcurrency, defaultClusterConcurrency,
+		"The number of the clusters of --clusters backed up concurrently.")
+	command.Flags().StringSlice(flagBackupPoints, nil,
+		"(experimental) Backup the snapshots at several points in one run, support TSO or datetime, e.g. "+
+			"'400036290571534337,2018-05-11 01:42:23'. The earliest point is backed up into --storage, and each later "+
+			"one is an incremental backup of the changes since the previous point into points/<ts> under --storage. "+
+			"The points are listed in "+metautil.RestorePointsFile+", and restored by --restore-point. Only API V2 is supported.")
+
+	command.Flags().StringArray(flagIncludePrefix, nil,
+		"Backup only the keys with the prefix in --format within the backup range, can be specified multiple times.")
+	command.Flags().StringArray(flagExcludePrefix, nil,
+		"Skip the keys with the prefix in --format, can be specified multiple times.")
+
+	command.Flags().String(flagKeyspaceName, "",
+		"(experimental) Backup only the keys of the API V2 keyspace with the name, the backup range and prefixes "+
+			"are within the keyspace. The keyspace is recorded in the backup.")
+	command.Flags().Uint32(flagKeyspaceID, defaultKeyspaceID,
+		"(experimental) Backup only the keys of the API V2 keyspace with the ID, the same as --keyspace-name.")
+
+	command.Flags().Bool(flagVerifyRanges, false,
+		"After the backup, verify the checksum of each backed up range against the cluster, instead of "+
+			"the total checksum of --checksum, and fail the backup if any range mismatches.")
+
+	command.Flags().Bool(flagResume, false,
+		"Resume the interrupted backup in the same storage from its checkpoint, the completed ranges are not "+
+			"backed up again. The backup range, --lastbackupts and --dst-api-version must be the same.")
+	command.Flags().Duration(flagCheckpointInterval, defaultCheckpointInterval,
+		"The interval of persisting the completed ranges into the storage, so that an interrupted backup "+
+			"can be resumed by --resume. 0 disables the checkpoint.")
+
+	command.Flags().Bool(flagAdoptNewClusterID, false,
+		"Continue the backup with the new cluster ID if the cluster is recreated during the backup, e.g. PD "+
+			"is recovered by pd-recover with a new cluster ID, instead of failing. Make sure the data is intact.")
+
+	// Older BR can't read the compressed backupmeta, so it's not compressed by default.
+	command.Flags().String(flagMetaCompression, "none",
+		"The compression algorithm of backupmeta and the meta files, which shrinks the meta of large backups. "+
+			"Available options: \"none\", \"gzip\", \"zstd\". The compressed backup can only be restored by BR supporting it.")
+	command.Flags().Int(flagMetaFlushItems, metautil.DefaultFlushItems,
+		"Flush the buffered file list into a meta file once it holds so many files, with --use-backupmeta-v2. "+
+			"0 means the meta files are only bounded by their size.")
+	command.Flags().Uint64(flagMetaFlushMemoryLimit, 0,
+		"Flush the buffered file list into a meta file early once the heap of BR exceeds so many bytes, which "+
+			"keeps the memory bounded on backups of lots of small files, with --use-backupmeta-v2. 0 disables it.")
+	command.Flags().StringSlice(flagStorageMirror, nil,
+		"The storages the backup is mirrored to, e.g. an offsite copy of the backup in --storage. TiKV writes the "+
+			"SST files to --storage, and they are copied to the mirrors once a range is backed up.")
+	command.Flags().String(flagStorageMirrorPolicy, string(storage.MirrorPolicyRequired),
+		"How the failures of a storage mirror are handled, required fails the backup, best-effort drops the mirror "+
+			"and goes on, the dropped mirror doesn't hold a complete backup")
+	command.Flags().String(flagChecksumAlgorithm, "sha256",
+		"The algorithm of the checksums of the meta files and the parent backupmeta computed by BR. Available options: "+
+			"\"sha256\", \"xxhash64\". xxhash64 is faster but isn't allowed in FIPS environments, and the backup can "+
+			"only be restored by BR supporting it. The checksums of the SST files and the ranges are computed by TiKV.")
+	command.Flags().Int(flagHeatmapBuckets, defaultHeatmapBuckets,
+		"Record the distribution of the backed up data over the key space in so many buckets aside backupmeta, "+
+			"which is rendered by `br debug heatmap` to plan the partial restores. 0 disables it.")
+	command.Flags().Duration(flagStatusInterval, defaultStatusInterval,
+		"The interval of writing the progress of the backup into the storage and the etcd of PD, from which "+
+			"`br show status` reads it on any machine, even if the backup crashed. 0 disables it.")
 
 	// safe-interval is difficult for common users to set one suitable value. Hide it.
 	_ = command.Flags().MarkHidden(flagSafeInterval)
@@ -66,22 +287,74 @@ func DefineRawBackupFlags(command *cobra.Command) {
 }
 
 // CalcChecksumFromBackupMeta read the backup meta and return Checksum
-func CalcChecksumAndRangeFromBackupMeta(ctx context.Context, backupMeta *backuppb.BackupMeta, curAPIVersion kvrpcpb.APIVersion) (rawkv.RawChecksum, []*utils.KeyRange) {
+func CalcChecksumAndRangeFromBackupMeta(
+	ctx context.Context,
+	backupMeta *backuppb.BackupMeta,
+	reader *metautil.MetaReader,
+	curAPIVersion kvrpcpb.APIVersion,
+) (rawkv.RawChecksum, []*utils.KeyRange, error) {
 	fileChecksum := rawkv.RawChecksum{}
 	keyRanges := make([]*utils.KeyRange, 0, len(backupMeta.Files))
-	for _, file := range backupMeta.Files {
+	err := reader.ReadDataFiles(ctx, func(file *backuppb.File) error {
 		checksum.UpdateChecksum(&fileChecksum, file.Crc64Xor, file.TotalKvs, file.TotalBytes)
 		keyRange := utils.ConvertBackupConfigKeyRange(file.StartKey, file.EndKey, backupMeta.ApiVersion, curAPIVersion)
 		keyRanges = append(keyRanges, keyRange)
-	}
-	return fileChecksum, keyRanges
+		return nil
+	})
+	return fileChecksum, keyRanges, errors.Trace(err)
+}
+
+// CalcChecksumPerRangeFromBackupMeta returns the checksum of each backed up range, the files of the same range
+// are summed up.
+func CalcChecksumPerRangeFromBackupMeta(
+	ctx context.Context,
+	backupMeta *backuppb.BackupMeta,
+	reader *metautil.MetaReader,
+	curAPIVersion kvrpcpb.APIVersion,
+) ([]rawkv.RawChecksum, []*utils.KeyRange, error) {
+	checksums := make([]rawkv.RawChecksum, 0, len(backupMeta.Files))
+	keyRanges := make([]*utils.KeyRange, 0, len(backupMeta.Files))
+	index := make(map[string]int, len(backupMeta.Files))
+	err := reader.ReadDataFiles(ctx, func(file *backuppb.File) error {
+		key := string(file.StartKey) + "\x00" + string(file.EndKey)
+		i, ok := index[key]
+		if !ok {
+			i = len(checksums)
+			index[key] = i
+			checksums = append(checksums, rawkv.RawChecksum{})
+			keyRanges = append(keyRanges, utils.ConvertBackupConfigKeyRange(file.StartKey, file.EndKey, backupMeta.ApiVersion, curAPIVersion))
+		}
+		checksum.UpdateChecksum(&checksums[i], file.Crc64Xor, file.TotalKvs, file.TotalBytes)
+		return nil
+	})
+	return checksums, keyRanges, errors.Trace(err)
 }
 
 // RunBackupRaw starts a backup task inside the current goroutine.
-func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConfig) error {
+func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConfig) (err error) {
+	if len(cfg.BackupPoints) > 0 {
+		return runBackupPoints(c, g, cmdName, cfg)
+	}
+	if len(cfg.Clusters) > 0 {
+		return runBackupClusters(c, g, cmdName, cfg)
+	}
+	result := newTaskResult(cmdName, metautil.BackupResultFile)
+	defer func() {
+		result.finish(err)
+	}()
 	cfg.adjust()
+	if err := registerRuntimeConfig(&cfg.Config); err != nil {
+		return errors.Trace(err)
+	}
+	if err := cfg.checkBackupName(); err != nil {
+		return errors.Trace(err)
+	}
 
 	defer summary.Summary(cmdName)
+	phaseStart := time.Now()
+	// planning is the time of planning the backup, apart from getting the backup ts, which is
+	// recorded as the safepoint phase.
+	var planning time.Duration
 	ctx, cancel := context.WithCancel(c)
 	defer cancel()
 
@@ -91,20 +364,40 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 		ctx = opentracing.ContextWithSpan(ctx, span1)
 	}
 
-	u, err := storage.ParseBackend(cfg.Storage, &cfg.BackendOptions)
-	if err != nil {
-		return errors.Trace(err)
-	}
 	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	defer mgr.Close()
+	mgr.SetGRPCMaxMsgSize(cfg.GRPCMaxMsgSize)
+	mgr.SetDNSRefreshInterval(cfg.DNSRefreshInterval)
+	if err = setupStoreConnectivity(ctx, mgr, &cfg.Config); err != nil {
+		return errors.Trace(err)
+	}
+	if err = applyTotalThroughput(ctx, mgr, cfg); err != nil {
+		return errors.Trace(err)
+	}
 
 	client, err := backup.NewBackupClient(ctx, mgr, mgr.GetTLSConfig())
 	if err != nil {
 		return errors.Trace(err)
 	}
+	client.SetDynamicSettings(utils.GlobalDynamicSettings())
+	client.SetFineGrainedLimit(cfg.FineGrainedMaxRounds, cfg.FineGrainedTimeout)
+	client.SetFineGrainedMaxWorkers(cfg.FineGrainedMaxWorkers)
+	client.SetStuckRangeTimeout(cfg.StuckRangeTimeout)
+	if cfg.Backoff != nil {
+		client.SetBackoffConfig(*cfg.Backoff)
+	}
+	client.SetTransferLeader(cfg.TransferLeader)
+	if len(cfg.SkipStores) > 0 || len(cfg.SkipStoreLabels) > 0 {
+		labels, err := conn.ParseStoreLabels(cfg.SkipStoreLabels)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		client.SetSkipStores(&conn.StoreSelector{StoreIDs: cfg.SkipStores, Labels: labels}, cfg.DeferSkippedStores)
+	}
+	client.SetAdoptNewClusterID(cfg.AdoptNewClusterID)
 	clusterVersion, err := mgr.GetClusterVersion(ctx)
 	if err != nil {
 		return errors.Trace(err)
@@ -112,38 +405,133 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 	brVersion := g.GetVersion()
 
 	curAPIVersion := client.GetCurAPIVersion()
-	cfg.adjustBackupRange(curAPIVersion)
 	if len(cfg.DstAPIVersion) == 0 { // if no DstAPIVersion is specified, backup to same api-version.
 		cfg.DstAPIVersion = curAPIVersion.String()
 	}
 	dstAPIVersion := kvrpcpb.APIVersion(kvrpcpb.APIVersion_value[cfg.DstAPIVersion])
+	if cfg.hasKeyspace() && (curAPIVersion != kvrpcpb.APIVersion_V2 || dstAPIVersion != kvrpcpb.APIVersion_V2) {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s and --%s require an API V2 cluster and --%s=v2", flagKeyspaceName, flagKeyspaceID, flagDstAPIVersion)
+	}
+	keyspace, err := cfg.resolveKeyspace(ctx, mgr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.adjustBackupRange(curAPIVersion, cfg.keyspaceID())
+	backupRanges, err := cfg.backupRanges(curAPIVersion)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	featureGate := feature.NewFeatureGate(semver.New(clusterVersion))
 	if !CheckBackupAPIVersion(featureGate, curAPIVersion, dstAPIVersion) {
 		return errors.Errorf("Unsupported backup api version in current cluster, cur:%s, dst:%s, cluster version:%s",
 			curAPIVersion.String(), cfg.DstAPIVersion, clusterVersion)
 	}
-	if cfg.Checksum && !featureGate.IsEnabled(feature.Checksum) {
+	if (cfg.Checksum || cfg.VerifyRanges) && !featureGate.IsEnabled(feature.Checksum) {
 		log.Error("TiKV cluster does not support checksum, please disable checksum", zap.String("version", clusterVersion))
 		return errors.Errorf("Current tikv cluster version %s does not support checksum, please disable checksum", clusterVersion)
 	}
-	opts := storage.ExternalStorageOptions{
-		NoCredentials:   cfg.NoCreds,
-		SendCredentials: cfg.SendCreds,
+	templateTS, err := cfg.expandStorage(ctx, client,
+		featureGate.IsEnabled(feature.BackupTs) && curAPIVersion == kvrpcpb.APIVersion_V2)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	u, err := storage.ParseBackend(cfg.Storage, &cfg.BackendOptions)
+	if err != nil {
+		return errors.Trace(err)
 	}
-	if err = client.SetStorage(ctx, u, &opts); err != nil {
+	if len(cfg.StorageFailover) > 0 {
+		s, err := newFailoverStorage(ctx, &cfg.Config)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = client.SetFailoverStorage(ctx, s); err != nil {
+			return errors.Trace(err)
+		}
+	} else if len(cfg.StorageMirror) > 0 {
+		s, err := cfg.newMirrorStorage(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = client.SetMirrorStorage(ctx, s); err != nil {
+			return errors.Trace(err)
+		}
+	} else if err = client.SetStorage(ctx, u, storageOpts(&cfg.Config)); err != nil {
 		return errors.Trace(err)
 	}
+	result.storage = client.GetStorage()
+	if err = cfg.setupDataKey(ctx, client.GetStorage(), cfg.Resume); err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.MasterKey) > 0 {
+		result.output("encryption", metautil.EncryptionFile)
+	}
+	if cfg.SetupLifecycle {
+		if err = storage.SetupLifecycle(ctx, client.GetStorage(), cfg.RetentionDays); err != nil {
+			return errors.Trace(err)
+		}
+		summary.CollectInt("retention days", int(cfg.RetentionDays))
+	}
 	client.SetGCTTL(cfg.GCTTL)
+	client.SetGCSafePointMargin(cfg.GCSafePointMargin)
+	var checkpoint *backup.Checkpoint
+	if cfg.Resume {
+		checkpoint, err = backup.ReadCheckpoint(ctx, client.GetStorage())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if checkpoint == nil {
+			log.Warn("no checkpoint to resume from, backup from scratch")
+		}
+	} else if _, exists, err := storage.FindCompressed(ctx, client.GetStorage(), backup.CheckpointFile); err != nil {
+		return errors.Trace(err)
+	} else if exists {
+		log.Warn("the storage has the checkpoint of an interrupted backup, which is overwritten, "+
+			"specify --"+flagResume+" to resume it instead", zap.String("storage", cfg.Storage))
+	}
+	if cfg.UnsafeTS && (!featureGate.IsEnabled(feature.BackupTs) || curAPIVersion != kvrpcpb.APIVersion_V2) {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s requires API V2, current api version: %s, cluster version: %s", flagUnsafeTS, curAPIVersion, clusterVersion)
+	}
+	if cfg.snapshotTS > 0 && !featureGate.IsEnabled(feature.BackupTs) {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s isn't supported by the cluster version %s", flagBackupPoints, clusterVersion)
+	}
+	var ts resolvedBackupTS
 	if featureGate.IsEnabled(feature.BackupTs) && curAPIVersion == kvrpcpb.APIVersion_V2 {
-		// set safepoint to avoid the logical deletion data to gc.
-		backupTs, err := client.UpdateBRGCSafePoint(ctx, cfg.SafeInterval)
+		planning += time.Since(phaseStart)
+		phaseStart = time.Now()
+		ts, err = resolveBackupTS(ctx, cfg, client, checkpoint, templateTS,
+			func(ctx context.Context) (*metautil.UnsafeTS, error) {
+				return getUnsafeTS(ctx, client, mgr)
+			})
 		if err != nil {
 			return errors.Trace(err)
 		}
-		g.Record("backup-ts", backupTs)
+		g.Record("backup-ts", ts.backupTS)
+		summary.CollectPhase("", phaseSafePoint, time.Since(phaseStart))
+		phaseStart = time.Now()
+	}
+	backupTs, unsafeTS := ts.backupTS, ts.unsafeTS
+	parent, err := cfg.resolveParent(ctx, dstAPIVersion)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.LastBackupTS > 0 {
+		if backupTs == 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"incremental backup requires API V2, current api version: %s, cluster version: %s", curAPIVersion, clusterVersion)
+		}
+		if cfg.LastBackupTS >= backupTs {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"the last backup ts %d must be less than the backup ts %d", cfg.LastBackupTS, backupTs)
+		}
+		if parent == nil {
+			log.Warn("the incremental backup is not linked to its parent, restore cannot walk the chain, "+
+				"specify --"+flagParentStorage+" to link them", zap.Uint64("last-backup-ts", cfg.LastBackupTS))
+		}
+		summary.CollectUint("last backup ts", cfg.LastBackupTS)
 	}
-
-	backupRange := rtree.Range{StartKey: cfg.StartKey, EndKey: cfg.EndKey}
 
 	if cfg.RemoveSchedulers {
 		restore, e := mgr.RemoveSchedulers(ctx)
@@ -162,12 +550,45 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 	}
 
 	// The number of regions need to backup
-	approximateRegions, err := mgr.GetRegionCount(ctx, backupRange.StartKey, backupRange.EndKey)
-	if err != nil {
-		return errors.Trace(err)
+	approximateRegions := 0
+	for _, rg := range backupRanges {
+		regions, err := mgr.GetRegionCount(ctx, rg.StartKey, rg.EndKey)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		approximateRegions += regions
 	}
 
 	summary.CollectInt("backup total regions", approximateRegions)
+	if len(backupRanges) > 1 {
+		summary.CollectInt("backup ranges", len(backupRanges))
+	}
+	// the size of the range overestimates an incremental backup, which only has the changes.
+	if cfg.SpaceReserveRatio > 0 && cfg.LastBackupTS == 0 {
+		if err = checkLocalBackupSpace(ctx, mgr, u, backupRanges, cfg.SpaceReserveRatio); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if cfg.StatusInterval > 0 {
+		sinks := []backup.StatusSink{backup.NewStorageStatusSink(client.GetStorage())}
+		if etcdCli, etcdErr := newPDEtcdClient(&cfg.Config); etcdErr != nil {
+			log.Warn("failed to connect to the etcd of PD, the backup status is only written into the storage",
+				zap.Error(etcdErr))
+		} else {
+			defer etcdCli.Close()
+			sinks = append(sinks, backup.NewEtcdStatusSink(etcdCli))
+		}
+		client.StartStatus(ctx, backup.Status{
+			Storage:      encryption.RedactURL(cfg.Storage),
+			Command:      cmdName,
+			TotalRegions: int64(approximateRegions),
+			TotalRanges:  int64(len(backupRanges)),
+		}, cfg.StatusInterval, sinks...)
+		defer func() {
+			client.StopStatus(err)
+		}()
+	}
 
 	// Backup
 	// Redirect to log if there is no log file to avoid unreadable output.
@@ -182,8 +603,8 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 	}
 	req := backuppb.BackupRequest{
 		ClusterId:        client.GetClusterID(),
-		StartVersion:     0,
-		EndVersion:       0,
+		StartVersion:     cfg.LastBackupTS,
+		EndVersion:       ts.endVersion,
 		RateLimit:        cfg.RateLimit,
 		Concurrency:      cfg.Concurrency,
 		IsRawKv:          true,
@@ -193,26 +614,130 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 		CompressionLevel: cfg.CompressionLevel,
 		CipherInfo:       &cfg.CipherInfo,
 	}
-	metaWriter := metautil.NewMetaWriter(client.GetStorage(), metautil.MetaFileSize, false, &cfg.CipherInfo)
+	if cfg.LastBackupTS > 0 {
+		req.EndVersion = backupTs
+	}
+	if len(cfg.FileLayout) > 0 {
+		layoutDate := time.Now()
+		if backupTs > 0 {
+			layoutDate = oracle.GetTimeFromTS(backupTs)
+		}
+		if err = client.SetFileLayout(cfg.FileLayout, layoutDate); err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("lay out the data files", zap.String("layout", cfg.FileLayout), zap.Time("date", layoutDate))
+	}
+	metaWriter := metautil.NewMetaWriter(client.GetStorage(), metautil.MetaShardSize, cfg.UseBackupMetaV2, &cfg.CipherInfo)
+	metaCompression, err := metautil.ParseMetaCompressionType(cfg.MetaCompression)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	checksumAlgorithm, err := metautil.ParseChecksumAlgorithm(cfg.ChecksumAlgorithm)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	metaWriter.SetCompression(metaCompression)
+	metaWriter.SetChecksumAlgorithm(checksumAlgorithm)
+	metaWriter.SetFlushPolicy(cfg.metaFlushPolicy())
+	if cfg.HeatmapBuckets > 0 {
+		client.EnableHeatmap(cfg.HeatmapBuckets)
+	}
 	metaWriter.StartWriteMetasAsync(ctx, metautil.AppendDataFile)
-	err = client.BackupRange(ctx, backupRange.StartKey, backupRange.EndKey, req, metaWriter, progressCallBack)
+	if cfg.CheckpointInterval > 0 {
+		checkpointCompression, err := cfg.artifactCompression()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		client.SetCheckpointCompression(checkpointCompression)
+		header := backup.Checkpoint{
+			StartKey:      cfg.StartKey,
+			EndKey:        cfg.EndKey,
+			StartVersion:  req.StartVersion,
+			EndVersion:    req.EndVersion,
+			BackupTS:      backupTs,
+			DstAPIVersion: cfg.DstAPIVersion,
+		}
+		if err = client.StartCheckpoint(ctx, header, checkpoint, cfg.CheckpointInterval); err != nil {
+			return errors.Trace(err)
+		}
+		defer func() {
+			if err := client.StopCheckpoint(); err != nil {
+				log.Warn("failed to persist the backup checkpoint, the backup cannot be resumed", zap.Error(err))
+			}
+		}()
+	}
+	backupCtx, cancelBackup := context.WithCancel(ctx)
+	defer cancelBackup()
+	if backupTs > 0 && !unsafeTS.WithoutSafePoint() {
+		// the backup may last longer than the TTL of the safe point, keep it alive until the backup finishes.
+		keeper := client.StartGCSafePointKeeper(backupCtx, cancelBackup)
+		defer func() {
+			if keeperErr := keeper.Err(); keeperErr != nil {
+				err = errors.Annotatef(keeperErr, "the backup ts %d is invalidated by GC, please backup again", backupTs)
+				return
+			}
+			if err != nil {
+				// keep the safe point until the TTL expires, the backup may be resumed from the checkpoint.
+				return
+			}
+			cancelBackup()
+			if releaseErr := keeper.Release(ctx); releaseErr != nil {
+				log.Warn("failed to release the service safe point, GC is held back until its TTL expires",
+					zap.Error(releaseErr))
+			}
+		}()
+	}
+	result.BackupTS = backupTs
+	catalogEntry := catalog.Entry{
+		CreatedAt:  time.Now(),
+		ClusterID:  client.GetClusterID(),
+		APIVersion: dstAPIVersion.String(),
+		BackupTS:   backupTs,
+		Status:     catalog.StatusRunning,
+		// the credentials in the query are removed by recordBackupInCatalog.
+		StorageTemplate: cfg.StorageTemplate,
+	}
+	if err = cfg.recordBackupInCatalog(ctx, catalogEntry); err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		catalogEntry.Status = catalog.StatusFailed
+		// the backup may fail for the canceled context.
+		if recordErr := cfg.recordBackupInCatalog(context.Background(), catalogEntry); recordErr != nil {
+			log.Warn("failed to record the failed backup in catalog", zap.Error(recordErr))
+		}
+	}()
+	if err = runHooks(ctx, cfg.Hooks, HookPreBackup, result); err != nil {
+		return errors.Trace(err)
+	}
+	summary.CollectPhase("", phasePlanning, planning+time.Since(phaseStart))
+	err = client.BackupRanges(logutil.ContextWithPhase(backupCtx, "backup"), backupRanges, req, uint(cfg.Concurrency),
+		metaWriter, progressCallBack)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	phaseStart = time.Now()
 	// Backup has finished
 	updateCh.Close()
-	// backup meta range should in DstAPIVersion format
-	metaRange := utils.ConvertBackupConfigKeyRange(cfg.StartKey, cfg.EndKey, curAPIVersion, dstAPIVersion)
+	// backup meta range should in DstAPIVersion format. With prefixes, it's the span of the
+	// backed up ranges, the keys in the gaps are not in the backup and never restored.
+	metaRange := utils.ConvertBackupConfigKeyRange(backupRanges[0].StartKey, backupRanges[len(backupRanges)-1].EndKey,
+		curAPIVersion, dstAPIVersion)
 	if metaRange == nil {
 		return errors.Errorf("fail to convert key. curAPIVer:%d, dstAPIVer:%d", curAPIVersion, dstAPIVersion)
 	}
 	rawRanges := []*backuppb.RawRange{{StartKey: metaRange.Start, EndKey: metaRange.End, Cf: "default"}}
 	metaWriter.Update(func(m *backuppb.BackupMeta) {
 		m.StartVersion = req.StartVersion
-		m.EndVersion = req.EndVersion
+		// record the backup ts as the end version, which is the start of the next incremental backup.
+		m.EndVersion = backupTs
 		m.IsRawKv = req.IsRawKv
 		m.RawRanges = rawRanges
-		m.ClusterId = req.ClusterId
+		// the cluster ID may be changed by --adopt-new-cluster-id.
+		m.ClusterId = client.GetClusterID()
 		m.ClusterVersion = clusterVersion
 		m.BrVersion = brVersion
 		m.ApiVersion = dstAPIVersion
@@ -227,33 +752,242 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 		return errors.Trace(err)
 	}
 	g.Record(summary.BackupDataSize, metaWriter.ArchiveSize())
+	result.BackupTS = backupTs
+	result.Size = metaWriter.ArchiveSize()
+	result.output("backupmeta", metautil.MetaFile)
+	if checksumAlgorithm != metautil.ChecksumSHA256 {
+		if err = metautil.WriteChecksumAlgorithm(ctx, client.GetStorage(), checksumAlgorithm); err != nil {
+			return errors.Annotate(err, "failed to record the checksum algorithm of the backup")
+		}
+		result.output("checksum", metautil.ChecksumFile)
+	}
+	if err = verifyBackupMeta(ctx, &cfg.Config, metaWriter.FlushedItems()); err != nil {
+		return errors.Trace(err)
+	}
+	if err = client.RemoveCheckpoint(ctx); err != nil {
+		log.Warn("failed to remove the backup checkpoint", zap.Error(err))
+	}
+	recordTopology(ctx, mgr, client.GetStorage())
+	if curAPIVersion == kvrpcpb.APIVersion_V2 && dstAPIVersion == kvrpcpb.APIVersion_V2 {
+		recordKeyspaces(ctx, mgr, client.GetStorage(), keyspace)
+	}
+	sideFiles := &backupSideFiles{
+		keyspace:  keyspace,
+		locations: client.FileLocations(),
+		heatmap:   client.Heatmap(),
+		parent:    parent,
+		unsafeTS:  unsafeTS,
+	}
+	if err = sideFiles.write(ctx, client.GetStorage(), result); err != nil {
+		return errors.Trace(err)
+	}
+	switch s := client.GetStorage().(type) {
+	case *storage.FailoverStorage:
+		if s.Failovers() > 0 {
+			summary.CollectInt("storage failovers", s.Failovers())
+		}
+	case *storage.MirrorStorage:
+		if dropped := s.Dropped(); len(dropped) > 0 {
+			log.Warn("the backup isn't complete in the dropped storage mirrors", zap.Strings("mirrors", dropped))
+			summary.CollectInt("storage mirrors dropped", len(dropped))
+		}
+	}
 
-	if cfg.Checksum {
-		_, _, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &cfg.Config)
+	summary.CollectPhase("", phaseMetaFlush, time.Since(phaseStart))
+	if err = runHooks(ctx, cfg.Hooks, HookPostMetaFlush, result); err != nil {
+		return errors.Trace(err)
+	}
+
+	if (cfg.Checksum || cfg.VerifyRanges) && cfg.keyspaceID() != defaultKeyspaceID {
+		// the checksum client only accesses the default keyspace.
+		log.Warn("skip checksum of the backup of a keyspace other than the default one", zap.Uint32("keyspace", cfg.keyspaceID()))
+	} else if cfg.Checksum || cfg.VerifyRanges {
+		phaseStart = time.Now()
+		_, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &cfg.Config)
 		if err != nil {
 			log.Error("fail to read backup meta", zap.Error(err))
 			return errors.Trace(err)
 		}
-		fileChecksum, keyRanges := CalcChecksumAndRangeFromBackupMeta(ctx, backupMeta, curAPIVersion)
+		reader := metautil.NewMetaReader(backupMeta, s, &cfg.CipherInfo)
+		fileChecksum, keyRanges, err := CalcChecksumAndRangeFromBackupMeta(ctx, backupMeta, reader, curAPIVersion)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		result.Checksum = &metautil.ResultChecksum{
+			Crc64Xor:   fileChecksum.Crc64Xor,
+			TotalKvs:   fileChecksum.TotalKvs,
+			TotalBytes: fileChecksum.TotalBytes,
+		}
 		checksumMethod := checksum.StorageChecksumCommand
 		if curAPIVersion.String() != cfg.DstAPIVersion {
 			checksumMethod = checksum.StorageScanCommand
 		}
 
+		var rangeChecksums []rawkv.RawChecksum
+		if cfg.VerifyRanges {
+			rangeChecksums, keyRanges, err = CalcChecksumPerRangeFromBackupMeta(ctx, backupMeta, reader, curAPIVersion)
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+
 		executor, err := checksum.NewExecutor(ctx, keyRanges, cfg.PD, curAPIVersion,
 			cfg.ChecksumConcurrency, cfg.TLS)
 		if err != nil {
 			return errors.Trace(err)
 		}
 		defer executor.Close()
-		err = checksum.Run(ctx, cmdName, executor,
-			checksumMethod, fileChecksum)
+		if cfg.VerifyRanges {
+			err = checksum.RunEachRange(logutil.ContextWithPhase(ctx, "verify"), cmdName, executor,
+				checksumMethod, rangeChecksums)
+			summary.CollectInt("verified ranges", len(keyRanges))
+		} else {
+			err = checksum.Run(logutil.ContextWithPhase(ctx, "checksum"), cmdName, executor,
+				checksumMethod, fileChecksum)
+		}
+		summary.CollectPhase("", phaseVerification, time.Since(phaseStart))
 		if err != nil {
 			return errors.Trace(err)
 		}
 	}
 
+	catalogEntry.Status, catalogEntry.Size = catalog.StatusFinished, metaWriter.ArchiveSize()
+	if err = cfg.recordBackupInCatalog(ctx, catalogEntry); err != nil {
+		return errors.Trace(err)
+	}
+
 	// Set task summary to success status.
 	summary.SetSuccessStatus(true)
 	return nil
 }
+
+// backupTSClient is the subset of the backup client protecting the backup ts by the service safe point.
+type backupTSClient interface {
+	UpdateBRGCSafePoint(ctx context.Context, safeInterval time.Duration) (uint64, error)
+	UpdateBRGCSafePointWithTS(ctx context.Context, backupTS uint64) error
+}
+
+// resolvedBackupTS is the ts a backup is taken at.
+type resolvedBackupTS struct {
+	// backupTS is the ts the backup is consistent at, which is recorded as the end version of
+	// backupmeta. It's 0 if the cluster cannot protect the ts from GC.
+	backupTS uint64
+	// endVersion is the snapshot ts of the backup sent to the stores, 0 means the latest data.
+	endVersion uint64
+	// unsafeTS is how the backup ts is derived by --unsafe-ts, nil if the backup ts is allocated by PD.
+	unsafeTS *metautil.UnsafeTS
+}
+
+// resolveBackupTS decides the ts of the backup, in the order of the interrupted backup being
+// resumed, --unsafe-ts, a point of --backup-points, the ts decided by the storage template and
+// a new ts allocated by PD.
+func resolveBackupTS(
+	ctx context.Context,
+	cfg *RawKvConfig,
+	client backupTSClient,
+	checkpoint *backup.Checkpoint,
+	templateTS uint64,
+	unsafeTSFn func(context.Context) (*metautil.UnsafeTS, error),
+) (resolvedBackupTS, error) {
+	switch {
+	case checkpoint != nil && checkpoint.BackupTS > 0:
+		// keep the ts of the interrupted backup, which the completed ranges are consistent with.
+		ts := resolvedBackupTS{backupTS: checkpoint.BackupTS, endVersion: checkpoint.EndVersion}
+		if err := client.UpdateBRGCSafePointWithTS(ctx, ts.backupTS); err != nil {
+			return ts, errors.Annotatef(err, "failed to resume the backup at backup ts %d, please backup from scratch", ts.backupTS)
+		}
+		return ts, nil
+	case cfg.UnsafeTS:
+		unsafeTS, err := unsafeTSFn(ctx)
+		if err != nil {
+			return resolvedBackupTS{}, errors.Trace(err)
+		}
+		return resolvedBackupTS{backupTS: unsafeTS.BackupTS, endVersion: unsafeTS.BackupTS, unsafeTS: unsafeTS}, nil
+	case cfg.snapshotTS > 0:
+		// a point of --backup-points, which is checked against the min resolved ts already.
+		if err := client.UpdateBRGCSafePointWithTS(ctx, cfg.snapshotTS); err != nil {
+			return resolvedBackupTS{}, errors.Trace(err)
+		}
+		return resolvedBackupTS{backupTS: cfg.snapshotTS, endVersion: cfg.snapshotTS}, nil
+	case templateTS > 0:
+		// decided and protected by the safe point when the storage is expanded.
+		return resolvedBackupTS{backupTS: templateTS}, nil
+	default:
+		// set safepoint to avoid the logical deletion data to gc.
+		backupTS, err := client.UpdateBRGCSafePoint(ctx, cfg.SafeInterval)
+		if err != nil {
+			return resolvedBackupTS{}, errors.Trace(err)
+		}
+		return resolvedBackupTS{backupTS: backupTS}, nil
+	}
+}
+
+// backupSideFiles are the files written aside backupmeta after the backup finishes, the nil
+// ones are not written.
+type backupSideFiles struct {
+	keyspace  *metautil.Keyspace
+	locations *metautil.Locations
+	heatmap   *metautil.Heatmap
+	parent    *metautil.Parent
+	unsafeTS  *metautil.UnsafeTS
+}
+
+// write writes the side files into the storage of the backup, and records them as the outputs
+// of the task. The heatmap is only for diagnosis, so the backup doesn't fail for it.
+func (f *backupSideFiles) write(ctx context.Context, s storage.ExternalStorage, result *taskResult) error {
+	if f.keyspace != nil {
+		if err := metautil.WriteKeyspaceScope(ctx, s, f.keyspace); err != nil {
+			return errors.Annotate(err, "failed to record the keyspace of the backup")
+		}
+		result.output("keyspace", metautil.KeyspaceScopeFile)
+	}
+	if f.locations != nil {
+		if err := metautil.WriteLocations(ctx, s, f.locations); err != nil {
+			return errors.Annotate(err, "failed to record the storages the files are written to")
+		}
+		result.output("locations", metautil.LocationsFile)
+	}
+	if f.heatmap != nil {
+		if err := metautil.WriteHeatmap(ctx, s, f.heatmap); err != nil {
+			log.Warn("failed to record the heatmap of the backup, skip it", zap.Error(err))
+		} else {
+			result.output("heatmap", metautil.HeatmapFile)
+		}
+	}
+	if f.parent != nil {
+		if err := metautil.WriteParent(ctx, s, f.parent); err != nil {
+			return errors.Annotate(err, "failed to link the incremental backup to its parent")
+		}
+		result.output("parent", metautil.ParentFile)
+	}
+	if f.unsafeTS != nil {
+		if err := metautil.WriteUnsafeTS(ctx, s, f.unsafeTS); err != nil {
+			return errors.Trace(err)
+		}
+		result.output("unsafe-ts", metautil.UnsafeTSFile)
+	}
+	return nil
+}
+
+// getUnsafeTS derives the backup ts from the safe-ts reported by the stores, and protects it from
+// GC by the service safe point if PD still accepts it. Only the TSO of PD may be unavailable, the
+// stores are still listed by PD.
+func getUnsafeTS(ctx context.Context, client *backup.Client, mgr *conn.Mgr) (*metautil.UnsafeTS, error) {
+	stores, err := conn.GetAllTiKVStores(ctx, mgr.GetPDClient(), conn.SkipTiFlash)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to list the stores for --"+flagUnsafeTS)
+	}
+	unsafeTS, err := backup.GetUnsafeTS(ctx, stores, mgr)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = client.UpdateBRGCSafePointWithTS(ctx, unsafeTS.BackupTS); err != nil {
+		log.Warn("failed to set the service safe point at the unsafe ts", zap.Error(err))
+		unsafeTS.Caveats = append(unsafeTS.Caveats, metautil.UnsafeTSCaveatNoSafePoint)
+	}
+	for _, caveat := range unsafeTS.Caveats {
+		logutil.WarnTerm("backup at unsafe ts", zap.Uint64("backup-ts", unsafeTS.BackupTS), zap.String("caveat", caveat))
+	}
+	summary.CollectUint("unsafe ts", unsafeTS.BackupTS)
+	return unsafeTS, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/tikv/migration/br/pkg/backup"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// RunShowStatus returns the progress of the backup in --storage. Without --storage, it returns
+// the progress of all backups published to the etcd of PD in the last week.
+func RunShowStatus(ctx context.Context, cfg *Config) ([]*backup.Status, error) {
+	if err := cfg.ResolveStorageByName(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(cfg.Storage) > 0 {
+		u, err := storage.ParseBackend(cfg.Storage, &cfg.BackendOptions)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		s, err := storage.New(ctx, u, storageOpts(cfg))
+		if err != nil {
+			return nil, errors.Annotate(err, "create storage failed")
+		}
+		status, err := backup.ReadStatus(ctx, s)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if status == nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"no backup status is found in %s, the backup may be run with --%s=0", cfg.Storage, flagStatusInterval)
+		}
+		return []*backup.Status{status}, nil
+	}
+	if len(cfg.PD) == 0 {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, "either --storage or --pd is required")
+	}
+	cli, err := newPDEtcdClient(cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer cli.Close()
+	ctx, cancel := context.WithTimeout(ctx, pdEtcdRequestTimeout)
+	defer cancel()
+	statuses, err := backup.ReadEtcdStatuses(ctx, cli)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read the backup status from the etcd of PD")
+	}
+	return statuses, nil
+}
@@ -3,10 +3,20 @@
 package task
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	backup "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/require"
+	brbackup "github.com/tikv/migration/br/pkg/backup"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/storage"
 )
 
 func TestParseCompressionType(t *testing.T) {
@@ -32,3 +42,184 @@ func TestParseCompressionType(t *testing.T) {
 	require.Regexp(t, "invalid compression.*", err.Error())
 	require.Zero(t, ct)
 }
+
+func TestBackupRanges(t *testing.T) {
+	cfg := &RawKvConfig{
+		StartKey:        []byte("b"),
+		EndKey:          []byte("y"),
+		IncludePrefixes: [][]byte{[]byte("a"), []byte("c"), []byte("ca"), []byte("d")},
+		ExcludePrefixes: [][]byte{[]byte("cb")},
+	}
+	ranges, err := cfg.backupRanges(kvrpcpb.APIVersion_V1)
+	require.NoError(t, err)
+	require.Equal(t, []rtree.Range{
+		{StartKey: []byte("c"), EndKey: []byte("cb")},
+		{StartKey: []byte("cc"), EndKey: []byte("e")},
+	}, ranges)
+
+	cfg = &RawKvConfig{StartKey: []byte("r\x00\x00\x00"), EndKey: []byte("r\x00\x00\x01"),
+		IncludePrefixes: [][]byte{[]byte("k")}}
+	ranges, err = cfg.backupRanges(kvrpcpb.APIVersion_V2)
+	require.NoError(t, err)
+	require.Equal(t, []rtree.Range{{StartKey: []byte("r\x00\x00\x00k"), EndKey: []byte("r\x00\x00\x00l")}}, ranges)
+
+	// the range and prefixes are within the keyspace.
+	keyspaceID := uint32(2)
+	cfg = &RawKvConfig{IncludePrefixes: [][]byte{[]byte("k")}, KeyspaceID: &keyspaceID}
+	cfg.adjustBackupRange(kvrpcpb.APIVersion_V2, cfg.keyspaceID())
+	require.Equal(t, []byte("r\x00\x00\x02"), cfg.StartKey)
+	require.Equal(t, []byte("r\x00\x00\x03"), cfg.EndKey)
+	ranges, err = cfg.backupRanges(kvrpcpb.APIVersion_V2)
+	require.NoError(t, err)
+	require.Equal(t, []rtree.Range{{StartKey: []byte("r\x00\x00\x02k"), EndKey: []byte("r\x00\x00\x02l")}}, ranges)
+
+	cfg = &RawKvConfig{IncludePrefixes: [][]byte{[]byte("a")}, ExcludePrefixes: [][]byte{[]byte("a")}}
+	_, err = cfg.backupRanges(kvrpcpb.APIVersion_V1)
+	require.True(t, berrors.Is(err, berrors.ErrBackupInvalidRange))
+}
+
+func TestParseBackoff(t *testing.T) {
+	command := &cobra.Command{}
+	DefineRawBackupFlags(command)
+	flags := command.Flags()
+	cfg := &RawKvConfig{}
+	require.NoError(t, cfg.parseBackoff(flags))
+	require.Equal(t, brbackup.DefaultBackoffConfig(), *cfg.Backoff)
+
+	require.NoError(t, flags.Set(flagBackoffStream, "initial=100ms,max-attempts=10"))
+	require.NoError(t, cfg.parseBackoff(flags))
+	expected := brbackup.DefaultBackoffConfig()
+	expected.Stream.InitialInterval = 100 * time.Millisecond
+	expected.Stream.MaxAttempts = 10
+	require.Equal(t, expected, *cfg.Backoff)
+
+	require.NoError(t, flags.Set(flagBackoffFineGrained, "jitter=1.5"))
+	require.Error(t, cfg.parseBackoff(flags))
+}
+
+func TestParseSkipStores(t *testing.T) {
+	parse := func(args ...string) (*RawKvConfig, error) {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags.StringSlice(flagSkipStores, nil, "")
+		flags.StringSlice(flagSkipStoreLabels, nil, "")
+		flags.Bool(flagDeferSkippedStores, false, "")
+		require.NoError(t, flags.Parse(args))
+		cfg := &RawKvConfig{}
+		return cfg, cfg.parseSkipStores(flags)
+	}
+	cfg, err := parse("--skip-stores", "1,4", "--skip-store-labels", "zone=z1", "--defer-skipped-stores")
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 4}, cfg.SkipStores)
+	require.Equal(t, []string{"zone=z1"}, cfg.SkipStoreLabels)
+	require.True(t, cfg.DeferSkippedStores)
+
+	for _, args := range [][]string{
+		{"--skip-stores", "a"},
+		{"--skip-stores", "0"},
+		{"--skip-store-labels", "zone"},
+	} {
+		_, err = parse(args...)
+		require.True(t, berrors.Is(err, berrors.ErrInvalidArgument), args)
+	}
+}
+
+// fakeBackupTSClient allocates the backup ts and records the ts protected by the safe point.
+type fakeBackupTSClient struct {
+	allocated uint64
+	protected []uint64
+}
+
+func (c *fakeBackupTSClient) UpdateBRGCSafePoint(ctx context.Context, safeInterval time.Duration) (uint64, error) {
+	c.protected = append(c.protected, c.allocated)
+	return c.allocated, nil
+}
+
+func (c *fakeBackupTSClient) UpdateBRGCSafePointWithTS(ctx context.Context, backupTS uint64) error {
+	c.protected = append(c.protected, backupTS)
+	return nil
+}
+
+func TestResolveBackupTS(t *testing.T) {
+	ctx := context.Background()
+	unsafeTS := &metautil.UnsafeTS{BackupTS: 400}
+	unsafeTSFn := func(context.Context) (*metautil.UnsafeTS, error) { return unsafeTS, nil }
+	resolve := func(cfg *RawKvConfig, checkpoint *brbackup.Checkpoint, templateTS uint64) (resolvedBackupTS, []uint64) {
+		client := &fakeBackupTSClient{allocated: 500}
+		ts, err := resolveBackupTS(ctx, cfg, client, checkpoint, templateTS, unsafeTSFn)
+		require.NoError(t, err)
+		return ts, client.protected
+	}
+
+	ts, protected := resolve(&RawKvConfig{}, nil, 0)
+	require.Equal(t, resolvedBackupTS{backupTS: 500}, ts)
+	require.Equal(t, []uint64{500}, protected)
+
+	// the template ts is protected when the storage is expanded.
+	ts, protected = resolve(&RawKvConfig{}, nil, 300)
+	require.Equal(t, resolvedBackupTS{backupTS: 300}, ts)
+	require.Empty(t, protected)
+
+	ts, protected = resolve(&RawKvConfig{snapshotTS: 200}, nil, 300)
+	require.Equal(t, resolvedBackupTS{backupTS: 200, endVersion: 200}, ts)
+	require.Equal(t, []uint64{200}, protected)
+
+	// the safe point of the unsafe ts is set by unsafeTSFn.
+	ts, protected = resolve(&RawKvConfig{UnsafeTS: true}, nil, 0)
+	require.Equal(t, resolvedBackupTS{backupTS: 400, endVersion: 400, unsafeTS: unsafeTS}, ts)
+	require.Empty(t, protected)
+
+	// the resumed backup keeps the ts of the checkpoint.
+	checkpoint := &brbackup.Checkpoint{BackupTS: 100, EndVersion: 100}
+	ts, protected = resolve(&RawKvConfig{UnsafeTS: true}, checkpoint, 300)
+	require.Equal(t, resolvedBackupTS{backupTS: 100, endVersion: 100}, ts)
+	require.Equal(t, []uint64{100}, protected)
+
+	// the checkpoint of the older BR has no backup ts.
+	ts, _ = resolve(&RawKvConfig{}, &brbackup.Checkpoint{}, 0)
+	require.Equal(t, resolvedBackupTS{backupTS: 500}, ts)
+}
+
+func TestWriteBackupSideFiles(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	result := newTaskResult("backup raw", metautil.BackupResultFile)
+	result.storage = s
+
+	require.NoError(t, (&backupSideFiles{}).write(ctx, s, result))
+	require.Empty(t, result.Outputs)
+
+	files := &backupSideFiles{
+		keyspace:  &metautil.Keyspace{Name: "ks", ID: 1},
+		locations: &metautil.Locations{Endpoints: []metautil.EndpointFiles{{URI: "local:///mirror"}}},
+		heatmap:   &metautil.Heatmap{Buckets: []metautil.HeatmapBucket{{StartKey: []byte("a"), EndKey: []byte("b")}}},
+		parent:    &metautil.Parent{Storage: "local:///parent"},
+		unsafeTS:  &metautil.UnsafeTS{BackupTS: 100},
+	}
+	require.NoError(t, files.write(ctx, s, result))
+	for name, file := range map[string]string{
+		"keyspace":  metautil.KeyspaceScopeFile,
+		"locations": metautil.LocationsFile,
+		"heatmap":   metautil.HeatmapFile,
+		"parent":    metautil.ParentFile,
+		"unsafe-ts": metautil.UnsafeTSFile,
+	} {
+		require.Equal(t, s.URI()+"/"+file, result.Outputs[name], name)
+	}
+
+	keyspace, err := metautil.ReadKeyspaceScope(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, files.keyspace, keyspace)
+	locations, err := metautil.ReadLocations(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, files.locations.Endpoints[0].URI, locations.Endpoints[0].URI)
+	heatmap, err := metautil.ReadHeatmap(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, files.heatmap.Buckets[0].StartKey, heatmap.Buckets[0].StartKey)
+	parent, err := metautil.ReadParent(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, files.parent.Storage, parent.Storage)
+	unsafeTS, err := metautil.ReadUnsafeTS(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, files.unsafeTS.BackupTS, unsafeTS.BackupTS)
+}
@@ -0,0 +1,157 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tikv/migration/br/pkg/backup"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/summary"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+const (
+	flagBenchObjectSize        = "object-size"
+	flagBenchObjects           = "objects"
+	flagBenchObjectConcurrency = "object-concurrency"
+
+	defaultBenchObjectSize        = 64 * units.MiB
+	defaultBenchObjects           = 32
+	defaultBenchObjectConcurrency = 4
+)
+
+// BenchStorageConfig is the configuration specific for `br bench storage`.
+type BenchStorageConfig struct {
+	Config
+
+	ObjectSize        int `json:"object-size" toml:"object-size"`
+	Objects           int `json:"objects" toml:"objects"`
+	ObjectConcurrency int `json:"object-concurrency" toml:"object-concurrency"`
+}
+
+// DefineBenchStorageFlags defines the flags of `br bench storage`.
+func DefineBenchStorageFlags(command *cobra.Command) {
+	command.Flags().Int(flagBenchObjectSize, defaultBenchObjectSize, "The size in bytes of each object uploaded and downloaded")
+	command.Flags().Int(flagBenchObjects, defaultBenchObjects, "The number of objects uploaded and downloaded")
+	command.Flags().Int(flagBenchObjectConcurrency, defaultBenchObjectConcurrency,
+		"The number of objects uploaded or downloaded at the same time")
+}
+
+// ParseFromFlags parses the config from the flag set.
+func (cfg *BenchStorageConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.ObjectSize, err = flags.GetInt(flagBenchObjectSize); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Objects, err = flags.GetInt(flagBenchObjects); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ObjectConcurrency, err = flags.GetInt(flagBenchObjectConcurrency); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// RunBenchStorage measures the throughput and the latencies of uploading objects to
+// and downloading them from the storage, to tell whether the storage is the bottleneck.
+func RunBenchStorage(c context.Context, cmdName string, cfg *BenchStorageConfig) ([]storage.BenchResult, error) {
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	if len(cfg.Storage) == 0 {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, "--storage is required by the storage benchmark")
+	}
+	_, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	results, err := storage.Bench(ctx, s, storage.BenchOptions{
+		ObjectSize:  cfg.ObjectSize,
+		Objects:     cfg.Objects,
+		Concurrency: cfg.ObjectConcurrency,
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	summary.SetSuccessStatus(true)
+	return results, nil
+}
+
+// DefineBenchClusterFlags defines the flags of `br bench cluster`.
+func DefineBenchClusterFlags(command *cobra.Command) {
+	command.Flags().StringP(flagStartKey, "", "", "The start key of the range to scan, key is inclusive.")
+	command.Flags().StringP(flagEndKey, "", "", "The end key of the range to scan, key is exclusive.")
+	command.Flags().StringP(flagKeyFormat, "", "hex",
+		"The format of start and end key. Available options: \"raw\", \"escaped\", \"hex\".")
+}
+
+// RunBenchCluster backs up the range on all stores to the noop storage, and returns
+// the throughput of each store, to tell whether TiKV is the bottleneck.
+func RunBenchCluster(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConfig) ([]backup.StoreBenchResult, error) {
+	cfg.adjust()
+
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer mgr.Close()
+	mgr.SetGRPCMaxMsgSize(cfg.GRPCMaxMsgSize)
+	mgr.SetDNSRefreshInterval(cfg.DNSRefreshInterval)
+	if err = setupStoreConnectivity(ctx, mgr, &cfg.Config); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	client, err := backup.NewBackupClient(ctx, mgr, mgr.GetTLSConfig())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	client.SetDynamicSettings(utils.GlobalDynamicSettings())
+	curAPIVersion := client.GetCurAPIVersion()
+	cfg.adjustBackupRange(curAPIVersion, defaultKeyspaceID)
+
+	ctx = logutil.ContextWithPhase(ctx, "bench-cluster")
+	req := backuppb.BackupRequest{
+		ClusterId:     client.GetClusterID(),
+		StartKey:      cfg.StartKey,
+		EndKey:        cfg.EndKey,
+		RateLimit:     cfg.RateLimit,
+		Concurrency:   cfg.Concurrency,
+		IsRawKv:       true,
+		Cf:            "default",
+		DstApiVersion: curAPIVersion,
+		CipherInfo:    &cfg.CipherInfo,
+	}
+	results, err := client.BenchStores(ctx, req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	summary.CollectInt("stores", len(results))
+	summary.SetSuccessStatus(true)
+	return results, nil
+}
@@ -0,0 +1,265 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/catalog"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// catalogUpdateAttempts is the number of attempts to update the catalog updated concurrently.
+const catalogUpdateAttempts = 3
+
+// catalogMu serializes the updates of the catalog in the process, e.g. by the backups of --clusters.
+var catalogMu sync.Mutex
+
+// storageURIWithoutCredentials keeps only the scheme, the host and the path of the storage URI.
+// The query holds the options of the storages, some of which are credentials or refer to them,
+// and the user info may hold the password, so they're all removed. The options are given by the
+// flags again to access the storage.
+func storageURIWithoutCredentials(rawURI string) (string, error) {
+	u, err := url.Parse(rawURI)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	u.User, u.RawQuery, u.ForceQuery, u.Fragment, u.RawFragment = nil, "", false, "", ""
+	return u.String(), nil
+}
+
+// openCatalog opens the storage of the catalog, and loads the catalog from it.
+func (cfg *Config) openCatalog(ctx context.Context) (storage.ExternalStorage, *catalog.Catalog, error) {
+	if len(cfg.Catalog) == 0 {
+		return nil, nil, errors.Annotate(berrors.ErrInvalidArgument, "--catalog is required when --name is set")
+	}
+	s, err := storage.NewFromURL(ctx, cfg.Catalog, &cfg.BackendOptions,
+		&storage.ExternalStorageOptions{NoCredentials: cfg.NoCreds, HDFS: &cfg.BackendOptions.HDFS})
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "create catalog storage failed")
+	}
+	c, err := catalog.Load(ctx, s)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	compression, err := cfg.artifactCompression()
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	c.SetCompression(compression)
+	return s, c, nil
+}
+
+// ResolveStorageByName sets the storage of the task by looking up the backup name in the catalog.
+// It does nothing if no name is given. The catalog doesn't record the options of the storage,
+// e.g. the endpoint and the credentials, which are given by the flags such as --s3.endpoint.
+func (cfg *Config) ResolveStorageByName(ctx context.Context) error {
+	if len(cfg.Name) == 0 {
+		return nil
+	}
+	if len(cfg.Storage) != 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "--storage and --name cannot be set at the same time")
+	}
+	_, c, err := cfg.openCatalog(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	entry, ok := c.Lookup(cfg.Name)
+	if !ok {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "backup '%s' not found in catalog %s", cfg.Name, cfg.Catalog)
+	}
+	cfg.Storage = entry.Storage
+	log.Info("resolve backup by name", zap.String("name", cfg.Name), zap.String("storage", entry.Storage))
+	return nil
+}
+
+// checkBackupName checks the name given to a backup task before the backup starts.
+func (cfg *Config) checkBackupName() error {
+	if len(cfg.Name) == 0 {
+		return nil
+	}
+	if len(cfg.Catalog) == 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "--catalog is required when --name is set")
+	}
+	return catalog.ValidateName(cfg.Name)
+}
+
+// catalogName returns the name of the backup in the catalog, which defaults to the path of the
+// storage under the catalog storage. It returns false if the backup isn't recorded in the catalog.
+func (cfg *Config) catalogName() (string, bool) {
+	if len(cfg.Catalog) == 0 {
+		return "", false
+	}
+	if len(cfg.Name) > 0 {
+		return cfg.Name, true
+	}
+	catalogURI, err := storageURIWithoutCredentials(cfg.Catalog)
+	if err != nil {
+		return "", false
+	}
+	storageURI, err := storageURIWithoutCredentials(cfg.Storage)
+	if err != nil {
+		return "", false
+	}
+	rel, ok := storage.RelativeURL(catalogURI, storageURI)
+	if !ok {
+		return "", false
+	}
+	rel = path.Clean(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") || catalog.ValidateName(rel) != nil {
+		return "", false
+	}
+	return rel, true
+}
+
+// updateCatalog applies the update to the catalog and saves it, the update returns the entry
+// expected in the catalog, or nil if there is nothing to update. The storage has no compare-and-swap,
+// so the catalog is read back to check the entry, and the update is applied again if it's
+// overwritten by a concurrent update of another BR.
+func (cfg *Config) updateCatalog(ctx context.Context, update func(c *catalog.Catalog) (*catalog.Entry, error)) error {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	for attempt := 1; ; attempt++ {
+		s, c, err := cfg.openCatalog(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		expected, err := update(c)
+		if err != nil || expected == nil {
+			return errors.Trace(err)
+		}
+		if err = c.Save(ctx, s); err != nil {
+			return errors.Trace(err)
+		}
+		saved, err := catalog.Load(ctx, s)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if entry, ok := saved.Lookup(expected.Name); ok {
+			want, _ := json.Marshal(expected)
+			got, _ := json.Marshal(entry)
+			if bytes.Equal(want, got) {
+				return nil
+			}
+		}
+		if attempt >= catalogUpdateAttempts {
+			return errors.Annotatef(berrors.ErrStorageUnknown,
+				"the catalog %s is updated concurrently, failed to record %s", cfg.Catalog, expected.Name)
+		}
+		log.Warn("the catalog is updated concurrently, update it again",
+			zap.String("name", expected.Name), zap.Int("attempt", attempt))
+		time.Sleep(time.Duration(rand.Int63n(int64(time.Second))))
+	}
+}
+
+// recordBackupInCatalog records the backup into the catalog with its status, which is finished if
+// it's not set. It does nothing if the backup isn't recorded in the catalog, see catalogName.
+func (cfg *Config) recordBackupInCatalog(ctx context.Context, entry catalog.Entry) error {
+	name, ok := cfg.catalogName()
+	if !ok {
+		return nil
+	}
+	var err error
+	entry.Name = name
+	if entry.Storage, err = storageURIWithoutCredentials(cfg.Storage); err != nil {
+		return errors.Trace(err)
+	}
+	if len(entry.StorageTemplate) > 0 {
+		if entry.StorageTemplate, err = storageURIWithoutCredentials(entry.StorageTemplate); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if len(entry.Status) == 0 {
+		entry.Status = catalog.StatusFinished
+	}
+	entry.UpdatedAt = time.Now()
+	err = cfg.updateCatalog(ctx, func(c *catalog.Catalog) (*catalog.Entry, error) {
+		old, exists := c.Lookup(name)
+		if exists && old.Storage == entry.Storage {
+			// the restore of the same backup is kept.
+			entry.LastRestoredAt, entry.LastRestoreStatus = old.LastRestoredAt, old.LastRestoreStatus
+		}
+		if err := c.Add(entry); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return &entry, nil
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("backup recorded in catalog",
+		zap.String("name", name), zap.String("status", entry.Status), zap.String("catalog", cfg.Catalog))
+	return nil
+}
+
+// recordRestoreInCatalog records the restore into the entry of the backup in the catalog, if any.
+// The restore doesn't fail for the catalog.
+func (cfg *Config) recordRestoreInCatalog(ctx context.Context, restoreErr error) {
+	if len(cfg.Catalog) == 0 || len(cfg.Storage) == 0 {
+		return
+	}
+	storageURI, err := storageURIWithoutCredentials(cfg.Storage)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	status := catalog.StatusFinished
+	if restoreErr != nil {
+		status = catalog.StatusFailed
+	}
+	err = cfg.updateCatalog(ctx, func(c *catalog.Catalog) (*catalog.Entry, error) {
+		entry, ok := c.LookupStorage(storageURI)
+		if len(cfg.Name) > 0 {
+			entry, ok = c.Lookup(cfg.Name)
+		}
+		if !ok {
+			return nil, nil
+		}
+		entry.LastRestoredAt, entry.LastRestoreStatus = &now, status
+		expected := *entry
+		return &expected, nil
+	})
+	if err != nil {
+		log.Warn("failed to record the restore in catalog", zap.String("catalog", cfg.Catalog), zap.Error(err))
+	}
+}
+
+// ListBackups returns the backups recorded in the catalog of --catalog, or at the root of --storage.
+func ListBackups(ctx context.Context, cfg *Config) ([]catalog.Entry, error) {
+	listCfg := *cfg
+	if len(listCfg.Catalog) == 0 {
+		listCfg.Catalog = cfg.Storage
+	}
+	if len(listCfg.Catalog) == 0 {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, "--catalog or --storage is required to list the backups")
+	}
+	_, c, err := listCfg.openCatalog(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return c.Entries, nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// resolveParent reads the parent backup of an incremental backup, and defaults
+// LastBackupTS to its backup ts. It returns nil if ParentStorage is not set.
+func (cfg *RawKvConfig) resolveParent(ctx context.Context, dstAPIVersion kvrpcpb.APIVersion) (*metautil.Parent, error) {
+	if len(cfg.ParentStorage) == 0 {
+		return nil, nil
+	}
+	parentCfg := cfg.configOfBackup(cfg.ParentStorage)
+	_, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &parentCfg)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read the parent backup %s", cfg.ParentStorage)
+	}
+	if !backupMeta.IsRawKv || backupMeta.ApiVersion != dstAPIVersion {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the parent backup %s is not a raw kv backup of api version %s", cfg.ParentStorage, dstAPIVersion)
+	}
+	if cfg.LastBackupTS == 0 {
+		if backupMeta.EndVersion == 0 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"the parent backup %s doesn't record its backup ts, please specify --%s", cfg.ParentStorage, flagLastBackupTS)
+		}
+		cfg.LastBackupTS = backupMeta.EndVersion
+	}
+	a, err := metautil.ParseChecksumAlgorithm(cfg.ChecksumAlgorithm)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	checksum, err := metautil.BackupMetaChecksum(ctx, s, a)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	parent := &metautil.Parent{Storage: cfg.ParentStorage, Checksum: checksum, BackupTS: cfg.LastBackupTS}
+	parent.RelativeStorage, _ = storage.RelativeURL(cfg.Storage, cfg.ParentStorage)
+	if a != metautil.ChecksumSHA256 {
+		// keep the linkage readable by older versions unless another algorithm is chosen.
+		parent.ChecksumAlgorithm = a.String()
+	}
+	return parent, nil
+}
+
+// loadBackupChain walks the parents of the backup in the storage, and returns
+// their storage URLs from the oldest one. Every parent is checked to be the one
+// the incremental backup was based on.
+func loadBackupChain(ctx context.Context, cfg *Config, s storage.ExternalStorage) ([]string, error) {
+	current := cfg.Storage
+	visited := map[string]struct{}{current: {}}
+	var chain []string
+	for {
+		parent, err := metautil.ReadParent(ctx, s)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if parent == nil {
+			break
+		}
+		parentURL, ps, err := openParent(ctx, cfg, current, parent)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if _, ok := visited[parentURL]; ok {
+			return nil, errors.Annotatef(berrors.ErrRestoreInvalidBackup,
+				"the backup chain has a cycle at %s", parentURL)
+		}
+		visited[parentURL] = struct{}{}
+		if err = metautil.VerifyParent(ctx, ps, parent); err != nil {
+			return nil, errors.Trace(err)
+		}
+		log.Info("found the parent backup", zap.String("storage", parentURL), zap.Uint64("backup-ts", parent.BackupTS))
+		chain = append(chain, parentURL)
+		current, s = parentURL, ps
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain, nil
+}
+
+// openParent opens the parent backup of the backup at rawURL. The parent at the relative URL is
+// preferred, which is where it is if the chain is copied to another bucket or prefix together.
+// The recorded absolute URL is the fallback, e.g. for the linkages written by older BR.
+func openParent(
+	ctx context.Context, cfg *Config, rawURL string, parent *metautil.Parent,
+) (string, storage.ExternalStorage, error) {
+	if len(parent.RelativeStorage) > 0 {
+		parentURL, err := storage.ResolveURL(rawURL, parent.RelativeStorage)
+		if err != nil {
+			return "", nil, errors.Trace(err)
+		}
+		parentCfg := *cfg
+		parentCfg.Storage = parentURL
+		_, s, err := GetStorage(ctx, &parentCfg)
+		if err != nil {
+			return "", nil, errors.Trace(err)
+		}
+		exists, err := s.FileExists(ctx, metautil.MetaFile)
+		if err != nil {
+			return "", nil, errors.Trace(err)
+		}
+		if exists {
+			return parentURL, s, nil
+		}
+		log.Info("the parent backup is not at the relative URL, try the recorded one",
+			zap.String("relative-storage", parentURL), zap.String("storage", parent.Storage))
+	}
+	parentCfg := *cfg
+	parentCfg.Storage = parent.Storage
+	_, s, err := GetStorage(ctx, &parentCfg)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	return parent.Storage, s, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestLoadBackupChain(t *testing.T) {
+	ctx := context.Background()
+	newBackup := func(meta string) (string, storage.ExternalStorage) {
+		dir := t.TempDir()
+		s, err := storage.NewLocalStorage(dir)
+		require.NoError(t, err)
+		require.NoError(t, s.WriteFile(ctx, metautil.MetaFile, []byte(meta)))
+		return "local://" + dir, s
+	}
+	link := func(s storage.ExternalStorage, parentURL string, parent storage.ExternalStorage) {
+		checksum, err := metautil.BackupMetaChecksum(ctx, parent, metautil.ChecksumSHA256)
+		require.NoError(t, err)
+		require.NoError(t, metautil.WriteParent(ctx, s, &metautil.Parent{Storage: parentURL, Checksum: checksum}))
+	}
+	fullURL, full := newBackup("full")
+	inc1URL, inc1 := newBackup("inc1")
+	inc2URL, inc2 := newBackup("inc2")
+	link(inc1, fullURL, full)
+	link(inc2, inc1URL, inc1)
+
+	chain, err := loadBackupChain(ctx, &Config{Storage: inc2URL}, inc2)
+	require.NoError(t, err)
+	require.Equal(t, []string{fullURL, inc1URL}, chain)
+	chain, err = loadBackupChain(ctx, &Config{Storage: fullURL}, full)
+	require.NoError(t, err)
+	require.Empty(t, chain)
+
+	// the parent is replaced by another backup.
+	require.NoError(t, full.WriteFile(ctx, metautil.MetaFile, []byte("another full")))
+	_, err = loadBackupChain(ctx, &Config{Storage: inc2URL}, inc2)
+	require.Error(t, err)
+
+	// the chain has a cycle.
+	link(full, inc2URL, inc2)
+	link(inc1, fullURL, full)
+	_, err = loadBackupChain(ctx, &Config{Storage: inc2URL}, inc2)
+	require.Error(t, err)
+}
+
+func TestLoadCopiedBackupChain(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	newBackup := func(dir, meta string) (string, storage.ExternalStorage) {
+		s, err := storage.NewLocalStorage(filepath.Join(root, dir))
+		require.NoError(t, err)
+		require.NoError(t, s.WriteFile(ctx, metautil.MetaFile, []byte(meta)))
+		return "local://" + filepath.Join(root, dir), s
+	}
+	fullURL, full := newBackup("origin/full", "full")
+	incURL, inc := newBackup("origin/inc", "inc")
+	checksum, err := metautil.BackupMetaChecksum(ctx, full, metautil.ChecksumSHA256)
+	require.NoError(t, err)
+	parent := &metautil.Parent{Storage: fullURL, Checksum: checksum}
+	parent.RelativeStorage, _ = storage.RelativeURL(incURL, fullURL)
+	require.Equal(t, "../full", parent.RelativeStorage)
+	require.NoError(t, metautil.WriteParent(ctx, inc, parent))
+
+	// the chain is copied to another prefix together, and the original is replaced.
+	copiedFullURL, _ := newBackup("copied/full", "full")
+	copiedIncURL, copiedInc := newBackup("copied/inc", "inc")
+	require.NoError(t, metautil.WriteParent(ctx, copiedInc, parent))
+	require.NoError(t, full.WriteFile(ctx, metautil.MetaFile, []byte("another full")))
+	chain, err := loadBackupChain(ctx, &Config{Storage: copiedIncURL}, copiedInc)
+	require.NoError(t, err)
+	require.Equal(t, []string{copiedFullURL}, chain)
+
+	// only the incremental backup is copied, the parent is found at the recorded URL.
+	require.NoError(t, full.WriteFile(ctx, metautil.MetaFile, []byte("full")))
+	aloneURL, alone := newBackup("alone/inc", "inc")
+	require.NoError(t, metautil.WriteParent(ctx, alone, parent))
+	chain, err = loadBackupChain(ctx, &Config{Storage: aloneURL}, alone)
+	require.NoError(t, err)
+	require.Equal(t, []string{fullURL}, chain)
+}
@@ -0,0 +1,260 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tikv/migration/br/pkg/backup"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/summary"
+	"go.uber.org/zap"
+)
+
+const (
+	// flagMinAge keeps the orphaned files modified recently.
+	flagMinAge = "min-age"
+
+	defaultCleanupMinAge = 24 * time.Hour
+)
+
+// CleanupConfig is the configuration specific for `br cleanup`.
+type CleanupConfig struct {
+	Config
+
+	DryRun bool `json:"dry-run" toml:"dry-run"`
+	// MinAge is the age of the orphaned files below which they are kept, since they may be
+	// written by a running backup. 0 deletes all the orphaned files.
+	MinAge time.Duration `json:"min-age" toml:"min-age"`
+}
+
+// DefineCleanupFlags defines the flags of `br cleanup`.
+func DefineCleanupFlags(command *cobra.Command) {
+	command.Flags().Bool(flagDryRun, false, "List the orphaned SST files without deleting them")
+	command.Flags().Duration(flagMinAge, defaultCleanupMinAge,
+		"Keep the orphaned SST files modified within the duration, which may be written by a running backup "+
+			"without a checkpoint yet. It should be longer than the longest backup. 0 deletes all the orphaned files, "+
+			"which requires no backup writing to the storage.")
+}
+
+// ParseFromFlags parses the config from the flag set.
+func (cfg *CleanupConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.DryRun, err = flags.GetBool(flagDryRun); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.MinAge, err = flags.GetDuration(flagMinAge); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.MinAge < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must not be negative", flagMinAge)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// CleanupReport is the orphaned SST files found under the storage.
+type CleanupReport struct {
+	// Backups are the directories of the backups found, i.e. those with a backupmeta.
+	Backups []string
+	// Orphaned are the SST files not referenced by any backupmeta, e.g. the leftovers of failed backups.
+	Orphaned []string
+	// OrphanedSize is the total size of the orphaned files.
+	OrphanedSize int64
+	// Kept are the SST files not referenced yet, but under an unfinished backup which can be resumed
+	// by its checkpoint.
+	Kept []string
+	// Recent are the SST files not referenced yet, but modified within --min-age, which may be
+	// written by a running backup.
+	Recent []string
+	// Deleted are the orphaned files deleted.
+	Deleted []string
+}
+
+// ReferencedFilesReader reads the files referenced by the backupmeta of the backup in the
+// directory of the storage, whose names are relative to the directory.
+type ReferencedFilesReader func(ctx context.Context, dir string) ([]string, error)
+
+// isUnder returns whether the name is in the directory, "." is the root of the storage.
+func isUnder(name, dir string) bool {
+	return dir == "." || strings.HasPrefix(name, dir+"/")
+}
+
+// backupObjects are the objects under a storage, which may hold many backups in its sub-directories.
+type backupObjects struct {
+	// sizes are the sizes of all the objects.
+	sizes map[string]int64
+	// metaDirs are the directories of the backups, i.e. those with a backupmeta.
+	metaDirs []string
+	// checkpointDirs are the directories of the unfinished backups, which can be resumed by their checkpoints.
+	checkpointDirs []string
+}
+
+// scanBackupObjects walks the storage for the backups under it.
+func scanBackupObjects(ctx context.Context, s storage.ExternalStorage) (*backupObjects, error) {
+	checkpoints := make(map[string]struct{}, 2)
+	for _, name := range storage.CompressedNames(backup.CheckpointFile) {
+		checkpoints[name] = struct{}{}
+	}
+	objects := &backupObjects{sizes: make(map[string]int64)}
+	err := s.WalkDir(ctx, &storage.WalkOption{}, func(name string, size int64) error {
+		name = strings.TrimPrefix(name, "/")
+		objects.sizes[name] = size
+		base := path.Base(name)
+		if base == metautil.MetaFile {
+			objects.metaDirs = append(objects.metaDirs, path.Dir(name))
+		} else if _, ok := checkpoints[base]; ok {
+			objects.checkpointDirs = append(objects.checkpointDirs, path.Dir(name))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(objects.metaDirs) == 0 {
+		// without any backupmeta, all the files would be orphaned, which is more likely a wrong storage.
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, "no backupmeta found in the storage")
+	}
+	sort.Strings(objects.metaDirs)
+	sort.Strings(objects.checkpointDirs)
+	return objects, nil
+}
+
+// FindOrphaned walks the storage, and finds the SST files not referenced by the backupmeta of
+// any backup under the storage, which may hold many backups in its sub-directories. The files
+// modified within minAge are not orphaned, 0 means no such files.
+func FindOrphaned(
+	ctx context.Context, s storage.ExternalStorage, read ReferencedFilesReader, minAge time.Duration,
+) (*CleanupReport, error) {
+	objects, err := scanBackupObjects(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ssts := make(map[string]int64)
+	for name, size := range objects.sizes {
+		if strings.HasSuffix(name, ".sst") {
+			ssts[name] = size
+		}
+	}
+	report := &CleanupReport{Backups: objects.metaDirs}
+	now := time.Now()
+	for _, dir := range objects.metaDirs {
+		referenced, err := read(ctx, dir)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to read the backupmeta in %s", dir)
+		}
+		for _, name := range referenced {
+			delete(ssts, path.Join(dir, strings.TrimPrefix(name, "/")))
+		}
+	}
+	for name, size := range ssts {
+		resumable := false
+		for _, dir := range objects.checkpointDirs {
+			if isUnder(name, dir) {
+				resumable = true
+				break
+			}
+		}
+		if resumable {
+			report.Kept = append(report.Kept, name)
+			continue
+		}
+		if minAge > 0 {
+			modTime, err := storage.ModTime(ctx, s, name)
+			if err != nil {
+				return nil, errors.Annotatef(err, "failed to check the age of %s, specify --%s=0 to skip it", name, flagMinAge)
+			}
+			if now.Sub(modTime) < minAge {
+				report.Recent = append(report.Recent, name)
+				continue
+			}
+		}
+		report.Orphaned = append(report.Orphaned, name)
+		report.OrphanedSize += size
+	}
+	sort.Strings(report.Orphaned)
+	sort.Strings(report.Kept)
+	sort.Strings(report.Recent)
+	return report, nil
+}
+
+// RunCleanup deletes the orphaned SST files under the backup storage, or lists them only with --dry-run.
+func RunCleanup(c context.Context, cmdName string, cfg *CleanupConfig) (*CleanupReport, error) {
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	if err := cfg.ResolveStorageByName(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(cfg.Storage) == 0 {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, "--storage or --name is required to cleanup the backups")
+	}
+	if len(cfg.StorageFailover) > 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "--%s can't be used to cleanup the backups", flagStorageFailover)
+	}
+	_, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	report, err := FindOrphaned(ctx, s, func(ctx context.Context, dir string) ([]string, error) {
+		metaCfg := cfg.Config
+		if dir != "." {
+			var err error
+			if metaCfg.Storage, err = storage.ResolveURL(cfg.Storage, dir); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		_, metaStorage, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &metaCfg)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		referenced, err := metautil.NewMetaReader(backupMeta, metaStorage, &metaCfg.CipherInfo).ReferencedFiles(ctx)
+		return referenced, errors.Trace(err)
+	}, cfg.MinAge)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	summary.CollectInt("backups", len(report.Backups))
+	summary.CollectInt("orphaned files", len(report.Orphaned))
+	if len(report.Kept) > 0 {
+		log.Info("keep the files of the unfinished backups, which can be resumed by their checkpoints",
+			zap.Int("kept-count", len(report.Kept)))
+	}
+	if len(report.Recent) > 0 {
+		log.Info("keep the files modified recently, which may be written by a running backup",
+			zap.Int("kept-count", len(report.Recent)), zap.Duration("min-age", cfg.MinAge))
+	}
+	if !cfg.DryRun {
+		for _, name := range report.Orphaned {
+			if err = s.DeleteFile(ctx, name); err != nil {
+				return nil, errors.Trace(err)
+			}
+			report.Deleted = append(report.Deleted, name)
+		}
+		summary.CollectInt("deleted files", len(report.Deleted))
+	}
+	summary.SetSuccessStatus(true)
+	return report, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/backup"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestFindOrphaned(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, sub := range []string{"app-1/7/2022-06-01/0", "app-2"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, sub), 0o755))
+	}
+	s, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	referenced := map[string][]string{
+		".":     {"1.sst"},
+		"app-1": {"7/2022-06-01/0/1.sst"},
+	}
+	read := func(ctx context.Context, dir string) ([]string, error) {
+		return referenced[dir], nil
+	}
+
+	_, err = FindOrphaned(ctx, s, read, 0)
+	require.ErrorIs(t, err, berrors.ErrInvalidArgument)
+
+	for _, name := range []string{
+		metautil.MetaFile, "1.sst", "leaked.sst", "notes.txt",
+		"app-1/" + metautil.MetaFile, "app-1/7/2022-06-01/0/1.sst", "app-1/7/2022-06-01/0/2.sst",
+		// an unfinished backup, which can be resumed.
+		"app-2/" + backup.CheckpointFile + ".zst", "app-2/3.sst",
+	} {
+		require.NoError(t, s.WriteFile(ctx, name, []byte(name)))
+	}
+	report, err := FindOrphaned(ctx, s, read, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{".", "app-1"}, report.Backups)
+	require.Equal(t, []string{"app-1/7/2022-06-01/0/2.sst", "leaked.sst"}, report.Orphaned)
+	require.Equal(t, int64(len("app-1/7/2022-06-01/0/2.sst")+len("leaked.sst")), report.OrphanedSize)
+	require.Equal(t, []string{"app-2/3.sst"}, report.Kept)
+
+	// the files modified within the min age may be written by a running backup.
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "leaked.sst"), old, old))
+	report, err = FindOrphaned(ctx, s, read, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, []string{"leaked.sst"}, report.Orphaned)
+	require.Equal(t, int64(len("leaked.sst")), report.OrphanedSize)
+	require.Equal(t, []string{"app-1/7/2022-06-01/0/2.sst"}, report.Recent)
+
+	require.True(t, isUnder("a/b.sst", "."))
+	require.True(t, isUnder("a/b.sst", "a"))
+	require.False(t, isUnder("ab/c.sst", "a"))
+}
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -24,13 +25,16 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/tikv/migration/br/pkg/conn"
+	"github.com/tikv/migration/br/pkg/encryption"
 	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/feature"
 	"github.com/tikv/migration/br/pkg/glue"
 	"github.com/tikv/migration/br/pkg/metautil"
 	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/trace"
 	"github.com/tikv/migration/br/pkg/utils"
 	pd "github.com/tikv/pd/client"
+	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/keepalive"
 )
@@ -42,6 +46,10 @@ const (
 	flagNoCreds = "no-credentials"
 	// flagStorage is the name of storage flag.
 	flagStorage = "storage"
+	// flagStorageFailover are the storages failed over to on the sustained errors of the storage.
+	flagStorageFailover = "storage-failover"
+	// flagStorageFailoverPolicy decides the order the storages are used in.
+	flagStorageFailoverPolicy = "storage-failover-policy"
 	// flagPD is the name of PD url flag.
 	flagPD = "pd"
 
@@ -56,18 +64,48 @@ const (
 	flagGrpcKeepaliveTime = "grpc-keepalive-time"
 	// flagGrpcKeepaliveTimeout is the max time a grpc conn can keep idel before killed.
 	flagGrpcKeepaliveTimeout = "grpc-keepalive-timeout"
+	// flagGrpcMaxMsgSize is the max size of the gRPC messages between BR and TiKV.
+	flagGrpcMaxMsgSize = "grpc-max-msg-size"
+	// flagDNSRefreshInterval is the interval of re-resolving the addresses of the TiKV stores.
+	flagDNSRefreshInterval = "dns-refresh-interval"
+	// flagStoreAddrMapping is the file mapping the advertised addresses of the stores to reachable ones.
+	flagStoreAddrMapping = "store-addr-mapping"
+	// flagStoreProbeTimeout is the timeout of probing the addresses of the stores before the task.
+	flagStoreProbeTimeout = "store-probe-timeout"
+	// flagSpaceReserveRatio is the ratio of the free space required before the task to the bytes it writes.
+	flagSpaceReserveRatio = "space-reserve-ratio"
 	// flagEnableOpenTracing is whether to enable opentracing
 	flagEnableOpenTracing = "enable-opentracing"
 	flagSkipCheckPath     = "skip-check-path"
+	// flagOTLPEndpoint is the endpoint the spans are exported to by OTLP.
+	flagOTLPEndpoint    = "otlp-endpoint"
+	flagOTLPSampleRatio = "otlp-sample-ratio"
+	// flagOTLPHeaders are sent along with the spans, they're redacted in the log.
+	flagOTLPHeaders = "otlp-headers"
+	// flagConfig is the path of the config file whose settings can be reloaded at runtime.
+	flagConfig = "config"
+	// flagDecryptCommand is the command decrypting the encrypted credentials.
+	flagDecryptCommand = "decrypt-command"
+	// flagName is the name of the backup recorded in the catalog.
+	flagName = "name"
+	// flagCatalog is the storage URI of the catalog.
+	flagCatalog = "catalog"
+	// flagArtifactCompression is the compression of the files beside the backup, e.g. the catalog.
+	flagArtifactCompression = "artifact-compression"
 
 	defaultSwitchInterval       = 5 * time.Minute
 	defaultGRPCKeepaliveTime    = 10 * time.Second
 	defaultGRPCKeepaliveTimeout = 3 * time.Second
 	defaultChecksumConcurrency  = 512
+	defaultStoreProbeTimeout    = 3 * time.Second
+	defaultSpaceReserveRatio    = 1.2
+	pdEtcdDialTimeout           = 5 * time.Second
+	pdEtcdRequestTimeout        = 10 * time.Second
 
 	flagCipherType    = "crypter.method"
 	flagCipherKey     = "crypter.key"
 	flagCipherKeyFile = "crypter.key-file"
+	flagMasterKey     = "crypter.master-key"
 
 	unlimited           = 0
 	crypterAES128KeyLen = 16
@@ -78,7 +116,15 @@ const (
 // DefineCommonFlags defines the flags common to all BRIE commands.
 func DefineCommonFlags(flags *pflag.FlagSet) {
 	flags.BoolP(flagSendCreds, "c", true, "Whether send credentials to tikv")
-	flags.StringP(flagStorage, "s", "", `specify the path where backup storage, eg, "local:///home/backup_data"`)
+	flags.StringP(flagStorage, "s", "", `specify the path where backup storage, eg, "local:///home/backup_data". `+
+		`Backup expands the variables {cluster_id}, {date}, {backup_ts} and {name} in it, `+
+		`e.g. "s3://bucket/{cluster_id}/{date}/{backup_ts}", and records the expanded one in the catalog`)
+	flags.StringSlice(flagStorageFailover, nil,
+		"the storages failed over to on the sustained errors of --storage, e.g. the buckets in other regions. "+
+			"Backup records which storage each file is written to, restore reads the files from all of them")
+	flags.String(flagStorageFailoverPolicy, string(storage.FailoverPolicyPrimary),
+		"the order to use the storages in, be one of primary|nearest. primary uses --storage first and fails over to "+
+			"--storage-failover in order, nearest uses the storage of the lowest latency first")
 	flags.StringSliceP(flagPD, "u", []string{"127.0.0.1:2379"}, "PD address")
 	utils.DefineTLSFlags(flags)
 	flags.Uint(flagChecksumConcurrency, defaultChecksumConcurrency, "The concurrency of table checksumming")
@@ -107,14 +153,58 @@ func DefineCommonFlags(flags *pflag.FlagSet) {
 		"the max time a gRPC connection can keep idle before killed, must keep the same value with TiKV and PD")
 	_ = flags.MarkHidden(flagGrpcKeepaliveTime)
 	_ = flags.MarkHidden(flagGrpcKeepaliveTimeout)
+	flags.Int(flagGrpcMaxMsgSize, conn.DefaultGRPCMaxMsgSize,
+		"the max size in bytes of the gRPC messages between BR and TiKV, "+
+			"increase it if the backup or restore of huge keys or values fails with 'received message larger than max'")
+	flags.Duration(flagDNSRefreshInterval, 0,
+		"the interval of re-resolving the addresses of the TiKV stores, the connection to a store is re-established "+
+			"once its address is resolved to other IPs, 0 means never re-resolving them")
+	flags.String(flagStoreAddrMapping, "",
+		"a JSON file mapping the addresses advertised by the TiKV stores, or the store IDs, to the addresses "+
+			"reachable from BR, e.g. {\"tikv-0.tikv:20160\": \"10.0.0.1:30160\"}, when TiKV is behind NAT")
+	flags.Duration(flagStoreProbeTimeout, defaultStoreProbeTimeout,
+		"the timeout of probing the address of each TiKV store by TCP before the task, "+
+			"the task fails early if any store is unreachable, 0 means not probing them")
+	flags.Float64(flagSpaceReserveRatio, defaultSpaceReserveRatio,
+		"the free space required before the task, as the ratio to the bytes it writes. Restore checks the disk "+
+			"of each TiKV store against the bytes ingested into it, and backup checks the disk of a local:// storage "+
+			"seen by BR against the size of the backup range. The task fails early if any is short, 0 means not checking")
 
 	flags.Bool(flagEnableOpenTracing, false,
 		"Set whether to enable opentracing during the backup/restore process")
 	_ = flags.MarkHidden(flagEnableOpenTracing)
+	flags.String(flagOTLPEndpoint, "",
+		"the OTLP/HTTP endpoint of an OpenTelemetry collector or a tracing backend, e.g. http://localhost:4318, "+
+			"the spans of the backup/restore are exported to it in json once the task finishes")
+	flags.Float64(flagOTLPSampleRatio, 1,
+		"the ratio of the tasks whose spans are exported by OTLP, within [0, 1]")
+	flags.String(flagOTLPHeaders, "",
+		"the headers sent along with the spans exported by OTLP, e.g. \"authorization=Bearer xxx,x-tenant=br\"")
 	flags.BoolP(flagNoCreds, "", false, "Don't load credentials")
 	_ = flags.MarkHidden(flagNoCreds)
 	flags.BoolP(flagSkipCheckPath, "", false, "Skip path verification")
 	_ = flags.MarkHidden(flagSkipCheckPath)
+	flags.String(flagConfig, "",
+		"The TOML config file of the reloadable settings (log-level, ratelimit, concurrency, and ingest-ratelimit "+
+			"and paused of restore), "+
+			"reloaded on SIGHUP or by POST /reload of the status server. It may also hold the credentials "+
+			"(s3.access-key, s3.secret-access-key, azblob.account-key, sftp.password, crypter.key) not set by the flags, "+
+			"and the [[hooks]] run at the points of the task (pre-backup, post-meta-flush, post-restore-verify)")
+	flags.String(flagDecryptCommand, "",
+		"The command decrypting the credentials in the form of DECRYPT[base64 of ciphertext], in the config file "+
+			"or the flags. The ciphertext is piped into it and the plaintext is read from its output, "+
+			"e.g. \"age --decrypt -i key.txt\", whose arguments may be quoted. The ciphertext is of the tool "+
+			"the command runs, the values encrypted in place by SOPS (ENC[AES256_GCM,...]) are not supported")
+	flags.String(flagName, "",
+		"The name of the backup. Backup records the name in the catalog, the other commands reading a backup, "+
+			"e.g. restore, describe, cleanup and purge, find the backup by the name instead of --storage. "+
+			"The catalog keeps only the scheme, the host and the path of the storage, its options are given by the flags")
+	flags.String(flagCatalog, "",
+		`The storage where the catalog of backups is kept, eg, "s3://bucket/br-catalog", required by --name. `+
+			`The backups under it are recorded by their paths if --name isn't set, and listed by br list`)
+	flags.String(flagArtifactCompression, "none",
+		"The compression of the catalog created and the checkpoint of backup, be one of none|gzip|zstd. They're "+
+			"written with the suffix .gz or .zst, and read by their suffixes whichever the compression is")
 
 	flags.String(flagCipherType, "plaintext", "Encrypt/decrypt method, "+
 		"be one of plaintext|aes128-ctr|aes192-ctr|aes256-ctr case-insensitively, "+
@@ -123,9 +213,15 @@ func DefineCommonFlags(flags *pflag.FlagSet) {
 		"aes-crypter key, used to encrypt/decrypt the data "+
 			"by the hexadecimal string, eg: \"0123456789abcdef0123456789abcdef\"")
 	flags.String(flagCipherKeyFile, "", "FilePath, its content is used as the cipher-key")
+	flags.String(flagMasterKey, "",
+		"The master key wrapping the data key, instead of --crypter.key. Backup generates the data key and keeps it "+
+			"wrapped in the storage, restore unwraps it by the master key recorded in the backup if it's not specified. "+
+			"One of \"local:///path/to/hex.key\", \"aws-kms:///<key-id>?region=...\" or "+
+			"\"gcp-kms:///projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>\"")
 	_ = flags.MarkHidden(flagCipherType)
 	_ = flags.MarkHidden(flagCipherKey)
 	_ = flags.MarkHidden(flagCipherKeyFile)
+	_ = flags.MarkHidden(flagMasterKey)
 	storage.DefineFlags(flags)
 }
 
@@ -224,7 +320,28 @@ func NewMgr(ctx context.Context,
 	)
 }
 
-// GetStorage gets the storage backend from the config.
+// setupStoreConnectivity applies the address mapping to mgr, and probes the addresses of
+// the stores to report the unreachable ones before the task starts.
+func setupStoreConnectivity(ctx context.Context, mgr *conn.Mgr, cfg *Config) error {
+	if len(cfg.StoreAddrMapping) > 0 {
+		mapping, err := conn.LoadAddrMapping(cfg.StoreAddrMapping)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		mgr.SetAddrMapping(mapping)
+	}
+	if cfg.StoreProbeTimeout <= 0 {
+		return nil
+	}
+	_, err := mgr.ProbeStores(ctx, cfg.StoreProbeTimeout)
+	if err != nil && len(cfg.StoreAddrMapping) == 0 {
+		return errors.Annotatef(err, "specify the mapping by --%s", flagStoreAddrMapping)
+	}
+	return errors.Trace(err)
+}
+
+// GetStorage gets the storage backend from the config. With --storage-failover,
+// the storage reads the files from all the failover storages.
 func GetStorage(
 	ctx context.Context,
 	cfg *Config,
@@ -233,6 +350,13 @@ func GetStorage(
 	if err != nil {
 		return nil, nil, errors.Trace(err)
 	}
+	if len(cfg.StorageFailover) > 0 {
+		s, err := newFailoverStorage(ctx, cfg)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		return u, s, nil
+	}
 	s, err := storage.New(ctx, u, storageOpts(cfg))
 	if err != nil {
 		return nil, nil, errors.Annotate(err, "create storage failed")
@@ -240,10 +364,44 @@ func GetStorage(
 	return u, s, nil
 }
 
+// newFailoverStorage creates the storage over --storage and --storage-failover.
+func newFailoverStorage(ctx context.Context, cfg *Config) (*storage.FailoverStorage, error) {
+	policy, err := storage.ParseFailoverPolicy(cfg.StorageFailoverPolicy)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	endpoints, err := newStorageEndpoints(ctx, cfg, append([]string{cfg.Storage}, cfg.StorageFailover...))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s, err := storage.NewFailoverStorage(ctx, endpoints, policy, storage.DefaultFailoverErrorThreshold)
+	return s, errors.Trace(err)
+}
+
+// newStorageEndpoints creates the storage endpoints of the storage URLs.
+func newStorageEndpoints(ctx context.Context, cfg *Config, rawURLs []string) ([]storage.StorageEndpoint, error) {
+	endpoints := make([]storage.StorageEndpoint, 0, len(rawURLs))
+	for _, rawURL := range rawURLs {
+		u, err := storage.ParseBackend(rawURL, &cfg.BackendOptions)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		s, err := storage.New(ctx, u, storageOpts(cfg))
+		if err != nil {
+			backendURL := storage.FormatBackendURL(u)
+			return nil, errors.Annotatef(err, "create storage %s failed", backendURL.String())
+		}
+		endpoints = append(endpoints, storage.StorageEndpoint{Backend: u, Storage: s})
+	}
+	return endpoints, nil
+}
+
 func storageOpts(cfg *Config) *storage.ExternalStorageOptions {
 	return &storage.ExternalStorageOptions{
 		NoCredentials:   cfg.NoCreds,
 		SendCredentials: cfg.SendCreds,
+		GCSChunkSize:    cfg.BackendOptions.GCS.ChunkSize,
+		HDFS:            &cfg.BackendOptions.HDFS,
 	}
 }
 
@@ -257,6 +415,9 @@ func ReadBackupMeta(
 	if err != nil {
 		return nil, nil, nil, errors.Trace(err)
 	}
+	if err = cfg.loadDataKey(ctx, s); err != nil {
+		return nil, nil, nil, errors.Trace(err)
+	}
 	metaData, err := s.ReadFile(ctx, fileName)
 	if err != nil {
 		if gcsObjectNotFound(err) {
@@ -290,6 +451,9 @@ func ReadBackupMeta(
 	if err != nil {
 		return nil, nil, nil, errors.Annotate(err, "decrypt failed with wrong key")
 	}
+	if decryptBackupMeta, err = metautil.DecompressMeta(decryptBackupMeta); err != nil {
+		return nil, nil, nil, errors.Trace(err)
+	}
 
 	backupMeta := &backuppb.BackupMeta{}
 	if err = proto.Unmarshal(decryptBackupMeta, backupMeta); err != nil {
@@ -311,6 +475,38 @@ func flagToZapField(f *pflag.Flag) zap.Field {
 		hiddenQuery.RawQuery = ""
 		return zap.Stringer(f.Name, hiddenQuery)
 	}
+	if f.Name == flagMasterKey {
+		return zap.String(f.Name, encryption.RedactURL(f.Value.String()))
+	}
+	if f.Name == flagMergeStorage {
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			hidden := make([]string, 0, len(sv.GetSlice()))
+			for _, rawURL := range sv.GetSlice() {
+				u, err := url.Parse(rawURL)
+				if err != nil {
+					hidden = append(hidden, "<invalid URI>")
+					continue
+				}
+				// hide all query here as --storage.
+				u.RawQuery = ""
+				hidden = append(hidden, u.String())
+			}
+			return zap.Strings(f.Name, hidden)
+		}
+	}
+	if f.Name == flagOTLPHeaders {
+		// only the keys are logged, the values may be credentials.
+		headers, err := trace.ParseOTLPHeaders(f.Value.String())
+		if err != nil {
+			return zap.String(f.Name, "<invalid headers>")
+		}
+		keys := make([]string, 0, len(headers))
+		for key := range headers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return zap.Strings(f.Name, keys)
+	}
 	return zap.Stringer(f.Name, f.Value)
 }
 
@@ -325,6 +521,27 @@ func LogArguments(cmd *cobra.Command) {
 	log.Info("arguments", fields...)
 }
 
+// newPDEtcdClient returns a client of the etcd embedded in PD.
+func newPDEtcdClient(cfg *Config) (*clientv3.Client, error) {
+	var tlsConf *tls.Config
+	if cfg.TLS.IsEnabled() {
+		var err error
+		if tlsConf, err = cfg.TLS.ToTLSConfig(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	keepalive := GetKeepalive(cfg)
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:            cfg.PD,
+		TLS:                  tlsConf,
+		DialTimeout:          pdEtcdDialTimeout,
+		DialKeepAliveTime:    keepalive.Time,
+		DialKeepAliveTimeout: keepalive.Timeout,
+		Logger:               log.L(),
+	})
+	return cli, errors.Trace(err)
+}
+
 // GetKeepalive get the keepalive info from the config.
 func GetKeepalive(cfg *Config) keepalive.ClientParameters {
 	return keepalive.ClientParameters{
@@ -364,3 +581,10 @@ func CheckBackupAPIVersion(gate *feature.Gate, storageAPIVersion, dstAPIVersion
 	return storageAPIVersion == dstAPIVersion ||
 		(gate.IsEnabled(feature.APIVersionConversion) && dstAPIVersion == kvrpcpb.APIVersion_V2)
 }
+
+// CheckChecksumAPIVersion returns false if the checksum of a backup in backupAPIVersion can't be
+// verified against the storage, which is either the source of the backup or restored from it.
+func CheckChecksumAPIVersion(gate *feature.Gate, storageAPIVersion, backupAPIVersion kvrpcpb.APIVersion) bool {
+	return CheckBackupAPIVersion(gate, storageAPIVersion, backupAPIVersion) ||
+		CheckBackupAPIVersion(gate, backupAPIVersion, storageAPIVersion)
+}
@@ -38,6 +38,21 @@ func TestUrlNoQuery(t *testing.T) {
 	field := flagToZapField(flag)
 	require.Equal(t, flagStorage, field.Key)
 	require.Equal(t, "s3://some/what", field.Interface.(fmt.Stringer).String())
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringArray(flagMergeStorage, nil, "")
+	require.NoError(t, flags.Parse([]string{
+		"--merge-storage", "s3://a/b?secret=a123456789",
+		"--merge-storage", "local:///c",
+	}))
+	field = flagToZapField(flags.Lookup(flagMergeStorage))
+	require.Equal(t, flagMergeStorage, field.Key)
+	require.Equal(t, "[s3://a/b local:///c]", fmt.Sprint(field.Interface))
+
+	flags.String(flagOTLPHeaders, "", "")
+	require.NoError(t, flags.Parse([]string{"--otlp-headers", "x-tenant=br,authorization=Bearer secret"}))
+	field = flagToZapField(flags.Lookup(flagOTLPHeaders))
+	require.Equal(t, "[authorization x-tenant]", fmt.Sprint(field.Interface))
 }
 
 func TestStripingPDURL(t *testing.T) {
@@ -175,3 +190,17 @@ func TestCheckBackupAPIVersion(t *testing.T) {
 	require.Equal(t, CheckBackupAPIVersion(featureGate, kvrpcpb.APIVersion_V2, kvrpcpb.APIVersion_V1), false)
 	require.Equal(t, CheckBackupAPIVersion(featureGate, kvrpcpb.APIVersion_V2, kvrpcpb.APIVersion_V1TTL), false)
 }
+
+func TestCheckChecksumAPIVersion(t *testing.T) {
+	featureGate := feature.NewFeatureGate(semver.New("6.1.0"))
+	require.True(t, CheckChecksumAPIVersion(featureGate, kvrpcpb.APIVersion_V1, kvrpcpb.APIVersion_V1))
+	// the source of an apiv2 backup, or the apiv2 storage restored from an apiv1/v1ttl backup.
+	require.True(t, CheckChecksumAPIVersion(featureGate, kvrpcpb.APIVersion_V1TTL, kvrpcpb.APIVersion_V2))
+	require.True(t, CheckChecksumAPIVersion(featureGate, kvrpcpb.APIVersion_V2, kvrpcpb.APIVersion_V1))
+	require.True(t, CheckChecksumAPIVersion(featureGate, kvrpcpb.APIVersion_V2, kvrpcpb.APIVersion_V1TTL))
+	require.False(t, CheckChecksumAPIVersion(featureGate, kvrpcpb.APIVersion_V1, kvrpcpb.APIVersion_V1TTL))
+
+	featureGate = feature.NewFeatureGate(semver.New("6.0.0"))
+	require.True(t, CheckChecksumAPIVersion(featureGate, kvrpcpb.APIVersion_V2, kvrpcpb.APIVersion_V2))
+	require.False(t, CheckChecksumAPIVersion(featureGate, kvrpcpb.APIVersion_V2, kvrpcpb.APIVersion_V1))
+}
@@ -15,6 +15,7 @@
 package task
 
 import (
+	"context"
 	"time"
 
 	"github.com/pingcap/errors"
@@ -22,8 +23,10 @@ import (
 	"github.com/pingcap/kvproto/pkg/encryptionpb"
 	"github.com/pingcap/log"
 	"github.com/spf13/pflag"
+	"github.com/tikv/migration/br/pkg/conn"
 	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/trace"
 	"github.com/tikv/migration/br/pkg/utils"
 )
 
@@ -42,6 +45,11 @@ type Config struct {
 	// LogProgress is true means the progress bar is printed to the log instead of stdout.
 	LogProgress bool `json:"log-progress" toml:"log-progress"`
 
+	// StorageFailover are the storages failed over to on the sustained errors of Storage.
+	StorageFailover []string `json:"storage-failover" toml:"storage-failover"`
+	// StorageFailoverPolicy decides the order the storages are used in.
+	StorageFailoverPolicy string `json:"storage-failover-policy" toml:"storage-failover-policy"`
+
 	// CaseSensitive should not be used.
 	//
 	// Deprecated: This field is kept only to satisfy the cyclic dependency with TiDB. This field
@@ -54,6 +62,9 @@ type Config struct {
 	CheckRequirements bool `json:"check-requirements" toml:"check-requirements"`
 	// EnableOpenTracing is whether to enable opentracing
 	EnableOpenTracing bool `json:"enable-opentracing" toml:"enable-opentracing"`
+	// OTLP is the config of exporting the spans to a tracing backend, the spans are
+	// recorded if the endpoint is set even if EnableOpenTracing is false.
+	OTLP trace.OTLPConfig `json:"otlp" toml:"otlp"`
 	// SkipCheckPath skips verifying the path
 	// deprecated
 	SkipCheckPath bool `json:"skip-check-path" toml:"skip-check-path"`
@@ -64,11 +75,47 @@ type Config struct {
 	GRPCKeepaliveTime time.Duration `json:"grpc-keepalive-time" toml:"grpc-keepalive-time"`
 	// GrpcKeepaliveTimeout is the max time a grpc conn can keep idel before killed.
 	GRPCKeepaliveTimeout time.Duration `json:"grpc-keepalive-timeout" toml:"grpc-keepalive-timeout"`
+	// GRPCMaxMsgSize is the max size of the gRPC messages between BR and TiKV.
+	GRPCMaxMsgSize int `json:"grpc-max-msg-size" toml:"grpc-max-msg-size"`
+	// DNSRefreshInterval is the interval of re-resolving the addresses of the TiKV stores.
+	DNSRefreshInterval time.Duration `json:"dns-refresh-interval" toml:"dns-refresh-interval"`
+	// StoreAddrMapping is the file mapping the advertised addresses of the stores to reachable ones.
+	StoreAddrMapping string `json:"store-addr-mapping" toml:"store-addr-mapping"`
+	// StoreProbeTimeout is the timeout of probing the addresses of the stores, zero means not probing.
+	StoreProbeTimeout time.Duration `json:"store-probe-timeout" toml:"store-probe-timeout"`
+	// SpaceReserveRatio is the ratio of the free space required before the task to the bytes it writes,
+	// zero means not checking the space.
+	SpaceReserveRatio float64 `json:"space-reserve-ratio" toml:"space-reserve-ratio"`
 
 	CipherInfo backuppb.CipherInfo `json:"-" toml:"-"`
+	// MasterKey is the URL of the master key wrapping the data key of CipherInfo.
+	MasterKey string `json:"master-key" toml:"master-key"`
+	// wrappedDataKey is whether CipherInfo holds the data key of a backup wrapped by a master key.
+	wrappedDataKey bool
+
+	// ConfigFile is the path of the config file whose settings can be reloaded at runtime.
+	ConfigFile string `json:"config" toml:"config"`
+	// Hooks are the commands run at the points of the task lifecycle, loaded from ConfigFile.
+	Hooks []Hook `json:"hooks" toml:"hooks"`
+
+	// Name is the name of the backup in the catalog, which can be used instead of the storage URI.
+	Name string `json:"name" toml:"name"`
+	// Catalog is the storage URI of the catalog.
+	Catalog string `json:"catalog" toml:"catalog"`
+	// ArtifactCompression is the compression of the catalog created and the checkpoint of backup.
+	ArtifactCompression string `json:"artifact-compression" toml:"artifact-compression"`
+}
+
+// artifactCompression returns the compression type of ArtifactCompression, which defaults to none.
+func (cfg *Config) artifactCompression() (storage.CompressType, error) {
+	if len(cfg.ArtifactCompression) == 0 {
+		return storage.NoCompression, nil
+	}
+	tp, err := storage.ParseCompressType(cfg.ArtifactCompression)
+	return tp, errors.Annotatef(err, "invalid --%s", flagArtifactCompression)
 }
 
-func (cfg *Config) parseCipherInfo(flags *pflag.FlagSet) error {
+func (cfg *Config) parseCipherInfo(flags *pflag.FlagSet, secrets *secretConfig, decryptCommand string) error {
 	crypterStr, err := flags.GetString(flagCipherType)
 	if err != nil {
 		return errors.Trace(err)
@@ -79,19 +126,40 @@ func (cfg *Config) parseCipherInfo(flags *pflag.FlagSet) error {
 		return errors.Trace(err)
 	}
 
-	if cfg.CipherInfo.CipherType == encryptionpb.EncryptionMethod_PLAINTEXT {
-		return nil
+	cfg.MasterKey, err = flags.GetString(flagMasterKey)
+	if err != nil {
+		return errors.Trace(err)
 	}
-
 	key, err := flags.GetString(flagCipherKey)
 	if err != nil {
 		return errors.Trace(err)
 	}
-
 	keyFilePath, err := flags.GetString(flagCipherKeyFile)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if len(cfg.MasterKey) > 0 {
+		// the data key is generated by backup, or unwrapped from the storage by restore.
+		if len(key) > 0 || len(keyFilePath) > 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s conflicts with --%s and --%s", flagMasterKey, flagCipherKey, flagCipherKeyFile)
+		}
+		if cfg.CipherInfo.CipherType == encryptionpb.EncryptionMethod_PLAINTEXT {
+			cfg.CipherInfo.CipherType = encryptionpb.EncryptionMethod_AES256_CTR
+		}
+		return nil
+	}
+
+	if cfg.CipherInfo.CipherType == encryptionpb.EncryptionMethod_PLAINTEXT {
+		return nil
+	}
+	if len(key) == 0 && len(keyFilePath) == 0 {
+		key = secrets.Crypter.Key
+	}
+	key, err = decryptValue(context.Background(), decryptCommand, key)
+	if err != nil {
+		return errors.Trace(err)
+	}
 
 	cfg.CipherInfo.CipherKey, err = getCipherKeyContent(key, keyFilePath)
 	if err != nil {
@@ -122,6 +190,15 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 	if cfg.Storage, err = flags.GetString(flagStorage); err != nil {
 		return errors.Trace(err)
 	}
+	if cfg.StorageFailover, err = flags.GetStringSlice(flagStorageFailover); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.StorageFailoverPolicy, err = flags.GetString(flagStorageFailoverPolicy); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = storage.ParseFailoverPolicy(cfg.StorageFailoverPolicy); err != nil {
+		return errors.Trace(err)
+	}
 	if cfg.SendCreds, err = flags.GetBool(flagSendCreds); err != nil {
 		return errors.Trace(err)
 	}
@@ -165,18 +242,80 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	cfg.GRPCMaxMsgSize, err = flags.GetInt(flagGrpcMaxMsgSize)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.DNSRefreshInterval, err = flags.GetDuration(flagDNSRefreshInterval)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.StoreAddrMapping, err = flags.GetString(flagStoreAddrMapping); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.StoreProbeTimeout, err = flags.GetDuration(flagStoreProbeTimeout); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.SpaceReserveRatio, err = flags.GetFloat64(flagSpaceReserveRatio); err != nil {
+		return errors.Trace(err)
+	}
 	cfg.EnableOpenTracing, err = flags.GetBool(flagEnableOpenTracing)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if cfg.OTLP.Endpoint, err = flags.GetString(flagOTLPEndpoint); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.OTLP.SampleRatio, err = flags.GetFloat64(flagOTLPSampleRatio); err != nil {
+		return errors.Trace(err)
+	}
+	otlpHeaders, err := flags.GetString(flagOTLPHeaders)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.OTLP.Headers, err = trace.ParseOTLPHeaders(otlpHeaders); err != nil {
+		return errors.Trace(err)
+	}
 
 	if cfg.SwitchModeInterval <= 0 {
 		return errors.Annotatef(berrors.ErrInvalidArgument, "--switch-mode-interval must be positive, %s is not allowed", cfg.SwitchModeInterval)
 	}
+	if cfg.GRPCMaxMsgSize <= 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--grpc-max-msg-size must be positive, %d is not allowed", cfg.GRPCMaxMsgSize)
+	}
+	if cfg.DNSRefreshInterval < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--dns-refresh-interval must not be negative, %s is not allowed", cfg.DNSRefreshInterval)
+	}
+	if cfg.StoreProbeTimeout < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--store-probe-timeout must not be negative, %s is not allowed", cfg.StoreProbeTimeout)
+	}
+	if cfg.SpaceReserveRatio < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--space-reserve-ratio must not be negative, %v is not allowed", cfg.SpaceReserveRatio)
+	}
+	if cfg.OTLP.SampleRatio < 0 || cfg.OTLP.SampleRatio > 1 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--otlp-sample-ratio must be within [0, 1], %v is not allowed", cfg.OTLP.SampleRatio)
+	}
 
 	if err = cfg.BackendOptions.ParseFromFlags(flags); err != nil {
 		return errors.Trace(err)
 	}
+	if cfg.ConfigFile, err = flags.GetString(flagConfig); err != nil {
+		return errors.Trace(err)
+	}
+	decryptCommand, err := flags.GetString(flagDecryptCommand)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	secrets, err := loadSecretConfig(cfg.ConfigFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.applySecrets(context.Background(), secrets, decryptCommand); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Hooks, err = loadHooks(cfg.ConfigFile); err != nil {
+		return errors.Trace(err)
+	}
 	if err = cfg.TLS.ParseFromFlags(flags); err != nil {
 		return errors.Trace(err)
 	}
@@ -187,6 +326,18 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 	if len(cfg.PD) == 0 {
 		return errors.Annotate(berrors.ErrInvalidArgument, "must provide at least one PD server address")
 	}
+	if cfg.Name, err = flags.GetString(flagName); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Catalog, err = flags.GetString(flagCatalog); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ArtifactCompression, err = flags.GetString(flagArtifactCompression); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = cfg.artifactCompression(); err != nil {
+		return errors.Trace(err)
+	}
 	if cfg.SkipCheckPath, err = flags.GetBool(flagSkipCheckPath); err != nil {
 		return errors.Trace(err)
 	}
@@ -194,7 +345,7 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 		log.L().Info("--skip-check-path is deprecated, need explicitly set it anymore")
 	}
 
-	if err = cfg.parseCipherInfo(flags); err != nil {
+	if err = cfg.parseCipherInfo(flags, secrets, decryptCommand); err != nil {
 		return errors.Trace(err)
 	}
 
@@ -203,6 +354,11 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 
 // adjust adjusts the abnormal config value in the current config.
 // useful when not starting BR from CLI (e.g. from BRIE in SQL).
+// TraceOutputs returns where the spans of the task go.
+func (cfg *Config) TraceOutputs() trace.Outputs {
+	return trace.Outputs{File: cfg.EnableOpenTracing, OTLP: cfg.OTLP}
+}
+
 func (cfg *Config) adjust() {
 	if cfg.GRPCKeepaliveTime == 0 {
 		cfg.GRPCKeepaliveTime = defaultGRPCKeepaliveTime
@@ -210,6 +366,9 @@ func (cfg *Config) adjust() {
 	if cfg.GRPCKeepaliveTimeout == 0 {
 		cfg.GRPCKeepaliveTimeout = defaultGRPCKeepaliveTimeout
 	}
+	if cfg.GRPCMaxMsgSize == 0 {
+		cfg.GRPCMaxMsgSize = conn.DefaultGRPCMaxMsgSize
+	}
 	if cfg.ChecksumConcurrency == 0 {
 		cfg.ChecksumConcurrency = defaultChecksumConcurrency
 	}
@@ -0,0 +1,244 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/relay"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/summary"
+	"go.uber.org/zap"
+)
+
+const (
+	// flagDstPD is the PD of the cluster the data is copied into.
+	flagDstPD = "dst-pd"
+	// flagRelayAddr is the address the relay listens on.
+	flagRelayAddr = "relay-addr"
+	// flagRelayAdvertiseAddr is the address of the relay reachable from the stores of both clusters.
+	flagRelayAdvertiseAddr = "relay-advertise-addr"
+	// flagRelayDir is the directory the relay spools the files in.
+	flagRelayDir = "relay-dir"
+
+	// relayBucket is the bucket of the relay storage.
+	relayBucket = "relay"
+	// relayShutdownTimeout is the time waiting for the requests to the relay before it's closed.
+	relayShutdownTimeout = 10 * time.Second
+)
+
+// CopyRawConfig is the configuration specific for `br copy raw`.
+type CopyRawConfig struct {
+	RawKvConfig
+
+	// DstPD is the PD of the cluster restored into, which is connected with the same TLS config.
+	DstPD []string `json:"dst-pd" toml:"dst-pd"`
+	// RelayAddr is the address the relay listens on, RelayAdvertiseAddr is the address the stores
+	// of both clusters access the relay by, which defaults to RelayAddr.
+	RelayAddr          string `json:"relay-addr" toml:"relay-addr"`
+	RelayAdvertiseAddr string `json:"relay-advertise-addr" toml:"relay-advertise-addr"`
+	// RelayDir is the directory the relay spools the backup in, which defaults to the temp dir.
+	RelayDir string `json:"relay-dir" toml:"relay-dir"`
+}
+
+// DefineCopyRawFlags defines the flags of `br copy raw`, which are the flags of the raw backup
+// besides the ones of the destination cluster and the relay.
+func DefineCopyRawFlags(command *cobra.Command) {
+	DefineRawBackupFlags(command)
+	command.Flags().StringSlice(flagDstPD, nil,
+		"The PD address of the cluster the data is copied into, which is accessed with the same TLS config as --pd.")
+	command.Flags().String(flagRelayAddr, "",
+		"The address the relay the data passes through listens on, e.g. 0.0.0.0:8288. "+
+			"The TiKV stores of both clusters must be able to access it.")
+	command.Flags().String(flagRelayAdvertiseAddr, "",
+		"The address the TiKV stores access the relay by, it defaults to --relay-addr.")
+	command.Flags().String(flagRelayDir, "",
+		"The directory the relay spools the backup files in, which must hold the whole backup. "+
+			"It defaults to the temp dir, and the files are removed after the copy.")
+}
+
+// ParseFromFlags parses the config from the flag set.
+func (cfg *CopyRawConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	if err := cfg.RawKvConfig.ParseBackupConfigFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	// the storage is the relay, and the backup in it is removed after the copy.
+	for _, name := range []string{
+		flagStorage, flagStorageFailover, flagStorageMirror, flagParentStorage,
+		flagResume, flagSetupLifecycle, flagEstimateCompression, flagDryRun, flagName, flagBackupPoints, flagClusters,
+	} {
+		if flags.Changed(name) {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "--%s can't be used by the copy", name)
+		}
+	}
+	var err error
+	if cfg.DstPD, err = flags.GetStringSlice(flagDstPD); err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.DstPD) == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s is required by the copy", flagDstPD)
+	}
+	for i := range cfg.DstPD {
+		if cfg.DstPD[i], err = normalizePDURL(cfg.DstPD[i], cfg.TLS.IsEnabled()); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if cfg.RelayAddr, err = flags.GetString(flagRelayAddr); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.RelayAdvertiseAddr, err = flags.GetString(flagRelayAdvertiseAddr); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.RelayDir, err = flags.GetString(flagRelayDir); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cfg.checkRelayAddr())
+}
+
+// checkRelayAddr checks the relay can be accessed by the advertised address.
+func (cfg *CopyRawConfig) checkRelayAddr() error {
+	if len(cfg.RelayAddr) == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s is required by the copy", flagRelayAddr)
+	}
+	if len(cfg.RelayAdvertiseAddr) == 0 {
+		cfg.RelayAdvertiseAddr = cfg.RelayAddr
+	}
+	host, port, err := net.SplitHostPort(cfg.RelayAdvertiseAddr)
+	if err != nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid relay address '%s': %v", cfg.RelayAdvertiseAddr, err)
+	}
+	if ip := net.ParseIP(host); len(host) == 0 || (ip != nil && ip.IsUnspecified()) || port == "0" {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"the TiKV stores can't access the relay by '%s', please specify --%s", cfg.RelayAdvertiseAddr, flagRelayAdvertiseAddr)
+	}
+	return nil
+}
+
+// relayConfig returns the config accessing the backup in the relay with the access key.
+func relayConfig(cfg Config, advertiseAddr string, accessKey string) Config {
+	cfg.Storage = "s3://" + relayBucket + "/copy"
+	cfg.BackendOptions = storage.BackendOptions{S3: storage.S3BackendOptions{
+		Endpoint:        "http://" + advertiseAddr,
+		AccessKey:       accessKey,
+		SecretAccessKey: accessKey,
+		ForcePathStyle:  true,
+	}}
+	cfg.SendCreds = true
+	cfg.NoCreds = true
+	cfg.StorageFailover = nil
+	cfg.Name = ""
+	cfg.Catalog = ""
+	return cfg
+}
+
+// restoreConfig returns the config restoring the whole backup into the destination cluster.
+func (cfg *CopyRawConfig) restoreConfig(relayCfg Config) *RestoreRawConfig {
+	restoreCfg := &RestoreRawConfig{CreateKeyspaces: true}
+	restoreCfg.Config = relayCfg
+	restoreCfg.PD = cfg.DstPD
+	// the concurrency and the rate limit of the backup are not for the restore.
+	restoreCfg.Concurrency = 0
+	restoreCfg.RateLimit = 0
+	// the range and the keyspace are left empty, the whole backup is restored into the
+	// keyspace the backup is scoped to.
+	return restoreCfg
+}
+
+func newRelayAccessKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", errors.Trace(err)
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// RunCopyRaw copies the raw kv range of the source cluster into the destination cluster.
+// The source TiKV stores back up the range into the relay served by BR, from which the
+// importers of the destination cluster download the files, so the backup is never staged
+// in an external storage. The backup passes the relay before the restore starts, so the
+// disk of --relay-dir must hold it.
+func RunCopyRaw(c context.Context, g glue.Glue, cmdName string, cfg *CopyRawConfig) error {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	spoolDir, err := os.MkdirTemp(cfg.RelayDir, "br-relay-")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(spoolDir); err != nil {
+			log.Warn("failed to remove the relay directory", zap.String("dir", spoolDir), zap.Error(err))
+		}
+	}()
+	accessKey, err := newRelayAccessKey()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	relayServer, err := relay.NewServer(spoolDir, accessKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	listener, err := net.Listen("tcp", cfg.RelayAddr)
+	if err != nil {
+		return errors.Annotatef(err, "failed to listen on the relay address %s", cfg.RelayAddr)
+	}
+	httpServer := &http.Server{Handler: relayServer}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Warn("the relay stopped serving", zap.Error(err))
+		}
+	}()
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), relayShutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Warn("failed to shut down the relay", zap.Error(err))
+		}
+	}()
+	log.Info("the relay is serving", zap.String("addr", listener.Addr().String()),
+		zap.String("advertise-addr", cfg.RelayAdvertiseAddr), zap.String("dir", spoolDir))
+
+	relayCfg := relayConfig(cfg.Config, cfg.RelayAdvertiseAddr, accessKey)
+	// the restore config is taken before the backup adjusts the config, e.g. the range and the data key.
+	restoreCfg := cfg.restoreConfig(relayCfg)
+	backupCfg := cfg.RawKvConfig
+	backupCfg.Config = relayCfg
+
+	summary.SetUnit(summary.BackupUnit)
+	if err = RunBackupRaw(ctx, g, cmdName+" backup", &backupCfg); err != nil {
+		return errors.Annotate(err, "failed to back up the source cluster into the relay")
+	}
+	summary.SetUnit(summary.RestoreUnit)
+	if err = RunRestoreRaw(ctx, g, cmdName+" restore", restoreCfg); err != nil {
+		return errors.Annotate(err, "failed to restore the destination cluster from the relay")
+	}
+	log.Info("the copy is finished",
+		zap.String("relay-received", units.HumanSize(float64(relayServer.Received()))),
+		zap.String("relay-sent", units.HumanSize(float64(relayServer.Sent()))))
+	return nil
+}
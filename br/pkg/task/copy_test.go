@@ -0,0 +1,87 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestCheckRelayAddr(t *testing.T) {
+	cfg := &CopyRawConfig{RelayAddr: "10.0.1.5:8288"}
+	require.NoError(t, cfg.checkRelayAddr())
+	require.Equal(t, "10.0.1.5:8288", cfg.RelayAdvertiseAddr)
+
+	cfg = &CopyRawConfig{RelayAddr: "0.0.0.0:8288", RelayAdvertiseAddr: "br.example.com:8288"}
+	require.NoError(t, cfg.checkRelayAddr())
+
+	for _, c := range []CopyRawConfig{
+		{},
+		{RelayAddr: "0.0.0.0:8288"},
+		{RelayAddr: ":8288"},
+		{RelayAddr: "10.0.1.5:0"},
+		{RelayAddr: "10.0.1.5"},
+	} {
+		err := c.checkRelayAddr()
+		require.True(t, berrors.Is(err, berrors.ErrInvalidArgument), c.RelayAddr)
+	}
+}
+
+func TestCopyRestoreConfig(t *testing.T) {
+	keyspaceID := uint32(3)
+	cfg := &CopyRawConfig{
+		RawKvConfig: RawKvConfig{
+			Config: Config{
+				PD:              []string{"http://src-pd:2379"},
+				Storage:         "local:///ignored",
+				StorageFailover: []string{"local:///failover"},
+				Concurrency:     4,
+				RateLimit:       100,
+				Checksum:        true,
+				MasterKey:       "local:///master.key",
+			},
+			StartKey:   []byte("a"),
+			EndKey:     []byte("b"),
+			KeyspaceID: &keyspaceID,
+		},
+		DstPD:              []string{"http://dst-pd:2379"},
+		RelayAdvertiseAddr: "10.0.1.5:8288",
+	}
+	relayCfg := relayConfig(cfg.Config, cfg.RelayAdvertiseAddr, "key")
+	require.Equal(t, "s3://relay/copy", relayCfg.Storage)
+	require.Empty(t, relayCfg.StorageFailover)
+	require.True(t, relayCfg.SendCreds)
+	require.Equal(t, storage.S3BackendOptions{
+		Endpoint: "http://10.0.1.5:8288", AccessKey: "key", SecretAccessKey: "key", ForcePathStyle: true,
+	}, relayCfg.S3)
+	require.Equal(t, []string{"http://src-pd:2379"}, relayCfg.PD)
+
+	// the whole backup is restored into the destination cluster.
+	restoreCfg := cfg.restoreConfig(relayCfg)
+	require.Equal(t, []string{"http://dst-pd:2379"}, restoreCfg.PD)
+	require.Equal(t, "s3://relay/copy", restoreCfg.Storage)
+	require.Equal(t, "local:///master.key", restoreCfg.MasterKey)
+	require.True(t, restoreCfg.Checksum)
+	require.True(t, restoreCfg.CreateKeyspaces)
+	require.Zero(t, restoreCfg.Concurrency)
+	require.Zero(t, restoreCfg.RateLimit)
+	require.Empty(t, restoreCfg.StartKey)
+	require.Nil(t, restoreCfg.KeyspaceID)
+	// the source config is not changed.
+	require.Equal(t, []string{"http://src-pd:2379"}, cfg.PD)
+}
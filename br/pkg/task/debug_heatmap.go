@@ -0,0 +1,223 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	flagReport = "report"
+
+	// the size of the bar of a bucket in the PNG report.
+	heatmapColumnWidth = 4
+	heatmapHeight      = 200
+)
+
+// DebugHeatmapConfig is the configuration of `br debug heatmap`.
+type DebugHeatmapConfig struct {
+	Config
+
+	// Format is the format of the keys in the report.
+	Format string `json:"format" toml:"format"`
+	// Report is the path of the report, which is a PNG image if it ends with ".png", or an HTML page.
+	Report string `json:"report" toml:"report"`
+}
+
+// DefineDebugHeatmapFlags defines the flags of `br debug heatmap`.
+func DefineDebugHeatmapFlags(flags *pflag.FlagSet) {
+	flags.String(flagKeyFormat, "hex", "the format of the keys in the report, support raw|escaped|hex")
+	flags.String(flagReport, "heatmap.html", "the path of the report, a PNG image if it ends with .png, or an HTML page")
+}
+
+// ParseFromFlags parses the heatmap debug flags from the flag set.
+func (cfg *DebugHeatmapConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.Format, err = flags.GetString(flagKeyFormat); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = utils.FormatKey(cfg.Format, nil); err != nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s '%s'", flagKeyFormat, cfg.Format)
+	}
+	if cfg.Report, err = flags.GetString(flagReport); err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.Report) == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s is required", flagReport)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// RunDebugHeatmap renders the heatmap recorded aside the backupmeta of the backup at --storage
+// into the report.
+func RunDebugHeatmap(ctx context.Context, cfg *DebugHeatmapConfig) error {
+	_, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	h, err := metautil.ReadHeatmap(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if h == nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"the backup %s has no heatmap, it's taken by older BR or with --%s=0", s.URI(), flagHeatmapBuckets)
+	}
+	f, err := os.Create(cfg.Report)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if strings.EqualFold(filepath.Ext(cfg.Report), ".png") {
+		err = renderHeatmapPNG(f, h)
+	} else {
+		err = renderHeatmapHTML(f, h, cfg.Format, s.URI())
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Annotatef(err, "failed to write the heatmap report %s", cfg.Report)
+	}
+	log.Info("heatmap report written", zap.String("report", cfg.Report), zap.Int("buckets", len(h.Buckets)))
+	return nil
+}
+
+// heatColor returns the color of the bucket of the bytes, from light yellow for the cold
+// buckets to red for the hottest one.
+func heatColor(bytes, maxBytes uint64) color.RGBA {
+	ratio := 0.0
+	if maxBytes > 0 {
+		ratio = float64(bytes) / float64(maxBytes)
+	}
+	return color.RGBA{R: 255, G: uint8(240 - 200*ratio), B: uint8(160 - 160*ratio), A: 255}
+}
+
+// renderHeatmapPNG draws a bar per bucket in the order of the keys, whose height and color
+// are of the bytes of the bucket.
+func renderHeatmapPNG(w io.Writer, h *metautil.Heatmap) error {
+	width := len(h.Buckets) * heatmapColumnWidth
+	if width == 0 {
+		width = heatmapColumnWidth
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, heatmapHeight))
+	for x := 0; x < width; x++ {
+		for y := 0; y < heatmapHeight; y++ {
+			img.SetRGBA(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+		}
+	}
+	maxBytes := h.MaxBytes()
+	for i, b := range h.Buckets {
+		c := heatColor(b.TotalBytes, maxBytes)
+		barHeight := heatmapHeight
+		if maxBytes > 0 {
+			barHeight = int(float64(heatmapHeight) * float64(b.TotalBytes) / float64(maxBytes))
+		}
+		for x := i * heatmapColumnWidth; x < (i+1)*heatmapColumnWidth; x++ {
+			for y := heatmapHeight - barHeight; y < heatmapHeight; y++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+	return errors.Trace(png.Encode(w, img))
+}
+
+var heatmapTemplate = template.Must(template.New("heatmap").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Backup heatmap</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 8px; text-align: left; font-size: 12px; }
+td.key { font-family: monospace; }
+.bar { height: 12px; }
+</style>
+</head>
+<body>
+<h2>{{.Storage}}</h2>
+<p>{{len .Buckets}} buckets, {{.TotalKvs}} kvs, {{.TotalBytes}}</p>
+<table>
+<tr><th>#</th><th>start key</th><th>end key</th><th>ranges</th><th>kvs</th><th>bytes</th><th></th></tr>
+{{range $i, $b := .Buckets}}<tr>
+<td>{{$i}}</td><td class="key">{{$b.StartKey}}</td><td class="key">{{$b.EndKey}}</td>
+<td>{{$b.Ranges}}</td><td>{{$b.TotalKvs}}</td><td>{{$b.Bytes}}</td>
+<td><div class="bar" style="width: {{$b.Width}}px; background: {{$b.Color}}"></div></td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type heatmapRow struct {
+	StartKey string
+	EndKey   string
+	Ranges   int
+	TotalKvs uint64
+	Bytes    string
+	Width    int
+	Color    template.CSS
+}
+
+// renderHeatmapHTML writes a table of the buckets with a bar of the bytes of each.
+func renderHeatmapHTML(w io.Writer, h *metautil.Heatmap, format, storageURI string) error {
+	maxBytes := h.MaxBytes()
+	var totalKvs, totalBytes uint64
+	rows := make([]heatmapRow, 0, len(h.Buckets))
+	for _, b := range h.Buckets {
+		start, _ := utils.FormatKey(format, b.StartKey)
+		end, _ := utils.FormatKey(format, b.EndKey)
+		c := heatColor(b.TotalBytes, maxBytes)
+		row := heatmapRow{
+			StartKey: start,
+			EndKey:   end,
+			Ranges:   b.Ranges,
+			TotalKvs: b.TotalKvs,
+			Bytes:    units.HumanSize(float64(b.TotalBytes)),
+			Width:    heatmapHeight,
+			Color:    template.CSS(fmt.Sprintf("rgb(%d, %d, %d)", c.R, c.G, c.B)),
+		}
+		if maxBytes > 0 {
+			row.Width = int(float64(heatmapHeight) * float64(b.TotalBytes) / float64(maxBytes))
+		}
+		rows = append(rows, row)
+		totalKvs += b.TotalKvs
+		totalBytes += b.TotalBytes
+	}
+	return errors.Trace(heatmapTemplate.Execute(w, map[string]interface{}{
+		"Storage":    storageURI,
+		"Buckets":    rows,
+		"TotalKvs":   totalKvs,
+		"TotalBytes": units.HumanSize(float64(totalBytes)),
+	}))
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestRenderHeatmap(t *testing.T) {
+	h := &metautil.Heatmap{Buckets: []metautil.HeatmapBucket{
+		{StartKey: []byte("a"), EndKey: []byte("b<"), Ranges: 2, TotalKvs: 10, TotalBytes: 1000},
+		{StartKey: []byte("b<"), EndKey: []byte("c"), Ranges: 1, TotalKvs: 5, TotalBytes: 250},
+	}}
+	var buf bytes.Buffer
+	require.NoError(t, renderHeatmapHTML(&buf, h, "raw", "local:///backup"))
+	html := buf.String()
+	require.Contains(t, html, "b&lt;")
+	require.Contains(t, html, "width: 200px; background: rgb(255, 40, 0)")
+	require.Contains(t, html, "width: 50px")
+
+	buf.Reset()
+	require.NoError(t, renderHeatmapPNG(&buf, h))
+	img, err := png.Decode(&buf)
+	require.NoError(t, err)
+	require.Equal(t, 2*heatmapColumnWidth, img.Bounds().Dx())
+	// the bar of the second bucket is a quarter of the height.
+	_, _, _, a := img.At(heatmapColumnWidth, heatmapHeight-1).RGBA()
+	require.NotZero(t, a)
+	r, g, b, _ := img.At(heatmapColumnWidth, 0).RGBA()
+	require.Equal(t, [3]uint32{0xffff, 0xffff, 0xffff}, [3]uint32{r, g, b})
+}
+
+func TestRunDebugHeatmap(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cfg := &DebugHeatmapConfig{Config: Config{Storage: "local://" + dir}, Format: "hex",
+		Report: filepath.Join(t.TempDir(), "heatmap.png")}
+	require.Error(t, RunDebugHeatmap(ctx, cfg))
+
+	s, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	h := metautil.NewHeatmap([]metautil.HeatmapBucket{{StartKey: []byte("a"), EndKey: []byte("b"), Ranges: 1}}, 8)
+	require.NoError(t, metautil.WriteHeatmap(ctx, s, h))
+	require.NoError(t, RunDebugHeatmap(ctx, cfg))
+	require.FileExists(t, cfg.Report)
+}
@@ -0,0 +1,218 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/spf13/pflag"
+	"github.com/tikv/client-go/v2/config"
+	"github.com/tikv/client-go/v2/rawkv"
+	"github.com/tikv/migration/br/pkg/conn"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+const (
+	flagAPIVersion = "api-version"
+	flagLimit      = "limit"
+
+	defaultDebugScanLimit = 100
+)
+
+// RawKVClient is the subset of the rawkv client used by the rawkv debug commands.
+type RawKVClient interface {
+	Get(ctx context.Context, key []byte, options ...rawkv.RawOption) ([]byte, error)
+	Put(ctx context.Context, key, value []byte, options ...rawkv.RawOption) error
+	Scan(ctx context.Context, startKey, endKey []byte, limit int, options ...rawkv.RawOption) (keys [][]byte, values [][]byte, err error)
+	DeleteRange(ctx context.Context, startKey []byte, endKey []byte, options ...rawkv.RawOption) error
+	Close() error
+}
+
+// DebugRawKVConfig is the configuration of `br debug rawkv`, which reads and writes the
+// raw keys of a cluster to spot-check the data during migration.
+type DebugRawKVConfig struct {
+	Config
+
+	// APIVersion is the api version of the cluster, it's detected from TiKV if empty.
+	APIVersion string `json:"api-version" toml:"api-version"`
+	// Format is the format of the keys and values in the arguments and the output.
+	Format string `json:"format" toml:"format"`
+	// Limit is the max number of the keys printed by scan.
+	Limit int `json:"limit" toml:"limit"`
+}
+
+// DefineDebugRawKVFlags defines the flags of the rawkv debug commands.
+func DefineDebugRawKVFlags(flags *pflag.FlagSet) {
+	flags.String(flagKeyFormat, "hex", "the format of the keys and values, support raw|escaped|hex")
+	flags.String(flagAPIVersion, "", "the api version of the cluster, V1|V1TTL|V2, detected from TiKV if not set")
+	flags.Int(flagLimit, defaultDebugScanLimit, "the max number of the keys printed by scan")
+}
+
+// ParseFromFlags parses the rawkv debug flags from the flag set.
+func (cfg *DebugRawKVConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.Format, err = flags.GetString(flagKeyFormat); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.APIVersion, err = flags.GetString(flagAPIVersion); err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.APIVersion) > 0 {
+		if _, ok := kvrpcpb.APIVersion_value[cfg.APIVersion]; !ok {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "invalid api version '%s'", cfg.APIVersion)
+		}
+	}
+	if cfg.Limit, err = flags.GetInt(flagLimit); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Limit <= 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must be positive", flagLimit)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// NewRawKVDebugger connects the cluster by a rawkv client with the api version and the
+// TLS settings of the config.
+func NewRawKVDebugger(ctx context.Context, g glue.Glue, cfg *DebugRawKVConfig, out io.Writer) (*RawKVDebugger, error) {
+	apiVersion := kvrpcpb.APIVersion(kvrpcpb.APIVersion_value[cfg.APIVersion])
+	if len(cfg.APIVersion) == 0 {
+		mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		apiVersion, err = conn.GetTiKVApiVersion(ctx, mgr.GetPDClient(), mgr.GetTLSConfig())
+		mgr.Close()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	security := config.Security{}
+	if cfg.TLS.IsEnabled() {
+		security = config.NewSecurity(cfg.TLS.CA, cfg.TLS.Cert, cfg.TLS.Key, []string{})
+	}
+	client, err := rawkv.NewClientWithOpts(ctx, cfg.PD, rawkv.WithAPIVersion(apiVersion), rawkv.WithSecurity(security))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return NewRawKVDebuggerWithClient(client, cfg.Format, out), nil
+}
+
+// RawKVDebugger runs the rawkv debug commands, the keys and values are in the user format,
+// i.e. without the API V2 prefix.
+type RawKVDebugger struct {
+	client RawKVClient
+	format string
+	out    io.Writer
+}
+
+// NewRawKVDebuggerWithClient creates a RawKVDebugger with the given client.
+func NewRawKVDebuggerWithClient(client RawKVClient, format string, out io.Writer) *RawKVDebugger {
+	return &RawKVDebugger{client: client, format: format, out: out}
+}
+
+func (d *RawKVDebugger) parse(keys ...string) ([][]byte, error) {
+	parsed := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		k, err := utils.ParseKey(d.format, key)
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to parse '%s' in format %s: %v", key, d.format, err)
+		}
+		parsed = append(parsed, k)
+	}
+	return parsed, nil
+}
+
+func (d *RawKVDebugger) print(values ...[]byte) error {
+	for i, value := range values {
+		formatted, err := utils.FormatKey(d.format, value)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if i > 0 {
+			fmt.Fprint(d.out, "\t")
+		}
+		fmt.Fprint(d.out, formatted)
+	}
+	fmt.Fprintln(d.out)
+	return nil
+}
+
+// Get prints the value of the key.
+func (d *RawKVDebugger) Get(ctx context.Context, key string) error {
+	keys, err := d.parse(key)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	value, err := d.client.Get(ctx, keys[0])
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if value == nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "key '%s' not found", key)
+	}
+	return d.print(value)
+}
+
+// Put writes the key and the value.
+func (d *RawKVDebugger) Put(ctx context.Context, key, value string) error {
+	kv, err := d.parse(key, value)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(d.client.Put(ctx, kv[0], kv[1]))
+}
+
+// Scan prints at most limit keys and values in [startKey, endKey), one pair per line
+// separated by a tab. An empty endKey is the end of the key space.
+func (d *RawKVDebugger) Scan(ctx context.Context, startKey, endKey string, limit int) error {
+	keys, err := d.parse(startKey, endKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	scannedKeys, values, err := d.client.Scan(ctx, keys[0], keys[1], limit)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for i := range scannedKeys {
+		if err = d.print(scannedKeys[i], values[i]); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// DeleteRange deletes the keys in [startKey, endKey). The end key is required to avoid
+// deleting the whole key space by mistake.
+func (d *RawKVDebugger) DeleteRange(ctx context.Context, startKey, endKey string) error {
+	keys, err := d.parse(startKey, endKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(keys[1]) == 0 || utils.CompareEndKey(keys[0], keys[1]) >= 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"invalid range ['%s', '%s'), the end key must be greater than the start key", startKey, endKey)
+	}
+	return errors.Trace(d.client.DeleteRange(ctx, keys[0], keys[1]))
+}
+
+// Close closes the underlying client.
+func (d *RawKVDebugger) Close() error {
+	return d.client.Close()
+}
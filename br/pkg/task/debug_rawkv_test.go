@@ -0,0 +1,111 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package task
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/rawkv"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+type fakeRawKVClient struct {
+	kvs map[string][]byte
+}
+
+func (c *fakeRawKVClient) Get(_ context.Context, key []byte, _ ...rawkv.RawOption) ([]byte, error) {
+	return c.kvs[string(key)], nil
+}
+
+func (c *fakeRawKVClient) Put(_ context.Context, key, value []byte, _ ...rawkv.RawOption) error {
+	c.kvs[string(key)] = value
+	return nil
+}
+
+func (c *fakeRawKVClient) inRange(key string, startKey, endKey []byte) bool {
+	return key >= string(startKey) && (len(endKey) == 0 || key < string(endKey))
+}
+
+func (c *fakeRawKVClient) Scan(_ context.Context, startKey, endKey []byte, limit int, _ ...rawkv.RawOption) ([][]byte, [][]byte, error) {
+	sorted := make([]string, 0, len(c.kvs))
+	for key := range c.kvs {
+		if c.inRange(key, startKey, endKey) {
+			sorted = append(sorted, key)
+		}
+	}
+	sort.Strings(sorted)
+	var keys, values [][]byte
+	for _, key := range sorted {
+		if len(keys) >= limit {
+			break
+		}
+		keys = append(keys, []byte(key))
+		values = append(values, c.kvs[key])
+	}
+	return keys, values, nil
+}
+
+func (c *fakeRawKVClient) DeleteRange(_ context.Context, startKey, endKey []byte, _ ...rawkv.RawOption) error {
+	for key := range c.kvs {
+		if c.inRange(key, startKey, endKey) {
+			delete(c.kvs, key)
+		}
+	}
+	return nil
+}
+
+func (c *fakeRawKVClient) Close() error {
+	return nil
+}
+
+func TestRawKVDebugger(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeRawKVClient{kvs: make(map[string][]byte)}
+	out := &bytes.Buffer{}
+	d := NewRawKVDebuggerWithClient(client, "escaped", out)
+
+	require.NoError(t, d.Put(ctx, "a", "1"))
+	require.NoError(t, d.Put(ctx, "b\\001", "2"))
+	require.NoError(t, d.Put(ctx, "c", "3"))
+	require.Equal(t, []byte("2"), client.kvs["b\x01"])
+
+	require.NoError(t, d.Get(ctx, "a"))
+	require.Equal(t, "1\n", out.String())
+	err := d.Get(ctx, "x")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+
+	out.Reset()
+	require.NoError(t, d.Scan(ctx, "", "", 2))
+	require.Equal(t, "a\t1\nb\\001\t2\n", out.String())
+
+	err = d.DeleteRange(ctx, "a", "")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+	err = d.DeleteRange(ctx, "c", "a")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+	require.NoError(t, d.DeleteRange(ctx, "a", "c"))
+
+	out.Reset()
+	require.NoError(t, d.Scan(ctx, "", "", 10))
+	require.Equal(t, "c\t3\n", out.String())
+
+	hex := NewRawKVDebuggerWithClient(client, "hex", out)
+	out.Reset()
+	require.NoError(t, hex.Get(ctx, "63"))
+	require.Equal(t, "33\n", out.String())
+	require.Error(t, hex.Get(ctx, "zz"))
+}
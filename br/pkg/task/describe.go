@@ -0,0 +1,203 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tikv/client-go/v2/oracle"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+const (
+	// flagOutput is the format of the output of `br list` and `br describe`.
+	flagOutput = "output"
+
+	// OutputTable prints the output as a table for humans.
+	OutputTable = "table"
+	// OutputJSON prints the output as JSON for scripts.
+	OutputJSON = "json"
+)
+
+// DefineOutputFlag defines the flag of the output format of the browsing commands.
+func DefineOutputFlag(command *cobra.Command) {
+	command.Flags().StringP(flagOutput, "o", OutputTable,
+		"The format of the output. Available options: \"table\", \"json\".")
+}
+
+// ParseOutputFlag parses the output format from the flag set.
+func ParseOutputFlag(flags *pflag.FlagSet) (string, error) {
+	output, err := flags.GetString(flagOutput)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if output != OutputTable && output != OutputJSON {
+		return "", errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s '%s'", flagOutput, output)
+	}
+	return output, nil
+}
+
+// DescribeConfig is the configuration specific for `br describe`.
+type DescribeConfig struct {
+	Config
+
+	// Format is the format of the keys of the ranges.
+	Format string `json:"format" toml:"format"`
+	Output string `json:"output" toml:"output"`
+}
+
+// DefineDescribeFlags defines the flags of `br describe`.
+func DefineDescribeFlags(command *cobra.Command) {
+	command.Flags().String(flagKeyFormat, "hex",
+		"The format of the keys of the backed up ranges. Available options: \"raw\", \"escaped\", \"hex\".")
+	DefineOutputFlag(command)
+}
+
+// ParseFromFlags parses the config from the flag set.
+func (cfg *DescribeConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.Format, err = flags.GetString(flagKeyFormat); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = utils.FormatKey(cfg.Format, nil); err != nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s '%s'", flagKeyFormat, cfg.Format)
+	}
+	if cfg.Output, err = ParseOutputFlag(flags); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// DescribedRange is a backed up key range in the format of DescribeConfig.Format.
+type DescribedRange struct {
+	StartKey string `json:"start-key"`
+	EndKey   string `json:"end-key"`
+}
+
+// BackupDescription is the summary of a backup read from its backupmeta.
+type BackupDescription struct {
+	Storage string `json:"storage"`
+	// BackupTS is the snapshot ts of the backup, 0 if the backup is not at a fixed ts.
+	BackupTS   uint64     `json:"backup-ts"`
+	BackupTime *time.Time `json:"backup-time,omitempty"`
+	// LastBackupTS is the start ts of an incremental backup, 0 for a full backup.
+	LastBackupTS   uint64           `json:"last-backup-ts"`
+	ClusterID      uint64           `json:"cluster-id"`
+	ClusterVersion string           `json:"cluster-version"`
+	BRVersion      string           `json:"br-version"`
+	APIVersion     string           `json:"api-version"`
+	MetaVersion    int32            `json:"meta-version"`
+	Ranges         []DescribedRange `json:"ranges"`
+	Files          int              `json:"files"`
+	TotalKvs       uint64           `json:"total-kvs"`
+	TotalBytes     uint64           `json:"total-bytes"`
+	// Size is the size of the data files of the backup.
+	Size uint64 `json:"size"`
+	// ParentChain are the storages of the parents of an incremental backup from the oldest one.
+	ParentChain []string `json:"parent-chain,omitempty"`
+	// UnsafeTSCaveats are why the backup taken by --unsafe-ts may be inconsistent, if it is.
+	UnsafeTSCaveats []string `json:"unsafe-ts-caveats,omitempty"`
+	// Encryption is the encryption method of the backup, "none" if it's not encrypted.
+	Encryption string `json:"encryption"`
+	// MasterKey is the master key wrapping the data key of the backup, if any.
+	MasterKey string `json:"master-key,omitempty"`
+}
+
+// DescribeBackup reads the backupmeta of the backup at --storage, or of --name in the catalog,
+// and summarizes it. The encrypted backup requires the key of the backup unless it's wrapped by
+// a master key.
+func DescribeBackup(ctx context.Context, cfg *DescribeConfig) (*BackupDescription, error) {
+	if err := cfg.ResolveStorageByName(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(cfg.Storage) == 0 {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, "--storage or --name is required to describe the backup")
+	}
+	_, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &cfg.Config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	storageURI, err := storageURIWithoutCredentials(cfg.Storage)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	d := &BackupDescription{
+		Storage:        storageURI,
+		BackupTS:       backupMeta.EndVersion,
+		LastBackupTS:   backupMeta.StartVersion,
+		ClusterID:      backupMeta.ClusterId,
+		ClusterVersion: backupMeta.ClusterVersion,
+		BRVersion:      backupMeta.BrVersion,
+		APIVersion:     backupMeta.ApiVersion.String(),
+		MetaVersion:    backupMeta.Version,
+		Encryption:     encryptionName(cfg.CipherInfo.CipherType),
+	}
+	if d.BackupTS > 0 {
+		backupTime := oracle.GetTimeFromTS(d.BackupTS)
+		d.BackupTime = &backupTime
+	}
+	for _, rg := range backupMeta.RawRanges {
+		start, _ := utils.FormatKey(cfg.Format, rg.StartKey)
+		end, _ := utils.FormatKey(cfg.Format, rg.EndKey)
+		d.Ranges = append(d.Ranges, DescribedRange{StartKey: start, EndKey: end})
+	}
+	reader := metautil.NewMetaReader(backupMeta, s, &cfg.CipherInfo)
+	err = reader.ReadDataFiles(ctx, func(file *backuppb.File) error {
+		d.Files++
+		d.TotalKvs += file.TotalKvs
+		d.TotalBytes += file.TotalBytes
+		d.Size += file.Size_
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if d.ParentChain, err = loadBackupChain(ctx, &cfg.Config, s); err != nil {
+		return nil, errors.Trace(err)
+	}
+	unsafeTS, err := metautil.ReadUnsafeTS(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if unsafeTS != nil {
+		d.UnsafeTSCaveats = unsafeTS.Caveats
+	}
+	e, err := metautil.ReadEncryption(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if e != nil {
+		d.MasterKey = e.MasterKey
+	}
+	return d, nil
+}
+
+// encryptionName returns the name of the encryption method accepted by --crypter.method, or
+// "none" if it's plaintext.
+func encryptionName(method encryptionpb.EncryptionMethod) string {
+	if method == encryptionpb.EncryptionMethod_PLAINTEXT || method == encryptionpb.EncryptionMethod_UNKNOWN {
+		return "none"
+	}
+	return strings.ToLower(strings.ReplaceAll(method.String(), "_", "-"))
+}
@@ -0,0 +1,96 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/catalog"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestDescribeBackup(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	plaintext := backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT}
+	writeBackup := func(dir string, backupTS uint64) storage.ExternalStorage {
+		s, err := storage.NewLocalStorage(root + "/" + dir)
+		require.NoError(t, err)
+		writer := metautil.NewMetaWriter(s, metautil.MetaShardSize, true, &plaintext)
+		writer.StartWriteMetasAsync(ctx, metautil.AppendDataFile)
+		for _, name := range []string{"1.sst", "2.sst"} {
+			file := &backuppb.File{Name: name, TotalKvs: 10, TotalBytes: 100, Size_: 40}
+			require.NoError(t, writer.Send([]*backuppb.File{file}, metautil.AppendDataFile))
+		}
+		require.NoError(t, writer.FinishWriteMetas(ctx, metautil.AppendDataFile))
+		writer.Update(func(m *backuppb.BackupMeta) {
+			m.EndVersion = backupTS
+			m.IsRawKv = true
+			m.ApiVersion = kvrpcpb.APIVersion_V2
+			m.RawRanges = []*backuppb.RawRange{{StartKey: []byte("ra"), EndKey: []byte("rz"), Cf: "default"}}
+		})
+		require.NoError(t, writer.FlushBackupMeta(ctx))
+		return s
+	}
+	full := writeBackup("full", 1<<18)
+	inc := writeBackup("inc", 2<<18)
+	checksum, err := metautil.BackupMetaChecksum(ctx, full, metautil.ChecksumSHA256)
+	require.NoError(t, err)
+	require.NoError(t, metautil.WriteParent(ctx, inc,
+		&metautil.Parent{Storage: "local://" + root + "/full", Checksum: checksum, BackupTS: 1 << 18}))
+
+	cfg := &DescribeConfig{Config: Config{Storage: "local://" + root + "/inc", CipherInfo: plaintext}, Format: "raw"}
+	d, err := DescribeBackup(ctx, cfg)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2<<18), d.BackupTS)
+	require.NotNil(t, d.BackupTime)
+	require.Equal(t, "V2", d.APIVersion)
+	require.Equal(t, int32(metautil.MetaV2), d.MetaVersion)
+	require.Equal(t, []DescribedRange{{StartKey: "ra", EndKey: "rz"}}, d.Ranges)
+	require.Equal(t, 2, d.Files)
+	require.Equal(t, uint64(20), d.TotalKvs)
+	require.Equal(t, uint64(200), d.TotalBytes)
+	require.Equal(t, uint64(80), d.Size)
+	require.Equal(t, []string{"local://" + root + "/full"}, d.ParentChain)
+	require.Equal(t, "none", d.Encryption)
+	require.Empty(t, d.MasterKey)
+
+	// the backup is found by its name in the catalog.
+	catalogCfg := &Config{Catalog: "local://" + root, Storage: "local://" + root + "/full"}
+	require.NoError(t, catalogCfg.recordBackupInCatalog(ctx, catalog.Entry{BackupTS: 1 << 18}))
+	cfg = &DescribeConfig{Config: Config{Catalog: "local://" + root, Name: "full", CipherInfo: plaintext}, Format: "hex"}
+	d, err = DescribeBackup(ctx, cfg)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1<<18), d.BackupTS)
+	require.Equal(t, []DescribedRange{{StartKey: "7261", EndKey: "727a"}}, d.Ranges)
+	require.Empty(t, d.ParentChain)
+
+	_, err = DescribeBackup(ctx, &DescribeConfig{Config: Config{CipherInfo: plaintext}})
+	require.Error(t, err)
+}
+
+func TestEncryptionName(t *testing.T) {
+	require.Equal(t, "none", encryptionName(encryptionpb.EncryptionMethod_PLAINTEXT))
+	require.Equal(t, "aes256-ctr", encryptionName(encryptionpb.EncryptionMethod_AES256_CTR))
+	method, err := parseCipherType(encryptionName(encryptionpb.EncryptionMethod_AES128_CTR))
+	require.NoError(t, err)
+	require.Equal(t, encryptionpb.EncryptionMethod_AES128_CTR, method)
+}
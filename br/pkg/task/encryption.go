@@ -0,0 +1,155 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package task
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/encryption"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// cipherTypeName is the name of the encryption method accepted by parseCipherType.
+func cipherTypeName(t encryptionpb.EncryptionMethod) string {
+	return strings.ToLower(strings.ReplaceAll(t.String(), "_", "-"))
+}
+
+func cipherKeyLen(t encryptionpb.EncryptionMethod) int {
+	switch t {
+	case encryptionpb.EncryptionMethod_AES128_CTR:
+		return crypterAES128KeyLen
+	case encryptionpb.EncryptionMethod_AES192_CTR:
+		return crypterAES192KeyLen
+	default:
+		return crypterAES256KeyLen
+	}
+}
+
+// setupDataKey generates the data key of the backup by --crypter.master-key, and writes it
+// wrapped by the master key into the storage. The data key of the interrupted backup is
+// kept when resuming it, which the finished ranges are encrypted by.
+func (cfg *Config) setupDataKey(ctx context.Context, s storage.ExternalStorage, resume bool) error {
+	if len(cfg.MasterKey) == 0 {
+		return nil
+	}
+	masterKey, err := encryption.NewMasterKey(ctx, cfg.MasterKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if resume {
+		e, err := metautil.ReadEncryption(ctx, s)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if e != nil {
+			return errors.Trace(cfg.unwrapDataKey(ctx, masterKey, e))
+		}
+	}
+
+	dataKey := make([]byte, cipherKeyLen(cfg.CipherInfo.CipherType))
+	if _, err = io.ReadFull(rand.Reader, dataKey); err != nil {
+		return errors.Trace(err)
+	}
+	wrappedKey, err := masterKey.Encrypt(ctx, dataKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = metautil.WriteEncryption(ctx, s, &metautil.Encryption{
+		MasterKey:  encryption.RedactURL(cfg.MasterKey),
+		Method:     cipherTypeName(cfg.CipherInfo.CipherType),
+		WrappedKey: wrappedKey,
+	})
+	if err != nil {
+		return errors.Annotate(err, "failed to write the wrapped data key")
+	}
+	cfg.CipherInfo.CipherKey = dataKey
+	cfg.wrappedDataKey = true
+	log.Info("generated the data key of the backup",
+		zap.String("master-key", encryption.RedactURL(cfg.MasterKey)),
+		zap.String("method", cipherTypeName(cfg.CipherInfo.CipherType)))
+	return nil
+}
+
+// loadDataKey unwraps the data key of the backup in the storage, if the backup is encrypted
+// by a master key and no data key is given. The master key recorded by the backup is used
+// unless --crypter.master-key is specified.
+func (cfg *Config) loadDataKey(ctx context.Context, s storage.ExternalStorage) error {
+	if len(cfg.CipherInfo.CipherKey) > 0 {
+		return nil
+	}
+	e, err := metautil.ReadEncryption(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if e == nil {
+		if len(cfg.MasterKey) > 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"the backup %s isn't encrypted by a master key, %s not found", s.URI(), metautil.EncryptionFile)
+		}
+		return nil
+	}
+	rawURL := cfg.MasterKey
+	if len(rawURL) == 0 {
+		rawURL = e.MasterKey
+	}
+	masterKey, err := encryption.NewMasterKey(ctx, rawURL)
+	if err != nil {
+		return errors.Annotatef(err, "the backup is encrypted by the master key %s, specify --%s if it has moved",
+			e.MasterKey, flagMasterKey)
+	}
+	return errors.Trace(cfg.unwrapDataKey(ctx, masterKey, e))
+}
+
+func (cfg *Config) unwrapDataKey(ctx context.Context, masterKey encryption.MasterKey, e *metautil.Encryption) error {
+	method, err := parseCipherType(e.Method)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	dataKey, err := masterKey.Decrypt(ctx, e.WrappedKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.CipherInfo.CipherType = method
+	cfg.CipherInfo.CipherKey = dataKey
+	cfg.wrappedDataKey = true
+	if !checkCipherKeyMatch(&cfg.CipherInfo) {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"the length of the unwrapped data key doesn't match the crypter method %s", e.Method)
+	}
+	return nil
+}
+
+// configOfBackup returns the config reading another backup in the storage, e.g. the parent
+// or a merged backup. The backups encrypted by master keys have their own data keys.
+func (cfg *Config) configOfBackup(rawURL string) Config {
+	other := *cfg
+	other.Storage = rawURL
+	// the failover storages are of the backup of cfg.
+	other.StorageFailover = nil
+	if cfg.wrappedDataKey {
+		other.CipherInfo = backuppb.CipherInfo{CipherType: cfg.CipherInfo.CipherType}
+		other.wrappedDataKey = false
+	}
+	return other
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package task
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestMasterKeyDataKey(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "master.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(strings.Repeat("01", 32)), 0o600))
+	s, err := storage.NewLocalStorage(filepath.Join(dir, "backup"))
+	require.NoError(t, err)
+
+	backupCfg := &Config{MasterKey: "local://" + keyFile}
+	backupCfg.CipherInfo.CipherType = encryptionpb.EncryptionMethod_AES128_CTR
+	require.NoError(t, backupCfg.setupDataKey(ctx, s, false))
+	require.Len(t, backupCfg.CipherInfo.CipherKey, crypterAES128KeyLen)
+	e, err := metautil.ReadEncryption(ctx, s)
+	require.NoError(t, err)
+	require.Equal(t, "local://"+keyFile, e.MasterKey)
+	require.Equal(t, "aes128-ctr", e.Method)
+	require.NotEqual(t, backupCfg.CipherInfo.CipherKey, e.WrappedKey)
+
+	// resuming the backup keeps the data key.
+	resumeCfg := &Config{MasterKey: backupCfg.MasterKey}
+	resumeCfg.CipherInfo.CipherType = encryptionpb.EncryptionMethod_AES128_CTR
+	require.NoError(t, resumeCfg.setupDataKey(ctx, s, true))
+	require.Equal(t, backupCfg.CipherInfo.CipherKey, resumeCfg.CipherInfo.CipherKey)
+
+	// restore unwraps the data key by the recorded master key.
+	restoreCfg := &Config{}
+	require.NoError(t, restoreCfg.loadDataKey(ctx, s))
+	require.Equal(t, encryptionpb.EncryptionMethod_AES128_CTR, restoreCfg.CipherInfo.CipherType)
+	require.Equal(t, backupCfg.CipherInfo.CipherKey, restoreCfg.CipherInfo.CipherKey)
+
+	// the other backups have their own data keys.
+	other := restoreCfg.configOfBackup("local:///other")
+	require.Empty(t, other.CipherInfo.CipherKey)
+	require.Equal(t, "local:///other", other.Storage)
+
+	// the given data key takes priority.
+	plainCfg := &Config{}
+	plainCfg.CipherInfo.CipherKey = []byte("given")
+	require.NoError(t, plainCfg.loadDataKey(ctx, s))
+	require.Equal(t, []byte("given"), plainCfg.CipherInfo.CipherKey)
+
+	// the backup isn't encrypted by a master key.
+	plain, err := storage.NewLocalStorage(filepath.Join(dir, "plain"))
+	require.NoError(t, err)
+	require.NoError(t, (&Config{}).loadDataKey(ctx, plain))
+	err = (&Config{MasterKey: "local://" + keyFile}).loadDataKey(ctx, plain)
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
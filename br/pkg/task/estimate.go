@@ -0,0 +1,94 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/migration/br/pkg/backup"
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/summary"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+// sampledCompressionTypes are the compression types compared by RunEstimateCompressionRaw.
+var sampledCompressionTypes = []backuppb.CompressionType{
+	backuppb.CompressionType_LZ4,
+	backuppb.CompressionType_SNAPPY,
+	backuppb.CompressionType_ZSTD,
+}
+
+// RunEstimateCompressionRaw backs up a few sampled regions in the range of the raw backup
+// with each compression type at its default level, and returns the achieved ratios and speeds.
+func RunEstimateCompressionRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConfig) ([]backup.CompressionSample, error) {
+	cfg.adjust()
+
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer mgr.Close()
+	mgr.SetGRPCMaxMsgSize(cfg.GRPCMaxMsgSize)
+	mgr.SetDNSRefreshInterval(cfg.DNSRefreshInterval)
+	if err = setupStoreConnectivity(ctx, mgr, &cfg.Config); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	client, err := backup.NewBackupClient(ctx, mgr, mgr.GetTLSConfig())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	client.SetDynamicSettings(utils.GlobalDynamicSettings())
+	curAPIVersion := client.GetCurAPIVersion()
+	if _, err = cfg.resolveKeyspace(ctx, mgr); err != nil {
+		return nil, errors.Trace(err)
+	}
+	cfg.adjustBackupRange(curAPIVersion, cfg.keyspaceID())
+	if len(cfg.DstAPIVersion) == 0 {
+		cfg.DstAPIVersion = curAPIVersion.String()
+	}
+
+	ctx = logutil.ContextWithPhase(ctx, "estimate-compression")
+	ranges, err := client.SampleRegions(ctx, cfg.StartKey, cfg.EndKey, cfg.SampleRegions)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	summary.CollectInt("sampled regions", len(ranges))
+
+	req := backuppb.BackupRequest{
+		ClusterId:     client.GetClusterID(),
+		RateLimit:     cfg.RateLimit,
+		Concurrency:   cfg.Concurrency,
+		IsRawKv:       true,
+		Cf:            "default",
+		DstApiVersion: kvrpcpb.APIVersion(kvrpcpb.APIVersion_value[cfg.DstAPIVersion]),
+		CipherInfo:    &cfg.CipherInfo,
+	}
+	samples, err := client.SampleCompression(ctx, ranges, req, sampledCompressionTypes)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	summary.SetSuccessStatus(true)
+	return samples, nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"go.uber.org/zap"
+)
+
+// HookPoint is the point of the task lifecycle a hook runs at.
+type HookPoint string
+
+const (
+	// HookPreBackup runs before the ranges are backed up, the backup ts is decided already.
+	HookPreBackup HookPoint = "pre-backup"
+	// HookPostMetaFlush runs once the backupmeta and the other metadata of the backup are written.
+	HookPostMetaFlush HookPoint = "post-meta-flush"
+	// HookPostRestoreVerify runs once the restored data is verified by the checksum, or restored
+	// if the checksum is skipped, and the changelog of --changelog-storage is replayed.
+	HookPostRestoreVerify HookPoint = "post-restore-verify"
+)
+
+// HookFailurePolicy is how the failure of a hook is handled.
+type HookFailurePolicy string
+
+const (
+	// HookFailureAbort fails the task.
+	HookFailureAbort HookFailurePolicy = "abort"
+	// HookFailureIgnore only logs the failure and goes on.
+	HookFailureIgnore HookFailurePolicy = "ignore"
+
+	defaultHookTimeout = 5 * time.Minute
+	// maxHookOutput is the size of the output of a hook kept in the log.
+	maxHookOutput = 4096
+)
+
+// Hook is an external command run at a point of the task lifecycle, e.g. snapshotting the
+// config of the application along with the backup. The hooks are the [[hooks]] tables of the
+// config file. The command gets the context of the task by the BR_* environment variables,
+// and by the JSON of hookInput on its stdin.
+type Hook struct {
+	Point   HookPoint `json:"point" toml:"point"`
+	Command string    `json:"command" toml:"command"`
+	// Timeout kills the command running longer, e.g. "30s". It defaults to 5m.
+	Timeout   string            `json:"timeout" toml:"timeout"`
+	OnFailure HookFailurePolicy `json:"on-failure" toml:"on-failure"`
+
+	timeout time.Duration
+}
+
+// hookInput is the context of the task passed to the hooks.
+type hookInput struct {
+	Point    HookPoint                `json:"point"`
+	Task     string                   `json:"task"`
+	Storage  string                   `json:"storage,omitempty"`
+	BackupTS uint64                   `json:"backup-ts,omitempty"`
+	Size     uint64                   `json:"size,omitempty"`
+	Checksum *metautil.ResultChecksum `json:"checksum,omitempty"`
+	// Outputs are the URLs of the files the task produced or consumed so far, by name.
+	Outputs map[string]string `json:"outputs,omitempty"`
+}
+
+// loadHooks loads the hooks of the config file, and checks them.
+func loadHooks(path string) ([]Hook, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	var file struct {
+		Hooks []Hook `toml:"hooks"`
+	}
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to load config file %s: %v", path, err)
+	}
+	for i := range file.Hooks {
+		if err := file.Hooks[i].adjust(); err != nil {
+			return nil, errors.Annotatef(err, "hook #%d of %s", i+1, path)
+		}
+	}
+	return file.Hooks, nil
+}
+
+func (h *Hook) adjust() error {
+	switch h.Point {
+	case HookPreBackup, HookPostMetaFlush, HookPostRestoreVerify:
+	default:
+		return errors.Annotatef(berrors.ErrInvalidArgument, "unknown hook point %q, it should be one of %s, %s, %s",
+			h.Point, HookPreBackup, HookPostMetaFlush, HookPostRestoreVerify)
+	}
+	args, err := splitCommand(h.Command)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(args) == 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "the command of the hook is empty")
+	}
+	switch h.OnFailure {
+	case "":
+		h.OnFailure = HookFailureAbort
+	case HookFailureAbort, HookFailureIgnore:
+	default:
+		return errors.Annotatef(berrors.ErrInvalidArgument, "unknown failure policy %q, it should be %s or %s",
+			h.OnFailure, HookFailureAbort, HookFailureIgnore)
+	}
+	h.timeout = defaultHookTimeout
+	if len(h.Timeout) > 0 {
+		timeout, err := time.ParseDuration(h.Timeout)
+		if err != nil || timeout <= 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "bad timeout %q of the hook", h.Timeout)
+		}
+		h.timeout = timeout
+	}
+	return nil
+}
+
+// runHooks runs the hooks of the point one by one, in the order of the config file. It stops
+// at the first hook failing by HookFailureAbort.
+func runHooks(ctx context.Context, hooks []Hook, point HookPoint, result *taskResult) error {
+	input := hookInput{
+		Point:    point,
+		Task:     result.Task,
+		BackupTS: result.BackupTS,
+		Size:     result.Size,
+		Checksum: result.Checksum,
+		Outputs:  result.Outputs,
+	}
+	if result.storage != nil {
+		input.Storage = result.storage.URI()
+	}
+	for i := range hooks {
+		hook := &hooks[i]
+		if hook.Point != point {
+			continue
+		}
+		start := time.Now()
+		err := hook.run(ctx, &input)
+		if err == nil {
+			log.Info("hook finished", zap.String("point", string(point)), zap.String("command", hook.Command),
+				zap.Duration("take", time.Since(start)))
+			continue
+		}
+		if hook.OnFailure == HookFailureIgnore {
+			log.Warn("hook failed, ignore it", zap.String("point", string(point)),
+				zap.String("command", hook.Command), zap.Error(err))
+			continue
+		}
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func (h *Hook) run(ctx context.Context, input *hookInput) error {
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	timeout := h.timeout
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	args, err := splitCommand(h.Command)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// nolint:gosec
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"BR_HOOK_POINT="+string(input.Point),
+		"BR_TASK="+input.Task,
+		"BR_STORAGE="+input.Storage,
+		"BR_BACKUP_TS="+strconv.FormatUint(input.BackupTS, 10),
+	)
+	var output bytes.Buffer
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err = cmd.Run()
+	out := output.String()
+	if len(out) > maxHookOutput {
+		out = out[:maxHookOutput] + "..."
+	}
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded { // nolint:errorlint
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		return errors.Annotatef(berrors.ErrHookFailed, "%s hook '%s': %v, %s",
+			input.Point, h.Command, err, strings.TrimSpace(out))
+	}
+	if len(out) > 0 {
+		log.Info("hook output", zap.String("point", string(input.Point)), zap.String("command", h.Command),
+			zap.String("output", strings.TrimSpace(out)))
+	}
+	return nil
+}
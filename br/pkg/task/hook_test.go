@@ -0,0 +1,110 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+)
+
+func TestLoadHooks(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "br.toml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+ratelimit = 64
+
+[[hooks]]
+point = "pre-backup"
+command = "/usr/local/bin/snapshot-app-config --dry-run"
+
+[[hooks]]
+point = "post-restore-verify"
+command = "notify"
+timeout = "30s"
+on-failure = "ignore"
+`), 0o600))
+	hooks, err := loadHooks(path)
+	require.NoError(t, err)
+	require.Len(t, hooks, 2)
+	require.Equal(t, HookPreBackup, hooks[0].Point)
+	require.Equal(t, HookFailureAbort, hooks[0].OnFailure)
+	require.Equal(t, defaultHookTimeout, hooks[0].timeout)
+	require.Equal(t, HookFailureIgnore, hooks[1].OnFailure)
+	require.Equal(t, 30*time.Second, hooks[1].timeout)
+
+	hooks, err = loadHooks("")
+	require.NoError(t, err)
+	require.Empty(t, hooks)
+
+	for _, bad := range []string{
+		"[[hooks]]\npoint = \"post-backup\"\ncommand = \"true\"\n",
+		"[[hooks]]\npoint = \"pre-backup\"\ncommand = \" \"\n",
+		"[[hooks]]\npoint = \"pre-backup\"\ncommand = \"true\"\non-failure = \"retry\"\n",
+		"[[hooks]]\npoint = \"pre-backup\"\ncommand = \"true\"\ntimeout = \"soon\"\n",
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(bad), 0o600))
+		_, err = loadHooks(path)
+		require.ErrorIs(t, err, berrors.ErrInvalidArgument, bad)
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "hook.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\n"+
+		"cat > \"$1/$BR_HOOK_POINT.json\"\n"+
+		"echo \"$BR_TASK $BR_BACKUP_TS\" > \"$1/$BR_HOOK_POINT.env\"\n"), 0o700)) // nolint:gosec
+
+	result := newTaskResult("Raw Backup", metautil.BackupResultFile)
+	result.BackupTS = 42
+	result.Outputs["backupmeta"] = "local:///backup/backupmeta"
+	hooks := []Hook{
+		{Point: HookPreBackup, Command: "false", OnFailure: HookFailureIgnore},
+		{Point: HookPreBackup, Command: script + " " + dir, OnFailure: HookFailureAbort},
+		{Point: HookPostMetaFlush, Command: "false", OnFailure: HookFailureAbort},
+	}
+	ctx := context.Background()
+	require.NoError(t, runHooks(ctx, hooks, HookPreBackup, result))
+	data, err := os.ReadFile(filepath.Join(dir, "pre-backup.json"))
+	require.NoError(t, err)
+	var input hookInput
+	require.NoError(t, json.Unmarshal(data, &input))
+	require.Equal(t, hookInput{
+		Point:    HookPreBackup,
+		Task:     "Raw Backup",
+		BackupTS: 42,
+		Outputs:  map[string]string{"backupmeta": "local:///backup/backupmeta"},
+	}, input)
+	env, err := os.ReadFile(filepath.Join(dir, "pre-backup.env"))
+	require.NoError(t, err)
+	require.Equal(t, "Raw Backup 42\n", string(env))
+
+	err = runHooks(ctx, hooks, HookPostMetaFlush, result)
+	require.ErrorIs(t, err, berrors.ErrHookFailed)
+	require.NoError(t, runHooks(ctx, hooks, HookPostRestoreVerify, result))
+
+	slow := []Hook{{Point: HookPostRestoreVerify, Command: "sleep 10", timeout: 50 * time.Millisecond}}
+	err = runHooks(ctx, slow, HookPostRestoreVerify, result)
+	require.ErrorIs(t, err, berrors.ErrHookFailed)
+	require.Contains(t, err.Error(), "timed out")
+}
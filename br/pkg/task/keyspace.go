@@ -0,0 +1,243 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	"github.com/tikv/migration/br/pkg/conn"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/summary"
+	"github.com/tikv/migration/br/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultKeyspaceID is the ID of the default keyspace, which exists in every API V2 cluster.
+	defaultKeyspaceID = 0
+
+	// flagKeyspaceName and flagKeyspaceID select the API V2 keyspace of the task.
+	flagKeyspaceName = "keyspace-name"
+	flagKeyspaceID   = "keyspace-id"
+)
+
+// parseKeyspace parses the keyspace selected by either --keyspace-name or --keyspace-id.
+func (cfg *RawKvConfig) parseKeyspace(flags *pflag.FlagSet) error {
+	name, err := flags.GetString(flagKeyspaceName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.KeyspaceName = name
+	if !flags.Changed(flagKeyspaceID) {
+		return nil
+	}
+	if len(name) > 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s can't be used with --%s", flagKeyspaceName, flagKeyspaceID)
+	}
+	id, err := flags.GetUint32(flagKeyspaceID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if id > utils.MaxKeyspaceID {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must be at most %d, got %d", flagKeyspaceID, utils.MaxKeyspaceID, id)
+	}
+	cfg.KeyspaceID = &id
+	return nil
+}
+
+// hasKeyspace returns whether a keyspace is selected by --keyspace-name or --keyspace-id.
+func (cfg *RawKvConfig) hasKeyspace() bool {
+	return len(cfg.KeyspaceName) > 0 || cfg.KeyspaceID != nil
+}
+
+// keyspaceID returns the ID of the keyspace selected, which defaults to the default keyspace.
+// It must be called after resolveKeyspace if the keyspace is selected by name.
+func (cfg *RawKvConfig) keyspaceID() uint32 {
+	if cfg.KeyspaceID == nil {
+		return defaultKeyspaceID
+	}
+	return *cfg.KeyspaceID
+}
+
+// resolveKeyspace looks up the keyspace selected in the cluster, and resolves --keyspace-name
+// into its ID. It returns nil if no keyspace is selected.
+func (cfg *RawKvConfig) resolveKeyspace(ctx context.Context, mgr *conn.Mgr) (*metautil.Keyspace, error) {
+	if !cfg.hasKeyspace() {
+		return nil, nil
+	}
+	metas, err := mgr.GetKeyspaces(ctx)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to get the keyspaces of the cluster")
+	}
+	for _, meta := range metas {
+		if (len(cfg.KeyspaceName) > 0 && meta.Name == cfg.KeyspaceName) ||
+			(cfg.KeyspaceID != nil && meta.ID == *cfg.KeyspaceID) {
+			id := meta.ID
+			cfg.KeyspaceID = &id
+			return &metautil.Keyspace{ID: meta.ID, Name: meta.Name, Config: meta.Config}, nil
+		}
+	}
+	if len(cfg.KeyspaceName) > 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "keyspace %s doesn't exist in the cluster", cfg.KeyspaceName)
+	}
+	if *cfg.KeyspaceID == defaultKeyspaceID {
+		// the default keyspace exists even if PD doesn't list it.
+		return &metautil.Keyspace{ID: defaultKeyspaceID}, nil
+	}
+	return nil, errors.Annotatef(berrors.ErrInvalidArgument, "keyspace %d doesn't exist in the cluster", *cfg.KeyspaceID)
+}
+
+// getKeyspaces returns the keyspaces of the cluster except the default one.
+func getKeyspaces(ctx context.Context, mgr *conn.Mgr) ([]metautil.Keyspace, error) {
+	metas, err := mgr.GetKeyspaces(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	keyspaces := make([]metautil.Keyspace, 0, len(metas))
+	for _, meta := range metas {
+		if meta.ID == defaultKeyspaceID {
+			continue
+		}
+		keyspaces = append(keyspaces, metautil.Keyspace{ID: meta.ID, Name: meta.Name, Config: meta.Config})
+	}
+	sort.Slice(keyspaces, func(i, j int) bool { return keyspaces[i].ID < keyspaces[j].ID })
+	return keyspaces, nil
+}
+
+// restoreKeyspaceIDs returns the keyspace the backup is scoped to, and the keyspace its keys are
+// restored into, which is selected by --keyspace-name or --keyspace-id and defaults to the former.
+func restoreKeyspaceIDs(
+	ctx context.Context, mgr *conn.Mgr, cfg *RestoreRawConfig, s storage.ExternalStorage, apiVersion kvrpcpb.APIVersion,
+) (source, target uint32, err error) {
+	scope, err := metautil.ReadKeyspaceScope(ctx, s)
+	if err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	source = defaultKeyspaceID
+	if scope != nil {
+		source = scope.ID
+	}
+	if !cfg.hasKeyspace() {
+		return source, source, nil
+	}
+	if apiVersion != kvrpcpb.APIVersion_V2 {
+		return 0, 0, errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s and --%s require an API V2 backup, the api version of the backup is %s",
+			flagKeyspaceName, flagKeyspaceID, apiVersion)
+	}
+	if _, err = cfg.resolveKeyspace(ctx, mgr); err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	target = cfg.keyspaceID()
+	if source != target {
+		log.Info("map the keys of the backup into another keyspace",
+			zap.Uint32("source", source), zap.Uint32("target", target))
+	}
+	return source, target, nil
+}
+
+// recordKeyspaces records the keyspaces of the backup cluster into the backup storage, or
+// only scope if the backup is scoped to it. The data is still restorable without them, so
+// the failure is only logged.
+func recordKeyspaces(ctx context.Context, mgr *conn.Mgr, s storage.ExternalStorage, scope *metautil.Keyspace) {
+	var keyspaces []metautil.Keyspace
+	var err error
+	if scope == nil {
+		keyspaces, err = getKeyspaces(ctx, mgr)
+	} else if scope.ID != defaultKeyspaceID {
+		keyspaces = []metautil.Keyspace{*scope}
+	}
+	if err == nil {
+		err = metautil.WriteKeyspaces(ctx, s, keyspaces)
+	}
+	if err != nil {
+		log.Warn("failed to record the keyspaces, skip it", zap.Error(err))
+		return
+	}
+	summary.CollectInt("backup keyspaces", len(keyspaces))
+}
+
+// missingKeyspaces returns the keyspaces of source to create in the target cluster, in the order of
+// their IDs. It fails if a keyspace can't keep its ID, because the ID is encoded in the keys.
+func missingKeyspaces(source, target []metautil.Keyspace) ([]metautil.Keyspace, error) {
+	byName := make(map[string]metautil.Keyspace, len(target))
+	byID := make(map[uint32]metautil.Keyspace, len(target))
+	for _, keyspace := range target {
+		byName[keyspace.Name] = keyspace
+		byID[keyspace.ID] = keyspace
+	}
+	missing := make([]metautil.Keyspace, 0, len(source))
+	for _, keyspace := range source {
+		if existing, ok := byName[keyspace.Name]; ok {
+			if existing.ID != keyspace.ID {
+				return nil, errors.Annotatef(berrors.ErrRestoreKeyspaceMismatch,
+					"keyspace %s has ID %d in the backup, but %d in the target cluster", keyspace.Name, keyspace.ID, existing.ID)
+			}
+			continue
+		}
+		if existing, ok := byID[keyspace.ID]; ok {
+			return nil, errors.Annotatef(berrors.ErrRestoreKeyspaceMismatch,
+				"the ID %d of keyspace %s in the backup is taken by keyspace %s in the target cluster",
+				keyspace.ID, keyspace.Name, existing.Name)
+		}
+		missing = append(missing, keyspace)
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i].ID < missing[j].ID })
+	return missing, nil
+}
+
+// createKeyspaces recreates the keyspaces of the backup cluster missing in the target cluster
+// with the same IDs and names, before the data of the keyspaces is restored.
+func createKeyspaces(ctx context.Context, mgr *conn.Mgr, s storage.ExternalStorage) error {
+	source, err := metautil.ReadKeyspaces(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if source == nil {
+		log.Info("the backup doesn't record the keyspaces, skip creating them")
+		return nil
+	}
+	target, err := getKeyspaces(ctx, mgr)
+	if err != nil {
+		return errors.Annotate(err, "failed to get the keyspaces of the target cluster")
+	}
+	missing, err := missingKeyspaces(source, target)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, keyspace := range missing {
+		// PD allocates the IDs in order, the keyspace gets the same ID only if
+		// the target cluster allocated the same IDs before, e.g. a fresh cluster.
+		created, err := mgr.CreateKeyspace(ctx, keyspace.Name, keyspace.Config)
+		if err != nil {
+			return errors.Annotatef(err, "failed to create keyspace %s", keyspace.Name)
+		}
+		if created.ID != keyspace.ID {
+			return errors.Annotatef(berrors.ErrRestoreKeyspaceMismatch,
+				"keyspace %s is created with ID %d instead of %d, please restore into a fresh cluster",
+				keyspace.Name, created.ID, keyspace.ID)
+		}
+		log.Info("keyspace created", zap.String("name", keyspace.Name), zap.Uint32("id", keyspace.ID))
+	}
+	summary.CollectInt("created keyspaces", len(missing))
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+)
+
+func TestMissingKeyspaces(t *testing.T) {
+	source := []metautil.Keyspace{{ID: 3, Name: "c"}, {ID: 1, Name: "a"}, {ID: 2, Name: "b"}}
+
+	missing, err := missingKeyspaces(source, nil)
+	require.NoError(t, err)
+	require.Equal(t, []metautil.Keyspace{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}, {ID: 3, Name: "c"}}, missing)
+
+	missing, err = missingKeyspaces(source, []metautil.Keyspace{{ID: 1, Name: "a"}})
+	require.NoError(t, err)
+	require.Equal(t, []metautil.Keyspace{{ID: 2, Name: "b"}, {ID: 3, Name: "c"}}, missing)
+
+	_, err = missingKeyspaces(source, []metautil.Keyspace{{ID: 4, Name: "a"}})
+	require.True(t, berrors.Is(err, berrors.ErrRestoreKeyspaceMismatch))
+
+	_, err = missingKeyspaces(source, []metautil.Keyspace{{ID: 2, Name: "x"}})
+	require.True(t, berrors.Is(err, berrors.ErrRestoreKeyspaceMismatch))
+}
+
+func TestParseKeyspace(t *testing.T) {
+	parse := func(args ...string) (*RawKvConfig, error) {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags.String(flagKeyspaceName, "", "")
+		flags.Uint32(flagKeyspaceID, defaultKeyspaceID, "")
+		require.NoError(t, flags.Parse(args))
+		cfg := &RawKvConfig{}
+		return cfg, cfg.parseKeyspace(flags)
+	}
+
+	cfg, err := parse()
+	require.NoError(t, err)
+	require.False(t, cfg.hasKeyspace())
+	require.Equal(t, uint32(defaultKeyspaceID), cfg.keyspaceID())
+
+	cfg, err = parse("--keyspace-name", "a")
+	require.NoError(t, err)
+	require.True(t, cfg.hasKeyspace())
+	require.Equal(t, "a", cfg.KeyspaceName)
+
+	// the default keyspace can be selected explicitly.
+	cfg, err = parse("--keyspace-id", "0")
+	require.NoError(t, err)
+	require.True(t, cfg.hasKeyspace())
+	require.Equal(t, uint32(0), cfg.keyspaceID())
+	cfg, err = parse("--keyspace-id", "7")
+	require.NoError(t, err)
+	require.Equal(t, uint32(7), cfg.keyspaceID())
+
+	_, err = parse("--keyspace-id", "16777216")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+	_, err = parse("--keyspace-name", "a", "--keyspace-id", "1")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
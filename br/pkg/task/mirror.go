@@ -0,0 +1,64 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/pflag"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+// parseStorageMirror parses the storages the backup is mirrored to.
+func (cfg *RawKvConfig) parseStorageMirror(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.StorageMirror, err = flags.GetStringSlice(flagStorageMirror); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.StorageMirrorPolicy, err = flags.GetString(flagStorageMirrorPolicy); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = storage.ParseMirrorPolicy(cfg.StorageMirrorPolicy); err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.StorageMirror) == 0 {
+		return nil
+	}
+	if len(cfg.StorageFailover) > 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s can't be used with --%s", flagStorageMirror, flagStorageFailover)
+	}
+	for _, mirror := range cfg.StorageMirror {
+		if mirror == cfg.Storage {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "the storage mirror %s is the same as --%s", mirror, flagStorage)
+		}
+	}
+	return nil
+}
+
+// newMirrorStorage creates the storage writing to --storage and the mirrors.
+func (cfg *RawKvConfig) newMirrorStorage(ctx context.Context) (*storage.MirrorStorage, error) {
+	policy, err := storage.ParseMirrorPolicy(cfg.StorageMirrorPolicy)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	endpoints, err := newStorageEndpoints(ctx, &cfg.Config, append([]string{cfg.Storage}, cfg.StorageMirror...))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s, err := storage.NewMirrorStorage(endpoints[0], endpoints[1:], policy)
+	return s, errors.Trace(err)
+}
@@ -0,0 +1,48 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestParseStorageMirror(t *testing.T) {
+	parse := func(cfg *RawKvConfig, args ...string) error {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags.StringSlice(flagStorageMirror, nil, "")
+		flags.String(flagStorageMirrorPolicy, string(storage.MirrorPolicyRequired), "")
+		require.NoError(t, flags.Parse(args))
+		return cfg.parseStorageMirror(flags)
+	}
+	cfg := &RawKvConfig{Config: Config{Storage: "local:///onsite"}}
+	require.NoError(t, parse(cfg, "--storage-mirror", "s3://offsite/backup,local:///nas",
+		"--storage-mirror-policy", "best-effort"))
+	require.Equal(t, []string{"s3://offsite/backup", "local:///nas"}, cfg.StorageMirror)
+	require.Equal(t, string(storage.MirrorPolicyBestEffort), cfg.StorageMirrorPolicy)
+
+	err := parse(cfg, "--storage-mirror-policy", "quorum")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+	err = parse(cfg, "--storage-mirror", "local:///onsite")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+
+	cfg = &RawKvConfig{Config: Config{Storage: "local:///onsite", StorageFailover: []string{"local:///failover"}}}
+	err = parse(cfg, "--storage-mirror", "local:///nas")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
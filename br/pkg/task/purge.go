@@ -0,0 +1,300 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tikv/client-go/v2/oracle"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/summary"
+	"go.uber.org/zap"
+)
+
+const (
+	flagKeepLast   = "keep-last"
+	flagKeepWithin = "keep-within"
+)
+
+// PurgeConfig is the configuration specific for `br purge`.
+type PurgeConfig struct {
+	Config
+
+	// KeepLast keeps the latest backups of the number.
+	KeepLast uint `json:"keep-last" toml:"keep-last"`
+	// KeepWithin keeps the backups whose backup ts is within the duration.
+	KeepWithin time.Duration `json:"keep-within" toml:"keep-within"`
+	DryRun     bool          `json:"dry-run" toml:"dry-run"`
+}
+
+// DefinePurgeFlags defines the flags of `br purge`.
+func DefinePurgeFlags(command *cobra.Command) {
+	command.Flags().Uint(flagKeepLast, 0, "Keep the latest backups of the number under the storage")
+	command.Flags().String(flagKeepWithin, "",
+		"Keep the backups within the duration, e.g. 30d or 12h")
+	command.Flags().Bool(flagDryRun, false, "List the expired backups without deleting them")
+}
+
+// parseRetention parses the duration of retention, which supports the unit of day besides
+// those of time.ParseDuration.
+func parseRetention(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.ParseUint(days, 10, 32)
+		if err != nil {
+			return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid duration %s", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid duration %s", s)
+	}
+	return d, nil
+}
+
+// ParseFromFlags parses the config from the flag set.
+func (cfg *PurgeConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.KeepLast, err = flags.GetUint(flagKeepLast); err != nil {
+		return errors.Trace(err)
+	}
+	keepWithin, err := flags.GetString(flagKeepWithin)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(keepWithin) > 0 {
+		if cfg.KeepWithin, err = parseRetention(keepWithin); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if cfg.KeepLast == 0 && cfg.KeepWithin == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s or --%s is required to purge the backups",
+			flagKeepLast, flagKeepWithin)
+	}
+	if cfg.DryRun, err = flags.GetBool(flagDryRun); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// PurgeBackup is a backup under the storage of `br purge`.
+type PurgeBackup struct {
+	// Dir is the directory of the backup in the storage.
+	Dir string
+	// BackupTS is the backup ts of the backup, 0 if unknown.
+	BackupTS uint64
+	// Parent is the directory of the parent backup if it's an incremental backup. It's empty for
+	// a full backup, or the parent outside of the storage.
+	Parent string
+	// Keep is the reason to keep the backup, empty if it's expired.
+	Keep string
+}
+
+// PurgeReport is the backups under the storage and the objects of the expired ones.
+type PurgeReport struct {
+	// Backups are the backups under the storage, the latest first.
+	Backups []*PurgeBackup
+	// Expired are the directories of the expired backups.
+	Expired []string
+	// ExpiredSize is the total size of the objects of the expired backups.
+	ExpiredSize int64
+	// Deleted are the objects deleted.
+	Deleted []string
+}
+
+// planPurge marks the backups to keep by the policy, the others are expired. The parents of a
+// kept incremental backup are kept as well, so that the kept backups can always be restored.
+func planPurge(backups []*PurgeBackup, keepLast uint, keepWithin time.Duration, now time.Time) {
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].BackupTS > backups[j].BackupTS
+	})
+	byDir := make(map[string]*PurgeBackup, len(backups))
+	for _, b := range backups {
+		byDir[b.Dir] = b
+	}
+	for i, b := range backups {
+		switch {
+		case b.BackupTS == 0:
+			b.Keep = "unknown backup ts"
+		case i == 0:
+			// never purge all the backups.
+			b.Keep = "latest"
+		case uint(i) < keepLast:
+			b.Keep = flagKeepLast
+		case keepWithin > 0 && now.Sub(oracle.GetTimeFromTS(b.BackupTS)) <= keepWithin:
+			b.Keep = flagKeepWithin
+		}
+	}
+	for _, b := range backups {
+		if len(b.Keep) == 0 || strings.HasPrefix(b.Keep, "parent of ") {
+			continue
+		}
+		visited := map[string]struct{}{b.Dir: {}}
+		for p := byDir[b.Parent]; p != nil; p = byDir[p.Parent] {
+			if _, ok := visited[p.Dir]; ok {
+				break
+			}
+			visited[p.Dir] = struct{}{}
+			if len(p.Keep) == 0 {
+				p.Keep = "parent of " + b.Dir
+			}
+		}
+	}
+}
+
+// expiredObjects returns the objects of the expired backups. An object belongs to the backup of the
+// deepest directory containing it, so that the backups nested in an expired one are untouched.
+// The backupmeta of a backup comes first, so that an interrupted purge never leaves a broken backup.
+func expiredObjects(objects *backupObjects, expired []string) []string {
+	expiredDirs := make(map[string]struct{}, len(expired))
+	for _, dir := range expired {
+		expiredDirs[dir] = struct{}{}
+	}
+	dirs := append(append([]string{}, objects.metaDirs...), objects.checkpointDirs...)
+	var metas, others []string
+	for name := range objects.sizes {
+		owner := ""
+		for _, dir := range dirs {
+			if isUnder(name, dir) && (len(owner) == 0 || owner == "." || len(dir) > len(owner)) {
+				owner = dir
+			}
+		}
+		if _, ok := expiredDirs[owner]; !ok {
+			continue
+		}
+		if path.Base(name) == metautil.MetaFile {
+			metas = append(metas, name)
+		} else {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(metas)
+	sort.Strings(others)
+	return append(metas, others...)
+}
+
+// parentDir returns the directory of the parent backup of the backup in the storage, it's empty
+// if the parent is outside of the storage.
+func parentDir(rootURL, backupURL string, parent *metautil.Parent) string {
+	parentURL := parent.Storage
+	if len(parent.RelativeStorage) > 0 {
+		if u, err := storage.ResolveURL(backupURL, parent.RelativeStorage); err == nil {
+			parentURL = u
+		}
+	}
+	rel, ok := storage.RelativeURL(rootURL, parentURL)
+	if !ok {
+		return ""
+	}
+	rel = path.Clean(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return ""
+	}
+	return rel
+}
+
+// readPurgeBackup reads the backup ts and the parent of the backup in the directory of the storage.
+func readPurgeBackup(ctx context.Context, cfg *Config, dir string) (*PurgeBackup, error) {
+	backupCfg := *cfg
+	if dir != "." {
+		var err error
+		if backupCfg.Storage, err = storage.ResolveURL(cfg.Storage, dir); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	_, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &backupCfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	b := &PurgeBackup{Dir: dir, BackupTS: backupMeta.EndVersion}
+	parent, err := metautil.ReadParent(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if parent != nil {
+		b.Parent = parentDir(cfg.Storage, backupCfg.Storage, parent)
+	}
+	return b, nil
+}
+
+// RunPurge deletes the backups under the storage expired by the retention policy, or lists them
+// only with --dry-run.
+func RunPurge(c context.Context, cmdName string, cfg *PurgeConfig) (*PurgeReport, error) {
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	if err := cfg.ResolveStorageByName(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(cfg.Storage) == 0 {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, "--storage or --name is required to purge the backups")
+	}
+	if len(cfg.StorageFailover) > 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "--%s can't be used to purge the backups", flagStorageFailover)
+	}
+	_, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	objects, err := scanBackupObjects(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	report := &PurgeReport{}
+	for _, dir := range objects.metaDirs {
+		b, err := readPurgeBackup(ctx, &cfg.Config, dir)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to read the backup in %s", dir)
+		}
+		report.Backups = append(report.Backups, b)
+	}
+	planPurge(report.Backups, cfg.KeepLast, cfg.KeepWithin, time.Now())
+	for _, b := range report.Backups {
+		if len(b.Keep) == 0 {
+			report.Expired = append(report.Expired, b.Dir)
+		} else {
+			log.Info("keep the backup", zap.String("dir", b.Dir), zap.Uint64("backup-ts", b.BackupTS), zap.String("reason", b.Keep))
+		}
+	}
+	toDelete := expiredObjects(objects, report.Expired)
+	for _, name := range toDelete {
+		report.ExpiredSize += objects.sizes[name]
+	}
+	summary.CollectInt("backups", len(report.Backups))
+	summary.CollectInt("expired backups", len(report.Expired))
+	if !cfg.DryRun {
+		for _, name := range toDelete {
+			if err = s.DeleteFile(ctx, name); err != nil {
+				return nil, errors.Trace(err)
+			}
+			report.Deleted = append(report.Deleted, name)
+		}
+		summary.CollectInt("deleted objects", len(report.Deleted))
+	}
+	summary.SetSuccessStatus(true)
+	return report, nil
+}
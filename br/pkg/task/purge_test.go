@@ -0,0 +1,118 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/migration/br/pkg/metautil"
+)
+
+func TestParseRetention(t *testing.T) {
+	d, err := parseRetention("30d")
+	require.NoError(t, err)
+	require.Equal(t, 30*24*time.Hour, d)
+	d, err = parseRetention("12h")
+	require.NoError(t, err)
+	require.Equal(t, 12*time.Hour, d)
+	for _, s := range []string{"d", "-1d", "1.5d", "-1h", "30"} {
+		_, err = parseRetention(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestPlanPurge(t *testing.T) {
+	now := time.Date(2022, 6, 30, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) uint64 {
+		return oracle.GoTimeToTS(now.Add(-time.Duration(days) * 24 * time.Hour))
+	}
+	newBackups := func() []*PurgeBackup {
+		return []*PurgeBackup{
+			{Dir: "full-1", BackupTS: daysAgo(60)},
+			{Dir: "inc-1", BackupTS: daysAgo(50), Parent: "full-1"},
+			{Dir: "full-2", BackupTS: daysAgo(40)},
+			{Dir: "inc-2", BackupTS: daysAgo(20), Parent: "full-2"},
+			{Dir: "inc-3", BackupTS: daysAgo(10), Parent: "inc-2"},
+			{Dir: "unknown", BackupTS: 0},
+		}
+	}
+	keeps := func(backups []*PurgeBackup) map[string]string {
+		m := make(map[string]string, len(backups))
+		for _, b := range backups {
+			m[b.Dir] = b.Keep
+		}
+		return m
+	}
+
+	backups := newBackups()
+	planPurge(backups, 0, 15*24*time.Hour, now)
+	require.Equal(t, "inc-3", backups[0].Dir)
+	require.Equal(t, map[string]string{
+		"full-1":  "",
+		"inc-1":   "",
+		"full-2":  "parent of inc-3",
+		"inc-2":   "parent of inc-3",
+		"inc-3":   "latest",
+		"unknown": "unknown backup ts",
+	}, keeps(backups))
+
+	backups = newBackups()
+	planPurge(backups, 4, 0, now)
+	require.Equal(t, map[string]string{
+		"full-1":  "parent of inc-1",
+		"inc-1":   flagKeepLast,
+		"full-2":  flagKeepLast,
+		"inc-2":   flagKeepLast,
+		"inc-3":   "latest",
+		"unknown": "unknown backup ts",
+	}, keeps(backups))
+
+	// the latest backup is always kept.
+	backups = newBackups()
+	planPurge(backups, 0, time.Hour, now)
+	require.Equal(t, "latest", keeps(backups)["inc-3"])
+	require.Equal(t, "parent of inc-3", keeps(backups)["full-2"])
+}
+
+func TestExpiredObjects(t *testing.T) {
+	objects := &backupObjects{
+		sizes: map[string]int64{
+			metautil.MetaFile: 1, "1.sst": 1,
+			"old/" + metautil.MetaFile: 1, "old/1.sst": 1, "old/data/2.sst": 1,
+			"old/nested/" + metautil.MetaFile: 1, "old/nested/3.sst": 1,
+			"old/running/checkpoint.meta": 1, "old/running/4.sst": 1,
+			"older/" + metautil.MetaFile: 1, "older/5.sst": 1,
+		},
+		metaDirs:       []string{".", "old", "old/nested", "older"},
+		checkpointDirs: []string{"old/running"},
+	}
+	require.Equal(t, []string{
+		"old/" + metautil.MetaFile, "older/" + metautil.MetaFile,
+		"old/1.sst", "old/data/2.sst", "older/5.sst",
+	}, expiredObjects(objects, []string{"old", "older"}))
+	require.Equal(t, []string{metautil.MetaFile, "1.sst"}, expiredObjects(objects, []string{"."}))
+}
+
+func TestParentDir(t *testing.T) {
+	root := "s3://bucket/backups?region=us-east-1"
+	backupURL := "s3://bucket/backups/inc?region=us-east-1"
+	require.Equal(t, "full", parentDir(root, backupURL, &metautil.Parent{RelativeStorage: "../full"}))
+	require.Equal(t, "full", parentDir(root, backupURL, &metautil.Parent{Storage: "s3://bucket/backups/full?region=us-east-1"}))
+	require.Empty(t, parentDir(root, backupURL, &metautil.Parent{RelativeStorage: "../../elsewhere"}))
+	require.Empty(t, parentDir(root, backupURL, &metautil.Parent{Storage: "gcs://bucket/full"}))
+}
@@ -16,6 +16,7 @@ package task
 
 import (
 	"bytes"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,7 +24,11 @@ import (
 	backuppb "github.com/pingcap/kvproto/pkg/brpb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/spf13/pflag"
+	"github.com/tikv/migration/br/pkg/backup"
+	"github.com/tikv/migration/br/pkg/conn"
 	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/rtree"
 	"github.com/tikv/migration/br/pkg/utils"
 )
 
@@ -38,6 +43,93 @@ type RawKvConfig struct {
 	RemoveSchedulers bool          `json:"remove-schedulers" toml:"remove-schedulers"`
 	SafeInterval     time.Duration `json:"safe-interval" toml:"safe-interval"`
 	GCTTL            time.Duration `json:"gc-ttl" toml:"gc-ttl"`
+	// GCSafePointMargin is the margin of the backup ts to the GC safe point, the safe points
+	// conflicting with the backup are reported before it sets the GC safe point.
+	GCSafePointMargin time.Duration `json:"gc-safepoint-margin" toml:"gc-safepoint-margin"`
+	// SetupLifecycle sets up a bucket lifecycle rule scoped to the backup prefix,
+	// so that the backup expires after RetentionDays days.
+	SetupLifecycle bool  `json:"setup-lifecycle" toml:"setup-lifecycle"`
+	RetentionDays  int64 `json:"retention-days" toml:"retention-days"`
+	// UnsafeTS backups the snapshot at the max safe-ts reported by the stores instead of a TSO of PD,
+	// for the disasters in which the TSO is unavailable. The backup may be inconsistent, the caveats
+	// are recorded in metautil.UnsafeTSFile.
+	UnsafeTS bool `json:"unsafe-ts" toml:"unsafe-ts"`
+	// FineGrainedMaxRounds and FineGrainedTimeout limit the fine grained backup,
+	// after which the remaining ranges fall back to push down backup.
+	FineGrainedMaxRounds int           `json:"fine-grained-max-rounds" toml:"fine-grained-max-rounds"`
+	FineGrainedTimeout   time.Duration `json:"fine-grained-timeout" toml:"fine-grained-timeout"`
+	// FineGrainedMaxWorkers caps the regions retried concurrently in the fine grained backup.
+	FineGrainedMaxWorkers int `json:"fine-grained-max-workers" toml:"fine-grained-max-workers"`
+	// TransferLeader transfers the leaders off the store failing a range when the range is retried.
+	TransferLeader bool `json:"transfer-leader-on-retry" toml:"transfer-leader-on-retry"`
+	// SkipStores and SkipStoreLabels select the stores the backup isn't pushed down to, they are
+	// pushed down to after the other stores instead if DeferSkippedStores.
+	SkipStores         []uint64 `json:"skip-stores" toml:"skip-stores"`
+	SkipStoreLabels    []string `json:"skip-store-labels" toml:"skip-store-labels"`
+	DeferSkippedStores bool     `json:"defer-skipped-stores" toml:"defer-skipped-stores"`
+	// StuckRangeTimeout is the max time a backup stream goes without any response before dispatched again.
+	StuckRangeTimeout time.Duration `json:"stuck-range-timeout" toml:"stuck-range-timeout"`
+	// Backoff is the backoff policies of retrying the backup, the default if nil.
+	Backoff *backup.BackoffConfig `json:"backoff" toml:"backoff"`
+	// TotalThroughput is the throughput of the whole backup in bytes/s, from which the rate limit
+	// and concurrency per store are derived. 0 means the rate limit is set per store directly.
+	TotalThroughput uint64 `json:"total-throughput" toml:"total-throughput"`
+	// EstimateCompression samples SampleRegions regions to estimate the compression
+	// ratios instead of running the backup.
+	EstimateCompression bool `json:"estimate-compression" toml:"estimate-compression"`
+	SampleRegions       int  `json:"sample-regions" toml:"sample-regions"`
+	// StorageTemplate is the templated --storage expanded into Storage by the backup.
+	StorageTemplate string `json:"storage-template" toml:"storage-template"`
+	// DryRun estimates the backup by the region stats of PD instead of running it.
+	DryRun bool `json:"dry-run" toml:"dry-run"`
+	// FileLayout is the template of the prefixes of the data files in the storage.
+	FileLayout string `json:"file-layout" toml:"file-layout"`
+	// Clusters are the source clusters backed up concurrently instead of PD, ClusterConcurrency
+	// at a time.
+	Clusters           []ClusterSpec `json:"clusters" toml:"clusters"`
+	ClusterConcurrency uint          `json:"cluster-concurrency" toml:"cluster-concurrency"`
+	// IncludePrefixes and ExcludePrefixes select the keys to backup within [StartKey, EndKey).
+	IncludePrefixes [][]byte `json:"include-prefixes" toml:"include-prefixes"`
+	ExcludePrefixes [][]byte `json:"exclude-prefixes" toml:"exclude-prefixes"`
+	// VerifyRanges verifies the checksum of each backed up range against the cluster after the backup.
+	VerifyRanges bool `json:"verify-ranges" toml:"verify-ranges"`
+	// LastBackupTS is the start ts of an incremental backup, which defaults to the backup ts
+	// of ParentStorage, the previous backup of the chain linked by the incremental backup.
+	LastBackupTS  uint64 `json:"last-backup-ts" toml:"last-backup-ts"`
+	ParentStorage string `json:"parent-storage" toml:"parent-storage"`
+	// BackupPoints are the ts the cluster is backed up at in one run, the later points are
+	// incremental backups of the earlier ones. See runBackupPoints.
+	BackupPoints []uint64 `json:"backup-points" toml:"backup-points"`
+	// Resume resumes the interrupted backup from the checkpoint persisted every CheckpointInterval.
+	Resume             bool          `json:"resume" toml:"resume"`
+	CheckpointInterval time.Duration `json:"checkpoint-interval" toml:"checkpoint-interval"`
+	// AdoptNewClusterID continues the backup with the new cluster ID if the cluster is recreated.
+	AdoptNewClusterID bool `json:"adopt-new-cluster-id" toml:"adopt-new-cluster-id"`
+	// MetaCompression is the compression algorithm of backupmeta and the meta files.
+	MetaCompression string `json:"meta-compression" toml:"meta-compression"`
+	// MetaFlushItems and MetaFlushMemoryLimit decide when the file list is flushed into the
+	// meta files of the V2 meta besides their size.
+	MetaFlushItems       int    `json:"meta-flush-items" toml:"meta-flush-items"`
+	MetaFlushMemoryLimit uint64 `json:"meta-flush-memory-limit" toml:"meta-flush-memory-limit"`
+	// StorageMirror are the storages the backup is mirrored to, StorageMirrorPolicy decides
+	// how the failures of a mirror are handled.
+	StorageMirror       []string `json:"storage-mirror" toml:"storage-mirror"`
+	StorageMirrorPolicy string   `json:"storage-mirror-policy" toml:"storage-mirror-policy"`
+	// ChecksumAlgorithm is the algorithm of the checksums of the meta files and the parent backupmeta.
+	ChecksumAlgorithm string `json:"checksum-algorithm" toml:"checksum-algorithm"`
+	// HeatmapBuckets is the number of the buckets of the heatmap recorded aside backupmeta, 0 disables it.
+	HeatmapBuckets int `json:"heatmap-buckets" toml:"heatmap-buckets"`
+	// StatusInterval is the interval of publishing the progress into the storage and PD, 0 disables it.
+	StatusInterval time.Duration `json:"status-interval" toml:"status-interval"`
+	// UseBackupMetaV2 writes the file list into size bounded shards indexed by backupmeta.
+	UseBackupMetaV2 bool `json:"use-backupmeta-v2" toml:"use-backupmeta-v2"`
+	// KeyspaceName and KeyspaceID select an API V2 keyspace, KeyspaceName is resolved into KeyspaceID.
+	// The backup is scoped to the keys of the keyspace, and the restore maps the keys into it.
+	KeyspaceName string  `json:"keyspace-name" toml:"keyspace-name"`
+	KeyspaceID   *uint32 `json:"keyspace-id" toml:"keyspace-id"`
+
+	// snapshotTS is the ts a point of BackupPoints is backed up at, 0 means it isn't one.
+	snapshotTS uint64
 }
 
 // ParseBackupConfigFromFlags parses the backup-related flags from the flag set.
@@ -60,6 +152,170 @@ func (cfg *RawKvConfig) ParseBackupConfigFromFlags(flags *pflag.FlagSet) error {
 		return errors.Trace(err)
 	}
 	cfg.GCTTL = gcTTL
+	cfg.GCSafePointMargin, err = flags.GetDuration(flagGCSafePointMargin)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.SetupLifecycle, err = flags.GetBool(flagSetupLifecycle)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.RetentionDays, err = flags.GetInt64(flagRetentionDays)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.UnsafeTS, err = flags.GetBool(flagUnsafeTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.FineGrainedMaxRounds, err = flags.GetInt(flagFineGrainedMaxRounds)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.FineGrainedTimeout, err = flags.GetDuration(flagFineGrainedTimeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.FineGrainedMaxWorkers, err = flags.GetInt(flagFineGrainedWorkers)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.FineGrainedMaxWorkers <= 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "--fine-grained-max-workers must be positive")
+	}
+	cfg.TransferLeader, err = flags.GetBool(flagTransferLeader)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.parseSkipStores(flags); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.StuckRangeTimeout, err = flags.GetDuration(flagStuckRangeTimeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.parseBackoff(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.parseTotalThroughput(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.parseKeyspace(flags); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.EstimateCompression, err = flags.GetBool(flagEstimateCompression)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.SampleRegions, err = flags.GetInt(flagSampleRegions)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.DryRun, err = flags.GetBool(flagDryRun)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.DryRun && cfg.EstimateCompression {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s can't be used with --%s", flagDryRun, flagEstimateCompression)
+	}
+	if cfg.IncludePrefixes, err = parsePrefixes(flags, flagIncludePrefix); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ExcludePrefixes, err = parsePrefixes(flags, flagExcludePrefix); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.VerifyRanges, err = flags.GetBool(flagVerifyRanges)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.LastBackupTS, err = flags.GetUint64(flagLastBackupTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.ParentStorage, err = flags.GetString(flagParentStorage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.FileLayout, err = flags.GetString(flagFileLayout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = backup.CheckFileLayout(cfg.FileLayout); err != nil {
+		return errors.Annotatef(err, "invalid --%s", flagFileLayout)
+	}
+	if cfg.BackupPoints, err = parseBackupPoints(flags); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Resume, err = flags.GetBool(flagResume)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.CheckpointInterval, err = flags.GetDuration(flagCheckpointInterval)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.AdoptNewClusterID, err = flags.GetBool(flagAdoptNewClusterID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.UseBackupMetaV2, err = flags.GetBool(flagUseBackupMetaV2)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.MetaCompression, err = flags.GetString(flagMetaCompression)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = metautil.ParseMetaCompressionType(cfg.MetaCompression); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.MetaFlushItems, err = flags.GetInt(flagMetaFlushItems)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.MetaFlushItems < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must not be negative", flagMetaFlushItems)
+	}
+	cfg.MetaFlushMemoryLimit, err = flags.GetUint64(flagMetaFlushMemoryLimit)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.parseStorageMirror(flags); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.ChecksumAlgorithm, err = flags.GetString(flagChecksumAlgorithm)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = metautil.ParseChecksumAlgorithm(cfg.ChecksumAlgorithm); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.StatusInterval, err = flags.GetDuration(flagStatusInterval)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.HeatmapBuckets, err = flags.GetInt(flagHeatmapBuckets)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.HeatmapBuckets < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must not be negative", flagHeatmapBuckets)
+	}
+	if err = cfg.checkStorageTemplate(); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.parseClusters(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Resume && cfg.CheckpointInterval <= 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "--checkpoint-interval must be positive when --resume is set")
+	}
+	if cfg.EstimateCompression && cfg.SampleRegions <= 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "--sample-regions must be positive when --estimate-compression is set")
+	}
+	if cfg.SetupLifecycle && cfg.RetentionDays <= 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "--retention-days must be positive when --setup-lifecycle is set")
+	}
 
 	compressionCfg, err := cfg.parseCompressionFlags(flags)
 	if err != nil {
@@ -120,6 +376,75 @@ func (cfg *RawKvConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	return nil
 }
 
+// parsePrefixes parses the key prefixes of the flag in --format.
+func parsePrefixes(flags *pflag.FlagSet, name string) ([][]byte, error) {
+	values, err := flags.GetStringArray(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	format, err := flags.GetString(flagKeyFormat)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	prefixes := make([][]byte, 0, len(values))
+	for _, value := range values {
+		prefix, err := utils.ParseKey(format, value)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(prefix) == 0 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "--%s must not be empty", name)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// parseTotalThroughput parses --total-throughput in the unit of --ratelimit, which can't be
+// used with --ratelimit since the rate limit is derived from it.
+func (cfg *RawKvConfig) parseTotalThroughput(flags *pflag.FlagSet) error {
+	totalThroughput, err := flags.GetUint64(flagTotalThroughput)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	rateLimitUnit, err := flags.GetUint64(flagRateLimitUnit)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.TotalThroughput = totalThroughput * rateLimitUnit
+	if cfg.TotalThroughput > 0 && flags.Changed(flagRateLimit) {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s can't be used with --%s, the rate limit per node is derived from it", flagTotalThroughput, flagRateLimit)
+	}
+	return nil
+}
+
+// parseSkipStores parses the store IDs of --skip-stores and the labels of --skip-store-labels.
+func (cfg *RawKvConfig) parseSkipStores(flags *pflag.FlagSet) error {
+	storeIDs, err := flags.GetStringSlice(flagSkipStores)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.SkipStores = make([]uint64, 0, len(storeIDs))
+	for _, s := range storeIDs {
+		storeID, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+		if err != nil || storeID == 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "invalid store ID %q of --%s", s, flagSkipStores)
+		}
+		cfg.SkipStores = append(cfg.SkipStores, storeID)
+	}
+	if cfg.SkipStoreLabels, err = flags.GetStringSlice(flagSkipStoreLabels); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = conn.ParseStoreLabels(cfg.SkipStoreLabels); err != nil {
+		return errors.Annotatef(err, "invalid --%s", flagSkipStoreLabels)
+	}
+	if cfg.DeferSkippedStores, err = flags.GetBool(flagDeferSkippedStores); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
 func (cfg *RawKvConfig) parseDstAPIVersion(flags *pflag.FlagSet) error {
 	originalValue, err := flags.GetString(flagDstAPIVersion)
 	if err != nil {
@@ -173,9 +498,72 @@ func (cfg *RawKvConfig) parseCompressionType(s string) (backuppb.CompressionType
 	return ct, nil
 }
 
-func (cfg *RawKvConfig) adjustBackupRange(curAPIVersion kvrpcpb.APIVersion) {
+// parseBackoff parses the backoff policies overriding the default ones.
+func (cfg *RawKvConfig) parseBackoff(flags *pflag.FlagSet) error {
+	backoff := backup.DefaultBackoffConfig()
+	for _, p := range []struct {
+		flag   string
+		policy *utils.BackoffPolicy
+	}{
+		{flagBackoffRegionLeader, &backoff.RegionLeader},
+		{flagBackoffStream, &backoff.Stream},
+		{flagBackoffFineGrained, &backoff.FineGrained},
+	} {
+		s, err := flags.GetString(p.flag)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if *p.policy, err = utils.ParseBackoffPolicy(s, *p.policy); err != nil {
+			return errors.Annotatef(err, "invalid --%s", p.flag)
+		}
+	}
+	cfg.Backoff = &backoff
+	return nil
+}
+
+// metaFlushPolicy returns the policy of flushing the file list into the meta files.
+func (cfg *RawKvConfig) metaFlushPolicy() metautil.FlushPolicy {
+	policy := metautil.DefaultFlushPolicy()
+	policy.MaxItems = cfg.MetaFlushItems
+	policy.MemoryLimit = cfg.MetaFlushMemoryLimit
+	return policy
+}
+
+// adjustBackupRange converts the range into the format of curAPIVersion, the API V2 keys are
+// in the keyspace.
+func (cfg *RawKvConfig) adjustBackupRange(curAPIVersion kvrpcpb.APIVersion, keyspaceID uint32) {
 	if curAPIVersion == kvrpcpb.APIVersion_V2 {
-		keyRange := utils.FormatAPIV2KeyRange(cfg.StartKey, cfg.EndKey)
+		keyRange := utils.FormatKeyspaceKeyRange(keyspaceID, cfg.StartKey, cfg.EndKey)
 		cfg.StartKey, cfg.EndKey = keyRange.Start, keyRange.End
 	}
 }
+
+// prefixRanges returns the key ranges of the prefixes in the format of curAPIVersion, the API V2
+// keys are in the keyspace.
+func prefixRanges(prefixes [][]byte, curAPIVersion kvrpcpb.APIVersion, keyspaceID uint32) []rtree.Range {
+	ranges := make([]rtree.Range, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		rg := rtree.Range{StartKey: prefix, EndKey: utils.PrefixNext(prefix)}
+		if curAPIVersion == kvrpcpb.APIVersion_V2 {
+			keyRange := utils.FormatKeyspaceKeyRange(keyspaceID, rg.StartKey, rg.EndKey)
+			rg.StartKey, rg.EndKey = keyRange.Start, keyRange.End
+		}
+		ranges = append(ranges, rg)
+	}
+	return ranges
+}
+
+// backupRanges returns the sorted and merged ranges to backup, which are the parts of [StartKey, EndKey)
+// with the included prefixes and without the excluded prefixes. It must be called after adjustBackupRange.
+func (cfg *RawKvConfig) backupRanges(curAPIVersion kvrpcpb.APIVersion) ([]rtree.Range, error) {
+	ranges := []rtree.Range{{StartKey: cfg.StartKey, EndKey: cfg.EndKey}}
+	if len(cfg.IncludePrefixes) > 0 {
+		ranges = rtree.Intersection(ranges, prefixRanges(cfg.IncludePrefixes, curAPIVersion, cfg.keyspaceID()))
+	}
+	ranges = rtree.Subtract(ranges, prefixRanges(cfg.ExcludePrefixes, curAPIVersion, cfg.keyspaceID()))
+	if len(ranges) == 0 {
+		return nil, errors.Annotate(berrors.ErrBackupInvalidRange,
+			"no key to backup, the prefixes don't overlap with the backup range or are all excluded")
+	}
+	return ranges, nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tikv/migration/br/pkg/backup"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/summary"
+	"go.uber.org/zap"
+)
+
+const flagReconcileCleanup = "cleanup"
+
+// sideFiles are the files written by br beside the files referenced by the backupmeta.
+var sideFiles = []string{
+	metautil.MetaFile,
+	metautil.MetaJSONFile,
+	metautil.LockFile,
+	metautil.TopologyFile,
+	metautil.KeyspacesFile,
+	metautil.KeyspaceScopeFile,
+	metautil.ChecksumFile,
+	metautil.ParentFile,
+	metautil.LocationsFile,
+	metautil.HeatmapFile,
+	metautil.UnsafeTSFile,
+	metautil.EncryptionFile,
+	metautil.BackupResultFile,
+	metautil.RestoreResultFile,
+	metautil.PartialRestoreFile,
+	backup.CheckpointFile,
+}
+
+// ReconcileConfig is the configuration specific for `br reconcile`.
+type ReconcileConfig struct {
+	Config
+
+	Cleanup bool `json:"cleanup" toml:"cleanup"`
+}
+
+// DefineReconcileFlags defines the flags of `br reconcile`.
+func DefineReconcileFlags(command *cobra.Command) {
+	command.Flags().Bool(flagReconcileCleanup, false,
+		"Delete the SST files and meta files which are not referenced by the backupmeta")
+}
+
+// ParseFromFlags parses the config from the flag set.
+func (cfg *ReconcileConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.Cleanup, err = flags.GetBool(flagReconcileCleanup); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// ReconcileReport is the difference between the objects in the storage and the files recorded in the backupmeta.
+type ReconcileReport struct {
+	// Unreferenced are the objects not referenced by the backupmeta, usually leaked by crashed backups.
+	Unreferenced []string
+	// UnreferencedSize is the total size of the unreferenced objects.
+	UnreferencedSize int64
+	// Missing are the files referenced by the backupmeta but absent in the storage.
+	Missing []string
+	// Deleted are the unreferenced objects deleted by the cleanup.
+	Deleted []string
+}
+
+// isLeakedOutput returns whether the unreferenced object is written by a backup, which is safe to delete.
+// Other unknown objects are reported only.
+func isLeakedOutput(name string) bool {
+	return strings.HasSuffix(name, ".sst") || strings.HasPrefix(path.Base(name), metautil.MetaFile+".")
+}
+
+// Reconcile compares the objects in the storage with the referenced files.
+func Reconcile(ctx context.Context, s storage.ExternalStorage, referenced []string) (*ReconcileReport, error) {
+	expected := make(map[string]struct{}, len(referenced)+len(sideFiles))
+	for _, name := range referenced {
+		expected[strings.TrimPrefix(name, "/")] = struct{}{}
+	}
+	known := make(map[string]struct{}, len(sideFiles))
+	for _, name := range sideFiles {
+		// the side files may be compressed by their suffixes.
+		for _, compressed := range storage.CompressedNames(name) {
+			known[compressed] = struct{}{}
+		}
+	}
+
+	report := &ReconcileReport{}
+	err := s.WalkDir(ctx, &storage.WalkOption{}, func(name string, size int64) error {
+		name = strings.TrimPrefix(name, "/")
+		if _, ok := expected[name]; ok {
+			delete(expected, name)
+			return nil
+		}
+		if _, ok := known[name]; ok {
+			return nil
+		}
+		report.Unreferenced = append(report.Unreferenced, name)
+		report.UnreferencedSize += size
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for name := range expected {
+		report.Missing = append(report.Missing, name)
+	}
+	sort.Strings(report.Unreferenced)
+	sort.Strings(report.Missing)
+	return report, nil
+}
+
+// CleanupLeaked deletes the unreferenced objects written by backups in the report.
+func CleanupLeaked(ctx context.Context, s storage.ExternalStorage, report *ReconcileReport) error {
+	for _, name := range report.Unreferenced {
+		if !isLeakedOutput(name) {
+			log.Info("skip deleting unknown object", zap.String("name", name))
+			continue
+		}
+		if err := s.DeleteFile(ctx, name); err != nil {
+			return errors.Trace(err)
+		}
+		report.Deleted = append(report.Deleted, name)
+	}
+	return nil
+}
+
+// RunReconcile reconciles the objects under the backup storage with the backupmeta.
+func RunReconcile(c context.Context, cmdName string, cfg *ReconcileConfig) (*ReconcileReport, error) {
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	if err := cfg.ResolveStorageByName(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(cfg.Storage) == 0 {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, "--storage or --name is required to reconcile a backup")
+	}
+	_, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &cfg.Config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	referenced, err := metautil.NewMetaReader(backupMeta, s, &cfg.CipherInfo).ReferencedFiles(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	report, err := Reconcile(ctx, s, referenced)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	summary.CollectInt("unreferenced objects", len(report.Unreferenced))
+	summary.CollectInt("missing objects", len(report.Missing))
+	if len(report.Missing) > 0 {
+		log.Warn("some files of the backup are missing, the backup can't be restored",
+			zap.Int("missing-count", len(report.Missing)))
+	}
+	if cfg.Cleanup {
+		if err = CleanupLeaked(ctx, s, report); err != nil {
+			return nil, errors.Trace(err)
+		}
+		summary.CollectInt("deleted objects", len(report.Deleted))
+	}
+	summary.SetSuccessStatus(true)
+	return report, nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	for _, name := range []string{metautil.MetaFile, "1.sst", "2.sst", "leaked.sst", "backupmeta.datafile.000000002", "notes.txt"} {
+		require.NoError(t, s.WriteFile(ctx, name, []byte(name)))
+	}
+
+	report, err := Reconcile(ctx, s, []string{"1.sst", "/2.sst", "3.sst"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"backupmeta.datafile.000000002", "leaked.sst", "notes.txt"}, report.Unreferenced)
+	require.Equal(t, []string{"3.sst"}, report.Missing)
+
+	// unknown objects are never deleted.
+	require.NoError(t, CleanupLeaked(ctx, s, report))
+	require.Equal(t, []string{"backupmeta.datafile.000000002", "leaked.sst"}, report.Deleted)
+	for name, expected := range map[string]bool{"leaked.sst": false, "notes.txt": true, "1.sst": true} {
+		exists, err := s.FileExists(ctx, name)
+		require.NoError(t, err)
+		require.Equal(t, expected, exists)
+	}
+}
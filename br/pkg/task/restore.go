@@ -17,6 +17,8 @@ import (
 const (
 	flagOnline   = "online"
 	flagNoSchema = "no-schema"
+	// flagIngestRateLimit limits the bytes restored into each store per second.
+	flagIngestRateLimit = "ingest-ratelimit"
 
 	// FlagMergeRegionSizeBytes is the flag name of merge small regions by size
 	FlagMergeRegionSizeBytes = "merge-region-size-bytes"
@@ -26,10 +28,16 @@ const (
 	FlagPDConcurrency = "pd-concurrency"
 	// FlagBatchFlushInterval controls after how long the restore batch would be auto sended.
 	FlagBatchFlushInterval = "batch-flush-interval"
+	// FlagPrecheckSampleKeys controls how many existing keys are sampled from each target range before restore.
+	FlagPrecheckSampleKeys = "precheck-sample-keys"
+	// flagMaxRegionsPerStore fails the restore if a store would have more regions after splitting.
+	flagMaxRegionsPerStore = "max-regions-per-store"
 
 	defaultRestoreConcurrency = 512
 	defaultPDConcurrency      = 1
 	defaultBatchFlushInterval = 16 * time.Second
+	// the target ranges aren't probed by default, since probing scans each of them.
+	defaultPrecheckSampleKeys = 0
 )
 
 // DefineRestoreCommonFlags defines common flags for the restore command.
@@ -45,6 +53,14 @@ func DefineRestoreCommonFlags(flags *pflag.FlagSet) {
 		"concurrency pd-relative operations like split & scatter.")
 	flags.Duration(FlagBatchFlushInterval, defaultBatchFlushInterval,
 		"after how long a restore batch would be auto sended.")
+	flags.Uint(FlagPrecheckSampleKeys, defaultPrecheckSampleKeys,
+		"the number of existing keys sampled from each target range to warn about non-empty ranges before restore. "+
+			"Probing scans each target range, so it's disabled by 0 (default), e.g. set it to 1 to enable it.")
+	flags.Uint(flagMaxRegionsPerStore, 0,
+		"fail the restore before splitting if any store is estimated to have more regions than it afterwards, 0 means unlimited.")
+	flags.Uint64(flagIngestRateLimit, unlimited,
+		"The rate limit of the files restored into each store, MB/s per store, so that the restore doesn't starve "+
+			"the foreground traffic. It's adjustable by the status server, which pauses and resumes the restore as well.")
 	_ = flags.MarkHidden(flagOnline)
 	_ = flags.MarkHidden(FlagMergeRegionSizeBytes)
 	_ = flags.MarkHidden(FlagMergeRegionKeyCount)
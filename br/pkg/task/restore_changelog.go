@@ -0,0 +1,125 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package task
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/summary"
+	"go.uber.org/zap"
+)
+
+// changelogReplay is the changelog replayed after restoring the backup.
+type changelogReplay struct {
+	storage storage.ExternalStorage
+	// startTS is the backup ts, the changes after which are replayed.
+	startTS    uint64
+	restoredTS uint64
+}
+
+// backupTSOf returns the backup ts of the backup in s, it's 0 if the backup doesn't record it.
+func backupTSOf(ctx context.Context, s storage.ExternalStorage, backupMeta *backuppb.BackupMeta) (uint64, error) {
+	if backupMeta.EndVersion > 0 {
+		return backupMeta.EndVersion, nil
+	}
+	// the backup ts of a backup of the latest data is only recorded in the result artifact.
+	result, err := metautil.ReadResult(ctx, s, metautil.BackupResultFile)
+	if err != nil || result == nil {
+		return 0, errors.Trace(err)
+	}
+	return result.BackupTS, nil
+}
+
+// prepareChangelogReplay checks the changelog of ChangelogStorage covers the changes from the
+// backup ts of the backup in s to RestoredTS. It returns nil if ChangelogStorage is not set.
+func prepareChangelogReplay(
+	ctx context.Context, cfg *RestoreRawConfig, s storage.ExternalStorage, backupMeta *backuppb.BackupMeta,
+) (*changelogReplay, error) {
+	if len(cfg.ChangelogStorage) == 0 {
+		return nil, nil
+	}
+	if backupMeta.ApiVersion != kvrpcpb.APIVersion_V2 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s requires an API V2 backup, the api version of the backup is %s", flagChangelogStorage, backupMeta.ApiVersion)
+	}
+	startTS, err := backupTSOf(ctx, s, backupMeta)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if startTS == 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the backup doesn't record its backup ts, which the changelog is replayed from")
+	}
+	changelogStorage, err := storage.NewFromURL(ctx, cfg.ChangelogStorage, &cfg.BackendOptions, storageOpts(&cfg.Config))
+	if err != nil {
+		return nil, errors.Annotate(err, "create changelog storage failed")
+	}
+	meta, err := metautil.ReadChangelogMeta(ctx, changelogStorage)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if meta == nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"%s is not found in --%s, it's not a changelog storage", metautil.ChangelogMetaFile, flagChangelogStorage)
+	}
+	if meta.StartTS == 0 || meta.StartTS > startTS {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the changelog starts from %d after the backup ts %d, the changes in between are missing", meta.StartTS, startTS)
+	}
+	restoredTS := cfg.RestoredTS
+	if restoredTS == 0 {
+		restoredTS = meta.CheckpointTS
+	}
+	if restoredTS < startTS {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the restored ts %d is before the backup ts %d", restoredTS, startTS)
+	}
+	if restoredTS > meta.CheckpointTS {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the restored ts %d is after the checkpoint ts %d of the changelog, please wait for the changefeed to catch up",
+			restoredTS, meta.CheckpointTS)
+	}
+	log.Info("restore to a point in time by the changelog", zap.Uint64("backup-ts", startTS),
+		zap.Uint64("restored-ts", restoredTS), zap.Uint64("changelog-checkpoint-ts", meta.CheckpointTS))
+	return &changelogReplay{storage: changelogStorage, startTS: startTS, restoredTS: restoredTS}, nil
+}
+
+// replay applies the changes of the changelog in the restore range to the target cluster.
+// It does nothing if c is nil.
+func (c *changelogReplay) replay(ctx context.Context, cfg *RestoreRawConfig) error {
+	if c == nil {
+		return nil
+	}
+	replayer, err := restore.NewChangelogReplayer(ctx, cfg.PD, cfg.TLS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer replayer.Close()
+	stats, err := replayer.Replay(ctx, c.storage, cfg.StartKey, cfg.EndKey, c.startTS, c.restoredTS)
+	if err != nil {
+		return errors.Annotate(err, "replay the changelog failed")
+	}
+	summary.CollectUint("restored ts", c.restoredTS)
+	summary.CollectInt("changelog files", stats.Files)
+	summary.CollectInt("changelog events", stats.Events)
+	return nil
+}
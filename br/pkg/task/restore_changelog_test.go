@@ -0,0 +1,94 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package task
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/oracle"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestParseTSString(t *testing.T) {
+	ts, err := parseTSString("400036290571534337")
+	require.NoError(t, err)
+	require.Equal(t, uint64(400036290571534337), ts)
+
+	ts, err = parseTSString("2018-05-11 01:42:23")
+	require.NoError(t, err)
+	expected := time.Date(2018, 5, 11, 1, 42, 23, 0, time.Local)
+	require.Equal(t, expected.UnixMilli(), oracle.ExtractPhysical(ts))
+
+	_, err = parseTSString("yesterday")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
+
+func TestPrepareChangelogReplay(t *testing.T) {
+	ctx := context.Background()
+	backup, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	changelogDir := t.TempDir()
+	changelog, err := storage.NewLocalStorage(changelogDir)
+	require.NoError(t, err)
+	require.NoError(t, changelog.WriteFile(ctx, metautil.ChangelogMetaFile,
+		[]byte(`{"version":1,"start-ts":90,"checkpoint-ts":300}`)))
+
+	backupMeta := &backuppb.BackupMeta{ApiVersion: kvrpcpb.APIVersion_V2}
+	cfg := &RestoreRawConfig{}
+	replay, err := prepareChangelogReplay(ctx, cfg, backup, backupMeta)
+	require.NoError(t, err)
+	require.Nil(t, replay)
+
+	cfg.ChangelogStorage = "local://" + changelogDir
+	// the backup ts is not recorded.
+	_, err = prepareChangelogReplay(ctx, cfg, backup, backupMeta)
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+
+	// the backup ts of a backup of the latest data is read from the result artifact.
+	result := metautil.NewResult("Raw backup")
+	result.BackupTS = 100
+	require.NoError(t, metautil.WriteResult(ctx, backup, metautil.BackupResultFile, result))
+	replay, err = prepareChangelogReplay(ctx, cfg, backup, backupMeta)
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), replay.startTS)
+	require.Equal(t, uint64(300), replay.restoredTS)
+
+	cfg.RestoredTS = 200
+	replay, err = prepareChangelogReplay(ctx, cfg, backup, backupMeta)
+	require.NoError(t, err)
+	require.Equal(t, uint64(200), replay.restoredTS)
+
+	for _, restoredTS := range []uint64{99, 301} {
+		cfg.RestoredTS = restoredTS
+		_, err = prepareChangelogReplay(ctx, cfg, backup, backupMeta)
+		require.True(t, berrors.Is(err, berrors.ErrInvalidArgument), restoredTS)
+	}
+	cfg.RestoredTS = 0
+
+	// the changelog starts after the backup.
+	backupMeta.EndVersion = 80
+	_, err = prepareChangelogReplay(ctx, cfg, backup, backupMeta)
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+
+	backupMeta.ApiVersion = kvrpcpb.APIVersion_V1
+	_, err = prepareChangelogReplay(ctx, cfg, backup, backupMeta)
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
@@ -29,6 +29,17 @@ type RestoreCommonConfig struct {
 	// See https://github.com/tikv/tikv/blob/v4.0.8/components/raftstore/src/coprocessor/config.rs#L35-L38
 	MergeSmallRegionSizeBytes uint64 `json:"merge-region-size-bytes" toml:"merge-region-size-bytes"`
 	MergeSmallRegionKeyCount  uint64 `json:"merge-region-key-count" toml:"merge-region-key-count"`
+
+	// PrecheckSampleKeys is the number of existing keys sampled from each target range
+	// to detect pre-existing data before ingesting. 0, the default, disables the check.
+	PrecheckSampleKeys uint `json:"precheck-sample-keys" toml:"precheck-sample-keys"`
+
+	// MaxRegionsPerStore is the most regions each store is estimated to have after splitting
+	// the target regions, 0 means unlimited.
+	MaxRegionsPerStore uint `json:"max-regions-per-store" toml:"max-regions-per-store"`
+
+	// IngestRateLimit is the bytes/s of the files restored into each store, 0 means unlimited.
+	IngestRateLimit uint64 `json:"ingest-rate-limit" toml:"ingest-rate-limit"`
 }
 
 // ParseFromFlags parses the config from the flag set.
@@ -46,6 +57,22 @@ func (cfg *RestoreCommonConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	cfg.PrecheckSampleKeys, err = flags.GetUint(FlagPrecheckSampleKeys)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.MaxRegionsPerStore, err = flags.GetUint(flagMaxRegionsPerStore)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var ingestRateLimit, rateLimitUnit uint64
+	if ingestRateLimit, err = flags.GetUint64(flagIngestRateLimit); err != nil {
+		return errors.Trace(err)
+	}
+	if rateLimitUnit, err = flags.GetUint64(flagRateLimitUnit); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.IngestRateLimit = ingestRateLimit * rateLimitUnit
 	return errors.Trace(err)
 }
 
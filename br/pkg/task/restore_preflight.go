@@ -0,0 +1,209 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/conn"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/version"
+	"go.uber.org/zap"
+)
+
+// restorePreflight verifies the target cluster is able to take the restore before anything is
+// written into it, so that the restore fails fast with all the problems at once instead of in
+// the middle of ingesting.
+type restorePreflight struct {
+	cfg    *RestoreRawConfig
+	client *restore.Client
+	mgr    *conn.Mgr
+
+	srcAPIVersion  kvrpcpb.APIVersion
+	dstAPIVersion  kvrpcpb.APIVersion
+	targetKeyspace uint32
+
+	files       []*backuppb.File
+	ranges      []rtree.Range
+	chain       []*restore.RawBackup
+	chainRanges [][]rtree.Range
+}
+
+// run runs all the checks. It only returns an error if the cluster can't be inspected, the failed
+// checks are reported by the Err of the report.
+func (p *restorePreflight) run(ctx context.Context) (*restore.PreflightReport, error) {
+	ctx = logutil.ContextWithPhase(ctx, "preflight")
+	report := &restore.PreflightReport{}
+	// the incompatible api versions fail the restore before reading the files of the backup.
+	if p.srcAPIVersion == p.dstAPIVersion {
+		report.Pass(restore.PreflightAPIVersion, "the backup and the cluster are of api version %s", p.dstAPIVersion)
+	} else {
+		report.Pass(restore.PreflightAPIVersion, "the backup of api version %s is converted into %s",
+			p.srcAPIVersion, p.dstAPIVersion)
+	}
+
+	p.checkTiKVVersion(ctx, report)
+	replication, err := p.mgr.GetReplicationConfig(ctx)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to get the replication config")
+	}
+	stores, err := p.stores(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	report.Add(restore.CheckStoreStates(stores, replication.MaxReplicas))
+	report.Add(restore.CheckStoreVersions(stores))
+
+	if p.client.IsOnline() {
+		report.Pass(restore.PreflightSchedulers, "the schedulers are kept in online restore")
+	} else {
+		schedulers, err := p.mgr.ListSchedulers(ctx)
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to list the schedulers")
+		}
+		report.Add(restore.CheckSchedulers(schedulers))
+	}
+
+	available := make(map[uint64]uint64)
+	impact, err := restoreImpact(ctx, p.client, p.mgr, p.files, p.ranges, p.chain, p.chainRanges, available)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to estimate the impact of the restore")
+	}
+	report.Add(restore.CheckRegionCount(stores, impact.SplitKeys, replication.MaxReplicas, int(p.cfg.MaxRegionsPerStore)))
+	p.checkDiskSpace(report, impact, available)
+
+	if err = p.checkOverlap(ctx, report); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return report, nil
+}
+
+// checkTiKVVersion checks the stores are new enough for BR. It's checked on connecting to the
+// cluster too, unless --check-requirements=false, in which case a too old store is only warned.
+func (p *restorePreflight) checkTiKVVersion(ctx context.Context, report *restore.PreflightReport) {
+	err := version.CheckClusterVersion(ctx, p.mgr.GetPDClient(), version.CheckVersionForBR)
+	switch {
+	case err == nil:
+		return
+	case p.cfg.CheckRequirements:
+		report.Fail(restore.PreflightTiKVVersion, "%v", err)
+	default:
+		report.Warn(restore.PreflightTiKVVersion, "%v, ignored by --%s=false", err, flagCheckRequirement)
+	}
+}
+
+// stores returns the TiKV stores of the cluster with their states.
+func (p *restorePreflight) stores(ctx context.Context) ([]restore.PreflightStore, error) {
+	stores, err := conn.GetAllTiKVStores(ctx, p.mgr.GetPDClient(), conn.SkipTiFlash)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	result := make([]restore.PreflightStore, 0, len(stores))
+	for _, s := range stores {
+		info, err := p.mgr.GetStoreInfo(ctx, s.GetId())
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to get the state of store %d", s.GetId())
+		}
+		store := restore.PreflightStore{
+			ID:          s.GetId(),
+			Address:     s.GetAddress(),
+			State:       s.GetState().String(),
+			Version:     s.GetVersion(),
+			RegionCount: info.Status.RegionCount,
+		}
+		// PD reports the disconnected and down stores by the state name only.
+		if info.Store != nil && len(info.Store.StateName) > 0 {
+			store.State = info.Store.StateName
+		}
+		result = append(result, store)
+	}
+	return result, nil
+}
+
+// checkDiskSpace checks the disk of each store has the free space for the bytes ingested into it
+// by the restore, times --space-reserve-ratio, instead of TiKV running out of space in the middle.
+func (p *restorePreflight) checkDiskSpace(
+	report *restore.PreflightReport, impact *restore.ImpactReport, available map[uint64]uint64,
+) {
+	ratio := p.cfg.SpaceReserveRatio
+	if ratio <= 0 {
+		report.Warn(restore.PreflightDiskSpace, "skipped by --%s=0", flagSpaceReserveRatio)
+		return
+	}
+	for _, s := range impact.Stores {
+		log.Info("the space required by the restore", zap.Uint64("store-id", s.StoreID),
+			zap.Uint64("required", uint64(float64(s.Bytes)*ratio)), zap.Uint64("available", available[s.StoreID]))
+	}
+	if shortages := restoreSpaceShortages(impact, available, ratio); len(shortages) > 0 {
+		report.Fail(restore.PreflightDiskSpace,
+			"the stores don't have enough space by --%s=%v, %s; please free up the disks or scale out the cluster",
+			flagSpaceReserveRatio, ratio, strings.Join(shortages, ", "))
+		return
+	}
+	report.Pass(restore.PreflightDiskSpace, "%s is ingested into %d stores",
+		units.HumanSize(float64(impact.Bytes)), len(impact.Stores))
+}
+
+// checkOverlap warns about the target ranges which already contain data, since the restore
+// overwrites the existing keys.
+func (p *restorePreflight) checkOverlap(ctx context.Context, report *restore.PreflightReport) error {
+	if p.cfg.PrecheckSampleKeys == 0 {
+		report.Pass(restore.PreflightOverlap, "skipped, enable it by --%s", FlagPrecheckSampleKeys)
+		return nil
+	}
+	if p.targetKeyspace != defaultKeyspaceID {
+		// the probing client only accesses the default keyspace.
+		report.Warn(restore.PreflightOverlap, "skipped in keyspace %d other than the default one", p.targetKeyspace)
+		return nil
+	}
+	nonEmpty, err := probeTargetRanges(ctx, p.cfg, p.ranges, p.dstAPIVersion)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if nonEmpty > 0 {
+		report.Warn(restore.PreflightOverlap,
+			"%d of %d target ranges are non-empty, the existing keys may be overwritten by the restore",
+			nonEmpty, len(p.ranges))
+		return nil
+	}
+	report.Pass(restore.PreflightOverlap, "%d target ranges are empty", len(p.ranges))
+	return nil
+}
+
+// logPreflightReport logs the results of the preflight checks, and warns about the suspicious
+// ones on the terminal.
+func logPreflightReport(report *restore.PreflightReport) {
+	for _, result := range report.Results {
+		switch result.Status {
+		case restore.PreflightWarn:
+			logutil.WarnTerm("restore preflight check warns",
+				zap.String("check", result.Check), zap.String("message", result.Message))
+		case restore.PreflightFail:
+			log.Error("restore preflight check fails",
+				zap.String("check", result.Check), zap.String("message", result.Message))
+		default:
+			log.Info("restore preflight check passes",
+				zap.String("check", result.Check), zap.String("message", result.Message))
+		}
+	}
+}
@@ -4,19 +4,30 @@ package task
 
 import (
 	"context"
+	"os"
+	"sort"
+	"time"
 
 	"github.com/coreos/go-semver/semver"
 	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/log"
 	"github.com/spf13/cobra"
 	"github.com/tikv/client-go/v2/rawkv"
 	"github.com/tikv/migration/br/pkg/checksum"
+	"github.com/tikv/migration/br/pkg/conn"
 	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/feature"
 	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/mask"
 	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/mount"
 	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/sst"
+	"github.com/tikv/migration/br/pkg/storage"
 	"github.com/tikv/migration/br/pkg/summary"
 	"github.com/tikv/migration/br/pkg/utils"
 	"go.uber.org/zap"
@@ -27,22 +38,94 @@ func DefineRawRestoreFlags(command *cobra.Command) {
 	command.Flags().StringP(flagKeyFormat, "", "hex", "start/end key format, support raw|escaped|hex")
 	command.Flags().StringP(flagStartKey, "", "", "restore raw kv start key, key is inclusive")
 	command.Flags().StringP(flagEndKey, "", "", "restore raw kv end key, key is exclusive")
+	command.Flags().StringArray(flagMergeStorage, nil,
+		"(experimental) restore another backup together with --storage in the same run, can be repeated. "+
+			"The ranges of the backups must be disjoint.")
+	command.Flags().Bool(flagRestoreChain, true,
+		"(experimental) if --storage is an incremental backup linked to its parent by --parent-storage, "+
+			"restore the backups of the chain from the oldest one before it.")
+	command.Flags().String(flagRestorePoint, "",
+		"(experimental) the point to restore from a backup taken by --backup-points, support TSO or datetime "+
+			"as it's given to --backup-points. The later points require --restore-chain.")
+	command.Flags().Bool(flagCreateKeyspaces, true,
+		"create the API V2 keyspaces recorded in the backup which are missing in the target cluster, "+
+			"with the same IDs and names, before restoring the data.")
+	command.Flags().String(flagChangelogStorage, "",
+		"(experimental) the storage written by the changelog sink of TiKV-CDC, whose changes after the backup ts "+
+			"are replayed after restoring the backup, to restore an API V2 cluster to a point in time.")
+	command.Flags().String(flagRestoredTS, "",
+		"(experimental) the point in time to restore to by --changelog-storage, support TSO or datetime, "+
+			"e.g. '400036290571534337' or '2018-05-11 01:42:23'. It defaults to the checkpoint ts of the changelog.")
+	command.Flags().StringArray(flagPriorityPrefix, nil,
+		"(experimental) the key prefix in --format whose files are restored before the others, can be repeated. "+
+			"When they are restored, "+metautil.PartialRestoreFile+" is written into --storage, so that the "+
+			"applications of the prefixes can come back online before the whole restore finishes.")
+	command.Flags().String(flagKeyRewrite, "",
+		"(experimental) rewrite the restored keys with a prefix to have another prefix, like old-prefix=new-prefix "+
+			"in --format, e.g. to clone the data of an environment into another one. "+
+			"Only the keys with the old prefix are restored, and the checksum is skipped.")
+	command.Flags().String(flagKeyRewriteFile, "",
+		"(experimental) the TOML file of the [[rules]] rewriting several prefixes of the restored keys, "+
+			"whose old and new prefixes are in --format, e.g. to reorganize the key schema on migration. "+
+			"Neither the old prefixes nor the new prefixes may overlap each other. "+
+			"Only the keys with the old prefixes are restored, and the checksum is skipped.")
+	command.Flags().String(flagKeyspaceName, "",
+		"(experimental) The name of the API V2 keyspace to restore the keys of the backup into, which must exist. "+
+			"It defaults to the keyspace the backup is scoped to, or the default keyspace.")
+	command.Flags().Uint32(flagKeyspaceID, defaultKeyspaceID,
+		"(experimental) The ID of the API V2 keyspace to restore the keys of the backup into, the same as --keyspace-name.")
+	command.Flags().Bool(flagPreview, false,
+		"print how many target regions will be split, how many SSTs and bytes each store receives "+
+			"and the estimated rebalance volume afterwards, then exit without restoring.")
+	command.Flags().Bool(flagPreSplit, true,
+		"split the target regions at the boundaries of the ranges of the backup files and scatter them "+
+			"before ingesting, so that the ingestion isn't hot-spotted on a few regions. "+
+			"Disable it if the target cluster is split in advance.")
+	command.Flags().Bool(flagScatterExisting, true,
+		"scatter the target regions which need no split as well, e.g. the ones split by a previous restore, "+
+			"which may be left on a few stores.")
+	command.Flags().Duration(flagScatterWaitTimeout, restore.ScatterWaitUpperInterval,
+		"how long to wait for the regions to be scattered before ingesting, 0 means not waiting.")
+	command.Flags().Bool(flagCompact, false,
+		"compact the restored ranges on the stores after ingesting and wait for it, so that the read latency "+
+			"is predictable right after the restore. The compaction takes extra IO of the stores.")
+	command.Flags().Uint32(flagCompactThreads, defaultCompactThreads,
+		"the number of the threads of each store compacting the restored ranges by --"+flagCompact+".")
+	command.Flags().String(flagMaskRules, "",
+		"(experimental) the TOML file of the [[rules]] masking the values of the keys with the prefixes, "+
+			"e.g. to seed a staging cluster by a production backup. The backup is restored logically through "+
+			"the rawkv client instead of ingesting, which is much slower, and the checksum is skipped. "+
+			"It requires the backup and the cluster of the same api version, in the default keyspace.")
 	DefineRestoreCommonFlags(command.PersistentFlags())
 }
 
 // RunRestoreRaw starts a raw kv restore task inside the current goroutine.
 func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreRawConfig) (err error) {
+	result := newTaskResult(cmdName, metautil.RestoreResultFile)
+	defer func() {
+		result.finish(err)
+	}()
 	cfg.adjust()
+	if err = registerRestoreRuntimeConfig(cfg); err != nil {
+		return errors.Trace(err)
+	}
 
 	defer summary.Summary(cmdName)
 	ctx, cancel := context.WithCancel(c)
 	defer cancel()
+	if err = cfg.resolveRestorePoint(ctx); err != nil {
+		return errors.Trace(err)
+	}
 
 	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	defer mgr.Close()
+	mgr.SetGRPCMaxMsgSize(cfg.GRPCMaxMsgSize)
+	if err = setupStoreConnectivity(ctx, mgr, &cfg.Config); err != nil {
+		return errors.Trace(err)
+	}
 
 	clusterVersion, err := mgr.GetClusterVersion(ctx)
 	if err != nil {
@@ -64,24 +147,95 @@ func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreR
 	}
 	defer client.Close()
 	client.SetRateLimit(cfg.RateLimit)
+	client.SetIngestRateLimit(cfg.IngestRateLimit)
+	if utils.GlobalDynamicSettings().Load().Paused {
+		client.Pause()
+	}
 	client.SetCrypter(&cfg.CipherInfo)
 	client.SetConcurrency(uint(cfg.Concurrency))
 	if cfg.Online {
 		client.EnableOnline()
 	}
 	client.SetSwitchModeInterval(cfg.SwitchModeInterval)
+	client.SetGRPCMaxMsgSize(cfg.GRPCMaxMsgSize)
+	// The thread pool of restore cannot be resized, only the rate limits and pausing take effect at runtime.
+	cancelListener := utils.GlobalDynamicSettings().OnChange(func(settings utils.TaskSettings) {
+		if err := client.UpdateRateLimit(ctx, settings.RateLimit); err != nil {
+			log.Warn("failed to update rate limit", zap.Uint64("rate-limit", settings.RateLimit), zap.Error(err))
+		}
+		client.SetIngestRateLimit(settings.IngestRateLimit)
+		if settings.Paused {
+			client.Pause()
+		} else {
+			client.Resume()
+		}
+	})
+	defer cancelListener()
 
+	if err = cfg.ResolveStorageByName(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	if !cfg.Preview {
+		defer func() {
+			cfg.recordRestoreInCatalog(context.Background(), err)
+		}()
+	}
 	u, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &cfg.Config)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	if client.GetAPIVersion() != backupMeta.ApiVersion {
-		return errors.Errorf("Unsupported backup api version, backup meta: %s, dst:%s",
-			backupMeta.ApiVersion.String(), client.GetAPIVersion().String())
+	if !cfg.Preview {
+		result.storage = s
+	}
+	result.BackupTS = backupMeta.EndVersion
+	result.output("backupmeta", metautil.MetaFile)
+	unsafeTS, err := metautil.ReadUnsafeTS(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if unsafeTS != nil {
+		for _, caveat := range unsafeTS.Caveats {
+			logutil.WarnTerm("restore a backup taken by --"+flagUnsafeTS, zap.String("caveat", caveat))
+		}
+	}
+	// the backups of API V1 and V1TTL can be converted into API V2 on restore.
+	srcAPIVersion, dstAPIVersion := backupMeta.ApiVersion, client.GetAPIVersion()
+	if !CheckBackupAPIVersion(featureGate, srcAPIVersion, dstAPIVersion) {
+		return errors.Errorf("Unsupported backup api version, backup meta: %s, dst:%s, cluster version:%s",
+			srcAPIVersion.String(), dstAPIVersion.String(), clusterVersion)
+	}
+	if srcAPIVersion != dstAPIVersion {
+		if len(cfg.KeyRewriteOld) > 0 || len(cfg.KeyRewriteRules) > 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s and --%s can't be used to restore a backup of api version %s into a cluster of api version %s",
+				flagKeyRewrite, flagKeyRewriteFile, srcAPIVersion, dstAPIVersion)
+		}
+		log.Info("convert the api version of the backup on restore",
+			zap.Stringer("backup", srcAPIVersion), zap.Stringer("cluster", dstAPIVersion))
 	}
 	// for restore, dst and cur are the same.
-	cfg.DstAPIVersion = client.GetAPIVersion().String()
-	cfg.adjustBackupRange(backupMeta.ApiVersion)
+	cfg.DstAPIVersion = dstAPIVersion.String()
+	sourceKeyspace, targetKeyspace, err := restoreKeyspaceIDs(ctx, mgr, cfg, s, backupMeta.ApiVersion)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.adjustBackupRange(backupMeta.ApiVersion, sourceKeyspace)
+	keyRewrites := cfg.rawKeyRewrites(backupMeta.ApiVersion, sourceKeyspace, targetKeyspace)
+	if len(keyRewrites) > 0 {
+		// only the keys with the old prefixes can be rewritten.
+		oldRanges := make([]rtree.Range, 0, len(keyRewrites))
+		for _, rewrite := range keyRewrites {
+			oldRanges = append(oldRanges, rewrite.OldRange())
+		}
+		clipped := rtree.Intersection([]rtree.Range{{StartKey: cfg.StartKey, EndKey: cfg.EndKey}}, oldRanges)
+		if len(clipped) == 0 {
+			return errors.Annotatef(berrors.ErrRestoreInvalidRange,
+				"the range to restore doesn't overlap with the prefixes to rewrite by --%s or --%s",
+				flagKeyRewrite, flagKeyRewriteFile)
+		}
+		cfg.StartKey, cfg.EndKey = clipped[0].StartKey, clipped[len(clipped)-1].EndKey
+		client.SetRawKeyRewrites(keyRewrites)
+	}
 	reader := metautil.NewMetaReader(backupMeta, s, &cfg.CipherInfo)
 	if err = client.InitBackupMeta(c, backupMeta, u, s, reader); err != nil {
 		return errors.Trace(err)
@@ -90,25 +244,141 @@ func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreR
 	if !client.IsRawKvMode() {
 		return errors.Annotate(berrors.ErrRestoreModeMismatch, "cannot do raw restore from transactional data")
 	}
+	checkTopology(ctx, mgr, s)
+	changelog, err := prepareChangelogReplay(ctx, cfg, s, backupMeta)
+	if err != nil {
+		return errors.Trace(err)
+	}
 
-	files, err := client.GetFilesInRawRange(cfg.StartKey, cfg.EndKey, "default")
+	files, err := client.GetFilesInRawRange(ctx, cfg.StartKey, cfg.EndKey, "default")
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if len(cfg.MaskRules) > 0 {
+		if srcAPIVersion != dstAPIVersion || len(keyRewrites) > 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s requires the backup and the cluster of the same api version in the default keyspace", flagMaskRules)
+		}
+		return errors.Trace(restoreRawLogically(ctx, g, cfg, s, srcAPIVersion, files, reader.ArchiveSize(ctx, files)))
+	}
 	archiveSize := reader.ArchiveSize(ctx, files)
+	backups, err := rawBackupsByLocation(ctx, client, u, s, files)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for i, mergeStorage := range cfg.MergeStorages {
+		backup, size, err := prepareMergedBackup(ctx, client, cfg, mergeStorage)
+		if err != nil {
+			return errors.Annotatef(err, "prepare the merged backup #%d failed", i+1)
+		}
+		backups = append(backups, backup)
+		files = append(files, backup.Files...)
+		archiveSize += size
+	}
+	if err = restore.CheckRawBackupsDisjoint(backups); err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.MergeStorages) > 0 {
+		summary.CollectInt("merged backups", len(cfg.MergeStorages)+1)
+	}
+	// chain are the parent backups from the oldest one, which are restored one by one before backups.
+	var chain []*restore.RawBackup
+	chainFiles := 0
+	if cfg.RestoreChain {
+		parents, err := loadBackupChain(ctx, &cfg.Config, s)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, parent := range parents {
+			backup, size, err := prepareMergedBackup(ctx, client, cfg, parent)
+			if err != nil {
+				return errors.Annotatef(err, "prepare the parent backup %s failed", parent)
+			}
+			chain = append(chain, backup)
+			chainFiles += len(backup.Files)
+			archiveSize += size
+		}
+		if len(chain) > 0 {
+			summary.CollectInt("chained backups", len(chain))
+		}
+	}
 	g.Record(summary.RestoreDataSize, archiveSize)
+	result.Size = archiveSize
 
-	if len(files) == 0 {
+	if len(files)+chainFiles == 0 {
 		log.Info("all files are filtered out from the backup archive, nothing to restore")
-		return nil
+		if cfg.Preview {
+			return nil
+		}
+		return errors.Trace(changelog.replay(logutil.ContextWithPhase(ctx, "replay"), cfg))
 	}
-	summary.CollectInt("restore files", len(files))
+	summary.CollectInt("restore files", len(files)+chainFiles)
 
 	ranges, _, err := restore.MergeFileRanges(
 		files, cfg.MergeSmallRegionKeyCount, cfg.MergeSmallRegionKeyCount)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	// the backups of the chain overlap each other, so their ranges are merged separately.
+	chainRanges := make([][]rtree.Range, 0, len(chain))
+	for _, backup := range chain {
+		backupRanges, _, err := restore.MergeFileRanges(
+			backup.Files, cfg.MergeSmallRegionKeyCount, cfg.MergeSmallRegionKeyCount)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		chainRanges = append(chainRanges, backupRanges)
+	}
+	if len(keyRewrites) > 0 {
+		// the regions are split and probed in the new prefixes.
+		ranges = restore.RewriteRawRanges(keyRewrites, ranges)
+		for i := range chainRanges {
+			chainRanges[i] = restore.RewriteRawRanges(keyRewrites, chainRanges[i])
+		}
+	}
+	// the regions are split and probed in the key space of the cluster.
+	ranges = restore.ConvertRawRanges(ranges, srcAPIVersion, dstAPIVersion)
+	for i := range chainRanges {
+		chainRanges[i] = restore.ConvertRawRanges(chainRanges[i], srcAPIVersion, dstAPIVersion)
+	}
+
+	if len(cfg.PriorityPrefixes) > 0 && len(chain) > 0 {
+		// the older files of the parents would overwrite the prioritized files restored before.
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s can't be used to restore a backup chain, specify --%s=false to restore --storage only",
+			flagPriorityPrefix, flagRestoreChain)
+	}
+
+	preflight := &restorePreflight{
+		cfg:            cfg,
+		client:         client,
+		mgr:            mgr,
+		srcAPIVersion:  srcAPIVersion,
+		dstAPIVersion:  dstAPIVersion,
+		targetKeyspace: targetKeyspace,
+		files:          files,
+		ranges:         ranges,
+		chain:          chain,
+		chainRanges:    chainRanges,
+	}
+	preflightReport, err := preflight.run(ctx)
+	if err != nil {
+		return errors.Annotate(err, "failed to run the preflight checks")
+	}
+	logPreflightReport(preflightReport)
+	if cfg.Preview {
+		preflightReport.Print(os.Stdout)
+		return errors.Trace(previewRestore(ctx, client, mgr, files, ranges, chain, chainRanges))
+	}
+	if err = preflightReport.Err(); err != nil {
+		return errors.Trace(err)
+	}
+	// the target keyspace of the mapped keys exists already.
+	if cfg.CreateKeyspaces && backupMeta.ApiVersion == kvrpcpb.APIVersion_V2 && sourceKeyspace == targetKeyspace {
+		if err = createKeyspaces(ctx, mgr, s); err != nil {
+			return errors.Trace(err)
+		}
+	}
 
 	// Redirect to log if there is no log file to avoid unreadable output.
 	updateCh := g.StartProgress(
@@ -116,61 +386,414 @@ func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreR
 		"Raw Restore",
 		// Split/Scatter + Download/Ingest.
 		// Regard split region as one step as it finish quickly compared to ingest.
-		int64(1+len(files)),
+		int64(1+len(files)+chainFiles),
 		!cfg.LogProgress)
 
-	// RawKV restore does not need to rewrite keys.
-	if featureGate.IsEnabled(feature.SplitRegion) {
-		needEncodeKey := (cfg.DstAPIVersion == kvrpcpb.APIVersion_V2.String())
-		err = restore.SplitRanges(ctx, client, ranges, nil, updateCh, true, needEncodeKey)
-		if err != nil {
-			return errors.Trace(err)
-		}
+	if err = preSplitRawRanges(ctx, client, cfg, featureGate, ranges, chainRanges, updateCh); err != nil {
+		return errors.Trace(err)
 	}
 
+	// only the stores receiving the ingested files enter import mode.
+	importModeRanges := ranges
+	for _, backupRanges := range chainRanges {
+		importModeRanges = append(importModeRanges, backupRanges...)
+	}
+	client.SetImportModeRanges(importModeRanges)
 	restoreSchedulers, err := restorePreWork(ctx, client, mgr)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	defer restorePostWork(ctx, client, restoreSchedulers)
 
-	// raw key without encoding
+	// raw key without encoding, in the key space of the cluster.
 	keyRanges := make([]*utils.KeyRange, 0, len(files))
 	for _, file := range files {
-		keyRanges = append(keyRanges, &utils.KeyRange{
-			Start: file.StartKey,
-			End:   file.EndKey,
-		})
+		keyRanges = append(keyRanges,
+			utils.ConvertBackupConfigKeyRange(file.StartKey, file.EndKey, srcAPIVersion, dstAPIVersion))
 	}
 
-	err = client.RestoreRaw(ctx, cfg.StartKey, cfg.EndKey, files, updateCh)
+	for i, backup := range chain {
+		err = client.RestoreRawBackups(logutil.ContextWithPhase(ctx, "restore"), cfg.StartKey, cfg.EndKey,
+			[]*restore.RawBackup{backup}, updateCh)
+		if err != nil {
+			return errors.Annotatef(err, "restore the parent backup #%d of the chain failed", i+1)
+		}
+	}
+	if len(cfg.PriorityPrefixes) > 0 {
+		if backups, err = restorePriorityPrefixes(ctx, client, cfg, s, backups, backupMeta.ApiVersion, sourceKeyspace, updateCh); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	err = client.RestoreRawBackups(logutil.ContextWithPhase(ctx, "restore"), cfg.StartKey, cfg.EndKey, backups, updateCh)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
 	// Restore has finished.
 	updateCh.Close()
+	if cfg.Compact {
+		if err = compactRestoredRanges(ctx, g, client, mgr, cfg, importModeRanges); err != nil {
+			return errors.Trace(err)
+		}
+	}
 
-	if cfg.Checksum {
+	if cfg.Checksum && len(keyRewrites) > 0 {
+		// the checksums of the files are computed over the old keys.
+		log.Warn("skip checksum after rewriting the keys")
+	} else if cfg.Checksum && targetKeyspace != defaultKeyspaceID {
+		// the checksum client only accesses the default keyspace.
+		log.Warn("skip checksum of the keys restored into a keyspace other than the default one",
+			zap.Uint32("keyspace", targetKeyspace))
+	} else if cfg.Checksum && len(chain) > 0 {
+		// the keys changed by the incremental backups overwrite the ones of their parents,
+		// so the checksums of the files don't add up.
+		log.Warn("skip checksum after restoring a backup chain")
+	} else if cfg.Checksum {
 		finalChecksum := rawkv.RawChecksum{}
 		for _, file := range files {
 			checksum.UpdateChecksum(&finalChecksum, file.Crc64Xor, file.TotalKvs, file.TotalBytes)
 		}
+		finalChecksum = checksum.ConvertChecksum(finalChecksum, srcAPIVersion, dstAPIVersion)
+		result.Checksum = &metautil.ResultChecksum{
+			Crc64Xor:   finalChecksum.Crc64Xor,
+			TotalKvs:   finalChecksum.TotalKvs,
+			TotalBytes: finalChecksum.TotalBytes,
+		}
 
 		executor, err := checksum.NewExecutor(ctx, keyRanges, cfg.PD,
-			backupMeta.ApiVersion, cfg.ChecksumConcurrency, cfg.TLS)
+			dstAPIVersion, cfg.ChecksumConcurrency, cfg.TLS)
 		if err != nil {
 			return errors.Trace(err)
 		}
 		defer executor.Close()
-		err = checksum.Run(ctx, cmdName, executor,
+		if srcAPIVersion != dstAPIVersion {
+			log.Warn("only verify the total kvs and bytes, the crc64 of the converted keys is unknown")
+			executor.IgnoreCrc64Xor()
+		}
+		err = checksum.Run(logutil.ContextWithPhase(ctx, "checksum"), cmdName, executor,
 			checksum.StorageChecksumCommand, finalChecksum)
 		if err != nil {
 			return errors.Trace(err)
 		}
 	}
+	// the changelog is replayed after the checksum, which verifies the data of the backup only.
+	if err = changelog.replay(logutil.ContextWithPhase(ctx, "replay"), cfg); err != nil {
+		return errors.Trace(err)
+	}
+	// the hooks see the cluster restored to the point in time, i.e. with the changelog replayed.
+	if err = runHooks(ctx, cfg.Hooks, HookPostRestoreVerify, result); err != nil {
+		return errors.Trace(err)
+	}
 
 	// Set task summary to success status.
 	summary.SetSuccessStatus(true)
 	return nil
 }
+
+// compactRestoredRanges compacts the restored ranges on the stores holding them, and waits for
+// it. The restored data is intact if the compaction fails, so it's only warned.
+func compactRestoredRanges(
+	ctx context.Context,
+	g glue.Glue,
+	client *restore.Client,
+	mgr *conn.Mgr,
+	cfg *RestoreRawConfig,
+	ranges []rtree.Range,
+) error {
+	ctx = logutil.ContextWithPhase(ctx, "compact")
+	start := time.Now()
+	tasks, err := client.CompactTasks(ctx, ranges)
+	if err != nil {
+		return errors.Annotate(err, "failed to locate the restored ranges to compact")
+	}
+	updateCh := g.StartProgress(ctx, "Compact", int64(len(tasks)), !cfg.LogProgress)
+	err = restore.Compact(ctx, mgr, tasks, cfg.CompactThreads, updateCh)
+	updateCh.Close()
+	if err != nil {
+		if errors.Cause(err) == context.Canceled {
+			return errors.Trace(err)
+		}
+		logutil.WarnTerm("failed to compact the restored ranges, the restored data is intact but the reads "+
+			"may be slow until TiKV compacts them", zap.Error(err))
+		return nil
+	}
+	log.Info("the restored ranges are compacted", zap.Int("tasks", len(tasks)), zap.Duration("take", time.Since(start)))
+	summary.CollectDuration("compact", time.Since(start))
+	return nil
+}
+
+// preSplitRawRanges splits the target regions at the boundaries of the ranges to restore and
+// scatters them before ingesting. The ranges of the backups of the chain overlap each other,
+// so they are split separately.
+func preSplitRawRanges(
+	ctx context.Context,
+	client *restore.Client,
+	cfg *RestoreRawConfig,
+	featureGate *feature.Gate,
+	ranges []rtree.Range,
+	chainRanges [][]rtree.Range,
+	updateCh glue.Progress,
+) error {
+	if !cfg.PreSplit {
+		log.Info("skip splitting the target regions", zap.String("flag", "--"+flagPreSplit+"=false"))
+		return nil
+	}
+	if !featureGate.IsEnabled(feature.SplitRegion) {
+		log.Warn("skip splitting the target regions, the cluster doesn't support splitting raw kv regions")
+		return nil
+	}
+	client.SetScatterWaitTimeout(cfg.ScatterWaitTimeout)
+	if cfg.ScatterExisting {
+		client.EnableScatterExisting()
+	}
+	// RawKV restore does not need to rewrite keys.
+	needEncodeKey := (cfg.DstAPIVersion == kvrpcpb.APIVersion_V2.String())
+	ctx = logutil.ContextWithPhase(ctx, "split")
+	for _, rs := range append([][]rtree.Range{ranges}, chainRanges...) {
+		if err := restore.SplitRanges(ctx, client, rs, nil, updateCh, true, needEncodeKey); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// restorePriorityPrefixes restores the files covering the priority prefixes, then publishes
+// the marker of them. It returns the backups with the remaining files.
+func restorePriorityPrefixes(
+	ctx context.Context,
+	client *restore.Client,
+	cfg *RestoreRawConfig,
+	s storage.ExternalStorage,
+	backups []*restore.RawBackup,
+	apiVersion kvrpcpb.APIVersion,
+	keyspaceID uint32,
+	updateCh glue.Progress,
+) ([]*restore.RawBackup, error) {
+	prioritized, others := restore.SplitRawBackupsByRanges(backups,
+		prefixRanges(cfg.PriorityPrefixes, apiVersion, keyspaceID))
+	files := 0
+	for _, backup := range prioritized {
+		files += len(backup.Files)
+	}
+	log.Info("restore the files of the priority prefixes first", zap.Int("files", files))
+	err := client.RestoreRawBackups(logutil.ContextWithPhase(ctx, "restore"), cfg.StartKey, cfg.EndKey, prioritized, updateCh)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	marker := &metautil.PartialRestore{Prefixes: cfg.PriorityPrefixes, Files: files, FinishTime: time.Now()}
+	if err = metautil.WritePartialRestore(ctx, s, marker); err != nil {
+		return nil, errors.Trace(err)
+	}
+	summary.CollectInt("priority files", files)
+	log.Info("the priority prefixes are restored", zap.Int("files", files),
+		zap.String("marker", s.URI()+"/"+metautil.PartialRestoreFile))
+	return others, nil
+}
+
+// rawBackupsByLocation splits the files of the backup by the storages they are in, if the
+// backup failed over between several storages. The files not recorded are in the storage u.
+func rawBackupsByLocation(
+	ctx context.Context,
+	client *restore.Client,
+	u *backuppb.StorageBackend,
+	s storage.ExternalStorage,
+	files []*backuppb.File,
+) ([]*restore.RawBackup, error) {
+	locations, err := metautil.ReadLocations(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if locations == nil {
+		return []*restore.RawBackup{client.NewMainRawBackup(files)}, nil
+	}
+	mainURL := storage.FormatBackendURL(u)
+	mainURI := s.URI()
+	endpoints := make(map[string]storage.StorageEndpoint)
+	if fs, ok := s.(*storage.FailoverStorage); ok {
+		for _, e := range fs.Endpoints() {
+			endpoints[e.Storage.URI()] = e
+			if backendURL := storage.FormatBackendURL(e.Backend); backendURL.String() == mainURL.String() {
+				mainURI = e.Storage.URI()
+			}
+		}
+	}
+	fileEndpoints, err := locations.FileEndpoints(mainURI)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	mainFiles := make([]*backuppb.File, 0, len(files))
+	otherFiles := make(map[string][]*backuppb.File)
+	for _, file := range files {
+		uri, ok := fileEndpoints[file.Name]
+		if !ok || uri == mainURI {
+			mainFiles = append(mainFiles, file)
+			continue
+		}
+		otherFiles[uri] = append(otherFiles[uri], file)
+	}
+	backups := []*restore.RawBackup{client.NewMainRawBackup(mainFiles)}
+	uris := make([]string, 0, len(otherFiles))
+	for uri := range otherFiles {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		endpoint, ok := endpoints[uri]
+		if !ok {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"%d files of the backup are in storage %s, please specify it by --%s", len(otherFiles[uri]), uri, flagStorageFailover)
+		}
+		backup, err := client.NewRawBackupInStorage(ctx, otherFiles[uri], endpoint.Backend)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		backups = append(backups, backup)
+		log.Info("restore the files in the failover storage", zap.String("storage", uri), zap.Int("files", len(otherFiles[uri])))
+	}
+	return backups, nil
+}
+
+// prepareMergedBackup reads the backup restored along with the one of --storage, and returns
+// it with its archive size.
+func prepareMergedBackup(
+	ctx context.Context, client *restore.Client, cfg *RestoreRawConfig, rawURL string,
+) (*restore.RawBackup, uint64, error) {
+	mergeCfg := cfg.configOfBackup(rawURL)
+	u, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &mergeCfg)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	reader := metautil.NewMetaReader(backupMeta, s, &mergeCfg.CipherInfo)
+	backup, err := client.NewRawBackup(ctx, backupMeta, reader, u, cfg.StartKey, cfg.EndKey, "default")
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	backup.SetCrypter(&mergeCfg.CipherInfo)
+	return backup, reader.ArchiveSize(ctx, backup.Files), nil
+}
+
+// previewRestore prints the impact of restoring the backups on the target cluster.
+func previewRestore(
+	ctx context.Context,
+	client *restore.Client,
+	mgr *conn.Mgr,
+	files []*backuppb.File,
+	ranges []rtree.Range,
+	chain []*restore.RawBackup,
+	chainRanges [][]rtree.Range,
+) error {
+	report, err := restoreImpact(logutil.ContextWithPhase(ctx, "preview"), client, mgr, files, ranges, chain, chainRanges, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("restore preview",
+		zap.Int("regions", report.Regions),
+		zap.Int("split-regions", report.SplitRegions),
+		zap.Uint64("bytes", report.Bytes),
+		zap.Uint64("rebalance-bytes", report.RebalanceBytes))
+	report.Print(os.Stdout)
+	summary.SetSuccessStatus(true)
+	return nil
+}
+
+// restoreImpact computes the impact of restoring the backups on the target cluster. The available
+// space of each store is recorded into available if it isn't nil.
+func restoreImpact(
+	ctx context.Context,
+	client *restore.Client,
+	mgr *conn.Mgr,
+	files []*backuppb.File,
+	ranges []rtree.Range,
+	chain []*restore.RawBackup,
+	chainRanges [][]rtree.Range,
+	available map[uint64]uint64,
+) (*restore.ImpactReport, error) {
+	allFiles := append([]*backuppb.File{}, files...)
+	allRanges := append([]rtree.Range{}, ranges...)
+	for i, backup := range chain {
+		allFiles = append(allFiles, backup.Files...)
+		allRanges = append(allRanges, chainRanges[i]...)
+	}
+	report, err := client.PreviewImpact(ctx, allRanges, allFiles,
+		func(ctx context.Context, storeID uint64) (uint64, error) {
+			info, err := mgr.GetStoreInfo(ctx, storeID)
+			if err != nil {
+				return 0, errors.Trace(err)
+			}
+			if available != nil {
+				available[storeID] = uint64(info.Status.Available)
+			}
+			return uint64(info.Status.UsedSize), nil
+		})
+	return report, errors.Trace(err)
+}
+
+// probeTargetRanges returns the count of the target ranges which already contain data before restore.
+func probeTargetRanges(ctx context.Context, cfg *RestoreRawConfig, ranges []rtree.Range, apiVersion kvrpcpb.APIVersion) (int, error) {
+	prober, err := restore.NewRangeProber(ctx, cfg.PD, apiVersion, cfg.TLS,
+		int(cfg.PrecheckSampleKeys), cfg.ChecksumConcurrency)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer prober.Close()
+
+	keyRanges := make([]*utils.KeyRange, 0, len(ranges))
+	for _, rg := range ranges {
+		keyRanges = append(keyRanges, &utils.KeyRange{Start: rg.StartKey, End: rg.EndKey})
+	}
+	results, err := prober.Probe(ctx, keyRanges)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	nonEmpty := 0
+	for i := range results {
+		if !results[i].IsEmpty() {
+			nonEmpty++
+		}
+	}
+	summary.CollectInt("non-empty target ranges", nonEmpty)
+	return nonEmpty, nil
+}
+
+// restoreRawLogically restores the files of the backup by writing the kvs masked by --mask-rules
+// through the rawkv client, the parent backups of the chain can't be restored this way.
+func restoreRawLogically(
+	ctx context.Context, g glue.Glue, cfg *RestoreRawConfig, s storage.ExternalStorage,
+	apiVersion kvrpcpb.APIVersion, files []*backuppb.File, archiveSize uint64,
+) error {
+	if cfg.RestoreChain {
+		parents, err := loadBackupChain(ctx, &cfg.Config, s)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(parents) > 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s can't restore the parent backups of an incremental backup", flagMaskRules)
+		}
+	}
+	masker, err := mask.NewMasker(cfg.MaskRules)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	restorer, err := restore.NewLogicalRestorer(ctx, cfg.PD, cfg.TLS, apiVersion, masker)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer restorer.Close()
+
+	summary.CollectInt("restore files", len(files))
+	snapshot := mount.NewSnapshot(s, apiVersion, files, &cfg.CipherInfo, sst.NewBlockCache(int64(defaultBlockCacheSize)))
+	// the files are scanned through one by one, so each of them is downloaded as a whole by the
+	// concurrent ranged reads, instead of a request for each block.
+	snapshot.SetPrefetch(storage.RangedReaderOptions{})
+	stats, err := restorer.Restore(logutil.ContextWithPhase(ctx, "restore"), snapshot, cfg.StartKey, cfg.EndKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	summary.CollectInt("restored keys", stats.Keys)
+	summary.CollectInt("masked keys", stats.Masked)
+	summary.CollectInt("expired keys", stats.Expired)
+	g.Record(summary.RestoreDataSize, archiveSize)
+	summary.SetSuccessStatus(true)
+	return nil
+}
@@ -15,14 +15,123 @@
 package task
 
 import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
 	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/spf13/pflag"
+	"github.com/tikv/client-go/v2/oracle"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/mask"
+	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+const (
+	// flagMergeStorage is the storage of the backup restored together with --storage.
+	flagMergeStorage = "merge-storage"
+	// flagRestoreChain restores the parent backups of an incremental backup first.
+	flagRestoreChain = "restore-chain"
+	// flagCreateKeyspaces recreates the keyspaces of the backup cluster before restoring.
+	flagCreateKeyspaces = "create-keyspaces"
+	// flagPreview prints the impact of the restore on the target cluster without restoring.
+	flagPreview = "preview"
+	// flagChangelogStorage is the storage of the TiKV-CDC changelog replayed after restoring the backup.
+	flagChangelogStorage = "changelog-storage"
+	// flagRestoredTS is the point in time the changelog is replayed to.
+	flagRestoredTS = "restored-ts"
+	// flagPriorityPrefix restores the files covering the prefix before the others.
+	flagPriorityPrefix = "priority-prefix"
+	// flagKeyRewrite rewrites the prefix of the restored keys.
+	flagKeyRewrite = "key-rewrite"
+	// flagKeyRewriteFile is the file of the rules rewriting several prefixes of the restored keys.
+	flagKeyRewriteFile = "key-rewrite-file"
+	// flagPreSplit splits and scatters the target regions by the ranges of the backup files before ingesting.
+	flagPreSplit = "pre-split"
+	// flagScatterExisting scatters the target regions which need no split as well.
+	flagScatterExisting = "scatter-existing-regions"
+	// flagScatterWaitTimeout is how long the restore waits for the regions to be scattered.
+	flagScatterWaitTimeout = "scatter-wait-timeout"
+	// flagCompact compacts the restored ranges on the stores after ingesting.
+	flagCompact = "compact-after-restore"
+	// flagCompactThreads is the number of the threads of each store compacting the restored ranges.
+	flagCompactThreads = "compact-threads"
+	// flagMaskRules is the file of the rules masking the values, which are restored logically.
+	flagMaskRules = "mask-rules"
+
+	defaultCompactThreads = 4
 )
 
+// KeyRewriteRule is a [[rules]] table of the file of --key-rewrite-file, which rewrites the
+// restored keys with the prefix Old to have the prefix New instead.
+type KeyRewriteRule struct {
+	// Old and New are the prefixes in --format.
+	Old string `json:"old" toml:"old"`
+	New string `json:"new" toml:"new"`
+
+	OldPrefix []byte `json:"-" toml:"-"`
+	NewPrefix []byte `json:"-" toml:"-"`
+}
+
 // RestoreRawConfig is the configuration specific for raw kv restore tasks.
 type RestoreRawConfig struct {
 	RawKvConfig
 	RestoreCommonConfig
+
+	// MergeStorages are the backups restored together with the one of Storage in a single run.
+	MergeStorages []string `json:"merge-storages" toml:"merge-storages"`
+	// RestoreChain walks the parents linked by the incremental backup of Storage,
+	// and restores them from the oldest one before it.
+	RestoreChain bool `json:"restore-chain" toml:"restore-chain"`
+	// RestorePoint is the point restored from the backup of Storage taken at several points,
+	// the backup of the point is restored instead. 0 means Storage is restored.
+	RestorePoint uint64 `json:"restore-point" toml:"restore-point"`
+	// CreateKeyspaces recreates the API V2 keyspaces of the backup cluster missing in the
+	// target cluster with the same IDs before restoring.
+	CreateKeyspaces bool `json:"create-keyspaces" toml:"create-keyspaces"`
+	// Preview reports the regions to split, the SSTs and bytes ingested into each store and
+	// the estimated rebalance volume afterwards, and exits without changing the target cluster.
+	Preview bool `json:"preview" toml:"preview"`
+
+	// PreSplit splits the target regions at the boundaries of the ranges of the backup files and
+	// scatters them before ingesting, so that the ingestion isn't hot-spotted on a few regions.
+	PreSplit bool `json:"pre-split" toml:"pre-split"`
+	// ScatterExisting scatters the target regions which need no split as well.
+	ScatterExisting bool `json:"scatter-existing-regions" toml:"scatter-existing-regions"`
+	// ScatterWaitTimeout is how long the restore waits for the regions to be scattered, 0 means not waiting.
+	ScatterWaitTimeout time.Duration `json:"scatter-wait-timeout" toml:"scatter-wait-timeout"`
+
+	// Compact compacts the restored ranges on the stores after ingesting, so that the reads right
+	// after the restore don't go through the ingested SSTs in the lower levels.
+	Compact bool `json:"compact-after-restore" toml:"compact-after-restore"`
+	// CompactThreads is the number of the threads of each store compacting the restored ranges.
+	CompactThreads uint32 `json:"compact-threads" toml:"compact-threads"`
+
+	// ChangelogStorage is the storage written by the changelog sink of TiKV-CDC, whose events
+	// after the backup ts are replayed upon the backup to restore the cluster to RestoredTS.
+	ChangelogStorage string `json:"changelog-storage" toml:"changelog-storage"`
+	// RestoredTS is the point in time to restore to, 0 means the checkpoint ts of the changelog.
+	RestoredTS uint64 `json:"restored-ts" toml:"restored-ts"`
+
+	// PriorityPrefixes are the key prefixes whose files are restored first, after which
+	// metautil.PartialRestoreFile is published into the backup storage.
+	PriorityPrefixes [][]byte `json:"priority-prefixes" toml:"priority-prefixes"`
+
+	// KeyRewriteOld and KeyRewriteNew rewrite the restored keys with the prefix KeyRewriteOld
+	// to have the prefix KeyRewriteNew instead, only the keys with KeyRewriteOld are restored.
+	KeyRewriteOld []byte `json:"key-rewrite-old" toml:"key-rewrite-old"`
+	KeyRewriteNew []byte `json:"key-rewrite-new" toml:"key-rewrite-new"`
+	// KeyRewriteRules rewrite several disjoint prefixes of the restored keys into disjoint prefixes,
+	// only the keys with the old prefixes are restored.
+	KeyRewriteRules []KeyRewriteRule `json:"key-rewrite-rules" toml:"key-rewrite-rules"`
+
+	// MaskRules mask the values of the matched keys, the backup is restored logically by writing
+	// the masked kvs through the rawkv client instead of ingesting the SST files.
+	MaskRules []mask.Rule `json:"mask-rules" toml:"mask-rules"`
 }
 
 // ParseFromFlags parses the backup-related flags from the flag set.
@@ -36,10 +145,248 @@ func (cfg *RestoreRawConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	cfg.MergeStorages, err = flags.GetStringArray(flagMergeStorage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.RestoreChain, err = flags.GetBool(flagRestoreChain)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	restorePoint, err := flags.GetString(flagRestorePoint)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(restorePoint) > 0 {
+		if cfg.RestorePoint, err = parseTSString(restorePoint); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	cfg.CreateKeyspaces, err = flags.GetBool(flagCreateKeyspaces)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Preview, err = flags.GetBool(flagPreview)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.PreSplit, err = flags.GetBool(flagPreSplit); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ScatterExisting, err = flags.GetBool(flagScatterExisting); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ScatterWaitTimeout, err = flags.GetDuration(flagScatterWaitTimeout); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ScatterWaitTimeout < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must not be negative", flagScatterWaitTimeout)
+	}
+	if cfg.Compact, err = flags.GetBool(flagCompact); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.CompactThreads, err = flags.GetUint32(flagCompactThreads); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Compact && cfg.CompactThreads == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must be positive", flagCompactThreads)
+	}
+	cfg.ChangelogStorage, err = flags.GetString(flagChangelogStorage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	restoredTS, err := flags.GetString(flagRestoredTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(restoredTS) > 0 {
+		if len(cfg.ChangelogStorage) == 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "--%s requires --%s", flagRestoredTS, flagChangelogStorage)
+		}
+		if cfg.RestoredTS, err = parseTSString(restoredTS); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if len(cfg.ChangelogStorage) > 0 && len(cfg.MergeStorages) > 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s can't be used with --%s, the changelog is replayed from the backup ts of --storage", flagChangelogStorage, flagMergeStorage)
+	}
+	if cfg.PriorityPrefixes, err = parsePrefixes(flags, flagPriorityPrefix); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.parseKeyRewrite(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.parseKeyspace(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.parseMaskRules(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.hasKeyspace() && len(cfg.ChangelogStorage) > 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s and --%s can't be used with --%s, the keys of the changelog are not mapped",
+			flagKeyspaceName, flagKeyspaceID, flagChangelogStorage)
+	}
 	// when restore, api version is read from backup meta, instead of user input.
 	return cfg.RawKvConfig.ParseFromFlags(flags)
 }
 
+// parseMaskRules loads the rules of --mask-rules, the logical restore they require can't work
+// with the options relying on ingesting the SST files.
+func (cfg *RestoreRawConfig) parseMaskRules(flags *pflag.FlagSet) error {
+	path, err := flags.GetString(flagMaskRules)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(path) == 0 {
+		return nil
+	}
+	if cfg.MaskRules, err = mask.LoadRules(path); err != nil {
+		return errors.Trace(err)
+	}
+	conflicts := []struct {
+		flag string
+		set  bool
+	}{
+		{flagMergeStorage, len(cfg.MergeStorages) > 0},
+		{flagPreview, cfg.Preview},
+		{flagChangelogStorage, len(cfg.ChangelogStorage) > 0},
+		{flagPriorityPrefix, len(cfg.PriorityPrefixes) > 0},
+		{flagKeyRewrite, len(cfg.KeyRewriteOld) > 0},
+		{flagKeyRewriteFile, len(cfg.KeyRewriteRules) > 0},
+		{flagKeyspaceName, cfg.hasKeyspace()},
+	}
+	for _, conflict := range conflicts {
+		if conflict.set {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "--%s can't be used with --%s", flagMaskRules, conflict.flag)
+		}
+	}
+	return nil
+}
+
+// parseKeyRewrite parses the rewrite rule like old-prefix=new-prefix, or the rules of the file of
+// --key-rewrite-file. The prefixes are in --format.
+func (cfg *RestoreRawConfig) parseKeyRewrite(flags *pflag.FlagSet) error {
+	rule, err := flags.GetString(flagKeyRewrite)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	path, err := flags.GetString(flagKeyRewriteFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(rule) == 0 && len(path) == 0 {
+		return nil
+	}
+	if len(rule) > 0 && len(path) > 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s can't be used with --%s", flagKeyRewrite, flagKeyRewriteFile)
+	}
+	if len(cfg.ChangelogStorage) > 0 {
+		name := flagKeyRewrite
+		if len(path) > 0 {
+			name = flagKeyRewriteFile
+		}
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s can't be used with --%s, the keys of the changelog are not rewritten", name, flagChangelogStorage)
+	}
+	format, err := flags.GetString(flagKeyFormat)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(path) > 0 {
+		cfg.KeyRewriteRules, err = loadKeyRewriteRules(path, format)
+		return errors.Trace(err)
+	}
+	parts := strings.SplitN(rule, "=", 2)
+	if len(parts) != 2 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must be like old-prefix=new-prefix, got '%s'", flagKeyRewrite, rule)
+	}
+	if cfg.KeyRewriteOld, err = utils.ParseKey(format, parts[0]); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.KeyRewriteNew, err = utils.ParseKey(format, parts[1]); err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.KeyRewriteOld) == 0 || len(cfg.KeyRewriteNew) == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "the prefixes of --%s must not be empty", flagKeyRewrite)
+	}
+	if bytes.Equal(cfg.KeyRewriteOld, cfg.KeyRewriteNew) {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "the prefixes of --%s are the same", flagKeyRewrite)
+	}
+	return nil
+}
+
+// loadKeyRewriteRules loads the rules of the file, whose prefixes are in the format. Neither the
+// old prefixes nor the new prefixes may overlap each other.
+func loadKeyRewriteRules(path, format string) ([]KeyRewriteRule, error) {
+	var file struct {
+		Rules []KeyRewriteRule `toml:"rules"`
+	}
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to load key rewrite rules file %s: %v", path, err)
+	}
+	if len(file.Rules) == 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "no [[rules]] in %s", path)
+	}
+	rewrites := make([]*restore.RawKeyRewrite, 0, len(file.Rules))
+	for i := range file.Rules {
+		r := &file.Rules[i]
+		var err error
+		if r.OldPrefix, err = utils.ParseKey(format, r.Old); err != nil {
+			return nil, errors.Annotatef(err, "the old prefix of rule #%d of %s", i+1, path)
+		}
+		if r.NewPrefix, err = utils.ParseKey(format, r.New); err != nil {
+			return nil, errors.Annotatef(err, "the new prefix of rule #%d of %s", i+1, path)
+		}
+		rewrites = append(rewrites, &restore.RawKeyRewrite{OldPrefix: r.OldPrefix, NewPrefix: r.NewPrefix})
+	}
+	if err := restore.CheckRawKeyRewrites(rewrites); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid rules in %s: %v", path, err)
+	}
+	return file.Rules, nil
+}
+
+// rawKeyRewrites returns the rewrites of the keys in the format of apiVersion, empty if there is none.
+func (cfg *RestoreRawConfig) rawKeyRewrites(apiVersion kvrpcpb.APIVersion, sourceKeyspace, targetKeyspace uint32) []*restore.RawKeyRewrite {
+	if len(cfg.KeyRewriteRules) == 0 {
+		if rewrite := cfg.rawKeyRewrite(apiVersion, sourceKeyspace, targetKeyspace); rewrite != nil {
+			return []*restore.RawKeyRewrite{rewrite}
+		}
+		return nil
+	}
+	rewrites := make([]*restore.RawKeyRewrite, 0, len(cfg.KeyRewriteRules))
+	for _, rule := range cfg.KeyRewriteRules {
+		rewrite := &restore.RawKeyRewrite{OldPrefix: rule.OldPrefix, NewPrefix: rule.NewPrefix}
+		if apiVersion == kvrpcpb.APIVersion_V2 {
+			rewrite.OldPrefix = utils.FormatKeyspaceKey(sourceKeyspace, rule.OldPrefix, false)
+			rewrite.NewPrefix = utils.FormatKeyspaceKey(targetKeyspace, rule.NewPrefix, false)
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+	return rewrites
+}
+
+// rawKeyRewrite returns the rewrite of the keys in the format of apiVersion, nil if there is none.
+// The API V2 keys are rewritten from the source keyspace into the target keyspace.
+func (cfg *RestoreRawConfig) rawKeyRewrite(apiVersion kvrpcpb.APIVersion, sourceKeyspace, targetKeyspace uint32) *restore.RawKeyRewrite {
+	if len(cfg.KeyRewriteOld) == 0 {
+		if sourceKeyspace == targetKeyspace {
+			return nil
+		}
+		return &restore.RawKeyRewrite{
+			OldPrefix: utils.KeyspacePrefix(sourceKeyspace),
+			NewPrefix: utils.KeyspacePrefix(targetKeyspace),
+		}
+	}
+	rewrite := &restore.RawKeyRewrite{OldPrefix: cfg.KeyRewriteOld, NewPrefix: cfg.KeyRewriteNew}
+	if apiVersion == kvrpcpb.APIVersion_V2 {
+		rewrite.OldPrefix = utils.FormatKeyspaceKey(sourceKeyspace, cfg.KeyRewriteOld, false)
+		rewrite.NewPrefix = utils.FormatKeyspaceKey(targetKeyspace, cfg.KeyRewriteNew, false)
+	}
+	return rewrite
+}
+
 func (cfg *RestoreRawConfig) adjust() {
 	cfg.Config.adjust()
 	cfg.RestoreCommonConfig.adjust()
@@ -48,3 +395,15 @@ func (cfg *RestoreRawConfig) adjust() {
 		cfg.Concurrency = defaultRestoreConcurrency
 	}
 }
+
+// parseTSString parses a TSO or a datetime in the local time zone, like "2022-05-01 12:00:00".
+func parseTSString(ts string) (uint64, error) {
+	if tso, err := strconv.ParseUint(ts, 10, 64); err == nil {
+		return tso, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", ts, time.Local)
+	if err != nil {
+		return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid ts '%s', it must be a TSO or a datetime like '2006-01-02 15:04:05'", ts)
+	}
+	return oracle.GoTimeToTS(t), nil
+}
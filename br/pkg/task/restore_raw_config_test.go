@@ -0,0 +1,157 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+func TestParseKeyRewrite(t *testing.T) {
+	parse := func(args ...string) (*RestoreRawConfig, error) {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags.String(flagKeyFormat, "raw", "")
+		flags.String(flagKeyRewrite, "", "")
+		flags.String(flagKeyRewriteFile, "", "")
+		require.NoError(t, flags.Parse(args))
+		cfg := &RestoreRawConfig{}
+		return cfg, cfg.parseKeyRewrite(flags)
+	}
+
+	cfg, err := parse()
+	require.NoError(t, err)
+	require.Nil(t, cfg.rawKeyRewrite(kvrpcpb.APIVersion_V2, 0, 0))
+
+	cfg, err = parse("--key-rewrite", "prod_=staging_")
+	require.NoError(t, err)
+	require.Equal(t, []byte("prod_"), cfg.KeyRewriteOld)
+	require.Equal(t, []byte("staging_"), cfg.KeyRewriteNew)
+	rewrite := cfg.rawKeyRewrite(kvrpcpb.APIVersion_V1, 0, 0)
+	require.Equal(t, []byte("prod_"), rewrite.OldPrefix)
+	rewrite = cfg.rawKeyRewrite(kvrpcpb.APIVersion_V2, 0, 0)
+	require.Equal(t, utils.FormatAPIV2Key([]byte("prod_"), false), rewrite.OldPrefix)
+	require.Equal(t, utils.FormatAPIV2Key([]byte("staging_"), false), rewrite.NewPrefix)
+	// the prefixes are in the source and target keyspaces.
+	rewrite = cfg.rawKeyRewrite(kvrpcpb.APIVersion_V2, 1, 2)
+	require.Equal(t, []byte("r\x00\x00\x01prod_"), rewrite.OldPrefix)
+	require.Equal(t, []byte("r\x00\x00\x02staging_"), rewrite.NewPrefix)
+
+	cfg, err = parse("--format", "hex", "--key-rewrite", "61=62")
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), cfg.KeyRewriteOld)
+	require.Equal(t, []byte("b"), cfg.KeyRewriteNew)
+
+	for _, rule := range []string{"prod_", "=staging_", "prod_=", "a=a"} {
+		_, err = parse("--key-rewrite", rule)
+		require.True(t, berrors.Is(err, berrors.ErrInvalidArgument), rule)
+	}
+}
+
+func TestRawKeyRewriteOfKeyspaces(t *testing.T) {
+	cfg := &RestoreRawConfig{}
+	require.Nil(t, cfg.rawKeyRewrite(kvrpcpb.APIVersion_V2, 3, 3))
+	rewrite := cfg.rawKeyRewrite(kvrpcpb.APIVersion_V2, 3, 4)
+	require.Equal(t, []byte{'r', 0, 0, 3}, rewrite.OldPrefix)
+	require.Equal(t, []byte{'r', 0, 0, 4}, rewrite.NewPrefix)
+}
+
+func TestParseKeyRewriteFile(t *testing.T) {
+	parse := func(args ...string) (*RestoreRawConfig, error) {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags.String(flagKeyFormat, "raw", "")
+		flags.String(flagKeyRewrite, "", "")
+		flags.String(flagKeyRewriteFile, "", "")
+		require.NoError(t, flags.Parse(args))
+		cfg := &RestoreRawConfig{}
+		return cfg, cfg.parseKeyRewrite(flags)
+	}
+	writeRules := func(content string) string {
+		path := filepath.Join(t.TempDir(), "rules.toml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+
+	path := writeRules(`
+[[rules]]
+old = "user_"
+new = "u/"
+
+[[rules]]
+old = "order_"
+new = "o/"
+`)
+	cfg, err := parse("--key-rewrite-file", path)
+	require.NoError(t, err)
+	require.Len(t, cfg.KeyRewriteRules, 2)
+	rewrites := cfg.rawKeyRewrites(kvrpcpb.APIVersion_V1, 0, 0)
+	require.Len(t, rewrites, 2)
+	require.Equal(t, []byte("order_"), rewrites[1].OldPrefix)
+	require.Equal(t, []byte("o/"), rewrites[1].NewPrefix)
+	// the prefixes are in the source and target keyspaces.
+	rewrites = cfg.rawKeyRewrites(kvrpcpb.APIVersion_V2, 1, 2)
+	require.Equal(t, []byte("r\x00\x00\x01user_"), rewrites[0].OldPrefix)
+	require.Equal(t, []byte("r\x00\x00\x02u/"), rewrites[0].NewPrefix)
+
+	_, err = parse("--key-rewrite-file", path, "--key-rewrite", "a=b")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+	for _, content := range []string{
+		``,
+		"[[rules]]\nold = \"user_\"\nnew = \"\"",
+		"[[rules]]\nold = \"user_\"\nnew = \"u/\"\n[[rules]]\nold = \"user_vip_\"\nnew = \"v/\"",
+		"[[rules]]\nold = \"user_\"\nnew = \"u/\"\n[[rules]]\nold = \"order_\"\nnew = \"u/\"",
+	} {
+		_, err = parse("--key-rewrite-file", writeRules(content))
+		require.True(t, berrors.Is(err, berrors.ErrInvalidArgument), content)
+	}
+
+	// the single rule of --key-rewrite is one of the rewrites.
+	cfg, err = parse("--key-rewrite", "prod_=staging_")
+	require.NoError(t, err)
+	require.Len(t, cfg.rawKeyRewrites(kvrpcpb.APIVersion_V1, 0, 0), 1)
+	require.Empty(t, (&RestoreRawConfig{}).rawKeyRewrites(kvrpcpb.APIVersion_V2, 0, 0))
+}
+
+func TestParseMaskRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mask.toml")
+	require.NoError(t, os.WriteFile(path, []byte("[[rules]]\nprefix = \"7573657273\"\nmethod = \"hash\"\n"), 0o644))
+	parse := func(cfg *RestoreRawConfig, args ...string) error {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags.String(flagMaskRules, "", "")
+		require.NoError(t, flags.Parse(args))
+		return cfg.parseMaskRules(flags)
+	}
+
+	cfg := &RestoreRawConfig{}
+	require.NoError(t, parse(cfg))
+	require.Empty(t, cfg.MaskRules)
+	require.NoError(t, parse(cfg, "--mask-rules", path))
+	require.Len(t, cfg.MaskRules, 1)
+
+	// the logical restore can't work with the options relying on ingesting.
+	for _, cfg := range []*RestoreRawConfig{
+		{Preview: true},
+		{MergeStorages: []string{"local:///tmp/other"}},
+		{KeyRewriteOld: []byte("a"), KeyRewriteNew: []byte("b")},
+	} {
+		require.True(t, berrors.Is(parse(cfg, "--mask-rules", path), berrors.ErrInvalidArgument))
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+
+	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// taskResult is the result artifact of a task, which is written into the storage
+// once the task finishes, so that external workflow engines can consume it.
+type taskResult struct {
+	*metautil.Result
+	file    string
+	storage storage.ExternalStorage
+}
+
+func newTaskResult(cmdName, file string) *taskResult {
+	return &taskResult{Result: metautil.NewResult(cmdName), file: file}
+}
+
+// output records the file in the storage as an output of the task.
+func (r *taskResult) output(name, file string) {
+	if r.storage != nil {
+		r.Outputs[name] = r.storage.URI() + "/" + file
+	}
+}
+
+// finish writes the result artifact of the task finished with err. The task is
+// not affected by the artifact, so the failure is only logged. Nothing is written
+// if the task fails before opening the storage.
+func (r *taskResult) finish(err error) {
+	if r.storage == nil {
+		return
+	}
+	r.Finish(err)
+	if err := metautil.WriteResult(context.Background(), r.storage, r.file, r.Result); err != nil {
+		log.Warn("failed to write the result artifact", zap.String("file", r.file), zap.Error(err))
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/BurntSushi/toml"
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/utils"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RuntimeConfig is the subset of the config file which can be reloaded
+// while a task is running. Unset fields keep their current values.
+type RuntimeConfig struct {
+	LogLevel *string `json:"log-level" toml:"log-level"`
+	// RateLimit is in MiB/s per node, the same as the `--ratelimit` flag.
+	RateLimit   *uint64 `json:"ratelimit" toml:"ratelimit"`
+	Concurrency *uint32 `json:"concurrency" toml:"concurrency"`
+	// IngestRateLimit is in MiB/s per node, the same as the `--ingest-ratelimit` flag of restore.
+	IngestRateLimit *uint64 `json:"ingest-ratelimit" toml:"ingest-ratelimit"`
+	// Paused pauses or resumes the restore.
+	Paused *bool `json:"paused" toml:"paused"`
+}
+
+var runtimeConfigPath = struct {
+	sync.Mutex
+	path string
+}{}
+
+// reloadSignalTrapped is set once a config file is given, after which SIGHUP reloads the
+// config file instead of terminating the process.
+var reloadSignalTrapped int32
+
+// LoadRuntimeConfig reads the reloadable settings from a TOML file.
+func LoadRuntimeConfig(path string) (*RuntimeConfig, error) {
+	cfg := &RuntimeConfig{}
+	if _, err := toml.DecodeFile(path, cfg); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to load config file %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Apply applies the runtime config to the logger and the given settings.
+func (cfg *RuntimeConfig) Apply(settings *utils.DynamicSettings) error {
+	if cfg.LogLevel != nil {
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(*cfg.LogLevel)); err != nil {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "invalid log level '%s'", *cfg.LogLevel)
+		}
+		log.SetLevel(level)
+		log.Info("log level updated", zap.Stringer("level", level))
+	}
+	settings.Update(func(ts *utils.TaskSettings) {
+		if cfg.RateLimit != nil {
+			ts.RateLimit = *cfg.RateLimit * units.MiB
+		}
+		if cfg.Concurrency != nil && *cfg.Concurrency > 0 {
+			ts.Concurrency = *cfg.Concurrency
+		}
+		if cfg.IngestRateLimit != nil {
+			ts.IngestRateLimit = *cfg.IngestRateLimit * units.MiB
+		}
+		if cfg.Paused != nil {
+			ts.Paused = *cfg.Paused
+		}
+	})
+	return nil
+}
+
+// CurrentRuntimeConfig returns the current settings of the running task.
+func CurrentRuntimeConfig() *RuntimeConfig {
+	current := utils.GlobalDynamicSettings().Load()
+	rateLimit := current.RateLimit / units.MiB
+	ingestRateLimit := current.IngestRateLimit / units.MiB
+	return &RuntimeConfig{
+		RateLimit:       &rateLimit,
+		Concurrency:     &current.Concurrency,
+		IngestRateLimit: &ingestRateLimit,
+		Paused:          &current.Paused,
+	}
+}
+
+// registerRuntimeConfig initializes the global dynamic settings by the task config,
+// then applies the config file (if any) so that it takes priority over the flags,
+// the same as the later reloads.
+func registerRuntimeConfig(cfg *Config) error {
+	_, err := registerTaskSettings(cfg, utils.TaskSettings{
+		RateLimit:   cfg.RateLimit,
+		Concurrency: cfg.Concurrency,
+	})
+	return errors.Trace(err)
+}
+
+// registerRestoreRuntimeConfig is registerRuntimeConfig of the raw restore, whose ingest rate
+// limit is reloadable as well.
+func registerRestoreRuntimeConfig(cfg *RestoreRawConfig) error {
+	loaded, err := registerTaskSettings(&cfg.Config, utils.TaskSettings{
+		RateLimit:       cfg.RateLimit,
+		Concurrency:     cfg.Concurrency,
+		IngestRateLimit: cfg.IngestRateLimit,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.IngestRateLimit = loaded.IngestRateLimit
+	return nil
+}
+
+func registerTaskSettings(cfg *Config, initial utils.TaskSettings) (utils.TaskSettings, error) {
+	settings := utils.GlobalDynamicSettings()
+	settings.Store(initial)
+
+	runtimeConfigPath.Lock()
+	runtimeConfigPath.path = cfg.ConfigFile
+	runtimeConfigPath.Unlock()
+	if len(cfg.ConfigFile) == 0 {
+		return initial, nil
+	}
+	atomic.StoreInt32(&reloadSignalTrapped, 1)
+	if err := ReloadRuntimeConfig(); err != nil {
+		return utils.TaskSettings{}, errors.Trace(err)
+	}
+	loaded := settings.Load()
+	cfg.RateLimit, cfg.Concurrency = loaded.RateLimit, loaded.Concurrency
+	return loaded, nil
+}
+
+// HandleReloadSignal reloads the config file on SIGHUP. It returns false if no config file
+// is given, in which case SIGHUP terminates the process gracefully as the other signals.
+func HandleReloadSignal() bool {
+	if atomic.LoadInt32(&reloadSignalTrapped) == 0 {
+		return false
+	}
+	if err := ReloadRuntimeConfig(); err != nil {
+		log.Warn("failed to reload config on SIGHUP", zap.Error(err))
+	}
+	return true
+}
+
+// ReloadRuntimeConfig reloads the config file of the running task.
+// It is called on SIGHUP or by the status server.
+func ReloadRuntimeConfig() error {
+	runtimeConfigPath.Lock()
+	path := runtimeConfigPath.path
+	runtimeConfigPath.Unlock()
+	if len(path) == 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "no config file specified, please set --config")
+	}
+	cfg, err := LoadRuntimeConfig(path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("reload config file", zap.String("path", path))
+	return cfg.Apply(utils.GlobalDynamicSettings())
+}
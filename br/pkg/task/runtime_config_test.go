@@ -0,0 +1,72 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/go-units"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+func TestRuntimeConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "br.toml")
+	require.NoError(t, os.WriteFile(path, []byte("ratelimit = 64\nconcurrency = 8\n"), 0o600))
+
+	cfg := &Config{RateLimit: 10 * units.MiB, Concurrency: 4, ConfigFile: path}
+	require.NoError(t, registerRuntimeConfig(cfg))
+	require.Equal(t, uint64(64*units.MiB), cfg.RateLimit)
+	require.Equal(t, uint32(8), cfg.Concurrency)
+
+	require.NoError(t, os.WriteFile(path, []byte("ratelimit = 32\n"), 0o600))
+	// SIGHUP reloads the config file once it's given.
+	require.True(t, HandleReloadSignal())
+	require.Equal(t, utils.TaskSettings{RateLimit: 32 * units.MiB, Concurrency: 8}, utils.GlobalDynamicSettings().Load())
+	current := CurrentRuntimeConfig()
+	require.Equal(t, uint64(32), *current.RateLimit)
+	require.Equal(t, uint32(8), *current.Concurrency)
+
+	require.NoError(t, os.WriteFile(path, []byte("log-level = \"unknown\"\n"), 0o600))
+	require.Error(t, ReloadRuntimeConfig())
+
+	require.NoError(t, registerRuntimeConfig(&Config{}))
+	require.Error(t, ReloadRuntimeConfig())
+}
+
+func TestRestoreRuntimeConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "br.toml")
+	require.NoError(t, os.WriteFile(path, []byte("ingest-ratelimit = 16\n"), 0o600))
+
+	cfg := &RestoreRawConfig{}
+	cfg.IngestRateLimit = 64 * units.MiB
+	require.NoError(t, registerRestoreRuntimeConfig(cfg))
+	require.Equal(t, uint64(64*units.MiB), cfg.IngestRateLimit)
+
+	cfg.ConfigFile = path
+	require.NoError(t, registerRestoreRuntimeConfig(cfg))
+	require.Equal(t, uint64(16*units.MiB), cfg.IngestRateLimit)
+	require.False(t, *CurrentRuntimeConfig().Paused)
+
+	require.NoError(t, os.WriteFile(path, []byte("paused = true\n"), 0o600))
+	require.NoError(t, ReloadRuntimeConfig())
+	require.Equal(t, utils.TaskSettings{IngestRateLimit: 16 * units.MiB, Paused: true}, utils.GlobalDynamicSettings().Load())
+	current := CurrentRuntimeConfig()
+	require.Equal(t, uint64(16), *current.IngestRateLimit)
+	require.True(t, *current.Paused)
+	require.NoError(t, registerRuntimeConfig(&Config{}))
+}
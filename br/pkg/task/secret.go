@@ -0,0 +1,179 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os/exec"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// The encrypted values are in the form of `DECRYPT[base64 of ciphertext]`, which is BR's own
+// scheme instead of the format of any encryption tool: the ciphertext is whatever the command
+// of --decrypt-command decrypts, e.g.
+//
+//	DECRYPT[$(age --encrypt -r $RECIPIENT secret.txt | base64 -w0)] by `age --decrypt -i key.txt`
+//	DECRYPT[$(sops --encrypt --input-type binary --output-type json secret.txt | base64 -w0)]
+//	  by `sops --decrypt --input-type json --output-type binary /dev/stdin`
+//
+// The values encrypted in place by SOPS, i.e. `ENC[AES256_GCM,data:...]`, are not supported,
+// since they can only be decrypted with the sops metadata of the whole file.
+const (
+	encryptedValuePrefix = "DECRYPT["
+	encryptedValueSuffix = "]"
+	// sopsValuePrefix is the prefix of the values encrypted in place by SOPS.
+	sopsValuePrefix = "ENC[AES256_GCM,"
+)
+
+// secretConfig is the part of the config file holding the credentials, which is loaded once
+// when the task starts. The values may be encrypted, see decryptValue.
+type secretConfig struct {
+	S3 struct {
+		AccessKey       string `toml:"access-key"`
+		SecretAccessKey string `toml:"secret-access-key"`
+	} `toml:"s3"`
+	Azblob struct {
+		AccountKey string `toml:"account-key"`
+	} `toml:"azblob"`
+	SFTP struct {
+		Password string `toml:"password"`
+	} `toml:"sftp"`
+	Crypter struct {
+		Key string `toml:"key"`
+	} `toml:"crypter"`
+}
+
+func loadSecretConfig(path string) (*secretConfig, error) {
+	secrets := &secretConfig{}
+	if len(path) == 0 {
+		return secrets, nil
+	}
+	if _, err := toml.DecodeFile(path, secrets); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to load config file %s: %v", path, err)
+	}
+	return secrets, nil
+}
+
+func isEncryptedValue(value string) bool {
+	return strings.HasPrefix(value, encryptedValuePrefix) && strings.HasSuffix(value, encryptedValueSuffix)
+}
+
+// decryptValue decrypts the value in the form of `DECRYPT[base64 of ciphertext]` by piping the
+// ciphertext into the command, which prints the plaintext, e.g. `age --decrypt -i key.txt`.
+// The other values are returned as is.
+func decryptValue(ctx context.Context, command, value string) (string, error) {
+	if strings.HasPrefix(value, sopsValuePrefix) {
+		return "", errors.Annotatef(berrors.ErrInvalidArgument,
+			"the value encrypted in place by SOPS is not supported, encrypt it into %s...%s instead",
+			encryptedValuePrefix, encryptedValueSuffix)
+	}
+	if !isEncryptedValue(value) {
+		return value, nil
+	}
+	encoded := strings.TrimSuffix(strings.TrimPrefix(value, encryptedValuePrefix), encryptedValueSuffix)
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errors.Annotatef(berrors.ErrInvalidArgument, "the encrypted value is not base64 encoded: %v", err)
+	}
+	args, err := splitCommand(command)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if len(args) == 0 {
+		return "", errors.Annotate(berrors.ErrInvalidArgument, "--"+flagDecryptCommand+" is required to decrypt the encrypted values")
+	}
+	// nolint:gosec
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(ciphertext)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		return "", errors.Annotatef(berrors.ErrInvalidArgument, "failed to decrypt the value by '%s': %v, %s",
+			command, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+// splitCommand splits the command line into the program and its arguments by the spaces, the
+// single or double quoted parts are kept as a whole, e.g. `age -i "/etc/br keys/key.txt"`.
+func splitCommand(command string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		inArg   bool
+		quote   rune
+	)
+	for _, r := range command {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "unterminated quote in the command %s", command)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// applySecrets fills the storage credentials not set by the flags with the ones of the
+// config file, then decrypts them.
+func (cfg *Config) applySecrets(ctx context.Context, secrets *secretConfig, command string) error {
+	values := []struct {
+		value  *string
+		secret string
+	}{
+		{&cfg.S3.AccessKey, secrets.S3.AccessKey},
+		{&cfg.S3.SecretAccessKey, secrets.S3.SecretAccessKey},
+		{&cfg.Azblob.AccountKey, secrets.Azblob.AccountKey},
+		{&cfg.SFTP.Password, secrets.SFTP.Password},
+	}
+	for _, v := range values {
+		if len(*v.value) == 0 {
+			*v.value = v.secret
+		}
+		plaintext, err := decryptValue(ctx, command, *v.value)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		*v.value = plaintext
+	}
+	return nil
+}
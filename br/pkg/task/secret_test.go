@@ -0,0 +1,99 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+func encryptedValue(plaintext string) string {
+	return encryptedValuePrefix + base64.StdEncoding.EncodeToString([]byte(plaintext)) + encryptedValueSuffix
+}
+
+func TestDecryptValue(t *testing.T) {
+	ctx := context.Background()
+	// `cat` stands for the decrypt command, which prints the ciphertext as is.
+	value, err := decryptValue(ctx, "cat", encryptedValue("secret\n"))
+	require.NoError(t, err)
+	require.Equal(t, "secret", value)
+
+	value, err = decryptValue(ctx, "false", "plain")
+	require.NoError(t, err)
+	require.Equal(t, "plain", value)
+
+	_, err = decryptValue(ctx, "cat", "DECRYPT[not base64]")
+	require.Error(t, err)
+	_, err = decryptValue(ctx, "false", encryptedValue("secret"))
+	require.Error(t, err)
+	_, err = decryptValue(ctx, "", encryptedValue("secret"))
+	require.Error(t, err)
+	_, err = decryptValue(ctx, "cat", "ENC[AES256_GCM,data:Tr7o,iv:1=,tag:2=,type:str]")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+
+	// the path of the decrypt command may have spaces.
+	dir := filepath.Join(t.TempDir(), "br keys")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "key.txt"), []byte("secret"), 0o600))
+	value, err = decryptValue(ctx, `cat "`+filepath.Join(dir, "key.txt")+`"`, encryptedValue("ignored"))
+	require.NoError(t, err)
+	require.Equal(t, "secret", value)
+}
+
+func TestSplitCommand(t *testing.T) {
+	for command, expected := range map[string][]string{
+		"":                                nil,
+		"  age --decrypt  -i key.txt ":    {"age", "--decrypt", "-i", "key.txt"},
+		`age -i "/etc/br keys/key.txt"`:   {"age", "-i", "/etc/br keys/key.txt"},
+		`sh -c 'echo "a b"'`:              {"sh", "-c", `echo "a b"`},
+		`"C:\Program Filesgege.exe" -d`: {`C:\Program Filesgege.exe`, "-d"},
+		`age -i ""`:                       {"age", "-i", ""},
+	} {
+		args, err := splitCommand(command)
+		require.NoError(t, err, command)
+		require.Equal(t, expected, args, command)
+	}
+	_, err := splitCommand(`age -i "key.txt`)
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
+
+func TestApplySecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "br.toml")
+	content := "ratelimit = 64\n" +
+		"[s3]\naccess-key = \"ak\"\nsecret-access-key = \"" + encryptedValue("sk") + "\"\n" +
+		"[crypter]\nkey = \"" + encryptedValue("0123") + "\"\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	secrets, err := loadSecretConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, "ak", secrets.S3.AccessKey)
+
+	cfg := &Config{}
+	// the flags take priority over the config file.
+	cfg.S3.AccessKey = "flag-ak"
+	require.NoError(t, cfg.applySecrets(context.Background(), secrets, "cat"))
+	require.Equal(t, "flag-ak", cfg.S3.AccessKey)
+	require.Equal(t, "sk", cfg.S3.SecretAccessKey)
+	require.Empty(t, cfg.Azblob.AccountKey)
+
+	secrets, err = loadSecretConfig("")
+	require.NoError(t, err)
+	require.Empty(t, secrets.Crypter.Key)
+}
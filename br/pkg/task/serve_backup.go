@@ -0,0 +1,105 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/mount"
+	"github.com/tikv/migration/br/pkg/sst"
+	"github.com/tikv/migration/br/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	flagServeAddr      = "addr"
+	flagBlockCacheSize = "block-cache-size"
+
+	defaultServeAddr      = "127.0.0.1:8288"
+	defaultBlockCacheSize = 256 * units.MiB
+)
+
+// ServeBackupConfig is the configuration of `br serve-backup`, which serves a raw kv backup
+// read-only by HTTP without restoring it.
+type ServeBackupConfig struct {
+	Config
+
+	// Addr is the address to serve the query API.
+	Addr string `json:"addr" toml:"addr"`
+	// Format is the format of the keys and values in the query API.
+	Format string `json:"format" toml:"format"`
+	// BlockCacheSize is the max bytes of the decompressed SST blocks cached.
+	BlockCacheSize uint64 `json:"block-cache-size" toml:"block-cache-size"`
+}
+
+// DefineServeBackupFlags defines the flags of `br serve-backup`.
+func DefineServeBackupFlags(flags *pflag.FlagSet) {
+	flags.String(flagServeAddr, defaultServeAddr, "the address to serve the query API of the backup")
+	flags.String(flagKeyFormat, "hex", "the format of the keys and values in the query API, support raw|escaped|hex")
+	flags.Uint64(flagBlockCacheSize, defaultBlockCacheSize,
+		"the max bytes of the SST blocks cached in memory, the blocks are fetched from the storage on demand")
+}
+
+// ParseFromFlags parses the serve-backup flags from the flag set.
+func (cfg *ServeBackupConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.Addr, err = flags.GetString(flagServeAddr); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Format, err = flags.GetString(flagKeyFormat); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = utils.ParseKey(cfg.Format, ""); err != nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s '%s'", flagKeyFormat, cfg.Format)
+	}
+	if cfg.BlockCacheSize, err = flags.GetUint64(flagBlockCacheSize); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// OpenBackupSnapshot reads the backupmeta of the raw kv backup in the storage, and returns
+// the snapshot serving its files.
+func OpenBackupSnapshot(ctx context.Context, cfg *ServeBackupConfig) (*mount.Snapshot, error) {
+	if err := cfg.ResolveStorageByName(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	_, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &cfg.Config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !backupMeta.IsRawKv {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, "only the raw kv backups can be served")
+	}
+	var files []*backuppb.File
+	reader := metautil.NewMetaReader(backupMeta, s, &cfg.CipherInfo)
+	if err = reader.ReadDataFiles(ctx, func(f *backuppb.File) error {
+		files = append(files, f)
+		return nil
+	}); err != nil {
+		return nil, errors.Trace(err)
+	}
+	log.Info("open the backup snapshot", zap.Int("files", len(files)),
+		zap.Stringer("api-version", backupMeta.ApiVersion))
+	cache := sst.NewBlockCache(int64(cfg.BlockCacheSize))
+	return mount.NewSnapshot(s, backupMeta.ApiVersion, files, &cfg.CipherInfo, cache), nil
+}
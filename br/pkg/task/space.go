@@ -0,0 +1,101 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/conn"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// restoreSpaceShortages returns the stores whose available space is less than the bytes ingested
+// into them times ratio. The stores not reporting the available space are skipped.
+func restoreSpaceShortages(report *restore.ImpactReport, available map[uint64]uint64, ratio float64) []string {
+	var shortages []string
+	for _, s := range report.Stores {
+		required := uint64(float64(s.Bytes) * ratio)
+		if avail := available[s.StoreID]; avail > 0 && avail < required {
+			shortages = append(shortages, fmt.Sprintf("store %d at %s requires %s but %s is available",
+				s.StoreID, s.Address, units.BytesSize(float64(required)), units.BytesSize(float64(avail))))
+		}
+	}
+	return shortages
+}
+
+// checkLocalBackupSpace checks the local:// storage has the free space for the backup of the ranges,
+// whose size is estimated by the approximate size of their regions, times ratio. The storage is
+// checked as seen by BR, which is the disk shared with the stores, e.g. by NFS.
+func checkLocalBackupSpace(
+	ctx context.Context, mgr *conn.Mgr, backend *backuppb.StorageBackend, ranges []rtree.Range, ratio float64,
+) error {
+	local := backend.GetLocal()
+	if local == nil {
+		return nil
+	}
+	var size uint64
+	leaderSizes := make(map[uint64]int64)
+	for _, rg := range ranges {
+		stats, err := mgr.GetRegionStats(ctx, rg.StartKey, rg.EndKey)
+		if err != nil {
+			return errors.Annotate(err, "failed to estimate the size of the backup")
+		}
+		size += uint64(stats.StorageSize) * units.MiB
+		for storeID, leaderSize := range stats.StoreLeaderSize {
+			leaderSizes[storeID] += leaderSize
+		}
+	}
+	storeIDs := make([]uint64, 0, len(leaderSizes))
+	for storeID := range leaderSizes {
+		storeIDs = append(storeIDs, storeID)
+	}
+	sort.Slice(storeIDs, func(i, j int) bool { return storeIDs[i] < storeIDs[j] })
+	for _, storeID := range storeIDs {
+		// each store writes the files of the regions it leads.
+		log.Info("the space required by the backup", zap.Uint64("store-id", storeID),
+			zap.Uint64("required", uint64(float64(leaderSizes[storeID]*units.MiB)*ratio)))
+	}
+	return errors.Trace(checkLocalSpace(local.Path, size, ratio))
+}
+
+// checkLocalSpace checks the file system of the path has the free space for size bytes times ratio.
+// It's skipped if the free space can't be told, e.g. the path doesn't exist on the host of BR.
+func checkLocalSpace(path string, size uint64, ratio float64) error {
+	free, err := storage.LocalFreeSpace(path)
+	if err != nil {
+		log.Warn("failed to get the free space, skip checking it", zap.String("path", path), zap.Error(err))
+		return nil
+	}
+	required := uint64(float64(size) * ratio)
+	log.Info("the space required at the local storage", zap.String("path", path),
+		zap.Uint64("required", required), zap.Uint64("available", free))
+	if free < required {
+		return errors.Annotatef(berrors.ErrStorageNoSpace,
+			"%s requires %s for about %s of data by --%s=%v, but %s is available",
+			path, units.BytesSize(float64(required)), units.BytesSize(float64(size)), flagSpaceReserveRatio, ratio,
+			units.BytesSize(float64(free)))
+	}
+	return nil
+}
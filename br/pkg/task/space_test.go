@@ -0,0 +1,53 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/restore"
+)
+
+func TestRestoreSpaceShortages(t *testing.T) {
+	report := &restore.ImpactReport{Stores: []*restore.StoreImpact{
+		{StoreID: 1, Address: "tikv-0:20160", Bytes: 1000},
+		{StoreID: 2, Address: "tikv-1:20160", Bytes: 1000},
+		{StoreID: 3, Address: "tikv-2:20160", Bytes: 1000},
+		{StoreID: 4, Address: "tikv-3:20160"},
+	}}
+	// store 3 doesn't report its available space.
+	available := map[uint64]uint64{1: 1500, 2: 1100, 4: 10}
+	require.Empty(t, restoreSpaceShortages(report, available, 1))
+	shortages := restoreSpaceShortages(report, available, 1.2)
+	require.Len(t, shortages, 1)
+	require.Contains(t, shortages[0], "store 2 at tikv-1:20160")
+	require.Len(t, restoreSpaceShortages(report, available, 2), 2)
+}
+
+func TestCheckLocalSpace(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("checking the free space isn't supported on windows")
+	}
+	dir := t.TempDir()
+	require.NoError(t, checkLocalSpace(dir, 1024, 1.2))
+	err := checkLocalSpace(dir, 1<<62, 1.2)
+	require.Error(t, err)
+	require.True(t, berrors.ErrStorageNoSpace.Equal(err))
+	// the path isn't seen by BR.
+	require.NoError(t, checkLocalSpace(dir+"/not-exist", 1<<62, 1.2))
+}
@@ -0,0 +1,135 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/migration/br/pkg/backup"
+	"github.com/tikv/migration/br/pkg/encryption"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// The variables of the templated --storage of backup, e.g. "s3://bucket/{cluster_id}/{date}/{backup_ts}".
+const (
+	templateClusterID = "{cluster_id}"
+	// templateDate is the UTC date of the backup ts, or of the start of the backup.
+	templateDate     = "{date}"
+	templateBackupTS = "{backup_ts}"
+	templateName     = "{name}"
+
+	templateDateLayout = "2006-01-02"
+)
+
+var storageTemplateVar = regexp.MustCompile(`\{[a-zA-Z_]+\}`)
+
+// storageTemplateVars are the values of the variables of the templated storage.
+type storageTemplateVars struct {
+	ClusterID uint64
+	BackupTS  uint64
+	Name      string
+	Date      time.Time
+}
+
+func isStorageTemplate(rawURL string) bool {
+	return storageTemplateVar.MatchString(rawURL)
+}
+
+// expandStorageTemplate replaces the variables of the template by their values.
+func expandStorageTemplate(template string, vars storageTemplateVars) (string, error) {
+	var err error
+	expanded := storageTemplateVar.ReplaceAllStringFunc(template, func(v string) string {
+		switch v {
+		case templateClusterID:
+			return strconv.FormatUint(vars.ClusterID, 10)
+		case templateDate:
+			return vars.Date.UTC().Format(templateDateLayout)
+		case templateBackupTS:
+			if vars.BackupTS == 0 {
+				err = errors.Annotatef(berrors.ErrInvalidArgument, "%s is unknown to expand the storage", v)
+			}
+			return strconv.FormatUint(vars.BackupTS, 10)
+		case templateName:
+			if len(vars.Name) == 0 {
+				err = errors.Annotatef(berrors.ErrInvalidArgument, "%s requires --%s", v, flagName)
+			}
+			return vars.Name
+		default:
+			err = errors.Annotatef(berrors.ErrInvalidArgument, "unknown variable %s of the storage, it should be one of %s",
+				v, strings.Join([]string{templateClusterID, templateDate, templateBackupTS, templateName}, ", "))
+			return v
+		}
+	})
+	return expanded, errors.Trace(err)
+}
+
+// checkStorageTemplate checks the templated storage can be expanded once for the backup.
+func (cfg *RawKvConfig) checkStorageTemplate() error {
+	if !isStorageTemplate(cfg.Storage) {
+		return nil
+	}
+	// the unknown variables and the name are checked before connecting to the cluster.
+	if _, err := expandStorageTemplate(cfg.Storage, storageTemplateVars{BackupTS: 1, Name: cfg.Name}); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Resume {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s requires the storage of the interrupted backup, not the template %s", flagResume, cfg.Storage)
+	}
+	if len(cfg.BackupPoints) > 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s can't be templated with --%s", flagStorage, flagBackupPoints)
+	}
+	return nil
+}
+
+// expandStorage expands the templated Storage, which is kept in StorageTemplate. If the
+// template has the backup ts, the backup ts is decided beforehand, protected by the GC
+// safe point, and returned.
+func (cfg *RawKvConfig) expandStorage(ctx context.Context, client *backup.Client, backupTSEnabled bool) (uint64, error) {
+	if !isStorageTemplate(cfg.Storage) {
+		return 0, nil
+	}
+	vars := storageTemplateVars{ClusterID: client.GetClusterID(), Name: cfg.Name, Date: time.Now()}
+	if strings.Contains(cfg.Storage, templateBackupTS) {
+		if !backupTSEnabled {
+			return 0, errors.Annotatef(berrors.ErrInvalidArgument,
+				"%s requires API V2, current api version: %s", templateBackupTS, client.GetCurAPIVersion())
+		}
+		vars.BackupTS = cfg.snapshotTS
+		if vars.BackupTS == 0 {
+			var err error
+			if vars.BackupTS, err = client.UpdateBRGCSafePoint(ctx, cfg.SafeInterval); err != nil {
+				return 0, errors.Trace(err)
+			}
+		}
+		vars.Date = oracle.GetTimeFromTS(vars.BackupTS)
+	}
+	expanded, err := expandStorageTemplate(cfg.Storage, vars)
+	if err != nil {
+		return 0, errors.Annotatef(err, "failed to expand the storage %s", cfg.Storage)
+	}
+	log.Info("expand the templated storage", zap.String("template", encryption.RedactURL(cfg.Storage)),
+		zap.String("storage", encryption.RedactURL(expanded)))
+	cfg.StorageTemplate, cfg.Storage = cfg.Storage, expanded
+	return vars.BackupTS, nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+func TestExpandStorageTemplate(t *testing.T) {
+	vars := storageTemplateVars{
+		ClusterID: 6987,
+		BackupTS:  435678,
+		Name:      "daily",
+		Date:      time.Date(2022, 7, 1, 23, 30, 0, 0, time.FixedZone("UTC-8", -8*3600)),
+	}
+	expanded, err := expandStorageTemplate("s3://bucket/{cluster_id}/{date}/{name}-{backup_ts}?region=us-west-2", vars)
+	require.NoError(t, err)
+	require.Equal(t, "s3://bucket/6987/2022-07-02/daily-435678?region=us-west-2", expanded)
+
+	require.False(t, isStorageTemplate("local:///backup/daily"))
+	expanded, err = expandStorageTemplate("local:///backup/daily", vars)
+	require.NoError(t, err)
+	require.Equal(t, "local:///backup/daily", expanded)
+
+	_, err = expandStorageTemplate("s3://bucket/{cluster}", vars)
+	require.ErrorIs(t, err, berrors.ErrInvalidArgument)
+	_, err = expandStorageTemplate("s3://bucket/{name}", storageTemplateVars{})
+	require.ErrorIs(t, err, berrors.ErrInvalidArgument)
+	_, err = expandStorageTemplate("s3://bucket/{backup_ts}", storageTemplateVars{})
+	require.ErrorIs(t, err, berrors.ErrInvalidArgument)
+}
+
+func TestCheckStorageTemplate(t *testing.T) {
+	cfg := &RawKvConfig{}
+	cfg.Storage = "s3://bucket/{cluster_id}/{date}/{backup_ts}"
+	require.NoError(t, cfg.checkStorageTemplate())
+
+	cfg.Resume = true
+	require.ErrorIs(t, cfg.checkStorageTemplate(), berrors.ErrInvalidArgument)
+	cfg.Resume = false
+	cfg.BackupPoints = []uint64{1, 2}
+	require.ErrorIs(t, cfg.checkStorageTemplate(), berrors.ErrInvalidArgument)
+	cfg.BackupPoints = nil
+
+	cfg.Storage = "s3://bucket/{name}"
+	require.ErrorIs(t, cfg.checkStorageTemplate(), berrors.ErrInvalidArgument)
+	cfg.Name = "daily"
+	require.NoError(t, cfg.checkStorageTemplate())
+	cfg.Storage = "s3://bucket/{unknown}"
+	require.ErrorIs(t, cfg.checkStorageTemplate(), berrors.ErrInvalidArgument)
+}
@@ -0,0 +1,280 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package task
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/migration/br/pkg/conn"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/handover"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/stream"
+	"github.com/tikv/migration/br/pkg/utils"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	flagStreamStartTS       = "start-ts"
+	flagStreamFlushInterval = "flush-interval"
+
+	defaultStreamFlushInterval = 10 * time.Second
+
+	// handoverStateSafePointID is the ID of the service safe point passed on upgrade.
+	handoverStateSafePointID = "stream-safe-point-id"
+)
+
+// errStreamHandedOver stops the stream backup handed over to the new process.
+var errStreamHandedOver = errors.New("the stream backup is handed over")
+
+// StreamConfig is the configuration of `br stream`, which backs up the changes of the raw
+// keys continuously into the changelog of --storage.
+type StreamConfig struct {
+	Config
+
+	// StartKey and EndKey are the user keys of the range, without the API V2 prefix.
+	StartKey []byte `json:"start-key" toml:"start-key"`
+	EndKey   []byte `json:"end-key" toml:"end-key"`
+	// StartTS is the ts after which the changes are backed up, it defaults to the current ts.
+	// It's ignored if the storage has a changelog already, which is resumed from its checkpoint.
+	StartTS uint64 `json:"start-ts" toml:"start-ts"`
+	// FlushInterval is the interval of persisting the changes and advancing the checkpoint.
+	FlushInterval time.Duration `json:"flush-interval" toml:"flush-interval"`
+}
+
+// DefineStreamStartFlags defines the flags of `br stream start`.
+func DefineStreamStartFlags(command *cobra.Command) {
+	command.Flags().String(flagKeyFormat, "hex", "start/end key format, support raw|escaped|hex")
+	command.Flags().String(flagStartKey, "", "the start key of the range to back up, key is inclusive")
+	command.Flags().String(flagEndKey, "", "the end key of the range to back up, key is exclusive")
+	command.Flags().String(flagStreamStartTS, "",
+		"the ts after which the changes are backed up, a TSO or a datetime like '2022-05-01 12:00:00', "+
+			"defaults to the current ts. It's ignored when resuming the changelog in the storage")
+	command.Flags().Duration(flagStreamFlushInterval, defaultStreamFlushInterval,
+		"the interval of persisting the changes and advancing the checkpoint of the changelog")
+}
+
+// ParseFromFlags parses the config of `br stream start` from the flag set.
+func (cfg *StreamConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	raw := RawKvConfig{}
+	if err := raw.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Config, cfg.StartKey, cfg.EndKey = raw.Config, raw.StartKey, raw.EndKey
+
+	startTS, err := flags.GetString(flagStreamStartTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(startTS) > 0 {
+		if cfg.StartTS, err = parseTSString(startTS); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if cfg.FlushInterval, err = flags.GetDuration(flagStreamFlushInterval); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.FlushInterval <= 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must be positive", flagStreamFlushInterval)
+	}
+	return nil
+}
+
+// openChangelogStorage opens the storage of the changelog.
+func openChangelogStorage(ctx context.Context, cfg *Config) (storage.ExternalStorage, error) {
+	if len(cfg.Storage) == 0 {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, "--storage is required")
+	}
+	u, err := storage.ParseBackend(cfg.Storage, &cfg.BackendOptions)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return storage.New(ctx, u, storageOpts(cfg))
+}
+
+// RunStreamStart backs up the changes of the raw keys into the changelog continuously,
+// until ctx is done or an unrecoverable error occurs.
+func RunStreamStart(c context.Context, g glue.Glue, cmdName string, cfg *StreamConfig) error {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	s, err := openChangelogStorage(ctx, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer mgr.Close()
+	mgr.SetGRPCMaxMsgSize(cfg.GRPCMaxMsgSize)
+	mgr.SetDNSRefreshInterval(cfg.DNSRefreshInterval)
+	if err = setupStoreConnectivity(ctx, mgr, &cfg.Config); err != nil {
+		return errors.Trace(err)
+	}
+
+	pdClient := mgr.GetPDClient()
+	apiVersion, err := conn.GetTiKVApiVersion(ctx, pdClient, mgr.GetTLSConfig())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if apiVersion != kvrpcpb.APIVersion_V2 {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"stream backup requires API V2, current api version: %s", apiVersion)
+	}
+	// the changelog is taken over after the old process flushes it.
+	h := handover.Global()
+	if err = h.Ready(); err != nil {
+		return errors.Trace(err)
+	}
+	if err = h.WaitReleased(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	startTS := cfg.StartTS
+	if startTS == 0 {
+		physical, logical, err := pdClient.GetTS(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		startTS = oracle.ComposeTS(physical, logical)
+	}
+	writer, err := stream.NewWriter(ctx, s, startTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	checkpoint := writer.Checkpoint()
+	if err = utils.CheckGCSafePoint(ctx, pdClient, checkpoint); err != nil {
+		return errors.Trace(err)
+	}
+	// keep the service safe point of the old process, which is alive until its TTL.
+	safePointID := h.State(handoverStateSafePointID)
+	if len(safePointID) == 0 {
+		safePointID = utils.MakeSafePointID()
+	}
+	sp := utils.BRServiceSafePoint{
+		ID:       safePointID,
+		TTL:      int64(utils.DefaultBRGCSafePointTTL.Seconds()),
+		BackupTS: checkpoint,
+	}
+	if err = utils.UpdateServiceSafePoint(ctx, pdClient, sp); err != nil {
+		return errors.Trace(err)
+	}
+	h.SetState(handoverStateSafePointID, sp.ID)
+
+	keyRange := utils.FormatAPIV2KeyRange(cfg.StartKey, cfg.EndKey)
+	ranges := []rtree.Range{{StartKey: keyRange.Start, EndKey: keyRange.End}}
+	subscriber := stream.NewSubscriber(pdClient, mgr, pdClient.GetClusterID(ctx), writer.Append)
+	log.Info("start the stream backup", zap.String("task", cmdName),
+		zap.Uint64("checkpoint-ts", checkpoint), zap.String("storage", cfg.Storage))
+
+	eg, ectx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		return subscriber.Run(ectx, ranges, checkpoint)
+	})
+	eg.Go(func() error {
+		ticker := time.NewTicker(cfg.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ectx.Done():
+				return errors.Trace(ectx.Err())
+			case <-ticker.C:
+			}
+			n, err := writer.Flush(ectx, subscriber.Watermark())
+			if err != nil {
+				// the events are kept and flushed in the next round.
+				log.Warn("failed to flush the changelog", zap.Error(err))
+				continue
+			}
+			sp.BackupTS = writer.Checkpoint()
+			if err = utils.UpdateServiceSafePoint(ectx, pdClient, sp); err != nil {
+				log.Warn("failed to update the service safe point", zap.Error(err))
+			}
+			log.Info("flushed the changelog", zap.Int("events", n),
+				zap.Uint64("checkpoint-ts", sp.BackupTS),
+				zap.Duration("lag", time.Since(oracle.GetTimeFromTS(sp.BackupTS))))
+		}
+	})
+	var successor *handover.Successor
+	eg.Go(func() error {
+		for {
+			select {
+			case <-ectx.Done():
+				return nil
+			case <-h.Requested():
+			}
+			s, err := h.Upgrade(ectx)
+			if err != nil {
+				log.Warn("failed to upgrade the stream backup", zap.Error(err))
+				continue
+			}
+			successor = s
+			// stop the others to hand over the changelog.
+			return errStreamHandedOver
+		}
+	})
+	err = eg.Wait()
+	if successor != nil {
+		// persist what's received so far, the new process subscribes the changes from the checkpoint.
+		if _, ferr := writer.Flush(context.Background(), subscriber.Watermark()); ferr != nil {
+			log.Warn("failed to flush the changelog on upgrade", zap.Error(ferr))
+		}
+		sp.BackupTS = writer.Checkpoint()
+		if err = utils.UpdateServiceSafePoint(context.Background(), pdClient, sp); err != nil {
+			log.Warn("failed to update the service safe point on upgrade", zap.Error(err))
+		}
+		if err = successor.Release(); err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("the stream backup is handed over to the new process", zap.Int("pid", successor.Pid()),
+			zap.Uint64("checkpoint-ts", sp.BackupTS))
+		return nil
+	}
+	if c.Err() != nil {
+		// stopped by the user, persist what's received so far.
+		if _, ferr := writer.Flush(context.Background(), subscriber.Watermark()); ferr != nil {
+			log.Warn("failed to flush the changelog on exit", zap.Error(ferr))
+		}
+		log.Info("the stream backup is stopped", zap.Uint64("checkpoint-ts", writer.Checkpoint()))
+		return nil
+	}
+	return errors.Trace(err)
+}
+
+// RunStreamStatus returns the meta of the changelog in the storage.
+func RunStreamStatus(ctx context.Context, cfg *Config) (*metautil.ChangelogMeta, error) {
+	s, err := openChangelogStorage(ctx, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	meta, err := metautil.ReadChangelogMeta(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if meta == nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "no changelog is found in %s", cfg.Storage)
+	}
+	return meta, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/conn"
+	"github.com/tikv/migration/br/pkg/utils"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+)
+
+const (
+	// flagTotalThroughput is the throughput of the whole backup, from which the rate limit and
+	// concurrency per store are derived.
+	flagTotalThroughput = "total-throughput"
+
+	// throughputPerThread is the throughput a backup thread of TiKV is expected to reach.
+	throughputPerThread = 64 * units.MiB
+	// maxDerivedConcurrency caps the concurrency derived from the throughput, the thread pool
+	// of TiKV can't grow beyond its config anyway.
+	maxDerivedConcurrency = 8
+	// throughputCheckInterval is the interval of checking whether the number of stores changed.
+	throughputCheckInterval = time.Minute
+)
+
+// throughputSettings splits the total throughput in bytes/s among the stores. The concurrency
+// per store is derived from the rate limit per store unless concurrency is specified.
+func throughputSettings(total uint64, stores int, concurrency uint32) utils.TaskSettings {
+	if stores < 1 {
+		stores = 1
+	}
+	rateLimit := total / uint64(stores)
+	if rateLimit == 0 {
+		// 0 means unlimited.
+		rateLimit = 1
+	}
+	if concurrency == 0 {
+		concurrency = uint32((rateLimit + throughputPerThread - 1) / throughputPerThread)
+		if concurrency > maxDerivedConcurrency {
+			concurrency = maxDerivedConcurrency
+		}
+	}
+	return utils.TaskSettings{RateLimit: rateLimit, Concurrency: concurrency}
+}
+
+// countUpStores returns the number of the TiKV stores serving, which share the throughput.
+func countUpStores(ctx context.Context, pdClient pd.Client) (int, error) {
+	stores, err := conn.GetAllTiKVStores(ctx, pdClient, conn.SkipTiFlash)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	count := 0
+	for _, store := range stores {
+		if store.GetState() == metapb.StoreState_Up {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// applyTotalThroughput derives the rate limit and concurrency of cfg from cfg.TotalThroughput by
+// the current stores, then keeps adjusting them as the number of stores changes until ctx is done.
+// The BR side concurrency of the ranges is derived once, it can't be resized.
+func applyTotalThroughput(ctx context.Context, mgr *conn.Mgr, cfg *RawKvConfig) error {
+	if cfg.TotalThroughput == 0 {
+		return nil
+	}
+	stores, err := countUpStores(ctx, mgr.GetPDClient())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	total, userConcurrency := cfg.TotalThroughput, cfg.Concurrency
+	settings := throughputSettings(total, stores, userConcurrency)
+	cfg.RateLimit, cfg.Concurrency = settings.RateLimit, settings.Concurrency
+	utils.GlobalDynamicSettings().Store(settings)
+	log.Info("derive the settings from the total throughput",
+		zap.Uint64("total-throughput", total),
+		zap.Int("stores", stores),
+		zap.Uint64("rate-limit", settings.RateLimit),
+		zap.Uint32("concurrency", settings.Concurrency))
+
+	go func() {
+		ticker := time.NewTicker(throughputCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			current, err := countUpStores(ctx, mgr.GetPDClient())
+			if err != nil {
+				log.Warn("failed to count the stores, keep the current settings", zap.Error(err))
+				continue
+			}
+			if current == stores || current == 0 {
+				continue
+			}
+			log.Info("the number of stores changed, derive the settings again",
+				zap.Int("old-stores", stores), zap.Int("new-stores", current))
+			stores = current
+			derived := throughputSettings(total, stores, userConcurrency)
+			utils.GlobalDynamicSettings().Update(func(ts *utils.TaskSettings) {
+				ts.RateLimit = derived.RateLimit
+				if userConcurrency == 0 {
+					ts.Concurrency = derived.Concurrency
+				}
+			})
+		}
+	}()
+	return nil
+}
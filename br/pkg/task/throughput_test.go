@@ -0,0 +1,61 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"testing"
+
+	"github.com/docker/go-units"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+func TestThroughputSettings(t *testing.T) {
+	require.Equal(t, utils.TaskSettings{RateLimit: 100 * units.MiB, Concurrency: 2},
+		throughputSettings(400*units.MiB, 4, 0))
+	// the concurrency is capped, or kept if specified.
+	require.Equal(t, utils.TaskSettings{RateLimit: 2 * units.GiB, Concurrency: maxDerivedConcurrency},
+		throughputSettings(4*units.GiB, 2, 0))
+	require.Equal(t, utils.TaskSettings{RateLimit: 2 * units.GiB, Concurrency: 16},
+		throughputSettings(4*units.GiB, 2, 16))
+	// the rate limit is never 0, which means unlimited.
+	require.Equal(t, utils.TaskSettings{RateLimit: 1, Concurrency: 1}, throughputSettings(3, 4, 0))
+	require.Equal(t, utils.TaskSettings{RateLimit: 64 * units.MiB, Concurrency: 1},
+		throughputSettings(64*units.MiB, 0, 0))
+}
+
+func TestParseTotalThroughput(t *testing.T) {
+	parse := func(args ...string) (*RawKvConfig, error) {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags.Uint64(flagTotalThroughput, 0, "")
+		flags.Uint64(flagRateLimit, unlimited, "")
+		flags.Uint64(flagRateLimitUnit, units.MiB, "")
+		require.NoError(t, flags.Parse(args))
+		cfg := &RawKvConfig{}
+		return cfg, cfg.parseTotalThroughput(flags)
+	}
+	cfg, err := parse("--total-throughput", "300")
+	require.NoError(t, err)
+	require.Equal(t, uint64(300*units.MiB), cfg.TotalThroughput)
+
+	cfg, err = parse("--ratelimit", "10")
+	require.NoError(t, err)
+	require.Zero(t, cfg.TotalThroughput)
+
+	_, err = parse("--total-throughput", "300", "--ratelimit", "10")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
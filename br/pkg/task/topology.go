@@ -0,0 +1,73 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/conn"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// getClusterTopology returns the stores and the replication config of the cluster.
+func getClusterTopology(ctx context.Context, mgr *conn.Mgr) (*metautil.Topology, error) {
+	stores, err := conn.GetAllTiKVStoresWithRetry(ctx, mgr.GetPDClient(), conn.SkipTiFlash)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	replication, err := mgr.GetReplicationConfig(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return metautil.NewTopology(stores, replication.MaxReplicas, replication.LocationLabels), nil
+}
+
+// recordTopology records the topology of the backup cluster into the backup storage.
+// The backup is still usable without it, so the failure is only logged.
+func recordTopology(ctx context.Context, mgr *conn.Mgr, s storage.ExternalStorage) {
+	topology, err := getClusterTopology(ctx, mgr)
+	if err == nil {
+		err = metautil.WriteTopology(ctx, s, topology)
+	}
+	if err != nil {
+		log.Warn("failed to record the cluster topology, skip it", zap.Error(err))
+	}
+}
+
+// checkTopology warns if the target cluster cannot keep the placement of the backup cluster.
+func checkTopology(ctx context.Context, mgr *conn.Mgr, s storage.ExternalStorage) {
+	source, err := metautil.ReadTopology(ctx, s)
+	if err != nil {
+		log.Warn("failed to read the topology of the backup cluster, skip checking", zap.Error(err))
+		return
+	}
+	if source == nil {
+		log.Info("the backup doesn't record the cluster topology, skip checking")
+		return
+	}
+	target, err := getClusterTopology(ctx, mgr)
+	if err != nil {
+		log.Warn("failed to get the topology of the target cluster, skip checking", zap.Error(err))
+		return
+	}
+	for _, diff := range metautil.CompareTopology(source, target) {
+		logutil.WarnTerm("the topology of the target cluster differs from the backup cluster", zap.String("diff", diff))
+	}
+}
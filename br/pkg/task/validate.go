@@ -0,0 +1,116 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package task
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/redact"
+	"github.com/tikv/migration/br/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// BackupMetaValidation is the result of validating a backupmeta.
+type BackupMetaValidation struct {
+	Version int32
+	// Shards is the number of the shards of the file list in the V2 meta.
+	Shards     int
+	Files      int
+	TotalKvs   uint64
+	TotalBytes uint64
+	Size       uint64
+	// ChecksumAlgorithm is the recorded algorithm of the checksums of the shards.
+	ChecksumAlgorithm metautil.ChecksumAlgorithm
+}
+
+// ValidateBackupMeta reads the files of the backup lazily and checks that each of them is
+// in a backed up range. The checksums of the shards of the V2 meta are verified as well, and
+// they must be of the checksum algorithm recorded by the backup.
+func ValidateBackupMeta(ctx context.Context, cfg *Config) (*BackupMetaValidation, error) {
+	_, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !backupMeta.IsRawKv {
+		return nil, errors.Annotate(berrors.ErrInvalidMetaFile, "only the raw kv backup is supported")
+	}
+	result := &BackupMetaValidation{
+		Version: backupMeta.Version,
+		Shards:  len(backupMeta.GetFileIndex().GetMetaFiles()),
+	}
+	if result.ChecksumAlgorithm, err = metautil.ReadChecksumAlgorithm(ctx, s); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, shard := range backupMeta.GetFileIndex().GetMetaFiles() {
+		if len(shard.Sha256) != result.ChecksumAlgorithm.Size() {
+			return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "the checksum of shard %s isn't of %s",
+				shard.Name, result.ChecksumAlgorithm)
+		}
+	}
+	reader := metautil.NewMetaReader(backupMeta, s, &cfg.CipherInfo)
+	err = reader.ReadDataFiles(ctx, func(file *backuppb.File) error {
+		if len(file.Name) == 0 {
+			return errors.Annotatef(berrors.ErrInvalidMetaFile, "the #%d file has no name", result.Files)
+		}
+		if len(file.EndKey) > 0 && bytes.Compare(file.StartKey, file.EndKey) >= 0 {
+			return errors.Annotatef(berrors.ErrInvalidMetaFile, "file %s has an invalid range [%s, %s)",
+				file.Name, redact.Key(file.StartKey), redact.Key(file.EndKey))
+		}
+		if !inRawRanges(backupMeta.RawRanges, file) {
+			return errors.Annotatef(berrors.ErrInvalidMetaFile, "file %s in range [%s, %s) of cf %s isn't backed up",
+				file.Name, redact.Key(file.StartKey), redact.Key(file.EndKey), file.Cf)
+		}
+		result.Files++
+		result.TotalKvs += file.TotalKvs
+		result.TotalBytes += file.TotalBytes
+		result.Size += file.Size_
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return result, nil
+}
+
+// verifyBackupMeta reads the backupmeta just written back and validates it by ValidateBackupMeta,
+// so that a corrupted backupmeta fails the backup instead of the restore. The files read must be
+// as many as the ones written.
+func verifyBackupMeta(ctx context.Context, cfg *Config, files int) error {
+	result, err := ValidateBackupMeta(ctx, cfg)
+	if err != nil {
+		return errors.Annotate(err, "the backupmeta read back is invalid")
+	}
+	if result.Files != files {
+		return errors.Annotatef(berrors.ErrInvalidMetaFile,
+			"the backupmeta read back has %d files, but %d files are written", result.Files, files)
+	}
+	log.Info("the backupmeta is verified", zap.Int("files", result.Files), zap.Int("shards", result.Shards))
+	return nil
+}
+
+func inRawRanges(ranges []*backuppb.RawRange, file *backuppb.File) bool {
+	for _, rg := range ranges {
+		if rg.Cf == file.Cf && bytes.Compare(rg.StartKey, file.StartKey) <= 0 &&
+			utils.CompareEndKey(file.EndKey, rg.EndKey) <= 0 {
+			return true
+		}
+	}
+	return false
+}
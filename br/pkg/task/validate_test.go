@@ -0,0 +1,125 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package task
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestValidateBackupMeta(t *testing.T) {
+	ctx := context.Background()
+	writeBackup := func(useV2Meta bool, endKey []byte, a metautil.ChecksumAlgorithm) *Config {
+		dir := t.TempDir()
+		s, err := storage.NewLocalStorage(dir)
+		require.NoError(t, err)
+		cfg := &Config{Storage: "local://" + dir}
+		cfg.CipherInfo.CipherType = encryptionpb.EncryptionMethod_PLAINTEXT
+
+		writer := metautil.NewMetaWriter(s, 256, useV2Meta, &cfg.CipherInfo)
+		writer.SetChecksumAlgorithm(a)
+		writer.StartWriteMetasAsync(ctx, metautil.AppendDataFile)
+		for i := 0; i < 20; i++ {
+			require.NoError(t, writer.Send([]*backuppb.File{{
+				Name:     fmt.Sprintf("%02d.sst", i),
+				StartKey: []byte(fmt.Sprintf("k%02d", i)),
+				EndKey:   []byte(fmt.Sprintf("k%02d", i+1)),
+				Cf:       "default",
+				TotalKvs: 1,
+				Size_:    10,
+			}}, metautil.AppendDataFile))
+		}
+		writer.Update(func(m *backuppb.BackupMeta) {
+			m.IsRawKv = true
+			m.RawRanges = []*backuppb.RawRange{{StartKey: []byte("k"), EndKey: endKey, Cf: "default"}}
+		})
+		require.NoError(t, writer.FinishWriteMetas(ctx, metautil.AppendDataFile))
+		require.NoError(t, writer.FlushBackupMeta(ctx))
+		if a != metautil.ChecksumSHA256 {
+			require.NoError(t, metautil.WriteChecksumAlgorithm(ctx, s, a))
+		}
+		return cfg
+	}
+
+	result, err := ValidateBackupMeta(ctx, writeBackup(false, []byte("l"), metautil.ChecksumSHA256))
+	require.NoError(t, err)
+	require.Equal(t, &BackupMetaValidation{Version: metautil.MetaV1, Files: 20, TotalKvs: 20, Size: 200}, result)
+
+	result, err = ValidateBackupMeta(ctx, writeBackup(true, nil, metautil.ChecksumSHA256))
+	require.NoError(t, err)
+	require.Greater(t, result.Shards, 1)
+	require.Equal(t, int32(metautil.MetaV2), result.Version)
+	require.Equal(t, 20, result.Files)
+
+	_, err = ValidateBackupMeta(ctx, writeBackup(true, []byte("k10"), metautil.ChecksumSHA256))
+	require.True(t, berrors.Is(err, berrors.ErrInvalidMetaFile))
+
+	cfg := writeBackup(true, nil, metautil.ChecksumXXHash64)
+	result, err = ValidateBackupMeta(ctx, cfg)
+	require.NoError(t, err)
+	require.Equal(t, metautil.ChecksumXXHash64, result.ChecksumAlgorithm)
+	require.Equal(t, 20, result.Files)
+
+	// the shards don't match the algorithm recorded by the backup.
+	s, err := storage.NewLocalStorage(strings.TrimPrefix(cfg.Storage, "local://"))
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteFile(ctx, metautil.ChecksumFile))
+	_, err = ValidateBackupMeta(ctx, cfg)
+	require.True(t, berrors.Is(err, berrors.ErrInvalidMetaFile))
+}
+
+func TestVerifyBackupMeta(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	cfg := &Config{Storage: "local://" + dir}
+	cfg.CipherInfo.CipherType = encryptionpb.EncryptionMethod_PLAINTEXT
+
+	writer := metautil.NewMetaWriter(s, 256, true, &cfg.CipherInfo)
+	writer.StartWriteMetasAsync(ctx, metautil.AppendDataFile)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, writer.Send([]*backuppb.File{{
+			Name:     fmt.Sprintf("%02d.sst", i),
+			StartKey: []byte(fmt.Sprintf("k%02d", i)),
+			EndKey:   []byte(fmt.Sprintf("k%02d", i+1)),
+			Cf:       "default",
+		}}, metautil.AppendDataFile))
+	}
+	writer.Update(func(m *backuppb.BackupMeta) {
+		m.IsRawKv = true
+		m.RawRanges = []*backuppb.RawRange{{StartKey: []byte("k"), Cf: "default"}}
+	})
+	require.NoError(t, writer.FinishWriteMetas(ctx, metautil.AppendDataFile))
+	require.NoError(t, writer.FlushBackupMeta(ctx))
+	require.Equal(t, 10, writer.FlushedItems())
+	require.NoError(t, verifyBackupMeta(ctx, cfg, writer.FlushedItems()))
+
+	err = verifyBackupMeta(ctx, cfg, 11)
+	require.True(t, berrors.Is(err, berrors.ErrInvalidMetaFile))
+
+	// a shard lost after the upload.
+	shard := writer.Backupmeta().FileIndex.MetaFiles[0].Name
+	require.NoError(t, s.DeleteFile(ctx, shard))
+	require.Error(t, verifyBackupMeta(ctx, cfg, 10))
+}
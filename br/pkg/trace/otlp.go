@@ -0,0 +1,214 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+const (
+	// DefaultOTLPServiceName is the service name of the spans exported by OTLP.
+	DefaultOTLPServiceName = "br"
+
+	otlpTracesPath = "/v1/traces"
+	otlpTimeout    = 10 * time.Second
+	// otlpSpanKindInternal is SPAN_KIND_INTERNAL of the OTLP protocol.
+	otlpSpanKindInternal = 1
+	otlpScopeName        = "github.com/tikv/migration/br"
+)
+
+// sampleRand decides whether a task is sampled, it's replaced in the tests.
+var sampleRand = rand.Float64
+
+// OTLPConfig is the config of exporting the spans of a task to an OpenTelemetry
+// collector, or any tracing backend accepting OTLP/HTTP in json.
+type OTLPConfig struct {
+	// Endpoint is the base URL of the collector, e.g. http://localhost:4318, the spans are
+	// posted to Endpoint/v1/traces. The spans aren't exported if it's empty.
+	Endpoint string `json:"endpoint" toml:"endpoint"`
+	// SampleRatio is the ratio of the tasks whose spans are exported, within [0, 1].
+	SampleRatio float64 `json:"sample-ratio" toml:"sample-ratio"`
+	// Headers are sent along with the spans, e.g. the authorization of the backend.
+	Headers map[string]string `json:"headers" toml:"headers"`
+	// ServiceName is the service.name of the resource, DefaultOTLPServiceName if empty.
+	ServiceName string `json:"service-name" toml:"service-name"`
+}
+
+// Enabled returns whether the spans are exported by OTLP.
+func (cfg *OTLPConfig) Enabled() bool {
+	return cfg.Endpoint != ""
+}
+
+// ParseOTLPHeaders parses the headers in the form of "key1=value1,key2=value2".
+func ParseOTLPHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(kv, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid OTLP header %q, it should be key=value", kv)
+		}
+		headers[key] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value otlpStringItem `json:"value"`
+}
+
+type otlpStringItem struct {
+	StringValue string `json:"stringValue"`
+}
+
+// isEventAnnotation returns whether the annotation is recorded by appdash for the name,
+// the time span or the logs of a span, rather than a tag of it.
+func isEventAnnotation(key string) bool {
+	switch key {
+	case "Name", "Span.Start", "Span.End", "Msg", "Time":
+		return true
+	}
+	return strings.HasPrefix(key, appdash.SchemaPrefix)
+}
+
+// otlpSpans converts the trace and its sub traces into the OTLP spans, the spans without
+// the time span are skipped.
+func otlpSpans(t *appdash.Trace, spans []otlpSpan) []otlpSpan {
+	if e, err := t.TimespanEvent(); err == nil {
+		span := otlpSpan{
+			// the trace ID of appdash is 64-bit, the OTLP one is 128-bit.
+			TraceID:           fmt.Sprintf("%032x", uint64(t.Span.ID.Trace)),
+			SpanID:            fmt.Sprintf("%016x", uint64(t.Span.ID.Span)),
+			Name:              t.Span.Name(),
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(e.Start().UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(e.End().UnixNano(), 10),
+		}
+		if t.Span.ID.Parent != 0 {
+			span.ParentSpanID = fmt.Sprintf("%016x", uint64(t.Span.ID.Parent))
+		}
+		for _, ann := range t.Span.Annotations {
+			if isEventAnnotation(ann.Key) {
+				continue
+			}
+			span.Attributes = append(span.Attributes,
+				otlpAttribute{Key: ann.Key, Value: otlpStringItem{StringValue: string(ann.Value)}})
+		}
+		spans = append(spans, span)
+	}
+	for _, sub := range t.Sub {
+		spans = otlpSpans(sub, spans)
+	}
+	return spans
+}
+
+// exportOTLP posts the traces to the OTLP endpoint, the task is sampled by SampleRatio.
+func exportOTLP(ctx context.Context, cfg *OTLPConfig, traces []*appdash.Trace) error {
+	if cfg.SampleRatio < 1 && sampleRand() >= cfg.SampleRatio {
+		return nil
+	}
+	var spans []otlpSpan
+	for _, t := range traces {
+		spans = otlpSpans(t, spans)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = DefaultOTLPServiceName
+	}
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpStringItem{StringValue: serviceName}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: otlpScopeName}, Spans: spans}},
+	}}})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, otlpTimeout)
+	defer cancel()
+	url := strings.TrimSuffix(cfg.Endpoint, "/") + otlpTracesPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("failed to export the spans to %s, status %s: %s", url, resp.Status, msg)
+	}
+	return nil
+}
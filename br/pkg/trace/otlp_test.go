@@ -0,0 +1,80 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportOTLP(t *testing.T) {
+	var received []otlpTraces
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, otlpTracesPath, r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		var traces otlpTraces
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&traces))
+		received = append(received, traces)
+	}))
+	defer server.Close()
+
+	headers, err := ParseOTLPHeaders("authorization=Bearer token, ")
+	require.NoError(t, err)
+	outputs := Outputs{OTLP: OTLPConfig{Endpoint: server.URL + "/", SampleRatio: 1, Headers: headers}}
+	require.True(t, outputs.Enabled())
+	ctx, store := TracerStartSpan(context.Background())
+	span := opentracing.SpanFromContext(ctx)
+	child := span.Tracer().StartSpan("jobA", opentracing.ChildOf(span.Context()))
+	child.SetTag("region", 1)
+	child.Finish()
+	TracerFinishSpan(ctx, store, outputs)
+
+	require.Len(t, received, 1)
+	require.Len(t, received[0].ResourceSpans, 1)
+	rs := received[0].ResourceSpans[0]
+	require.Equal(t, DefaultOTLPServiceName, rs.Resource.Attributes[0].Value.StringValue)
+	spans := rs.ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	root, job := spans[0], spans[1]
+	require.Equal(t, "trace", root.Name)
+	require.Empty(t, root.ParentSpanID)
+	require.Equal(t, "jobA", job.Name)
+	require.Len(t, job.TraceID, 32)
+	require.Len(t, job.SpanID, 16)
+	require.Equal(t, root.TraceID, job.TraceID)
+	require.Equal(t, root.SpanID, job.ParentSpanID)
+	require.Equal(t, []otlpAttribute{{Key: "region", Value: otlpStringItem{StringValue: "1"}}}, job.Attributes)
+
+	// the task isn't sampled.
+	sampleRand = func() float64 { return 0.5 }
+	defer func() {
+		sampleRand = rand.Float64
+	}()
+	outputs.OTLP.SampleRatio = 0.5
+	ctx, store = TracerStartSpan(context.Background())
+	TracerFinishSpan(ctx, store, outputs)
+	require.Len(t, received, 1)
+
+	_, err = ParseOTLPHeaders("authorization")
+	require.Error(t, err)
+}
@@ -34,8 +34,21 @@ func TracerStartSpan(ctx context.Context) (context.Context, *appdash.MemoryStore
 	return ctx, store
 }
 
-// TracerFinishSpan finishes the tracer for BR
-func TracerFinishSpan(ctx context.Context, store appdash.Queryer) {
+// Outputs is where the spans of a task go.
+type Outputs struct {
+	// File writes the spans as a tree into a file in the temp dir.
+	File bool
+	// OTLP exports the spans to a tracing backend, if the endpoint is set.
+	OTLP OTLPConfig
+}
+
+// Enabled returns whether the spans go anywhere, the tracer isn't needed otherwise.
+func (o *Outputs) Enabled() bool {
+	return o.File || o.OTLP.Enabled()
+}
+
+// TracerFinishSpan finishes the tracer for BR, and outputs the spans.
+func TracerFinishSpan(ctx context.Context, store appdash.Queryer, outputs Outputs) {
 	span := opentracing.SpanFromContext(ctx)
 	traces, err := store.Traces(appdash.TracesOpts{})
 	if err != nil {
@@ -43,11 +56,24 @@ func TracerFinishSpan(ctx context.Context, store appdash.Queryer) {
 		return
 	}
 	span.Finish()
-	if len(traces) < 1 {
-		return
+	if outputs.File && len(traces) > 0 {
+		writeTraceFile(traces[0])
 	}
-	trace := traces[0]
+	if outputs.OTLP.Enabled() {
+		// get the traces again with the root span finished.
+		if traces, err = store.Traces(appdash.TracesOpts{}); err != nil {
+			log.Error("fail to get traces", zap.Error(err))
+			return
+		}
+		if err = exportOTLP(ctx, &outputs.OTLP, traces); err != nil {
+			log.Warn("fail to export the spans by OTLP", zap.String("endpoint", outputs.OTLP.Endpoint), zap.Error(err))
+			return
+		}
+		log.Info("exported the spans by OTLP", zap.String("endpoint", outputs.OTLP.Endpoint))
+	}
+}
 
+func writeTraceFile(trace *appdash.Trace) {
 	filename := getTraceFileName()
 	file, err := os.Create(filename)
 	if err != nil {
@@ -41,7 +41,7 @@ func TestSpan(t *testing.T) {
 	}()
 	ctx, store := TracerStartSpan(context.Background())
 	jobA(ctx)
-	TracerFinishSpan(ctx, store)
+	TracerFinishSpan(ctx, store, Outputs{File: true})
 	content, err := os.ReadFile(filename)
 	require.NoError(t, err)
 	s := string(content)
@@ -0,0 +1,170 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// BackoffPolicy is a truncated exponential backoff with jitter. The n-th interval is
+// InitialInterval * Multiplier^(n-1), capped by MaxInterval, then randomized by Jitter.
+type BackoffPolicy struct {
+	InitialInterval time.Duration `json:"initial" toml:"initial"`
+	MaxInterval     time.Duration `json:"max" toml:"max"`
+	Multiplier      float64       `json:"multiplier" toml:"multiplier"`
+	// Jitter is the ratio the interval is randomized by within [0, 1], e.g. the interval
+	// of 0.2 is within [0.8, 1.2] times of the unrandomized one.
+	Jitter float64 `json:"jitter" toml:"jitter"`
+	// MaxElapsedTime stops the backoff once the time since it starts exceeds it, 0 means no limit.
+	MaxElapsedTime time.Duration `json:"max-elapsed" toml:"max-elapsed"`
+	// MaxAttempts stops the backoff after the number of the intervals, 0 means no limit.
+	MaxAttempts int `json:"max-attempts" toml:"max-attempts"`
+}
+
+// Validate checks whether the policy is valid.
+func (p BackoffPolicy) Validate() error {
+	switch {
+	case p.InitialInterval < 0 || p.MaxInterval < 0 || p.MaxElapsedTime < 0 || p.MaxAttempts < 0:
+		return errors.Annotatef(berrors.ErrInvalidArgument, "negative backoff %s", p)
+	case p.MaxInterval > 0 && p.MaxInterval < p.InitialInterval:
+		return errors.Annotatef(berrors.ErrInvalidArgument, "the max interval is less than the initial one in %s", p)
+	case p.Multiplier < 1:
+		return errors.Annotatef(berrors.ErrInvalidArgument, "the multiplier is less than 1 in %s", p)
+	case p.Jitter < 0 || p.Jitter > 1:
+		return errors.Annotatef(berrors.ErrInvalidArgument, "the jitter isn't within [0, 1] in %s", p)
+	}
+	return nil
+}
+
+// String formats the policy in the form parsed by ParseBackoffPolicy.
+func (p BackoffPolicy) String() string {
+	return fmt.Sprintf("initial=%s,max=%s,multiplier=%g,jitter=%g,max-elapsed=%s,max-attempts=%d",
+		p.InitialInterval, p.MaxInterval, p.Multiplier, p.Jitter, p.MaxElapsedTime, p.MaxAttempts)
+}
+
+// ParseBackoffPolicy overrides the fields of the base policy by s in the form of
+// "initial=1s,max=10s,multiplier=2,jitter=0.2,max-elapsed=1m,max-attempts=5",
+// the fields absent keep those of the base.
+func ParseBackoffPolicy(s string, base BackoffPolicy) (BackoffPolicy, error) {
+	p := base
+	for _, kv := range strings.Split(s, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return p, errors.Annotatef(berrors.ErrInvalidArgument, "invalid backoff %q, it should be key=value", kv)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		var err error
+		switch key {
+		case "initial":
+			p.InitialInterval, err = time.ParseDuration(value)
+		case "max":
+			p.MaxInterval, err = time.ParseDuration(value)
+		case "multiplier":
+			p.Multiplier, err = strconv.ParseFloat(value, 64)
+		case "jitter":
+			p.Jitter, err = strconv.ParseFloat(value, 64)
+		case "max-elapsed":
+			p.MaxElapsedTime, err = time.ParseDuration(value)
+		case "max-attempts":
+			p.MaxAttempts, err = strconv.Atoi(value)
+		default:
+			return p, errors.Annotatef(berrors.ErrInvalidArgument, "unknown backoff field %q", key)
+		}
+		if err != nil {
+			return p, errors.Annotatef(berrors.ErrInvalidArgument, "invalid backoff %s %q: %v", key, value, err)
+		}
+	}
+	return p, errors.Trace(p.Validate())
+}
+
+// NewBackoff starts a backoff of the policy.
+func (p BackoffPolicy) NewBackoff() *Backoff {
+	return &Backoff{policy: p, start: time.Now(), next: p.InitialInterval}
+}
+
+// Backoff is a backoff in progress, it isn't safe for concurrent use.
+type Backoff struct {
+	policy   BackoffPolicy
+	start    time.Time
+	attempts int
+	next     time.Duration
+}
+
+// Next returns the next interval to wait, it returns false if the backoff is exhausted
+// by MaxAttempts or MaxElapsedTime.
+func (b *Backoff) Next() (time.Duration, bool) {
+	p := &b.policy
+	if p.MaxAttempts > 0 && b.attempts >= p.MaxAttempts {
+		return 0, false
+	}
+	if p.MaxElapsedTime > 0 && time.Since(b.start) >= p.MaxElapsedTime {
+		return 0, false
+	}
+	b.attempts++
+	interval := b.next
+	if p.MaxInterval > 0 && interval > p.MaxInterval {
+		interval = p.MaxInterval
+	}
+	b.next = time.Duration(float64(interval) * p.Multiplier)
+	if p.Jitter > 0 {
+		// within [1-Jitter, 1+Jitter) times of the interval.
+		interval = time.Duration(float64(interval) * (1 - p.Jitter + 2*p.Jitter*rand.Float64()))
+	}
+	return interval, true
+}
+
+// Attempts returns the number of the intervals returned.
+func (b *Backoff) Attempts() int {
+	return b.attempts
+}
+
+// Wait waits for the next interval, it returns false if the backoff is exhausted or the
+// ctx is done, whose error is returned.
+func (b *Backoff) Wait(ctx context.Context) (bool, error) {
+	interval, ok := b.Next()
+	if !ok {
+		return false, nil
+	}
+	if err := Sleep(ctx, interval); err != nil {
+		return false, errors.Trace(err)
+	}
+	return true, nil
+}
+
+// Sleep waits for the duration, it returns the error of the ctx if it's done first.
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
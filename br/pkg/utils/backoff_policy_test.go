@@ -0,0 +1,100 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+func TestBackoffPolicy(t *testing.T) {
+	p := utils.BackoffPolicy{
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     time.Second,
+		Multiplier:      3,
+		MaxAttempts:     5,
+	}
+	b := p.NewBackoff()
+	var intervals []time.Duration
+	for {
+		interval, ok := b.Next()
+		if !ok {
+			break
+		}
+		intervals = append(intervals, interval)
+	}
+	require.Equal(t, []time.Duration{
+		100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second, time.Second,
+	}, intervals)
+	require.Equal(t, 5, b.Attempts())
+
+	p.Jitter = 0.5
+	p.MaxAttempts = 0
+	b = p.NewBackoff()
+	for i := 0; i < 100; i++ {
+		interval, ok := b.Next()
+		require.True(t, ok)
+		require.GreaterOrEqual(t, interval, 50*time.Millisecond)
+		require.Less(t, interval, 1500*time.Millisecond)
+	}
+
+	p = utils.BackoffPolicy{InitialInterval: 10 * time.Millisecond, Multiplier: 1, MaxElapsedTime: 50 * time.Millisecond}
+	b = p.NewBackoff()
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		ok, err := b.Wait(ctx)
+		require.NoError(t, err)
+		if !ok {
+			break
+		}
+	}
+	require.GreaterOrEqual(t, b.Attempts(), 4)
+	require.LessOrEqual(t, b.Attempts(), 6)
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	ok, err := p.NewBackoff().Wait(ctx)
+	require.False(t, ok)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestParseBackoffPolicy(t *testing.T) {
+	base := utils.BackoffPolicy{InitialInterval: time.Second, MaxInterval: 10 * time.Second, Multiplier: 2, MaxAttempts: 5}
+	p, err := utils.ParseBackoffPolicy("", base)
+	require.NoError(t, err)
+	require.Equal(t, base, p)
+
+	p, err = utils.ParseBackoffPolicy(" max=20s, jitter=0.2,max-elapsed=1m ,max-attempts=0", base)
+	require.NoError(t, err)
+	require.Equal(t, utils.BackoffPolicy{
+		InitialInterval: time.Second,
+		MaxInterval:     20 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+		MaxElapsedTime:  time.Minute,
+	}, p)
+	p2, err := utils.ParseBackoffPolicy(p.String(), utils.BackoffPolicy{})
+	require.NoError(t, err)
+	require.Equal(t, p, p2)
+
+	for _, s := range []string{"initial", "initial=1", "retry=3", "multiplier=0.5", "jitter=2", "max=1ms", "max-attempts=-1"} {
+		_, err = utils.ParseBackoffPolicy(s, base)
+		require.Error(t, err, s)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"sync"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// TaskSettings is a snapshot of the task settings which can be adjusted
+// while the task is running.
+type TaskSettings struct {
+	// RateLimit is the rate limit in bytes/s per node, 0 means unlimited.
+	RateLimit uint64
+	// Concurrency is the size of thread pool on each node that executes the task.
+	Concurrency uint32
+	// IngestRateLimit is the bytes/s of the files restored into each node, 0 means unlimited.
+	IngestRateLimit uint64
+	// Paused holds back the restore until it's false again.
+	Paused bool
+}
+
+// DynamicSettings holds the adjustable settings of a running task.
+// It is safe for concurrent use.
+type DynamicSettings struct {
+	mu        sync.RWMutex
+	current   TaskSettings
+	listeners map[int]func(TaskSettings)
+	nextID    int
+}
+
+// NewDynamicSettings creates a DynamicSettings with the initial values.
+func NewDynamicSettings(initial TaskSettings) *DynamicSettings {
+	return &DynamicSettings{
+		current:   initial,
+		listeners: make(map[int]func(TaskSettings)),
+	}
+}
+
+var globalDynamicSettings = NewDynamicSettings(TaskSettings{})
+
+// GlobalDynamicSettings returns the process-wide settings shared by the
+// running task and the reloading entries (SIGHUP, status server).
+func GlobalDynamicSettings() *DynamicSettings {
+	return globalDynamicSettings
+}
+
+// Load returns the current settings.
+func (s *DynamicSettings) Load() TaskSettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// Store replaces the current settings and notifies the listeners if anything changed.
+func (s *DynamicSettings) Store(settings TaskSettings) {
+	s.Update(func(ts *TaskSettings) { *ts = settings })
+}
+
+// Update modifies the current settings by f and notifies the listeners if anything changed.
+func (s *DynamicSettings) Update(f func(*TaskSettings)) {
+	s.mu.Lock()
+	old := s.current
+	f(&s.current)
+	updated := s.current
+	listeners := make([]func(TaskSettings), 0, len(s.listeners))
+	for _, l := range s.listeners {
+		listeners = append(listeners, l)
+	}
+	s.mu.Unlock()
+
+	if old == updated {
+		return
+	}
+	log.Info("task settings updated",
+		zap.Uint64("old-rate-limit", old.RateLimit),
+		zap.Uint64("new-rate-limit", updated.RateLimit),
+		zap.Uint32("old-concurrency", old.Concurrency),
+		zap.Uint32("new-concurrency", updated.Concurrency),
+		zap.Uint64("old-ingest-rate-limit", old.IngestRateLimit),
+		zap.Uint64("new-ingest-rate-limit", updated.IngestRateLimit),
+		zap.Bool("paused", updated.Paused))
+	for _, l := range listeners {
+		l(updated)
+	}
+}
+
+// OnChange registers a listener called after the settings changed.
+// The returned function unregisters the listener.
+func (s *DynamicSettings) OnChange(fn func(TaskSettings)) (cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextID
+	s.nextID++
+	s.listeners[id] = fn
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.listeners, id)
+	}
+}
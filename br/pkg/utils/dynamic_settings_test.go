@@ -0,0 +1,44 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/utils"
+)
+
+func TestDynamicSettings(t *testing.T) {
+	s := utils.NewDynamicSettings(utils.TaskSettings{RateLimit: 10, Concurrency: 4})
+	require.Equal(t, uint64(10), s.Load().RateLimit)
+
+	notified := make([]utils.TaskSettings, 0)
+	cancel := s.OnChange(func(ts utils.TaskSettings) {
+		notified = append(notified, ts)
+	})
+
+	s.Update(func(ts *utils.TaskSettings) { ts.RateLimit = 20 })
+	require.Equal(t, []utils.TaskSettings{{RateLimit: 20, Concurrency: 4}}, notified)
+
+	// Nothing changed, the listener should not be called.
+	s.Store(utils.TaskSettings{RateLimit: 20, Concurrency: 4})
+	require.Len(t, notified, 1)
+
+	cancel()
+	s.Store(utils.TaskSettings{RateLimit: 30, Concurrency: 8})
+	require.Len(t, notified, 1)
+	require.Equal(t, utils.TaskSettings{RateLimit: 30, Concurrency: 8}, s.Load())
+}
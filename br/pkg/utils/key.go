@@ -38,6 +38,35 @@ func ParseKey(format, key string) ([]byte, error) {
 	return nil, errors.Annotate(berrors.ErrInvalidArgument, "unknown format")
 }
 
+// FormatKey formats key by given format, it's the reverse of ParseKey.
+func FormatKey(format string, key []byte) (string, error) {
+	switch format {
+	case "raw":
+		return string(key), nil
+	case "escaped":
+		return escapedKey(key), nil
+	case "hex":
+		return hex.EncodeToString(key), nil
+	}
+	return "", errors.Annotate(berrors.ErrInvalidArgument, "unknown format")
+}
+
+// escapedKey escapes the backslash and the non-printable bytes in octal, which unescapedKey accepts.
+func escapedKey(key []byte) string {
+	var buf strings.Builder
+	for _, c := range key {
+		switch {
+		case c == '\\':
+			buf.WriteString(`\\`)
+		case c >= 0x20 && c < 0x7f:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "\\%03o", c)
+		}
+	}
+	return buf.String()
+}
+
 // Ref PD: https://github.com/pingcap/pd/blob/master/tools/pd-ctl/pdctl/command/region_command.go#L334
 func unescapedKey(text string) ([]byte, error) {
 	var buf []byte
@@ -119,6 +148,45 @@ func FormatAPIV2KeyRange(startKey, endKey []byte) *KeyRange {
 	}
 }
 
+// MaxKeyspaceID is the max ID of the API V2 keyspaces, which is encoded in 3 bytes of the keys.
+const MaxKeyspaceID = 1<<24 - 1
+
+// KeyspacePrefix returns the API V2 prefix of the raw keys in the keyspace.
+func KeyspacePrefix(keyspaceID uint32) []byte {
+	return []byte{APIV2KeyPrefix[0], byte(keyspaceID >> 16), byte(keyspaceID >> 8), byte(keyspaceID)}
+}
+
+// FormatKeyspaceKey converts the user key into the API V2 key in the keyspace, the empty end key
+// is the end of the keyspace. FormatAPIV2Key is the same for the default keyspace.
+func FormatKeyspaceKey(keyspaceID uint32, key []byte, isEnd bool) []byte {
+	prefix := KeyspacePrefix(keyspaceID)
+	if isEnd && len(key) == 0 {
+		return PrefixNext(prefix)
+	}
+	return append(prefix, key...)
+}
+
+// FormatKeyspaceKeyRange converts the user key range into the API V2 key range in the keyspace.
+func FormatKeyspaceKeyRange(keyspaceID uint32, startKey, endKey []byte) *KeyRange {
+	return &KeyRange{
+		Start: FormatKeyspaceKey(keyspaceID, startKey, false),
+		End:   FormatKeyspaceKey(keyspaceID, endKey, true),
+	}
+}
+
+// PrefixNext returns the smallest key greater than all keys with the prefix,
+// nil means the end of the key space.
+func PrefixNext(prefix []byte) []byte {
+	next := append([]byte{}, prefix...)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next[:i+1]
+		}
+	}
+	return nil
+}
+
 // ConvertBackupConfigKeyRange do conversion between formated APIVersion key and backupmeta key
 // for example, backup apiv1 -> apiv2, add `r` prefix and `s` for empty end key.
 // apiv2 -> apiv1, remove first byte.
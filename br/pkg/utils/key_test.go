@@ -92,6 +92,22 @@ func TestParseKey(t *testing.T) {
 	}
 }
 
+func TestFormatKey(t *testing.T) {
+	key := []byte("a\\b\x00\xff\n\"c")
+	for _, format := range []string{"raw", "escaped", "hex"} {
+		formatted, err := FormatKey(format, key)
+		require.NoError(t, err)
+		parsed, err := ParseKey(format, formatted)
+		require.NoError(t, err)
+		require.Equal(t, key, parsed, format)
+	}
+	formatted, err := FormatKey("escaped", key)
+	require.NoError(t, err)
+	require.Equal(t, `a\\b\000\377\012"c`, formatted)
+	_, err = FormatKey("base64", key)
+	require.Error(t, err)
+}
+
 func TestCompareEndKey(t *testing.T) {
 	// test endKey
 	testCase := []struct {
@@ -129,6 +145,25 @@ func TestFormatAPIV2KeyRange(t *testing.T) {
 	}
 }
 
+func TestFormatKeyspaceKeyRange(t *testing.T) {
+	require.Equal(t, []byte{'r', 0, 0, 0}, KeyspacePrefix(0))
+	require.Equal(t, []byte{'r', 0x01, 0x02, 0x03}, KeyspacePrefix(0x010203))
+	require.Equal(t, FormatAPIV2KeyRange([]byte("a"), nil), FormatKeyspaceKeyRange(0, []byte("a"), nil))
+	require.Equal(t, &KeyRange{Start: []byte{'r', 0, 0, 5}, End: []byte{'r', 0, 0, 6}},
+		FormatKeyspaceKeyRange(5, nil, nil))
+	require.Equal(t, &KeyRange{Start: []byte{'r', 0, 0, 5, 'a'}, End: []byte{'r', 0, 0, 5, 'b'}},
+		FormatKeyspaceKeyRange(5, []byte("a"), []byte("b")))
+	require.Equal(t, &KeyRange{Start: []byte{'r', 0, 0x01, 0xff}, End: []byte{'r', 0, 0x02}},
+		FormatKeyspaceKeyRange(0x01ff, nil, nil))
+}
+
+func TestPrefixNext(t *testing.T) {
+	require.Equal(t, []byte("abd"), PrefixNext([]byte("abc")))
+	require.Equal(t, []byte{'a', 'c'}, PrefixNext([]byte{'a', 'b', 0xff}))
+	require.Nil(t, PrefixNext([]byte{0xff, 0xff}))
+	require.Nil(t, PrefixNext(nil))
+}
+
 func TestConvertBackupConfigKeyRange(t *testing.T) {
 	testCases := []struct {
 		input     KeyRange
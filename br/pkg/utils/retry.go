@@ -30,6 +30,22 @@ var retryableServerError = []string{
 	"put object timeout",
 }
 
+var corruptedFileError = []string{
+	"sha256 not match",
+	"checksum mismatch",
+	"crc32 mismatch",
+	"corrupt",
+}
+
+// noSpaceError are the messages of running out of the disk space, e.g. ENOSPC and the
+// DiskFull error of TiKV, which never succeed on retry.
+var noSpaceError = []string{
+	"no space left on device",
+	"disk full",
+	"diskfull",
+	"disk is full",
+}
+
 // RetryableFunc presents a retryable operation.
 type RetryableFunc func() error
 
@@ -79,6 +95,30 @@ func MessageIsRetryableStorageError(msg string) bool {
 	return false
 }
 
+// MessageIsCorruptedFileError checks whether the message returning from TiKV means the
+// downloaded file fails the verification, which may succeed after downloading again.
+func MessageIsCorruptedFileError(msg string) bool {
+	msgLower := strings.ToLower(msg)
+	for _, errStr := range corruptedFileError {
+		if strings.Contains(msgLower, errStr) {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageIsNoSpaceError checks whether the message returning from TiKV means the disk
+// of the store or the storage is full.
+func MessageIsNoSpaceError(msg string) bool {
+	msgLower := strings.ToLower(msg)
+	for _, errStr := range noSpaceError {
+		if strings.Contains(msgLower, errStr) {
+			return true
+		}
+	}
+	return false
+}
+
 // sqlmock uses fmt.Errorf to produce expectation failures, which will cause
 // unnecessary retry if not specially handled >:(
 var stdFatalErrorsRegexp = regexp.MustCompile(
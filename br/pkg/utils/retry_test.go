@@ -44,3 +44,15 @@ func TestIsRetryableError(t *testing.T) {
 	require.True(t, IsRetryableError(multierr.Combine(&net.DNSError{IsTimeout: true}, &net.DNSError{IsTimeout: true})))
 	require.False(t, IsRetryableError(multierr.Combine(context.Canceled, &net.DNSError{IsTimeout: true})))
 }
+
+func TestMessageIsCorruptedFileError(t *testing.T) {
+	require.True(t, MessageIsCorruptedFileError("Io(Custom { kind: InvalidData, error: \"sha256 not match, expect [1], got [2]\" })"))
+	require.True(t, MessageIsCorruptedFileError("file is Corrupted"))
+	require.False(t, MessageIsCorruptedFileError("connection reset by peer"))
+}
+
+func TestMessageIsNoSpaceError(t *testing.T) {
+	require.True(t, MessageIsNoSpaceError("Io(Os { code: 28, kind: StorageFull, message: \"No space left on device\" })"))
+	require.True(t, MessageIsNoSpaceError("DiskFull { store_id: [1], reason: \"disk full\" }"))
+	require.False(t, MessageIsNoSpaceError("connection reset by peer"))
+}
@@ -5,13 +5,16 @@ package utils
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pingcap/errors"
+	"github.com/pingcap/failpoint"
 	"github.com/pingcap/log"
 	"github.com/tikv/client-go/v2/oracle"
 	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/failpoints"
 	pd "github.com/tikv/pd/client"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -137,3 +140,141 @@ func StartServiceSafePointKeeper(
 	}()
 	return nil
 }
+
+// ServiceSafePointKeeper keeps the service safe point of a backup alive during the backup.
+// Unlike StartServiceSafePointKeeper, it tolerates PD being unavailable for a while:
+// the safe point is re-acquired once PD returns, and the backup ts is verified against
+// the GC safe point, the backup is aborted only if GC has advanced past the backup ts.
+type ServiceSafePointKeeper struct {
+	pdClient      pd.Client
+	sp            BRServiceSafePoint
+	updateGapTime time.Duration
+	retryGapTime  time.Duration
+
+	mu  sync.Mutex
+	err error
+	// stopped is closed once the keeper exits, it's nil if the keeper isn't started.
+	stopped chan struct{}
+}
+
+// NewServiceSafePointKeeper creates a ServiceSafePointKeeper of the service safe point.
+func NewServiceSafePointKeeper(pdClient pd.Client, sp BRServiceSafePoint) *ServiceSafePointKeeper {
+	updateGapTime := time.Duration(sp.TTL) * time.Second / preUpdateServiceSafePointFactor
+	if updateGapTime <= 0 {
+		updateGapTime = DefaultBRGCSafePointTTL / preUpdateServiceSafePointFactor
+	}
+	retryGapTime := checkGCSafePointGapTime
+	if retryGapTime > updateGapTime {
+		retryGapTime = updateGapTime
+	}
+	return &ServiceSafePointKeeper{
+		pdClient:      pdClient,
+		sp:            sp,
+		updateGapTime: updateGapTime,
+		retryGapTime:  retryGapTime,
+	}
+}
+
+// Start keeps the service safe point alive in background until ctx is done.
+// cancel is called once the backup ts becomes invalid, and the reason is returned by Err.
+func (k *ServiceSafePointKeeper) Start(ctx context.Context, cancel context.CancelFunc) {
+	k.stopped = make(chan struct{})
+	go func() {
+		defer close(k.stopped)
+		timer := time.NewTimer(k.updateGapTime)
+		defer timer.Stop()
+		// unavailableSince is the time PD became unavailable, zero if PD is available.
+		var unavailableSince time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				log.Debug("service safe point keeper exited")
+				return
+			case <-timer.C:
+			}
+			err := k.keepAlive(ctx, !unavailableSince.IsZero())
+			switch {
+			case err == nil:
+				if !unavailableSince.IsZero() {
+					log.Info("service safe point re-acquired after PD returns",
+						zap.Duration("unavailable", time.Since(unavailableSince)),
+						zap.Object("safePoint", k.sp))
+					unavailableSince = time.Time{}
+				}
+				timer.Reset(k.updateGapTime)
+			case berrors.Is(err, berrors.ErrBackupGCSafepointExceeded):
+				log.Error("backup ts is invalidated by GC, aborting", zap.Error(err), zap.Object("safePoint", k.sp))
+				k.mu.Lock()
+				k.err = err
+				k.mu.Unlock()
+				cancel()
+				return
+			default:
+				if unavailableSince.IsZero() {
+					unavailableSince = time.Now()
+				}
+				log.Warn("failed to update service safe point, retry until PD returns",
+					zap.Duration("unavailable", time.Since(unavailableSince)),
+					zap.Object("safePoint", k.sp), zap.Error(err))
+				timer.Reset(k.retryGapTime)
+			}
+		}
+	}()
+}
+
+// keepAlive updates the service safe point, and verifies the backup ts if the safe point
+// may have been lost, i.e. it has expired while PD was unavailable or it isn't accepted by PD.
+func (k *ServiceSafePointKeeper) keepAlive(ctx context.Context, mayLost bool) error {
+	failpoint.Inject(failpoints.SafePointKeepAliveError, func(val failpoint.Value) {
+		failpoint.Return(errors.Errorf("failpoint: %v", val))
+	})
+	failpoint.Inject(failpoints.SafePointExceeded, func(_ failpoint.Value) {
+		failpoint.Return(errors.Annotatef(berrors.ErrBackupGCSafepointExceeded, "failpoint: GC safepoint exceed TS %d", k.sp.BackupTS))
+	})
+	minSafePoint, err := k.pdClient.UpdateServiceGCSafePoint(ctx, k.sp.ID, k.sp.TTL, k.sp.BackupTS-1)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !mayLost && minSafePoint <= k.sp.BackupTS-1 {
+		return nil
+	}
+	if minSafePoint > k.sp.BackupTS-1 {
+		log.Warn("service GC safe point lost, we may fail to back up if GC lifetime isn't long enough",
+			zap.Uint64("lastSafePoint", minSafePoint), zap.Object("safePoint", k.sp))
+	}
+	safePoint, err := getGCSafePoint(ctx, k.pdClient)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if k.sp.BackupTS <= safePoint {
+		return errors.Annotatef(berrors.ErrBackupGCSafepointExceeded, "GC safepoint %d exceed TS %d", safePoint, k.sp.BackupTS)
+	}
+	return nil
+}
+
+// Release removes the service safe point once the keeper exits, so GC isn't held back
+// until the TTL expires. It waits for the ctx passed to Start to be done, the caller
+// should cancel it first.
+func (k *ServiceSafePointKeeper) Release(ctx context.Context) error {
+	if k.stopped != nil {
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-k.stopped:
+		}
+	}
+	// PD removes the service safe point whose TTL isn't positive.
+	_, err := k.pdClient.UpdateServiceGCSafePoint(ctx, k.sp.ID, 0, k.sp.BackupTS-1)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("service safe point released", zap.Object("safePoint", k.sp))
+	return nil
+}
+
+// Err returns the reason why the backup ts became invalid, nil if it's still valid.
+func (k *ServiceSafePointKeeper) Err() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.err
+}
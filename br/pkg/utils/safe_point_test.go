@@ -6,8 +6,11 @@ import (
 	"context"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/pingcap/errors"
 	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/utils"
 	pd "github.com/tikv/pd/client"
 )
@@ -39,12 +42,29 @@ type mockSafePoint struct {
 	pd.Client
 	safepoint           uint64
 	minServiceSafepoint uint64
+	// err makes the requests fail as if PD is unavailable.
+	err error
+	// removed are the IDs of the service safe points removed.
+	removed []string
+}
+
+func (m *mockSafePoint) setErr(err error) {
+	m.Lock()
+	defer m.Unlock()
+	m.err = err
 }
 
 func (m *mockSafePoint) UpdateServiceGCSafePoint(ctx context.Context, serviceID string, ttl int64, safePoint uint64) (uint64, error) {
 	m.Lock()
 	defer m.Unlock()
 
+	if m.err != nil {
+		return 0, m.err
+	}
+	if ttl <= 0 {
+		m.removed = append(m.removed, serviceID)
+		return m.minServiceSafepoint, nil
+	}
 	if m.safepoint > safePoint {
 		return m.safepoint, nil
 	}
@@ -58,6 +78,9 @@ func (m *mockSafePoint) UpdateGCSafePoint(ctx context.Context, safePoint uint64)
 	m.Lock()
 	defer m.Unlock()
 
+	if m.err != nil {
+		return 0, m.err
+	}
 	if m.safepoint < safePoint && safePoint < m.minServiceSafepoint {
 		m.safepoint = safePoint
 	}
@@ -127,3 +150,50 @@ func TestStartServiceSafePointKeeper(t *testing.T) {
 		cancel()
 	}
 }
+
+func TestServiceSafePointKeeper(t *testing.T) {
+	pdClient := &mockSafePoint{safepoint: 2333}
+	sp := utils.BRServiceSafePoint{ID: "br", TTL: 3, BackupTS: 2333 + 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	keeper := utils.NewServiceSafePointKeeper(pdClient, sp)
+	keeper.Start(ctx, cancel)
+
+	// the backup goes on while PD is unavailable, and after PD returns.
+	pdClient.setErr(errors.New("pd is unavailable"))
+	time.Sleep(2500 * time.Millisecond)
+	pdClient.setErr(nil)
+	time.Sleep(1500 * time.Millisecond)
+	require.NoError(t, ctx.Err())
+	require.NoError(t, keeper.Err())
+
+	// GC advances past the backup ts while PD is unavailable.
+	pdClient.setErr(errors.New("pd is unavailable"))
+	time.Sleep(1500 * time.Millisecond)
+	pdClient.Lock()
+	pdClient.safepoint = 3000
+	pdClient.minServiceSafepoint = 3000
+	pdClient.Unlock()
+	pdClient.setErr(nil)
+	require.Eventually(t, func() bool { return ctx.Err() != nil }, 5*time.Second, 100*time.Millisecond)
+	require.True(t, berrors.Is(keeper.Err(), berrors.ErrBackupGCSafepointExceeded))
+}
+
+func TestReleaseServiceSafePoint(t *testing.T) {
+	pdClient := &mockSafePoint{safepoint: 2333}
+	sp := utils.BRServiceSafePoint{ID: "br", TTL: 3, BackupTS: 2333 + 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	keeper := utils.NewServiceSafePointKeeper(pdClient, sp)
+	keeper.Start(ctx, cancel)
+
+	// the keeper is still running.
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer timeoutCancel()
+	require.Error(t, keeper.Release(timeoutCtx))
+	require.Empty(t, pdClient.removed)
+
+	cancel()
+	require.NoError(t, keeper.Release(context.Background()))
+	require.Equal(t, []string{"br"}, pdClient.removed)
+	require.NoError(t, keeper.Err())
+}
@@ -134,6 +134,12 @@ type ChangeFeedInfo struct {
 	Error    *RunningError         `json:"error"`
 
 	CreatorVersion string `json:"creator-version"`
+
+	// PauseWindowEnd is the end of the last pause window applied to the changefeed,
+	// and PausedByWindow tells whether the changefeed is paused by the window, which
+	// is resumed when the window ends.
+	PauseWindowEnd *time.Time `json:"pause-window-end,omitempty"`
+	PausedByWindow bool       `json:"paused-by-window,omitempty"`
 }
 
 const changeFeedIDMaxLen = 128
@@ -17,6 +17,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
@@ -30,6 +31,9 @@ type AdminJobType int
 // AdminJobOption records addition options of an admin job
 type AdminJobOption struct {
 	ForceRemove bool
+	// PauseWindowEnd is set if the job is issued by the owner when a pause window
+	// of the changefeed begins or ends, it's the end of the window.
+	PauseWindowEnd *time.Time
 }
 
 // AdminJob holds an admin job
@@ -125,6 +125,10 @@ func (c *changefeed) checkStaleCheckpointTs(ctx cdcContext.Context, checkpointTs
 
 func (c *changefeed) tick(ctx cdcContext.Context, state *orchestrator.ChangefeedReactorState, captures map[model.CaptureID]*model.CaptureInfo) error {
 	c.state = state
+	if state.Info != nil && state.Info.Config != nil && len(state.Info.Config.PauseWindows) > 0 {
+		pdTime, _ := ctx.GlobalVars().TimeAcquirer.CurrentTimeFromCached()
+		c.feedStateManager.CheckPauseWindows(state, pdTime)
+	}
 	c.feedStateManager.Tick(state)
 
 	checkpointTs := c.state.Info.GetCheckpointTs(c.state.Status)
@@ -136,6 +140,9 @@ func (c *changefeed) tick(ctx cdcContext.Context, state *orchestrator.Changefeed
 
 	if !c.feedStateManager.ShouldRunning() {
 		c.isRemoved = c.feedStateManager.ShouldRemoved()
+		if c.initialized && !c.isRemoved {
+			c.persistFinalCheckpoint()
+		}
 		c.releaseResources(ctx)
 		return nil
 	}
@@ -316,6 +323,30 @@ func (c *changefeed) preflightCheck(captures map[model.CaptureID]*model.CaptureI
 	return
 }
 
+// persistFinalCheckpoint advances the checkpoint of the changefeed being paused to the one
+// flushed by the sinks of all the processors, which is where the changefeed resumes from.
+// The task positions are still readable in this tick, before they are cleaned up.
+func (c *changefeed) persistFinalCheckpoint() {
+	checkpointTs, ok := c.scheduler.FinalCheckpointTs(c.state)
+	if !ok {
+		log.Info("the final checkpoint of the changefeed is unknown, resume from the last checkpoint",
+			zap.String("changefeedID", c.id), zap.Uint64("checkpointTs", c.state.Status.CheckpointTs))
+		return
+	}
+	c.state.PatchStatus(func(status *model.ChangeFeedStatus) (*model.ChangeFeedStatus, bool, error) {
+		if status == nil || checkpointTs <= status.CheckpointTs {
+			return status, false, nil
+		}
+		log.Info("persist the final checkpoint of the paused changefeed", zap.String("changefeedID", c.id),
+			zap.Uint64("oldCheckpointTs", status.CheckpointTs), zap.Uint64("checkpointTs", checkpointTs))
+		status.CheckpointTs = checkpointTs
+		if status.ResolvedTs < checkpointTs {
+			status.ResolvedTs = checkpointTs
+		}
+		return status, true, nil
+	})
+}
+
 func (c *changefeed) updateStatus(currentTs int64, checkpointTs, resolvedTs model.Ts) {
 	c.state.PatchStatus(func(status *model.ChangeFeedStatus) (*model.ChangeFeedStatus, bool, error) {
 		changed := false
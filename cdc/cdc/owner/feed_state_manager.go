@@ -114,6 +114,7 @@ func (m *feedStateManager) handleAdminJob() (jobsPending bool) {
 		m.shouldBeRunning = false
 		jobsPending = true
 		m.patchState(model.StateStopped)
+		m.markPausedByWindow(job)
 	case model.AdminRemove:
 		switch m.state.Info.State {
 		case model.StateNormal, model.StateError, model.StateFailed,
@@ -148,14 +149,17 @@ func (m *feedStateManager) handleAdminJob() (jobsPending bool) {
 		m.shouldBeRunning = true
 		jobsPending = true
 		m.patchState(model.StateNormal)
+		log.Info("the changefeed is resumed", zap.String("changefeed-id", m.state.ID),
+			zap.Uint64("checkpoint-ts", m.state.Info.GetCheckpointTs(m.state.Status)))
 		// remove error history to make sure the changefeed can running in next tick
 		m.state.PatchInfo(func(info *model.ChangeFeedInfo) (*model.ChangeFeedInfo, bool, error) {
 			if info == nil {
 				return nil, false, nil
 			}
-			if info.Error != nil || len(info.ErrorHis) != 0 {
+			if info.Error != nil || len(info.ErrorHis) != 0 || info.PausedByWindow {
 				info.Error = nil
 				info.ErrorHis = nil
+				info.PausedByWindow = false
 				return info, true, nil
 			}
 			return info, false, nil
@@ -231,6 +235,77 @@ func (m *feedStateManager) patchState(feedState model.FeedState) {
 	})
 }
 
+// CheckPauseWindows pauses the changefeed when one of its pause windows begins, and
+// resumes it when the window ends if it's paused by the window. A window is applied
+// only once, so the changefeed resumed manually during the window keeps running.
+func (m *feedStateManager) CheckPauseWindows(state *orchestrator.ChangefeedReactorState, now time.Time) {
+	info := state.Info
+	if info == nil || info.Config == nil {
+		return
+	}
+	switch info.State {
+	case model.StateNormal:
+		windowEnd, ok := info.Config.PauseWindowEnd(now)
+		if !ok || (info.PauseWindowEnd != nil && info.PauseWindowEnd.Equal(windowEnd)) {
+			return
+		}
+		log.Info("[audit] pause the changefeed as its pause window begins",
+			zap.String("changefeedID", state.ID), zap.Time("now", now), zap.Time("windowEnd", windowEnd))
+		changefeedPauseWindowCounter.WithLabelValues(state.ID, "pause").Inc()
+		m.pushAdminJob(&model.AdminJob{
+			CfID: state.ID,
+			Type: model.AdminStop,
+			Opts: &model.AdminJobOption{PauseWindowEnd: &windowEnd},
+		})
+	case model.StateStopped:
+		if !info.PausedByWindow || info.PauseWindowEnd == nil || now.Before(*info.PauseWindowEnd) {
+			return
+		}
+		// the windows may be overlapped, so check whether another one is in progress.
+		if windowEnd, ok := info.Config.PauseWindowEnd(now); ok {
+			log.Info("[audit] extend the pause of the changefeed to an overlapped pause window",
+				zap.String("changefeedID", state.ID), zap.Time("windowEnd", windowEnd))
+			state.PatchInfo(func(info *model.ChangeFeedInfo) (*model.ChangeFeedInfo, bool, error) {
+				if info == nil {
+					return nil, false, nil
+				}
+				info.PauseWindowEnd = &windowEnd
+				return info, true, nil
+			})
+			return
+		}
+		log.Info("[audit] resume the changefeed as its pause window ends",
+			zap.String("changefeedID", state.ID), zap.Time("now", now), zap.Time("windowEnd", *info.PauseWindowEnd))
+		changefeedPauseWindowCounter.WithLabelValues(state.ID, "resume").Inc()
+		m.pushAdminJob(&model.AdminJob{
+			CfID: state.ID,
+			Type: model.AdminResume,
+			Opts: &model.AdminJobOption{PauseWindowEnd: info.PauseWindowEnd},
+		})
+	}
+}
+
+// markPausedByWindow records whether the changefeed is paused by one of its pause windows.
+func (m *feedStateManager) markPausedByWindow(job *model.AdminJob) {
+	var windowEnd *time.Time
+	if job.Opts != nil {
+		windowEnd = job.Opts.PauseWindowEnd
+	}
+	m.state.PatchInfo(func(info *model.ChangeFeedInfo) (*model.ChangeFeedInfo, bool, error) {
+		if info == nil {
+			return nil, false, nil
+		}
+		if windowEnd == nil {
+			changed := info.PausedByWindow
+			info.PausedByWindow = false
+			return info, changed, nil
+		}
+		info.PausedByWindow = true
+		info.PauseWindowEnd = windowEnd
+		return info, true, nil
+	})
+}
+
 func (m *feedStateManager) cleanUpInfos() {
 	for captureID := range m.state.TaskStatuses {
 		m.state.PatchTaskStatus(captureID, func(status *model.TaskStatus) (*model.TaskStatus, bool, error) {
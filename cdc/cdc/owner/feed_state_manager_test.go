@@ -14,6 +14,8 @@
 package owner
 
 import (
+	"time"
+
 	"github.com/pingcap/check"
 	"github.com/tikv/migration/cdc/cdc/model"
 	"github.com/tikv/migration/cdc/pkg/config"
@@ -253,3 +255,60 @@ func (s *feedStateManagerSuite) TestChangefeedStatusNotExist(c *check.C) {
 	c.Assert(state.Info, check.IsNil)
 	c.Assert(state.Exist(), check.IsFalse)
 }
+
+func (s *feedStateManagerSuite) TestPauseWindow(c *check.C) {
+	defer testleak.AfterTest(c)()
+	ctx := cdcContext.NewBackendContext4Test(true)
+	manager := new(feedStateManager)
+	state := orchestrator.NewChangefeedReactorState(ctx.ChangefeedVars().ID)
+	tester := orchestrator.NewReactorStateTester(c, state, nil)
+	state.PatchInfo(func(info *model.ChangeFeedInfo) (*model.ChangeFeedInfo, bool, error) {
+		c.Assert(info, check.IsNil)
+		return &model.ChangeFeedInfo{SinkURI: "123", Config: &config.ReplicaConfig{
+			PauseWindows: []*config.PauseWindow{{Start: "01:00", End: "02:00"}},
+		}}, true, nil
+	})
+	state.PatchStatus(func(status *model.ChangeFeedStatus) (*model.ChangeFeedStatus, bool, error) {
+		c.Assert(status, check.IsNil)
+		return &model.ChangeFeedStatus{}, true, nil
+	})
+	tester.MustApplyPatches()
+	tick := func(now time.Time) {
+		manager.CheckPauseWindows(state, now)
+		manager.Tick(state)
+		tester.MustApplyPatches()
+	}
+	day := time.Date(2022, 6, 4, 0, 0, 0, 0, time.UTC)
+
+	tick(day.Add(30 * time.Minute))
+	c.Assert(manager.ShouldRunning(), check.IsTrue)
+
+	// the window begins.
+	tick(day.Add(90 * time.Minute))
+	c.Assert(manager.ShouldRunning(), check.IsFalse)
+	c.Assert(state.Info.State, check.Equals, model.StateStopped)
+	c.Assert(state.Info.PausedByWindow, check.IsTrue)
+
+	// the window ends.
+	tick(day.Add(2 * time.Hour))
+	c.Assert(manager.ShouldRunning(), check.IsTrue)
+	c.Assert(state.Info.State, check.Equals, model.StateNormal)
+	c.Assert(state.Info.PausedByWindow, check.IsFalse)
+
+	// the changefeed resumed manually during the window keeps running.
+	tick(day.Add(25 * time.Hour))
+	c.Assert(manager.ShouldRunning(), check.IsFalse)
+	manager.PushAdminJob(&model.AdminJob{CfID: ctx.ChangefeedVars().ID, Type: model.AdminResume})
+	tick(day.Add(25*time.Hour + time.Minute))
+	c.Assert(manager.ShouldRunning(), check.IsTrue)
+	tick(day.Add(25*time.Hour + 2*time.Minute))
+	c.Assert(manager.ShouldRunning(), check.IsTrue)
+	c.Assert(state.Info.State, check.Equals, model.StateNormal)
+
+	// the changefeed paused manually is not resumed by the window.
+	manager.PushAdminJob(&model.AdminJob{CfID: ctx.ChangefeedVars().ID, Type: model.AdminStop})
+	tick(day.Add(25*time.Hour + 3*time.Minute))
+	tick(day.Add(27 * time.Hour))
+	c.Assert(manager.ShouldRunning(), check.IsFalse)
+	c.Assert(state.Info.State, check.Equals, model.StateStopped)
+}
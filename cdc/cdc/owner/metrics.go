@@ -65,6 +65,13 @@ var (
 			Name:      "status",
 			Help:      "The status of changefeeds",
 		}, []string{"changefeed"})
+	changefeedPauseWindowCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tikv_cdc",
+			Subsystem: "owner",
+			Name:      "pause_window_total",
+			Help:      "The number of changefeeds paused or resumed by their pause windows",
+		}, []string{"changefeed", "action"})
 )
 
 const (
@@ -83,4 +90,5 @@ func InitMetrics(registry *prometheus.Registry) {
 	registry.MustRegister(ownershipCounter)
 	registry.MustRegister(ownerMaintainKeySpanNumGauge)
 	registry.MustRegister(changefeedStatusGauge)
+	registry.MustRegister(changefeedPauseWindowCounter)
 }
@@ -39,6 +39,11 @@ type scheduler interface {
 	// Rebalance is used to trigger manual workload rebalances.
 	Rebalance()
 
+	// FinalCheckpointTs returns the checkpoint ts flushed by the sinks of all the processors,
+	// which is persisted when the changefeed is paused. It returns false if some keyspans
+	// are not replicated normally, e.g. being moved or not dispatched.
+	FinalCheckpointTs(state *orchestrator.ChangefeedReactorState) (model.Ts, bool)
+
 	// Close closes the scheduler and releases resources.
 	Close(ctx context.Context)
 }
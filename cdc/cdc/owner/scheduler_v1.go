@@ -544,7 +544,7 @@ func (w *schedulerV1CompatWrapper) Tick(
 		return schedulerv2.CheckpointCannotProceed, schedulerv2.CheckpointCannotProceed, nil
 	}
 
-	checkpointTs, resolvedTs := w.calculateWatermarks(state)
+	checkpointTs, resolvedTs := calculateWatermarks(state)
 	return checkpointTs, resolvedTs, nil
 }
 
@@ -560,7 +560,39 @@ func (w *schedulerV1CompatWrapper) Close(_ cdcContext.Context) {
 	// No-op for the old scheduler
 }
 
-func (w *schedulerV1CompatWrapper) calculateWatermarks(
+func (w *schedulerV1CompatWrapper) FinalCheckpointTs(state *orchestrator.ChangefeedReactorState) (model.Ts, bool) {
+	if len(w.inner.currentKeySpanIDs) == 0 {
+		return 0, false
+	}
+	keyspan2Capture := make(map[model.KeySpanID]model.CaptureID)
+	for captureID, taskStatus := range state.TaskStatuses {
+		if len(taskStatus.Operation) != 0 {
+			// some keyspans are being added, removed or moved.
+			return 0, false
+		}
+		for keyspanID := range taskStatus.KeySpans {
+			keyspan2Capture[keyspanID] = captureID
+		}
+	}
+	// every keyspan must be replicated by a processor reporting its position normally.
+	for _, keyspanID := range w.inner.currentKeySpanIDs {
+		captureID, ok := keyspan2Capture[keyspanID]
+		if !ok {
+			return 0, false
+		}
+		position, ok := state.TaskPositions[captureID]
+		if !ok || position.Error != nil {
+			return 0, false
+		}
+	}
+	checkpointTs, _ := calculateWatermarks(state)
+	if checkpointTs == schedulerv2.CheckpointCannotProceed {
+		return 0, false
+	}
+	return checkpointTs, true
+}
+
+func calculateWatermarks(
 	state *orchestrator.ChangefeedReactorState,
 ) (newCheckpointTs, newResolvedTs model.Ts) {
 	resolvedTs := model.Ts(math.MaxUint64)
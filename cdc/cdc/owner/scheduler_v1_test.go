@@ -623,3 +623,55 @@ func (s *schedulerSuite) TestScheduleUpdateKeySpansBySingleSpan(c *check.C) {
 		StartTs: 0, Start: []byte{'r', 0, 0, 0, 'a'}, End: []byte{'r', 0, 0, 0, 'z'},
 	})
 }
+
+func (s *schedulerSuite) TestFinalCheckpointTs(c *check.C) {
+	defer testleak.AfterTest(c)()
+
+	s.reset(c)
+	captureID := "test-capture-0"
+	s.addCapture(captureID)
+	wrapper := &schedulerV1CompatWrapper{inner: s.scheduler}
+
+	ctx := cdcContext.NewBackendContext4Test(false)
+	ctx, cancel := cdcContext.WithCancel(ctx)
+	defer cancel()
+
+	// no keyspan is dispatched.
+	_, ok := wrapper.FinalCheckpointTs(s.state)
+	c.Assert(ok, check.IsFalse)
+
+	s.scheduler.updateCurrentKeySpans = func(ctx cdcContext.Context, info *model.ChangeFeedInfo) ([]model.KeySpanID, map[model.KeySpanID]regionspan.Span, error) {
+		return []model.KeySpanID{1, 2}, map[model.KeySpanID]regionspan.Span{
+			1: {Start: []byte{'1'}, End: []byte{'2'}},
+			2: {Start: []byte{'2'}, End: []byte{'3'}},
+		}, nil
+	}
+	_, err := s.scheduler.Tick(ctx, s.state, s.captures)
+	c.Assert(err, check.IsNil)
+	s.tester.MustApplyPatches()
+	s.state.PatchTaskPosition(captureID, func(position *model.TaskPosition) (*model.TaskPosition, bool, error) {
+		return &model.TaskPosition{CheckPointTs: 10, ResolvedTs: 20}, true, nil
+	})
+	s.tester.MustApplyPatches()
+
+	// the keyspans are being added.
+	_, ok = wrapper.FinalCheckpointTs(s.state)
+	c.Assert(ok, check.IsFalse)
+
+	s.finishKeySpanOperation(captureID, 1, 2)
+	_, err = s.scheduler.Tick(ctx, s.state, s.captures)
+	c.Assert(err, check.IsNil)
+	s.tester.MustApplyPatches()
+	checkpointTs, ok := wrapper.FinalCheckpointTs(s.state)
+	c.Assert(ok, check.IsTrue)
+	c.Assert(checkpointTs, check.Equals, model.Ts(10))
+
+	// the processor reports an error.
+	s.state.PatchTaskPosition(captureID, func(position *model.TaskPosition) (*model.TaskPosition, bool, error) {
+		position.Error = &model.RunningError{Message: "sink error"}
+		return position, true, nil
+	})
+	s.tester.MustApplyPatches()
+	_, ok = wrapper.FinalCheckpointTs(s.state)
+	c.Assert(ok, check.IsFalse)
+}
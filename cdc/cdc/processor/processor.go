@@ -170,7 +170,11 @@ func (p *processor) tick(ctx cdcContext.Context, state *orchestrator.ChangefeedR
 		return nil, errors.Trace(err)
 	}
 	// sink manager will return this checkpointTs to sink node if sink node resolvedTs flush failed
-	p.sinkManager.UpdateChangeFeedCheckpointTs(state.Info.GetCheckpointTs(state.Status))
+	checkpointTs := state.Info.GetCheckpointTs(state.Status)
+	p.sinkManager.UpdateChangeFeedCheckpointTs(checkpointTs)
+	if err := p.sinkManager.EmitCheckpointTs(ctx, checkpointTs); err != nil {
+		return nil, errors.Trace(err)
+	}
 	if err := p.handleKeySpanOperation(ctx); err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -0,0 +1,304 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/tikv/migration/cdc/cdc/model"
+	"github.com/tikv/migration/cdc/pkg/config"
+	cerror "github.com/tikv/migration/cdc/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// The changelog sink writes the changed events into an external storage, which
+// `br restore raw --changelog-storage` replays upon a full backup to restore the
+// cluster to a point in time. The layout is shared with br, keep them in sync.
+const (
+	// changelogDir is the directory of the changelog files. A file is named by the
+	// min and the max commit ts of its events, the sink and the sequence of the file:
+	// changelog/{min-ts:016x}-{max-ts:016x}-{sink-id}-{seq}.log
+	changelogDir        = "changelog"
+	changelogFileSuffix = ".log"
+	// changelogMetaFile records the commit ts range covered by the changelog files.
+	changelogMetaFile    = "changelog.meta.json"
+	changelogMetaVersion = 1
+
+	changelogOpPut    = "put"
+	changelogOpDelete = "delete"
+
+	defaultChangelogCheckpointInterval = 5 * time.Second
+)
+
+// changelogSchemes are the sink URI schemes of the external storages.
+var changelogSchemes = []string{"local", "file", "s3", "gcs", "gs", "azure", "azblob"}
+
+// changelogEvent is a line of the changelog files in JSON.
+type changelogEvent struct {
+	OpType string `json:"op"`
+	// Key is the API V2 key, with the prefix of the keyspace.
+	Key       []byte `json:"key"`
+	Value     []byte `json:"value,omitempty"`
+	CRTs      uint64 `json:"ts"`
+	ExpiredTs uint64 `json:"expired-ts,omitempty"`
+}
+
+// changelogMeta is the content of changelogMetaFile. All the events whose commit ts
+// are in (StartTs, CheckpointTs] are in the changelog files.
+type changelogMeta struct {
+	Version      int    `json:"version"`
+	StartTs      uint64 `json:"start-ts"`
+	CheckpointTs uint64 `json:"checkpoint-ts"`
+}
+
+type changelogSink struct {
+	storage storage.ExternalStorage
+	// id distinguishes the files written by the sinks of different captures and runs.
+	id  string
+	seq uint64
+
+	buffer   map[model.KeySpanID][]*model.RawKVEntry
+	bufferMu sync.Mutex
+
+	// checkpointTs is the latest checkpoint ts of the changefeed, which is persisted
+	// into meta periodically.
+	checkpointTs uint64
+	meta         changelogMeta
+	metaMu       sync.Mutex
+
+	statistics *Statistics
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+func newChangelogSink(ctx context.Context, sinkURI *url.URL, _ *config.ReplicaConfig, opts map[string]string) (*changelogSink, error) {
+	backend, err := storage.ParseBackend(sinkURI.String(), &storage.BackendOptions{})
+	if err != nil {
+		return nil, cerror.WrapError(cerror.ErrSinkURIInvalid, err)
+	}
+	if local := backend.GetLocal(); local != nil {
+		// the local storage doesn't create the directories of the files.
+		if err = os.MkdirAll(filepath.Join(local.Path, changelogDir), 0o755); err != nil {
+			return nil, cerror.WrapError(cerror.ErrChangelogStorage, err)
+		}
+	}
+	s, err := storage.New(ctx, backend, &storage.ExternalStorageOptions{})
+	if err != nil {
+		return nil, cerror.WrapError(cerror.ErrChangelogStorage, err)
+	}
+	meta, err := readChangelogMeta(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	sink := &changelogSink{
+		storage:      s,
+		id:           uuid.New().String(),
+		buffer:       make(map[model.KeySpanID][]*model.RawKVEntry),
+		checkpointTs: meta.CheckpointTs,
+		meta:         *meta,
+		statistics:   NewStatistics(ctx, "changelog", opts),
+		cancel:       cancel,
+	}
+	sink.wg.Add(1)
+	go func() {
+		defer sink.wg.Done()
+		sink.run(ctx, defaultChangelogCheckpointInterval)
+	}()
+	return sink, nil
+}
+
+func readChangelogMeta(ctx context.Context, s storage.ExternalStorage) (*changelogMeta, error) {
+	meta := &changelogMeta{Version: changelogMetaVersion}
+	exists, err := s.FileExists(ctx, changelogMetaFile)
+	if err != nil {
+		return nil, cerror.WrapError(cerror.ErrChangelogStorage, err)
+	}
+	if !exists {
+		return meta, nil
+	}
+	data, err := s.ReadFile(ctx, changelogMetaFile)
+	if err != nil {
+		return nil, cerror.WrapError(cerror.ErrChangelogStorage, err)
+	}
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, cerror.WrapError(cerror.ErrChangelogStorage, err)
+	}
+	if meta.Version > changelogMetaVersion {
+		return nil, cerror.ErrChangelogStorage.GenWithStack(
+			"unsupported version %d of %s, the latest supported one is %d", meta.Version, changelogMetaFile, changelogMetaVersion)
+	}
+	return meta, nil
+}
+
+func (c *changelogSink) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.persistCheckpoint(ctx); err != nil {
+				// the checkpoint is persisted again in the next round, the restore
+				// is limited by the previous one meanwhile.
+				log.Warn("failed to persist the checkpoint of the changelog", zap.Error(err))
+			}
+		}
+	}
+}
+
+// persistCheckpoint writes the latest checkpoint ts into the meta file if it advances.
+// The changelog starts from the first checkpoint ts persisted.
+func (c *changelogSink) persistCheckpoint(ctx context.Context) error {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	checkpointTs := atomic.LoadUint64(&c.checkpointTs)
+	if checkpointTs <= c.meta.CheckpointTs {
+		return nil
+	}
+	meta := c.meta
+	if meta.StartTs == 0 {
+		meta.StartTs = checkpointTs
+	}
+	meta.CheckpointTs = checkpointTs
+	data, err := json.Marshal(&meta)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = c.storage.WriteFile(ctx, changelogMetaFile, data); err != nil {
+		return cerror.WrapError(cerror.ErrChangelogStorage, err)
+	}
+	c.meta = meta
+	return nil
+}
+
+func (c *changelogSink) EmitChangedEvents(ctx context.Context, rawKVEntries ...*model.RawKVEntry) error {
+	c.bufferMu.Lock()
+	for _, entry := range rawKVEntries {
+		c.buffer[entry.KeySpanID] = append(c.buffer[entry.KeySpanID], entry)
+	}
+	c.bufferMu.Unlock()
+	c.statistics.AddEntriesCount(len(rawKVEntries))
+	return nil
+}
+
+// FlushChangedEvents writes the buffered events of the keyspan whose commit ts are
+// less than or equal to resolvedTs into a changelog file.
+func (c *changelogSink) FlushChangedEvents(ctx context.Context, keyspanID model.KeySpanID, resolvedTs uint64) (uint64, error) {
+	c.bufferMu.Lock()
+	var flushed, remained []*model.RawKVEntry
+	for _, entry := range c.buffer[keyspanID] {
+		if entry.CRTs <= resolvedTs {
+			flushed = append(flushed, entry)
+		} else {
+			remained = append(remained, entry)
+		}
+	}
+	if len(remained) > 0 {
+		c.buffer[keyspanID] = remained
+	} else {
+		delete(c.buffer, keyspanID)
+	}
+	c.bufferMu.Unlock()
+
+	err := c.statistics.RecordBatchExecution(func() (int, error) {
+		if len(flushed) == 0 {
+			return 0, nil
+		}
+		return len(flushed), c.writeFile(ctx, flushed)
+	})
+	if err != nil {
+		// put them back, the events are written again if the changefeed retries in place.
+		c.bufferMu.Lock()
+		c.buffer[keyspanID] = append(flushed, c.buffer[keyspanID]...)
+		c.bufferMu.Unlock()
+		return 0, errors.Trace(err)
+	}
+	c.statistics.PrintStatus(ctx)
+	return resolvedTs, nil
+}
+
+func (c *changelogSink) writeFile(ctx context.Context, entries []*model.RawKVEntry) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	minTs, maxTs := entries[0].CRTs, entries[0].CRTs
+	for _, entry := range entries {
+		event := changelogEvent{
+			Key:       entry.Key,
+			CRTs:      entry.CRTs,
+			ExpiredTs: entry.ExpiredTs,
+		}
+		switch entry.OpType {
+		case model.OpTypePut:
+			event.OpType = changelogOpPut
+			event.Value = entry.Value
+		case model.OpTypeDelete:
+			event.OpType = changelogOpDelete
+		default:
+			return errors.Errorf("unexpected OpType: %v", entry.OpType)
+		}
+		if err := encoder.Encode(&event); err != nil {
+			return errors.Trace(err)
+		}
+		if entry.CRTs < minTs {
+			minTs = entry.CRTs
+		}
+		if entry.CRTs > maxTs {
+			maxTs = entry.CRTs
+		}
+	}
+	name := fmt.Sprintf("%s/%016x-%016x-%s-%d%s",
+		changelogDir, minTs, maxTs, c.id, atomic.AddUint64(&c.seq, 1), changelogFileSuffix)
+	if err := c.storage.WriteFile(ctx, name, buf.Bytes()); err != nil {
+		return cerror.WrapError(cerror.ErrChangelogStorage, err)
+	}
+	return nil
+}
+
+// EmitCheckpointTs records the checkpoint ts of the changefeed, which is persisted
+// asynchronously to keep it off the processor tick.
+func (c *changelogSink) EmitCheckpointTs(ctx context.Context, ts uint64) error {
+	for {
+		checkpointTs := atomic.LoadUint64(&c.checkpointTs)
+		if ts <= checkpointTs || atomic.CompareAndSwapUint64(&c.checkpointTs, checkpointTs, ts) {
+			return nil
+		}
+	}
+}
+
+func (c *changelogSink) Close(ctx context.Context) error {
+	c.cancel()
+	c.wg.Wait()
+	return errors.Trace(c.persistCheckpoint(ctx))
+}
+
+func (c *changelogSink) Barrier(ctx context.Context, keyspanID model.KeySpanID) error {
+	// Barrier does nothing because FlushChangedEvents writes the events synchronously.
+	return nil
+}
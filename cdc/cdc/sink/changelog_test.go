@@ -0,0 +1,99 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/cdc/cdc/model"
+	"github.com/tikv/migration/cdc/pkg/util/testleak"
+)
+
+func readChangelogFile(t *testing.T, path string) []changelogEvent {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var events []changelogEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event changelogEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
+func TestChangelogSink(t *testing.T) {
+	defer testleak.AfterTestT(t)()
+	require := require.New(t)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	sinkURI, err := url.Parse("local://" + dir)
+	require.NoError(err)
+	sink, err := newChangelogSink(ctx, sinkURI, nil, make(map[string]string))
+	require.NoError(err)
+
+	require.NoError(sink.EmitChangedEvents(ctx,
+		&model.RawKVEntry{OpType: model.OpTypePut, Key: []byte("ra"), Value: []byte("v1"), CRTs: 101, KeySpanID: 1},
+		&model.RawKVEntry{OpType: model.OpTypeDelete, Key: []byte("rb"), CRTs: 102, KeySpanID: 1},
+		&model.RawKVEntry{OpType: model.OpTypePut, Key: []byte("rc"), Value: []byte("v2"), CRTs: 103, ExpiredTs: 200, KeySpanID: 1},
+		&model.RawKVEntry{OpType: model.OpTypePut, Key: []byte("rd"), Value: []byte("v3"), CRTs: 101, KeySpanID: 2},
+	))
+	checkpointTs, err := sink.FlushChangedEvents(ctx, 1, 102)
+	require.NoError(err)
+	require.Equal(uint64(102), checkpointTs)
+
+	files, err := os.ReadDir(filepath.Join(dir, changelogDir))
+	require.NoError(err)
+	require.Len(files, 1)
+	require.Regexp(`^0000000000000065-0000000000000066-.+-1\.log$`, files[0].Name())
+	require.Equal([]changelogEvent{
+		{OpType: changelogOpPut, Key: []byte("ra"), Value: []byte("v1"), CRTs: 101},
+		{OpType: changelogOpDelete, Key: []byte("rb"), CRTs: 102},
+	}, readChangelogFile(t, filepath.Join(dir, changelogDir, files[0].Name())))
+
+	// the events of other keyspans and the unresolved events are kept.
+	_, err = sink.FlushChangedEvents(ctx, 1, 103)
+	require.NoError(err)
+	files, err = os.ReadDir(filepath.Join(dir, changelogDir))
+	require.NoError(err)
+	require.Len(files, 2)
+	require.Len(sink.buffer, 1)
+	require.Len(sink.buffer[2], 1)
+
+	// the checkpoint ts is persisted on close, and the start ts is kept by the next run.
+	require.NoError(sink.EmitCheckpointTs(ctx, 100))
+	require.NoError(sink.EmitCheckpointTs(ctx, 99))
+	require.NoError(sink.Close(ctx))
+	meta, err := readChangelogMeta(ctx, sink.storage)
+	require.NoError(err)
+	require.Equal(changelogMeta{Version: changelogMetaVersion, StartTs: 100, CheckpointTs: 100}, *meta)
+
+	sink, err = newChangelogSink(ctx, sinkURI, nil, make(map[string]string))
+	require.NoError(err)
+	require.NoError(sink.EmitCheckpointTs(ctx, 103))
+	require.NoError(sink.Close(ctx))
+	meta, err = readChangelogMeta(ctx, sink.storage)
+	require.NoError(err)
+	require.Equal(changelogMeta{Version: changelogMetaVersion, StartTs: 100, CheckpointTs: 103}, *meta)
+}
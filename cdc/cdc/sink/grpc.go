@@ -0,0 +1,397 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/tikv/migration/cdc/cdc/model"
+	"github.com/tikv/migration/cdc/pkg/config"
+	cerror "github.com/tikv/migration/cdc/pkg/errors"
+	"github.com/tikv/migration/cdc/pkg/security"
+	"github.com/tikv/migration/cdc/proto/sinkpb"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// The grpc sink pushes the changed events to a user service implementing the
+// ChangefeedSink service of proto/ChangefeedSink.proto, by the sink URI
+// grpc://{host}:{port}/?max-batch-size=1024&max-inflight-batches=16&ack-timeout=30s.
+// The service acks every batch, and at most max-inflight-batches batches are not
+// acked at the same time, which is the backpressure on the changefeed.
+const (
+	defaultGRPCMaxBatchSize       = 1024
+	defaultGRPCMaxInflightBatches = 16
+	defaultGRPCAckTimeout         = 30 * time.Second
+)
+
+type grpcSink struct {
+	changefeedID model.ChangeFeedID
+	conn         *grpc.ClientConn
+	client       sinkpb.ChangefeedSinkClient
+
+	maxBatchSize       int
+	maxInflightBatches int
+	ackTimeout         time.Duration
+
+	buffer   map[model.KeySpanID][]*model.RawKVEntry
+	bufferMu sync.Mutex
+
+	// checkpointTs is the latest checkpoint ts of the changefeed, which is sent
+	// along with the batches.
+	checkpointTs uint64
+
+	// stream is the stream the batches are pushed to, it's recreated by the next
+	// flush once broken.
+	stream   *grpcSinkStream
+	streamMu sync.Mutex
+
+	statistics *Statistics
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+func parseGRPCSinkURI(sinkURI *url.URL) (maxBatchSize, maxInflightBatches int, ackTimeout time.Duration, err error) {
+	maxBatchSize, maxInflightBatches, ackTimeout = defaultGRPCMaxBatchSize, defaultGRPCMaxInflightBatches, defaultGRPCAckTimeout
+	query := sinkURI.Query()
+	if s := query.Get("max-batch-size"); s != "" {
+		if maxBatchSize, err = strconv.Atoi(s); err != nil || maxBatchSize <= 0 {
+			return 0, 0, 0, cerror.ErrSinkURIInvalid.GenWithStack("invalid max-batch-size %s of the grpc sink", s)
+		}
+	}
+	if s := query.Get("max-inflight-batches"); s != "" {
+		if maxInflightBatches, err = strconv.Atoi(s); err != nil || maxInflightBatches <= 0 {
+			return 0, 0, 0, cerror.ErrSinkURIInvalid.GenWithStack("invalid max-inflight-batches %s of the grpc sink", s)
+		}
+	}
+	if s := query.Get("ack-timeout"); s != "" {
+		if ackTimeout, err = time.ParseDuration(s); err != nil || ackTimeout <= 0 {
+			return 0, 0, 0, cerror.ErrSinkURIInvalid.GenWithStack("invalid ack-timeout %s of the grpc sink", s)
+		}
+	}
+	return maxBatchSize, maxInflightBatches, ackTimeout, nil
+}
+
+func newGRPCSink(
+	ctx context.Context, changefeedID model.ChangeFeedID, sinkURI *url.URL, _ *config.ReplicaConfig, opts map[string]string,
+) (*grpcSink, error) {
+	if sinkURI.Host == "" {
+		return nil, cerror.ErrSinkURIInvalid.GenWithStack("the address of the grpc sink is missing")
+	}
+	maxBatchSize, maxInflightBatches, ackTimeout, err := parseGRPCSinkURI(sinkURI)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	credential := &security.Credential{
+		CAPath:   sinkURI.Query().Get("ca-path"),
+		CertPath: sinkURI.Query().Get("cert-path"),
+		KeyPath:  sinkURI.Query().Get("key-path"),
+	}
+	dialOpt, err := credential.ToGRPCDialOption()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// the connection is established lazily, so an unavailable service doesn't fail
+	// creating the changefeed, but the first flush.
+	conn, err := grpc.DialContext(ctx, sinkURI.Host, dialOpt)
+	if err != nil {
+		return nil, cerror.WrapError(cerror.ErrGRPCSink, err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &grpcSink{
+		changefeedID:       changefeedID,
+		conn:               conn,
+		client:             sinkpb.NewChangefeedSinkClient(conn),
+		maxBatchSize:       maxBatchSize,
+		maxInflightBatches: maxInflightBatches,
+		ackTimeout:         ackTimeout,
+		buffer:             make(map[model.KeySpanID][]*model.RawKVEntry),
+		statistics:         NewStatistics(ctx, "grpc", opts),
+		ctx:                ctx,
+		cancel:             cancel,
+	}, nil
+}
+
+func (g *grpcSink) EmitChangedEvents(ctx context.Context, rawKVEntries ...*model.RawKVEntry) error {
+	g.bufferMu.Lock()
+	for _, entry := range rawKVEntries {
+		g.buffer[entry.KeySpanID] = append(g.buffer[entry.KeySpanID], entry)
+	}
+	g.bufferMu.Unlock()
+	g.statistics.AddEntriesCount(len(rawKVEntries))
+	return nil
+}
+
+// FlushChangedEvents pushes the buffered events of the keyspan whose commit ts are
+// less than or equal to resolvedTs, and waits for the service acking them.
+func (g *grpcSink) FlushChangedEvents(ctx context.Context, keyspanID model.KeySpanID, resolvedTs uint64) (uint64, error) {
+	g.bufferMu.Lock()
+	var flushed, remained []*model.RawKVEntry
+	for _, entry := range g.buffer[keyspanID] {
+		if entry.CRTs <= resolvedTs {
+			flushed = append(flushed, entry)
+		} else {
+			remained = append(remained, entry)
+		}
+	}
+	if len(remained) > 0 {
+		g.buffer[keyspanID] = remained
+	} else {
+		delete(g.buffer, keyspanID)
+	}
+	g.bufferMu.Unlock()
+
+	err := g.statistics.RecordBatchExecution(func() (int, error) {
+		if len(flushed) == 0 {
+			return 0, nil
+		}
+		return len(flushed), g.push(ctx, flushed, resolvedTs)
+	})
+	if err != nil {
+		// put them back, the events are pushed again if the changefeed retries in place.
+		g.bufferMu.Lock()
+		g.buffer[keyspanID] = append(flushed, g.buffer[keyspanID]...)
+		g.bufferMu.Unlock()
+		return 0, errors.Trace(err)
+	}
+	g.statistics.PrintStatus(ctx)
+	return resolvedTs, nil
+}
+
+// push sends the entries in batches of maxBatchSize, and waits for all of them acked.
+func (g *grpcSink) push(ctx context.Context, entries []*model.RawKVEntry, resolvedTs uint64) error {
+	stream, err := g.getStream()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	acks := make([]<-chan error, 0, (len(entries)+g.maxBatchSize-1)/g.maxBatchSize)
+	for start := 0; start < len(entries); start += g.maxBatchSize {
+		end := start + g.maxBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		batch := &sinkpb.EventBatch{
+			ChangefeedId: g.changefeedID,
+			Events:       make([]*sinkpb.Event, 0, end-start),
+			CheckpointTs: atomic.LoadUint64(&g.checkpointTs),
+		}
+		if end == len(entries) {
+			// the events are resolved once the last batch is acked.
+			batch.ResolvedTs = resolvedTs
+		}
+		for _, entry := range entries[start:end] {
+			event, err := grpcSinkEvent(entry)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			batch.Events = append(batch.Events, event)
+		}
+		ack, err := stream.send(ctx, batch)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		acks = append(acks, ack)
+	}
+	timer := time.NewTimer(g.ackTimeout)
+	defer timer.Stop()
+	for _, ack := range acks {
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case err := <-ack:
+			if err != nil {
+				return errors.Trace(err)
+			}
+		case <-timer.C:
+			// the batches not acked may be lost, push them on a new stream.
+			err := cerror.ErrGRPCSink.GenWithStack("the batches are not acked in %s", g.ackTimeout)
+			stream.fail(err)
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func grpcSinkEvent(entry *model.RawKVEntry) (*sinkpb.Event, error) {
+	event := &sinkpb.Event{
+		Key:       entry.Key,
+		CommitTs:  entry.CRTs,
+		ExpiredTs: entry.ExpiredTs,
+	}
+	switch entry.OpType {
+	case model.OpTypePut:
+		event.OpType = sinkpb.OpType_PUT
+		event.Value = entry.Value
+	case model.OpTypeDelete:
+		event.OpType = sinkpb.OpType_DELETE
+	default:
+		return nil, errors.Errorf("unexpected OpType: %v", entry.OpType)
+	}
+	return event, nil
+}
+
+// getStream returns the stream the batches are pushed to, a new one is opened if
+// the previous one is broken.
+func (g *grpcSink) getStream() (*grpcSinkStream, error) {
+	g.streamMu.Lock()
+	defer g.streamMu.Unlock()
+	if g.stream != nil && g.stream.broken() == nil {
+		return g.stream, nil
+	}
+	ctx, cancel := context.WithCancel(g.ctx)
+	client, err := g.client.Push(ctx)
+	if err != nil {
+		cancel()
+		return nil, cerror.WrapError(cerror.ErrGRPCSink, err)
+	}
+	g.stream = newGRPCSinkStream(client, cancel, g.maxInflightBatches)
+	return g.stream, nil
+}
+
+func (g *grpcSink) EmitCheckpointTs(ctx context.Context, ts uint64) error {
+	for {
+		checkpointTs := atomic.LoadUint64(&g.checkpointTs)
+		if ts <= checkpointTs || atomic.CompareAndSwapUint64(&g.checkpointTs, checkpointTs, ts) {
+			return nil
+		}
+	}
+}
+
+func (g *grpcSink) Close(ctx context.Context) error {
+	g.cancel()
+	g.streamMu.Lock()
+	if g.stream != nil {
+		g.stream.fail(cerror.ErrGRPCSink.GenWithStack("the sink is closed"))
+	}
+	g.streamMu.Unlock()
+	return errors.Trace(g.conn.Close())
+}
+
+func (g *grpcSink) Barrier(ctx context.Context, keyspanID model.KeySpanID) error {
+	// Barrier does nothing because FlushChangedEvents waits for the events acked.
+	return nil
+}
+
+// grpcSinkStream is a Push stream, the acks are received in the background and
+// dispatched to the senders of the batches.
+type grpcSinkStream struct {
+	client sinkpb.ChangefeedSink_PushClient
+	cancel context.CancelFunc
+	// inflight has a token for every batch not acked.
+	inflight chan struct{}
+
+	sendMu sync.Mutex
+
+	mu      sync.Mutex
+	batchID uint64
+	pending map[uint64]chan error
+	err     error
+}
+
+func newGRPCSinkStream(client sinkpb.ChangefeedSink_PushClient, cancel context.CancelFunc, maxInflightBatches int) *grpcSinkStream {
+	s := &grpcSinkStream{
+		client:   client,
+		cancel:   cancel,
+		inflight: make(chan struct{}, maxInflightBatches),
+		pending:  make(map[uint64]chan error),
+	}
+	go s.receive()
+	return s
+}
+
+// send sends the batch once the number of the batches not acked is below the limit,
+// it returns the channel receiving the result of the batch.
+func (s *grpcSinkStream) send(ctx context.Context, batch *sinkpb.EventBatch) (<-chan error, error) {
+	select {
+	case <-ctx.Done():
+		return nil, errors.Trace(ctx.Err())
+	case s.inflight <- struct{}{}:
+	}
+	ack := make(chan error, 1)
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		<-s.inflight
+		return nil, errors.Trace(s.err)
+	}
+	s.batchID++
+	batch.BatchId = s.batchID
+	s.pending[batch.BatchId] = ack
+	s.mu.Unlock()
+
+	s.sendMu.Lock()
+	err := s.client.Send(batch)
+	s.sendMu.Unlock()
+	if err != nil {
+		err = cerror.WrapError(cerror.ErrGRPCSink, err)
+		s.fail(err)
+		return nil, errors.Trace(err)
+	}
+	return ack, nil
+}
+
+func (s *grpcSinkStream) receive() {
+	for {
+		ack, err := s.client.Recv()
+		if err != nil {
+			s.fail(cerror.WrapError(cerror.ErrGRPCSink, err))
+			return
+		}
+		s.mu.Lock()
+		ch, ok := s.pending[ack.BatchId]
+		delete(s.pending, ack.BatchId)
+		s.mu.Unlock()
+		if !ok {
+			log.Warn("grpc sink receives the ack of an unknown batch", zap.Uint64("batch", ack.BatchId))
+			continue
+		}
+		<-s.inflight
+		if ack.Error != "" {
+			ch <- cerror.ErrGRPCSink.GenWithStack("batch %d is rejected: %s", ack.BatchId, ack.Error)
+		} else {
+			ch <- nil
+		}
+	}
+}
+
+// fail breaks the stream, the batches not acked fail with err.
+func (s *grpcSinkStream) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	s.cancel()
+	for id, ch := range s.pending {
+		delete(s.pending, id)
+		<-s.inflight
+		ch <- err
+	}
+	log.Warn("grpc sink stream is broken", zap.Error(err))
+}
+
+// broken returns the error breaking the stream, nil if the stream is healthy.
+func (s *grpcSinkStream) broken() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
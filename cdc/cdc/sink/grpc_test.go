@@ -0,0 +1,174 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/cdc/cdc/model"
+	"github.com/tikv/migration/cdc/proto/sinkpb"
+	"google.golang.org/grpc"
+)
+
+// mockChangefeedSinkServer records the pushed batches, and rejects them if reject is set.
+type mockChangefeedSinkServer struct {
+	sinkpb.UnimplementedChangefeedSinkServer
+
+	mu      sync.Mutex
+	batches []*sinkpb.EventBatch
+	reject  string
+}
+
+func (s *mockChangefeedSinkServer) Push(stream sinkpb.ChangefeedSink_PushServer) error {
+	for {
+		batch, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		reject := s.reject
+		if reject == "" {
+			s.batches = append(s.batches, batch)
+		}
+		s.mu.Unlock()
+		if err = stream.Send(&sinkpb.Ack{BatchId: batch.BatchId, Error: reject}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *mockChangefeedSinkServer) pushed() []*sinkpb.EventBatch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*sinkpb.EventBatch{}, s.batches...)
+}
+
+func startMockChangefeedSinkServer(t *testing.T) (*mockChangefeedSinkServer, string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	mock := &mockChangefeedSinkServer{}
+	sinkpb.RegisterChangefeedSinkServer(server, mock)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+	return mock, lis.Addr().String()
+}
+
+func TestGRPCSink(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	server, addr := startMockChangefeedSinkServer(t)
+
+	sinkURI, err := url.Parse("grpc://" + addr + "/?max-batch-size=2&max-inflight-batches=1")
+	require.NoError(err)
+	sink, err := newGRPCSink(ctx, "test-cf", sinkURI, nil, make(map[string]string))
+	require.NoError(err)
+	defer sink.Close(ctx)
+
+	require.NoError(sink.EmitCheckpointTs(ctx, 100))
+	require.NoError(sink.EmitChangedEvents(ctx,
+		&model.RawKVEntry{OpType: model.OpTypePut, Key: []byte("ra"), Value: []byte("v1"), CRTs: 101, KeySpanID: 1},
+		&model.RawKVEntry{OpType: model.OpTypeDelete, Key: []byte("rb"), CRTs: 102, KeySpanID: 1},
+		&model.RawKVEntry{OpType: model.OpTypePut, Key: []byte("rc"), Value: []byte("v2"), CRTs: 103, ExpiredTs: 200, KeySpanID: 1},
+		&model.RawKVEntry{OpType: model.OpTypePut, Key: []byte("rd"), Value: []byte("v3"), CRTs: 110, KeySpanID: 1},
+	))
+	resolvedTs, err := sink.FlushChangedEvents(ctx, 1, 105)
+	require.NoError(err)
+	require.Equal(uint64(105), resolvedTs)
+
+	// the events are pushed in batches of 2, and the last batch carries the resolved ts.
+	batches := server.pushed()
+	require.Len(batches, 2)
+	require.Equal("test-cf", batches[0].ChangefeedId)
+	require.Equal(uint64(100), batches[0].CheckpointTs)
+	require.Zero(batches[0].ResolvedTs)
+	require.Equal([]*sinkpb.Event{
+		{OpType: sinkpb.OpType_PUT, Key: []byte("ra"), Value: []byte("v1"), CommitTs: 101},
+		{OpType: sinkpb.OpType_DELETE, Key: []byte("rb"), CommitTs: 102},
+	}, batches[0].Events)
+	require.Equal(uint64(105), batches[1].ResolvedTs)
+	require.Equal([]*sinkpb.Event{
+		{OpType: sinkpb.OpType_PUT, Key: []byte("rc"), Value: []byte("v2"), CommitTs: 103, ExpiredTs: 200},
+	}, batches[1].Events)
+	require.Less(batches[0].BatchId, batches[1].BatchId)
+
+	// the rejected events are kept and pushed again.
+	server.mu.Lock()
+	server.reject = "downstream is full"
+	server.mu.Unlock()
+	_, err = sink.FlushChangedEvents(ctx, 1, 110)
+	require.Error(err)
+	require.Regexp("downstream is full", err.Error())
+	server.mu.Lock()
+	server.reject = ""
+	server.mu.Unlock()
+	_, err = sink.FlushChangedEvents(ctx, 1, 110)
+	require.NoError(err)
+	batches = server.pushed()
+	require.Len(batches, 3)
+	require.Equal([]byte("rd"), batches[2].Events[0].Key)
+}
+
+func TestGRPCSinkStreamBroken(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	addr := lis.Addr().String()
+	require.NoError(lis.Close())
+
+	sinkURI, err := url.Parse("grpc://" + addr + "/?ack-timeout=1s")
+	require.NoError(err)
+	sink, err := newGRPCSink(ctx, "test-cf", sinkURI, nil, make(map[string]string))
+	require.NoError(err)
+	defer sink.Close(ctx)
+
+	// the service is unavailable, the events are kept for the retry.
+	require.NoError(sink.EmitChangedEvents(ctx,
+		&model.RawKVEntry{OpType: model.OpTypePut, Key: []byte("ra"), Value: []byte("v1"), CRTs: 101, KeySpanID: 1},
+	))
+	flushCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err = sink.FlushChangedEvents(flushCtx, 1, 101)
+	require.Error(err)
+	sink.bufferMu.Lock()
+	require.Len(sink.buffer[1], 1)
+	sink.bufferMu.Unlock()
+}
+
+func TestParseGRPCSinkURI(t *testing.T) {
+	require := require.New(t)
+	sinkURI, err := url.Parse("grpc://127.0.0.1:9000/")
+	require.NoError(err)
+	maxBatchSize, maxInflightBatches, ackTimeout, err := parseGRPCSinkURI(sinkURI)
+	require.NoError(err)
+	require.Equal(defaultGRPCMaxBatchSize, maxBatchSize)
+	require.Equal(defaultGRPCMaxInflightBatches, maxInflightBatches)
+	require.Equal(defaultGRPCAckTimeout, ackTimeout)
+
+	for _, query := range []string{"max-batch-size=0", "max-inflight-batches=x", "ack-timeout=-1s"} {
+		sinkURI, err = url.Parse("grpc://127.0.0.1:9000/?" + query)
+		require.NoError(err)
+		_, _, _, err = parseGRPCSinkURI(sinkURI)
+		require.Error(err, query)
+	}
+}
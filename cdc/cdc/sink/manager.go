@@ -132,6 +132,11 @@ func (m *Manager) UpdateChangeFeedCheckpointTs(checkpointTs uint64) {
 	}
 }
 
+// EmitCheckpointTs sends the checkpoint ts of the changefeed to the backend Sink.
+func (m *Manager) EmitCheckpointTs(ctx context.Context, checkpointTs uint64) error {
+	return m.bufSink.EmitCheckpointTs(ctx, checkpointTs)
+}
+
 type drawbackMsg struct {
 	keyspanID model.KeySpanID
 	callback  chan struct{}
@@ -86,6 +86,21 @@ func init() {
 	) (Sink, error) {
 		return newTiKVSink(ctx, sinkURI, config, opts, errCh)
 	}
+
+	sinkIniterMap["grpc"] = func(ctx context.Context, changefeedID model.ChangeFeedID, sinkURI *url.URL,
+		config *config.ReplicaConfig, opts map[string]string, errCh chan error,
+	) (Sink, error) {
+		return newGRPCSink(ctx, changefeedID, sinkURI, config, opts)
+	}
+
+	// register changelog sink for the external storages
+	for _, scheme := range changelogSchemes {
+		sinkIniterMap[scheme] = func(ctx context.Context, changefeedID model.ChangeFeedID, sinkURI *url.URL,
+			config *config.ReplicaConfig, opts map[string]string, errCh chan error,
+		) (Sink, error) {
+			return newChangelogSink(ctx, sinkURI, config, opts)
+		}
+	}
 }
 
 // New creates a new sink with the sink-uri
@@ -73,35 +73,37 @@ func (o *resumeChangefeedOptions) complete(f factory.Factory) error {
 }
 
 // confirmResumeChangefeedCheck prompts the user to confirm the use of a large data gap when noConfirm is turned off.
-func (o *resumeChangefeedOptions) confirmResumeChangefeedCheck(ctx context.Context, cmd *cobra.Command) error {
+// It returns the changefeed, whose checkpoint is where the changefeed resumes from.
+func (o *resumeChangefeedOptions) confirmResumeChangefeedCheck(ctx context.Context, cmd *cobra.Command) (*cdc.ChangefeedResp, error) {
 	resp, err := sendOwnerChangefeedQuery(ctx, o.etcdClient, o.changefeedID, o.credential)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	info := &cdc.ChangefeedResp{}
 	err = json.Unmarshal([]byte(resp), info)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	currentPhysical, _, err := o.pdClient.GetTS(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if !o.noConfirm {
-		return confirmLargeDataGap(cmd, currentPhysical, info.TSO)
+		return info, confirmLargeDataGap(cmd, currentPhysical, info.TSO)
 	}
 
-	return nil
+	return info, nil
 }
 
 // run the `cli changefeed resume` command.
 func (o *resumeChangefeedOptions) run(cmd *cobra.Command) error {
 	ctx := cmdcontext.GetDefaultContext()
 
-	if err := o.confirmResumeChangefeedCheck(ctx, cmd); err != nil {
+	info, err := o.confirmResumeChangefeedCheck(ctx, cmd)
+	if err != nil {
 		return err
 	}
 
@@ -110,7 +112,13 @@ func (o *resumeChangefeedOptions) run(cmd *cobra.Command) error {
 		Type: model.AdminResume,
 	}
 
-	return sendOwnerAdminChangeQuery(ctx, o.etcdClient, job, o.credential)
+	if err := sendOwnerAdminChangeQuery(ctx, o.etcdClient, job, o.credential); err != nil {
+		return err
+	}
+	// the checkpoint of a paused changefeed is the one flushed by all the sinks,
+	// the events after it are replicated again, and some of them may be duplicated.
+	cmd.Printf("Resume changefeed %s from checkpoint ts %d (%s)\n", o.changefeedID, info.TSO, info.Checkpoint)
+	return nil
 }
 
 // newCmdResumeChangefeed creates the `cli changefeed resume` command.
@@ -147,6 +147,7 @@ func TestParseCfg(t *testing.T) {
 				MaxDays:    0,
 				MaxBackups: 0,
 			},
+			Format:            "text",
 			InternalErrOutput: "stderr",
 		},
 		DataDir:                dataDir,
@@ -281,6 +282,7 @@ server-worker-pool-size = 16
 				MaxDays:    1,
 				MaxBackups: 1,
 			},
+			Format:            "text",
 			InternalErrOutput: "stderr",
 		},
 		DataDir:                dataDir,
@@ -406,6 +408,7 @@ cert-allowed-cn = ["dd","ee"]
 				MaxDays:    1,
 				MaxBackups: 1,
 			},
+			Format:            "text",
 			InternalErrOutput: "stderr",
 		},
 		DataDir:                dataDir,
@@ -54,6 +54,7 @@ const (
       "max-days": 0,
       "max-backups": 0
     },
+    "format": "text",
     "error-output": "stderr"
   },
   "data-dir": "",
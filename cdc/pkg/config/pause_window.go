@@ -0,0 +1,110 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"time"
+
+	cerror "github.com/tikv/migration/cdc/pkg/errors"
+)
+
+const pauseWindowTimeLayout = "15:04"
+
+// PauseWindow is a daily time window during which the changefeed is paused by the owner,
+// e.g. when the upstream runs nightly batch imports.
+type PauseWindow struct {
+	// Start and End are the time of day in "15:04" format. The window crosses
+	// midnight if End is not after Start.
+	Start string `toml:"start" json:"start"`
+	End   string `toml:"end" json:"end"`
+	// Weekdays are the days the window starts on, e.g. ["sat", "sun"]. Empty means every day.
+	Weekdays []string `toml:"weekdays" json:"weekdays,omitempty"`
+	// TimeZone is the IANA time zone of Start and End, UTC by default.
+	TimeZone string `toml:"time-zone" json:"time-zone,omitempty"`
+}
+
+func (w *PauseWindow) validate() error {
+	if _, err := time.Parse(pauseWindowTimeLayout, w.Start); err != nil {
+		return cerror.ErrChangefeedPauseWindowInvalid.GenWithStackByArgs("start", w.Start)
+	}
+	if _, err := time.Parse(pauseWindowTimeLayout, w.End); err != nil {
+		return cerror.ErrChangefeedPauseWindowInvalid.GenWithStackByArgs("end", w.End)
+	}
+	if _, err := time.LoadLocation(w.TimeZone); err != nil {
+		return cerror.ErrChangefeedPauseWindowInvalid.GenWithStackByArgs("time-zone", w.TimeZone)
+	}
+	for _, day := range w.Weekdays {
+		if _, ok := parseWeekday(day); !ok {
+			return cerror.ErrChangefeedPauseWindowInvalid.GenWithStackByArgs("weekdays", day)
+		}
+	}
+	return nil
+}
+
+func parseWeekday(day string) (time.Weekday, bool) {
+	day = strings.ToLower(day)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if day == name || day == name[:3] {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+func (w *PauseWindow) startsOn(day time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, d := range w.Weekdays {
+		if weekday, ok := parseWeekday(d); ok && weekday == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains returns the end of the window if t is in the window.
+func (w *PauseWindow) Contains(t time.Time) (time.Time, bool) {
+	start, err := time.Parse(pauseWindowTimeLayout, w.Start)
+	if err != nil {
+		return time.Time{}, false
+	}
+	end, err := time.Parse(pauseWindowTimeLayout, w.End)
+	if err != nil {
+		return time.Time{}, false
+	}
+	loc, err := time.LoadLocation(w.TimeZone)
+	if err != nil {
+		return time.Time{}, false
+	}
+	t = t.In(loc)
+	// the window containing t starts either today or yesterday if it crosses midnight.
+	for _, offset := range []int{0, -1} {
+		day := time.Date(t.Year(), t.Month(), t.Day()+offset, 0, 0, 0, 0, loc)
+		if !w.startsOn(day.Weekday()) {
+			continue
+		}
+		windowStart := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, loc)
+		windowEnd := time.Date(day.Year(), day.Month(), day.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+		if !windowEnd.After(windowStart) {
+			windowEnd = windowEnd.AddDate(0, 0, 1)
+		}
+		if !t.Before(windowStart) && t.Before(windowEnd) {
+			return windowEnd, true
+		}
+	}
+	return time.Time{}, false
+}
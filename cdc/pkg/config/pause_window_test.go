@@ -0,0 +1,71 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPauseWindow(t *testing.T) {
+	t.Parallel()
+	at := func(s string) time.Time {
+		tm, err := time.Parse(time.RFC3339, s)
+		require.Nil(t, err)
+		return tm
+	}
+
+	// 2022-06-04 is a Saturday.
+	w := &PauseWindow{Start: "01:00", End: "05:30"}
+	require.Nil(t, w.validate())
+	end, ok := w.Contains(at("2022-06-04T03:00:00Z"))
+	require.True(t, ok)
+	require.Equal(t, at("2022-06-04T05:30:00Z"), end.UTC())
+	_, ok = w.Contains(at("2022-06-04T05:30:00Z"))
+	require.False(t, ok)
+	_, ok = w.Contains(at("2022-06-04T00:59:00Z"))
+	require.False(t, ok)
+
+	// the window crosses midnight and starts on Saturday only.
+	w = &PauseWindow{Start: "22:00", End: "02:00", Weekdays: []string{"sat"}}
+	require.Nil(t, w.validate())
+	end, ok = w.Contains(at("2022-06-05T01:00:00Z"))
+	require.True(t, ok)
+	require.Equal(t, at("2022-06-05T02:00:00Z"), end.UTC())
+	_, ok = w.Contains(at("2022-06-05T23:00:00Z"))
+	require.False(t, ok)
+
+	// the window is in another time zone.
+	w = &PauseWindow{Start: "09:00", End: "10:00", TimeZone: "Asia/Shanghai"}
+	require.Nil(t, w.validate())
+	end, ok = w.Contains(at("2022-06-04T01:30:00Z"))
+	require.True(t, ok)
+	require.Equal(t, at("2022-06-04T02:00:00Z"), end.UTC())
+
+	require.NotNil(t, (&PauseWindow{Start: "25:00", End: "02:00"}).validate())
+	require.NotNil(t, (&PauseWindow{Start: "01:00", End: "02:00", Weekdays: []string{"someday"}}).validate())
+	require.NotNil(t, (&PauseWindow{Start: "01:00", End: "02:00", TimeZone: "Mars/Olympus"}).validate())
+
+	// the latest end of the overlapped windows is returned.
+	cfg := GetDefaultReplicaConfig()
+	cfg.PauseWindows = []*PauseWindow{{Start: "01:00", End: "03:00"}, {Start: "02:00", End: "04:00"}}
+	require.Nil(t, cfg.Validate())
+	end, ok = cfg.PauseWindowEnd(at("2022-06-04T02:30:00Z"))
+	require.True(t, ok)
+	require.Equal(t, at("2022-06-04T04:00:00Z"), end.UTC())
+	_, ok = cfg.PauseWindowEnd(at("2022-06-04T04:30:00Z"))
+	require.False(t, ok)
+}
@@ -16,6 +16,7 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/tikv/migration/cdc/pkg/config/outdated"
 	"github.com/tikv/migration/cdc/pkg/util"
@@ -45,6 +46,7 @@ type replicaConfig struct {
 	Sink             *SinkConfig          `toml:"sink" json:"sink"`
 	Scheduler        *SchedulerConfig     `toml:"scheduler" json:"scheduler"`
 	Filter           *util.KvFilterConfig `toml:"filter" json:"filter"`
+	PauseWindows     []*PauseWindow       `toml:"pause-windows" json:"pause-windows,omitempty"`
 }
 
 // Marshal returns the json marshal format of a ReplicationConfig
@@ -121,9 +123,26 @@ func (c *ReplicaConfig) Validate() error {
 			return err
 		}
 	}
+	for _, w := range c.PauseWindows {
+		if err := w.validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// PauseWindowEnd returns the end of the pause window containing t.
+// If t is in several windows, the latest end is returned.
+func (c *ReplicaConfig) PauseWindowEnd(t time.Time) (time.Time, bool) {
+	var end time.Time
+	for _, w := range c.PauseWindows {
+		if e, ok := w.Contains(t); ok && e.After(end) {
+			end = e
+		}
+	}
+	return end, !end.IsZero()
+}
+
 // GetDefaultReplicaConfig returns the default replica config.
 func GetDefaultReplicaConfig() *ReplicaConfig {
 	return defaultReplicaConfig.Clone()
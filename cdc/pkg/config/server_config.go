@@ -55,8 +55,10 @@ type LogFileConfig struct {
 
 // LogConfig represents log config for server
 type LogConfig struct {
-	File              *LogFileConfig `toml:"file" json:"file"`
-	InternalErrOutput string         `toml:"error-output" json:"error-output"`
+	File *LogFileConfig `toml:"file" json:"file"`
+	// Format is the format of the logs, "text" or "json".
+	Format            string `toml:"format" json:"format"`
+	InternalErrOutput string `toml:"error-output" json:"error-output"`
 }
 
 var defaultServerConfig = &ServerConfig{
@@ -70,6 +72,7 @@ var defaultServerConfig = &ServerConfig{
 			MaxDays:    0,
 			MaxBackups: 0,
 		},
+		Format:            "text",
 		InternalErrOutput: "stderr",
 	},
 	DataDir: "",
@@ -183,6 +183,7 @@ var (
 	ErrOwnerChangefeedNotFound      = errors.Normalize("changefeed %s not found in owner cache", errors.RFCCodeText("CDC:ErrOwnerChangefeedNotFound"))
 	ErrChangefeedUpdateRefused      = errors.Normalize("changefeed update error: %s", errors.RFCCodeText("CDC:ErrChangefeedUpdateRefused"))
 	ErrChangefeedAbnormalState      = errors.Normalize("changefeed in abnormal state: %s, replication status: %+v", errors.RFCCodeText("CDC:ErrChangefeedAbnormalState"))
+	ErrChangefeedPauseWindowInvalid = errors.Normalize("invalid %s of the pause window: %s", errors.RFCCodeText("CDC:ErrChangefeedPauseWindowInvalid"))
 	ErrInvalidAdminJobType          = errors.Normalize("invalid admin job type: %d", errors.RFCCodeText("CDC:ErrInvalidAdminJobType"))
 	ErrOwnerEtcdWatch               = errors.Normalize("etcd watch returns error", errors.RFCCodeText("CDC:ErrOwnerEtcdWatch"))
 	ErrOwnerCampaignKeyDeleted      = errors.Normalize("owner campaign key deleted", errors.RFCCodeText("CDC:ErrOwnerCampaignKeyDeleted"))
@@ -292,4 +293,10 @@ var (
 
 	// TiKV sink related error
 	ErrTiKVInvalidConfig = errors.Normalize("TiKV sink config invalid", errors.RFCCodeText("CDC:ErrTiKVInvalidConfig"))
+
+	// changelog sink related error
+	ErrChangelogStorage = errors.Normalize("changelog storage failed", errors.RFCCodeText("CDC:ErrChangelogStorage"))
+
+	// grpc sink related error
+	ErrGRPCSink = errors.Normalize("grpc sink failed", errors.RFCCodeText("CDC:ErrGRPCSink"))
 )
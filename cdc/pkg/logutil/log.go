@@ -20,6 +20,7 @@ import (
 	"strings"
 
 	"github.com/Shopify/sarama"
+	"github.com/google/uuid"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"go.uber.org/zap"
@@ -30,6 +31,10 @@ import (
 // _globalP is the global ZapProperties in log
 var _globalP *log.ZapProperties
 
+// FieldRunID identifies a run of the process, it annotates all logs of the process, so that
+// the logs of the process can be filtered out after aggregated with others, as br does.
+const FieldRunID = "run-id"
+
 const (
 	defaultLogLevel   = "info"
 	defaultLogMaxDays = 7
@@ -42,6 +47,8 @@ type Config struct {
 	Level string `toml:"level" json:"level"`
 	// Log filename, leave empty to disable file log.
 	File string `toml:"file" json:"file"`
+	// Log format, "text" or "json".
+	Format string `toml:"format" json:"format"`
 	// Max size for a single file, in MB.
 	FileMaxSize int `toml:"max-size" json:"max-size"`
 	// Max log keep days, default is never deleting.
@@ -86,7 +93,8 @@ func SetLogLevel(level string) error {
 // InitLogger initializes logger
 func InitLogger(cfg *Config) error {
 	pclogConfig := &log.Config{
-		Level: cfg.Level,
+		Level:  cfg.Level,
+		Format: cfg.Format,
 		File: log.FileLogConfig{
 			Filename:   cfg.File,
 			MaxSize:    cfg.FileMaxSize,
@@ -105,7 +113,8 @@ func InitLogger(cfg *Config) error {
 
 	// Do not log stack traces at all, as we'll get the stack trace from the
 	// error itself.
-	lg = lg.WithOptions(zap.AddStacktrace(zap.DPanicLevel))
+	lg = lg.WithOptions(zap.AddStacktrace(zap.DPanicLevel)).
+		With(zap.String(FieldRunID, uuid.New().String()))
 
 	log.ReplaceGlobals(lg, _globalP)
 
@@ -55,6 +55,12 @@ func TestInitLoggerAndSetLogLevel(t *testing.T) {
 	// Set an invalid level.
 	err = SetLogLevel("badlevel")
 	require.Error(t, err)
+
+	log.Warn("annotated by the run id")
+	require.NoError(t, log.Sync())
+	content, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+	require.Contains(t, string(content), "["+FieldRunID+"=")
 }
 
 func TestZapErrorFilter(t *testing.T) {
@@ -0,0 +1,205 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sinkpb is the Go binding of ChangefeedSink.proto, the contract between the
+// grpc sink and the user service. The messages carry the protobuf struct tags and are
+// marshaled by reflection, so they are kept in sync with the proto file by hand.
+package sinkpb
+
+import (
+	context "context"
+
+	proto "github.com/gogo/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+type OpType int32
+
+const (
+	OpType_PUT    OpType = 0
+	OpType_DELETE OpType = 1
+)
+
+var OpType_name = map[int32]string{
+	0: "PUT",
+	1: "DELETE",
+}
+
+var OpType_value = map[string]int32{
+	"PUT":    0,
+	"DELETE": 1,
+}
+
+func (x OpType) String() string {
+	return proto.EnumName(OpType_name, int32(x))
+}
+
+// Event is a changed raw kv entry.
+type Event struct {
+	OpType OpType `protobuf:"varint,1,opt,name=op_type,json=opType,proto3,enum=sinkpb.OpType" json:"op_type,omitempty"`
+	// key is the API V2 key, with the prefix of the keyspace.
+	Key []byte `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	// value is empty for DELETE.
+	Value    []byte `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	CommitTs uint64 `protobuf:"varint,4,opt,name=commit_ts,json=commitTs,proto3" json:"commit_ts,omitempty"`
+	// expired_ts is the ts the entry expires at, 0 for no TTL.
+	ExpiredTs uint64 `protobuf:"varint,5,opt,name=expired_ts,json=expiredTs,proto3" json:"expired_ts,omitempty"`
+}
+
+func (m *Event) Reset()         { *m = Event{} }
+func (m *Event) String() string { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()    {}
+
+type EventBatch struct {
+	ChangefeedId string `protobuf:"bytes,1,opt,name=changefeed_id,json=changefeedId,proto3" json:"changefeed_id,omitempty"`
+	// batch_id is unique in a stream and increases monotonically.
+	BatchId uint64 `protobuf:"varint,2,opt,name=batch_id,json=batchId,proto3" json:"batch_id,omitempty"`
+	// the events of a key are sent in the order of their commit ts.
+	Events []*Event `protobuf:"bytes,3,rep,name=events,proto3" json:"events,omitempty"`
+	// all the events whose commit ts are less than or equal to resolved_ts of the
+	// key span have been sent once the batch is acked.
+	ResolvedTs uint64 `protobuf:"varint,4,opt,name=resolved_ts,json=resolvedTs,proto3" json:"resolved_ts,omitempty"`
+	// all the events of the changefeed whose commit ts are less than or equal to
+	// checkpoint_ts have been acked.
+	CheckpointTs uint64 `protobuf:"varint,5,opt,name=checkpoint_ts,json=checkpointTs,proto3" json:"checkpoint_ts,omitempty"`
+}
+
+func (m *EventBatch) Reset()         { *m = EventBatch{} }
+func (m *EventBatch) String() string { return proto.CompactTextString(m) }
+func (*EventBatch) ProtoMessage()    {}
+
+type Ack struct {
+	BatchId uint64 `protobuf:"varint,1,opt,name=batch_id,json=batchId,proto3" json:"batch_id,omitempty"`
+	// error fails the batch, the changefeed retries it later.
+	Error string `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+func (m *Ack) Reset()         { *m = Ack{} }
+func (m *Ack) String() string { return proto.CompactTextString(m) }
+func (*Ack) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterEnum("sinkpb.OpType", OpType_name, OpType_value)
+	proto.RegisterType((*Event)(nil), "sinkpb.Event")
+	proto.RegisterType((*EventBatch)(nil), "sinkpb.EventBatch")
+	proto.RegisterType((*Ack)(nil), "sinkpb.Ack")
+}
+
+// ChangefeedSinkClient is the client API for ChangefeedSink service.
+type ChangefeedSinkClient interface {
+	// Push streams the batches of the changed events to the service, which replies
+	// an Ack for every batch once the batch is handled.
+	Push(ctx context.Context, opts ...grpc.CallOption) (ChangefeedSink_PushClient, error)
+}
+
+type changefeedSinkClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewChangefeedSinkClient(cc *grpc.ClientConn) ChangefeedSinkClient {
+	return &changefeedSinkClient{cc}
+}
+
+func (c *changefeedSinkClient) Push(ctx context.Context, opts ...grpc.CallOption) (ChangefeedSink_PushClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_ChangefeedSink_serviceDesc.Streams[0], "/sinkpb.ChangefeedSink/Push", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &changefeedSinkPushClient{stream}
+	return x, nil
+}
+
+type ChangefeedSink_PushClient interface {
+	Send(*EventBatch) error
+	Recv() (*Ack, error)
+	grpc.ClientStream
+}
+
+type changefeedSinkPushClient struct {
+	grpc.ClientStream
+}
+
+func (x *changefeedSinkPushClient) Send(m *EventBatch) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *changefeedSinkPushClient) Recv() (*Ack, error) {
+	m := new(Ack)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ChangefeedSinkServer is the server API for ChangefeedSink service, which is implemented
+// by the user service.
+type ChangefeedSinkServer interface {
+	// Push streams the batches of the changed events to the service, which replies
+	// an Ack for every batch once the batch is handled.
+	Push(ChangefeedSink_PushServer) error
+}
+
+// UnimplementedChangefeedSinkServer can be embedded to have forward compatible implementations.
+type UnimplementedChangefeedSinkServer struct {
+}
+
+func (*UnimplementedChangefeedSinkServer) Push(srv ChangefeedSink_PushServer) error {
+	return status.Errorf(codes.Unimplemented, "method Push not implemented")
+}
+
+func RegisterChangefeedSinkServer(s *grpc.Server, srv ChangefeedSinkServer) {
+	s.RegisterService(&_ChangefeedSink_serviceDesc, srv)
+}
+
+func _ChangefeedSink_Push_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ChangefeedSinkServer).Push(&changefeedSinkPushServer{stream})
+}
+
+type ChangefeedSink_PushServer interface {
+	Send(*Ack) error
+	Recv() (*EventBatch, error)
+	grpc.ServerStream
+}
+
+type changefeedSinkPushServer struct {
+	grpc.ServerStream
+}
+
+func (x *changefeedSinkPushServer) Send(m *Ack) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *changefeedSinkPushServer) Recv() (*EventBatch, error) {
+	m := new(EventBatch)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var _ChangefeedSink_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "sinkpb.ChangefeedSink",
+	HandlerType: (*ChangefeedSinkServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Push",
+			Handler:       _ChangefeedSink_Push_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "ChangefeedSink.proto",
+}